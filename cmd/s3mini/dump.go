@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+)
+
+// runExport — s3mini export [-vserver name] [-o file]: метаданные в JSONL
+// (по умолчанию в stdout). База должна быть на схеме этой сборки.
+func runExport(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	only := fs.String("vserver", "", "virtual server (required if there are several)")
+	out := fs.String("o", "-", "output file, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	vs, err := pickVServer(cfg, *only)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	database, closeDB, err := openForDump(vs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", vs.Name, err)
+		return 1
+	}
+	defer closeDB()
+	if n, err := database.PendingMigrations(); err != nil || n > 0 {
+		fmt.Fprintf(os.Stderr, "%s: schema is not up to date (pending %d, err %v), run `s3mini migrate up`\n", vs.Name, n, err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	counts, err := database.ExportMetadata(context.Background(), w)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: export: %v\n", vs.Name, err)
+		return 1
+	}
+	printCounts(os.Stderr, vs.Name, "exported", counts)
+	return 0
+}
+
+// runImport — s3mini import [-vserver name] [-allow-missing] [-i file]: дамп
+// в пустую базу. Схема создаётся миграциями, байты каждого блоба ищутся в
+// data_dir (и tier_data_dir) виртуального сервера.
+func runImport(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	only := fs.String("vserver", "", "virtual server (required if there are several)")
+	in := fs.String("i", "-", "input file, - for stdin")
+	allowMissing := fs.Bool("allow-missing", false, "import even if some blobs are missing in storage")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	vs, err := pickVServer(cfg, *only)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	database, closeDB, err := openForDump(vs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", vs.Name, err)
+		return 1
+	}
+	defer closeDB()
+	if _, err := database.Migrate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: migration: %v\n", vs.Name, err)
+		return 1
+	}
+
+	data, tier, err := storageDrivers(cfg, vs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", vs.Name, err)
+		return 1
+	}
+	st := storage.NewWithDriver(data)
+	if tier != nil {
+		st.AddNode(storage.NodeTier, tier)
+	}
+	ctx := context.Background()
+	checkBlob := func(b *db.Blob) error {
+		// у manifest-блоба байт нет, а pending — недописанная загрузка, её заберёт GC
+		if b.Kind == "manifest" || b.State == "pending" {
+			return nil
+		}
+		size, ok, err := st.StatNode(ctx, b.StorageNode, b.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("missing")
+		}
+		want := b.Size
+		if b.Encoding != "" {
+			want = b.StoredSize
+		}
+		if size != want {
+			return fmt.Errorf("size %d, want %d", size, want)
+		}
+		return nil
+	}
+	res, err := database.ImportMetadata(ctx, r, checkBlob, *allowMissing)
+	if res != nil {
+		for i, s := range res.BadBlobs {
+			if i == 20 {
+				fmt.Fprintf(os.Stderr, "... and %d more\n", len(res.BadBlobs)-i)
+				break
+			}
+			fmt.Fprintf(os.Stderr, "%s: blob %s\n", vs.Name, s)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, db.ErrBadBlobs) {
+			fmt.Fprintf(os.Stderr, "%s: nothing imported: %v (use -allow-missing to import anyway)\n", vs.Name, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: import: %v\n", vs.Name, err)
+		}
+		return 1
+	}
+	printCounts(os.Stdout, vs.Name, "imported", res.Rows)
+	return 0
+}
+
+func pickVServer(cfg config.Config, name string) (config.VServer, error) {
+	vss, err := cfg.VServers()
+	if err != nil {
+		return config.VServer{}, fmt.Errorf("VSERVERS_FILE: %w", err)
+	}
+	if name == "" {
+		if len(vss) > 1 {
+			return config.VServer{}, errors.New("several virtual servers configured, choose one with -vserver")
+		}
+		return vss[0], nil
+	}
+	for _, vs := range vss {
+		if vs.Name == name {
+			return vs, nil
+		}
+	}
+	return config.VServer{}, fmt.Errorf("vserver %q not found", name)
+}
+
+func openForDump(vs config.VServer) (*db.DB, func(), error) {
+	database, err := db.Open(vs.DBPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeDB := func() {}
+	if sqlDB, err := database.DB.DB(); err == nil {
+		closeDB = func() { _ = sqlDB.Close() }
+	}
+	return database, closeDB, nil
+}
+
+func printCounts(w io.Writer, vserver, verb string, counts map[string]int) {
+	tables := make([]string, 0, len(counts))
+	for t := range counts {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, t := range tables {
+		fmt.Fprintf(w, "%s: %s %-24s %d\n", vserver, verb, t, counts[t])
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+	"github.com/DanikLP1/s3-storage-service/internal/server"
+)
+
+// runFsck — s3mini fsck [-vserver name] [-repair] [-checksums] [-min-age d]:
+// сверка базы с файлами data_dir (и tier_data_dir). Код выхода 1 — остались
+// неисправленные проблемы. Можно запускать на работающем сервере: файлы
+// моложе -min-age считаются идущей записью и не трогаются.
+func runFsck(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	only := fs.String("vserver", "", "virtual server (required if there are several)")
+	repair := fs.Bool("repair", false, "delete orphaned and temporary files, mark missing and corrupt blobs")
+	checksums := fs.Bool("checksums", false, "re-read every blob and compare sha256 (slow)")
+	minAge := fs.Duration("min-age", time.Hour, "ignore files younger than this (writes in progress)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	vs, err := pickVServer(cfg, *only)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	database, closeDB, err := openForDump(vs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", vs.Name, err)
+		return 1
+	}
+	defer closeDB()
+	if n, err := database.PendingMigrations(); err != nil || n > 0 {
+		fmt.Fprintf(os.Stderr, "%s: schema is not up to date (pending %d, err %v), run `s3mini migrate up`\n", vs.Name, n, err)
+		return 1
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	cfg.Addr, cfg.DataDir = vs.Addr, vs.DataDir
+	data, tier, err := storageDrivers(cfg, vs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", vs.Name, err)
+		return 1
+	}
+	srv := server.New(database, data, logger, cfg)
+	if tier != nil {
+		srv.AddStorageTier(tier)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	opt := server.FsckOptions{Repair: *repair, Checksums: *checksums, MinAge: *minAge}
+	st, err := srv.Fsck(ctx, opt, func(is server.FsckIssue) {
+		line := fmt.Sprintf("%s: %-8s %-5s %s: %s", vs.Name, is.Kind, is.Node, is.BlobID, is.Detail)
+		if is.Action != "" {
+			line += " [" + is.Action + "]"
+		}
+		fmt.Println(line)
+	})
+	for _, node := range st.SkippedNodes {
+		fmt.Fprintf(os.Stderr, "%s: node %s: driver cannot list files, orphans not checked\n", vs.Name, node)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d files, %d blobs checked, %d issues, %d repaired\n",
+		vs.Name, st.Files, st.Blobs, st.Issues, st.Repaired)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: fsck: %v\n", vs.Name, err)
+		return 1
+	}
+	if st.Issues > st.Repaired {
+		return 1
+	}
+	return 0
+}
@@ -2,25 +2,39 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+	"github.com/DanikLP1/s3-storage-service/internal/graceful"
 	"github.com/DanikLP1/s3-storage-service/internal/logging"
-	"github.com/DanikLP1/s3-storage-service/internal/server"
-	"github.com/DanikLP1/s3-storage-service/internal/storage/fsdriver"
+	"github.com/DanikLP1/s3-storage-service/internal/secrets"
 )
 
 func main() {
-	database, err := db.OpenSQLite("meta.db")
-	if err != nil {
-		log.Fatal("DB error:", err)
-	}
-
-	if err := database.AutoMigrate(); err != nil {
-		log.Fatalf("Migration error: %v", err)
+	cfg := config.New()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(cfg, os.Args[2:]))
+		case "export":
+			os.Exit(runExport(cfg, os.Args[2:]))
+		case "import":
+			os.Exit(runImport(cfg, os.Args[2:]))
+		case "fsck":
+			os.Exit(runFsck(cfg, os.Args[2:]))
+		case "rebalance":
+			os.Exit(runRebalance(cfg, os.Args[2:]))
+		}
 	}
 
 	logger := logging.New(logging.Config{
@@ -28,22 +42,158 @@ func main() {
 		JSON:  true,
 	})
 
-	drv := fsdriver.New("data")
+	box, err := openSecretBox(cfg)
+	if err != nil {
+		log.Fatalf("MASTER_KEY: %v", err)
+	}
+	if box == nil {
+		logger.Warn("secrets.master_key_missing", "hint", "set MASTER_KEY or MASTER_KEY_COMMAND to encrypt secret access keys at rest")
+	}
 
-	srv := server.New(database, drv, logger)
-	addr := ":8080"
-	mux := srv.Router()
-	handler := srv.WithRecover(srv.WithRequestLogger(srv.AuthMiddleware(mux)))
+	vss, err := cfg.VServers()
+	if err != nil {
+		log.Fatalf("VSERVERS_FILE: %v", err)
+	}
+	tlsSet, err := newTLSSetup(cfg, logger)
+	if err != nil {
+		log.Fatalf("TLS: %v", err)
+	}
+	if _, err := cfg.CertIdentities(); err != nil {
+		log.Fatal(err)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	srv.StartGC(ctx, 15*time.Minute, 256)
+	var (
+		servers   []*vserver
+		lns       = map[string]net.Listener{}
+		inherited bool
+	)
+	for _, vs := range vss {
+		tlsCfg, err := tlsSet.configFor(vs)
+		if err != nil {
+			log.Fatalf("vserver %s: TLS: %v", vs.Name, err)
+		}
+		v, inh, err := startVServer(ctx, cfg, box, tlsCfg, logger.With(slog.String("vserver", vs.Name)), vs)
+		if err != nil {
+			log.Fatalf("vserver %s: %v", vs.Name, err)
+		}
+		servers = append(servers, v)
+		lns[v.addr] = v.ln
+		inherited = inherited || inh
+		scheme := "http"
+		if v.tls {
+			scheme = "https"
+		}
+		fmt.Printf("Listening on %s://localhost%s (%s)\n", scheme, v.addr, v.name)
+	}
+
+	// HTTP-01: ответы на проверки ACME, остальное — редирект на https
+	var challengeSrv *http.Server
+	if tlsSet.acme != nil {
+		ln, inh, err := graceful.Listen(cfg.ACMEHTTPAddr)
+		if err != nil {
+			log.Fatalf("ACME_HTTP_ADDR: %v", err)
+		}
+		lns[cfg.ACMEHTTPAddr] = ln
+		inherited = inherited || inh
+		challengeSrv = &http.Server{Handler: tlsSet.acme.HTTPHandler(nil), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := challengeSrv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+		logger.Info("tls.acme_enabled", "domains", cfg.ACMEDomains, "http_addr", cfg.ACMEHTTPAddr)
+	}
+	if inherited {
+		logger.Info("graceful.listener_inherited")
+		if err := graceful.NotifyParentReady(); err != nil {
+			logger.Warn("graceful.notify_parent_fail", "err", err)
+		}
+	}
 
-	go srv.StartLifecycle(ctx, 15*time.Minute, 50)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+wait:
+	for sig := range sigs {
+		switch sig {
+		case syscall.SIGUSR2:
+			p, err := graceful.Upgrade(lns)
+			if err != nil {
+				logger.Error("graceful.upgrade_fail", "err", err)
+				continue
+			}
+			// ждём SIGTERM от нового процесса, когда он будет готов
+			logger.Info("graceful.upgrade_started", "child_pid", p.Pid)
+		default:
+			break wait
+		}
+	}
 
-	fmt.Println("Listening on http://localhost" + addr)
-	if err := http.ListenAndServe(addr, server.WrapWriteCheck(handler)); err != nil {
-		log.Fatal(err)
+	// drain: новые соединения не принимаем, текущие запросы (в т.ч. загрузки) дожидаемся;
+	// контекст воркеров отменяется только после него — запросы ещё могут ставить им работу
+	logger.Info("shutdown.begin", "timeout_s", cfg.ShutdownTimeoutS)
+	shutdownCtx, stop := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeoutS)*time.Second)
+	defer stop()
+	var wg sync.WaitGroup
+	if challengeSrv != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = challengeSrv.Shutdown(shutdownCtx)
+		}()
+	}
+	for _, v := range servers {
+		wg.Add(1)
+		go func(v *vserver) {
+			defer wg.Done()
+			v.shutdown(shutdownCtx)
+		}(v)
+	}
+	wg.Wait()
+	cancel()
+	for _, v := range servers {
+		v.releaseLeases()
+	}
+	logger.Info("shutdown.done")
+}
+
+// openSecretBox — мастер-ключ из MASTER_KEY или из KMS-хука MASTER_KEY_COMMAND;
+// nil, если не задан ни тот, ни другой.
+func openSecretBox(cfg config.Config) (*secrets.Box, error) {
+	var (
+		key []byte
+		err error
+	)
+	switch {
+	case cfg.MasterKeyCommand != "":
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		key, err = secrets.KeyFromCommand(ctx, cfg.MasterKeyCommand)
+	case cfg.MasterKey != "":
+		key, err = secrets.ParseKey(cfg.MasterKey)
+	default:
+		if cfg.MasterKeyPrevious != "" {
+			return nil, errors.New("MASTER_KEY_PREVIOUS is set without a current master key")
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	box, err := secrets.NewBox(key)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MasterKeyPrevious != "" {
+		prev, err := secrets.ParseKey(cfg.MasterKeyPrevious)
+		if err != nil {
+			return nil, fmt.Errorf("MASTER_KEY_PREVIOUS: %w", err)
+		}
+		if err := box.AddRetiredKey(prev); err != nil {
+			return nil, fmt.Errorf("MASTER_KEY_PREVIOUS: %w", err)
+		}
 	}
+	return box, nil
 }
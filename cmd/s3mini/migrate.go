@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// migrateOnStart — схема БД перед запуском сервера. С DB_AUTO_MIGRATE=0
+// миграции не применяются, а неприменённые не дают стартовать: в проде схему
+// обновляют отдельным шагом (s3mini migrate up) до выкладки новой версии.
+func migrateOnStart(cfg config.Config, database *db.DB, logger *slog.Logger) error {
+	if !cfg.DBAutoMigrate {
+		n, err := database.PendingMigrations()
+		if err != nil {
+			return fmt.Errorf("migration: %w", err)
+		}
+		if n > 0 {
+			return fmt.Errorf("migration: %d pending, run `s3mini migrate up`", n)
+		}
+		return nil
+	}
+	done, err := database.Migrate()
+	if len(done) > 0 {
+		logger.Info("db.migrated", "versions", done)
+	}
+	if err != nil {
+		return fmt.Errorf("migration: %w", err)
+	}
+	return nil
+}
+
+// runMigrate — s3mini migrate [-vserver name] status|up|down N.
+func runMigrate(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	only := fs.String("vserver", "", "only this virtual server (default: all)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: s3mini migrate [-vserver name] status|up|down N")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cmd, target := fs.Arg(0), 0
+	switch cmd {
+	case "status", "up":
+	case "down":
+		v, err := strconv.Atoi(fs.Arg(1))
+		if err != nil || v < 0 {
+			fs.Usage()
+			return 2
+		}
+		target = v
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	vss, err := cfg.VServers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "VSERVERS_FILE:", err)
+		return 1
+	}
+	found := false
+	for _, vs := range vss {
+		if *only != "" && vs.Name != *only {
+			continue
+		}
+		found = true
+		if err := migrateVServer(vs, cmd, target); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", vs.Name, err)
+			return 1
+		}
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "vserver %q not found\n", *only)
+		return 1
+	}
+	return 0
+}
+
+func migrateVServer(vs config.VServer, cmd string, target int) error {
+	database, err := db.Open(vs.DBPath)
+	if err != nil {
+		return err
+	}
+	if sqlDB, err := database.DB.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	switch cmd {
+	case "up":
+		done, err := database.Migrate()
+		fmt.Printf("%s: applied %v\n", vs.Name, done)
+		return err
+	case "down":
+		done, err := database.MigrateDown(target)
+		fmt.Printf("%s: rolled back %v\n", vs.Name, done)
+		return err
+	}
+	st, err := database.MigrationsStatus()
+	for _, m := range st {
+		applied := "pending"
+		if m.AppliedAt != nil {
+			applied = m.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%s: %4d %-28s %s\n", vs.Name, m.Version, m.Name, applied)
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+	"github.com/DanikLP1/s3-storage-service/internal/storage/jbod"
+)
+
+// runRebalance — s3mini rebalance [-vserver name] [-dry-run]: перенос блобов
+// на диски, которые им выбирает хэш, после добавления диска в data_dirs или
+// починки сломанного. База не нужна и не меняется; можно запускать на
+// работающем сервере. Код выхода 1 — часть переносов не удалась.
+func runRebalance(cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("rebalance", flag.ContinueOnError)
+	only := fs.String("vserver", "", "virtual server (required if there are several)")
+	dryRun := fs.Bool("dry-run", false, "only list blobs that are not on their disk")
+	verbose := fs.Bool("v", false, "print every move")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	vs, err := pickVServer(cfg, *only)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data, err := dataDriver(cfg, vs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", vs.Name, err)
+		return 1
+	}
+	j, ok := data.(*jbod.JBOD)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: data_dirs is not configured, nothing to rebalance\n", vs.Name)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// сломанные и заполненные диски новых блобов не получают — как у сервера
+	for _, d := range j.CheckDisks(ctx) {
+		if d.State != storage.DiskOK {
+			fmt.Fprintf(os.Stderr, "%s: disk %s is %s %s, skipped as a target\n", vs.Name, d.Path, d.State, d.Error)
+		}
+	}
+	st, err := j.Rebalance(ctx, *dryRun, func(m jbod.Move, err error) {
+		action := "move"
+		if m.Duplicate {
+			action = "dedup"
+		}
+		switch {
+		case err != nil:
+			fmt.Printf("%s: %s %s %s -> %s: %v\n", vs.Name, action, m.ID, m.From, m.To, err)
+		case *dryRun || *verbose:
+			fmt.Printf("%s: %s %s %s -> %s (%d bytes)\n", vs.Name, action, m.ID, m.From, m.To, m.Size)
+		}
+	})
+	verb := ""
+	if *dryRun {
+		verb = "to be "
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d blobs checked, %d %smoved (%d bytes), %d extra copies %sremoved, %d failed\n",
+		vs.Name, st.Files, st.Moved, verb, st.Bytes, st.Removed, verb, st.Failed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: rebalance: %v\n", vs.Name, err)
+		return 1
+	}
+	if st.Failed > 0 {
+		return 1
+	}
+	return 0
+}
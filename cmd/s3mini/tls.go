@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+)
+
+// certCheckInterval — как часто смотреть на mtime файлов сертификата:
+// certbot и cert-manager подменяют их без перезапуска сервера.
+const certCheckInterval = time.Minute
+
+// tlsSetup — TLS на весь процесс: сертификат из файлов (TLS_CERT_FILE) или
+// ACME-менеджер (ACME_DOMAINS). Виртуальный сервер может задать свой сертификат.
+type tlsSetup struct {
+	cert   *certFile
+	acme   *autocert.Manager
+	logger *slog.Logger
+
+	// mTLS: CA клиентских сертификатов (TLS_CLIENT_CA_FILE)
+	clientCAs     *x509.CertPool
+	requireClient bool
+}
+
+func newTLSSetup(cfg config.Config, logger *slog.Logger) (*tlsSetup, error) {
+	t := &tlsSetup{logger: logger, requireClient: cfg.MTLSRequired}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSCertFile != "" && cfg.ACMEDomains != "" {
+		return nil, errors.New("TLS_CERT_FILE and ACME_DOMAINS are mutually exclusive")
+	}
+	if cfg.TLSCertFile != "" {
+		c, err := loadCertFile(cfg.TLSCertFile, cfg.TLSKeyFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		t.cert = c
+	}
+	if cfg.ACMEDomains != "" {
+		var domains []string
+		for _, d := range strings.Split(cfg.ACMEDomains, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				domains = append(domains, d)
+			}
+		}
+		if len(domains) == 0 {
+			return nil, errors.New("ACME_DOMAINS: no domains")
+		}
+		t.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Email:      cfg.ACMEEmail,
+		}
+		if cfg.ACMEDirectoryURL != "" {
+			t.acme.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+		}
+	}
+	if cfg.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		t.clientCAs = x509.NewCertPool()
+		if !t.clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates", cfg.TLSClientCAFile)
+		}
+	} else if cfg.MTLSRequired {
+		return nil, errors.New("MTLS_REQUIRED needs TLS_CLIENT_CA_FILE")
+	}
+	return t, nil
+}
+
+// configFor — TLS-конфигурация виртуального сервера; nil — обычный HTTP.
+func (t *tlsSetup) configFor(vs config.VServer) (*tls.Config, error) {
+	var tc *tls.Config
+	switch {
+	case vs.TLSCertFile != "":
+		c, err := loadCertFile(vs.TLSCertFile, vs.TLSKeyFile, t.logger)
+		if err != nil {
+			return nil, err
+		}
+		tc = &tls.Config{GetCertificate: c.get}
+	case t.cert != nil:
+		tc = &tls.Config{GetCertificate: t.cert.get}
+	case t.acme != nil:
+		// включает acme-tls/1 в NextProtos: проверка TLS-ALPN-01 тоже пройдёт
+		tc = t.acme.TLSConfig()
+	default:
+		if t.clientCAs != nil {
+			return nil, errors.New("TLS_CLIENT_CA_FILE needs a server certificate (TLS_CERT_FILE or ACME_DOMAINS)")
+		}
+		return nil, nil
+	}
+	tc.MinVersion = tls.VersionTLS12
+	if t.clientCAs != nil {
+		// сертификат необязателен: без него клиент подписывает запросы SigV4
+		tc.ClientCAs, tc.ClientAuth = t.clientCAs, tls.VerifyClientCertIfGiven
+		if t.requireClient {
+			tc.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tc, nil
+}
+
+// certFile — пара cert/key с диска, перечитываемая при изменении файлов.
+type certFile struct {
+	certPath, keyPath string
+	logger            *slog.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func loadCertFile(certPath, keyPath string, logger *slog.Logger) (*certFile, error) {
+	c := &certFile{certPath: certPath, keyPath: keyPath, logger: logger}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certFile) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= certCheckInterval {
+		// битый файл посреди подмены — не повод рвать рукопожатия, служим старым
+		if changed, err := c.reload(); err != nil {
+			c.logger.Warn("tls.cert_reload_fail", "cert", c.certPath, "err", err)
+		} else if changed {
+			c.logger.Info("tls.cert_reloaded", "cert", c.certPath)
+		}
+	}
+	return c.cert, nil
+}
+
+// reload перечитывает файлы, если их mtime сдвинулся. Вызывать под mu
+// (или до того, как certFile стал доступен другим горутинам).
+func (c *certFile) reload() (bool, error) {
+	c.checked = time.Now()
+	var mod time.Time
+	for _, p := range []string{c.certPath, c.keyPath} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return false, err
+		}
+		if fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	if c.cert != nil && mod.Equal(c.modTime) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", c.certPath, err)
+	}
+	c.cert, c.modTime = &cert, mod
+	return true, nil
+}
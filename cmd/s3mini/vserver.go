@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/graceful"
+	"github.com/DanikLP1/s3-storage-service/internal/secrets"
+	"github.com/DanikLP1/s3-storage-service/internal/server"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+	"github.com/DanikLP1/s3-storage-service/internal/storage/fsdriver"
+	"github.com/DanikLP1/s3-storage-service/internal/storage/jbod"
+	"github.com/DanikLP1/s3-storage-service/internal/storage/s3driver"
+)
+
+// blobDriver — хранилище по адресу dir: каталог или бакет s3://bucket/prefix
+// во внешнем S3 (REMOTE_S3_*).
+func blobDriver(cfg config.Config, dir string) (storage.StorageDriver, error) {
+	if !s3driver.IsURL(dir) {
+		return fsdriver.New(dir), nil
+	}
+	bucket, prefix, err := s3driver.ParseURL(dir)
+	if err != nil {
+		return nil, err
+	}
+	return s3driver.New(s3driver.Config{
+		Endpoint:  cfg.RemoteS3Endpoint,
+		Region:    cfg.RemoteS3Region,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		AccessKey: cfg.RemoteS3AccessKey,
+		SecretKey: cfg.RemoteS3SecretKey,
+		PathStyle: cfg.RemoteS3PathStyle,
+		SpoolDir:  cfg.RemoteS3SpoolDir,
+	})
+}
+
+// dataDriver — основное хранилище арендатора: data_dir (каталог или бакет)
+// или, с data_dirs, JBOD из каталога data_dir и этих каталогов.
+func dataDriver(cfg config.Config, vs config.VServer) (storage.StorageDriver, error) {
+	if len(vs.DataDirs) == 0 {
+		return blobDriver(cfg, vs.DataDir)
+	}
+	dirs := append([]string{vs.DataDir}, vs.DataDirs...)
+	for _, dir := range dirs {
+		if s3driver.IsURL(dir) {
+			return nil, fmt.Errorf("%s: data_dirs are local disks, %s can't be one of them", vs.Name, dir)
+		}
+	}
+	return jbod.New(int64(cfg.DiskMinFreeMB)<<20, dirs...), nil
+}
+
+// storageDrivers — основное хранилище арендатора и второй уровень
+// (tier_data_dir; nil — не настроен).
+func storageDrivers(cfg config.Config, vs config.VServer) (data, tier storage.StorageDriver, err error) {
+	if data, err = dataDriver(cfg, vs); err != nil {
+		return nil, nil, err
+	}
+	if vs.TierDataDir != "" {
+		if tier, err = blobDriver(cfg, vs.TierDataDir); err != nil {
+			return nil, nil, err
+		}
+	}
+	return data, tier, nil
+}
+
+// vserver — один арендатор: своя БД, свой каталог данных, свой сокет и воркеры.
+type vserver struct {
+	name    string
+	addr    string
+	srv     *server.Server
+	httpSrv *http.Server
+	ln      net.Listener
+	tls     bool
+	logger  *slog.Logger
+}
+
+func startVServer(ctx context.Context, cfg config.Config, box *secrets.Box, tlsCfg *tls.Config, logger *slog.Logger, vs config.VServer) (*vserver, bool, error) {
+	database, err := db.Open(vs.DBPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("db: %w", err)
+	}
+	if err := migrateOnStart(cfg, database, logger); err != nil {
+		return nil, false, err
+	}
+
+	if box != nil {
+		database.SetSecretBox(box)
+		n, err := database.SealPlaintextSecrets()
+		if err != nil {
+			return nil, false, fmt.Errorf("sealing secrets: %w", err)
+		}
+		if n > 0 {
+			logger.Info("secrets.sealed_plaintext", "keys", n)
+		}
+	}
+
+	if vs.AdminAccessKey != "" && vs.AdminSecretKey != "" {
+		if _, err := database.EnsureUser(vs.AdminAccessKey, vs.AdminSecretKey); err != nil {
+			return nil, false, fmt.Errorf("admin bootstrap: %w", err)
+		}
+		if err := database.SetUserRole(vs.AdminAccessKey, db.RoleAdmin); err != nil {
+			return nil, false, fmt.Errorf("admin bootstrap: %w", err)
+		}
+	}
+
+	cfg.Addr, cfg.DataDir = vs.Addr, vs.DataDir
+	data, tier, err := storageDrivers(cfg, vs)
+	if err != nil {
+		return nil, false, err
+	}
+	srv := server.New(database, data, logger, cfg)
+	if tier != nil {
+		srv.AddStorageTier(tier)
+	}
+	if cfg.ReadOnly {
+		logger.Warn("server.read_only", "reason", "READ_ONLY=1")
+	}
+	handler := srv.WithRecover(srv.WithRequestLogger(srv.WithCORS(srv.WithClientCert(srv.AuthMiddleware(srv.Router())))))
+
+	srv.StartGC(ctx, 15*time.Minute, 256)
+
+	srv.StartRecovery(ctx, time.Hour, 256)
+
+	srv.StartDBMaintenance(ctx)
+
+	srv.StartDiskMonitor(ctx)
+
+	go srv.StartLifecycle(ctx, 15*time.Minute, 50)
+
+	srv.StartDedupStats(ctx, time.Hour)
+
+	srv.StartUsageMetrics(ctx, time.Minute)
+
+	srv.StartChangeFeedPrune(ctx, time.Hour)
+
+	srv.StartBatchJobs(ctx, 5*time.Second)
+
+	srv.StartAccessLog(ctx, time.Duration(cfg.AccessLogFlushS)*time.Second)
+
+	srv.StartNotifications(ctx, time.Second)
+
+	// сокет может прийти от предыдущего процесса (перезапуск без простоя, SIGUSR2)
+	ln, inherited, err := graceful.Listen(vs.Addr)
+	if err != nil {
+		return nil, false, err
+	}
+	// TLS поверх того же сокета: при перезапуске без простоя передаётся голый TCP
+	httpSrv := &http.Server{Handler: server.WrapWriteCheck(handler), TLSConfig: tlsCfg}
+	go func() {
+		var err error
+		if tlsCfg != nil {
+			err = httpSrv.ServeTLS(ln, "", "")
+		} else {
+			err = httpSrv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	return &vserver{name: vs.Name, addr: vs.Addr, srv: srv, httpSrv: httpSrv, ln: ln, tls: tlsCfg != nil, logger: logger}, inherited, nil
+}
+
+// shutdown — drain текущих запросов: новые соединения не принимаются, начатые
+// загрузки дописываются.
+func (v *vserver) shutdown(ctx context.Context) {
+	if err := v.httpSrv.Shutdown(ctx); err != nil {
+		v.logger.Error("shutdown.drain_fail", "err", err)
+	}
+}
+
+// releaseLeases — освобождение lease'ов воркеров после их остановки, чтобы
+// другой экземпляр подхватил работу сразу, не дожидаясь истечения.
+func (v *vserver) releaseLeases() {
+	if err := v.srv.ReleaseLeases(); err != nil {
+		v.logger.Warn("shutdown.release_leases_fail", "err", err)
+	}
+}
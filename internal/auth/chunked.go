@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Варианты x-amz-content-sha256 для тела в формате aws-chunked.
+const (
+	StreamingSigned          = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingSignedTrailer   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+var (
+	ErrMalformedChunk      = errors.New("malformed aws-chunked payload")
+	ErrUnsupportedChunking = errors.New("unsupported streaming payload")
+	// трейлерная контрольная сумма отсутствует / не разбирается или не совпала с телом
+	ErrBadTrailerChecksum = errors.New("invalid trailing checksum")
+	ErrChecksumMismatch   = errors.New("trailing checksum does not match payload")
+)
+
+// ChecksumTrailer — объявленная в x-amz-trailer контрольная сумма (x-amz-checksum-crc32
+// и т.п.): декодер считает Hash по чистым байтам и сверяет с base64-значением трейлера.
+type ChecksumTrailer struct {
+	Name string
+	Hash hash.Hash
+}
+
+const (
+	maxChunkLine    = 4 << 10 // строка заголовка куска / трейлера
+	maxTrailerBytes = 16 << 10
+)
+
+var emptySHA256 = hexSha256OfBytes(nil)
+
+// IsStreamingPayload — тело передано в aws-chunked (x-amz-content-sha256: STREAMING-*).
+func IsStreamingPayload(payloadHash string) bool {
+	return strings.HasPrefix(payloadHash, "STREAMING-")
+}
+
+// NewChunkedReader снимает с тела aws-chunked обрамление и отдаёт чистые байты.
+// Для подписанных вариантов подпись каждого куска (и трейлера) проверяется по
+// цепочке от подписи заголовка Authorization — res нужен из VerifySigV4; для
+// STREAMING-UNSIGNED-PAYLOAD-TRAILER res может быть nil. Байты куска отдаются
+// по мере чтения, а несовпадение подписи всплывает ошибкой Read в конце куска —
+// вызывающий обязан выбросить всё прочитанное. ck (может быть nil) — контрольная
+// сумма из трейлера; несовпадение так же всплывает ошибкой последнего Read.
+func NewChunkedReader(body io.ReadCloser, payloadHash string, res *Result, ck *ChecksumTrailer) (io.ReadCloser, error) {
+	c := &chunkedReader{body: body, br: bufio.NewReader(body)}
+	switch payloadHash {
+	case StreamingSigned, StreamingSignedTrailer:
+		if res == nil || res.signingKey == nil {
+			return nil, ErrUnsupportedChunking
+		}
+		c.signed = true
+		c.trailer = payloadHash == StreamingSignedTrailer
+		c.key, c.prevSig = res.signingKey, res.signature
+		c.amzDate, c.scope = res.amzDate, res.scope
+		c.hash = sha256.New()
+	case StreamingUnsignedTrailer:
+		c.trailer = true
+	default:
+		return nil, ErrUnsupportedChunking
+	}
+	if c.trailer && ck != nil {
+		c.checksum = ck
+		c.checksum.Name = strings.ToLower(ck.Name)
+	}
+	return c, nil
+}
+
+type chunkedReader struct {
+	body io.Closer
+	br   *bufio.Reader
+
+	signed, trailer bool
+	key             []byte
+	prevSig         string
+	amzDate, scope  string
+
+	left    int64     // байт текущего куска ещё не отдано
+	wantSig string    // подпись текущего куска
+	hash    hash.Hash // sha256 текущего куска
+	done    bool
+	err     error
+
+	trailers map[string]string
+	checksum *ChecksumTrailer
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	for c.left == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.br.Read(p)
+	c.left -= int64(n)
+	if c.signed {
+		c.hash.Write(p[:n])
+	}
+	if c.checksum != nil {
+		c.checksum.Hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && c.left == 0 {
+		err = c.endChunk()
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *chunkedReader) Close() error { return c.body.Close() }
+
+// Trailer — значение трейлера name (x-amz-checksum-crc32 и т.п.); известно только
+// после того, как тело дочитано до конца.
+func (c *chunkedReader) Trailer(name string) string {
+	return c.trailers[strings.ToLower(name)]
+}
+
+// nextChunk читает заголовок куска "<hex-size>[;chunk-signature=<sig>]\r\n".
+// Нулевой кусок — конец тела (и трейлеры, если они объявлены).
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeStr, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("%w: bad chunk size %q", ErrMalformedChunk, sizeStr)
+	}
+	if c.signed {
+		sig, ok := strings.CutPrefix(strings.TrimSpace(ext), "chunk-signature=")
+		if !ok || sig == "" {
+			return fmt.Errorf("%w: missing chunk-signature", ErrMalformedChunk)
+		}
+		c.wantSig = strings.ToLower(sig)
+		c.hash.Reset()
+	}
+	if size > 0 {
+		c.left = size
+		return nil
+	}
+
+	c.done = true
+	if c.signed {
+		if err := c.checkChunkSig(); err != nil {
+			return err
+		}
+	}
+	if c.trailer {
+		return c.readTrailer()
+	}
+	// пустая строка после нулевого куска
+	if line, err := c.readLine(); err != nil || line != "" {
+		return fmt.Errorf("%w: bad final chunk", ErrMalformedChunk)
+	}
+	return nil
+}
+
+// endChunk — CRLF после данных куска и проверка его подписи.
+func (c *chunkedReader) endChunk() error {
+	if line, err := c.readLine(); err != nil || line != "" {
+		return fmt.Errorf("%w: missing CRLF after chunk data", ErrMalformedChunk)
+	}
+	if c.signed {
+		return c.checkChunkSig()
+	}
+	return nil
+}
+
+func (c *chunkedReader) checkChunkSig() error {
+	sts := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		c.amzDate,
+		c.scope,
+		c.prevSig,
+		emptySHA256,
+		hex.EncodeToString(c.hash.Sum(nil)),
+	}, "\n")
+	return c.checkSig(sts, c.wantSig)
+}
+
+func (c *chunkedReader) checkSig(stringToSign, got string) error {
+	want := hmacSHA256Hex(c.key, []byte(stringToSign))
+	if subtle.ConstantTimeCompare([]byte(want), []byte(got)) != 1 {
+		return ErrSignatureMismatch
+	}
+	c.prevSig = want
+	return nil
+}
+
+// readTrailer — строки "имя:значение" до пустой строки. В подписанном варианте
+// последней идёт x-amz-trailer-signature над остальными трейлерами.
+func (c *chunkedReader) readTrailer() error {
+	var canon bytes.Buffer
+	var trailerSig string
+	total := 0
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+		if total += len(line); total > maxTrailerBytes {
+			return fmt.Errorf("%w: trailer too large", ErrMalformedChunk)
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("%w: bad trailer line", ErrMalformedChunk)
+		}
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if name == "x-amz-trailer-signature" {
+			trailerSig = strings.ToLower(value)
+			continue
+		}
+		fmt.Fprintf(&canon, "%s:%s\n", name, value)
+		if c.trailers == nil {
+			c.trailers = map[string]string{}
+		}
+		c.trailers[name] = value
+	}
+	if c.signed {
+		if trailerSig == "" {
+			return fmt.Errorf("%w: missing x-amz-trailer-signature", ErrMalformedChunk)
+		}
+		sts := strings.Join([]string{
+			"AWS4-HMAC-SHA256-TRAILER",
+			c.amzDate,
+			c.scope,
+			c.prevSig,
+			hexSha256OfBytes(canon.Bytes()),
+		}, "\n")
+		if err := c.checkSig(sts, trailerSig); err != nil {
+			return err
+		}
+	}
+	return c.checkChecksum()
+}
+
+// checkChecksum сверяет объявленную в x-amz-trailer сумму с посчитанной по телу.
+func (c *chunkedReader) checkChecksum() error {
+	if c.checksum == nil {
+		return nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(c.trailers[c.checksum.Name])
+	if err != nil || len(raw) != c.checksum.Hash.Size() {
+		return fmt.Errorf("%w: %s", ErrBadTrailerChecksum, c.checksum.Name)
+	}
+	if !bytes.Equal(raw, c.checksum.Hash.Sum(nil)) {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, c.checksum.Name)
+	}
+	return nil
+}
+
+// readLine читает строку до CRLF (без него); длинные строки — ошибка.
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxChunkLine {
+		return "", fmt.Errorf("%w: line too long", ErrMalformedChunk)
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	s := strings.TrimSuffix(string(line), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrPolicyExpired = errors.New("policy expired")
+
+// VerifyPostPolicy — подпись браузерной загрузки (POST формой): SigV4-ключ
+// подписывает base64-строку поля policy. Ключи fields — в нижнем регистре.
+func VerifyPostPolicy(fields map[string]string, cred CredentialsProvider, opts VerifyOptions) (*Result, error) {
+	if fields["x-amz-algorithm"] != "AWS4-HMAC-SHA256" {
+		return nil, ErrUnsuportedAlgorithm
+	}
+	credParts := strings.Split(fields["x-amz-credential"], "/")
+	if len(credParts) != 5 {
+		return nil, ErrBadCredentialScope
+	}
+	accessKeyID, scopeDate, region, service := credParts[0], credParts[1], credParts[2], credParts[3]
+	if service != opts.ExpectedService || credParts[4] != "aws4_request" {
+		return nil, ErrBadCredentialScope
+	}
+	t, err := time.Parse("20060102T150405Z", fields["x-amz-date"])
+	if err != nil {
+		return nil, fmt.Errorf("bad x-amz-date")
+	}
+	signature := fields["x-amz-signature"]
+	if fields["policy"] == "" || signature == "" {
+		return nil, fmt.Errorf("policy and x-amz-signature are required")
+	}
+
+	secret, err := cred.LookupSecret(accessKeyID)
+	if err != nil {
+		return nil, err
+	}
+	expectedSig := hmacSHA256Hex(signingKey(secret, scopeDate, region, service), []byte(fields["policy"]))
+	if subtle.ConstantTimeCompare([]byte(expectedSig), []byte(strings.ToLower(signature))) != 1 {
+		return nil, ErrSignatureMismatch
+	}
+	return &Result{AccessKeyID: accessKeyID, AmzDate: t.UTC(), Region: region, ScopeDate: scopeDate}, nil
+}
+
+// PostPolicy — документ политики POST-загрузки: срок действия и условия на поля формы.
+type PostPolicy struct {
+	Expiration time.Time
+	conds      []postCond
+	// content-length-range
+	HasLengthRange       bool
+	MinLength, MaxLength int64
+}
+
+type postCond struct {
+	op    string // eq | starts-with
+	field string // в нижнем регистре, без '$'
+	value string
+}
+
+// ParsePostPolicy разбирает base64 JSON из поля policy.
+func ParsePostPolicy(b64 string) (*PostPolicy, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("policy is not valid base64")
+	}
+	var doc struct {
+		Expiration string            `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("policy is not valid JSON")
+	}
+	p := &PostPolicy{}
+	if p.Expiration, err = time.Parse(time.RFC3339, doc.Expiration); err != nil {
+		return nil, fmt.Errorf("policy expiration must be an ISO 8601 date")
+	}
+	for _, c := range doc.Conditions {
+		// {"field": "value"} — точное совпадение
+		var obj map[string]string
+		if json.Unmarshal(c, &obj) == nil {
+			for k, v := range obj {
+				p.conds = append(p.conds, postCond{op: "eq", field: strings.ToLower(k), value: v})
+			}
+			continue
+		}
+		var arr []any
+		if err := json.Unmarshal(c, &arr); err != nil || len(arr) != 3 {
+			return nil, fmt.Errorf("invalid policy condition %s", c)
+		}
+		op, _ := arr[0].(string)
+		switch strings.ToLower(op) {
+		case "eq", "starts-with":
+			field, ok1 := arr[1].(string)
+			value, ok2 := arr[2].(string)
+			if !ok1 || !ok2 || !strings.HasPrefix(field, "$") {
+				return nil, fmt.Errorf("invalid policy condition %s", c)
+			}
+			p.conds = append(p.conds, postCond{op: strings.ToLower(op), field: strings.ToLower(field[1:]), value: value})
+		case "content-length-range":
+			lo, ok1 := arr[1].(float64)
+			hi, ok2 := arr[2].(float64)
+			if !ok1 || !ok2 || lo < 0 || hi < lo {
+				return nil, fmt.Errorf("invalid content-length-range")
+			}
+			p.HasLengthRange, p.MinLength, p.MaxLength = true, int64(lo), int64(hi)
+		default:
+			return nil, fmt.Errorf("unsupported policy condition %q", op)
+		}
+	}
+	return p, nil
+}
+
+// Check — срок действия, все условия выполнены и каждое поле формы покрыто
+// условием (кроме самой подписи, policy, file и x-ignore-*). bucket берётся
+// из пути запроса, а не из формы.
+func (p *PostPolicy) Check(fields map[string]string, bucket string, now time.Time) error {
+	if !now.Before(p.Expiration) {
+		return ErrPolicyExpired
+	}
+	covered := map[string]bool{}
+	for _, c := range p.conds {
+		v := fields[c.field]
+		if c.field == "bucket" {
+			v = bucket
+		}
+		covered[c.field] = true
+		switch c.op {
+		case "eq":
+			if v != c.value {
+				return fmt.Errorf("policy condition failed: [\"eq\", \"$%s\", %q]", c.field, c.value)
+			}
+		case "starts-with":
+			// для Content-Type — каждое значение через запятую
+			vals := []string{v}
+			if c.field == "content-type" {
+				vals = strings.Split(v, ",")
+			}
+			for _, one := range vals {
+				if !strings.HasPrefix(strings.TrimSpace(one), c.value) {
+					return fmt.Errorf("policy condition failed: [\"starts-with\", \"$%s\", %q]", c.field, c.value)
+				}
+			}
+		}
+	}
+	for name := range fields {
+		switch {
+		case name == "policy", name == "x-amz-signature", name == "file", strings.HasPrefix(name, "x-ignore-"):
+		case !covered[name]:
+			return fmt.Errorf("extra input fields: %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayCache помнит подписи уже принятых запросов, пока они не устареют по
+// MaxSkew: тот же запрос, перехваченный и отправленный повторно, отклоняется.
+type ReplayCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // access key + подпись -> когда забыть
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewReplayCache — ttl должен покрывать окно, в котором подпись ещё валидна
+// (2 × MaxSkew: дата запроса может быть и в прошлом, и в будущем).
+func NewReplayCache(ttl time.Duration) *ReplayCache {
+	return &ReplayCache{ttl: ttl, seen: map[string]time.Time{}, now: time.Now}
+}
+
+// Remember отмечает подпись; false — она уже встречалась.
+func (c *ReplayCache) Remember(accessKeyID, signature string) bool {
+	key := accessKeyID + "/" + signature
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	if exp, ok := c.seen[key]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[key] = now.Add(c.ttl)
+	return true
+}
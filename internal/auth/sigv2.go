@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Подпись AWS Signature Version 2 — для старых клиентов (Hadoop s3n, древние
+// SDK). Включается на сервере отдельно: V2 слабее V4 (HMAC-SHA1, тело не
+// подписано), поэтому по умолчанию такие запросы отклоняются.
+
+var ErrSignatureExpired = errors.New("presigned request has expired")
+
+// v2SubResources — параметры запроса, входящие в CanonicalizedResource.
+var v2SubResources = map[string]bool{
+	"acl": true, "cors": true, "delete": true, "lifecycle": true, "location": true,
+	"logging": true, "notification": true, "partNumber": true, "policy": true,
+	"requestPayment": true, "restore": true, "tagging": true, "torrent": true,
+	"uploadId": true, "uploads": true, "versionId": true, "versioning": true,
+	"versions": true, "website": true, "object-lock": true, "retention": true,
+	"legal-hold": true, "select": true, "select-type": true, "attributes": true,
+	"response-cache-control": true, "response-content-disposition": true,
+	"response-content-encoding": true, "response-content-language": true,
+	"response-content-type": true, "response-expires": true,
+}
+
+// IsSigV2 — запрос подписан V2: заголовок "AWS AKID:sig" или presigned URL
+// с AWSAccessKeyId/Signature.
+func IsSigV2(r *http.Request) bool {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "AWS ") {
+		return true
+	}
+	q := r.URL.Query()
+	return q.Get("AWSAccessKeyId") != "" && q.Get("Signature") != ""
+}
+
+// VerifySigV2 проверяет подпись V2 из заголовка Authorization или из
+// presigned URL (Expires вместо Date). Используются MaxSkew и Replay из opts.
+func VerifySigV2(r *http.Request, cred CredentialsProvider, opts VerifyOptions) (*Result, error) {
+	var accessKeyID, signature, date string
+	q := r.URL.Query()
+	if authz := r.Header.Get("Authorization"); authz != "" {
+		rest, ok := strings.CutPrefix(authz, "AWS ")
+		if !ok {
+			return nil, ErrUnsuportedAlgorithm
+		}
+		accessKeyID, signature, ok = strings.Cut(rest, ":")
+		if !ok || accessKeyID == "" || signature == "" {
+			return nil, ErrMalformedAuth
+		}
+		// x-amz-date сильнее Date и тогда попадает в подпись через x-amz-*, а строка Date пустая
+		t, err := v2RequestTime(r)
+		if err != nil {
+			return nil, err
+		}
+		if opts.MaxSkew > 0 {
+			skew := time.Since(t)
+			if skew < 0 {
+				skew = -skew
+			}
+			if skew > opts.MaxSkew {
+				return nil, ErrSkewedDate
+			}
+		}
+		if r.Header.Get("x-amz-date") == "" {
+			date = r.Header.Get("Date")
+		}
+	} else {
+		accessKeyID, signature, date = q.Get("AWSAccessKeyId"), q.Get("Signature"), q.Get("Expires")
+		if accessKeyID == "" || signature == "" || date == "" {
+			return nil, ErrNoAuthHeader
+		}
+		exp, err := strconv.ParseInt(date, 10, 64)
+		if err != nil {
+			return nil, ErrBadDate
+		}
+		if time.Now().Unix() > exp {
+			return nil, ErrSignatureExpired
+		}
+	}
+
+	secret, err := cred.LookupSecret(accessKeyID)
+	if err != nil {
+		return nil, err
+	}
+	m := hmac.New(sha1.New, []byte(secret))
+	m.Write([]byte(v2StringToSign(r, date)))
+	expected := base64.StdEncoding.EncodeToString(m.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		return nil, ErrSignatureMismatch
+	}
+	// без Content-MD5 тело в подпись V2 не входит — как UNSIGNED-PAYLOAD у V4
+	if opts.Replay != nil && !isSafeMethod(r.Method) && r.Header.Get("Content-MD5") != "" &&
+		!opts.Replay.Remember(accessKeyID, expected) {
+		return nil, ErrReplayed
+	}
+	return &Result{AccessKeyID: accessKeyID, AmzDate: time.Now().UTC()}, nil
+}
+
+func v2RequestTime(r *http.Request) (time.Time, error) {
+	v := r.Header.Get("x-amz-date")
+	if v == "" {
+		v = r.Header.Get("Date")
+	}
+	if v == "" {
+		return time.Time{}, ErrMissingDate
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return time.Time{}, ErrBadDate
+	}
+	return t, nil
+}
+
+// v2StringToSign — Method, Content-MD5, Content-Type, Date (или Expires),
+// CanonicalizedAmzHeaders, CanonicalizedResource.
+func v2StringToSign(r *http.Request, date string) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	b.WriteString(r.Header.Get("Content-MD5") + "\n")
+	b.WriteString(r.Header.Get("Content-Type") + "\n")
+	b.WriteString(date + "\n")
+
+	amz := map[string][]string{}
+	var names []string
+	for k, vv := range r.Header {
+		lk := strings.ToLower(k)
+		if !strings.HasPrefix(lk, "x-amz-") {
+			continue
+		}
+		if _, ok := amz[lk]; !ok {
+			names = append(names, lk)
+		}
+		for _, v := range vv {
+			amz[lk] = append(amz[lk], compressSpaces(strings.TrimSpace(v)))
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		b.WriteString(n + ":" + strings.Join(amz[n], ",") + "\n")
+	}
+
+	// path-style: путь уже содержит бакет
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path)
+	q := r.URL.Query()
+	var subs []string
+	for k := range q {
+		if v2SubResources[k] {
+			subs = append(subs, k)
+		}
+	}
+	sort.Strings(subs)
+	for i, k := range subs {
+		if i == 0 {
+			b.WriteByte('?')
+		} else {
+			b.WriteByte('&')
+		}
+		b.WriteString(k)
+		// значения подресурсов подписываются без URL-кодирования
+		if v := q.Get(k); v != "" {
+			b.WriteString("=" + v)
+		}
+	}
+	return b.String()
+}
@@ -19,9 +19,15 @@ import (
 var (
 	ErrNoAuthHeader        = errors.New("missing Authorization header")
 	ErrUnsuportedAlgorithm = errors.New("unsupported algorithm")
+	ErrMalformedAuth       = errors.New("authorization header malformed")
 	ErrBadCredentialScope  = errors.New("bad credential scope")
+	ErrMissingDate         = errors.New("missing x-amz-date")
+	ErrBadDate             = errors.New("bad x-amz-date")
+	ErrHeaderNotSigned     = errors.New("header must be signed")
+	ErrUnsignedPayload     = errors.New("unsigned payload not allowed")
 	ErrSignatureMismatch   = errors.New("signature does not match")
 	ErrSkewedDate          = errors.New("date skew too large")
+	ErrReplayed            = errors.New("request signature already used")
 )
 
 type CredentialsProvider interface {
@@ -37,6 +43,10 @@ type VerifyOptions struct {
 	AllowUnsignedPayload bool
 	// Регион/сервис — для S3 это "s3", регион можно не проверять строго (aws-cli кладёт любой)
 	ExpectedService string // "s3"
+	// Кэш принятых подписей; nil — без защиты от повтора. Проверяются только
+	// изменяющие запросы: повтор GET/HEAD безвреден, а клиенты шлют одинаковые
+	// GET в пределах секунды с одинаковой подписью
+	Replay *ReplayCache
 }
 
 type Result struct {
@@ -45,8 +55,16 @@ type Result struct {
 	AmzDate       time.Time
 	Region        string
 	ScopeDate     string
+
+	// для проверки подписей кусков aws-chunked (NewChunkedReader)
+	signingKey []byte
+	signature  string
+	amzDate    string
+	scope      string
 }
 
+// VerifySigV4 проверяет подпись из заголовка Authorization. Ошибки — из
+// списка выше (через errors.Is), ошибка CredentialsProvider возвращается как есть.
 func VerifySigV4(r *http.Request, cred CredentialsProvider, opts VerifyOptions) (*Result, error) {
 	authz := r.Header.Get("Authorization")
 	if authz == "" {
@@ -59,14 +77,14 @@ func VerifySigV4(r *http.Request, cred CredentialsProvider, opts VerifyOptions)
 	params := parseAuthzParams(strings.TrimPrefix(authz, "AWS4-HMAC-SHA256 "))
 	credential := params["Credential"]
 	signedHeaderCSV := params["SignedHeaders"]
-	signatureHex := params["Signature"]
-	if credential == "" || signedHeaderCSV == "" || signatureHex == "" {
-		return nil, fmt.Errorf("authorization header malformed")
+	signatureHex := strings.ToLower(params["Signature"])
+	if credential == "" || signedHeaderCSV == "" || len(signatureHex) != sha256.Size*2 {
+		return nil, ErrMalformedAuth
 	}
 
 	// Credential=AKIA.../YYYYMMDD/region/service/aws4_request
 	credParts := strings.Split(credential, "/")
-	if len(credParts) != 5 {
+	if len(credParts) != 5 || credParts[0] == "" {
 		return nil, ErrBadCredentialScope
 	}
 	accessKeyID := credParts[0]
@@ -78,17 +96,21 @@ func VerifySigV4(r *http.Request, cred CredentialsProvider, opts VerifyOptions)
 		return nil, ErrBadCredentialScope
 	}
 
-	// Time
+	// Time: x-amz-date, либо Date в том же формате
+	dateHeader := "x-amz-date"
 	amzDate := r.Header.Get("x-amz-date")
 	if amzDate == "" {
-		amzDate = r.Header.Get("Date")
+		dateHeader, amzDate = "date", r.Header.Get("Date")
 	}
 	if amzDate == "" {
-		return nil, fmt.Errorf("missing x-amz-date")
+		return nil, ErrMissingDate
 	}
 	t, err := time.Parse("20060102T150405Z", amzDate)
 	if err != nil {
-		return nil, fmt.Errorf("bad x-amz-date")
+		return nil, ErrBadDate
+	}
+	if scopeDate != t.Format("20060102") {
+		return nil, fmt.Errorf("%w: scope date %s does not match request date", ErrBadCredentialScope, scopeDate)
 	}
 	if opts.MaxSkew > 0 {
 		skew := time.Since(t)
@@ -101,8 +123,20 @@ func VerifySigV4(r *http.Request, cred CredentialsProvider, opts VerifyOptions)
 	}
 
 	signedHeaders := strings.Split(signedHeaderCSV, ";")
+	signed := make(map[string]bool, len(signedHeaders))
 	for i := range signedHeaders {
 		signedHeaders[i] = strings.TrimSpace(strings.ToLower(signedHeaders[i]))
+		if signedHeaders[i] == "" || signed[signedHeaders[i]] {
+			return nil, ErrMalformedAuth
+		}
+		signed[signedHeaders[i]] = true
+	}
+	// без host и даты подпись можно перенести на другой сервер или во времени;
+	// x-amz-* меняют смысл запроса, поэтому тоже обязаны быть подписаны
+	for _, h := range mandatorySignedHeaders(r, dateHeader) {
+		if !signed[h] {
+			return nil, fmt.Errorf("%w: %s", ErrHeaderNotSigned, h)
+		}
 	}
 
 	// Payload Hash
@@ -111,7 +145,7 @@ func VerifySigV4(r *http.Request, cred CredentialsProvider, opts VerifyOptions)
 		payloadHash = hexSha256OfBytes(nil)
 	}
 	if strings.EqualFold(payloadHash, "UNSIGNED-PAYLOAD") && !opts.AllowUnsignedPayload {
-		return nil, fmt.Errorf("unsigned payload not allowed")
+		return nil, ErrUnsignedPayload
 	}
 
 	// Canonical request
@@ -122,10 +156,11 @@ func VerifySigV4(r *http.Request, cred CredentialsProvider, opts VerifyOptions)
 	canonHash := hexSha256OfBytes([]byte(canonicalRequest))
 
 	// String to sign
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", scopeDate, region, service)
 	stringToSign := strings.Join([]string{
 		"AWS4-HMAC-SHA256",
 		amzDate,
-		fmt.Sprintf("%s/%s/%s/aws4_request", scopeDate, region, service),
+		scope,
 		canonHash,
 	}, "\n")
 
@@ -134,17 +169,22 @@ func VerifySigV4(r *http.Request, cred CredentialsProvider, opts VerifyOptions)
 	if err != nil {
 		return nil, err
 	}
-	kDate := hmacSHA256([]byte("AWS4"+secret), []byte(scopeDate))
-	kRegion := hmacSHA256(kDate, []byte(region))
-	kService := hmacSHA256(kRegion, []byte(service))
-	kSigning := hmacSHA256(kService, []byte("aws4_request"))
+	kSigning := signingKey(secret, scopeDate, region, service)
 
 	// Signature
 	expectedSig := hmacSHA256Hex(kSigning, []byte(stringToSign))
 
 	// Compare constant-time
-	if subtle.ConstantTimeCompare([]byte(expectedSig), []byte(strings.ToLower(signatureHex))) != 1 {
-		return nil, err
+	if subtle.ConstantTimeCompare([]byte(expectedSig), []byte(signatureHex)) != 1 {
+		return nil, ErrSignatureMismatch
+	}
+
+	// повтор проверяем только для верной подписи, иначе чужой мусор вытеснял бы записи;
+	// тело без хэша в подписи не проверяем: два разных PUT одного ключа в одну
+	// секунду дали бы одну подпись
+	if opts.Replay != nil && !isSafeMethod(r.Method) && !unsignedPayload(payloadHash) &&
+		!opts.Replay.Remember(accessKeyID, expectedSig) {
+		return nil, ErrReplayed
 	}
 
 	return &Result{
@@ -153,9 +193,56 @@ func VerifySigV4(r *http.Request, cred CredentialsProvider, opts VerifyOptions)
 		AmzDate:       t.UTC(),
 		Region:        region,
 		ScopeDate:     scopeDate,
+		signingKey:    kSigning,
+		signature:     expectedSig,
+		amzDate:       amzDate,
+		scope:         scope,
 	}, nil
 }
 
+// mandatorySignedHeaders — host, заголовок с датой и все присланные x-amz-*.
+func mandatorySignedHeaders(r *http.Request, dateHeader string) []string {
+	out := []string{"host", dateHeader}
+	for k := range r.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") && lk != dateHeader {
+			out = append(out, lk)
+		}
+	}
+	return out
+}
+
+func isSafeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions
+}
+
+// unsignedPayload — x-amz-content-sha256 не закрепляет тело за подписью.
+func unsignedPayload(payloadHash string) bool {
+	return strings.EqualFold(payloadHash, "UNSIGNED-PAYLOAD") ||
+		strings.EqualFold(payloadHash, StreamingUnsignedTrailer)
+}
+
+// AccessKeyFromRequest достаёт AccessKeyID из Credential без проверки подписи
+// (для троттлинга/логов до основной верификации).
+func AccessKeyFromRequest(r *http.Request) string {
+	authz := r.Header.Get("Authorization")
+	if v2, ok := strings.CutPrefix(authz, "AWS "); ok {
+		akid, _, _ := strings.Cut(v2, ":")
+		return akid
+	}
+	if authz == "" {
+		return r.URL.Query().Get("AWSAccessKeyId")
+	}
+	if !strings.HasPrefix(authz, "AWS4-HMAC-SHA256 ") {
+		return ""
+	}
+	cred := parseAuthzParams(strings.TrimPrefix(authz, "AWS4-HMAC-SHA256 "))["Credential"]
+	if i := strings.IndexByte(cred, '/'); i > 0 {
+		return cred[:i]
+	}
+	return ""
+}
+
 // ----- helpers -----
 
 func parseAuthzParams(s string) map[string]string {
@@ -184,18 +271,27 @@ func buildCanonicalRequest(r *http.Request, signedHeaders []string, payloadHash
 		uri = "/"
 	}
 
-	// Canonical Query String: сортировка по ключу/значению, RFC3986 encoding
-	var qpairs []string
+	// Canonical Query String: сортировка по ключу, затем по значению, RFC3986 encoding.
+	// Сортировать готовые "k=v" нельзя: "select-type=2" встал бы раньше "select=".
+	var qpairs [][2]string
 	q := r.URL.Query()
 	for key, vals := range q {
 		ek := uriEncode(key, true)
-		sort.Strings(vals)
 		for _, v := range vals {
-			qpairs = append(qpairs, fmt.Sprintf("%s=%s", ek, uriEncode(v, true)))
+			qpairs = append(qpairs, [2]string{ek, uriEncode(v, true)})
 		}
 	}
-	sort.Strings(qpairs)
-	canonicalQuery := strings.Join(qpairs, "&")
+	sort.Slice(qpairs, func(i, j int) bool {
+		if qpairs[i][0] != qpairs[j][0] {
+			return qpairs[i][0] < qpairs[j][0]
+		}
+		return qpairs[i][1] < qpairs[j][1]
+	})
+	parts := make([]string, len(qpairs))
+	for i, kv := range qpairs {
+		parts[i] = kv[0] + "=" + kv[1]
+	}
+	canonicalQuery := strings.Join(parts, "&")
 
 	// Canonical Headers: только из SignedHeaders (в нижнем регистре; сворачиваем  пробелы)
 	lcHeaders := make(http.Header)
@@ -257,7 +353,7 @@ func uriEncode(s string, encodeSlash bool) string {
 	// AWS style: RFC3986; пробел -> %20; тильда не кодируется
 	escaped := url.QueryEscape(s)
 	escaped = strings.ReplaceAll(escaped, "+", "%20")
-	escaped = strings.ReplaceAll(escaped, "&7E", "~")
+	escaped = strings.ReplaceAll(escaped, "%7E", "~")
 	if !encodeSlash {
 		escaped = strings.ReplaceAll(escaped, "%2F", "/")
 	}
@@ -269,6 +365,13 @@ func hexSha256OfBytes(p []byte) string {
 	return hex.EncodeToString(h[:])
 }
 
+func signingKey(secret, scopeDate, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secret), []byte(scopeDate))
+	kRegion := hmacSHA256(kDate, []byte(region))
+	kService := hmacSHA256(kRegion, []byte(service))
+	return hmacSHA256(kService, []byte("aws4_request"))
+}
+
 func hmacSHA256(key, data []byte) []byte {
 	m := hmac.New(sha256.New, key)
 	m.Write(data)
@@ -9,10 +9,126 @@ import (
 type Config struct {
 	Addr          string // ":8080"
 	DataDir       string // "./data"
-	DBDSN         string // "file:meta.db?_busy_timeout=5000&_fk=1"
+	DBDSN         string // "meta.db" — файл SQLite, postgres://... или mysql://... (сервер default)
+	DBAutoMigrate bool   // true — миграции схемы применяются при старте
 	Region        string // "us-east-1"
 	LogLevel      string // "info"
 	MaxClockSkewS int    // 900 (15 мин)
+	MasterKey     string // 32 байта hex/base64; шифрует SecretAccessKey в БД
+
+	// Мастер-ключ из KMS/HSM: stdout команды вместо MASTER_KEY
+	MasterKeyCommand string
+	// Прежний мастер-ключ на время ротации: секреты перешифровываются при старте
+	MasterKeyPrevious string
+
+	// Троттлинг неудачных попыток аутентификации
+	AuthMaxFailures  int // 5 ошибок подряд до первой блокировки
+	AuthLockoutBaseS int // 30 — первая блокировка, дальше x2
+	AuthLockoutMaxS  int // 900 — потолок блокировки
+	// Задержка ответа на неудачную попытку до блокировки (tarpit)
+	AuthFailDelayMS    int // 100 — после первой ошибки, дальше x2
+	AuthFailDelayMaxMS int // 3000 — потолок задержки
+
+	// Отклонять повторно присланные изменяющие запросы с той же подписью SigV4
+	AuthReplayCheck bool
+	// Принимать подпись AWS Signature V2 от старых клиентов
+	AllowSigV2 bool
+
+	// Лимиты на access key по умолчанию (у пользователя можно переопределить); 0 — без лимита
+	KeyRateLimitRPS float64
+	KeyRateBurst    int
+	KeyMaxInFlight  int
+
+	// Межузловой канал (/internal/v1): общий секрет кластера и имя узла
+	NodeID        string
+	ClusterSecret string // пусто => межузловой API выключен
+
+	// Учётка администратора (создаётся/повышается до role=admin при старте)
+	AdminAccessKey string
+	AdminSecretKey string
+
+	// JSON со списком виртуальных серверов (арендаторов); пусто — один сервер
+	VServersFile string
+
+	// Сколько ждать завершения текущих запросов при остановке/перезапуске
+	ShutdownTimeoutS int
+
+	// Сколько дней хранить ленту изменений (CDC)
+	ChangeFeedRetentionDays int
+
+	// Лимит времени на выполнение Lua-скрипта бакета
+	ScriptTimeoutMS int
+
+	// PUT больше порога режется на куски-блобы этого размера (0 — не резать)
+	PutChunkSizeMB int
+
+	// Как часто сбрасывать журнал доступа (?logging) объектами в целевые бакеты
+	AccessLogFlushS int
+
+	// Сколько раз пытаться доставить уведомление (?notification) до dead-letter
+	NotifyMaxAttempts int
+
+	// Сколько блоб без ссылок ждёт после приговора GC до удаления (0 — сразу)
+	GCGraceS int
+
+	// Через сколько недописанные tmp-файлы и застрявшие pending-блобы считаются
+	// брошенными после сбоя и убираются (0 — уборка выключена)
+	RecoveryAgeS int
+
+	// Период обслуживания SQLite: checkpoint WAL, PRAGMA optimize, incremental
+	// vacuum (0 — выключено; для PostgreSQL и MySQL не используется)
+	DBMaintenanceS int
+
+	// Пределы размера: тело одного PUT/UploadPart/append и итоговый объект (0 — без предела)
+	MaxPutSizeMB    int
+	MaxObjectSizeMB int
+
+	// Свободное место на дисках хранилища: ниже DiskWarnFreeMB — предупреждения
+	// в лог и метрики, ниже DiskMinFreeMB — запись данных отклоняется (0 — порог
+	// выключен); проверка раз в DiskCheckS
+	DiskWarnFreeMB int
+	DiskMinFreeMB  int
+	DiskCheckS     int
+
+	// Старт в режиме только чтения (бэкап, миграция, кончился диск); переключается админским API
+	ReadOnly bool
+
+	// Каталог второго уровня хранения для lifecycle-переходов (Transition); пусто — выключено
+	TierDataDir string
+
+	// Внешнее S3-хранилище для data_dir/tier_data_dir вида s3://bucket/prefix:
+	// адрес (http(s)://host[:port]; пусто — AWS S3), регион и ключи (пусто —
+	// из окружения AWS SDK); RemoteS3PathStyle — бакет в пути, а не в имени
+	// хоста (MinIO); временные файлы записи — в RemoteS3SpoolDir
+	RemoteS3Endpoint  string
+	RemoteS3Region    string
+	RemoteS3AccessKey string
+	RemoteS3SecretKey string
+	RemoteS3PathStyle bool
+	RemoteS3SpoolDir  string
+
+	// Ещё диски основного хранилища через запятую: блобы раскладываются по
+	// data и этим каталогам (JBOD); пусто — один каталог
+	DataDirs string
+
+	// TLS: сертификат и ключ в PEM; пусто — обычный HTTP
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Автоматические сертификаты ACME (Let's Encrypt), challenge HTTP-01
+	ACMEDomains      string // через запятую; пусто — ACME выключен
+	ACMEEmail        string
+	ACMECacheDir     string // "acme-cache"
+	ACMEHTTPAddr     string // ":80" — сюда приходит проверка HTTP-01
+	ACMEDirectoryURL string // пусто — боевой Let's Encrypt
+
+	// mTLS: CA клиентских сертификатов и сопоставление SAN -> access key
+	TLSClientCAFile string
+	MTLSIdentities  string // "svc.internal=AKID,spiffe://corp/app=AKID2"
+	MTLSRequired    bool   // без сертификата соединение не принимается
+
+	// Ключ HMAC токенов продолжения ListObjectsV2; общий у узлов над одной БД
+	ListTokenKey string
 }
 
 func getenv(key, def string) string {
@@ -22,21 +138,134 @@ func getenv(key, def string) string {
 	return def
 }
 
+func getenvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s: %v", key, err)
+		return def
+	}
+	return n
+}
+
+func getenvFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("invalid %s: %v", key, err)
+		return def
+	}
+	return f
+}
+
+func hostname() string {
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	return "local"
+}
+
+// remoteS3PathStyle — REMOTE_S3_PATH_STYLE; по умолчанию бакет в пути у
+// своего хранилища (REMOTE_S3_ENDPOINT задан) и в имени хоста у AWS S3.
+func remoteS3PathStyle() bool {
+	if v := os.Getenv("REMOTE_S3_PATH_STYLE"); v != "" {
+		return v == "1"
+	}
+	return os.Getenv("REMOTE_S3_ENDPOINT") != ""
+}
+
 func New() Config {
-	cfg := Config{
+	return Config{
 		Addr:          getenv("PORT", ":8080"),
 		DataDir:       getenv("DATA_DIR", "./data"),
-		DBDSN:         getenv("DB_DSN", "file:meta.db?_busy_timeout=5000&_fk=1"),
+		DBDSN:         getenv("DB_DSN", "meta.db"),
+		DBAutoMigrate: os.Getenv("DB_AUTO_MIGRATE") != "0",
 		Region:        getenv("REGION", "us-east-1"),
 		LogLevel:      getenv("LOG_LEVEL", "info"),
-		MaxClockSkewS: 900,
-	}
-	if v := os.Getenv("MAX_CLOCK_SKEW_S"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			cfg.MaxClockSkewS = n
-		} else {
-			log.Printf("invalid MAX_CLOCK_SKEW_S: %v", err)
-		}
+		MaxClockSkewS: getenvInt("MAX_CLOCK_SKEW_S", 900),
+		MasterKey:     os.Getenv("MASTER_KEY"),
+
+		MasterKeyCommand:  os.Getenv("MASTER_KEY_COMMAND"),
+		MasterKeyPrevious: os.Getenv("MASTER_KEY_PREVIOUS"),
+
+		AuthMaxFailures:  getenvInt("AUTH_MAX_FAILURES", 5),
+		AuthLockoutBaseS: getenvInt("AUTH_LOCKOUT_BASE_S", 30),
+		AuthLockoutMaxS:  getenvInt("AUTH_LOCKOUT_MAX_S", 900),
+
+		AuthFailDelayMS:    getenvInt("AUTH_FAIL_DELAY_MS", 100),
+		AuthFailDelayMaxMS: getenvInt("AUTH_FAIL_DELAY_MAX_MS", 3000),
+
+		AuthReplayCheck: getenv("AUTH_REPLAY_CHECK", "1") == "1",
+		AllowSigV2:      os.Getenv("ALLOW_SIGV2") == "1",
+
+		KeyRateLimitRPS: getenvFloat("KEY_RATE_LIMIT_RPS", 0),
+		KeyRateBurst:    getenvInt("KEY_RATE_BURST", 0),
+		KeyMaxInFlight:  getenvInt("KEY_MAX_IN_FLIGHT", 0),
+
+		NodeID:        getenv("NODE_ID", hostname()),
+		ClusterSecret: os.Getenv("CLUSTER_SECRET"),
+
+		AdminAccessKey: os.Getenv("ADMIN_ACCESS_KEY"),
+		AdminSecretKey: os.Getenv("ADMIN_SECRET_KEY"),
+
+		VServersFile: os.Getenv("VSERVERS_FILE"),
+
+		ShutdownTimeoutS: getenvInt("SHUTDOWN_TIMEOUT_S", 300),
+
+		ChangeFeedRetentionDays: getenvInt("CHANGE_FEED_RETENTION_DAYS", 7),
+
+		ScriptTimeoutMS: getenvInt("SCRIPT_TIMEOUT_MS", 50),
+
+		PutChunkSizeMB: getenvInt("PUT_CHUNK_SIZE_MB", 256),
+
+		AccessLogFlushS: getenvInt("ACCESS_LOG_FLUSH_S", 300),
+
+		NotifyMaxAttempts: getenvInt("NOTIFY_MAX_ATTEMPTS", 8),
+
+		GCGraceS: getenvInt("GC_GRACE_S", 3600),
+
+		RecoveryAgeS: getenvInt("RECOVERY_AGE_S", 3600),
+
+		DBMaintenanceS: getenvInt("DB_MAINTENANCE_S", 900),
+
+		MaxPutSizeMB:    getenvInt("MAX_PUT_SIZE_MB", 5120),
+		MaxObjectSizeMB: getenvInt("MAX_OBJECT_SIZE_MB", 5<<20),
+
+		DiskWarnFreeMB: getenvInt("DISK_WARN_FREE_MB", 5120),
+		DiskMinFreeMB:  getenvInt("DISK_MIN_FREE_MB", 1024),
+		DiskCheckS:     getenvInt("DISK_CHECK_S", 10),
+
+		ReadOnly: os.Getenv("READ_ONLY") == "1",
+
+		TierDataDir: os.Getenv("TIER_DATA_DIR"),
+		DataDirs:    os.Getenv("DATA_DIRS"),
+
+		RemoteS3Endpoint:  os.Getenv("REMOTE_S3_ENDPOINT"),
+		RemoteS3Region:    os.Getenv("REMOTE_S3_REGION"),
+		RemoteS3AccessKey: os.Getenv("REMOTE_S3_ACCESS_KEY"),
+		RemoteS3SecretKey: os.Getenv("REMOTE_S3_SECRET_KEY"),
+		RemoteS3PathStyle: remoteS3PathStyle(),
+		RemoteS3SpoolDir:  os.Getenv("REMOTE_S3_SPOOL_DIR"),
+
+		TLSCertFile: os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:  os.Getenv("TLS_KEY_FILE"),
+
+		ACMEDomains:      os.Getenv("ACME_DOMAINS"),
+		ACMEEmail:        os.Getenv("ACME_EMAIL"),
+		ACMECacheDir:     getenv("ACME_CACHE_DIR", "acme-cache"),
+		ACMEHTTPAddr:     getenv("ACME_HTTP_ADDR", ":80"),
+		ACMEDirectoryURL: os.Getenv("ACME_DIRECTORY_URL"),
+
+		TLSClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		MTLSIdentities:  os.Getenv("MTLS_IDENTITIES"),
+		MTLSRequired:    os.Getenv("MTLS_REQUIRED") == "1",
+
+		ListTokenKey: os.Getenv("LIST_TOKEN_KEY"),
 	}
-	return cfg
 }
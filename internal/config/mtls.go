@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CertIdentities — сопоставление SAN клиентского сертификата (DNS, URI или
+// e-mail) ключу доступа из MTLS_IDENTITIES: "san=AKID,san=AKID". Ключ
+// доступа отделяется по последнему "=": в URI SAN он может встречаться.
+func (c Config) CertIdentities() (map[string]string, error) {
+	out := map[string]string{}
+	for _, kv := range strings.Split(c.MTLSIdentities, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.LastIndex(kv, "=")
+		if i <= 0 || i == len(kv)-1 {
+			return nil, fmt.Errorf("MTLS_IDENTITIES: %q: want san=access_key", kv)
+		}
+		san := kv[:i]
+		if _, dup := out[san]; dup {
+			return nil, fmt.Errorf("MTLS_IDENTITIES: %q mapped twice", san)
+		}
+		out[san] = kv[i+1:]
+	}
+	if len(out) > 0 && c.TLSClientCAFile == "" {
+		return nil, fmt.Errorf("MTLS_IDENTITIES requires TLS_CLIENT_CA_FILE")
+	}
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// VServer — изолированный арендатор в одном процессе: свой слушатель,
+// каталог данных, БД (а значит и свои пользователи/бакеты) и админ.
+type VServer struct {
+	Name           string   `json:"name"`
+	Addr           string   `json:"addr"`
+	DataDir        string   `json:"data_dir"`
+	DataDirs       []string `json:"data_dirs,omitempty"`     // ещё диски основного хранилища (JBOD) вдобавок к data_dir
+	DBPath         string   `json:"db_path"`                 // файл SQLite, postgres://... или mysql://...
+	TierDataDir    string   `json:"tier_data_dir,omitempty"` // второй уровень хранения (lifecycle Transition)
+	AdminAccessKey string   `json:"admin_access_key,omitempty"`
+	AdminSecretKey string   `json:"admin_secret_key,omitempty"`
+	TLSCertFile    string   `json:"tls_cert_file,omitempty"` // свой сертификат вместо общего TLS_CERT_FILE/ACME
+	TLSKeyFile     string   `json:"tls_key_file,omitempty"`
+}
+
+// VServers — список виртуальных серверов из VSERVERS_FILE; без файла —
+// один сервер "default" с прежними настройками (PORT, DATA_DIR, DB_DSN).
+func (c Config) VServers() ([]VServer, error) {
+	if c.VServersFile == "" {
+		return []VServer{{
+			Name:           "default",
+			Addr:           listenAddr(c.Addr),
+			DataDir:        c.DataDir,
+			DataDirs:       c.DataDirList(),
+			DBPath:         c.DBDSN,
+			TierDataDir:    c.TierDataDir,
+			AdminAccessKey: c.AdminAccessKey,
+			AdminSecretKey: c.AdminSecretKey,
+		}}, nil
+	}
+	raw, err := os.ReadFile(c.VServersFile)
+	if err != nil {
+		return nil, err
+	}
+	var list []VServer
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.VServersFile, err)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%s: no servers defined", c.VServersFile)
+	}
+	// арендаторы не должны делить ни сокет, ни данные, ни БД
+	seen := map[string]string{}
+	for i, vs := range list {
+		if vs.Name == "" || vs.Addr == "" || vs.DataDir == "" || vs.DBPath == "" {
+			return nil, fmt.Errorf("%s: server #%d: name, addr, data_dir and db_path are required", c.VServersFile, i)
+		}
+		for _, k := range []string{"name:" + vs.Name, "addr:" + vs.Addr, "data_dir:" + vs.DataDir, "db_path:" + vs.DBPath} {
+			if other, dup := seen[k]; dup {
+				return nil, fmt.Errorf("%s: %s and %s share %s", c.VServersFile, other, vs.Name, k)
+			}
+			seen[k] = vs.Name
+		}
+		if (vs.TLSCertFile == "") != (vs.TLSKeyFile == "") {
+			return nil, fmt.Errorf("%s: server %s: tls_cert_file and tls_key_file go together", c.VServersFile, vs.Name)
+		}
+		var keys []string
+		for _, dir := range vs.DataDirs {
+			keys = append(keys, "data_dir:"+dir)
+		}
+		if vs.TierDataDir != "" {
+			keys = append(keys, "tier_data_dir:"+vs.TierDataDir)
+		}
+		for _, k := range keys {
+			if other, dup := seen[k]; dup {
+				return nil, fmt.Errorf("%s: %s and %s share %s", c.VServersFile, other, vs.Name, k)
+			}
+			seen[k] = vs.Name
+		}
+	}
+	return list, nil
+}
+
+// listenAddr — адрес слушателя из PORT: "9000" и ":9000" одно и то же,
+// пустой — :8080.
+func listenAddr(port string) string {
+	switch {
+	case port == "":
+		return ":8080"
+	case !strings.Contains(port, ":"):
+		return ":" + port
+	}
+	return port
+}
+
+// DataDirList — DATA_DIRS списком.
+func (c Config) DataDirList() []string {
+	var out []string
+	for _, dir := range strings.Split(c.DataDirs, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			out = append(out, dir)
+		}
+	}
+	return out
+}
@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+// Без VSERVERS_FILE единственный сервер слушает PORT.
+func TestDefaultVServerAddr(t *testing.T) {
+	for _, tc := range []struct{ port, want string }{
+		{"", ":8080"},
+		{":9000", ":9000"},
+		{"9000", ":9000"},
+		{"127.0.0.1:9001", "127.0.0.1:9001"},
+	} {
+		t.Setenv("PORT", tc.port)
+		t.Setenv("VSERVERS_FILE", "")
+		list, err := New().VServers()
+		if err != nil {
+			t.Fatalf("PORT=%q: %v", tc.port, err)
+		}
+		if len(list) != 1 || list[0].Addr != tc.want {
+			t.Errorf("PORT=%q: %+v, want addr %s", tc.port, list, tc.want)
+		}
+	}
+}
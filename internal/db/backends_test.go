@@ -0,0 +1,390 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Одни и те же сценарии на всех СУБД: SQLite — всегда (временный файл),
+// PostgreSQL и MySQL — если заданы TEST_POSTGRES_DSN и TEST_MYSQL_DSN. БД для
+// них — отдельная, под тесты: схема накатывается миграциями, бакеты получают
+// уникальные имена, так что прогоны можно повторять на той же БД.
+
+func forEachBackend(t *testing.T, fn func(t *testing.T, db *DB)) {
+	backends := []struct{ name, env string }{
+		{"sqlite", ""},
+		{"postgres", "TEST_POSTGRES_DSN"},
+		{"mysql", "TEST_MYSQL_DSN"},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			dsn := filepath.Join(t.TempDir(), "meta.db")
+			if b.env != "" {
+				if dsn = os.Getenv(b.env); dsn == "" {
+					t.Skipf("%s is not set", b.env)
+				}
+			}
+			fn(t, openTestDB(t, dsn))
+		})
+	}
+}
+
+func openTestDB(t *testing.T, dsn string) *DB {
+	t.Helper()
+	db, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	if _, err := db.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func testBucket(t *testing.T, db *DB) uint {
+	t.Helper()
+	id, err := db.EnsureBucket("test-"+db.GenVersionID(), 0)
+	if err != nil {
+		t.Fatalf("bucket: %v", err)
+	}
+	return id
+}
+
+// putVersion — новая версия ключа так же, как её пишет PUT: строка ключа под
+// блокировкой, дедуп по checksum или новый блоб, версия, HEAD. Возвращает ID
+// версии и блоба.
+func putVersion(t *testing.T, db *DB, bucketID uint, key, data string) (string, string) {
+	t.Helper()
+	verID, blobID, err := tryPutVersion(db, bucketID, key, data)
+	if err != nil {
+		t.Fatalf("put %q: %v", key, err)
+	}
+	return verID, blobID
+}
+
+func tryPutVersion(db *DB, bucketID uint, key, data string) (verID, blobID string, err error) {
+	sum := sha256.Sum256([]byte(data))
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+	size := int64(len(data))
+	err = db.WithTxImmediate(func(tx *gorm.DB) error {
+		if err := db.LockObjectForUpdate(tx, bucketID, key); err != nil {
+			return err
+		}
+		b, err := db.FindBlobByChecksumTx(tx, checksum)
+		switch {
+		case err == nil:
+			blobID = b.ID
+		case errors.Is(err, ErrNotFound):
+			blobID = db.GenBlobID()
+			if err := db.ReserveBlobPendingTx(tx, blobID, checksum, size, "local"); err != nil {
+				return err
+			}
+			if err := db.MarkBlobReadyTx(tx, blobID); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+		verID = db.GenVersionID()
+		etag := `"` + checksum[7:39] + `"`
+		if err := db.InsertObjectVersionTx(tx, bucketID, key, verID, blobID, size, etag, "text/plain"); err != nil {
+			return err
+		}
+		if err := db.UpsertObjectTx(tx, bucketID, key, blobID, size, etag, "text/plain", verID); err != nil {
+			return err
+		}
+		return db.SetHeadVersionTx(tx, bucketID, key, verID)
+	})
+	return verID, blobID, err
+}
+
+// deleteVersion — DELETE с versionId: версия уходит, HEAD переезжает на
+// предыдущую, без версий пропадает и строка ключа.
+func deleteVersion(t *testing.T, db *DB, bucketID uint, key, verID string) {
+	t.Helper()
+	if err := tryDeleteVersion(db, bucketID, key, verID); err != nil {
+		t.Fatalf("delete %q %s: %v", key, verID, err)
+	}
+}
+
+func tryDeleteVersion(db *DB, bucketID uint, key, verID string) error {
+	return db.WithTxImmediate(func(tx *gorm.DB) error {
+		if err := db.LockObjectForUpdate(tx, bucketID, key); err != nil {
+			return err
+		}
+		if err := db.DeleteVersionTx(tx, verID); err != nil {
+			return err
+		}
+		prev, err := db.GetPrevVersionTx(tx, bucketID, key, "")
+		if errors.Is(err, ErrNotFound) {
+			return db.DeleteObjectTx(tx, bucketID, key)
+		}
+		if err != nil {
+			return err
+		}
+		return db.SetHeadVersionTx(tx, bucketID, key, prev.VersionID)
+	})
+}
+
+func listKeys(t *testing.T, db *DB, p ListV2Params) (keys, prefixes []string) {
+	t.Helper()
+	for {
+		res, err := db.ListObjectsV2(context.Background(), p)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		for _, o := range res.Objects {
+			keys = append(keys, o.Key)
+		}
+		prefixes = append(prefixes, res.CommonPrefixes...)
+		if !res.IsTruncated {
+			return keys, prefixes
+		}
+		p.Cursor = res.Next
+	}
+}
+
+func TestBackendListings(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *DB) {
+		bkt := testBucket(t, db)
+		for _, k := range []string{"a/1", "a/2", "a_b", "axb", "b", "c/d/e", "c/f", "key", "z%"} {
+			putVersion(t, db, bkt, k, "data of "+k)
+		}
+		// скрытый delete-marker'ом ключ в листинг не попадает
+		if err := db.WithTxImmediate(func(tx *gorm.DB) error {
+			dm := db.GenVersionID()
+			if err := db.CreateDeleteMarkerTx(tx, bkt, "key", dm); err != nil {
+				return err
+			}
+			return db.SetHeadVersionTx(tx, bkt, "key", dm)
+		}); err != nil {
+			t.Fatalf("delete marker: %v", err)
+		}
+
+		tests := []struct {
+			name           string
+			p              ListV2Params
+			keys, prefixes []string
+		}{
+			{"all", ListV2Params{}, []string{"a/1", "a/2", "a_b", "axb", "b", "c/d/e", "c/f", "z%"}, nil},
+			{"paged", ListV2Params{MaxKeys: 3}, []string{"a/1", "a/2", "a_b", "axb", "b", "c/d/e", "c/f", "z%"}, nil},
+			{"delimiter", ListV2Params{Delimiter: "/", MaxKeys: 2}, []string{"a_b", "axb", "b", "z%"}, []string{"a/", "c/"}},
+			{"prefix", ListV2Params{Prefix: "c/", Delimiter: "/"}, []string{"c/f"}, []string{"c/d/"}},
+			{"like_escape", ListV2Params{Prefix: "a_"}, []string{"a_b"}, nil},
+			{"percent", ListV2Params{Prefix: "z%"}, []string{"z%"}, nil},
+			{"start_after", ListV2Params{StartAfter: "b"}, []string{"c/d/e", "c/f", "z%"}, nil},
+		}
+		for _, tc := range tests {
+			tc.p.BucketID = bkt
+			keys, prefixes := listKeys(t, db, tc.p)
+			if !slices.Equal(keys, tc.keys) || !slices.Equal(prefixes, tc.prefixes) {
+				t.Errorf("%s: got keys %q prefixes %q, want %q %q", tc.name, keys, prefixes, tc.keys, tc.prefixes)
+			}
+		}
+	})
+}
+
+func TestBackendVersions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *DB) {
+		bkt := testBucket(t, db)
+		var vers []string
+		for _, data := range []string{"v1", "v2", "v3"} {
+			v, _ := putVersion(t, db, bkt, "doc", data)
+			vers = append(vers, v)
+			// порядок версий ключа — по created_at
+			time.Sleep(2 * time.Millisecond)
+		}
+		putVersion(t, db, bkt, "other", "x")
+
+		head, err := db.GetHeadVersion(bkt, "doc")
+		if err != nil || head.VersionID != vers[2] {
+			t.Fatalf("head: %+v %v, want %s", head, err, vers[2])
+		}
+
+		items, err := db.ListObjectVersions(bkt, "", "", nil, 100)
+		if err != nil {
+			t.Fatalf("list versions: %v", err)
+		}
+		var got []string
+		for _, it := range items {
+			got = append(got, it.Key+"/"+it.VersionID)
+			if latest := it.VersionID == vers[2] || it.Key == "other"; it.IsLatest != latest {
+				t.Errorf("%s %s: IsLatest %v", it.Key, it.VersionID, it.IsLatest)
+			}
+		}
+		want := []string{"doc/" + vers[2], "doc/" + vers[1], "doc/" + vers[0]}
+		if len(got) != 4 || !slices.Equal(got[:3], want) {
+			t.Fatalf("versions: got %q, want %q then other", got, want)
+		}
+
+		// постранично с позиции последней отданной версии
+		page, err := db.ListObjectVersions(bkt, "", "doc", &items[1].ObjectVersion, 100)
+		if err != nil || len(page) != 2 || page[0].VersionID != vers[0] || page[1].Key != "other" {
+			t.Fatalf("page after %s: %+v %v", vers[1], page, err)
+		}
+		if page, err := db.ListObjectVersions(bkt, "d", "", nil, 100); err != nil || len(page) != 3 {
+			t.Fatalf("prefix: %d versions, %v", len(page), err)
+		}
+
+		// удаление текущей версии возвращает HEAD на предыдущую
+		deleteVersion(t, db, bkt, "doc", vers[2])
+		head, err = db.GetHeadVersion(bkt, "doc")
+		if err != nil || head.VersionID != vers[1] {
+			t.Fatalf("head after delete: %+v %v, want %s", head, err, vers[1])
+		}
+		deleteVersion(t, db, bkt, "doc", vers[1])
+		deleteVersion(t, db, bkt, "doc", vers[0])
+		if _, err := db.GetHeadVersion(bkt, "doc"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("head of removed key: %v", err)
+		}
+	})
+}
+
+func gcCandidates(t *testing.T, db *DB, condemnedBefore time.Time) []string {
+	t.Helper()
+	rows, err := db.BlobsForGCWithSize(1<<20, condemnedBefore)
+	if err != nil {
+		t.Fatalf("gc candidates: %v", err)
+	}
+	var ids []string
+	for _, r := range rows {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func refCount(t *testing.T, db *DB, blobID string) int64 {
+	t.Helper()
+	n, err := db.BlobRefCountTx(db.DB, blobID)
+	if err != nil {
+		t.Fatalf("ref_count: %v", err)
+	}
+	return n
+}
+
+func TestBackendGC(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *DB) {
+		bkt := testBucket(t, db)
+		data := "shared " + db.GenBlobID()
+		v1, blob := putVersion(t, db, bkt, "one", data)
+		v2, blob2 := putVersion(t, db, bkt, "two", data)
+		if blob2 != blob || refCount(t, db, blob) != 2 {
+			t.Fatalf("dedup: blobs %s %s, ref_count %d", blob, blob2, refCount(t, db, blob))
+		}
+
+		deleteVersion(t, db, bkt, "one", v1)
+		if _, err := db.CondemnBlobs(time.Now()); err != nil {
+			t.Fatalf("condemn: %v", err)
+		}
+		if slices.Contains(gcCandidates(t, db, time.Now()), blob) {
+			t.Fatalf("blob with a reference is a GC candidate")
+		}
+
+		// последняя ссылка снята: приговор, и до конца окна блоб не кандидат
+		deleteVersion(t, db, bkt, "two", v2)
+		condemned := time.Now().UTC()
+		if _, err := db.CondemnBlobs(condemned); err != nil {
+			t.Fatalf("condemn: %v", err)
+		}
+		if slices.Contains(gcCandidates(t, db, condemned.Add(-time.Minute)), blob) {
+			t.Fatalf("blob is a GC candidate inside the grace window")
+		}
+		if !slices.Contains(gcCandidates(t, db, condemned.Add(time.Second)), blob) {
+			t.Fatalf("orphaned blob is not a GC candidate")
+		}
+
+		// PUT тех же байт в окне снимает приговор, и GC блоб не трогает
+		v3, blob3 := putVersion(t, db, bkt, "three", data)
+		if blob3 != blob {
+			t.Fatalf("dedup onto condemned blob: got %s, want %s", blob3, blob)
+		}
+		var gone bool
+		err := db.WithTxImmediate(func(tx *gorm.DB) error {
+			var err error
+			gone, err = db.DeleteBlobIfUnreferencedTx(tx, blob, condemned.Add(time.Second))
+			return err
+		})
+		if err != nil || gone {
+			t.Fatalf("GC of revived blob: gone=%v err=%v", gone, err)
+		}
+		if slices.Contains(gcCandidates(t, db, time.Now().Add(time.Hour)), blob) {
+			t.Fatalf("revived blob is still queued for GC")
+		}
+
+		// снова сирота — приговор заново, и после окна запись удаляется
+		deleteVersion(t, db, bkt, "three", v3)
+		condemned = time.Now().UTC()
+		if _, err := db.CondemnBlobs(condemned); err != nil {
+			t.Fatalf("condemn: %v", err)
+		}
+		err = db.WithTxImmediate(func(tx *gorm.DB) error {
+			var err error
+			gone, err = db.DeleteBlobIfUnreferencedTx(tx, blob, condemned.Add(time.Second))
+			return err
+		})
+		if err != nil || !gone {
+			t.Fatalf("GC of orphan: gone=%v err=%v", gone, err)
+		}
+		if _, err := db.GetBlob(blob); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("blob record after GC: %v", err)
+		}
+		// ссылка на удалённый блоб — ErrBlobGone, а не висячая версия
+		err = db.WithTxImmediate(func(tx *gorm.DB) error {
+			return db.InsertObjectVersionTx(tx, bkt, "late", db.GenVersionID(), blob, 1, `"x"`, "text/plain")
+		})
+		if !errors.Is(err, ErrBlobGone) {
+			t.Fatalf("reference to a deleted blob: %v", err)
+		}
+	})
+}
+
+// Старые версии хранят ACL пустой строкой, новые — "private"; для компакции
+// это одно и то же, как и для SameVersionContent.
+func TestBackendCompactableLegacyACL(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *DB) {
+		for _, tc := range []struct {
+			older, newer string
+			want         bool
+		}{
+			{"", ACLPrivate, true},
+			{ACLPrivate, "", true},
+			{"", "", true},
+			{"", "public-read", false},
+		} {
+			bkt := testBucket(t, db)
+			v1, _ := putVersion(t, db, bkt, "doc", "same bytes")
+			time.Sleep(2 * time.Millisecond)
+			v2, _ := putVersion(t, db, bkt, "doc", "same bytes")
+			for v, acl := range map[string]string{v1: tc.older, v2: tc.newer} {
+				if err := db.UpdateVersionFieldsTx(db.DB, v, map[string]any{"acl": acl}); err != nil {
+					t.Fatalf("acl: %v", err)
+				}
+			}
+			vers, err := db.ListCompactableVersions(bkt, 10)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			a, _ := db.GetVersionTx(db.DB, v1)
+			b, _ := db.GetVersionTx(db.DB, v2)
+			got := len(vers) == 1 && vers[0].VersionID == v1
+			if got != tc.want || SameVersionContent(a, b) != tc.want {
+				t.Errorf("acl %q then %q: compactable %v (%d rows), SameVersionContent %v, want %v",
+					tc.older, tc.newer, got, len(vers), SameVersionContent(a, b), tc.want)
+			}
+		}
+	})
+}
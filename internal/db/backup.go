@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"errors"
+)
+
+// Онлайн-бэкап SQLite: VACUUM INTO пишет согласованный снимок одной читающей
+// транзакцией — в WAL-режиме запись в базу при этом не останавливается.
+
+// ErrBackupUnsupported — у PostgreSQL и MySQL свои средства (pg_dump,
+// mysqldump) или s3mini export.
+var ErrBackupUnsupported = errors.New("online backup is supported for SQLite only")
+
+// SQLiteFile — путь к файлу основной базы SQLite.
+func (db *DB) SQLiteFile() (string, error) {
+	if db.dialect() != dialectSQLite {
+		return "", ErrBackupUnsupported
+	}
+	var rows []struct {
+		Seq  int
+		Name string
+		File string
+	}
+	if err := db.DB.Raw("PRAGMA database_list").Scan(&rows).Error; err != nil {
+		return "", err
+	}
+	for _, r := range rows {
+		if r.Name == "main" && r.File != "" {
+			return r.File, nil
+		}
+	}
+	return "", errors.New("sqlite database has no file (in-memory?)")
+}
+
+// BackupSQLite пишет снимок базы в файл dst, которого ещё не должно быть.
+func (db *DB) BackupSQLite(ctx context.Context, dst string) error {
+	if db.dialect() != dialectSQLite {
+		return ErrBackupUnsupported
+	}
+	return db.DB.WithContext(ctx).Exec("VACUUM INTO ?", dst).Error
+}
+
+// SnapshotBlobs перечисляет блобы снимка path, чьи байты нужны после
+// восстановления: готовые plain-блобы, на которые есть ссылки.
+func SnapshotBlobs(ctx context.Context, path string, fn func(*Blob) error) error {
+	snap, err := OpenSQLite(path)
+	if err != nil {
+		return err
+	}
+	if sqlDB, err := snap.DB.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	after := ""
+	for {
+		var page []Blob
+		if err := snap.DB.WithContext(ctx).
+			Where("id > ? AND ref_count > 0 AND state = ? AND kind = ?", after, "ready", "plain").
+			Order("id").Limit(1000).Find(&page).Error; err != nil {
+			return err
+		}
+		for i := range page {
+			if err := fn(&page[i]); err != nil {
+				return err
+			}
+		}
+		if len(page) < 1000 {
+			return nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
@@ -3,54 +3,90 @@ package db
 import (
 	"fmt"
 
+	"github.com/DanikLP1/s3-storage-service/internal/secrets"
 	"gorm.io/gorm"
 )
 
 type DB struct {
 	*gorm.DB
+	secrets *secrets.Box // nil => секреты пользователей хранятся в открытом виде
 }
 
-func New(gormDB *gorm.DB) *DB { return &DB{gormDB} }
+func New(gormDB *gorm.DB) *DB { return &DB{DB: gormDB} }
 
-func (db *DB) AutoMigrate() error {
-	if err := db.DB.AutoMigrate(&Bucket{}, &Blob{}, &Object{}, &ObjectVersion{}, &User{}, &IdempotencyKey{}, &LifecycleRule{}); err != nil {
-		return err
-	}
-	return db.ensureIndexes()
+// SetSecretBox включает шифрование SecretAccessKey мастер-ключом.
+func (db *DB) SetSecretBox(b *secrets.Box) { db.secrets = b }
+
+// models — все таблицы схемы; порядок — как их создаёт первая миграция.
+func models() []any {
+	return []any{&Bucket{}, &Blob{}, &Object{}, &ObjectVersion{}, &User{}, &AccessKey{}, &IdempotencyKey{}, &LifecycleRule{}, &BlobChunk{}, &DedupSnapshot{}, &DerivedBlob{}, &WorkerLease{}, &BucketChaos{}, &BucketSimulation{}, &ChangeEvent{}, &BatchJob{}, &BatchJobFailure{}, &MultipartUpload{}, &MultipartPart{}, &ObjectVersionTag{}, &BucketTag{}, &BucketGrant{}, &NotificationCursor{}, &NotificationDelivery{}, &PendingDeletion{}, &TrashedVersion{}, &BucketUsageCounter{}}
+}
+
+// index — дополнительный индекс, которого нет в тегах моделей.
+type index struct {
+	name, table, columns string
+	unique               bool
 }
 
-func (db *DB) ensureIndexes() error {
-	stmts := []string{
+func (db *DB) extraIndexes() []index {
+	k := db.quote("key")
+	return []index{
 		// --- blobs ---
-		`CREATE UNIQUE INDEX IF NOT EXISTS ux_blobs_checksum ON blobs (checksum)`,
-		`CREATE INDEX IF NOT EXISTS ix_blobs_state ON blobs (state)`,
-		`CREATE INDEX IF NOT EXISTS ix_blobs_state_created ON blobs (state, created_at)`,
-		`CREATE INDEX IF NOT EXISTS ix_blobs_storage_node ON blobs (storage_node)`,
+		{name: "ux_blobs_checksum", table: "blobs", columns: "checksum", unique: true},
+		{name: "ix_blobs_state", table: "blobs", columns: "state"},
+		{name: "ix_blobs_state_created", table: "blobs", columns: "state, created_at"},
+		{name: "ix_blobs_storage_node", table: "blobs", columns: "storage_node"},
 
 		// --- objects ---
 		// GORM уже держит уникальность по (bucket_id,key) через теги, но индекс явный не помешает.
-		`CREATE INDEX IF NOT EXISTS ix_objects_bucket_key ON objects (bucket_id, key)`,
-		`CREATE INDEX IF NOT EXISTS ix_objects_bucket_key_head ON objects (bucket_id, key, head_version_id)`,
+		{name: "ix_objects_bucket_key", table: "objects", columns: "bucket_id, " + k},
+		{name: "ix_objects_bucket_key_head", table: "objects", columns: "bucket_id, " + k + ", head_version_id"},
 
 		// --- object_versions ---
 		// для быстрых листингов и поиска предыдущих версий
-		`CREATE INDEX IF NOT EXISTS ix_objvers_bucket_key_created_desc ON object_versions (bucket_id, key, created_at DESC)`,
-		`CREATE INDEX IF NOT EXISTS ix_objvers_bucket_key_isdel_created ON object_versions (bucket_id, key, is_delete, created_at)`,
-		`CREATE INDEX IF NOT EXISTS ix_objvers_blob_id ON object_versions (blob_id)`,
+		{name: "ix_objvers_bucket_key_created_desc", table: "object_versions", columns: "bucket_id, " + k + ", created_at DESC"},
+		{name: "ix_objvers_bucket_key_isdel_created", table: "object_versions", columns: "bucket_id, " + k + ", is_delete, created_at"},
+		{name: "ix_objvers_blob_id", table: "object_versions", columns: "blob_id"},
 
 		// --- lifecycle_rules ---
-		`CREATE INDEX IF NOT EXISTS ix_lifecycle_bucket_prefix_enabled ON lifecycle_rules (bucket_id, prefix, enabled)`,
+		{name: "ix_lifecycle_bucket_prefix_enabled", table: "lifecycle_rules", columns: "bucket_id, prefix, enabled"},
 	}
+}
 
-	for i, s := range stmts {
-		if err := db.DB.Exec(s).Error; err != nil {
-			return fmt.Errorf("ensureIndexes step %d failed: %w", i, err)
+// createIndexes создаёт недостающие индексы. MySQL не знает CREATE INDEX IF
+// NOT EXISTS, поэтому наличие проверяется заранее.
+func (db *DB) createIndexes(tx *gorm.DB, indexes []index) error {
+	for _, ix := range indexes {
+		if tx.Migrator().HasIndex(ix.table, ix.name) {
+			continue
+		}
+		s := "CREATE INDEX "
+		if ix.unique {
+			s = "CREATE UNIQUE INDEX "
+		}
+		s += ix.name + " ON " + ix.table + " (" + ix.columns + ")"
+		if err := tx.Exec(s).Error; err != nil {
+			return fmt.Errorf("index %s: %w", ix.name, err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) dropIndexes(tx *gorm.DB, indexes []index) error {
+	for _, ix := range indexes {
+		if !tx.Migrator().HasIndex(ix.table, ix.name) {
+			continue
+		}
+		if err := tx.Migrator().DropIndex(ix.table, ix.name); err != nil {
+			return fmt.Errorf("index %s: %w", ix.name, err)
 		}
 	}
 	return nil
 }
 
 func (db *DB) DSN(path string) string {
-	// WAL + FK + нормальная синхронизация
-	return fmt.Sprintf("%s?_journal_mode=WAL&_foreign_keys=on&_busy_timeout=5000", path)
+	// WAL + ожидание блокировки + нормальная синхронизация; параметры — в синтаксисе
+	// glebarez (_pragma=...), прежние _journal_mode/_busy_timeout он молча пропускал.
+	// Внешние ключи по-прежнему не включены.
+	return fmt.Sprintf("%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)", path)
 }
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Различия SQL между поддерживаемыми СУБД. Имена — как у gorm-диалектов
+// (Dialector.Name()): "sqlite" по умолчанию, "postgres" — PostgreSQL,
+// "mysql" — MySQL и MariaDB.
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+	dialectMySQL    = "mysql"
+)
+
+func (db *DB) dialect() string { return db.DB.Dialector.Name() }
+
+// rowLocks — СУБД умеет SELECT ... FOR UPDATE. SQLite пишет одной
+// транзакцией за раз и блокировку строки заменяет пустым UPDATE.
+func (db *DB) rowLocks() bool { return db.dialect() != dialectSQLite }
+
+// quote — идентификатор (можно "alias.column") в кавычках текущей СУБД:
+// в MySQL KEY — зарезервированное слово, и колонку key без кавычек он не примет.
+func (db *DB) quote(name string) string {
+	var b strings.Builder
+	db.DB.Dialector.QuoteTo(&b, name)
+	return b.String()
+}
+
+// likePrefix — условие «col начинается с prefix» и аргумент к нему. % и _ в
+// префиксе экранируются: иначе prefix "a_b" совпал бы и с "axb".
+func (db *DB) likePrefix(col, prefix string) (string, any) {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	// в строковых литералах MySQL обратный слэш сам экранирует
+	if db.dialect() == dialectMySQL {
+		return col + ` LIKE ? ESCAPE '\\'`, r.Replace(prefix) + "%"
+	}
+	return col + ` LIKE ? ESCAPE '\'`, r.Replace(prefix) + "%"
+}
+
+// insertIgnore — INSERT, который пропускает строку с уже занятым ключом, так
+// что RowsAffected — число вставленных. В MySQL ON DUPLICATE KEY UPDATE (во
+// что gorm превращает DoNothing) с CLIENT_FOUND_ROWS считает и пропущенные.
+func (db *DB) insertIgnore(tx *gorm.DB) *gorm.DB {
+	if db.dialect() == dialectMySQL {
+		return tx.Clauses(clause.Insert{Modifier: "IGNORE"})
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true})
+}
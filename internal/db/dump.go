@@ -0,0 +1,274 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Переносимый дамп метаданных (s3mini export / import): JSONL, первая строка —
+// заголовок, дальше по строке на запись. Колонки — по именам в БД, значения —
+// в JSON-виде полей модели, поэтому дамп одной СУБД загружается в другую
+// (SQLite → PostgreSQL и обратно). Байты блобов в дамп не входят, секреты
+// ключей — как лежат в базе (зашифрованные MASTER_KEY или открытые).
+
+const dumpFormat = "s3mini-metadata"
+
+// ErrBadBlobs — у блобов из дампа нет байт в хранилище (или не тот размер).
+var ErrBadBlobs = errors.New("blobs missing in storage")
+
+type dumpHeader struct {
+	Format     string    `json:"format"`
+	Schema     int       `json:"schema"` // последняя миграция сборки, сделавшей дамп
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+type dumpLine struct {
+	Table string                     `json:"table"`
+	Row   map[string]json.RawMessage `json:"row"`
+}
+
+// ImportResult — сколько строк загружено по таблицам и какие блобы не прошли проверку.
+type ImportResult struct {
+	Rows     map[string]int
+	BadBlobs []string
+}
+
+// dumpModels — таблицы дампа в порядке загрузки. worker_leases нет: аренды
+// принадлежат процессам исходной базы.
+func dumpModels() []any {
+	return []any{
+		&User{}, &AccessKey{}, &Bucket{}, &BucketTag{}, &BucketGrant{}, &BucketChaos{}, &BucketSimulation{}, &LifecycleRule{},
+		&Blob{}, &BlobChunk{}, &DerivedBlob{}, &PendingDeletion{},
+		&Object{}, &ObjectVersion{}, &ObjectVersionTag{}, &TrashedVersion{}, &MultipartUpload{}, &MultipartPart{},
+		&IdempotencyKey{}, &DedupSnapshot{}, &ChangeEvent{}, &NotificationCursor{}, &NotificationDelivery{},
+		&BatchJob{}, &BatchJobFailure{},
+	}
+}
+
+func (db *DB) modelSchema(m any) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: db.DB}
+	if err := stmt.Parse(m); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+// dumpFields — колонки, которые пишутся в таблицу (без связей и полей из JOIN).
+func dumpFields(s *schema.Schema) []*schema.Field {
+	var out []*schema.Field
+	for _, f := range s.Fields {
+		if f.DBName != "" && f.Creatable {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (db *DB) schemaVersion() int {
+	ms := db.migrations()
+	return ms[len(ms)-1].version
+}
+
+// ExportMetadata пишет дамп всех таблиц в w и возвращает число строк по таблицам.
+func (db *DB) ExportMetadata(ctx context.Context, w io.Writer) (map[string]int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(dumpHeader{Format: dumpFormat, Schema: db.schemaVersion(), ExportedAt: time.Now().UTC()}); err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, m := range dumpModels() {
+		s, err := db.modelSchema(m)
+		if err != nil {
+			return counts, err
+		}
+		n, err := db.exportTable(ctx, enc, m, s)
+		counts[s.Table] = n
+		if err != nil {
+			return counts, fmt.Errorf("%s: %w", s.Table, err)
+		}
+	}
+	return counts, bw.Flush()
+}
+
+func (db *DB) exportTable(ctx context.Context, enc *json.Encoder, m any, s *schema.Schema) (int, error) {
+	q := db.DB.WithContext(ctx).Model(m)
+	for _, pk := range s.PrimaryFieldDBNames {
+		q = q.Order(db.quote(pk))
+	}
+	rows, err := q.Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	fields := dumpFields(s)
+	n := 0
+	for rows.Next() {
+		item := reflect.New(s.ModelType)
+		if err := db.DB.ScanRows(rows, item.Interface()); err != nil {
+			return n, err
+		}
+		line := dumpLine{Table: s.Table, Row: make(map[string]json.RawMessage, len(fields))}
+		for _, f := range fields {
+			v, _ := f.ValueOf(ctx, item.Elem())
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return n, err
+			}
+			line.Row[f.DBName] = raw
+		}
+		if err := enc.Encode(line); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// ImportMetadata загружает дамп в пустую базу со схемой этой сборки одной
+// транзакцией. checkBlob проверяет байты каждого блоба; при отказах и без
+// allowBadBlobs ничего не загружается (ErrBadBlobs). Счётчики ссылок, занятого
+// места и очередь GC после загрузки пересчитываются по таблицам.
+func (db *DB) ImportMetadata(ctx context.Context, r io.Reader, checkBlob func(*Blob) error, allowBadBlobs bool) (*ImportResult, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var h dumpHeader
+	if err := dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("dump header: %w", err)
+	}
+	if h.Format != dumpFormat {
+		return nil, fmt.Errorf("not an s3mini metadata dump (format %q)", h.Format)
+	}
+	if h.Schema > db.schemaVersion() {
+		return nil, fmt.Errorf("%w: dump has schema %d, this build knows up to %d", ErrSchemaTooNew, h.Schema, db.schemaVersion())
+	}
+
+	tables := map[string]*schema.Schema{}
+	for _, m := range dumpModels() {
+		s, err := db.modelSchema(m)
+		if err != nil {
+			return nil, err
+		}
+		var n int64
+		if err := db.DB.Model(m).Count(&n).Error; err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, fmt.Errorf("target database is not empty: %s has %d rows", s.Table, n)
+		}
+		tables[s.Table] = s
+	}
+
+	res := &ImportResult{Rows: map[string]int{}}
+	err := db.WithTx(func(tx *gorm.DB) error {
+		tx = tx.WithContext(ctx)
+		var (
+			cur    *schema.Schema
+			fields []*schema.Field
+			batch  []map[string]any
+		)
+		// строки пишутся картами колонок: модель подставила бы умолчание колонки
+		// вместо нулевого значения (Enabled=false у правила с default:true)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := tx.Table(cur.Table).Create(&batch).Error; err != nil {
+				return fmt.Errorf("%s: %w", cur.Table, err)
+			}
+			res.Rows[cur.Table] += len(batch)
+			batch = batch[:0]
+			return nil
+		}
+		for line := 2; ; line++ { // первая строка — заголовок
+			var l dumpLine
+			if err := dec.Decode(&l); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return fmt.Errorf("dump line %d: %w", line, err)
+			}
+			s, ok := tables[l.Table]
+			if !ok {
+				return fmt.Errorf("dump line %d: unknown table %q", line, l.Table)
+			}
+			if s != cur {
+				if err := flush(); err != nil {
+					return err
+				}
+				cur, fields = s, dumpFields(s)
+			}
+			item := reflect.New(s.ModelType).Elem()
+			for col, raw := range l.Row {
+				f := s.LookUpField(col)
+				if f == nil || f.DBName != col || !f.Creatable {
+					return fmt.Errorf("dump line %d: %s has no column %q", line, l.Table, col)
+				}
+				v := reflect.New(f.FieldType)
+				if err := json.Unmarshal(raw, v.Interface()); err != nil {
+					return fmt.Errorf("dump line %d: %s.%s: %w", line, l.Table, col, err)
+				}
+				if err := f.Set(ctx, item, v.Elem().Interface()); err != nil {
+					return fmt.Errorf("dump line %d: %s.%s: %w", line, l.Table, col, err)
+				}
+			}
+			if b, ok := item.Addr().Interface().(*Blob); ok && checkBlob != nil {
+				if err := checkBlob(b); err != nil {
+					res.BadBlobs = append(res.BadBlobs, b.ID+": "+err.Error())
+				}
+			}
+			row := make(map[string]any, len(fields))
+			for _, f := range fields {
+				row[f.DBName], _ = f.ValueOf(ctx, item)
+			}
+			batch = append(batch, row)
+			if len(batch) >= 200 {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if len(res.BadBlobs) > 0 && !allowBadBlobs {
+			return fmt.Errorf("%w: %d", ErrBadBlobs, len(res.BadBlobs))
+		}
+		if err := db.recountBlobRefs(tx); err != nil {
+			return err
+		}
+		if err := db.recountUsageTx(tx); err != nil {
+			return err
+		}
+		return db.resetSequencesTx(tx, tables)
+	})
+	return res, err
+}
+
+// resetSequencesTx — в PostgreSQL явные id не двигают последовательность
+// serial-колонки, и следующая вставка упёрлась бы в уже загруженную строку.
+// MySQL и SQLite поднимают счётчик сами.
+func (db *DB) resetSequencesTx(tx *gorm.DB, tables map[string]*schema.Schema) error {
+	if db.dialect() != dialectPostgres {
+		return nil
+	}
+	for _, s := range tables {
+		f := s.PrioritizedPrimaryField
+		if f == nil || !f.AutoIncrement {
+			continue
+		}
+		q := fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%s', '%s'), COALESCE(MAX(%s), 0) + 1, false) FROM %s`,
+			s.Table, f.DBName, db.quote(f.DBName), db.quote(s.Table))
+		if err := tx.Exec(q).Error; err != nil {
+			return fmt.Errorf("%s: sequence: %w", s.Table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GC без окна (приговор и удаление в одном проходе) крутится параллельно с
+// PUT, которые дедупятся на общие блобы, и DELETE, которые снимают с них
+// последние ссылки. Выборка кандидатов и удаление — разные запросы, и между
+// ними PUT успевает снова сослаться на блоб; DeleteBlobIfUnreferencedTx
+// обязан это увидеть. Запускать и с go test -race.
+
+func gcPassNoGrace(db *DB) error {
+	now := time.Now().UTC()
+	if _, err := db.CondemnBlobs(now); err != nil {
+		return err
+	}
+	rows, err := db.BlobsForGCWithSize(64, now)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := db.WithTxImmediate(func(tx *gorm.DB) error {
+			_, err := db.DeleteBlobIfUnreferencedTx(tx, r.ID, now)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isBusy — SQLite не дождался блокировки записи за busy_timeout. Под -race
+// транзакции медленнее в разы, и при плотной конкуренции так бывает.
+func isBusy(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "SQLITE_BUSY")
+}
+
+// retryBusy повторяет операцию после SQLITE_BUSY, как клиент повторил бы
+// запрос после 500. Транзакция при этом откатилась целиком.
+func retryBusy(op func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := op(); !isBusy(err) || attempt == 5 {
+			return err
+		}
+	}
+}
+
+func TestBackendConcurrentPutDeleteGC(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *DB) {
+		bkt := testBucket(t, db)
+		// свои тела у каждого прогона: на общей тестовой БД дедуп не зацепит чужие блобы
+		run := db.GenVersionID()
+		bodies := make([]string, 4)
+		for i := range bodies {
+			bodies[i] = fmt.Sprintf("%s body %d", run, i)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		gcDone := make(chan error, 1)
+		go func() {
+			// пауза между проходами: вплотную GC не отпускал бы блокировку записи
+			// SQLite; SQLITE_BUSY — как и у StartGC, повтор на следующем проходе
+			for ; ctx.Err() == nil; time.Sleep(2 * time.Millisecond) {
+				if err := gcPassNoGrace(db); err != nil && !isBusy(err) {
+					gcDone <- err
+					return
+				}
+			}
+			gcDone <- nil
+		}()
+
+		const workers, ops = 4, 60
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rnd := rand.New(rand.NewSource(int64(w)))
+				// ключ держит одну версию: новая вытесняет прежнюю, как перезапись без версий
+				live := map[string]string{}
+				for i := range ops {
+					key := fmt.Sprintf("w%d/k%d", w, i%2)
+					var verID, blobID string
+					body := bodies[rnd.Intn(len(bodies))]
+					err := retryBusy(func() (err error) {
+						verID, blobID, err = tryPutVersion(db, bkt, key, body)
+						return err
+					})
+					if err != nil {
+						errs <- fmt.Errorf("put %s: %w", key, err)
+						return
+					}
+					// версия только что записана и ещё не удалена — её блоб жив
+					if _, err := db.GetBlob(blobID); err != nil {
+						errs <- fmt.Errorf("blob %s of %s right after put: %w", blobID, key, err)
+						return
+					}
+					drop := []string{live[key]}
+					live[key] = verID
+					if rnd.Intn(2) == 0 {
+						drop = append(drop, verID)
+						delete(live, key)
+					}
+					for _, v := range drop {
+						if v == "" {
+							continue
+						}
+						if err := retryBusy(func() error { return tryDeleteVersion(db, bkt, key, v) }); err != nil {
+							errs <- fmt.Errorf("delete %s: %w", key, err)
+							return
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		cancel()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		if err := <-gcDone; err != nil {
+			t.Fatalf("gc: %v", err)
+		}
+
+		// ни одна оставшаяся версия не ссылается на удалённый блоб, а счётчики
+		// ссылок сходятся с таблицей версий
+		var dangling int64
+		if err := db.Model(&ObjectVersion{}).
+			Where("bucket_id = ? AND blob_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM blobs b WHERE b.id = object_versions.blob_id)", bkt).
+			Count(&dangling).Error; err != nil {
+			t.Fatalf("dangling: %v", err)
+		}
+		if dangling > 0 {
+			t.Errorf("%d versions reference deleted blobs", dangling)
+		}
+		var blobIDs []string
+		if err := db.Model(&ObjectVersion{}).Where("bucket_id = ? AND blob_id IS NOT NULL", bkt).
+			Distinct().Pluck("blob_id", &blobIDs).Error; err != nil {
+			t.Fatalf("blobs: %v", err)
+		}
+		for _, id := range blobIDs {
+			fromVersions, err := db.BlobRefCountFromVersionsTx(db.DB, id)
+			if err != nil {
+				t.Fatalf("refs: %v", err)
+			}
+			if n := refCount(t, db, id); n != fromVersions {
+				t.Errorf("blob %s: ref_count %d, versions %d", id, n, fromVersions)
+			}
+		}
+	})
+}
+
+// TestBackendGCDedupRace — сама гонка, из-за которой GC двухфазный: блоб уже
+// выбран кандидатом, и тут PUT тех же байт и удаление стартуют одновременно.
+// Кто бы ни успел первым, итог согласован: PUT сослался на блоб — GC его не
+// удалил; GC удалил — PUT записал байты новым блобом.
+func TestBackendGCDedupRace(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *DB) {
+		bkt := testBucket(t, db)
+		for round := range 100 {
+			data := fmt.Sprintf("round %d %s", round, db.GenBlobID())
+			verID, blob := putVersion(t, db, bkt, "old", data)
+			deleteVersion(t, db, bkt, "old", verID)
+			condemned := time.Now().UTC()
+			if _, err := db.CondemnBlobs(condemned); err != nil {
+				t.Fatalf("condemn: %v", err)
+			}
+			if !slices.Contains(gcCandidates(t, db, condemned), blob) {
+				t.Fatalf("round %d: orphan %s is not a GC candidate", round, blob)
+			}
+
+			var (
+				wg      sync.WaitGroup
+				start   = make(chan struct{})
+				gone    bool
+				gcErr   error
+				newVer  string
+				newBlob string
+				putErr  error
+			)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				<-start
+				gcErr = retryBusy(func() error {
+					return db.WithTxImmediate(func(tx *gorm.DB) error {
+						var err error
+						gone, err = db.DeleteBlobIfUnreferencedTx(tx, blob, condemned)
+						return err
+					})
+				})
+			}()
+			go func() {
+				defer wg.Done()
+				<-start
+				putErr = retryBusy(func() (err error) {
+					newVer, newBlob, err = tryPutVersion(db, bkt, "new", data)
+					return err
+				})
+			}()
+			close(start)
+			wg.Wait()
+			if gcErr != nil || putErr != nil {
+				t.Fatalf("round %d: gc %v, put %v", round, gcErr, putErr)
+			}
+			if gone == (newBlob == blob) {
+				t.Fatalf("round %d: GC deleted=%v, PUT deduped onto the candidate=%v", round, gone, newBlob == blob)
+			}
+			if _, err := db.GetBlob(newBlob); err != nil {
+				t.Fatalf("round %d: blob %s of the new version: %v", round, newBlob, err)
+			}
+			deleteVersion(t, db, bkt, "new", newVer)
+		}
+	})
+}
@@ -1,6 +1,73 @@
 package db
 
-import "time"
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TagFilter — теги из фильтра правила (все должны совпасть).
+func (r *LifecycleRule) TagFilter() map[string]string {
+	if r.Tags == "" {
+		return nil
+	}
+	q, err := url.ParseQuery(r.Tags)
+	if err != nil {
+		return nil
+	}
+	out := make(map[string]string, len(q))
+	for k, vs := range q {
+		if len(vs) > 0 {
+			out[k] = vs[0]
+		}
+	}
+	return out
+}
+
+// ruleFilterSQL — условия фильтра правила на версию из таблицы/алиаса v: бакет,
+// префикс, размер и теги. Delete-marker без размера и тегов под фильтр по
+// размеру или тегам не попадает.
+func (db *DB) ruleFilterSQL(v string, r *LifecycleRule) (string, []any) {
+	prefixCond, prefixArg := db.likePrefix(db.quote(v+".key"), r.Prefix)
+	conds := []string{v + ".bucket_id = ?", prefixCond}
+	args := []any{r.BucketID, prefixArg}
+	if r.SizeGreaterThan != nil {
+		conds = append(conds, v+".size > ?")
+		args = append(args, *r.SizeGreaterThan)
+	}
+	if r.SizeLessThan != nil {
+		conds = append(conds, v+".size < ?")
+		args = append(args, *r.SizeLessThan)
+	}
+	for k, val := range r.TagFilter() {
+		conds = append(conds, "EXISTS (SELECT 1 FROM object_version_tags t WHERE t.version_id = "+v+".version_id AND "+db.quote("t.key")+" = ? AND t.value = ?)")
+		args = append(args, k, val)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// Matches — то же, что ruleFilterSQL, но для одной версии в памяти.
+func (r *LifecycleRule) Matches(key string, size int64, tags map[string]string) bool {
+	if !strings.HasPrefix(key, r.Prefix) {
+		return false
+	}
+	if r.SizeGreaterThan != nil && size <= *r.SizeGreaterThan || r.SizeLessThan != nil && size >= *r.SizeLessThan {
+		return false
+	}
+	for k, v := range r.TagFilter() {
+		if got, ok := tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ListEnabledBucketLifecycleRules — включённые правила одного бакета.
+func (db *DB) ListEnabledBucketLifecycleRules(bucketID uint) ([]LifecycleRule, error) {
+	var rules []LifecycleRule
+	err := db.DB.Where("bucket_id = ? AND enabled = ?", bucketID, true).Order("id").Find(&rules).Error
+	return rules, err
+}
 
 func (db *DB) ListEnabledLifecycleRules() ([]LifecycleRule, error) {
 	var rules []LifecycleRule
@@ -8,22 +75,24 @@ func (db *DB) ListEnabledLifecycleRules() ([]LifecycleRule, error) {
 	return rules, err
 }
 
-func (db *DB) ListNoncurrentByAge(bucketID uint, prefix string, olderThan time.Time, limit int) ([]ObjectVersion, error) {
+func (db *DB) ListNoncurrentByAge(rule *LifecycleRule, olderThan time.Time, limit int) ([]ObjectVersion, error) {
 	var vers []ObjectVersion
+	cond, args := db.ruleFilterSQL("object_versions", rule)
 	err := db.DB.
-		Where("bucket_id = ? AND key LIKE ? AND is_delete = FALSE AND created_at < ?", bucketID, prefix+"%", olderThan).
+		Where(cond, args...).
+		Where("is_delete = ? AND created_at < ?", false, olderThan).
 		Order("created_at ASC").
 		Limit(limit).
 		Find(&vers).Error
 	return vers, err
 }
 
-// Для SQLite: вернуть самые старые noncurrent-версии СВЕРХ K свежих.
+// Вернуть самые старые noncurrent-версии СВЕРХ K свежих.
 // Алгоритм:
 //  1) Найти ключи, где число noncurrent-версий > keep.
 //  2) Для каждого ключа взять версии, отсортированные по created_at DESC,
 //     с OFFSET keep (то есть «всё после K свежих»), пока не наберём limit.
-func (db *DB) ListNoncurrentKeepNewest(bucketID uint, prefix string, keep int, limit int) ([]ObjectVersion, error) {
+func (db *DB) ListNoncurrentKeepNewest(rule *LifecycleRule, keep int, limit int) ([]ObjectVersion, error) {
 	type KeyCnt struct {
 		Key string
 		Cnt int64
@@ -33,18 +102,20 @@ func (db *DB) ListNoncurrentKeepNewest(bucketID uint, prefix string, keep int, l
 	// 1) ключи с избытком noncurrent-версий
 	// Важно: исключаем HEAD для каждого key
 
+	cond, condArgs := db.ruleFilterSQL("v", rule)
+	vkey, okey := db.quote("v.key"), db.quote("o.key")
 	q := `
-		SELECT v.key AS key, COUNT(*) AS cnt
+		SELECT ` + vkey + ` AS ` + db.quote("key") + `, COUNT(*) AS cnt
 		FROM object_versions v
 		JOIN objects o
-		  ON o.bucket_id = v.bucket_id AND o.key = v.key
-		WHERE v.bucket_id = ? AND v.key LIKE ? AND v.is_delete = FALSE
+		  ON o.bucket_id = v.bucket_id AND ` + okey + ` = ` + vkey + `
+		WHERE ` + cond + ` AND v.is_delete = ?
 		  AND v.version_id <> o.head_version_id
-		GROUP BY v.key
+		GROUP BY ` + vkey + `
 		HAVING COUNT(*) > ?
-		ORDER BY v.key
+		ORDER BY ` + vkey + `
 	`
-	if err := db.DB.Raw(q, bucketID, prefix+"%", keep).Scan(&keys).Error; err != nil {
+	if err := db.DB.Raw(q, append(condArgs, false, keep)...).Scan(&keys).Error; err != nil {
 		return nil, err
 	}
 	if len(keys) == 0 || limit <= 0 {
@@ -65,15 +136,15 @@ func (db *DB) ListNoncurrentKeepNewest(bucketID uint, prefix string, keep int, l
 		// добавляем вторичный порядок по version_id для стабильности
 		err := db.DB.
 			Raw(`
-				SELECT v.version_id, v.bucket_id, v.key, v.blob_id
+				SELECT v.version_id, v.bucket_id, `+vkey+`, v.blob_id
 				FROM object_versions v
 				JOIN objects o
-				  ON o.bucket_id = v.bucket_id AND o.key = v.key
-				WHERE v.bucket_id = ? AND v.key = ? AND v.is_delete = FALSE
+				  ON o.bucket_id = v.bucket_id AND `+okey+` = `+vkey+`
+				WHERE `+cond+` AND `+vkey+` = ? AND v.is_delete = ?
 				  AND v.version_id <> o.head_version_id
 				ORDER BY v.created_at DESC, v.version_id DESC
 				LIMIT ? OFFSET ?
-			`, bucketID, kc.Key, left, keep).
+			`, append(append([]any{}, condArgs...), kc.Key, false, left, keep)...).
 			Scan(&rows).Error
 		if err != nil {
 			return nil, err
@@ -92,22 +163,98 @@ func min(a, b int) int {
 	return b
 }
 
-func (db *DB) ListDeleteMarkersForPurge(bucketID uint, prefix string, olderThan time.Time, limit int) ([]ObjectVersion, error) {
+func (db *DB) ListDeleteMarkersForPurge(rule *LifecycleRule, olderThan time.Time, limit int) ([]ObjectVersion, error) {
 	var dms []ObjectVersion
+	cond, args := db.ruleFilterSQL("object_versions", rule)
 	err := db.DB.
-		Where("bucket_id = ? AND key LIKE ? AND is_delete = TRUE AND created_at < ?", bucketID, prefix+"%", olderThan).
+		Where(cond, args...).
+		Where("is_delete = ? AND created_at < ?", true, olderThan).
 		Order("created_at ASC").
 		Limit(limit).
 		Find(&dms).Error
 	return dms, err
 }
 
-func (db *DB) ListHeadsOlderThan(bucketID uint, prefix string, olderThan time.Time, limit int) ([]Object, error) {
+// ListHeadsOlderThan — объекты, HEAD-версия которых создана раньше olderThan;
+// размер и теги фильтра правила тоже проверяются на HEAD. Объекты, уже
+// закрытые delete-marker'ом, не возвращаются.
+func (db *DB) ListHeadsOlderThan(rule *LifecycleRule, olderThan time.Time, limit int) ([]Object, error) {
 	var objs []Object
+	cond, args := db.ruleFilterSQL("v", rule)
+	err := db.DB.Table("objects o").
+		Select("o.*").
+		Joins("JOIN object_versions v ON v.version_id = o.head_version_id").
+		Where(cond, args...).
+		Where("v.is_delete = ? AND v.created_at < ?", false, olderThan).
+		Order("v.created_at ASC").
+		Limit(limit).
+		Scan(&objs).Error
+	return objs, err
+}
+
+// ListCurrentForTransition — текущие (HEAD) версии старше olderThan, у которых
+// класс хранения ещё не class.
+func (db *DB) ListCurrentForTransition(rule *LifecycleRule, olderThan time.Time, limit int) ([]ObjectVersion, error) {
+	var vers []ObjectVersion
+	cond, args := db.ruleFilterSQL("v", rule)
+	err := db.DB.Table("object_versions v").
+		Select("v.*").
+		Joins("JOIN objects o ON o.bucket_id = v.bucket_id AND "+db.quote("o.key")+" = "+db.quote("v.key")+" AND o.head_version_id = v.version_id").
+		Where(cond, args...).
+		Where("v.is_delete = ? AND v.blob_id IS NOT NULL", false).
+		Where("v.storage_class <> ? AND v.created_at < ?", rule.TransitionToClass, olderThan).
+		Order("v.created_at ASC").
+		Limit(limit).
+		Scan(&vers).Error
+	return vers, err
+}
+
+// ListStaleMultipartUploads — незавершённые multipart-загрузки, начатые раньше olderThan.
+// У загрузки нет ни тегов, ни итогового размера — из фильтра действует только префикс.
+func (db *DB) ListStaleMultipartUploads(rule *LifecycleRule, olderThan time.Time, limit int) ([]MultipartUpload, error) {
+	var ups []MultipartUpload
+	prefixCond, prefixArg := db.likePrefix(db.quote("key"), rule.Prefix)
 	err := db.DB.
-		Where("bucket_id = ? AND key LIKE ? AND created_at < ?", bucketID, prefix+"%", olderThan).
+		Where("bucket_id = ? AND "+prefixCond+" AND created_at < ?", rule.BucketID, prefixArg, olderThan).
 		Order("created_at ASC").
 		Limit(limit).
-		Find(&objs).Error
-	return objs, err
+		Find(&ups).Error
+	return ups, err
+}
+
+// ListPendingRestores — версии с запрошенным RestoreObject, байты которых ещё не перенесены.
+func (db *DB) ListPendingRestores(limit int) ([]ObjectVersion, error) {
+	var vers []ObjectVersion
+	err := db.DB.
+		Where("restore_ongoing = ? AND blob_id IS NOT NULL", true).
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&vers).Error
+	return vers, err
+}
+
+// ListExpiredRestores — восстановленные версии, срок копии которых истёк к now.
+func (db *DB) ListExpiredRestores(now time.Time, limit int) ([]ObjectVersion, error) {
+	var vers []ObjectVersion
+	err := db.DB.
+		Where("restore_ongoing = ? AND restore_expires_at IS NOT NULL AND restore_expires_at < ?", false, now).
+		Where("blob_id IS NOT NULL").
+		Order("restore_expires_at ASC").
+		Limit(limit).
+		Find(&vers).Error
+	return vers, err
+}
+
+// EnsureDefaultLifecycleRule — правило-пресет, если у бакета ещё нет ни одного правила.
+func (db *DB) EnsureDefaultLifecycleRule(bucketID uint, rule LifecycleRule) error {
+	var n int64
+	if err := db.Model(&LifecycleRule{}).Where("bucket_id = ?", bucketID).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	rule.ID = 0
+	rule.BucketID = bucketID
+	return db.Create(&rule).Error
 }
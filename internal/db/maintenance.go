@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Обслуживание SQLite: WAL-файл растёт, пока его не перенесут в базу
+// (checkpoint), статистика планировщика устаревает, а страницы удалённых
+// строк остаются в файле на freelist. PostgreSQL и MySQL делают это сами
+// (autovacuum, фоновый purge), для них методы возвращают ErrMaintenanceUnsupported.
+
+var ErrMaintenanceUnsupported = errors.New("db maintenance is supported for SQLite only")
+
+// CheckpointResult — итог PRAGMA wal_checkpoint.
+type CheckpointResult struct {
+	Busy         bool // checkpoint не завершился: мешал читатель или писатель
+	Log          int  // страниц в WAL (-1 — база не в WAL-режиме)
+	Checkpointed int  // из них перенесено в базу
+}
+
+// CheckpointWAL переносит WAL в базу и обрезает его до нуля (TRUNCATE).
+func (db *DB) CheckpointWAL(ctx context.Context) (CheckpointResult, error) {
+	if db.dialect() != dialectSQLite {
+		return CheckpointResult{}, ErrMaintenanceUnsupported
+	}
+	var row struct {
+		Busy         int
+		Log          int
+		Checkpointed int
+	}
+	if err := db.DB.WithContext(ctx).Raw("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&row).Error; err != nil {
+		return CheckpointResult{}, err
+	}
+	return CheckpointResult{Busy: row.Busy != 0, Log: row.Log, Checkpointed: row.Checkpointed}, nil
+}
+
+// Optimize обновляет статистику планировщика там, где она устарела.
+func (db *DB) Optimize(ctx context.Context) error {
+	if db.dialect() != dialectSQLite {
+		return ErrMaintenanceUnsupported
+	}
+	return db.DB.WithContext(ctx).Exec("PRAGMA optimize").Error
+}
+
+// VacuumResult — итог IncrementalVacuum.
+type VacuumResult struct {
+	Enabled bool // auto_vacuum=INCREMENTAL; иначе страницы не освобождаются
+	Freed   int  // страниц возвращено файловой системе
+	Left    int  // осталось на freelist (не уложились в budget)
+}
+
+// vacuumStep — страниц за один PRAGMA incremental_vacuum (прагма не принимает
+// параметров запроса, число вставляется в текст): каждый шаг — отдельная
+// короткая запись, между шагами успевают пройти запросы клиентов.
+const vacuumStep = 1024
+
+// IncrementalVacuum отдаёт свободные страницы файловой системе шагами по
+// vacuumStep, пока freelist не опустеет или не выйдет budget.
+func (db *DB) IncrementalVacuum(ctx context.Context, budget time.Duration) (VacuumResult, error) {
+	var res VacuumResult
+	if db.dialect() != dialectSQLite {
+		return res, ErrMaintenanceUnsupported
+	}
+	var mode int
+	if err := db.DB.WithContext(ctx).Raw("PRAGMA auto_vacuum").Scan(&mode).Error; err != nil {
+		return res, err
+	}
+	free, err := db.freelistCount(ctx)
+	if err != nil {
+		return res, err
+	}
+	// 2 — INCREMENTAL; в режиме NONE (0) и FULL (1) шагать нечего
+	if mode != 2 {
+		res.Left = free
+		return res, nil
+	}
+	res.Enabled = true
+	deadline := time.Now().Add(budget)
+	for free > 0 && time.Now().Before(deadline) {
+		if err := db.DB.WithContext(ctx).Exec("PRAGMA incremental_vacuum(" + strconv.Itoa(vacuumStep) + ")").Error; err != nil {
+			return res, err
+		}
+		left, err := db.freelistCount(ctx)
+		if err != nil {
+			return res, err
+		}
+		if left >= free {
+			break // страницы заняли снова быстрее, чем освобождаем
+		}
+		res.Freed += free - left
+		free = left
+	}
+	res.Left = free
+	return res, nil
+}
+
+func (db *DB) freelistCount(ctx context.Context) (int, error) {
+	var n int
+	err := db.DB.WithContext(ctx).Raw("PRAGMA freelist_count").Scan(&n).Error
+	return n, err
+}
+
+// initAutoVacuum включает auto_vacuum=INCREMENTAL у новой, ещё пустой базы.
+// Не в DSN: прагма на каждом новом соединении мешает параллельной записи, а
+// journal_mode(WAL) из DSN уже записал заголовок файла — режим меняет только
+// VACUUM, на пустой базе он мгновенный. Существующей базе нужен разовый
+// VACUUM вручную (см. README).
+func (db *DB) initAutoVacuum() error {
+	var tables int64
+	if err := db.DB.Raw("SELECT count(*) FROM sqlite_master").Scan(&tables).Error; err != nil {
+		return err
+	}
+	if tables > 0 {
+		return nil
+	}
+	// одно соединение: до VACUUM прагма живёт только в нём
+	return db.DB.Connection(func(tx *gorm.DB) error {
+		if err := tx.Exec("PRAGMA auto_vacuum = INCREMENTAL").Error; err != nil {
+			return err
+		}
+		return tx.Exec("VACUUM").Error
+	})
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Схема меняется только миграциями: у каждой номер, Up и (если возможно) Down.
+// Применённые записываются в schema_migrations, так что обновление идёт по
+// шагам, а откат — обратно до нужного номера. Первая миграция создаёт таблицы
+// по текущим моделям, поэтому последующие проверяют, не сделано ли их изменение
+// уже (HasColumn/HasIndex): на свежей базе колонка может появиться ещё в первой.
+//
+// Каждая миграция — в своей транзакции вместе с записью о ней. В MySQL DDL
+// фиксируется сразу, и упавшая посередине миграция там может остаться
+// частично применённой.
+
+// SchemaMigration — строка schema_migrations: применённая миграция.
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:128;not null"`
+	AppliedAt time.Time
+}
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+	down    func(tx *gorm.DB) error // nil — необратимая
+}
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this build")
+
+// migrations — все миграции по возрастанию номера. Номера не переиспользуются,
+// применённые миграции не меняются — только новые в конец.
+func (db *DB) migrations() []migration {
+	return []migration{
+		{
+			version: 1, name: "initial_schema",
+			// на базе, созданной до миграций, досоздаёт недостающие колонки
+			up:   func(tx *gorm.DB) error { return tx.AutoMigrate(models()...) },
+			down: func(tx *gorm.DB) error { return tx.Migrator().DropTable(models()...) },
+		},
+		{
+			version: 2, name: "extra_indexes",
+			up:   func(tx *gorm.DB) error { return db.createIndexes(tx, db.extraIndexes()) },
+			down: func(tx *gorm.DB) error { return db.dropIndexes(tx, db.extraIndexes()) },
+		},
+		{
+			version: 3, name: "access_keys_from_users",
+			up: db.migrateAccessKeys,
+			// ключи остаются в access_keys: их читает любая сборка с миграциями,
+			// а секреты там уже могут быть зашифрованы мастер-ключом
+			down: func(tx *gorm.DB) error { return nil },
+		},
+		{
+			version: 4, name: "blob_refcounts",
+			up: db.migrateBlobRefs,
+			down: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&PendingDeletion{}); err != nil {
+					return err
+				}
+				return db.dropColumn(tx, "blobs", "ref_count")
+			},
+		},
+		{
+			version: 5, name: "gc_condemned_at",
+			up:   db.migrateCondemnedAt,
+			down: db.rollbackCondemnedAt,
+		},
+		{
+			version: 6, name: "bucket_trash",
+			up:   db.migrateTrash,
+			down: db.rollbackTrash,
+		},
+		{
+			version: 7, name: "bucket_usage_counters",
+			up: db.migrateUsageCounters,
+			down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&BucketUsageCounter{})
+			},
+		},
+		{
+			version: 8, name: "quotas",
+			up:   db.migrateQuotas,
+			down: db.rollbackQuotas,
+		},
+	}
+}
+
+// dropColumn — ALTER TABLE ... DROP COLUMN. Мигратор gorm для SQLite вместо
+// этого пересоздаёт таблицу и теряет её индексы.
+func (db *DB) dropColumn(tx *gorm.DB, table, column string) error {
+	return tx.Exec("ALTER TABLE " + db.quote(table) + " DROP COLUMN " + db.quote(column)).Error
+}
+
+// MigrationStatus — миграция этой сборки и когда она применена (nil — нет).
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+func (db *DB) appliedMigrations() (map[int]SchemaMigration, error) {
+	if err := db.DB.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.DB.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[int]SchemaMigration, len(rows))
+	for _, r := range rows {
+		out[r.Version] = r
+	}
+	return out, nil
+}
+
+// checkKnown — в базе нет миграций, которых не знает эта сборка: после отката
+// бинарника без отката схемы старый код работал бы с чужими таблицами.
+func (db *DB) checkKnown(applied map[int]SchemaMigration) error {
+	known := map[int]bool{}
+	for _, m := range db.migrations() {
+		known[m.version] = true
+	}
+	for v, r := range applied {
+		if !known[v] {
+			return fmt.Errorf("%w: migration %d (%s) is applied; roll it back with the newer build first", ErrSchemaTooNew, v, r.Name)
+		}
+	}
+	return nil
+}
+
+// MigrationsStatus — все миграции сборки с отметкой о применении.
+func (db *DB) MigrationsStatus() ([]MigrationStatus, error) {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var out []MigrationStatus
+	for _, m := range db.migrations() {
+		st := MigrationStatus{Version: m.version, Name: m.name}
+		if r, ok := applied[m.version]; ok {
+			st.AppliedAt = &r.AppliedAt
+		}
+		out = append(out, st)
+	}
+	return out, db.checkKnown(applied)
+}
+
+// PendingMigrations — число ещё не применённых миграций.
+func (db *DB) PendingMigrations() (int, error) {
+	st, err := db.MigrationsStatus()
+	n := 0
+	for _, m := range st {
+		if m.AppliedAt == nil {
+			n++
+		}
+	}
+	return n, err
+}
+
+// Migrate применяет все недостающие миграции по порядку и возвращает их номера.
+func (db *DB) Migrate() ([]int, error) {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.checkKnown(applied); err != nil {
+		return nil, err
+	}
+	var done []int
+	for _, m := range db.migrations() {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		err := db.WithTx(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		done = append(done, m.version)
+	}
+	return done, nil
+}
+
+// MigrateDown откатывает применённые миграции с номером больше target, от
+// последней к первой. Необратимая миграция на пути останавливает откат.
+func (db *DB) MigrateDown(target int) ([]int, error) {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.checkKnown(applied); err != nil {
+		return nil, err
+	}
+	ms := db.migrations()
+	var done []int
+	for i := len(ms) - 1; i >= 0; i-- {
+		m := ms[i]
+		if m.version <= target {
+			break
+		}
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		if m.down == nil {
+			return done, fmt.Errorf("migration %d (%s) is irreversible", m.version, m.name)
+		}
+		err := db.WithTx(func(tx *gorm.DB) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback %d (%s): %w", m.version, m.name, err)
+		}
+		done = append(done, m.version)
+	}
+	return done, nil
+}
@@ -9,6 +9,51 @@ type Bucket struct {
 	OwnerID   uint      `gorm:"index;"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 
+	// Настройки безопасности загрузок (?settings)
+	RejectUnsignedPayload  bool `gorm:"not null;default:false"`
+	RequireContentChecksum bool `gorm:"not null;default:false"` // Content-MD5 или x-amz-checksum-*
+	RequireSSE             bool `gorm:"not null;default:false"` // x-amz-server-side-encryption
+	// Схлопывать подряд идущие версии с тем же блобом (повторная загрузка без изменений)
+	CompactIdenticalVersions bool `gorm:"not null;default:false"`
+	// Трансформации на GET (?w=&h= и т.п.) с кэшем производных блобов
+	TransformsEnabled bool `gorm:"not null;default:false"`
+	// Определять Content-Type (расширение + первые байты), если клиент его не прислал
+	DetectContentType bool `gorm:"not null;default:false"`
+	// Профиль бакета (задаётся при создании): "" | "backup"
+	Profile string `gorm:"size:32;not null;default:''"`
+	// WORM-окно: версии моложе N дней нельзя удалить ни клиенту, ни lifecycle
+	ProtectionDays int `gorm:"not null;default:0"`
+	// Lua-скрипт бакета (?script): allow/deny и правка заголовков запроса
+	Script string `gorm:"type:text;not null;default:''"`
+	// Версионирование (?versioning): Enabled | Suspended | Disabled
+	Versioning string `gorm:"size:16;not null;default:'Enabled'"`
+	// Политика бакета (?policy): IAM JSON, проверяется в AuthMiddleware
+	Policy string `gorm:"type:text;not null;default:''"`
+	// Canned ACL бакета (?acl, x-amz-acl при создании)
+	ACL string `gorm:"size:32;not null;default:'private'"`
+	// CORS-правила (?cors): канонический XML CORSConfiguration
+	CORS string `gorm:"type:text;not null;default:''"`
+	// Object Lock (?object-lock): включается насовсем и держит версионирование
+	// Enabled; LockDefault* — срок хранения новых версий по умолчанию
+	ObjectLockEnabled bool   `gorm:"not null;default:false"`
+	LockDefaultMode   string `gorm:"size:16;not null;default:''"` // "" | GOVERNANCE | COMPLIANCE
+	LockDefaultDays   int    `gorm:"not null;default:0"`
+	LockDefaultYears  int    `gorm:"not null;default:0"`
+	// Журнал доступа (?logging): записи копятся и сбрасываются объектами
+	// TargetPrefix<время>-<id> в целевой бакет того же владельца
+	LoggingTargetBucket string `gorm:"size:255;not null;default:''"`
+	LoggingTargetPrefix string `gorm:"size:1024;not null;default:''"`
+	// Уведомления о событиях (?notification): канонический XML NotificationConfiguration
+	Notification string `gorm:"type:text;not null;default:''"`
+	// Фильтр по IP клиента (?settings, IPFilter): CIDR через запятую; пустой Allow — все адреса
+	IPAllow string `gorm:"type:text;not null;default:''"`
+	IPDeny  string `gorm:"type:text;not null;default:''"`
+	// Корзина (?settings, Trash): удалённые версии N дней можно восстановить; 0 — выключена
+	TrashDays int `gorm:"not null;default:0"`
+	// Квота (админский API): предел версий с данными и их байт; 0 — без предела
+	QuotaMaxBytes   int64 `gorm:"not null;default:0"`
+	QuotaMaxObjects int64 `gorm:"not null;default:0"`
+
 	User User `gorm:"foreignKey:OwnerID;references:ID;constraint:OnDelete:SET NULL"`
 }
 
@@ -18,9 +63,46 @@ type Blob struct {
 	StorageNode string    `gorm:"size:64;index"`
 	Path        string    `gorm:"not null"`
 	Size        int64     `gorm:"not null"`
-	Checksum    string    `gorm:"index;size:80"`               // "sha256:...."
-	State       string    `gorm:"size:16;index;default:ready"` // pending|ready
+	Checksum    string    `gorm:"index;size:80"`                    // "sha256:...."
+	State       string    `gorm:"size:16;index;default:'ready'"`    // pending|ready
+	Kind        string    `gorm:"size:16;not null;default:'plain'"` // plain|manifest
+	Encoding    string    `gorm:"size:16;not null;default:''"`      // "" | gzip — как байты лежат в storage
+	StoredSize  int64     `gorm:"not null;default:0"`               // байт в storage, если Encoding != ""
+	RefCount    int64     `gorm:"not null;default:0"`               // ссылки версий (и в корзине), кусков, кэша трансформаций и частей multipart
 	CreatedAt   time.Time `gorm:"autoCreateTime"`
+
+	// результат последней проверки целостности (?verify)
+	VerifiedAt   *time.Time
+	VerifyStatus string `gorm:"size:16"` // ok|corrupt|missing
+}
+
+// PendingDeletion — очередь GC: блоб, у которого не осталось ссылок (RefCount = 0).
+// Ссылка, появившаяся позже, убирает его из очереди. GC сначала приговаривает
+// блоб (CondemnedAt), а удаляет только по истечении окна GC_GRACE_S.
+type PendingDeletion struct {
+	BlobID      string     `gorm:"primaryKey;size:64"`
+	QueuedAt    time.Time  `gorm:"not null;index"`
+	CondemnedAt *time.Time `gorm:"index"`
+}
+
+// BlobChunk — кусок составного (manifest) блоба: диапазон plain-блоба.
+// У manifest-блоба нет собственных байт в storage, чтение идёт по кускам.
+type BlobChunk struct {
+	BlobID      string `gorm:"primaryKey;size:64"` // manifest-блоб
+	Seq         int    `gorm:"primaryKey"`
+	ChunkBlobID string `gorm:"index;size:64;not null"` // plain-блоб с байтами
+	Offset      int64  `gorm:"not null"`
+	Size        int64  `gorm:"not null"`
+}
+
+// DerivedBlob — кэш результата трансформации: исходный блоб + ключ
+// трансформации => блоб с результатом. Живёт, пока жив исходный блоб.
+type DerivedBlob struct {
+	SourceBlobID string    `gorm:"primaryKey;size:64"`
+	Transform    string    `gorm:"primaryKey;size:128"` // "image.resize:w=200,h=0"
+	BlobID       string    `gorm:"index;size:64;not null"`
+	ContentType  string    `gorm:"size:255"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
 }
 
 // Object — логический объект, указывает на Blob
@@ -30,7 +112,7 @@ type Object struct {
 	Key           string    `gorm:"uniqueIndex:ux_bucket_key,priority:2;size:2048;not null"`
 	BlobID        string    `gorm:"index;size:64;not null"`
 	Size          int64     `gorm:"not null"`
-	ETag          string    `gorm:"size:96;not null"` // "\"sha256:...\""
+	ETag          string    `gorm:"size:96;not null"` // "\"<md5>\"", "\"<md5>-N\"" (multipart), у старых — "\"sha256:...\""
 	ContentType   string    `gorm:"size:255"`
 	HeadVersionID string    `gorm:"index;size:64"`
 	CreatedAt     time.Time `gorm:"autoCreateTime"`
@@ -51,14 +133,106 @@ type ObjectVersion struct {
 	ContentType *string   `gorm:"size:255"`
 	IsDelete    bool      `gorm:"not null;default:false"`
 	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	// x-amz-storage-class; на второй уровень хранения байты переносит lifecycle Transition
+	StorageClass string `gorm:"size:32;not null;default:'STANDARD'"`
+	// x-amz-checksum-*: алгоритм (CRC32, CRC32C, CRC64NVME, SHA1, SHA256) и base64-значение
+	ChecksumAlgorithm string `gorm:"size:16;not null;default:''"`
+	ChecksumValue     string `gorm:"size:96;not null;default:''"`
+	// RestoreObject: копия архивной версии на основном узле; пока RestoreOngoing —
+	// байты ещё переносит lifecycle, после RestoreExpiresAt они уезжают обратно
+	RestoreDays      int        `gorm:"not null;default:0"`
+	RestoreOngoing   bool       `gorm:"not null;default:false"`
+	RestoreExpiresAt *time.Time `gorm:""`
+
+	// SSE-KMS encryption context: канонический JSON {"k":"v"}; GET обязан предъявить тот же
+	EncryptionContext *string `gorm:"size:2048"`
+	// x-amz-meta-*: канонический JSON {"имя":"значение"}, имена в нижнем регистре
+	UserMetadata *string `gorm:"size:4096"`
+	// Canned ACL версии (?acl, x-amz-acl)
+	ACL string `gorm:"size:32;not null;default:'private'"`
+	// Object Lock (?retention, ?legal-hold): версию нельзя удалить до RetainUntil
+	// и пока стоит legal hold
+	RetentionMode string `gorm:"size:16;not null;default:''"` // "" | GOVERNANCE | COMPLIANCE
+	RetainUntil   *time.Time
+	LegalHold     bool `gorm:"not null;default:false"`
+}
+
+// ObjectVersionTag — тег версии объекта (?tagging, x-amz-tagging).
+type ObjectVersionTag struct {
+	VersionID string `gorm:"primaryKey;size:64"`
+	Key       string `gorm:"primaryKey;size:128"`
+	Value     string `gorm:"size:256;not null"`
+}
+
+// TrashedVersion — версия с данными, удалённая в бакете с корзиной (?settings,
+// Trash). Строка версии с тегами хранится в Version до ExpiresAt и держит ссылку
+// на блоб; восстановление возвращает версию с прежним VersionID.
+type TrashedVersion struct {
+	VersionID string    `gorm:"primaryKey;size:64" json:"version_id"`
+	BucketID  uint      `gorm:"index:idx_trash_bucket_key,priority:1;not null" json:"-"`
+	Key       string    `gorm:"index:idx_trash_bucket_key,priority:2;size:2048;not null" json:"key"`
+	BlobID    string    `gorm:"index;size:64;not null" json:"-"`
+	Size      int64     `gorm:"not null" json:"size"`
+	ETag      string    `gorm:"size:96;not null;default:''" json:"etag"`
+	Version   string    `gorm:"type:text;not null" json:"-"` // JSON trashedVersionData
+	TrashedAt time.Time `gorm:"not null" json:"trashed_at"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+}
+
+// BucketUsageCounter — счётчики занятого места бакета. Меняются в той же
+// транзакции, что добавляет или убирает версию с данными, поэтому объём
+// бакета и пользователя читается без обхода object_versions.
+type BucketUsageCounter struct {
+	BucketID     uint      `gorm:"primaryKey;autoIncrement:false"`
+	Objects      int64     `gorm:"not null;default:0"` // версии с данными (delete-marker'ы не в счёт)
+	Bytes        int64     `gorm:"not null;default:0"`
+	TrashObjects int64     `gorm:"not null;default:0"` // версии в корзине
+	TrashBytes   int64     `gorm:"not null;default:0"`
+	UpdatedAt    time.Time `gorm:"not null"`
+}
+
+// BucketGrant — грант ACL бакета другому пользователю (Grantee CanonicalUser в ?acl).
+type BucketGrant struct {
+	BucketID   uint   `gorm:"primaryKey"`
+	GranteeID  uint   `gorm:"primaryKey"`
+	Permission string `gorm:"primaryKey;size:16"` // READ | WRITE | READ_ACP | WRITE_ACP | FULL_CONTROL
+}
+
+// BucketTag — тег бакета (?tagging на бакете).
+type BucketTag struct {
+	BucketID uint   `gorm:"primaryKey"`
+	Key      string `gorm:"primaryKey;size:128"`
+	Value    string `gorm:"size:256;not null"`
 }
 
 // User - пользователь для SigV4
 type User struct {
 	ID              uint      `gorm:"primaryKey"`
 	AccessKeyID     string    `gorm:"uniqueIndex;size:64;not null"`
-	SecretAccessKey string    `gorm:"size:128;not null"`
-	Status          string    `gorm:"size:16;default:active"`
+	SecretAccessKey string    `gorm:"size:255;not null"` // устарело: секреты — в AccessKey, здесь пусто
+	Status          string    `gorm:"size:16;default:'active'"`
+	Role            string    `gorm:"size:16;not null;default:'user'"` // user|admin
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+
+	// лимиты на каждый ключ пользователя; NULL — значение из конфигурации, 0 — без лимита
+	RateLimitRPS *float64
+	RateBurst    *int
+	MaxInFlight  *int
+
+	// квота на все бакеты пользователя вместе; 0 — без предела
+	QuotaMaxBytes   int64 `gorm:"not null;default:0"`
+	QuotaMaxObjects int64 `gorm:"not null;default:0"`
+}
+
+// AccessKey — ключ доступа пользователя; у одного пользователя их может быть
+// несколько (ротация). User.AccessKeyID — первый ключ и заодно имя пользователя
+// (Principal в политиках), после ротации он остаётся прежним.
+type AccessKey struct {
+	AccessKeyID     string    `gorm:"primaryKey;size:64"`
+	UserID          uint      `gorm:"index;not null"`
+	SecretAccessKey string    `gorm:"size:255;not null"`                 // "enc:v1:..." при заданном мастер-ключе
+	Status          string    `gorm:"size:16;not null;default:'active'"` // active|disabled
+	Policy          string    `gorm:"type:text;not null;default:''"`     // JSON политики ключа; пусто — все права владельца
 	CreatedAt       time.Time `gorm:"autoCreateTime"`
 }
 
@@ -77,16 +251,161 @@ type LifecycleRule struct {
 	BucketID uint   `gorm:"index;not null"`
 	Prefix   string `gorm:"size:1024;default:''"`
 	Enabled  bool   `gorm:"default:true"`
+	RuleID   string `gorm:"size:255;not null;default:''"` // <ID> из XML
+	// <Rule> как его прислали в PUT ?lifecycle — GET отдаёт его байт в байт
+	RuleXML string `gorm:"type:text;not null;default:''"`
+	// Filter: кроме префикса — теги ("k1=v1&k2=v2", все должны совпасть) и размер версии
+	Tags            string `gorm:"type:text;not null;default:''"`
+	SizeGreaterThan *int64 `gorm:""`
+	SizeLessThan    *int64 `gorm:""`
 	//Actions
-	ExpireCurrentAfterDays        *int `gorm:""` // N дней не обновлялся -> delete-marker
-	ExpireNoncurrentAfterDays     *int `gorm:""` // удалить версии старше X дней
-	NoncurrentNewerVersionsToKeep *int `gorm:""` // оставить K свежих версий (опц.)
-	PurgeDeleteMarkersAfterDays   *int `gorm:""` // чистить delete-markers старше Y дней
-	// на будущее
-	// TransitionToClass string  // "cold", "archive", ...
-	// TransitionAfterDays *int
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	ExpireCurrentAfterDays        *int       `gorm:""` // N дней не обновлялся -> delete-marker
+	ExpireCurrentOnDate           *time.Time `gorm:""` // с этой даты (полночь UTC) HEAD -> delete-marker
+	ExpireNoncurrentAfterDays     *int       `gorm:""` // удалить версии старше X дней
+	NoncurrentNewerVersionsToKeep *int       `gorm:""` // оставить K свежих версий (опц.)
+	PurgeDeleteMarkersAfterDays   *int       `gorm:""` // чистить delete-markers старше Y дней
+	AbortIncompleteAfterDays      *int       `gorm:""` // отменять незавершённые multipart старше N дней
+	// Transition: HEAD старше N дней переносится на второй уровень хранения с этим классом
+	TransitionToClass   string    `gorm:"size:32;not null;default:''"` // STANDARD_IA, GLACIER, ...
+	TransitionAfterDays *int      `gorm:""`
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
 
 	Bucket Bucket `gorm:"foreignKey:BucketID;constraint:OnDelete:CASCADE"`
 }
+
+// WorkerLease — кто из процессов/узлов сейчас крутит фоновый воркер (gc, lifecycle, ...).
+// Нужна, чтобы при перезапуске без простоя старый и новый процесс не работали вдвоём.
+type WorkerLease struct {
+	Name      string    `gorm:"primaryKey;size:64"`
+	Holder    string    `gorm:"size:128;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// BucketChaos — инъекция сбоев в S3-запросы к бакету (тестирование ретраев и
+// проверок целостности у клиентов). Вероятности 0..1, задаётся админом.
+type BucketChaos struct {
+	BucketID     uint      `gorm:"primaryKey" json:"-"`
+	Enabled      bool      `gorm:"not null;default:false" json:"enabled"`
+	DelayMs      int       `gorm:"not null;default:0" json:"delay_ms"`
+	DelayProb    float64   `gorm:"not null;default:0" json:"delay_prob"`
+	ErrorProb    float64   `gorm:"not null;default:0" json:"error_prob"`    // 500 InternalError
+	SlowDownProb float64   `gorm:"not null;default:0" json:"slowdown_prob"` // 503 SlowDown
+	TruncateProb float64   `gorm:"not null;default:0" json:"truncate_prob"` // GET: обрыв тела
+	CorruptProb  float64   `gorm:"not null;default:0" json:"corrupt_prob"`  // GET: испорченный байт
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (BucketChaos) TableName() string { return "bucket_chaos" }
+
+// BucketSimulation — детерминированный профиль задержек/ошибок бакета для
+// воспроизведения инцидентов на стейджинге. Rules — JSON-массив правил (см. server).
+type BucketSimulation struct {
+	BucketID  uint   `gorm:"primaryKey"`
+	Name      string `gorm:"size:128;not null;default:''"`
+	Rules     string `gorm:"type:text;not null"`
+	UpdatedAt time.Time
+}
+
+// ChangeEvent — запись ленты изменений метаданных (CDC). Пишется в той же
+// транзакции, что и само изменение; Seq монотонно растёт в порядке коммитов.
+type ChangeEvent struct {
+	Seq       uint64    `gorm:"primaryKey;autoIncrement" json:"seq"`
+	BucketID  uint      `gorm:"index:idx_change_bucket_seq,priority:1;not null" json:"-"`
+	Bucket    string    `gorm:"->;-:migration" json:"bucket"` // из JOIN с buckets при чтении
+	Type      string    `gorm:"size:32;not null" json:"type"`
+	Key       string    `gorm:"size:2048;not null" json:"key"`
+	VersionID string    `gorm:"size:64" json:"version_id,omitempty"`
+	ETag      string    `gorm:"size:96" json:"etag,omitempty"`
+	Size      *int64    `json:"size,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index" json:"time"`
+}
+
+// NotificationCursor — до какого Seq ленты изменений события уже разобраны
+// диспетчером уведомлений (одна строка).
+type NotificationCursor struct {
+	ID  uint   `gorm:"primaryKey"`
+	Seq uint64 `gorm:"not null"`
+}
+
+// NotificationDelivery — уведомление о событии бакета к отправке по правилу
+// ?notification. Доставленные удаляются; исчерпавшие попытки остаются со
+// Status dead (dead-letter) до ручного перезапуска.
+type NotificationDelivery struct {
+	ID            uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	BucketID      uint      `gorm:"not null;index" json:"-"`
+	Seq           uint64    `gorm:"not null" json:"seq"` // событие ленты изменений
+	RuleID        string    `gorm:"size:255;not null" json:"rule_id"`
+	Sink          string    `gorm:"size:16;not null" json:"sink"` // webhook|nats|kafka
+	Target        string    `gorm:"size:2048;not null" json:"target"`
+	Key           string    `gorm:"size:1024;not null;default:''" json:"key"` // ключ объекта (ключ сообщения Kafka)
+	Event         string    `gorm:"size:64;not null" json:"event"`
+	Payload       string    `gorm:"type:text;not null" json:"payload"`
+	Status        string    `gorm:"size:16;not null;index:idx_notify_due,priority:1" json:"status"`
+	NextAttemptAt time.Time `gorm:"not null;index:idx_notify_due,priority:2" json:"next_attempt_at"`
+	Attempts      int       `gorm:"not null;default:0" json:"attempts"`
+	LastError     string    `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// BatchJob — пакетная операция над объектами из манифеста (CSV bucket,key[,version_id]).
+// Выполняется воркером в фоне; Cursor — сколько строк манифеста уже обработано,
+// по нему задание продолжается после перезапуска.
+type BatchJob struct {
+	ID                string     `gorm:"primaryKey;size:32" json:"id"`
+	Action            string     `gorm:"size:32;not null" json:"action"`
+	Params            string     `gorm:"type:text;not null;default:'{}'" json:"-"`
+	ManifestBucket    string     `gorm:"size:255;not null" json:"manifest_bucket"`
+	ManifestKey       string     `gorm:"size:2048;not null" json:"manifest_key"`
+	ManifestVersionID string     `gorm:"size:64;not null" json:"manifest_version_id"`
+	ReportBucket      string     `gorm:"size:255" json:"report_bucket,omitempty"`
+	ReportPrefix      string     `gorm:"size:1024" json:"report_prefix,omitempty"`
+	ReportKey         string     `gorm:"size:2048" json:"report_key,omitempty"`
+	MaxRetries        int        `gorm:"not null;default:0" json:"max_retries"` // 0 — без повторов; по умолчанию 3 ставит API
+	Status            string     `gorm:"size:16;not null;index" json:"status"`
+	Total             int64      `gorm:"not null;default:0" json:"total"`
+	Cursor            int64      `gorm:"not null;default:0" json:"processed"`
+	Succeeded         int64      `gorm:"not null;default:0" json:"succeeded"`
+	Failed            int64      `gorm:"not null;default:0" json:"failed"`
+	Error             string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedBy         string     `gorm:"size:128" json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	FinishedAt        *time.Time `json:"finished_at,omitempty"`
+}
+
+// BatchJobFailure — задача задания, не выполненная после всех повторов.
+type BatchJobFailure struct {
+	ID        uint   `gorm:"primaryKey" json:"-"`
+	JobID     string `gorm:"size:32;not null;index" json:"-"`
+	Line      int64  `gorm:"not null" json:"line"`
+	Bucket    string `gorm:"size:255;not null" json:"bucket"`
+	Key       string `gorm:"size:2048;not null" json:"key"`
+	VersionID string `gorm:"size:64" json:"version_id,omitempty"`
+	Error     string `gorm:"type:text;not null" json:"error"`
+}
+
+// MultipartUpload — незавершённая составная загрузка (S3 multipart). Параметры
+// будущей версии (тип, контекст шифрования) фиксируются при создании.
+type MultipartUpload struct {
+	UploadID          string    `gorm:"primaryKey;size:64"`
+	BucketID          uint      `gorm:"index:idx_mpu_bucket_key,priority:1;not null"`
+	Key               string    `gorm:"index:idx_mpu_bucket_key,priority:2;size:2048;not null"`
+	ContentType       string    `gorm:"size:255"`
+	EncryptionContext *string   `gorm:"size:2048"`
+	UserMetadata      *string   `gorm:"size:4096"`
+	ACL               string    `gorm:"size:32;not null;default:'private'"`
+	StorageClass      string    `gorm:"size:32;not null;default:'STANDARD'"`
+	InitiatorID       uint      `gorm:"not null"`
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+}
+
+// MultipartPart — загруженная часть; BlobID держит блоб живым до Complete/Abort.
+type MultipartPart struct {
+	UploadID   string    `gorm:"primaryKey;size:64"`
+	PartNumber int       `gorm:"primaryKey"`
+	BlobID     string    `gorm:"index;size:64;not null"`
+	Size       int64     `gorm:"not null"`
+	ETag       string    `gorm:"size:96;not null"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,17 @@
+package db
+
+import (
+	"strings"
+)
+
+// Open выбирает СУБД по DSN: postgres:// или postgresql:// — PostgreSQL,
+// mysql:// — MySQL/MariaDB, всё остальное — путь к файлу SQLite.
+func Open(dsn string) (*DB, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return OpenPostgres(dsn)
+	}
+	if strings.HasPrefix(dsn, "mysql://") {
+		return OpenMySQL(dsn)
+	}
+	return OpenSQLite(dsn)
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"fmt"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// OpenPostgres — метаданные в PostgreSQL вместо файла SQLite: несколько
+// процессов s3mini могут работать с одной базой, блокировки строк — FOR UPDATE.
+func OpenPostgres(dsn string) (*DB, error) {
+	// внешние ключи не создаются: в SQLite они не включены, и код рассчитывает
+	// на это (строка объекта-заготовки с пустым blob_id, удаление бакета по шагам)
+	g, err := gorm.Open(postgres.Open(dsn), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true})
+	if err != nil {
+		return nil, err
+	}
+	db := New(g)
+	if err := db.checkPostgresCollation(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// checkPostgresCollation — листинги S3 сортируют ключи побайтово, а продолжение
+// листинга ищет key > последнего. С локалью вроде en_US.UTF-8 PostgreSQL
+// сравнивает строки иначе, и страницы теряли бы или повторяли ключи.
+func (db *DB) checkPostgresCollation() error {
+	var collate string
+	if err := db.Raw(`SELECT datcollate FROM pg_database WHERE datname = current_database()`).Scan(&collate).Error; err != nil {
+		return err
+	}
+	if collate != "C" && collate != "POSIX" {
+		return fmt.Errorf("postgres: database collation is %q, want C (CREATE DATABASE ... LC_COLLATE 'C' TEMPLATE template0)", collate)
+	}
+	return nil
+}
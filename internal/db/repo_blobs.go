@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type BlobMeta struct {
@@ -13,6 +14,7 @@ type BlobMeta struct {
 	Size        int64
 	Checksum    string
 	StorageNode string
+	Kind        string
 	CreatedAt   time.Time
 }
 
@@ -21,9 +23,16 @@ type GCBlob struct {
 	Size int64
 }
 
+// FindBlobByChecksumTx — готовый блоб с этими байтами (дедуп). Строка берётся под
+// блокировку до конца транзакции: GC не удалит блоб, пока на него не легла ссылка.
+// В SQLite то же даёт блокировка записи, взятая раньше (LockObjectForUpdate).
 func (db *DB) FindBlobByChecksumTx(tx *gorm.DB, checksum string) (*Blob, error) {
 	var b Blob
-	if err := tx.Where("checksum = ? AND state = ?", checksum, "ready").First(&b).Error; err != nil {
+	q := tx.Where("checksum = ? AND state = ?", checksum, "ready")
+	if db.rowLocks() {
+		q = q.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	if err := q.First(&b).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
@@ -34,26 +43,92 @@ func (db *DB) FindBlobByChecksumTx(tx *gorm.DB, checksum string) (*Blob, error)
 
 func (db *DB) CreateBlobTx(tx *gorm.DB, blobID string, path string, size int64, checksum, state string) error {
 	// path можешь передавать "" (мы от него ушли логически)
-	return tx.Create(&Blob{
+	return db.createBlobTx(tx, &Blob{
 		ID: blobID, Path: path, Size: size, Checksum: checksum,
 		StorageNode: "local", // пока одиночный инстанс
-	}).Error
+	})
 }
 
 func (db *DB) ReserveBlobPendingTx(tx *gorm.DB, id, checksum string, size int64, storageNode string) error {
-	return tx.Create(&Blob{
+	return db.createBlobTx(tx, &Blob{
 		ID: id, Checksum: checksum, Size: size, State: "pending", StorageNode: storageNode,
-	}).Error
+	})
+}
+
+// SetBlobEncodingTx — байты блоба лежат в storage сжатыми (Size остаётся логическим).
+func (db *DB) SetBlobEncodingTx(tx *gorm.DB, id, encoding string, storedSize int64) error {
+	return tx.Model(&Blob{}).Where("id = ?", id).
+		Updates(map[string]any{"encoding": encoding, "stored_size": storedSize}).Error
+}
+
+// MoveBlobNodeTx — байты блоба перенесены с узла from на узел to. false — блоб
+// уже удалён или лежит не на from (запись не тронута).
+func (db *DB) MoveBlobNodeTx(tx *gorm.DB, id, from, to string) (bool, error) {
+	q := tx.Model(&Blob{}).Where("id = ?", id)
+	if from == "" || from == "local" {
+		q = q.Where("(storage_node IN ('', 'local') OR storage_node IS NULL)")
+	} else {
+		q = q.Where("storage_node = ?", from)
+	}
+	res := q.Update("storage_node", to)
+	return res.RowsAffected == 1, res.Error
 }
 
 func (db *DB) MarkBlobReadyTx(tx *gorm.DB, id string) error {
 	return tx.Model(&Blob{}).Where("id = ?", id).Update("state", "ready").Error
 }
 
+// DeleteBlobRecordTx удаляет запись блоба; куски manifest-блоба уходят вместе с ним
+// (их plain-блобы осиротеют и будут собраны GC).
 func (db *DB) DeleteBlobRecordTx(tx *gorm.DB, id string) error {
+	if err := db.dropBlobLinksTx(tx, id); err != nil {
+		return err
+	}
 	return tx.Delete(&Blob{ID: id}).Error
 }
 
+// DeleteBlobIfUnreferencedTx удаляет запись готового блоба, если ссылок на него
+// по-прежнему нет и он приговорён не позже condemnedBefore (нулевое время —
+// без окна). Проверка и удаление — один DELETE: ссылка, добавленная
+// параллельно, либо успевает раньше (и блоб остаётся), либо получает ErrBlobGone.
+// true — запись удалена, байты в storage можно удалять.
+func (db *DB) DeleteBlobIfUnreferencedTx(tx *gorm.DB, id string, condemnedBefore time.Time) (bool, error) {
+	q := tx.Where("id = ? AND ref_count = 0 AND state = ?", id, "ready")
+	if !condemnedBefore.IsZero() {
+		q = q.Where("EXISTS (SELECT 1 FROM pending_deletions q WHERE q.blob_id = blobs.id AND q.condemned_at <= ?)", condemnedBefore)
+	}
+	res := q.Delete(&Blob{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false, res.Error
+	}
+	return true, db.dropBlobLinksTx(tx, id)
+}
+
+// dropBlobLinksTx — куски manifest-блоба, кэш его трансформаций и место в очереди
+// GC; блобы, на которые ссылались куски и кэш, теряют по ссылке.
+func (db *DB) dropBlobLinksTx(tx *gorm.DB, id string) error {
+	var chunkIDs, derivedIDs []string
+	if err := tx.Model(&BlobChunk{}).Where("blob_id = ?", id).Pluck("chunk_blob_id", &chunkIDs).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("blob_id = ?", id).Delete(&BlobChunk{}).Error; err != nil {
+		return err
+	}
+	// производные блобы осиротеют и уйдут следующим проходом GC
+	if err := tx.Model(&DerivedBlob{}).Where("source_blob_id = ?", id).Pluck("blob_id", &derivedIDs).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("source_blob_id = ?", id).Delete(&DerivedBlob{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("blob_id = ?", id).Delete(&PendingDeletion{}).Error; err != nil {
+		return err
+	}
+	return db.refBlobsTx(tx, -1, append(chunkIDs, derivedIDs...)...)
+}
+
+// BlobRefCountFromVersionsTx — ссылки версий, посчитанные по таблице (ref_count
+// включает и остальные ссылки).
 func (db *DB) BlobRefCountFromVersionsTx(tx *gorm.DB, blobID string) (int64, error) {
 	var cnt int64
 	if err := tx.Model(&ObjectVersion{}).Where("blob_id = ?", blobID).Count(&cnt).Error; err != nil {
@@ -63,10 +138,12 @@ func (db *DB) BlobRefCountFromVersionsTx(tx *gorm.DB, blobID string) (int64, err
 }
 
 func (db *DB) CreateBlob(id, path string, size int64, checksum, storageNode string) error {
-	return db.Create(&Blob{
-		ID: id, Path: path, Size: size, Checksum: checksum,
-		StorageNode: storageNode, CreatedAt: time.Now().UTC(),
-	}).Error
+	return db.WithTx(func(tx *gorm.DB) error {
+		return db.createBlobTx(tx, &Blob{
+			ID: id, Path: path, Size: size, Checksum: checksum,
+			StorageNode: storageNode, CreatedAt: time.Now().UTC(),
+		})
+	})
 }
 
 func (db *DB) GetBlob(id string) (*BlobMeta, error) {
@@ -79,7 +156,7 @@ func (db *DB) GetBlob(id string) (*BlobMeta, error) {
 	}
 	return &BlobMeta{
 		ID: b.ID, Path: b.Path, Size: b.Size, Checksum: b.Checksum,
-		StorageNode: b.StorageNode, CreatedAt: b.CreatedAt,
+		StorageNode: b.StorageNode, Kind: b.Kind, CreatedAt: b.CreatedAt,
 	}, nil
 }
 
@@ -99,7 +176,7 @@ func (db *DB) FindBlobByChecksum(checksum string) (*BlobMeta, error) {
 
 func (db *DB) ListObjectVersionBlobIDs(bucketID uint, key string) ([]string, error) {
 	var vers []ObjectVersion
-	if err := db.Where("bucket_id = ? AND `key` = ?", bucketID, key).
+	if err := db.Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).
 		Select("blob_id").Find(&vers).Error; err != nil {
 		return nil, err
 	}
@@ -123,16 +200,54 @@ func (db *DB) BlobRefCount(blobID string) (int64, error) {
 }
 
 // GC / pending
-// BlobsForGCWithSize возвращает до limit блобов, на которые нет ссылок версий (is_delete=false)
-// и которые уже в состоянии 'ready'.
-func (db *DB) BlobsForGCWithSize(limit int) ([]GCBlob, error) {
+// CondemnBlobs — первая фаза GC: готовые блобы из очереди получают время
+// приговора at. Ссылка, появившаяся до удаления, снимает приговор вместе с очередью.
+func (db *DB) CondemnBlobs(at time.Time) (int64, error) {
+	res := db.DB.Model(&PendingDeletion{}).
+		Where("condemned_at IS NULL AND blob_id IN (?)",
+			db.DB.Model(&Blob{}).Select("id").Where("ref_count = 0 AND state = ?", "ready")).
+		Update("condemned_at", at)
+	return res.RowsAffected, res.Error
+}
+
+// BlobsForGCWithSize возвращает до limit блобов, приговорённых не позже
+// condemnedBefore и так и оставшихся без ссылок, давно осиротевшие первыми.
+// Удалять — DeleteBlobIfUnreferencedTx с тем же condemnedBefore.
+func (db *DB) BlobsForGCWithSize(limit int, condemnedBefore time.Time) ([]GCBlob, error) {
 	var rows []GCBlob
 	err := db.DB.Raw(`
 		SELECT b.id, b.size
-		FROM blobs b
-		LEFT JOIN object_versions v ON v.blob_id = b.id AND v.is_delete = FALSE
-		WHERE v.blob_id IS NULL AND b.state='ready'
+		FROM pending_deletions q
+		JOIN blobs b ON b.id = q.blob_id
+		WHERE b.ref_count = 0 AND b.state = ? AND q.condemned_at <= ?
+		ORDER BY q.queued_at
 		LIMIT ?
-	`, limit).Scan(&rows).Error
+	`, "ready", condemnedBefore, limit).Scan(&rows).Error
 	return rows, err
 }
+
+// StalePendingBlobs — до limit блобов без ссылок, которые застряли в pending
+// с момента до before: их загрузку оборвал сбой процесса. GC их не берёт.
+func (db *DB) StalePendingBlobs(before time.Time, limit int) ([]GCBlob, error) {
+	var rows []GCBlob
+	err := db.DB.Model(&Blob{}).Select("id, size").
+		Where("state = ? AND ref_count = 0 AND created_at < ?", "pending", before).
+		Order("created_at").Limit(limit).Scan(&rows).Error
+	return rows, err
+}
+
+// DeleteStalePendingBlobTx удаляет запись такого блоба. false — он успел стать
+// готовым или получить ссылку, трогать нельзя.
+func (db *DB) DeleteStalePendingBlobTx(tx *gorm.DB, id string, before time.Time) (bool, error) {
+	res := tx.Where("id = ? AND state = ? AND ref_count = 0 AND created_at < ?", id, "pending", before).Delete(&Blob{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false, res.Error
+	}
+	return true, db.dropBlobLinksTx(tx, id)
+}
+
+// MarkBlobVerified — итог перепроверки байт блоба (ok|corrupt|missing).
+func (db *DB) MarkBlobVerified(id, status string, at time.Time) error {
+	return db.Model(&Blob{}).Where("id = ?", id).
+		Updates(map[string]any{"verified_at": at, "verify_status": status}).Error
+}
@@ -6,6 +6,56 @@ import (
 	"gorm.io/gorm"
 )
 
+// Canned ACL: бакету public-read открывает листинг, public-read-write — ещё и
+// запись/удаление объектов; версии объекта public-read* открывает чтение.
+const (
+	ACLPrivate         = "private"
+	ACLPublicRead      = "public-read"
+	ACLPublicReadWrite = "public-read-write"
+)
+
+// Права грантов бакета (BucketGrant.Permission).
+const (
+	PermRead        = "READ"
+	PermWrite       = "WRITE"
+	PermReadACP     = "READ_ACP"
+	PermWriteACP    = "WRITE_ACP"
+	PermFullControl = "FULL_CONTROL"
+)
+
+func (db *DB) ListBucketGrants(bucketID uint) ([]BucketGrant, error) {
+	var out []BucketGrant
+	err := db.DB.Where("bucket_id = ?", bucketID).Order("grantee_id, permission").Find(&out).Error
+	return out, err
+}
+
+// GranteePermissions — права пользователя по грантам бакета.
+func (db *DB) GranteePermissions(bucketID, granteeID uint) ([]string, error) {
+	var out []string
+	err := db.DB.Model(&BucketGrant{}).Where("bucket_id = ? AND grantee_id = ?", bucketID, granteeID).
+		Pluck("permission", &out).Error
+	return out, err
+}
+
+// SetBucketACL — canned ACL и гранты бакета одной транзакцией; гранты заменяются целиком.
+func (db *DB) SetBucketACL(bucketID uint, acl string, grants []BucketGrant) error {
+	return db.WithTx(func(tx *gorm.DB) error {
+		if err := tx.Model(&Bucket{}).Where("id = ?", bucketID).Update("acl", acl).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("bucket_id = ?", bucketID).Delete(&BucketGrant{}).Error; err != nil {
+			return err
+		}
+		if len(grants) == 0 {
+			return nil
+		}
+		for i := range grants {
+			grants[i].BucketID = bucketID
+		}
+		return tx.Create(&grants).Error
+	})
+}
+
 // EnsureBucket — найти или создать
 func (db *DB) EnsureBucket(name string, ownerID uint) (uint, error) {
 	b := Bucket{Name: name}
@@ -31,6 +81,54 @@ func (db *DB) BucketIDByName(name string, ownerID uint) (uint, error) {
 	return b.ID, nil
 }
 
+func (db *DB) FindBucket(name string, ownerID uint) (*Bucket, error) {
+	var b Bucket
+	if err := db.Where("name = ? AND owner_id = ?", name, ownerID).Take(&b).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+// FindBucketByName — без проверки владельца (админские ручки).
+func (db *DB) FindBucketByName(name string) (*Bucket, error) {
+	var b Bucket
+	if err := db.Take(&b, "name = ?", name).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (db *DB) FindBucketByID(id uint) (*Bucket, error) {
+	var b Bucket
+	if err := db.Take(&b, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &b, nil
+}
+
+// UpdateBucketSettings — частичное обновление колонок настроек бакета.
+func (db *DB) UpdateBucketSettings(bucketID uint, fields map[string]any) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return db.DB.Model(&Bucket{}).Where("id = ?", bucketID).Updates(fields).Error
+}
+
+func (db *DB) ListBucketsWithCompaction() ([]Bucket, error) {
+	var out []Bucket
+	err := db.DB.Where("compact_identical_versions = ?", true).Find(&out).Error
+	return out, err
+}
+
 func (db *DB) ListBuckets(ownerID uint) ([]Bucket, error) {
 	var out []Bucket
 	q := db.DB.Model(&Bucket{})
@@ -60,6 +158,33 @@ func (db *DB) DeleteBucketIfEmpty(tx *gorm.DB, bucketID uint) error {
 	if n > 0 {
 		return ErrBucketNotEmpty
 	}
+	// ID бакетов переиспользуются — не оставляем чужому бакету настройки сбоев
+	if err := tx.Where("bucket_id = ?", bucketID).Delete(&BucketChaos{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("bucket_id = ?", bucketID).Delete(&BucketSimulation{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("bucket_id = ?", bucketID).Delete(&BucketTag{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("bucket_id = ?", bucketID).Delete(&BucketGrant{}).Error; err != nil {
+		return err
+	}
+	// незавершённые multipart-загрузки уходят вместе с бакетом, блобы частей — в GC
+	if err := db.deletePartsTx(tx, "upload_id IN (?)", tx.Model(&MultipartUpload{}).Select("upload_id").Where("bucket_id = ?", bucketID)); err != nil {
+		return err
+	}
+	if err := tx.Where("bucket_id = ?", bucketID).Delete(&MultipartUpload{}).Error; err != nil {
+		return err
+	}
+	// корзина пропадает вместе с бакетом, её блобы — в GC
+	if _, err := db.dropTrashTx(tx, "bucket_id = ?", bucketID); err != nil {
+		return err
+	}
+	if err := tx.Where("bucket_id = ?", bucketID).Delete(&BucketUsageCounter{}).Error; err != nil {
+		return err
+	}
 	// Удаляем бакет
 	if err := tx.Delete(&Bucket{}, bucketID).Error; err != nil {
 		return err
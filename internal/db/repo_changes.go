@@ -0,0 +1,66 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Типы событий ленты изменений.
+const (
+	ChangeObjectCreated       = "object_created"        // новая версия с данными
+	ChangeDeleteMarkerCreated = "delete_marker_created" // мягкое удаление
+	ChangeVersionDeleted      = "version_deleted"       // версия удалена насовсем
+	ChangeHeadChanged         = "head_changed"          // HEAD ключа указывает на VersionID
+	ChangeTagsChanged         = "tags_changed"          // теги версии заменены или удалены
+)
+
+// changeVisibilityLag — на PostgreSQL и MySQL Seq выдаётся при INSERT, а строка
+// видна только после COMMIT: транзакция с меньшим Seq может закоммититься позже
+// соседней, и читатель, уже сдвинувший курсор after дальше, её потерял бы. Поэтому
+// там читаются только события старше лага — транзакции изменений короче него.
+// SQLite пишет одной транзакцией за раз, и порядок Seq там совпадает с порядком
+// коммитов.
+const changeVisibilityLag = 5 * time.Second
+
+// visibleChanges — ограничение выборки change_events событиями, которые уже не
+// может обогнать незакоммиченная транзакция (см. changeVisibilityLag).
+func (db *DB) visibleChanges(q *gorm.DB) *gorm.DB {
+	if !db.rowLocks() {
+		return q
+	}
+	return q.Where("change_events.created_at < ?", time.Now().Add(-changeVisibilityLag))
+}
+
+func recordChangeTx(tx *gorm.DB, ev *ChangeEvent) error {
+	return tx.Omit("Bucket").Create(ev).Error
+}
+
+// ListChanges — события с Seq > after по возрастанию; bucketID == 0 — все бакеты.
+// На PostgreSQL и MySQL свежие события появляются с задержкой changeVisibilityLag.
+func (db *DB) ListChanges(bucketID uint, after uint64, limit int) ([]ChangeEvent, error) {
+	q := db.visibleChanges(db.DB.Table("change_events")).
+		Select("change_events.*, buckets.name AS bucket").
+		Joins("LEFT JOIN buckets ON buckets.id = change_events.bucket_id").
+		Where("change_events.seq > ?", after)
+	if bucketID != 0 {
+		q = q.Where("change_events.bucket_id = ?", bucketID)
+	}
+	var out []ChangeEvent
+	err := q.Order("change_events.seq").Limit(limit).Scan(&out).Error
+	return out, err
+}
+
+// ChangeSeqBounds — самый старый и самый новый видимый Seq в ленте (0, 0 — лента пуста).
+func (db *DB) ChangeSeqBounds() (oldest, latest uint64, err error) {
+	var row struct{ Oldest, Latest uint64 }
+	err = db.visibleChanges(db.DB.Table("change_events")).
+		Select("COALESCE(MIN(seq), 0) AS oldest, COALESCE(MAX(seq), 0) AS latest").
+		Scan(&row).Error
+	return row.Oldest, row.Latest, err
+}
+
+func (db *DB) PruneChanges(olderThan time.Time) (int64, error) {
+	res := db.DB.Where("created_at < ?", olderThan).Delete(&ChangeEvent{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ChaosForBucket — включённая конфигурация сбоев бакета по имени (nil, если нет).
+func (db *DB) ChaosForBucket(name string) (*BucketChaos, error) {
+	var c BucketChaos
+	err := db.DB.Joins("JOIN buckets ON buckets.id = bucket_chaos.bucket_id").
+		Where("buckets.name = ? AND bucket_chaos.enabled = ?", name, true).
+		Take(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (db *DB) GetBucketChaos(bucketID uint) (*BucketChaos, error) {
+	var c BucketChaos
+	err := db.DB.Where("bucket_id = ?", bucketID).Take(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (db *DB) SaveBucketChaos(c *BucketChaos) error {
+	return db.DB.Save(c).Error
+}
+
+func (db *DB) DeleteBucketChaos(bucketID uint) error {
+	return db.DB.Where("bucket_id = ?", bucketID).Delete(&BucketChaos{}).Error
+}
+
+// SimulationForBucket — профиль симуляции бакета по имени (nil, если нет).
+func (db *DB) SimulationForBucket(name string) (*BucketSimulation, error) {
+	var p BucketSimulation
+	err := db.DB.Joins("JOIN buckets ON buckets.id = bucket_simulations.bucket_id").
+		Where("buckets.name = ?", name).
+		Take(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (db *DB) SaveBucketSimulation(p *BucketSimulation) error {
+	return db.DB.Save(p).Error
+}
+
+func (db *DB) DeleteBucketSimulation(bucketID uint) error {
+	return db.DB.Where("bucket_id = ?", bucketID).Delete(&BucketSimulation{}).Error
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BlobKindPlain    = "plain"
+	BlobKindManifest = "manifest"
+)
+
+// ChunkRange — непрерывный диапазон байт plain-блоба.
+type ChunkRange struct {
+	BlobID   string
+	Offset   int64
+	Size     int64
+	Encoding string // "" | gzip — нужно читателю, в состав manifest не входит
+	Node     string // узел хранения блоба (Blob.StorageNode) — тоже только для читателя
+}
+
+// ResolveRangeTx раскладывает диапазон [off, off+n) блоба на диапазоны plain-блобов;
+// manifest-блобы разворачиваются. n < 0 — до конца блоба.
+func (db *DB) ResolveRangeTx(tx *gorm.DB, blobID string, off, n int64) ([]ChunkRange, error) {
+	var b Blob
+	if err := tx.Take(&b, "id = ?", blobID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if n < 0 || off+n > b.Size {
+		n = b.Size - off
+	}
+	if off < 0 || n < 0 {
+		return nil, fmt.Errorf("range %d+%d out of blob %s size %d", off, n, blobID, b.Size)
+	}
+	if b.Kind != BlobKindManifest {
+		return []ChunkRange{{BlobID: b.ID, Offset: off, Size: n, Encoding: b.Encoding, Node: b.StorageNode}}, nil
+	}
+
+	var chunks []struct {
+		BlobChunk
+		Encoding    string
+		StorageNode string
+	}
+	if err := tx.Table("blob_chunks c").
+		Select("c.*, b.encoding, b.storage_node").
+		Joins("JOIN blobs b ON b.id = c.chunk_blob_id").
+		Where("c.blob_id = ?", blobID).Order("c.seq ASC").
+		Scan(&chunks).Error; err != nil {
+		return nil, err
+	}
+	out := make([]ChunkRange, 0, len(chunks))
+	var pos int64 // позиция начала куска в manifest-блобе
+	end := off + n
+	for _, c := range chunks {
+		cStart, cEnd := pos, pos+c.Size
+		pos = cEnd
+		if cEnd <= off || cStart >= end {
+			continue
+		}
+		from, to := max64(cStart, off), min64(cEnd, end)
+		out = append(out, ChunkRange{
+			BlobID: c.ChunkBlobID, Offset: c.Offset + (from - cStart), Size: to - from, Encoding: c.Encoding, Node: c.StorageNode,
+		})
+	}
+	return out, nil
+}
+
+// CreateManifestBlobTx создаёт manifest-блоб из кусков (или возвращает уже
+// существующий с тем же составом — дедуп по checksum "manifest:...").
+func (db *DB) CreateManifestBlobTx(tx *gorm.DB, chunks []ChunkRange) (blobID string, size int64, checksum string, err error) {
+	h := sha256.New()
+	for _, c := range chunks {
+		fmt.Fprintf(h, "%s:%d:%d\n", c.BlobID, c.Offset, c.Size)
+		size += c.Size
+	}
+	checksum = "manifest:" + hex.EncodeToString(h.Sum(nil))
+
+	if exist, err := db.FindBlobByChecksumTx(tx, checksum); err == nil {
+		return exist.ID, exist.Size, checksum, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return "", 0, "", err
+	}
+
+	blobID = db.GenBlobID()
+	if err := db.createBlobTx(tx, &Blob{
+		ID: blobID, Checksum: checksum, Size: size, State: "ready",
+		Kind: BlobKindManifest, StorageNode: "local",
+	}); err != nil {
+		return "", 0, "", err
+	}
+	rows := make([]BlobChunk, 0, len(chunks))
+	for i, c := range chunks {
+		rows = append(rows, BlobChunk{BlobID: blobID, Seq: i, ChunkBlobID: c.BlobID, Offset: c.Offset, Size: c.Size})
+		// каждый кусок — ссылка, даже если plain-блоб в составе не первый раз
+		if err := db.refBlobTx(tx, c.BlobID, 1); err != nil {
+			return "", 0, "", err
+		}
+	}
+	if len(rows) > 0 {
+		if err := tx.CreateInBatches(rows, 200).Error; err != nil {
+			return "", 0, "", err
+		}
+	}
+	return blobID, size, checksum, nil
+}
+
+// BlobRefCountTx — сколько ссылок держат блоб живым: версии, куски manifest-блобов,
+// кэш трансформаций и части незавершённых multipart-загрузок (blobs.ref_count).
+// Удалённого блоба — 0.
+func (db *DB) BlobRefCountTx(tx *gorm.DB, blobID string) (int64, error) {
+	var n int64
+	err := tx.Model(&Blob{}).Where("id = ?", blobID).Select("ref_count").Scan(&n).Error
+	return n, err
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
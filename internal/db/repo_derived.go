@@ -0,0 +1,27 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// FindDerivedBlob — закэшированный результат трансформации исходного блоба.
+func (db *DB) FindDerivedBlob(sourceBlobID, transform string) (*DerivedBlob, error) {
+	var d DerivedBlob
+	err := db.Take(&d, "source_blob_id = ? AND transform = ?", sourceBlobID, transform).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	return &d, err
+}
+
+// SaveDerivedBlobTx — запись кэша; при гонке двух GET побеждает первый,
+// блоб проигравшего останется без ссылок и уйдёт в GC.
+func (db *DB) SaveDerivedBlobTx(tx *gorm.DB, d *DerivedBlob) error {
+	res := db.insertIgnore(tx).Create(d)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+	return db.refBlobTx(tx, d.BlobID, 1)
+}
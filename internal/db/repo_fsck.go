@@ -0,0 +1,80 @@
+package db
+
+import "strconv"
+
+// Запросы s3mini fsck: сверка таблицы blobs с файлами хранилища.
+
+// BlobsByIDs — записи блобов с данными id; отсутствующих в карте нет.
+func (db *DB) BlobsByIDs(ids []string) (map[string]Blob, error) {
+	out := make(map[string]Blob, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	var rows []Blob
+	if err := db.DB.Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, b := range rows {
+		out[b.ID] = b
+	}
+	return out, nil
+}
+
+// ListBlobsAfter — до limit блобов с id строго больше afterID, по id.
+func (db *DB) ListBlobsAfter(afterID string, limit int) ([]Blob, error) {
+	var out []Blob
+	err := db.DB.Where("id > ?", afterID).Order("id").Limit(limit).Find(&out).Error
+	return out, err
+}
+
+// BlobUser — версия, которая читает блоб напрямую или куском manifest-блоба.
+type BlobUser struct {
+	Bucket    string
+	Key       string `gorm:"column:obj_key"`
+	VersionID string
+}
+
+// BlobUsers — до limit версий (в том числе в корзине), которые читают блоб.
+// LIMIT после UNION подставляется числом: плейсхолдер там понимают не все СУБД.
+func (db *DB) BlobUsers(blobID string, limit int) ([]BlobUser, error) {
+	var out []BlobUser
+	k := db.quote("key")
+	err := db.DB.Raw(`
+		SELECT b.name AS bucket, v.`+k+` AS obj_key, v.version_id
+		FROM object_versions v JOIN buckets b ON b.id = v.bucket_id
+		WHERE v.blob_id = ? OR v.blob_id IN (SELECT blob_id FROM blob_chunks WHERE chunk_blob_id = ?)
+		UNION ALL
+		SELECT b.name, t.`+k+`, t.version_id
+		FROM trashed_versions t JOIN buckets b ON b.id = t.bucket_id
+		WHERE t.blob_id = ? OR t.blob_id IN (SELECT blob_id FROM blob_chunks WHERE chunk_blob_id = ?)
+		LIMIT `+strconv.Itoa(limit),
+		blobID, blobID, blobID, blobID).Scan(&out).Error
+	return out, err
+}
+
+// DanglingRef — ссылка на блоб, записи которого нет в blobs.
+type DanglingRef struct {
+	Table  string `gorm:"column:ref_table"` // object_versions | trashed_versions | blob_chunks
+	Owner  string // version_id или id manifest-блоба
+	BlobID string
+}
+
+// DanglingBlobRefs — до limit ссылок версий, корзины и кусков manifest-блобов
+// на несуществующие блобы.
+func (db *DB) DanglingBlobRefs(limit int) ([]DanglingRef, error) {
+	var out []DanglingRef
+	err := db.DB.Raw(`
+		SELECT 'object_versions' AS ref_table, v.version_id AS owner, v.blob_id
+		FROM object_versions v LEFT JOIN blobs b ON b.id = v.blob_id
+		WHERE v.blob_id IS NOT NULL AND b.id IS NULL
+		UNION ALL
+		SELECT 'trashed_versions', t.version_id, t.blob_id
+		FROM trashed_versions t LEFT JOIN blobs b ON b.id = t.blob_id
+		WHERE b.id IS NULL
+		UNION ALL
+		SELECT 'blob_chunks', c.blob_id, c.chunk_blob_id
+		FROM blob_chunks c LEFT JOIN blobs b ON b.id = c.chunk_blob_id
+		WHERE b.id IS NULL
+		LIMIT ` + strconv.Itoa(limit)).Scan(&out).Error
+	return out, err
+}
@@ -18,7 +18,7 @@ func (db *DB) SaveIdempotencyTx(tx *gorm.DB, bucketID uint, key, idemKey, versio
 
 func (db *DB) GetIdempotencyTx(tx *gorm.DB, bucketID uint, key, idemKey string) (string, string, error) {
 	var item IdempotencyKey
-	err := tx.Where("bucket_id = ? AND key = ? AND idem_key = ?", bucketID, key, idemKey).First(&item).Error
+	err := tx.Where("bucket_id = ? AND "+db.quote("key")+" = ? AND idem_key = ?", bucketID, key, idemKey).First(&item).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return "", "", ErrNotFound
 	}
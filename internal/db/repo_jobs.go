@@ -0,0 +1,99 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Статусы пакетного задания.
+const (
+	JobStatusNew       = "new"
+	JobStatusRunning   = "running"
+	JobStatusComplete  = "complete"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+func (db *DB) CreateBatchJob(j *BatchJob) error {
+	return db.DB.Create(j).Error
+}
+
+func (db *DB) GetBatchJob(id string) (*BatchJob, error) {
+	var j BatchJob
+	err := db.DB.Where("id = ?", id).Take(&j).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ListBatchJobs — последние задания (status == "" — любые).
+func (db *DB) ListBatchJobs(status string, limit int) ([]BatchJob, error) {
+	q := db.DB.Order("created_at DESC").Limit(limit)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var out []BatchJob
+	err := q.Find(&out).Error
+	return out, err
+}
+
+// NextRunnableBatchJob — самое старое незавершённое задание (nil, если нет).
+func (db *DB) NextRunnableBatchJob() (*BatchJob, error) {
+	var j BatchJob
+	err := db.DB.Where("status IN ?", []string{JobStatusNew, JobStatusRunning}).
+		Order("created_at").Take(&j).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+func (db *DB) UpdateBatchJob(id string, fields map[string]any) error {
+	return db.DB.Model(&BatchJob{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// CheckpointBatchJob — прогресс и новые отказы одной транзакцией, чтобы после
+// перезапуска счётчики совпадали с записанными отказами.
+func (db *DB) CheckpointBatchJob(id string, fields map[string]any, failures []BatchJobFailure) error {
+	return db.WithTx(func(tx *gorm.DB) error {
+		if len(failures) > 0 {
+			if err := tx.CreateInBatches(failures, 100).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&BatchJob{}).Where("id = ?", id).Updates(fields).Error
+	})
+}
+
+// FinishBatchJob ставит итоговый статус, если задание ещё не отменено.
+func (db *DB) FinishBatchJob(id string, fields map[string]any) error {
+	return db.DB.Model(&BatchJob{}).
+		Where("id = ? AND status IN ?", id, []string{JobStatusNew, JobStatusRunning}).
+		Updates(fields).Error
+}
+
+// CancelBatchJob переводит незавершённое задание в cancelled; false — уже завершено.
+func (db *DB) CancelBatchJob(id string) (bool, error) {
+	now := time.Now().UTC()
+	res := db.DB.Model(&BatchJob{}).
+		Where("id = ? AND status IN ?", id, []string{JobStatusNew, JobStatusRunning}).
+		Updates(map[string]any{"status": JobStatusCancelled, "finished_at": &now})
+	return res.RowsAffected > 0, res.Error
+}
+
+// ListBatchJobFailures — отказы задания по порядку строк манифеста, после строки afterLine.
+func (db *DB) ListBatchJobFailures(jobID string, afterLine int64, limit int) ([]BatchJobFailure, error) {
+	var out []BatchJobFailure
+	err := db.DB.Where("job_id = ? AND line > ?", jobID, afterLine).
+		Order("line").Limit(limit).Find(&out).Error
+	return out, err
+}
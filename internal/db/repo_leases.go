@@ -0,0 +1,32 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AcquireLease берёт или продлевает lease воркера: удаётся, если lease свободен,
+// истёк или уже наш.
+func (db *DB) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	var ok bool
+	err := db.WithTxImmediate(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&WorkerLease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&WorkerLease{}).
+			Where("name = ? AND (holder = ? OR expires_at < ?)", name, holder, now).
+			Updates(map[string]any{"holder": holder, "expires_at": now.Add(ttl)})
+		ok = res.RowsAffected > 0
+		return res.Error
+	})
+	return ok, err
+}
+
+// ReleaseLeases отпускает все lease'ы держателя (при остановке процесса).
+func (db *DB) ReleaseLeases(holder string) error {
+	return db.Where("holder = ?", holder).Delete(&WorkerLease{}).Error
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (db *DB) CreateMultipartUpload(u *MultipartUpload) error {
+	return db.DB.Create(u).Error
+}
+
+func (db *DB) GetMultipartUploadTx(tx *gorm.DB, uploadID string) (*MultipartUpload, error) {
+	var u MultipartUpload
+	err := tx.Where("upload_id = ?", uploadID).Take(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// PutMultipartPartTx сохраняет часть; повторная загрузка того же номера её заменяет
+// (прежний блоб осиротеет и уйдёт в GC).
+func (db *DB) PutMultipartPartTx(tx *gorm.DB, p *MultipartPart) error {
+	var prev []string
+	if err := tx.Model(&MultipartPart{}).Where("upload_id = ? AND part_number = ?", p.UploadID, p.PartNumber).
+		Pluck("blob_id", &prev).Error; err != nil {
+		return err
+	}
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "upload_id"}, {Name: "part_number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"blob_id", "size", "e_tag", "updated_at"}),
+	}).Create(p).Error; err != nil {
+		return err
+	}
+	if err := db.refBlobTx(tx, p.BlobID, 1); err != nil {
+		return err
+	}
+	return db.refBlobsTx(tx, -1, prev...)
+}
+
+func (db *DB) ListMultipartPartsTx(tx *gorm.DB, uploadID string) ([]MultipartPart, error) {
+	var out []MultipartPart
+	err := tx.Where("upload_id = ?", uploadID).Order("part_number").Find(&out).Error
+	return out, err
+}
+
+// DeleteMultipartUploadTx — загрузка и её части (Complete/Abort).
+func (db *DB) DeleteMultipartUploadTx(tx *gorm.DB, uploadID string) error {
+	if err := db.deletePartsTx(tx, "upload_id = ?", uploadID); err != nil {
+		return err
+	}
+	return tx.Where("upload_id = ?", uploadID).Delete(&MultipartUpload{}).Error
+}
+
+// deletePartsTx удаляет части по условию и снимает их ссылки на блобы.
+func (db *DB) deletePartsTx(tx *gorm.DB, query string, args ...any) error {
+	var blobIDs []string
+	if err := tx.Model(&MultipartPart{}).Where(query, args...).Pluck("blob_id", &blobIDs).Error; err != nil {
+		return err
+	}
+	if err := tx.Where(query, args...).Delete(&MultipartPart{}).Error; err != nil {
+		return err
+	}
+	return db.refBlobsTx(tx, -1, blobIDs...)
+}
+
+// ListMultipartPartsPage — части с номером больше afterPart (ListParts).
+func (db *DB) ListMultipartPartsPage(uploadID string, afterPart, limit int) ([]MultipartPart, error) {
+	var out []MultipartPart
+	err := db.DB.Where("upload_id = ? AND part_number > ?", uploadID, afterPart).
+		Order("part_number").Limit(limit).Find(&out).Error
+	return out, err
+}
+
+// ListMultipartUploads — загрузки бакета по (key, upload_id) строго после
+// (keyMarker, uploadIDMarker); пустой uploadIDMarker — после всех загрузок keyMarker.
+func (db *DB) ListMultipartUploads(bucketID uint, prefix, keyMarker, uploadIDMarker string, limit int) ([]MultipartUpload, error) {
+	k := db.quote("key")
+	q := db.DB.Where("bucket_id = ?", bucketID)
+	if prefix != "" {
+		q = q.Where(db.likePrefix(k, prefix))
+	}
+	switch {
+	case keyMarker != "" && uploadIDMarker != "":
+		q = q.Where(k+" > ? OR ("+k+" = ? AND upload_id > ?)", keyMarker, keyMarker, uploadIDMarker)
+	case keyMarker != "":
+		q = q.Where(k+" > ?", keyMarker)
+	}
+	var out []MultipartUpload
+	err := q.Order(k).Order("upload_id").Limit(limit).Find(&out).Error
+	return out, err
+}
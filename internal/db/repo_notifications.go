@@ -0,0 +1,71 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Статусы доставки уведомления.
+const (
+	NotifyPending = "pending"
+	NotifyDead    = "dead"
+)
+
+// NotificationCursorSeq — позиция диспетчера в ленте; ok == false — ещё не начинал.
+func (db *DB) NotificationCursorSeq() (seq uint64, ok bool, err error) {
+	var c NotificationCursor
+	err = db.DB.Where("id = 1").Take(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return c.Seq, true, nil
+}
+
+// EnqueueNotifications — доставки по событиям ленты и сдвиг курсора одной транзакцией:
+// после сбоя события не теряются и не дублируются.
+func (db *DB) EnqueueNotifications(ds []NotificationDelivery, cursor uint64) error {
+	return db.WithTx(func(tx *gorm.DB) error {
+		if len(ds) > 0 {
+			if err := tx.CreateInBatches(ds, 100).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(&NotificationCursor{ID: 1, Seq: cursor}).Error
+	})
+}
+
+// DueNotifications — ожидающие доставки, чей срок попытки наступил.
+func (db *DB) DueNotifications(now time.Time, limit int) ([]NotificationDelivery, error) {
+	var out []NotificationDelivery
+	err := db.DB.Where("status = ? AND next_attempt_at <= ?", NotifyPending, now).
+		Order("id").Limit(limit).Find(&out).Error
+	return out, err
+}
+
+func (db *DB) DeleteNotification(id uint64) error {
+	return db.DB.Delete(&NotificationDelivery{}, "id = ?", id).Error
+}
+
+func (db *DB) UpdateNotification(id uint64, fields map[string]any) error {
+	return db.DB.Model(&NotificationDelivery{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// ListDeadNotifications — dead-letter по возрастанию ID после after.
+func (db *DB) ListDeadNotifications(after uint64, limit int) ([]NotificationDelivery, error) {
+	var out []NotificationDelivery
+	err := db.DB.Where("status = ? AND id > ?", NotifyDead, after).
+		Order("id").Limit(limit).Find(&out).Error
+	return out, err
+}
+
+// RequeueDeadNotifications — вернуть dead-letter в очередь с нуля попыток.
+func (db *DB) RequeueDeadNotifications(now time.Time) (int64, error) {
+	res := db.DB.Model(&NotificationDelivery{}).Where("status = ?", NotifyDead).
+		Updates(map[string]any{"status": NotifyPending, "attempts": 0, "next_attempt_at": now})
+	return res.RowsAffected, res.Error
+}
@@ -2,9 +2,7 @@ package db
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
-	"sort"
 	"strings"
 	"time"
 
@@ -18,16 +16,26 @@ type ListV2Params struct {
 	Delimiter    string
 	MaxKeys      int
 	StartAfter   string
-	ContTokenRaw string // continuation-token (base64)
+	ContTokenRaw string        // continuation-token как пришёл (эхо в ответе)
+	Cursor       *ListV2Cursor // разобранный токен; nil — с начала или со StartAfter
 	FetchOwner   bool
 	EncodingType string // "url" or ""
 }
 
+// ListV2Cursor — где закончилась прошлая страница: последний отданный ключ или
+// CommonPrefix. После CommonPrefix (Rollup) пропускаются все ключи под ним —
+// иначе следующая страница отдала бы тот же префикс ещё раз.
+type ListV2Cursor struct {
+	After  string
+	Rollup bool
+}
+
 type ListV2Item struct {
 	Key          string
 	ETag         *string
 	Size         int64
 	LastModified time.Time
+	StorageClass string
 	OwnerID      *string
 	OwnerName    *string
 }
@@ -36,7 +44,8 @@ type ListV2Result struct {
 	Objects        []ListV2Item
 	CommonPrefixes []string
 	IsTruncated    bool
-	NextToken      string
+	Next           *ListV2Cursor // при IsTruncated — позиция для следующей страницы
+	NextToken      string        // заполняет сервер из Next
 	KeyCount       int
 }
 
@@ -52,7 +61,13 @@ type ObjectMeta struct {
 	ContentType string
 }
 
+// LockObjectForUpdate держит строку ключа до конца транзакции tx (строка
+// создаётся, если её не было). PostgreSQL — SELECT ... FOR UPDATE; SQLite
+// строк не блокирует, там пустой UPDATE берёт блокировку записи на всю БД.
 func (db *DB) LockObjectForUpdate(tx *gorm.DB, bucketID uint, key string) error {
+	if db.rowLocks() {
+		return db.lockObjectRow(tx, bucketID, key)
+	}
 	res := tx.Exec(`UPDATE objects SET key = key WHERE bucket_id = ? AND key = ?`, bucketID, key)
 	if res.Error != nil {
 		return res.Error
@@ -72,6 +87,25 @@ func (db *DB) LockObjectForUpdate(tx *gorm.DB, bucketID uint, key string) error
 	return tx.Exec(`UPDATE objects SET key = key WHERE bucket_id = ? AND key = ?`, bucketID, key).Error
 }
 
+func (db *DB) lockObjectRow(tx *gorm.DB, bucketID uint, key string) error {
+	obj := Object{BucketID: bucketID, Key: key}
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "bucket_id"}, {Name: "key"}},
+		DoNothing: true,
+	}).Create(&obj).Error; err != nil {
+		return err
+	}
+	var locked Object
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).
+		Take(&locked).Error
+}
+
+// DeleteObjectTx удаляет строку ключа (версий у него уже не осталось).
+func (db *DB) DeleteObjectTx(tx *gorm.DB, bucketID uint, key string) error {
+	return tx.Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).Delete(&Object{}).Error
+}
+
 func (db *DB) UpsertObjectTx(tx *gorm.DB, bucketID uint, key, blobID string, size int64, etag, contentType string, headVersionID string) error {
 	obj := Object{
 		BucketID: bucketID, Key: key,
@@ -86,7 +120,7 @@ func (db *DB) UpsertObjectTx(tx *gorm.DB, bucketID uint, key, blobID string, siz
 
 func (db *DB) FindObject(bucketID uint, key string) (*ObjectMeta, error) {
 	var o Object
-	if err := db.Where("bucket_id = ? AND key = ?", bucketID, key).Take(&o).Error; err != nil {
+	if err := db.Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).Take(&o).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
@@ -101,122 +135,136 @@ func (db *DB) ListObjectsV2(ctx context.Context, p ListV2Params) (*ListV2Result,
 	if p.MaxKeys <= 0 || p.MaxKeys > 1000 {
 		p.MaxKeys = 1000
 	}
-	afterKey := p.StartAfter
-	if p.ContTokenRaw != "" {
+	afterKey, fromKey := p.StartAfter, ""
+	if c := p.Cursor; c != nil {
 		// токен главнее start-after
-		p.StartAfter = ""
-		b, err := base64.RawURLEncoding.DecodeString(p.ContTokenRaw)
-		if err != nil {
-			return nil, ErrInvalidContToken
+		p.StartAfter, afterKey = "", c.After
+		if c.Rollup {
+			end, ok := prefixEnd(c.After)
+			if !ok {
+				return &ListV2Result{}, nil
+			}
+			afterKey, fromKey = "", end
 		}
-		afterKey = string(b)
 	}
 
-	type row struct {
-		Key          string    `gorm:"column:key"`
-		ETag         *string   `gorm:"column:e_tag"`
-		Size         *int64    `gorm:"column:size"`
-		LastModified time.Time `gorm:"column:last_modified"`
+	// Ключи под уже отданным CommonPrefix пропускаются, а следующая пачка
+	// начинается сразу за префиксом — по индексу, не читая его ключи. Число
+	// запросов на страницу зависит от числа префиксов на ней, а не от того,
+	// сколько ключей лежит под каждым.
+	result := &ListV2Result{}
+	var last ListV2Cursor
+	for {
+		need := p.MaxKeys - len(result.Objects) - len(result.CommonPrefixes) + 1
+		rows, err := db.listV2Rows(ctx, p, afterKey, fromKey, need)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			if last.Rollup && strings.HasPrefix(r.Key, last.After) {
+				continue
+			}
+			if len(result.Objects)+len(result.CommonPrefixes) == p.MaxKeys {
+				result.IsTruncated = true
+				break
+			}
+			if p.Delimiter != "" {
+				rest := strings.TrimPrefix(r.Key, p.Prefix)
+				if idx := strings.Index(rest, p.Delimiter); idx >= 0 {
+					cp := p.Prefix + rest[:idx+len(p.Delimiter)]
+					result.CommonPrefixes = append(result.CommonPrefixes, cp)
+					last = ListV2Cursor{After: cp, Rollup: true}
+					continue
+				}
+			}
+			result.Objects = append(result.Objects, ListV2Item{
+				Key:          r.Key,
+				ETag:         r.ETag,
+				Size:         derefInt64(r.Size),
+				LastModified: r.LastModified.UTC(),
+				StorageClass: r.StorageClass,
+			})
+			last = ListV2Cursor{After: r.Key}
+		}
+		if result.IsTruncated || len(rows) < need {
+			break
+		}
+		// пачка кончилась — следующая после последнего элемента
+		afterKey, fromKey = last.After, ""
+		if last.Rollup {
+			end, ok := prefixEnd(last.After)
+			if !ok {
+				break
+			}
+			afterKey, fromKey = "", end
+		}
+	}
+	if result.IsTruncated {
+		result.Next = &last
 	}
 
+	// Итоговый счётчик
+	result.KeyCount = len(result.Objects) + len(result.CommonPrefixes)
+	return result, nil
+}
+
+type listV2Row struct {
+	Key          string    `gorm:"column:key"`
+	ETag         *string   `gorm:"column:e_tag"`
+	Size         *int64    `gorm:"column:size"`
+	LastModified time.Time `gorm:"column:last_modified"`
+	StorageClass string    `gorm:"column:storage_class"`
+}
+
+// listV2Rows — до limit текущих (не delete-marker) ключей по порядку: строго
+// после afterKey или начиная с fromKey.
+func (db *DB) listV2Rows(ctx context.Context, p ListV2Params, afterKey, fromKey string, limit int) ([]listV2Row, error) {
+	k := db.quote("objects.key")
 	q := db.
 		Model(&Object{}).
 		// ВАЖНО: join по ov.version_id (а не ov.id)
 		Select(`
-			objects.key AS key,
+			`+k+` AS `+db.quote("key")+`,
 			ov.e_tag    AS e_tag,
 			ov.size     AS size,
-			ov.created_at AS last_modified
+			ov.created_at AS last_modified,
+			ov.storage_class AS storage_class
 		`).
 		Joins(`JOIN object_versions ov ON ov.version_id = objects.head_version_id`).
 		Where("objects.bucket_id = ?", p.BucketID).
 		Where("ov.is_delete = ?", false)
 
 	if p.Prefix != "" {
-		q = q.Where("objects.key LIKE ?", p.Prefix+"%")
+		q = q.Where(db.likePrefix(k, p.Prefix))
 	}
 	if afterKey != "" {
-		q = q.Where("objects.key > ?", afterKey)
+		q = q.Where(k+" > ?", afterKey)
 	}
-
-	q = q.Order("objects.key ASC").Limit(p.MaxKeys + 1)
-
-	var rows []row
-	if err := q.WithContext(ctx).Scan(&rows).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return &ListV2Result{}, nil
-		}
-		return nil, err
+	if fromKey != "" {
+		q = q.Where(k+" >= ?", fromKey)
 	}
 
-	// формирование результата
-	result := &ListV2Result{}
-	if p.Delimiter != "" {
-		prefixSet := make(map[string]struct{})
-		for _, r := range rows {
-			rest := strings.TrimPrefix(r.Key, p.Prefix)
-			if idx := strings.Index(rest, p.Delimiter); idx >= 0 {
-				cp := p.Prefix + rest[:idx+1]
-				prefixSet[cp] = struct{}{}
-				continue
-			}
-			result.Objects = append(result.Objects, ListV2Item{
-				Key:          r.Key,
-				ETag:         r.ETag,
-				Size:         derefInt64(r.Size),
-				LastModified: r.LastModified.UTC(),
-			})
-		}
-		for cp := range prefixSet {
-			result.CommonPrefixes = append(result.CommonPrefixes, cp)
-		}
-		sort.Strings(result.CommonPrefixes)
-	} else {
-		for _, r := range rows {
-			result.Objects = append(result.Objects, ListV2Item{
-				Key:          r.Key,
-				ETag:         r.ETag,
-				Size:         derefInt64(r.Size),
-				LastModified: r.LastModified.UTC(),
-			})
-		}
-	}
-
-	if len(rows) > p.MaxKeys || len(result.Objects)+len(result.CommonPrefixes) > p.MaxKeys {
-		result.IsTruncated = true
-
-		// Обрезаем видимые элементы до лимита (учитывая префиксы)
-		trimTo := p.MaxKeys - len(result.CommonPrefixes)
-		if trimTo < 0 {
-			trimTo = 0
-		}
-		if len(result.Objects) > trimTo {
-			result.Objects = result.Objects[:trimTo]
-		}
+	var rows []listV2Row
+	err := q.Order(k + " ASC").Limit(limit).WithContext(ctx).Scan(&rows).Error
+	return rows, err
+}
 
-		// Токен — base64(rawurl) от последнего возвращённого объекта,
-		// если объектов нет — можно использовать последний префикс (приблизительно).
-		var lastKey string
-		if n := len(result.Objects); n > 0 {
-			lastKey = result.Objects[n-1].Key
-		} else if n := len(result.CommonPrefixes); n > 0 {
-			// это компромисс: AWS использует внутренний ключ,
-			// у нас допустимо взять последний CommonPrefix
-			lastKey = result.CommonPrefixes[n-1]
-		}
-		if lastKey != "" {
-			result.NextToken = base64.RawURLEncoding.EncodeToString([]byte(lastKey))
+// prefixEnd — наименьшая строка больше всех строк с префиксом p (в побайтовом
+// порядке). ok=false — такой нет: p из одних байт 0xFF.
+func prefixEnd(p string) (string, bool) {
+	b := []byte(p)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xFF {
+			b[i]++
+			return string(b[:i+1]), true
 		}
 	}
-
-	// Итоговый счётчик
-	result.KeyCount = len(result.Objects) + len(result.CommonPrefixes)
-	return result, nil
+	return "", false
 }
 
 func (db *DB) ClearObjectHeadMeta(bucketID uint, key string) error {
 	return db.DB.Model(&Object{}).
-		Where("bucket_id = ? AND `key` = ?", bucketID, key).
+		Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).
 		Updates(map[string]any{
 			"blob_id":      "",
 			"size":         0,
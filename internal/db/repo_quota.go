@@ -0,0 +1,123 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Квоты: предел версий с данными и их байт у бакета и у пользователя (сумма по
+// его бакетам). Считаются по bucket_usage_counters: корзина и delete-marker'ы
+// в квоту не входят. Проверка — в конце транзакции записи, когда счётчики уже
+// учли и новую версию, и удалённые ею старые (перезапись без версионирования
+// места не прибавляет).
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError — какой предел превышен; errors.Is(err, ErrQuotaExceeded).
+type QuotaError struct {
+	Scope string // bucket | user
+	Limit string // bytes | objects
+	Max   int64
+	Used  int64 // с учётом отклонённой записи
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s quota exceeded: %d %s of %d", e.Scope, e.Used, e.Limit, e.Max)
+}
+
+func (e *QuotaError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// CheckQuotaTx проверяет квоты бакета и его владельца по текущим счётчикам —
+// вызывается после записи версии в той же транзакции.
+func (db *DB) CheckQuotaTx(tx *gorm.DB, bucketID uint) error {
+	return db.checkQuotaTx(tx, bucketID, 0, 0)
+}
+
+// CheckQuota — предварительная проверка до приёма тела: поместятся ли ещё
+// objects версий на bytes байт. Окончательно решает CheckQuotaTx.
+func (db *DB) CheckQuota(bucketID uint, objects, bytes int64) error {
+	return db.checkQuotaTx(db.DB, bucketID, objects, bytes)
+}
+
+func (db *DB) checkQuotaTx(tx *gorm.DB, bucketID uint, addObjects, addBytes int64) error {
+	var q struct {
+		OwnerID         uint
+		QuotaMaxBytes   int64
+		QuotaMaxObjects int64
+		UserMaxBytes    int64
+		UserMaxObjects  int64
+	}
+	err := tx.Raw(`
+		SELECT bk.owner_id, bk.quota_max_bytes, bk.quota_max_objects,
+		       COALESCE(u.quota_max_bytes, 0) AS user_max_bytes, COALESCE(u.quota_max_objects, 0) AS user_max_objects
+		FROM buckets bk LEFT JOIN users u ON u.id = bk.owner_id
+		WHERE bk.id = ?
+	`, bucketID).Scan(&q).Error
+	if err != nil {
+		return err
+	}
+	if q.QuotaMaxBytes > 0 || q.QuotaMaxObjects > 0 {
+		var used struct{ Objects, Bytes int64 }
+		err := tx.Raw(`SELECT objects, bytes FROM bucket_usage_counters WHERE bucket_id = ?`, bucketID).Scan(&used).Error
+		if err != nil {
+			return err
+		}
+		if err := quotaCheck("bucket", q.QuotaMaxObjects, q.QuotaMaxBytes, used.Objects+addObjects, used.Bytes+addBytes); err != nil {
+			return err
+		}
+	}
+	if q.OwnerID != 0 && (q.UserMaxBytes > 0 || q.UserMaxObjects > 0) {
+		var used struct{ Objects, Bytes int64 }
+		err := tx.Raw(`
+			SELECT COALESCE(SUM(c.objects), 0) AS objects, COALESCE(SUM(c.bytes), 0) AS bytes
+			FROM bucket_usage_counters c JOIN buckets bk ON bk.id = c.bucket_id
+			WHERE bk.owner_id = ?
+		`, q.OwnerID).Scan(&used).Error
+		if err != nil {
+			return err
+		}
+		if err := quotaCheck("user", q.UserMaxObjects, q.UserMaxBytes, used.Objects+addObjects, used.Bytes+addBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func quotaCheck(scope string, maxObjects, maxBytes, objects, bytes int64) error {
+	if maxBytes > 0 && bytes > maxBytes {
+		return &QuotaError{Scope: scope, Limit: "bytes", Max: maxBytes, Used: bytes}
+	}
+	if maxObjects > 0 && objects > maxObjects {
+		return &QuotaError{Scope: scope, Limit: "objects", Max: maxObjects, Used: objects}
+	}
+	return nil
+}
+
+// migrateQuotas — колонки квот у бакетов и пользователей.
+func (db *DB) migrateQuotas(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, model := range []any{&Bucket{}, &User{}} {
+		for _, field := range []string{"QuotaMaxBytes", "QuotaMaxObjects"} {
+			if m.HasColumn(model, field) {
+				continue
+			}
+			if err := m.AddColumn(model, field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (db *DB) rollbackQuotas(tx *gorm.DB) error {
+	for _, table := range []string{"buckets", "users"} {
+		for _, column := range []string{"quota_max_bytes", "quota_max_objects"} {
+			if err := db.dropColumn(tx, table, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
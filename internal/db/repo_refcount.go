@@ -0,0 +1,133 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Живость блоба — счётчик blobs.ref_count: его меняет каждая запись, которая
+// добавляет или убирает ссылку на блоб (версия, кусок manifest-блоба, кэш
+// трансформации, часть multipart, версия в корзине), в той же транзакции. Блоб
+// со счётчиком 0 стоит в pending_deletions, и GC берёт кандидатов оттуда, не
+// пересчитывая ссылки по таблицам.
+
+// ErrBlobGone — ссылку добавляют на блоб, которого уже нет (его удалил GC).
+var ErrBlobGone = errors.New("blob is gone")
+
+// createBlobTx — новый блоб: ссылок на него ещё нет, поэтому он сразу в очереди
+// GC. Ссылка в той же транзакции его оттуда уберёт; не появится — блоб соберут.
+func (db *DB) createBlobTx(tx *gorm.DB, b *Blob) error {
+	if err := tx.Create(b).Error; err != nil {
+		return err
+	}
+	return db.queueBlobDeletionTx(tx, b.ID)
+}
+
+func (db *DB) queueBlobDeletionTx(tx *gorm.DB, id string) error {
+	return db.insertIgnore(tx).Create(&PendingDeletion{BlobID: id, QueuedAt: time.Now().UTC()}).Error
+}
+
+// refBlobTx меняет счётчик ссылок блоба на delta. Снять ссылку с блоба, которого
+// нет, — не ошибка (удалён раньше, чем ссылки начали считаться).
+func (db *DB) refBlobTx(tx *gorm.DB, id string, delta int64) error {
+	res := tx.Model(&Blob{}).Where("id = ?", id).UpdateColumn("ref_count", gorm.Expr("ref_count + ?", delta))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		if delta > 0 {
+			return fmt.Errorf("%w: %s", ErrBlobGone, id)
+		}
+		return nil
+	}
+	var n int64
+	if err := tx.Model(&Blob{}).Where("id = ?", id).Select("ref_count").Scan(&n).Error; err != nil {
+		return err
+	}
+	switch {
+	case n <= 0:
+		return db.queueBlobDeletionTx(tx, id)
+	case n == delta: // было 0 — блоб снова нужен
+		return tx.Where("blob_id = ?", id).Delete(&PendingDeletion{}).Error
+	}
+	return nil
+}
+
+func (db *DB) refBlobsTx(tx *gorm.DB, delta int64, ids ...string) error {
+	for _, id := range ids {
+		if err := db.refBlobTx(tx, id, delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBlobRefs — колонка ref_count и очередь GC, счётчики — по таблицам.
+func (db *DB) migrateBlobRefs(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasColumn(&Blob{}, "RefCount") {
+		if err := m.AddColumn(&Blob{}, "RefCount"); err != nil {
+			return err
+		}
+	}
+	if !m.HasTable(&PendingDeletion{}) {
+		if err := m.CreateTable(&PendingDeletion{}); err != nil {
+			return err
+		}
+	}
+	return db.recountBlobRefs(tx)
+}
+
+// migrateCondemnedAt — колонка приговора в очереди GC (двухфазное удаление).
+func (db *DB) migrateCondemnedAt(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasColumn(&PendingDeletion{}, "CondemnedAt") {
+		if err := m.AddColumn(&PendingDeletion{}, "CondemnedAt"); err != nil {
+			return err
+		}
+	}
+	if !m.HasIndex(&PendingDeletion{}, "CondemnedAt") {
+		return m.CreateIndex(&PendingDeletion{}, "CondemnedAt")
+	}
+	return nil
+}
+
+func (db *DB) rollbackCondemnedAt(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if m.HasIndex(&PendingDeletion{}, "CondemnedAt") {
+		if err := m.DropIndex(&PendingDeletion{}, "CondemnedAt"); err != nil {
+			return err
+		}
+	}
+	return db.dropColumn(tx, "pending_deletions", "condemned_at")
+}
+
+// recountBlobRefs пересчитывает ref_count по таблицам и заново строит очередь GC.
+func (db *DB) recountBlobRefs(tx *gorm.DB) error {
+	// корзину добавляет миграция 6, а пересчёт бывает и до неё (миграция 4)
+	trash := ""
+	if tx.Migrator().HasTable(&TrashedVersion{}) {
+		trash = "+ (SELECT COUNT(*) FROM trashed_versions t WHERE t.blob_id = blobs.id)"
+	}
+	if err := tx.Exec(`
+		UPDATE blobs SET ref_count =
+			  (SELECT COUNT(*) FROM object_versions v WHERE v.blob_id = blobs.id)
+			+ (SELECT COUNT(*) FROM blob_chunks c WHERE c.chunk_blob_id = blobs.id)
+			+ (SELECT COUNT(*) FROM derived_blobs d WHERE d.blob_id = blobs.id)
+			+ (SELECT COUNT(*) FROM multipart_parts p WHERE p.blob_id = blobs.id)
+			` + trash).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("blob_id IN (?)", tx.Model(&Blob{}).Select("id").Where("ref_count > 0")).
+		Delete(&PendingDeletion{}).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`
+		INSERT INTO pending_deletions (blob_id, queued_at)
+		SELECT b.id, ? FROM blobs b
+		WHERE b.ref_count = 0 AND NOT EXISTS (SELECT 1 FROM pending_deletions q WHERE q.blob_id = b.id)
+	`, time.Now().UTC()).Error
+}
@@ -0,0 +1,121 @@
+package db
+
+import "time"
+
+// DedupSnapshot — срез логического/физического объёма для истории экономии.
+type DedupSnapshot struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	TakenAt       time.Time `gorm:"index;not null" json:"taken_at"`
+	LogicalBytes  int64     `gorm:"not null" json:"logical_bytes"`
+	PhysicalBytes int64     `gorm:"not null" json:"physical_bytes"`
+	Versions      int64     `gorm:"not null" json:"versions"`
+	Blobs         int64     `gorm:"not null" json:"blobs"`
+}
+
+// BucketUsage — логический объём бакета (все живые версии) и физический —
+// уникальные plain-блобы, на которые ссылаются его версии (manifest раскрыт).
+type BucketUsage struct {
+	BucketID      uint   `json:"-"`
+	Bucket        string `json:"bucket"`
+	Versions      int64  `json:"versions"`
+	LogicalBytes  int64  `json:"logical_bytes"`
+	PhysicalBytes int64  `json:"physical_bytes"`
+	SavedBytes    int64  `json:"saved_bytes" gorm:"-"` // logical - physical
+}
+
+// DuplicateBlob — блоб, на который ссылается больше одной версии.
+type DuplicateBlob struct {
+	BlobID     string `json:"blob_id"`
+	Checksum   string `json:"checksum"`
+	Size       int64  `json:"size"`
+	Refs       int64  `json:"refs"`
+	SavedBytes int64  `json:"saved_bytes"`
+}
+
+// BucketUsageStats — объёмы по всем бакетам (bucketID == 0) или по одному.
+func (db *DB) BucketUsageStats(bucketID uint) ([]BucketUsage, error) {
+	var out []BucketUsage
+	err := db.DB.Raw(`
+		WITH live AS (
+			SELECT bucket_id, blob_id, size FROM object_versions
+			WHERE is_delete = FALSE AND blob_id IS NOT NULL AND (? = 0 OR bucket_id = ?)
+		),
+		logical AS (
+			SELECT bucket_id, COUNT(*) AS versions, COALESCE(SUM(size), 0) AS logical_bytes
+			FROM live GROUP BY bucket_id
+		),
+		plain AS (
+			SELECT DISTINCT l.bucket_id, COALESCE(c.chunk_blob_id, l.blob_id) AS blob_id
+			FROM live l LEFT JOIN blob_chunks c ON c.blob_id = l.blob_id
+		),
+		physical AS (
+			SELECT p.bucket_id, COALESCE(SUM(CASE WHEN b.stored_size > 0 THEN b.stored_size ELSE b.size END), 0) AS physical_bytes
+			FROM plain p JOIN blobs b ON b.id = p.blob_id AND b.kind = 'plain'
+			GROUP BY p.bucket_id
+		)
+		SELECT bk.id AS bucket_id, bk.name AS bucket,
+		       COALESCE(lg.versions, 0) AS versions,
+		       COALESCE(lg.logical_bytes, 0) AS logical_bytes,
+		       COALESCE(ph.physical_bytes, 0) AS physical_bytes
+		FROM buckets bk
+		LEFT JOIN logical lg ON lg.bucket_id = bk.id
+		LEFT JOIN physical ph ON ph.bucket_id = bk.id
+		WHERE (? = 0 OR bk.id = ?)
+		ORDER BY bk.name
+	`, bucketID, bucketID, bucketID, bucketID).Scan(&out).Error
+	for i := range out {
+		out[i].SavedBytes = out[i].LogicalBytes - out[i].PhysicalBytes
+	}
+	return out, err
+}
+
+// GlobalUsageStats — логический объём всех живых версий против байт на диске.
+func (db *DB) GlobalUsageStats() (snap DedupSnapshot, err error) {
+	err = db.DB.Raw(`
+		SELECT COUNT(*) AS versions, COALESCE(SUM(size), 0) AS logical_bytes
+		FROM object_versions WHERE is_delete = FALSE AND blob_id IS NOT NULL
+	`).Scan(&snap).Error
+	if err != nil {
+		return snap, err
+	}
+	var phys struct {
+		Blobs         int64
+		PhysicalBytes int64
+	}
+	err = db.DB.Raw(`
+		SELECT COUNT(*) AS blobs, COALESCE(SUM(CASE WHEN stored_size > 0 THEN stored_size ELSE size END), 0) AS physical_bytes
+		FROM blobs WHERE kind = 'plain' AND state = 'ready'
+	`).Scan(&phys).Error
+	snap.Blobs, snap.PhysicalBytes = phys.Blobs, phys.PhysicalBytes
+	return snap, err
+}
+
+// TopDuplicateBlobs — блобы с наибольшей экономией (refs-1)*size.
+func (db *DB) TopDuplicateBlobs(bucketID uint, limit int) ([]DuplicateBlob, error) {
+	var out []DuplicateBlob
+	err := db.DB.Raw(`
+		SELECT b.id AS blob_id, b.checksum, b.size, COUNT(*) AS refs, (COUNT(*) - 1) * b.size AS saved_bytes
+		FROM object_versions v JOIN blobs b ON b.id = v.blob_id
+		WHERE v.is_delete = FALSE AND (? = 0 OR v.bucket_id = ?)
+		GROUP BY b.id, b.checksum, b.size
+		HAVING COUNT(*) > 1
+		ORDER BY saved_bytes DESC
+		LIMIT ?
+	`, bucketID, bucketID, limit).Scan(&out).Error
+	return out, err
+}
+
+func (db *DB) SaveDedupSnapshot(s *DedupSnapshot) error {
+	return db.Create(s).Error
+}
+
+// DedupHistory — снимки за период, старые первыми.
+func (db *DB) DedupHistory(since time.Time) ([]DedupSnapshot, error) {
+	var out []DedupSnapshot
+	err := db.Where("taken_at >= ?", since).Order("taken_at").Find(&out).Error
+	return out, err
+}
+
+func (db *DB) PruneDedupSnapshots(before time.Time) error {
+	return db.Where("taken_at < ?", before).Delete(&DedupSnapshot{}).Error
+}
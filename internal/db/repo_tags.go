@@ -0,0 +1,72 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+// ListVersionTagsTx — теги версии по ключу тега.
+func (db *DB) ListVersionTagsTx(tx *gorm.DB, versionID string) ([]ObjectVersionTag, error) {
+	var out []ObjectVersionTag
+	err := tx.Where("version_id = ?", versionID).Order(db.quote("key")).Find(&out).Error
+	return out, err
+}
+
+func (db *DB) CountVersionTags(versionID string) (int64, error) {
+	var n int64
+	err := db.DB.Model(&ObjectVersionTag{}).Where("version_id = ?", versionID).Count(&n).Error
+	return n, err
+}
+
+// SetVersionTagsTx заменяет набор тегов версии целиком (пустой набор — без тегов).
+// Событие в ленту не пишет: при PUT объекта теги — часть object_created.
+func (db *DB) SetVersionTagsTx(tx *gorm.DB, versionID string, tags map[string]string) error {
+	if err := tx.Where("version_id = ?", versionID).Delete(&ObjectVersionTag{}).Error; err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	rows := make([]ObjectVersionTag, 0, len(tags))
+	for k, v := range tags {
+		rows = append(rows, ObjectVersionTag{VersionID: versionID, Key: k, Value: v})
+	}
+	return tx.Create(&rows).Error
+}
+
+// ReplaceVersionTagsTx — PUT/DELETE ?tagging: замена тегов с событием tags_changed.
+func (db *DB) ReplaceVersionTagsTx(tx *gorm.DB, ver *ObjectVersion, tags map[string]string) error {
+	if err := db.SetVersionTagsTx(tx, ver.VersionID, tags); err != nil {
+		return err
+	}
+	return recordChangeTx(tx, &ChangeEvent{Type: ChangeTagsChanged, BucketID: ver.BucketID, Key: ver.Key, VersionID: ver.VersionID})
+}
+
+// CopyVersionTagsTx — теги версии from на версию to (CopyObject, пакетное копирование).
+func (db *DB) CopyVersionTagsTx(tx *gorm.DB, from, to string) error {
+	k := db.quote("key")
+	return tx.Exec(`INSERT INTO object_version_tags (version_id, `+k+`, value)
+		SELECT ?, `+k+`, value FROM object_version_tags WHERE version_id = ?`, to, from).Error
+}
+
+func (db *DB) ListBucketTags(bucketID uint) ([]BucketTag, error) {
+	var out []BucketTag
+	err := db.DB.Where("bucket_id = ?", bucketID).Order(db.quote("key")).Find(&out).Error
+	return out, err
+}
+
+// SetBucketTags заменяет набор тегов бакета целиком (пустой набор — удалить все).
+func (db *DB) SetBucketTags(bucketID uint, tags map[string]string) error {
+	return db.WithTx(func(tx *gorm.DB) error {
+		if err := tx.Where("bucket_id = ?", bucketID).Delete(&BucketTag{}).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		rows := make([]BucketTag, 0, len(tags))
+		for k, v := range tags {
+			rows = append(rows, BucketTag{BucketID: bucketID, Key: k, Value: v})
+		}
+		return tx.Create(&rows).Error
+	})
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Корзина бакета: версия с данными не удаляется, а переезжает в trashed_versions
+// вместе с тегами. Ссылка на блоб переходит к записи корзины, так что байты
+// живут до ExpiresAt; после — запись удаляет GC, и блоб уходит в его очередь.
+
+// trashedVersionData — содержимое TrashedVersion.Version.
+type trashedVersionData struct {
+	Version ObjectVersion      `json:"version"`
+	Tags    []ObjectVersionTag `json:"tags,omitempty"`
+}
+
+// TrashVersionTx переносит версию в корзину до expiresAt. Delete-marker'у байт
+// беречь не нужно — он удаляется как обычно.
+func (db *DB) TrashVersionTx(tx *gorm.DB, versionID string, expiresAt time.Time) error {
+	var ver ObjectVersion
+	err := tx.Where("version_id = ?", versionID).Take(&ver).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ver.BlobID == nil {
+		return db.DeleteVersionTx(tx, versionID)
+	}
+	tags, err := db.ListVersionTagsTx(tx, versionID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(trashedVersionData{Version: ver, Tags: tags})
+	if err != nil {
+		return err
+	}
+	t := TrashedVersion{
+		VersionID: ver.VersionID, BucketID: ver.BucketID, Key: ver.Key, BlobID: *ver.BlobID,
+		Version: string(data), TrashedAt: time.Now().UTC(), ExpiresAt: expiresAt.UTC(),
+	}
+	if ver.Size != nil {
+		t.Size = *ver.Size
+	}
+	if ver.ETag != nil {
+		t.ETag = *ver.ETag
+	}
+	if err := tx.Create(&t).Error; err != nil {
+		return err
+	}
+	if err := db.addUsageTx(tx, t.BucketID, usageDelta{trashObjects: 1, trashBytes: t.Size}); err != nil {
+		return err
+	}
+	// ссылка корзины раньше, чем версия снимет свою: блоб не попадёт в очередь GC
+	if err := db.refBlobTx(tx, t.BlobID, 1); err != nil {
+		return err
+	}
+	return db.DeleteVersionTx(tx, versionID)
+}
+
+// ListTrash — корзина бакета по (key, version_id) строго после (afterKey, afterVersionID).
+func (db *DB) ListTrash(bucketID uint, prefix, afterKey, afterVersionID string, limit int) ([]TrashedVersion, error) {
+	k := db.quote("key")
+	q := db.DB.Omit("Version").Where("bucket_id = ?", bucketID)
+	if prefix != "" {
+		q = q.Where(db.likePrefix(k, prefix))
+	}
+	if afterKey != "" {
+		q = q.Where("("+k+" > ? OR ("+k+" = ? AND version_id > ?))", afterKey, afterKey, afterVersionID)
+	}
+	var out []TrashedVersion
+	err := q.Order(k).Order("version_id").Limit(limit).Find(&out).Error
+	return out, err
+}
+
+func (db *DB) GetTrashedVersion(bucketID uint, versionID string) (*TrashedVersion, error) {
+	var t TrashedVersion
+	err := db.DB.Where("bucket_id = ? AND version_id = ?", bucketID, versionID).Take(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RestoreTrashedVersionTx возвращает версию из корзины с прежними VersionID,
+// временем создания и тегами; ссылка на блоб переходит от корзины к версии.
+// HEAD ключа не трогает. Вызывается под LockObjectForUpdate.
+func (db *DB) RestoreTrashedVersionTx(tx *gorm.DB, bucketID uint, versionID string) (*ObjectVersion, error) {
+	var t TrashedVersion
+	err := tx.Where("bucket_id = ? AND version_id = ?", bucketID, versionID).Take(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data trashedVersionData
+	if err := json.Unmarshal([]byte(t.Version), &data); err != nil {
+		return nil, err
+	}
+	ver := data.Version
+	if err := tx.Where("version_id = ?", versionID).Delete(&TrashedVersion{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&ver).Error; err != nil {
+		return nil, err
+	}
+	d := versionUsage(ver.BlobID, ver.Size, 1)
+	d.trashObjects, d.trashBytes = -1, -t.Size
+	if err := db.addUsageTx(tx, ver.BucketID, d); err != nil {
+		return nil, err
+	}
+	if len(data.Tags) > 0 {
+		if err := tx.Create(&data.Tags).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := recordChangeTx(tx, &ChangeEvent{Type: ChangeObjectCreated, BucketID: ver.BucketID, Key: ver.Key,
+		VersionID: ver.VersionID, ETag: t.ETag, Size: &t.Size}); err != nil {
+		return nil, err
+	}
+	return &ver, nil
+}
+
+// DeleteTrashedVersion — удалить версию из корзины бакета досрочно.
+func (db *DB) DeleteTrashedVersion(bucketID uint, versionID string) (bool, error) {
+	var n int64
+	err := db.WithTx(func(tx *gorm.DB) error {
+		var err error
+		n, err = db.dropTrashTx(tx, "bucket_id = ? AND version_id = ?", bucketID, versionID)
+		return err
+	})
+	return n > 0, err
+}
+
+// PurgeExpiredTrash удаляет из корзин до limit версий с истёкшим сроком.
+func (db *DB) PurgeExpiredTrash(now time.Time, limit int) (int64, error) {
+	var n int64
+	err := db.WithTx(func(tx *gorm.DB) error {
+		var ids []string
+		if err := tx.Model(&TrashedVersion{}).Where("expires_at <= ?", now.UTC()).
+			Order("expires_at").Limit(limit).Pluck("version_id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		var err error
+		n, err = db.dropTrashTx(tx, "version_id IN ?", ids)
+		return err
+	})
+	return n, err
+}
+
+// dropTrashTx удаляет записи корзины по условию и снимает их ссылки на блобы.
+func (db *DB) dropTrashTx(tx *gorm.DB, query string, args ...any) (int64, error) {
+	var rows []TrashedVersion
+	if err := tx.Select("bucket_id", "blob_id", "size").Where(query, args...).Find(&rows).Error; err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	if err := tx.Where(query, args...).Delete(&TrashedVersion{}).Error; err != nil {
+		return 0, err
+	}
+	blobIDs := make([]string, len(rows))
+	usage := map[uint]usageDelta{}
+	for i, r := range rows {
+		blobIDs[i] = r.BlobID
+		d := usage[r.BucketID]
+		d.trashObjects--
+		d.trashBytes -= r.Size
+		usage[r.BucketID] = d
+	}
+	for bucketID, d := range usage {
+		if err := db.addUsageTx(tx, bucketID, d); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(rows)), db.refBlobsTx(tx, -1, blobIDs...)
+}
+
+// migrateTrash — настройка корзины у бакетов и таблица trashed_versions.
+func (db *DB) migrateTrash(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasColumn(&Bucket{}, "TrashDays") {
+		if err := m.AddColumn(&Bucket{}, "TrashDays"); err != nil {
+			return err
+		}
+	}
+	if !m.HasTable(&TrashedVersion{}) {
+		return m.CreateTable(&TrashedVersion{})
+	}
+	return nil
+}
+
+// rollbackTrash — версии из корзины пропадают, их блобы уходят в очередь GC.
+func (db *DB) rollbackTrash(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if m.HasTable(&TrashedVersion{}) {
+		if err := m.DropTable(&TrashedVersion{}); err != nil {
+			return err
+		}
+	}
+	if err := db.recountBlobRefs(tx); err != nil {
+		return err
+	}
+	return db.dropColumn(tx, "buckets", "trash_days")
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Занятое место: bucket_usage_counters меняет каждая запись, которая добавляет
+// или убирает версию с данными (PUT, DELETE, lifecycle, корзина), в той же
+// транзакции. Объём пользователя — сумма по его бакетам. Bytes — размер версий
+// для клиента: байты, общие у нескольких версий (дедуп), считаются у каждой.
+
+// usageDelta — изменение счётчиков бакета.
+type usageDelta struct {
+	objects, bytes           int64
+	trashObjects, trashBytes int64
+}
+
+// addUsageTx прибавляет d к счётчикам бакета; строки бакета может ещё не быть.
+func (db *DB) addUsageTx(tx *gorm.DB, bucketID uint, d usageDelta) error {
+	if d == (usageDelta{}) {
+		return nil
+	}
+	now := time.Now().UTC()
+	if err := db.insertIgnore(tx).Create(&BucketUsageCounter{BucketID: bucketID, UpdatedAt: now}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&BucketUsageCounter{}).Where("bucket_id = ?", bucketID).UpdateColumns(map[string]any{
+		"objects":       gorm.Expr("objects + ?", d.objects),
+		"bytes":         gorm.Expr("bytes + ?", d.bytes),
+		"trash_objects": gorm.Expr("trash_objects + ?", d.trashObjects),
+		"trash_bytes":   gorm.Expr("trash_bytes + ?", d.trashBytes),
+		"updated_at":    now,
+	}).Error
+}
+
+// versionUsage — вклад версии в счётчики: delete-marker места не занимает.
+func versionUsage(blobID *string, size *int64, sign int64) usageDelta {
+	if blobID == nil {
+		return usageDelta{}
+	}
+	d := usageDelta{objects: sign}
+	if size != nil {
+		d.bytes = sign * *size
+	}
+	return d
+}
+
+// BucketUsageRow — счётчики бакета с именем и владельцем.
+type BucketUsageRow struct {
+	BucketID     uint       `json:"-"`
+	Bucket       string     `json:"bucket"`
+	OwnerID      uint       `json:"owner_id"`
+	Owner        string     `json:"owner"` // имя пользователя (его первый ключ)
+	Objects      int64      `json:"objects"`
+	Bytes        int64      `json:"bytes"`
+	TrashObjects int64      `json:"trash_objects"`
+	TrashBytes   int64      `json:"trash_bytes"`
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"` // nil — в бакет ещё не писали
+}
+
+// UserUsageRow — сумма счётчиков бакетов пользователя.
+type UserUsageRow struct {
+	UserID       uint   `json:"user_id"`
+	Name         string `json:"name"`
+	Buckets      int64  `json:"buckets"`
+	Objects      int64  `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+	TrashObjects int64  `json:"trash_objects"`
+	TrashBytes   int64  `json:"trash_bytes"`
+}
+
+// BucketUsageCounters — счётчики бакетов; bucketID и ownerID, если не 0,
+// сужают выборку до бакета и до бакетов пользователя. Бакет без строки
+// счётчиков — пустой.
+func (db *DB) BucketUsageCounters(bucketID, ownerID uint) ([]BucketUsageRow, error) {
+	var out []BucketUsageRow
+	err := db.DB.Raw(`
+		SELECT bk.id AS bucket_id, bk.name AS bucket, bk.owner_id, COALESCE(u.access_key_id, '') AS owner,
+		       COALESCE(c.objects, 0) AS objects, COALESCE(c.bytes, 0) AS bytes,
+		       COALESCE(c.trash_objects, 0) AS trash_objects, COALESCE(c.trash_bytes, 0) AS trash_bytes,
+		       c.updated_at
+		FROM buckets bk
+		LEFT JOIN bucket_usage_counters c ON c.bucket_id = bk.id
+		LEFT JOIN users u ON u.id = bk.owner_id
+		WHERE (? = 0 OR bk.id = ?) AND (? = 0 OR bk.owner_id = ?)
+		ORDER BY bk.name
+	`, bucketID, bucketID, ownerID, ownerID).Scan(&out).Error
+	return out, err
+}
+
+// UserUsageCounters — счётчики, сложенные по владельцам бакетов (ownerID == 0 — все).
+func (db *DB) UserUsageCounters(ownerID uint) ([]UserUsageRow, error) {
+	var out []UserUsageRow
+	err := db.DB.Raw(`
+		SELECT bk.owner_id AS user_id, COALESCE(u.access_key_id, '') AS name, COUNT(*) AS buckets,
+		       COALESCE(SUM(c.objects), 0) AS objects, COALESCE(SUM(c.bytes), 0) AS bytes,
+		       COALESCE(SUM(c.trash_objects), 0) AS trash_objects, COALESCE(SUM(c.trash_bytes), 0) AS trash_bytes
+		FROM buckets bk
+		LEFT JOIN bucket_usage_counters c ON c.bucket_id = bk.id
+		LEFT JOIN users u ON u.id = bk.owner_id
+		WHERE (? = 0 OR bk.owner_id = ?)
+		GROUP BY bk.owner_id, u.access_key_id
+		ORDER BY bk.owner_id
+	`, ownerID, ownerID).Scan(&out).Error
+	return out, err
+}
+
+// RecountUsage пересчитывает счётчики по object_versions и корзине — если
+// они разошлись с таблицами (ручная правка базы). Полный обход, как у миграции.
+func (db *DB) RecountUsage() error {
+	return db.WithTxImmediate(db.recountUsageTx)
+}
+
+func (db *DB) recountUsageTx(tx *gorm.DB) error {
+	if err := tx.Where("1 = 1").Delete(&BucketUsageCounter{}).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`
+		INSERT INTO bucket_usage_counters (bucket_id, objects, bytes, trash_objects, trash_bytes, updated_at)
+		SELECT bk.id,
+		       (SELECT COUNT(*) FROM object_versions v WHERE v.bucket_id = bk.id AND v.blob_id IS NOT NULL),
+		       (SELECT COALESCE(SUM(v.size), 0) FROM object_versions v WHERE v.bucket_id = bk.id AND v.blob_id IS NOT NULL),
+		       (SELECT COUNT(*) FROM trashed_versions t WHERE t.bucket_id = bk.id),
+		       (SELECT COALESCE(SUM(t.size), 0) FROM trashed_versions t WHERE t.bucket_id = bk.id),
+		       ?
+		FROM buckets bk
+	`, time.Now().UTC()).Error
+}
+
+// migrateUsageCounters — таблица счётчиков, заполненная по текущим версиям.
+func (db *DB) migrateUsageCounters(tx *gorm.DB) error {
+	if !tx.Migrator().HasTable(&BucketUsageCounter{}) {
+		if err := tx.Migrator().CreateTable(&BucketUsageCounter{}); err != nil {
+			return err
+		}
+	}
+	return db.recountUsageTx(tx)
+}
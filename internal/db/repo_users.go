@@ -2,27 +2,52 @@ package db
 
 import (
 	"errors"
+	"fmt"
 
 	"gorm.io/gorm"
 )
 
+// EnsureUser — найти пользователя по ключу доступа или создать нового с этим
+// ключом (бутстрап админа из окружения).
 func (db *DB) EnsureUser(accessKeyID, secret string) (uint, error) {
-	var u User
-	if err := db.Where("access_key_id = ?", accessKeyID).Take(&u).Error; err == nil {
-		return u.ID, nil
+	var k AccessKey
+	if err := db.Where("access_key_id = ?", accessKeyID).Take(&k).Error; err == nil {
+		return k.UserID, nil
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return 0, err
 	}
-	u = User{AccessKeyID: accessKeyID, SecretAccessKey: secret, Status: "active"}
-	if err := db.Create(&u).Error; err != nil {
+	u, err := db.CreateUser(accessKeyID, secret, RoleUser)
+	if err != nil {
 		return 0, err
 	}
 	return u.ID, nil
 }
 
+// CreateUser — пользователь с первым ключом доступа; ID ключа становится его именем.
+func (db *DB) CreateUser(accessKeyID, secret, role string) (*User, error) {
+	sealed, err := db.secrets.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	u := User{AccessKeyID: accessKeyID, Status: "active", Role: role}
+	err = db.WithTx(func(tx *gorm.DB) error {
+		if err := tx.Create(&u).Error; err != nil {
+			return err
+		}
+		return tx.Create(&AccessKey{AccessKeyID: accessKeyID, UserID: u.ID, SecretAccessKey: sealed, Status: "active"}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// FindUserByAccessKey — владелец активного ключа, если сам пользователь активен.
 func (db *DB) FindUserByAccessKey(id string) (*User, error) {
 	var u User
-	if err := db.Where("access_key_id = ? AND status = 'active'", id).Take(&u).Error; err != nil {
+	if err := db.Joins("JOIN access_keys ak ON ak.user_id = users.id").
+		Where("ak.access_key_id = ? AND ak.status = 'active' AND users.status = 'active'", id).
+		Take(&u).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
@@ -41,3 +66,159 @@ func (db *DB) FindUserByID(id uint) (*User, error) {
 	}
 	return &u, nil
 }
+
+func (db *DB) ListUsers() ([]User, error) {
+	var out []User
+	err := db.Order("id").Find(&out).Error
+	return out, err
+}
+
+// UpdateUser — status (active|disabled) и role.
+func (db *DB) UpdateUser(id uint, fields map[string]any) error {
+	res := db.Model(&User{}).Where("id = ?", id).Updates(fields)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// SetUserRole — роль пользователя (user|admin) по access key.
+func (db *DB) SetUserRole(accessKeyID, role string) error {
+	res := db.Model(&User{}).Where("access_key_id = ?", accessKeyID).Update("role", role)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AccessKeySecret возвращает расшифрованный секрет активного ключа (нужен SigV4 в сыром виде).
+func (db *DB) AccessKeySecret(accessKeyID string) (string, error) {
+	if _, err := db.FindUserByAccessKey(accessKeyID); err != nil {
+		return "", err
+	}
+	var k AccessKey
+	if err := db.Where("access_key_id = ?", accessKeyID).Take(&k).Error; err != nil {
+		return "", err
+	}
+	return db.secrets.Open(k.SecretAccessKey)
+}
+
+func (db *DB) ListAccessKeys(userID uint) ([]AccessKey, error) {
+	var out []AccessKey
+	err := db.Where("user_id = ?", userID).Order("created_at, access_key_id").Find(&out).Error
+	return out, err
+}
+
+// FindAccessKey — ключ по ID (вместе с политикой, без расшифровки секрета).
+func (db *DB) FindAccessKey(accessKeyID string) (*AccessKey, error) {
+	var k AccessKey
+	if err := db.Where("access_key_id = ?", accessKeyID).Take(&k).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &k, nil
+}
+
+// CountActiveAccessKeys — активные ключи с полными правами; ограниченные
+// политикой ключи приложений в лимит не входят.
+func (db *DB) CountActiveAccessKeys(userID uint) (int64, error) {
+	var n int64
+	err := db.Model(&AccessKey{}).Where("user_id = ? AND status = 'active' AND policy = ''", userID).Count(&n).Error
+	return n, err
+}
+
+// CreateAccessKey выдаёт пользователю новый ключ с политикой keyPolicy (пусто —
+// без ограничений); disableID, если задан, в той же транзакции отключается (ротация).
+func (db *DB) CreateAccessKey(userID uint, accessKeyID, secret, keyPolicy, disableID string) error {
+	sealed, err := db.secrets.Seal(secret)
+	if err != nil {
+		return err
+	}
+	return db.WithTx(func(tx *gorm.DB) error {
+		if disableID != "" {
+			res := tx.Model(&AccessKey{}).Where("access_key_id = ? AND user_id = ?", disableID, userID).Update("status", "disabled")
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return ErrNotFound
+			}
+		}
+		return tx.Create(&AccessKey{AccessKeyID: accessKeyID, UserID: userID, SecretAccessKey: sealed, Status: "active", Policy: keyPolicy}).Error
+	})
+}
+
+func (db *DB) SetAccessKeyStatus(userID uint, accessKeyID, status string) error {
+	res := db.Model(&AccessKey{}).Where("access_key_id = ? AND user_id = ?", accessKeyID, userID).Update("status", status)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *DB) DeleteAccessKey(userID uint, accessKeyID string) error {
+	res := db.Where("access_key_id = ? AND user_id = ?", accessKeyID, userID).Delete(&AccessKey{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// migrateAccessKeys — миграция: ключи, которые раньше жили в строке users,
+// переезжают в access_keys. Идемпотентна.
+func (db *DB) migrateAccessKeys(tx *gorm.DB) error {
+	if err := tx.Exec(`INSERT INTO access_keys (access_key_id, user_id, secret_access_key, status, created_at)
+		SELECT access_key_id, id, secret_access_key, 'active', created_at FROM users
+		WHERE secret_access_key <> '' AND access_key_id NOT IN (SELECT access_key_id FROM access_keys)`).Error; err != nil {
+		return err
+	}
+	return tx.Model(&User{}).Where("secret_access_key <> ''").Update("secret_access_key", "").Error
+}
+
+// SealPlaintextSecrets — миграция: шифрует ключи, записанные до появления
+// мастер-ключа, и перешифровывает запечатанные прежним (MASTER_KEY_PREVIOUS).
+// Идемпотентна; без ключа ничего не делает.
+func (db *DB) SealPlaintextSecrets() (int, error) {
+	if db.secrets == nil {
+		return 0, nil
+	}
+	var keys []AccessKey
+	if err := db.Find(&keys).Error; err != nil {
+		return 0, err
+	}
+	sealedCnt := 0
+	for _, k := range keys {
+		sealed, changed, err := db.secrets.Reseal(k.SecretAccessKey)
+		if err != nil {
+			return sealedCnt, fmt.Errorf("access key %s: %w", k.AccessKeyID, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := db.Model(&AccessKey{}).Where("access_key_id = ? AND secret_access_key = ?", k.AccessKeyID, k.SecretAccessKey).
+			Update("secret_access_key", sealed).Error; err != nil {
+			return sealedCnt, err
+		}
+		sealedCnt++
+	}
+	return sealedCnt, nil
+}
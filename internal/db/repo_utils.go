@@ -10,7 +10,6 @@ import (
 
 var ErrNotFound = errors.New("not found")
 var ErrBucketNotEmpty = errors.New("bucket not empty")
-var ErrInvalidContToken = errors.New("can't validate continuation token")
 var ErrAccessDenied = errors.New("access denied")
 
 func genHex(n int) string {
@@ -34,6 +33,8 @@ func (db *DB) WithTx(fn func(tx *gorm.DB) error) error {
 }
 
 // ВАЖНО: не используй gorm.Transaction внутри этой функции для sqlite!
+// Строки, которые транзакция меняет, берутся под LockObjectForUpdate: в
+// PostgreSQL это SELECT ... FOR UPDATE, в SQLite — блокировка записи всей БД.
 func (db *DB) WithTxImmediate(fn func(tx *gorm.DB) error) error {
 	return db.DB.Transaction(func(tx *gorm.DB) error {
 		return fn(tx)
@@ -25,23 +25,47 @@ func (db *DB) InsertObjectVersionTx(tx *gorm.DB, bucketID uint, key, versionID,
 		BlobID: &blobID, Size: &size, ETag: &etag, ContentType: &contentType,
 		IsDelete: false,
 	}
-	return tx.Create(&ver).Error
+	if err := tx.Create(&ver).Error; err != nil {
+		return err
+	}
+	if err := db.refBlobTx(tx, blobID, 1); err != nil {
+		return err
+	}
+	if err := db.addUsageTx(tx, bucketID, usageDelta{objects: 1, bytes: size}); err != nil {
+		return err
+	}
+	return recordChangeTx(tx, &ChangeEvent{Type: ChangeObjectCreated, BucketID: bucketID, Key: key,
+		VersionID: versionID, ETag: etag, Size: &size})
+}
+
+// UpdateVersionFieldsTx — частичное обновление колонок версии (доп. атрибуты после commit).
+func (db *DB) UpdateVersionFieldsTx(tx *gorm.DB, versionID string, fields map[string]any) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return tx.Model(&ObjectVersion{}).Where("version_id = ?", versionID).Updates(fields).Error
 }
 
 func (db *DB) CreateDeleteMarkerTx(tx *gorm.DB, bucketID uint, key, versionID string) error {
 	ver := ObjectVersion{VersionID: versionID, BucketID: bucketID, Key: key, IsDelete: true}
-	return tx.Create(&ver).Error
+	if err := tx.Create(&ver).Error; err != nil {
+		return err
+	}
+	return recordChangeTx(tx, &ChangeEvent{Type: ChangeDeleteMarkerCreated, BucketID: bucketID, Key: key, VersionID: versionID})
 }
 
 func (db *DB) SetHeadVersionTx(tx *gorm.DB, bucketID uint, key, versionID string) error {
-	return tx.Model(&Object{}).
-		Where("bucket_id = ? AND key = ?", bucketID, key).
-		Update("head_version_id", versionID).Error
+	if err := tx.Model(&Object{}).
+		Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).
+		Update("head_version_id", versionID).Error; err != nil {
+		return err
+	}
+	return recordChangeTx(tx, &ChangeEvent{Type: ChangeHeadChanged, BucketID: bucketID, Key: key, VersionID: versionID})
 }
 
 func (db *DB) GetHeadVersionTx(tx *gorm.DB, bucketID uint, key string) (*ObjectVersion, error) {
 	var obj Object
-	if err := tx.Where("bucket_id = ? AND key = ?", bucketID, key).First(&obj).Error; err != nil {
+	if err := tx.Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).First(&obj).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
@@ -57,6 +81,96 @@ func (db *DB) GetHeadVersionTx(tx *gorm.DB, bucketID uint, key string) (*ObjectV
 	return &ver, nil
 }
 
+// TouchVersionTx — повторная загрузка тех же байт при компакции: версия
+// остаётся прежней, но Last-Modified сдвигается на момент загрузки.
+func (db *DB) TouchVersionTx(tx *gorm.DB, versionID string) error {
+	return tx.Model(&ObjectVersion{}).Where("version_id = ?", versionID).
+		Update("created_at", time.Now().UTC()).Error
+}
+
+// SameVersionContent — версии неотличимы для клиента, кроме тегов (их сравнивают
+// отдельно): те же байты, Content-Type, контекст шифрования, x-amz-meta-*, ACL,
+// класс хранения и алгоритм checksum. Тот же набор полей сравнивает
+// ListCompactableVersions — при добавлении поля менять оба места.
+func SameVersionContent(a, b *ObjectVersion) bool {
+	str := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return *p
+	}
+	acl := func(s string) string {
+		if s == "" {
+			return ACLPrivate
+		}
+		return s
+	}
+	return !a.IsDelete && !b.IsDelete && a.BlobID != nil && b.BlobID != nil && *a.BlobID == *b.BlobID &&
+		str(a.ContentType) == str(b.ContentType) && str(a.EncryptionContext) == str(b.EncryptionContext) &&
+		str(a.UserMetadata) == str(b.UserMetadata) && acl(a.ACL) == acl(b.ACL) &&
+		a.StorageClass == b.StorageClass && a.ChecksumAlgorithm == b.ChecksumAlgorithm
+}
+
+// ListCompactableVersions — версии, за которыми сразу следует версия того же ключа,
+// равная ей по SameVersionContent и с теми же тегами (старшую из пары можно удалить
+// без потери данных).
+func (db *DB) ListCompactableVersions(bucketID uint, limit int) ([]ObjectVersion, error) {
+	var vers []ObjectVersion
+	k := db.quote("key")
+	err := db.DB.Raw(`
+		SELECT version_id, bucket_id, `+k+`, blob_id
+		FROM (
+			SELECT v.version_id, v.bucket_id, `+db.quote("v.key")+`, v.blob_id, v.content_type, v.is_delete,
+			       v.encryption_context, v.user_metadata, v.acl, v.storage_class, v.checksum_algorithm,
+			       LEAD(v.version_id)         OVER w AS next_ver,
+			       LEAD(v.blob_id)            OVER w AS next_blob,
+			       LEAD(v.content_type)       OVER w AS next_ct,
+			       LEAD(v.is_delete)          OVER w AS next_del,
+			       LEAD(v.encryption_context) OVER w AS next_enc,
+			       LEAD(v.user_metadata)      OVER w AS next_meta,
+			       LEAD(v.acl)                OVER w AS next_acl,
+			       LEAD(v.storage_class)      OVER w AS next_class,
+			       LEAD(v.checksum_algorithm) OVER w AS next_ck
+			FROM object_versions v
+			WHERE v.bucket_id = ?
+			WINDOW w AS (PARTITION BY `+db.quote("v.key")+` ORDER BY v.created_at, v.version_id)
+		) t
+		WHERE t.is_delete = FALSE AND t.next_del = FALSE
+		  AND t.blob_id = t.next_blob AND COALESCE(t.content_type, '') = COALESCE(t.next_ct, '')
+		  AND COALESCE(t.encryption_context, '') = COALESCE(t.next_enc, '')
+		  AND COALESCE(t.user_metadata, '') = COALESCE(t.next_meta, '')
+		  AND COALESCE(NULLIF(t.acl, ''), 'private') = COALESCE(NULLIF(t.next_acl, ''), 'private')
+		  AND t.storage_class = t.next_class AND t.checksum_algorithm = t.next_ck
+		  AND NOT EXISTS (
+			SELECT 1 FROM object_version_tags a
+			WHERE a.version_id = t.version_id AND NOT EXISTS (
+				SELECT 1 FROM object_version_tags b
+				WHERE b.version_id = t.next_ver AND b.`+k+` = a.`+k+` AND b.value = a.value))
+		  AND NOT EXISTS (
+			SELECT 1 FROM object_version_tags b
+			WHERE b.version_id = t.next_ver AND NOT EXISTS (
+				SELECT 1 FROM object_version_tags a
+				WHERE a.version_id = t.version_id AND a.`+k+` = b.`+k+` AND a.value = b.value))
+		LIMIT ?
+	`, bucketID, limit).Scan(&vers).Error
+	return vers, err
+}
+
+// GetNextVersionTx — версия того же ключа, следующая за ver в порядке окна
+// ListCompactableVersions (created_at, затем version_id).
+func (db *DB) GetNextVersionTx(tx *gorm.DB, ver *ObjectVersion) (*ObjectVersion, error) {
+	var next ObjectVersion
+	err := tx.Where("bucket_id = ? AND "+db.quote("key")+" = ?", ver.BucketID, ver.Key).
+		Where(`created_at > (SELECT c.created_at FROM object_versions c WHERE c.version_id = ?)
+			OR (created_at = (SELECT c.created_at FROM object_versions c WHERE c.version_id = ?) AND version_id > ?)`,
+			ver.VersionID, ver.VersionID, ver.VersionID).
+		Order("created_at, version_id").First(&next).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	return &next, err
+}
+
 func (db *DB) GetVersionTx(tx *gorm.DB, versionID string) (*ObjectVersion, error) {
 	var ver ObjectVersion
 	err := tx.Where("version_id = ?", versionID).First(&ver).Error
@@ -66,9 +180,43 @@ func (db *DB) GetVersionTx(tx *gorm.DB, versionID string) (*ObjectVersion, error
 	return &ver, err
 }
 
+// Статусы версионирования бакета.
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+	VersioningDisabled  = "Disabled" // расширение s3mini: без истории, перезапись на месте
+)
+
+// Режимы Object Lock: GOVERNANCE владелец может снять заголовком
+// x-amz-bypass-governance-retention, COMPLIANCE — никто до истечения срока.
+const (
+	LockGovernance = "GOVERNANCE"
+	LockCompliance = "COMPLIANCE"
+)
+
+// NullVersionPrefix — префикс ID версий, записанных при Suspended. Клиенту они
+// видны как "null" (как в S3), а в БД остаются уникальными.
+const NullVersionPrefix = "null-"
+
+// ListNullVersionsTx — null-версии ключа, новые первыми. Обычно одна; больше —
+// только если прежнюю держит окно защиты.
+func (db *DB) ListNullVersionsTx(tx *gorm.DB, bucketID uint, key string) ([]ObjectVersion, error) {
+	var out []ObjectVersion
+	err := tx.Where("bucket_id = ? AND "+db.quote("key")+" = ? AND version_id LIKE ?", bucketID, key, NullVersionPrefix+"%").
+		Order("created_at DESC").Find(&out).Error
+	return out, err
+}
+
+// ListKeyVersionsTx — все версии ключа (включая delete-marker'ы).
+func (db *DB) ListKeyVersionsTx(tx *gorm.DB, bucketID uint, key string) ([]ObjectVersion, error) {
+	var out []ObjectVersion
+	err := tx.Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).Order("created_at DESC").Find(&out).Error
+	return out, err
+}
+
 func (db *DB) GetPrevVersionTx(tx *gorm.DB, bucketID uint, key, currentVersionID string) (*ObjectVersion, error) {
 	var ver ObjectVersion
-	err := tx.Where("bucket_id = ? AND key = ? AND version_id <> ?", bucketID, key, currentVersionID).
+	err := tx.Where("bucket_id = ? AND "+db.quote("key")+" = ? AND version_id <> ?", bucketID, key, currentVersionID).
 		Order("created_at DESC").First(&ver).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, ErrNotFound
@@ -77,12 +225,34 @@ func (db *DB) GetPrevVersionTx(tx *gorm.DB, bucketID uint, key, currentVersionID
 }
 
 func (db *DB) DeleteVersionTx(tx *gorm.DB, versionID string) error {
-	return tx.Delete(&ObjectVersion{VersionID: versionID}).Error
+	var ver ObjectVersion
+	err := tx.Select("version_id", "bucket_id", "key", "blob_id", "size").Where("version_id = ?", versionID).Take(&ver).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Delete(&ObjectVersion{VersionID: versionID}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("version_id = ?", versionID).Delete(&ObjectVersionTag{}).Error; err != nil {
+		return err
+	}
+	if ver.BlobID != nil {
+		if err := db.refBlobTx(tx, *ver.BlobID, -1); err != nil {
+			return err
+		}
+	}
+	if err := db.addUsageTx(tx, ver.BucketID, versionUsage(ver.BlobID, ver.Size, -1)); err != nil {
+		return err
+	}
+	return recordChangeTx(tx, &ChangeEvent{Type: ChangeVersionDeleted, BucketID: ver.BucketID, Key: ver.Key, VersionID: versionID})
 }
 
 func (db *DB) CreateVersionTx(tx *gorm.DB, bucketID uint, key, versionID, blobID string,
 	size int64, etag, contentType string) error {
-	return tx.Create(&ObjectVersion{
+	if err := tx.Create(&ObjectVersion{
 		VersionID:   versionID,
 		BucketID:    bucketID,
 		Key:         key,
@@ -91,16 +261,30 @@ func (db *DB) CreateVersionTx(tx *gorm.DB, bucketID uint, key, versionID, blobID
 		ETag:        &etag,
 		ContentType: &contentType,
 		IsDelete:    false,
-	}).Error
+	}).Error; err != nil {
+		return err
+	}
+	if err := db.refBlobTx(tx, blobID, 1); err != nil {
+		return err
+	}
+	return db.addUsageTx(tx, bucketID, usageDelta{objects: 1, bytes: size})
 }
 
 func (db *DB) CreateVersion(bucketID uint, key, versionID, blobID string,
 	size int64, etag, contentType string) error {
-	return db.Create(&ObjectVersion{
-		VersionID: versionID, BucketID: bucketID, Key: key,
-		BlobID: &blobID, Size: &size, ETag: &etag, ContentType: &contentType,
-		IsDelete: false, CreatedAt: time.Now().UTC(),
-	}).Error
+	return db.WithTx(func(tx *gorm.DB) error {
+		if err := tx.Create(&ObjectVersion{
+			VersionID: versionID, BucketID: bucketID, Key: key,
+			BlobID: &blobID, Size: &size, ETag: &etag, ContentType: &contentType,
+			IsDelete: false, CreatedAt: time.Now().UTC(),
+		}).Error; err != nil {
+			return err
+		}
+		if err := db.refBlobTx(tx, blobID, 1); err != nil {
+			return err
+		}
+		return db.addUsageTx(tx, bucketID, usageDelta{objects: 1, bytes: size})
+	})
 }
 
 func (db *DB) CreateDeleteMarker(bucketID uint, key, versionID string) error {
@@ -113,7 +297,7 @@ func (db *DB) CreateDeleteMarker(bucketID uint, key, versionID string) error {
 
 func (db *DB) GetHeadVersion(bucketID uint, key string) (*VersionMeta, error) {
 	var o Object
-	if err := db.Where("bucket_id=? AND `key`=?", bucketID, key).Take(&o).Error; err != nil {
+	if err := db.Where("bucket_id = ? AND "+db.quote("key")+" = ?", bucketID, key).Take(&o).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
@@ -141,7 +325,25 @@ func (db *DB) GetVersion(versionID string) (*VersionMeta, error) {
 }
 
 func (db *DB) DeleteVersion(versionID string) error {
-	return db.Delete(&ObjectVersion{}, "version_id = ?", versionID).Error
+	return db.WithTx(func(tx *gorm.DB) error {
+		var ver ObjectVersion
+		err := tx.Select("version_id", "bucket_id", "blob_id", "size").Where("version_id = ?", versionID).Take(&ver).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&ObjectVersion{}, "version_id = ?", versionID).Error; err != nil {
+			return err
+		}
+		if ver.BlobID != nil {
+			if err := db.refBlobTx(tx, *ver.BlobID, -1); err != nil {
+				return err
+			}
+		}
+		return db.addUsageTx(tx, ver.BucketID, versionUsage(ver.BlobID, ver.Size, -1))
+	})
 }
 
 func (db *DB) BlobRefCountFromVersions(blobID string) (int64, error) {
@@ -153,7 +355,7 @@ func (db *DB) BlobRefCountFromVersions(blobID string) (int64, error) {
 func (db *DB) GetPrevVersion(bucketID uint, key, excludeVersionID string) (*VersionMeta, error) {
 	var v ObjectVersion
 	err := db.
-		Where("bucket_id=? AND `key`=? AND version_id <> ?", bucketID, key, excludeVersionID).
+		Where("bucket_id = ? AND "+db.quote("key")+" = ? AND version_id <> ?", bucketID, key, excludeVersionID).
 		Order("created_at DESC").
 		Take(&v).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -168,3 +370,33 @@ func (db *DB) GetPrevVersion(bucketID uint, key, excludeVersionID string) (*Vers
 		IsDelete: v.IsDelete, CreatedAt: v.CreatedAt,
 	}, nil
 }
+
+// VersionListItem — строка ListObjectVersions; IsLatest — версия является HEAD ключа.
+type VersionListItem struct {
+	ObjectVersion
+	IsLatest bool
+}
+
+// ListObjectVersions — версии бакета в порядке ListObjectVersions (key по возрастанию,
+// внутри ключа — от новых к старым) строго после позиции: after — последняя отданная
+// версия ключа afterKey; after == nil — после всех версий afterKey.
+func (db *DB) ListObjectVersions(bucketID uint, prefix, afterKey string, after *ObjectVersion, limit int) ([]VersionListItem, error) {
+	k := db.quote("ov.key")
+	q := db.DB.Table("object_versions AS ov").
+		Select("ov.*, CASE WHEN o.head_version_id = ov.version_id THEN 1 ELSE 0 END AS is_latest").
+		Joins("LEFT JOIN objects o ON o.bucket_id = ov.bucket_id AND "+db.quote("o.key")+" = "+k).
+		Where("ov.bucket_id = ?", bucketID)
+	if prefix != "" {
+		q = q.Where(db.likePrefix(k, prefix))
+	}
+	switch {
+	case after != nil:
+		q = q.Where(k+" > ? OR ("+k+" = ? AND (ov.created_at < ? OR (ov.created_at = ? AND ov.version_id < ?)))",
+			afterKey, afterKey, after.CreatedAt, after.CreatedAt, after.VersionID)
+	case afterKey != "":
+		q = q.Where(k+" > ?", afterKey)
+	}
+	var out []VersionListItem
+	err := q.Order(k).Order("ov.created_at DESC").Order("ov.version_id DESC").Limit(limit).Scan(&out).Error
+	return out, err
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"github.com/glebarez/sqlite" // 👈 вместо gorm.io/driver/sqlite
+	"gorm.io/gorm"
+)
+
+// OpenSQLite — драйвер на чистом Go при любом CGO_ENABLED: параметры DSN — в его
+// синтаксисе (см. DSN), а сборка с cgo нужна хотя бы для go test -race.
+func OpenSQLite(path string) (*DB, error) {
+	g, err := gorm.Open(sqlite.Open((&DB{}).DSN(path)), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	db := New(g)
+	if err := db.initAutoVacuum(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
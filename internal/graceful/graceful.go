@@ -0,0 +1,116 @@
+// Package graceful — перезапуск без простоя: новый процесс наследует
+// слушающие сокеты старого, а старый после сигнала готовности дообслуживает
+// текущие запросы и выходит.
+//
+// Сценарий: SIGUSR2 старому процессу -> Upgrade() запускает новый бинарник с
+// сокетами начиная с fd 3 -> новый поднимается и вызывает NotifyParentReady() (SIGTERM
+// родителю) -> родитель делает http.Server.Shutdown и отдаёт lease'ы воркеров.
+package graceful
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	envListenFDs = "S3MINI_LISTEN_FDS" // addr=fd,addr=fd
+	envParentPID = "S3MINI_PARENT_PID"
+)
+
+// Listen возвращает унаследованный от родителя сокет для addr или открывает новый.
+func Listen(addr string) (ln net.Listener, inherited bool, err error) {
+	for _, kv := range strings.Split(os.Getenv(envListenFDs), ",") {
+		a, v, ok := strings.Cut(kv, "=")
+		if !ok || a != addr {
+			continue
+		}
+		fd, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", envListenFDs, err)
+		}
+		f := os.NewFile(uintptr(fd), "listener")
+		ln, err := net.FileListener(f)
+		_ = f.Close() // FileListener делает dup
+		if err != nil {
+			return nil, false, err
+		}
+		return ln, true, nil
+	}
+	ln, err = net.Listen("tcp", addr)
+	return ln, false, err
+}
+
+// Upgrade запускает текущий бинарник заново, передавая ему сокеты (addr -> listener).
+// Старый процесс продолжает обслуживать запросы, пока новый не сообщит о готовности.
+func Upgrade(lns map[string]net.Listener) (*os.Process, error) {
+	var (
+		files []*os.File
+		fds   []string
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for addr, ln := range lns {
+		tl, ok := ln.(*net.TCPListener)
+		if !ok {
+			return nil, errors.New("graceful: listener is not TCP")
+		}
+		f, err := tl.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		fds = append(fds, fmt.Sprintf("%s=%d", addr, 2+len(files))) // ExtraFiles начинаются с fd 3
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(withoutEnv(os.Environ(), envListenFDs, envParentPID),
+		envListenFDs+"="+strings.Join(fds, ","),
+		envParentPID+"="+strconv.Itoa(os.Getpid()),
+	)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// NotifyParentReady — новый процесс готов принимать запросы: родитель уходит в drain.
+func NotifyParentReady() error {
+	v := os.Getenv(envParentPID)
+	if v == "" {
+		return nil
+	}
+	pid, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+func withoutEnv(env []string, keys ...string) []string {
+	out := env[:0:0]
+next:
+	for _, kv := range env {
+		for _, k := range keys {
+			if len(kv) > len(k) && kv[:len(k)+1] == k+"=" {
+				continue next
+			}
+		}
+		out = append(out, kv)
+	}
+	return out
+}
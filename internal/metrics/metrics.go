@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Минимальный реестр метрик в текстовом формате Prometheus — без внешних зависимостей.
+
+type metric interface {
+	write(sb *strings.Builder)
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+var Default = &Registry{metrics: map[string]metric{}}
+
+func (r *Registry) register(name string, m metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.metrics[name]; ok {
+		return old
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// Handler отдаёт все метрики реестра.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		r.mu.Lock()
+		names := make([]string, 0, len(r.metrics))
+		for n := range r.metrics {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var sb strings.Builder
+		for _, n := range names {
+			r.metrics[n].write(&sb)
+		}
+		r.mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(sb.String()))
+	})
+}
+
+func Handler() http.Handler { return Default.Handler() }
+
+// ---------------- Counter ----------------
+
+type Counter struct {
+	name, help string
+	v          atomic.Uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	return Default.register(name, &Counter{name: name, help: help}).(*Counter)
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+func (c *Counter) write(sb *strings.Builder) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.v.Load())
+}
+
+// ---------------- Gauge ----------------
+
+type Gauge struct {
+	name, help string
+	bits       atomic.Uint64
+}
+
+func NewGauge(name, help string) *Gauge {
+	return Default.register(name, &Gauge{name: name, help: help}).(*Gauge)
+}
+
+func (g *Gauge) Set(v float64)  { g.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+func (g *Gauge) write(sb *strings.Builder) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, g.Value())
+}
+
+// ---------------- Vec (метрики с метками) ----------------
+
+type vecValue struct {
+	labels string
+	v      atomic.Uint64 // counter: uint64; gauge: float64 bits
+}
+
+type vec struct {
+	name, help, typ string
+	labelNames      []string
+	mu              sync.Mutex
+	values          map[string]*vecValue
+}
+
+func (v *vec) get(values []string) *vecValue {
+	if len(values) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d labels, got %d", v.name, len(v.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if vv, ok := v.values[key]; ok {
+		return vv
+	}
+	parts := make([]string, len(values))
+	for i, lv := range values {
+		parts[i] = fmt.Sprintf("%s=%q", v.labelNames[i], lv)
+	}
+	vv := &vecValue{labels: "{" + strings.Join(parts, ",") + "}"}
+	v.values[key] = vv
+	return vv
+}
+
+func (v *vec) write(sb *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.typ)
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vv := v.values[k]
+		if v.typ == "counter" {
+			fmt.Fprintf(sb, "%s%s %d\n", v.name, vv.labels, vv.v.Load())
+		} else {
+			fmt.Fprintf(sb, "%s%s %g\n", v.name, vv.labels, math.Float64frombits(vv.v.Load()))
+		}
+	}
+}
+
+type CounterVec struct{ v *vec }
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	m := Default.register(name, &vec{name: name, help: help, typ: "counter", labelNames: labelNames, values: map[string]*vecValue{}})
+	return &CounterVec{v: m.(*vec)}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) { c.v.get(labelValues).v.Add(1) }
+func (c *CounterVec) Add(n uint64, labelValues ...string) {
+	c.v.get(labelValues).v.Add(n)
+}
+
+type GaugeVec struct{ v *vec }
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	m := Default.register(name, &vec{name: name, help: help, typ: "gauge", labelNames: labelNames, values: map[string]*vecValue{}})
+	return &GaugeVec{v: m.(*vec)}
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.v.get(labelValues).v.Store(math.Float64bits(v))
+}
@@ -0,0 +1,285 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Kafka: Metadata v4 (лидер партиции) и Produce v3 с RecordBatch v2 — самые
+// старые версии, которые принимает Kafka 4.x.
+const (
+	apiProduce  = 0
+	apiMetadata = 3
+
+	kafkaClientID = "s3mini"
+	maxKafkaFrame = 64 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ValidKafkaTopic — имя топика по правилам Kafka.
+func ValidKafkaTopic(s string) bool {
+	if s == "" || len(s) > 249 || s == "." || s == ".." {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// KafkaError — ненулевой error_code из ответа брокера.
+type KafkaError int16
+
+func (e KafkaError) Error() string { return "kafka error code " + strconv.Itoa(int(e)) }
+
+// PublishKafka пишет одно сообщение (key, payload) в topic с acks=all.
+// Партиция — по хэшу key, чтобы события одного объекта шли по порядку.
+// brokers — bootstrap-адреса host:port, пробуются по очереди.
+func PublishKafka(ctx context.Context, brokers []string, topic string, key, payload []byte) error {
+	var lastErr error = errors.New("kafka: no brokers")
+	for _, b := range brokers {
+		leader, partition, err := kafkaLeader(ctx, b, topic, key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return kafkaProduce(ctx, leader, topic, partition, key, payload)
+	}
+	return lastErr
+}
+
+func kafkaLeader(ctx context.Context, broker, topic string, key []byte) (addr string, partition int32, err error) {
+	var req kbuf
+	req.array(1)
+	req.str(topic)
+	req.i8(0) // allow_auto_topic_creation
+	resp, err := kafkaCall(ctx, broker, apiMetadata, 4, req)
+	if err != nil {
+		return "", 0, err
+	}
+	r := kreader{b: resp}
+	r.i32() // throttle_time_ms
+	hosts := map[int32]string{}
+	for n := r.i32(); n > 0; n-- {
+		id, host, port := r.i32(), r.str(), r.i32()
+		r.str() // rack
+		hosts[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.str() // cluster_id
+	r.i32() // controller_id
+	var leaders []int32
+	for n := r.i32(); n > 0; n-- {
+		code := r.i16()
+		name := r.str()
+		r.i8() // is_internal
+		parts := map[int32]int32{}
+		for p := r.i32(); p > 0; p-- {
+			r.i16() // error_code партиции
+			idx, leader := r.i32(), r.i32()
+			r.skipInt32Array() // replicas
+			r.skipInt32Array() // isr
+			parts[idx] = leader
+		}
+		if name != topic {
+			continue
+		}
+		if code != 0 {
+			return "", 0, fmt.Errorf("kafka: metadata for %q: %w", topic, KafkaError(code))
+		}
+		leaders = make([]int32, len(parts))
+		for idx, l := range parts {
+			if int(idx) < len(leaders) {
+				leaders[idx] = l
+			}
+		}
+	}
+	if r.err != nil {
+		return "", 0, fmt.Errorf("kafka: bad metadata response: %w", r.err)
+	}
+	if len(leaders) == 0 {
+		return "", 0, fmt.Errorf("kafka: topic %q has no partitions", topic)
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	partition = int32(h.Sum32() % uint32(len(leaders)))
+	addr, ok := hosts[leaders[partition]]
+	if !ok {
+		return "", 0, fmt.Errorf("kafka: no leader for %s/%d", topic, partition)
+	}
+	return addr, partition, nil
+}
+
+func kafkaProduce(ctx context.Context, broker, topic string, partition int32, key, payload []byte) error {
+	batch := recordBatch(key, payload, time.Now())
+	var req kbuf
+	req.i16(-1) // transactional_id = null
+	req.i16(-1) // acks = all
+	req.i32(int32(dialTimeout / time.Millisecond))
+	req.array(1)
+	req.str(topic)
+	req.array(1)
+	req.i32(partition)
+	req.i32(int32(len(batch)))
+	req.b = append(req.b, batch...)
+	resp, err := kafkaCall(ctx, broker, apiProduce, 3, req)
+	if err != nil {
+		return err
+	}
+	r := kreader{b: resp}
+	for n := r.i32(); n > 0; n-- {
+		r.str()
+		for p := r.i32(); p > 0; p-- {
+			r.i32()
+			if code := r.i16(); code != 0 && r.err == nil {
+				return fmt.Errorf("kafka: produce to %s/%d: %w", topic, partition, KafkaError(code))
+			}
+			r.i64() // base_offset
+			r.i64() // log_append_time
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("kafka: bad produce response: %w", r.err)
+	}
+	return nil
+}
+
+// recordBatch — RecordBatch v2 из одной записи без заголовков и сжатия.
+func recordBatch(key, value []byte, ts time.Time) []byte {
+	var rec kbuf
+	rec.i8(0)     // attributes
+	rec.varint(0) // timestamp delta
+	rec.varint(0) // offset delta
+	rec.varint(int64(len(key)))
+	rec.b = append(rec.b, key...)
+	rec.varint(int64(len(value)))
+	rec.b = append(rec.b, value...)
+	rec.varint(0) // headers
+
+	// от attributes до конца — под CRC32C
+	var body kbuf
+	ms := ts.UnixMilli()
+	body.i16(0) // attributes
+	body.i32(0) // last_offset_delta
+	body.i64(ms)
+	body.i64(ms)
+	body.i64(-1) // producer_id
+	body.i16(-1) // producer_epoch
+	body.i32(-1) // base_sequence
+	body.i32(1)
+	body.varint(int64(len(rec.b)))
+	body.b = append(body.b, rec.b...)
+
+	var out kbuf
+	out.i64(0)                              // base_offset
+	out.i32(int32(4 + 1 + 4 + len(body.b))) // batch_length
+	out.i32(-1)                             // partition_leader_epoch
+	out.i8(2)                               // magic
+	out.i32(int32(crc32.Checksum(body.b, castagnoli)))
+	out.b = append(out.b, body.b...)
+	return out.b
+}
+
+// kafkaCall — один запрос-ответ на отдельном соединении; тело ответа без
+// заголовка (correlation_id проверяется).
+func kafkaCall(ctx context.Context, broker string, apiKey, apiVersion int16, body kbuf) ([]byte, error) {
+	conn, err := dial(ctx, broker)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	defer conn.Close()
+
+	const corrID = 1
+	var hdr kbuf
+	hdr.i32(0) // размер, ниже
+	hdr.i16(apiKey)
+	hdr.i16(apiVersion)
+	hdr.i32(corrID)
+	hdr.str(kafkaClientID)
+	msg := append(hdr.b, body.b...)
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)-4))
+	if _, err := conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("kafka: write: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	var size [4]byte
+	if _, err := io.ReadFull(br, size[:]); err != nil {
+		return nil, fmt.Errorf("kafka: read: %w", err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > maxKafkaFrame {
+		return nil, fmt.Errorf("kafka: bad response size %d", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(br, resp); err != nil {
+		return nil, fmt.Errorf("kafka: read: %w", err)
+	}
+	if int32(binary.BigEndian.Uint32(resp)) != corrID {
+		return nil, errors.New("kafka: correlation id mismatch")
+	}
+	return resp[4:], nil
+}
+
+// kbuf — кодирование протокола Kafka (big-endian).
+type kbuf struct{ b []byte }
+
+func (k *kbuf) i8(v int8)   { k.b = append(k.b, byte(v)) }
+func (k *kbuf) i16(v int16) { k.b = binary.BigEndian.AppendUint16(k.b, uint16(v)) }
+func (k *kbuf) i32(v int32) { k.b = binary.BigEndian.AppendUint32(k.b, uint32(v)) }
+func (k *kbuf) i64(v int64) { k.b = binary.BigEndian.AppendUint64(k.b, uint64(v)) }
+func (k *kbuf) array(n int) { k.i32(int32(n)) }
+func (k *kbuf) str(s string) {
+	k.i16(int16(len(s)))
+	k.b = append(k.b, s...)
+}
+func (k *kbuf) varint(v int64) { k.b = binary.AppendVarint(k.b, v) }
+
+// kreader — разбор ответа; первая ошибка запоминается, дальше читаются нули.
+type kreader struct {
+	b   []byte
+	err error
+}
+
+func (r *kreader) take(n int) []byte {
+	if r.err != nil || n < 0 || len(r.b) < n {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return make([]byte, max(n, 0))
+	}
+	out := r.b[:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *kreader) i8() int8   { return int8(r.take(1)[0]) }
+func (r *kreader) i16() int16 { return int16(binary.BigEndian.Uint16(r.take(2))) }
+func (r *kreader) i32() int32 { return int32(binary.BigEndian.Uint32(r.take(4))) }
+func (r *kreader) i64() int64 { return int64(binary.BigEndian.Uint64(r.take(8))) }
+
+// str — string / nullable string (-1 — пустая строка)
+func (r *kreader) str() string {
+	n := r.i16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.take(int(n)))
+}
+
+func (r *kreader) skipInt32Array() {
+	if n := r.i32(); n > 0 {
+		r.take(int(n) * 4)
+	}
+}
@@ -0,0 +1,109 @@
+// Package notify — минимальные клиенты брокеров для уведомлений о событиях
+// бакета: публикация одного сообщения без внешних зависимостей.
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+
+// ValidNATSSubject — непустые токены через '.', без пробелов и wildcard'ов.
+func ValidNATSSubject(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t\r\n/") {
+		return false
+	}
+	for _, tok := range strings.Split(s, ".") {
+		if tok == "" || tok == "*" || tok == ">" {
+			return false
+		}
+	}
+	return true
+}
+
+// PublishNATS публикует payload в subject на сервере nats://[user:pass@|token@]host[:4222].
+// После PUB отправляется PING: PONG означает, что сервер принял сообщение
+// (core NATS, без JetStream-подтверждений).
+func PublishNATS(ctx context.Context, serverURL, subject string, payload []byte) error {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return fmt.Errorf("bad nats url %q", serverURL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := dial(ctx, host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("nats: read INFO: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	_ = json.Unmarshal([]byte(line[5:]), &info)
+	if info.TLSRequired {
+		return errors.New("nats: server requires TLS, not supported")
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "s3mini", "lang": "go", "protocol": 1}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"], opts["pass"] = u.User.Username(), pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	msg := fmt.Sprintf("CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", connect, subject, len(payload), payload)
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("nats: write: %w", err)
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("nats: read: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// dial — TCP-соединение с дедлайном из ctx (по умолчанию dialTimeout на всё).
+func dial(ctx context.Context, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(dialTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+	return conn, nil
+}
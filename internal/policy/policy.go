@@ -0,0 +1,487 @@
+// Package policy — политика бакета в формате IAM JSON: разбор, проверка и
+// вычисление решения для запроса.
+//
+// Поддерживаются Effect, Principal ("*" или {"AWS": [...]}), Action, Resource
+// и Condition. Action и Resource — шаблоны с '*' и '?'. Порядок как в AWS:
+// явный Deny сильнее любого Allow, без подходящего Allow — NoMatch.
+//
+// ParseIdentity разбирает политику, привязанную к ключу доступа (как
+// identity-based policy в IAM): без Principal и с ресурсами в любых бакетах.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxSize = 20 << 10
+
+// Decision — итог вычисления политики.
+type Decision int
+
+const (
+	NoMatch Decision = iota // ни одно утверждение не подошло
+	Allow
+	Deny
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Allow:
+		return "allow"
+	case Deny:
+		return "deny"
+	}
+	return "nomatch"
+}
+
+// Request — что известно о запросе. Principal — access key, "" для анонима.
+type Request struct {
+	Principal string
+	Action    string            // "s3:GetObject"
+	Resource  string            // "arn:aws:s3:::bucket/key"
+	Context   map[string]string // ключи условий в нижнем регистре: "aws:sourceip", "s3:prefix"
+}
+
+// Policy — разобранная и проверенная политика.
+type Policy struct {
+	Version    string
+	ID         string
+	Statements []Statement
+}
+
+type Statement struct {
+	Sid        string
+	Effect     string
+	Principals []string // "*" — любой, включая анонима
+	Actions    []string
+	Resources  []string
+	Conditions []Condition
+}
+
+// Condition — один оператор над одним ключом: значения объединяются по OR.
+type Condition struct {
+	Op       string
+	Key      string
+	Values   []string
+	IfExists bool
+}
+
+var condOps = map[string]bool{
+	"StringEquals": true, "StringNotEquals": true,
+	"StringEqualsIgnoreCase": true, "StringNotEqualsIgnoreCase": true,
+	"StringLike": true, "StringNotLike": true,
+	"NumericEquals": true, "NumericNotEquals": true,
+	"NumericLessThan": true, "NumericLessThanEquals": true,
+	"NumericGreaterThan": true, "NumericGreaterThanEquals": true,
+	"DateLessThan": true, "DateGreaterThan": true,
+	"Bool": true, "IpAddress": true, "NotIpAddress": true,
+	"Null": true,
+}
+
+// Parse разбирает JSON и проверяет, что все ресурсы относятся к bucket.
+func Parse(data []byte, bucket string) (*Policy, error) {
+	return parse(data, bucket, false)
+}
+
+// ParseIdentity разбирает политику ключа доступа: Principal запрещён (это
+// сам ключ), Resource — ARN любого бакета или "*".
+func ParseIdentity(data []byte) (*Policy, error) {
+	return parse(data, "", true)
+}
+
+func parse(data []byte, bucket string, identity bool) (*Policy, error) {
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("policy too large (max %d bytes)", MaxSize)
+	}
+	var raw struct {
+		Version   string
+		Id        string
+		Statement json.RawMessage
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid policy json: %v", err)
+	}
+	switch raw.Version {
+	case "2012-10-17", "2008-10-17":
+	default:
+		return nil, errors.New("policy Version must be 2012-10-17")
+	}
+	var stmts []json.RawMessage
+	if err := json.Unmarshal(raw.Statement, &stmts); err != nil {
+		// одиночное утверждение без массива
+		stmts = []json.RawMessage{raw.Statement}
+	}
+	if len(raw.Statement) == 0 || len(stmts) == 0 {
+		return nil, errors.New("policy has no statements")
+	}
+	p := &Policy{Version: raw.Version, ID: raw.Id}
+	for i, m := range stmts {
+		st, err := parseStatement(m, bucket, identity)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %v", i, err)
+		}
+		p.Statements = append(p.Statements, st)
+	}
+	return p, nil
+}
+
+func parseStatement(m json.RawMessage, bucket string, identity bool) (Statement, error) {
+	var raw struct {
+		Sid       string
+		Effect    string
+		Principal json.RawMessage
+		Action    json.RawMessage
+		Resource  json.RawMessage
+		Condition map[string]map[string]json.RawMessage
+	}
+	dec := json.NewDecoder(strings.NewReader(string(m)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return Statement{}, err
+	}
+	st := Statement{Sid: raw.Sid, Effect: raw.Effect}
+	if st.Effect != "Allow" && st.Effect != "Deny" {
+		return st, errors.New("Effect must be Allow or Deny")
+	}
+
+	var err error
+	if identity {
+		if len(raw.Principal) != 0 {
+			return st, errors.New("Principal is not allowed in an access key policy")
+		}
+		st.Principals = []string{"*"}
+	} else if st.Principals, err = parsePrincipal(raw.Principal); err != nil {
+		return st, err
+	}
+	if st.Actions, err = stringOrList(raw.Action); err != nil || len(st.Actions) == 0 {
+		return st, errors.New("Action must be a string or a list of strings")
+	}
+	for _, a := range st.Actions {
+		if a != "*" && !strings.HasPrefix(strings.ToLower(a), "s3:") {
+			return st, fmt.Errorf("unsupported action %q", a)
+		}
+	}
+	if st.Resources, err = stringOrList(raw.Resource); err != nil || len(st.Resources) == 0 {
+		return st, errors.New("Resource must be a string or a list of strings")
+	}
+	for _, res := range st.Resources {
+		if identity {
+			if res != "*" && !strings.HasPrefix(res, "arn:aws:s3:::") {
+				return st, fmt.Errorf("unsupported resource %q", res)
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(res, "arn:aws:s3:::"), "/")
+		if !strings.HasPrefix(res, "arn:aws:s3:::") || !Match(name, bucket) {
+			return st, fmt.Errorf("resource %q is outside of bucket %q", res, bucket)
+		}
+	}
+
+	for op, keys := range raw.Condition {
+		base, ifExists := strings.CutSuffix(op, "IfExists")
+		if !condOps[base] {
+			return st, fmt.Errorf("unsupported condition operator %q", op)
+		}
+		for key, v := range keys {
+			vals, err := conditionValues(v)
+			if err != nil {
+				return st, fmt.Errorf("condition %s/%s: %v", op, key, err)
+			}
+			c := Condition{Op: base, Key: strings.ToLower(key), Values: vals, IfExists: ifExists}
+			if err := c.validate(); err != nil {
+				return st, fmt.Errorf("condition %s/%s: %v", op, key, err)
+			}
+			st.Conditions = append(st.Conditions, c)
+		}
+	}
+	return st, nil
+}
+
+// parsePrincipal: "*", {"AWS": "*"}, {"AWS": ["AKID", "arn:aws:iam::...:user/AKID"]}.
+func parsePrincipal(m json.RawMessage) ([]string, error) {
+	if len(m) == 0 {
+		return nil, errors.New("Principal is required")
+	}
+	var s string
+	if json.Unmarshal(m, &s) == nil {
+		if s != "*" {
+			return nil, errors.New(`Principal must be "*" or {"AWS": ...}`)
+		}
+		return []string{"*"}, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(m, &obj); err != nil || len(obj) != 1 || obj["AWS"] == nil {
+		return nil, errors.New(`Principal must be "*" or {"AWS": ...}`)
+	}
+	list, err := stringOrList(obj["AWS"])
+	if err != nil || len(list) == 0 {
+		return nil, errors.New("Principal.AWS must be a string or a list of strings")
+	}
+	out := make([]string, 0, len(list))
+	for _, p := range list {
+		// из ARN пользователя берём последний сегмент — access key
+		if strings.HasPrefix(p, "arn:") {
+			_, user, ok := strings.Cut(p, ":user/")
+			if !ok || user == "" {
+				return nil, fmt.Errorf("unsupported principal %q", p)
+			}
+			p = user
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func stringOrList(m json.RawMessage) ([]string, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	var s string
+	if json.Unmarshal(m, &s) == nil {
+		return []string{s}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(m, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// conditionValues — строки, числа и bool (в JSON условия пишут по-разному).
+func conditionValues(m json.RawMessage) ([]string, error) {
+	var one any
+	if err := json.Unmarshal(m, &one); err != nil {
+		return nil, err
+	}
+	items, ok := one.([]any)
+	if !ok {
+		items = []any{one}
+	}
+	out := make([]string, 0, len(items))
+	for _, it := range items {
+		switch v := it.(type) {
+		case string:
+			out = append(out, v)
+		case float64:
+			out = append(out, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			out = append(out, strconv.FormatBool(v))
+		default:
+			return nil, errors.New("values must be strings, numbers or booleans")
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no values")
+	}
+	return out, nil
+}
+
+func (c Condition) validate() error {
+	for _, v := range c.Values {
+		switch {
+		case strings.HasPrefix(c.Op, "Numeric"):
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return fmt.Errorf("%q is not a number", v)
+			}
+		case strings.HasPrefix(c.Op, "Date"):
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				return fmt.Errorf("%q is not an RFC3339 date", v)
+			}
+		case c.Op == "Bool" || c.Op == "Null":
+			if v != "true" && v != "false" {
+				return fmt.Errorf("%q is not a boolean", v)
+			}
+		case c.Op == "IpAddress" || c.Op == "NotIpAddress":
+			if _, err := parseCIDR(v); err != nil {
+				return fmt.Errorf("%q is not an IP or CIDR", v)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate — решение политики для запроса.
+func (p *Policy) Evaluate(req Request) Decision {
+	d := NoMatch
+	for _, st := range p.Statements {
+		if !st.applies(req) {
+			continue
+		}
+		if st.Effect == "Deny" {
+			return Deny
+		}
+		d = Allow
+	}
+	return d
+}
+
+func (st Statement) applies(req Request) bool {
+	return st.matchPrincipal(req.Principal) &&
+		matchAny(st.Actions, req.Action, true) &&
+		matchAny(st.Resources, req.Resource, false) &&
+		st.matchConditions(req.Context)
+}
+
+func (st Statement) matchPrincipal(p string) bool {
+	for _, want := range st.Principals {
+		if want == "*" || (p != "" && want == p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string, fold bool) bool {
+	if fold {
+		s = strings.ToLower(s)
+	}
+	for _, pat := range patterns {
+		if fold {
+			pat = strings.ToLower(pat)
+		}
+		if Match(pat, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (st Statement) matchConditions(ctx map[string]string) bool {
+	for _, c := range st.Conditions {
+		if !c.eval(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) eval(ctx map[string]string) bool {
+	v, ok := ctx[c.Key]
+	if c.Op == "Null" {
+		// Null: true — ключа нет, false — ключ есть
+		return (c.Values[0] == "true") != ok
+	}
+	if !ok {
+		// отрицающие операторы на отсутствующем ключе истинны, как в AWS
+		_, neg := negated[c.Op]
+		return c.IfExists || neg
+	}
+	op, negate := c.Op, false
+	if pos, ok := negated[op]; ok {
+		op, negate = pos, true
+	}
+	hit := false
+	for _, want := range c.Values {
+		if compare(op, v, want) {
+			hit = true
+			break
+		}
+	}
+	return hit != negate
+}
+
+// negated — отрицающий оператор и его положительная пара.
+var negated = map[string]string{
+	"StringNotEquals":           "StringEquals",
+	"StringNotEqualsIgnoreCase": "StringEqualsIgnoreCase",
+	"StringNotLike":             "StringLike",
+	"NumericNotEquals":          "NumericEquals",
+	"NotIpAddress":              "IpAddress",
+}
+
+func compare(op, got, want string) bool {
+	switch op {
+	case "StringEquals", "Bool":
+		return got == want
+	case "StringEqualsIgnoreCase":
+		return strings.EqualFold(got, want)
+	case "StringLike":
+		return Match(want, got)
+	case "IpAddress":
+		n, err := parseCIDR(want)
+		ip := net.ParseIP(got)
+		return err == nil && ip != nil && n.Contains(ip)
+	}
+	if strings.HasPrefix(op, "Numeric") {
+		g, err1 := strconv.ParseFloat(got, 64)
+		w, err2 := strconv.ParseFloat(want, 64)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		switch op {
+		case "NumericEquals":
+			return g == w
+		case "NumericLessThan":
+			return g < w
+		case "NumericLessThanEquals":
+			return g <= w
+		case "NumericGreaterThan":
+			return g > w
+		case "NumericGreaterThanEquals":
+			return g >= w
+		}
+	}
+	if strings.HasPrefix(op, "Date") {
+		g, err1 := time.Parse(time.RFC3339, got)
+		w, err2 := time.Parse(time.RFC3339, want)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		if op == "DateLessThan" {
+			return g.Before(w)
+		}
+		return g.After(w)
+	}
+	return false
+}
+
+// parseCIDR принимает и голый адрес (как /32 или /128).
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.New("bad ip")
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// Match — шаблон с '*' (любая строка) и '?' (один символ).
+func Match(pattern, s string) bool {
+	px, sx := 0, 0
+	star, mark := -1, 0
+	for sx < len(s) {
+		switch {
+		case px < len(pattern) && (pattern[px] == '?' || pattern[px] == s[sx]):
+			px++
+			sx++
+		case px < len(pattern) && pattern[px] == '*':
+			star, mark = px, sx
+			px++
+		case star >= 0:
+			px = star + 1
+			mark++
+			sx = mark
+		default:
+			return false
+		}
+	}
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+	return px == len(pattern)
+}
@@ -0,0 +1,506 @@
+package s3select
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Значения: nil (NULL/MISSING), bool, float64, string, json.Number,
+// jsonObject и []any. Поля CSV — строки; при сравнении и арифметике строка,
+// похожая на число, приводится к числу (без явного CAST, мягче, чем в S3).
+
+// record — одна запись входа.
+type record interface {
+	get(parts []pathPart) any
+	fields() []field // для SELECT *
+}
+
+type field struct {
+	name string
+	v    any
+}
+
+type aggState struct {
+	n        int64
+	sum      float64
+	best     any
+	hasValue bool
+}
+
+type evaluator struct {
+	q     *query
+	rec   record
+	aggs  map[*funcExpr]*aggState
+	final bool // вычисление итоговой строки агрегатов
+}
+
+// row — поля выходной записи.
+func (ev *evaluator) row() ([]field, error) {
+	if ev.q.star {
+		return ev.rec.fields(), nil
+	}
+	out := make([]field, len(ev.q.items))
+	for i, it := range ev.q.items {
+		v, err := ev.eval(it.e)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = field{name: it.name, v: v}
+	}
+	return out, nil
+}
+
+func (ev *evaluator) accumulate() error {
+	for _, it := range ev.q.items {
+		var err error
+		walk(it.e, func(x expr) bool {
+			fe, ok := x.(*funcExpr)
+			if !ok || !aggregateFuncs[fe.name] || err != nil {
+				return err == nil
+			}
+			st := ev.aggs[fe]
+			if st == nil {
+				st = &aggState{}
+				ev.aggs[fe] = st
+			}
+			if fe.star {
+				st.n++
+				return false
+			}
+			var v any
+			if v, err = ev.eval(fe.args[0]); err != nil || v == nil {
+				return false
+			}
+			switch fe.name {
+			case "COUNT":
+				st.n++
+			case "SUM", "AVG":
+				if sv, isStr := v.(string); isStr && strings.TrimSpace(sv) == "" {
+					return false // пустое поле CSV — как NULL
+				}
+				f, ok := toNumber(v)
+				if !ok {
+					err = errf("EvaluatorInvalidArguments", "%s: value %q is not a number", fe.name, toText(v))
+					return false
+				}
+				st.n++
+				st.sum += f
+				st.hasValue = true
+			case "MIN", "MAX":
+				// числа из CSV сравниваются как числа, а не как строки ("9" < "10")
+				a, b := v, st.best
+				if x, ok := toNumber(a); ok {
+					if y, ok := toNumber(b); ok {
+						a, b = x, y
+					}
+				}
+				if c, ok := compare(a, b); !st.hasValue || ok && (fe.name == "MIN" && c < 0 || fe.name == "MAX" && c > 0) {
+					st.best, st.hasValue = v, true
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ev *evaluator) aggResult(fe *funcExpr) any {
+	st := ev.aggs[fe]
+	if st == nil {
+		st = &aggState{}
+	}
+	switch fe.name {
+	case "COUNT":
+		return float64(st.n)
+	case "SUM":
+		if !st.hasValue {
+			return nil
+		}
+		return st.sum
+	case "AVG":
+		if st.n == 0 {
+			return nil
+		}
+		return st.sum / float64(st.n)
+	}
+	return st.best
+}
+
+func (ev *evaluator) eval(e expr) (any, error) {
+	switch x := e.(type) {
+	case *litExpr:
+		return x.v, nil
+	case *pathExpr:
+		if ev.rec == nil {
+			return nil, nil
+		}
+		parts := x.parts
+		if len(parts) > 1 && !parts[0].quoted && ev.q.alias != "" && strings.EqualFold(parts[0].name, ev.q.alias) {
+			parts = parts[1:]
+		}
+		return ev.rec.get(parts), nil
+	case *unaryExpr:
+		v, err := ev.eval(x.x)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		if x.op == "NOT" {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, nil
+			}
+			return !b, nil
+		}
+		f, ok := toNumber(v)
+		if !ok {
+			return nil, nil
+		}
+		return -f, nil
+	case *binExpr:
+		return ev.binary(x)
+	case *isNullExpr:
+		v, err := ev.eval(x.x)
+		return (v == nil) != x.not, err
+	case *inExpr:
+		v, err := ev.eval(x.x)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		found := false
+		for _, y := range x.list {
+			w, err := ev.eval(y)
+			if err != nil {
+				return nil, err
+			}
+			if c, ok := compare(v, w); ok && c == 0 {
+				found = true
+				break
+			}
+		}
+		return found != x.not, nil
+	case *betweenExpr:
+		v, err := ev.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		lo, err := ev.eval(x.lo)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := ev.eval(x.hi)
+		if err != nil {
+			return nil, err
+		}
+		c1, ok1 := compare(v, lo)
+		c2, ok2 := compare(v, hi)
+		if !ok1 || !ok2 {
+			return nil, nil
+		}
+		return (c1 >= 0 && c2 <= 0) != x.not, nil
+	case *likeExpr:
+		v, err := ev.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		pv, err := ev.eval(x.pat)
+		if err != nil || v == nil || pv == nil {
+			return nil, err
+		}
+		re, err := x.regexp(toText(pv))
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(toText(v)) != x.not, nil
+	case *funcExpr:
+		if aggregateFuncs[x.name] {
+			if !ev.final {
+				return nil, nil
+			}
+			return ev.aggResult(x), nil
+		}
+		return ev.call(x)
+	case *castExpr:
+		v, err := ev.eval(x.x)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return cast(v, x.typ)
+	}
+	return nil, errf("InternalError", "unknown expression %T", e)
+}
+
+func (ev *evaluator) binary(x *binExpr) (any, error) {
+	l, err := ev.eval(x.l)
+	if err != nil {
+		return nil, err
+	}
+	// AND/OR — трёхзначная логика
+	if x.op == "AND" || x.op == "OR" {
+		lb, lok := l.(bool)
+		if x.op == "AND" && lok && !lb || x.op == "OR" && lok && lb {
+			return lb, nil
+		}
+		r, err := ev.eval(x.r)
+		if err != nil {
+			return nil, err
+		}
+		rb, rok := r.(bool)
+		switch {
+		case x.op == "AND" && rok && !rb, x.op == "OR" && rok && rb:
+			return rb, nil
+		case lok && rok:
+			return rb, nil
+		}
+		return nil, nil
+	}
+	r, err := ev.eval(x.r)
+	if err != nil || l == nil || r == nil {
+		return nil, err
+	}
+	switch x.op {
+	case "||":
+		return toText(l) + toText(r), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		c, ok := compare(l, r)
+		if !ok {
+			return nil, nil
+		}
+		switch x.op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+	a, ok1 := toNumber(l)
+	b, ok2 := toNumber(r)
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+	switch x.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, errf("EvaluatorDivisionByZero", "division by zero")
+		}
+		return a / b, nil
+	}
+	if b == 0 {
+		return nil, errf("EvaluatorDivisionByZero", "division by zero")
+	}
+	return math.Mod(a, b), nil
+}
+
+func (ev *evaluator) call(x *funcExpr) (any, error) {
+	args := make([]any, len(x.args))
+	for i, a := range x.args {
+		v, err := ev.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	switch x.name {
+	case "COALESCE":
+		for _, v := range args {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	case "NULLIF":
+		if c, ok := compare(args[0], args[1]); ok && c == 0 {
+			return nil, nil
+		}
+		return args[0], nil
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	s := toText(args[0])
+	switch x.name {
+	case "LOWER":
+		return strings.ToLower(s), nil
+	case "UPPER":
+		return strings.ToUpper(s), nil
+	case "TRIM":
+		return strings.TrimSpace(s), nil
+	case "CHAR_LENGTH", "CHARACTER_LENGTH":
+		return float64(len([]rune(s))), nil
+	}
+	// SUBSTRING: позиция с 1, как в SQL
+	rs := []rune(s)
+	start, ok := toNumber(args[1])
+	if !ok {
+		return nil, errf("EvaluatorInvalidArguments", "SUBSTRING: start must be a number")
+	}
+	from, to := int(start)-1, len(rs)
+	if len(args) == 3 {
+		n, ok := toNumber(args[2])
+		if !ok || n < 0 {
+			return nil, errf("EvaluatorInvalidArguments", "SUBSTRING: length must be a non-negative number")
+		}
+		to = min(from+int(n), len(rs))
+	}
+	from = max(from, 0)
+	if from >= to {
+		return "", nil
+	}
+	return string(rs[from:to]), nil
+}
+
+func (x *likeExpr) regexp(pat string) (*regexp.Regexp, error) {
+	if re, ok := x.cache[pat]; ok {
+		return re, nil
+	}
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	rs := []rune(pat)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case x.escape != "" && string(c) == x.escape:
+			if i+1 == len(rs) {
+				return nil, errf("LikeInvalidInputs", "LIKE pattern ends with the escape character")
+			}
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(rs[i])))
+		case c == '%':
+			sb.WriteString(".*")
+		case c == '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, errf("LikeInvalidInputs", "invalid LIKE pattern")
+	}
+	if len(x.cache) < 64 {
+		x.cache[pat] = re
+	}
+	return re, nil
+}
+
+func cast(v any, typ string) (any, error) {
+	switch typ {
+	case "STRING":
+		return toText(v), nil
+	case "BOOL", "BOOLEAN":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(toText(v)))
+		if err != nil {
+			return nil, errf("CastFailed", "cannot cast %q to BOOL", toText(v))
+		}
+		return b, nil
+	}
+	f, ok := toNumber(v)
+	if !ok {
+		return nil, errf("CastFailed", "cannot cast %q to %s", toText(v), typ)
+	}
+	if typ == "INT" || typ == "INTEGER" {
+		return math.Trunc(f), nil
+	}
+	return f, nil
+}
+
+func isNumeric(v any) bool {
+	switch v.(type) {
+	case float64, json.Number:
+		return true
+	}
+	return false
+}
+
+func toNumber(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compare: ok == false — значения несравнимы (NULL, разные типы).
+func compare(a, b any) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if isNumeric(a) || isNumeric(b) {
+		x, ok1 := toNumber(a)
+		y, ok2 := toNumber(b)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return strings.Compare(as, bs), true
+		}
+	}
+	if ab, ok := a.(bool); ok {
+		if bb, ok := b.(bool); ok {
+			switch {
+			case ab == bb:
+				return 0, true
+			case bb:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// toText — значение как текст (поле CSV, операнд ||, LIKE).
+func toText(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case json.Number:
+		return string(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	var sb strings.Builder
+	writeJSONValue(&sb, v)
+	return sb.String()
+}
@@ -0,0 +1,78 @@
+package s3select
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Кадры application/vnd.amazon.eventstream:
+// [total len][headers len][prelude crc][headers][payload][message crc], CRC32 IEEE.
+func encodeMessage(headers [][2]string, payload []byte) []byte {
+	var hb []byte
+	for _, h := range headers {
+		hb = append(hb, byte(len(h[0])))
+		hb = append(hb, h[0]...)
+		hb = append(hb, 7) // тип значения: string
+		hb = binary.BigEndian.AppendUint16(hb, uint16(len(h[1])))
+		hb = append(hb, h[1]...)
+	}
+	total := 12 + len(hb) + len(payload) + 4
+	msg := make([]byte, 0, total)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(total))
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(hb)))
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+	msg = append(msg, hb...)
+	msg = append(msg, payload...)
+	return binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+}
+
+func eventMessage(event, contentType string, payload []byte) []byte {
+	h := [][2]string{{":event-type", event}}
+	if contentType != "" {
+		h = append(h, [2]string{":content-type", contentType})
+	}
+	h = append(h, [2]string{":message-type", "event"})
+	return encodeMessage(h, payload)
+}
+
+// RecordsMessage — порция результата.
+func RecordsMessage(p []byte) []byte {
+	return eventMessage("Records", "application/octet-stream", p)
+}
+
+// ContMessage — keep-alive, пока сканирование не дало результата.
+func ContMessage() []byte { return eventMessage("Cont", "", nil) }
+
+// EndMessage — запрос выполнен полностью.
+func EndMessage() []byte { return eventMessage("End", "", nil) }
+
+// StatsMessage — итоговая статистика, перед End.
+func StatsMessage(st Stats) []byte {
+	return eventMessage("Stats", "text/xml", []byte(st.xml("Stats")))
+}
+
+// ProgressMessage — промежуточная статистика (RequestProgress.Enabled).
+func ProgressMessage(st Stats) []byte {
+	return eventMessage("Progress", "text/xml", []byte(st.xml("Progress")))
+}
+
+// ErrorMessage — ошибка посреди потока, когда HTTP-статус уже отправлен.
+func ErrorMessage(code, msg string) []byte {
+	return encodeMessage([][2]string{
+		{":error-code", code},
+		{":error-message", msg},
+		{":message-type", "error"},
+	}, nil)
+}
+
+// Stats — байты объекта прочитано (как хранится), обработано (после
+// распаковки) и отдано клиенту.
+type Stats struct {
+	BytesScanned, BytesProcessed, BytesReturned int64
+}
+
+func (st Stats) xml(root string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><%s><BytesScanned>%d</BytesScanned><BytesProcessed>%d</BytesProcessed><BytesReturned>%d</BytesReturned></%s>`,
+		root, st.BytesScanned, st.BytesProcessed, st.BytesReturned, root)
+}
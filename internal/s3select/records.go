@@ -0,0 +1,497 @@
+package s3select
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type recordReader interface {
+	next() (record, error) // io.EOF — записей больше нет
+}
+
+// ---- CSV ----
+
+type csvConfig struct {
+	header      string // USE | IGNORE | NONE
+	fieldDelim  byte
+	recordDelim []byte
+	quote       byte // 0 — без кавычек
+	escape      byte
+	comment     byte // 0 — без комментариев
+}
+
+func singleChar(name, v string, def byte) (byte, error) {
+	switch len(v) {
+	case 0:
+		return def, nil
+	case 1:
+		return v[0], nil
+	}
+	return 0, errf("InvalidRequestParameter", "%s must be a single character", name)
+}
+
+func newCSVConfig(in *CSVInput) (*csvConfig, error) {
+	cfg := &csvConfig{header: strings.ToUpper(in.FileHeaderInfo), recordDelim: []byte(in.RecordDelimiter)}
+	switch cfg.header {
+	case "":
+		cfg.header = "NONE"
+	case "USE", "IGNORE", "NONE":
+	default:
+		return nil, errf("InvalidFileHeaderInfo", "The FileHeaderInfo is invalid. Only NONE, USE, and IGNORE are supported.")
+	}
+	var err error
+	if cfg.fieldDelim, err = singleChar("FieldDelimiter", in.FieldDelimiter, ','); err != nil {
+		return nil, err
+	}
+	if cfg.quote, err = singleChar("QuoteCharacter", in.QuoteCharacter, '"'); err != nil {
+		return nil, err
+	}
+	if cfg.escape, err = singleChar("QuoteEscapeCharacter", in.QuoteEscapeCharacter, cfg.quote); err != nil {
+		return nil, err
+	}
+	if cfg.comment, err = singleChar("Comments", in.Comments, 0); err != nil {
+		return nil, err
+	}
+	if len(cfg.recordDelim) == 0 {
+		cfg.recordDelim = []byte{'\n'}
+	}
+	if len(cfg.recordDelim) > 2 {
+		return nil, errf("InvalidRequestParameter", "RecordDelimiter must be one or two characters")
+	}
+	return cfg, nil
+}
+
+type csvRecords struct {
+	cfg   *csvConfig
+	br    *bufio.Reader
+	names []string
+	index map[string]int // имя колонки в нижнем регистре → номер
+	line  int
+}
+
+func newCSVRecords(r io.Reader, cfg *csvConfig) (*csvRecords, error) {
+	c := &csvRecords{cfg: cfg, br: bufio.NewReaderSize(r, 64<<10)}
+	if cfg.header == "NONE" {
+		return c, nil
+	}
+	hdr, err := c.read()
+	if err == io.EOF {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.header == "USE" {
+		c.names = hdr
+		c.index = make(map[string]int, len(hdr))
+		for i, n := range hdr {
+			if _, dup := c.index[strings.ToLower(n)]; !dup {
+				c.index[strings.ToLower(n)] = i
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *csvRecords) next() (record, error) {
+	vals, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	return &csvRecord{vals: vals, r: c}, nil
+}
+
+// read — одна запись: разделители полей и записей настраиваются, кавычки
+// удваиваются или экранируются QuoteEscapeCharacter, внутри кавычек допустим
+// разделитель записей.
+func (c *csvRecords) read() ([]string, error) {
+	cfg := c.cfg
+	var fields []string
+	var fld []byte
+	started, inQuotes := false, false
+	c.line++
+	for {
+		b, err := c.br.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errf("CSVParsingError", "unterminated quoted field in record %d", c.line)
+			}
+			if !started {
+				return nil, io.EOF
+			}
+			return append(fields, string(fld)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !started && cfg.comment != 0 && b == cfg.comment {
+			if err := c.skipRecord(); err != nil {
+				return nil, err
+			}
+			c.line++
+			continue
+		}
+		started = true
+		if inQuotes {
+			switch {
+			case b == cfg.escape && cfg.escape != cfg.quote:
+				nb, err := c.br.ReadByte()
+				if err != nil {
+					return nil, errf("CSVParsingError", "unterminated quoted field in record %d", c.line)
+				}
+				fld = append(fld, nb)
+			case b == cfg.quote:
+				if nb, err := c.br.Peek(1); err == nil && nb[0] == cfg.quote {
+					_, _ = c.br.ReadByte()
+					fld = append(fld, b)
+				} else {
+					inQuotes = false
+				}
+			default:
+				fld = append(fld, b)
+			}
+			continue
+		}
+		switch {
+		case cfg.quote != 0 && b == cfg.quote && len(fld) == 0:
+			inQuotes = true
+		case b == cfg.fieldDelim:
+			fields = append(fields, string(fld))
+			fld = fld[:0]
+		case c.isRecordDelim(b):
+			return append(fields, string(fld)), nil
+		default:
+			fld = append(fld, b)
+		}
+	}
+}
+
+// isRecordDelim — b начинает разделитель записей (остаток разделителя съедается);
+// для "\n" принимается и "\r\n".
+func (c *csvRecords) isRecordDelim(b byte) bool {
+	rd := c.cfg.recordDelim
+	if len(rd) == 1 && rd[0] == '\n' && b == '\r' {
+		if nb, err := c.br.Peek(1); err == nil && nb[0] == '\n' {
+			_, _ = c.br.ReadByte()
+			return true
+		}
+		return false
+	}
+	if b != rd[0] {
+		return false
+	}
+	if len(rd) == 1 {
+		return true
+	}
+	if nb, err := c.br.Peek(len(rd) - 1); err == nil && bytes.Equal(nb, rd[1:]) {
+		_, _ = c.br.Discard(len(rd) - 1)
+		return true
+	}
+	return false
+}
+
+func (c *csvRecords) skipRecord() error {
+	for {
+		b, err := c.br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.isRecordDelim(b) {
+			return nil
+		}
+	}
+}
+
+type csvRecord struct {
+	vals []string
+	r    *csvRecords
+}
+
+// get: _N — колонка по номеру с 1; иначе по имени из заголовка (USE).
+func (rec *csvRecord) get(parts []pathPart) any {
+	if len(parts) != 1 || parts[0].index >= 0 {
+		return nil
+	}
+	name := parts[0].name
+	if !parts[0].quoted && isPositional(name) {
+		n, _ := strconv.Atoi(name[1:])
+		if n >= 1 && n <= len(rec.vals) {
+			return rec.vals[n-1]
+		}
+		return nil
+	}
+	if rec.r.names != nil {
+		i := -1
+		if parts[0].quoted {
+			for j, h := range rec.r.names {
+				if h == name {
+					i = j
+					break
+				}
+			}
+		} else if j, ok := rec.r.index[strings.ToLower(name)]; ok {
+			i = j
+		}
+		if i >= 0 && i < len(rec.vals) {
+			return rec.vals[i]
+		}
+	}
+	return nil
+}
+
+func (rec *csvRecord) fields() []field {
+	out := make([]field, len(rec.vals))
+	for i, v := range rec.vals {
+		name := "_" + strconv.Itoa(i+1)
+		if i < len(rec.r.names) {
+			name = rec.r.names[i]
+		}
+		out[i] = field{name: name, v: v}
+	}
+	return out
+}
+
+// ---- JSON ----
+
+// jsonObject — объект JSON с исходным порядком ключей.
+type jsonObject []field
+
+func (o jsonObject) lookup(name string, exact bool) (any, bool) {
+	for _, f := range o {
+		if f.name == name {
+			return f.v, true
+		}
+	}
+	if !exact {
+		for _, f := range o {
+			if strings.EqualFold(f.name, name) {
+				return f.v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// jsonRecords — поток значений верхнего уровня (LINES и DOCUMENT читаются
+// одинаково); массив верхнего уровня разворачивается в записи (S3Object[*]).
+type jsonRecords struct {
+	dec     *json.Decoder
+	pending []any
+}
+
+func newJSONRecords(r io.Reader) *jsonRecords {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &jsonRecords{dec: dec}
+}
+
+func (j *jsonRecords) next() (record, error) {
+	for len(j.pending) == 0 {
+		v, err := decodeJSONValue(j.dec)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, errf("JSONParsingError", "error parsing JSON input: %v", err)
+		}
+		if arr, ok := v.([]any); ok {
+			j.pending = arr
+			continue
+		}
+		return &jsonRecord{v: v}, nil
+	}
+	v := j.pending[0]
+	j.pending = j.pending[1:]
+	return &jsonRecord{v: v}, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	d, ok := t.(json.Delim)
+	if !ok {
+		return t, nil
+	}
+	switch d {
+	case '{':
+		obj := jsonObject{}
+		for dec.More() {
+			kt, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, field{name: kt.(string), v: v})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case '[':
+		arr := []any{}
+		for dec.More() {
+			v, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return nil, errors.New("unexpected " + d.String())
+}
+
+type jsonRecord struct{ v any }
+
+func (rec *jsonRecord) get(parts []pathPart) any {
+	cur := rec.v
+	for _, p := range parts {
+		switch x := cur.(type) {
+		case jsonObject:
+			if p.index >= 0 {
+				return nil
+			}
+			v, ok := x.lookup(p.name, p.quoted)
+			if !ok {
+				return nil
+			}
+			cur = v
+		case []any:
+			if p.index < 0 || p.index >= len(x) {
+				return nil
+			}
+			cur = x[p.index]
+		default:
+			return nil
+		}
+	}
+	return cur
+}
+
+func (rec *jsonRecord) fields() []field {
+	if obj, ok := rec.v.(jsonObject); ok {
+		return obj
+	}
+	return []field{{name: "_1", v: rec.v}}
+}
+
+func writeJSONValue(w io.StringWriter, v any) {
+	switch x := v.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		w.WriteString(strconv.FormatBool(x))
+	case json.Number:
+		w.WriteString(string(x))
+	case float64:
+		w.WriteString(strconv.FormatFloat(x, 'f', -1, 64))
+	case string:
+		b, _ := json.Marshal(x)
+		w.WriteString(string(b))
+	case jsonObject:
+		writeJSONObject(w, x)
+	case []any:
+		w.WriteString("[")
+		for i, e := range x {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			writeJSONValue(w, e)
+		}
+		w.WriteString("]")
+	}
+}
+
+func writeJSONObject(w io.StringWriter, fs []field) {
+	w.WriteString("{")
+	for i, f := range fs {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		writeJSONValue(w, f.name)
+		w.WriteString(":")
+		writeJSONValue(w, f.v)
+	}
+	w.WriteString("}")
+}
+
+// ---- вывод ----
+
+type rowWriter interface {
+	write(buf *bytes.Buffer, row []field)
+}
+
+type csvWriter struct {
+	fieldDelim, recordDelim string
+	quote, escape           string
+	always                  bool
+}
+
+func newCSVWriter(o *CSVOutput) (*csvWriter, error) {
+	w := &csvWriter{fieldDelim: o.FieldDelimiter, recordDelim: o.RecordDelimiter, quote: o.QuoteCharacter, escape: o.QuoteEscapeCharacter}
+	switch strings.ToUpper(o.QuoteFields) {
+	case "", "ASNEEDED":
+	case "ALWAYS":
+		w.always = true
+	default:
+		return nil, errf("InvalidQuoteFields", "The QuoteFields is invalid. Only ALWAYS and ASNEEDED are supported.")
+	}
+	if w.fieldDelim == "" {
+		w.fieldDelim = ","
+	}
+	if w.recordDelim == "" {
+		w.recordDelim = "\n"
+	}
+	if w.quote == "" {
+		w.quote = `"`
+	}
+	if w.escape == "" {
+		w.escape = w.quote
+	}
+	return w, nil
+}
+
+func (w *csvWriter) write(buf *bytes.Buffer, row []field) {
+	for i, f := range row {
+		if i > 0 {
+			buf.WriteString(w.fieldDelim)
+		}
+		s := toText(f.v)
+		if w.always || strings.Contains(s, w.fieldDelim) || strings.Contains(s, w.quote) ||
+			strings.Contains(s, w.recordDelim) || strings.ContainsAny(s, "\r\n") {
+			buf.WriteString(w.quote)
+			buf.WriteString(strings.ReplaceAll(s, w.quote, w.escape+w.quote))
+			buf.WriteString(w.quote)
+			continue
+		}
+		buf.WriteString(s)
+	}
+	buf.WriteString(w.recordDelim)
+}
+
+type jsonWriter struct{ recordDelim string }
+
+func newJSONWriter(o *JSONOutput) *jsonWriter {
+	w := &jsonWriter{recordDelim: o.RecordDelimiter}
+	if w.recordDelim == "" {
+		w.recordDelim = "\n"
+	}
+	return w
+}
+
+func (w *jsonWriter) write(buf *bytes.Buffer, row []field) {
+	writeJSONObject(buf, row)
+	buf.WriteString(w.recordDelim)
+}
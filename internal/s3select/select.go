@@ -0,0 +1,271 @@
+// Package s3select — SelectObjectContent: минимальный SQL (проекция, WHERE,
+// LIMIT, агрегаты без GROUP BY) поверх CSV и JSON с потоковой выдачей в
+// формате event stream. Объект читается один раз, целиком в память не грузится.
+package s3select
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	recordsChunk = 64 << 10        // размер порции Records
+	contEvery    = 5 * time.Second // keep-alive при долгом сканировании без результата
+)
+
+// Request — тело POST /:bucket/:key?select&select-type=2.
+type Request struct {
+	XMLName         xml.Name `xml:"SelectObjectContentRequest"`
+	Expression      string   `xml:"Expression"`
+	ExpressionType  string   `xml:"ExpressionType"`
+	RequestProgress struct {
+		Enabled bool `xml:"Enabled"`
+	} `xml:"RequestProgress"`
+	InputSerialization  InputSerialization  `xml:"InputSerialization"`
+	OutputSerialization OutputSerialization `xml:"OutputSerialization"`
+	ScanRange           *struct{}           `xml:"ScanRange"`
+}
+
+type InputSerialization struct {
+	CompressionType string     `xml:"CompressionType"` // NONE | GZIP | BZIP2
+	CSV             *CSVInput  `xml:"CSV"`
+	JSON            *JSONInput `xml:"JSON"`
+	Parquet         *struct{}  `xml:"Parquet"`
+}
+
+type CSVInput struct {
+	FileHeaderInfo       string `xml:"FileHeaderInfo"` // USE | IGNORE | NONE
+	Comments             string `xml:"Comments"`
+	QuoteEscapeCharacter string `xml:"QuoteEscapeCharacter"`
+	RecordDelimiter      string `xml:"RecordDelimiter"`
+	FieldDelimiter       string `xml:"FieldDelimiter"`
+	QuoteCharacter       string `xml:"QuoteCharacter"`
+}
+
+type JSONInput struct {
+	Type string `xml:"Type"` // DOCUMENT | LINES
+}
+
+type OutputSerialization struct {
+	CSV  *CSVOutput  `xml:"CSV"`
+	JSON *JSONOutput `xml:"JSON"`
+}
+
+type CSVOutput struct {
+	QuoteFields          string `xml:"QuoteFields"` // ASNEEDED | ALWAYS
+	QuoteEscapeCharacter string `xml:"QuoteEscapeCharacter"`
+	RecordDelimiter      string `xml:"RecordDelimiter"`
+	FieldDelimiter       string `xml:"FieldDelimiter"`
+	QuoteCharacter       string `xml:"QuoteCharacter"`
+}
+
+type JSONOutput struct {
+	RecordDelimiter string `xml:"RecordDelimiter"`
+}
+
+// Error — ошибка запроса с кодом S3 (ParseUnexpectedToken, CSVParsingError…).
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+func errf(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Select — разобранный и проверенный запрос.
+type Select struct {
+	req   *Request
+	q     *query
+	out   rowWriter
+	input func(io.Reader) (recordReader, error)
+}
+
+// Prepare проверяет запрос и разбирает SQL; ошибки отсюда — обычный 400.
+func Prepare(req *Request) (*Select, error) {
+	if !strings.EqualFold(req.ExpressionType, "SQL") {
+		return nil, errf("InvalidExpressionType", "The ExpressionType is invalid. Only SQL expressions are supported.")
+	}
+	if req.ScanRange != nil {
+		return nil, errf("NotImplemented", "ScanRange is not supported.")
+	}
+	in := req.InputSerialization
+	switch strings.ToUpper(in.CompressionType) {
+	case "", "NONE", "GZIP", "BZIP2":
+	default:
+		return nil, errf("InvalidCompressionFormat", "The file is not in a supported compression format. Only GZIP and BZIP2 are supported.")
+	}
+	sel := &Select{req: req}
+	switch {
+	case in.Parquet != nil:
+		return nil, errf("NotImplemented", "Parquet input is not supported.")
+	case in.CSV != nil && in.JSON == nil:
+		cfg, err := newCSVConfig(in.CSV)
+		if err != nil {
+			return nil, err
+		}
+		sel.input = func(r io.Reader) (recordReader, error) { return newCSVRecords(r, cfg) }
+	case in.JSON != nil && in.CSV == nil:
+		switch strings.ToUpper(in.JSON.Type) {
+		case "DOCUMENT", "LINES":
+		default:
+			return nil, errf("InvalidJsonType", "The JsonType is invalid. Only DOCUMENT and LINES are supported.")
+		}
+		sel.input = func(r io.Reader) (recordReader, error) { return newJSONRecords(r), nil }
+	default:
+		return nil, errf("InvalidRequestParameter", "Exactly one of CSV, JSON must be specified in InputSerialization.")
+	}
+	var err error
+	switch out := req.OutputSerialization; {
+	case out.CSV != nil && out.JSON == nil:
+		sel.out, err = newCSVWriter(out.CSV)
+	case out.JSON != nil && out.CSV == nil:
+		sel.out = newJSONWriter(out.JSON)
+	default:
+		err = errf("InvalidRequestParameter", "Exactly one of CSV, JSON must be specified in OutputSerialization.")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sel.q, err = parseQuery(req.Expression); err != nil {
+		return nil, err
+	}
+	return sel, nil
+}
+
+// Run выполняет запрос над src (байты объекта как хранятся) и шлёт кадры
+// event stream в emit. Ошибка после начала потока — вызывающий отправляет ErrorMessage.
+func (s *Select) Run(ctx context.Context, src io.Reader, emit func([]byte) error) (Stats, error) {
+	var st Stats
+	scanned := &countingReader{r: src}
+	var plain io.Reader = scanned
+	switch strings.ToUpper(s.req.InputSerialization.CompressionType) {
+	case "GZIP":
+		zr, err := gzip.NewReader(scanned)
+		if err != nil {
+			return st, errf("InvalidCompressionFormat", "cannot read GZIP input: %v", err)
+		}
+		defer zr.Close()
+		plain = zr
+	case "BZIP2":
+		plain = bzip2.NewReader(scanned)
+	}
+	processed := &countingReader{r: plain}
+	recs, err := s.input(processed)
+	if err != nil {
+		return st, err
+	}
+
+	var buf bytes.Buffer
+	stats := func() Stats {
+		return Stats{BytesScanned: scanned.n, BytesProcessed: processed.n, BytesReturned: st.BytesReturned}
+	}
+	lastEmit := time.Now()
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		st.BytesReturned += int64(buf.Len())
+		if err := emit(RecordsMessage(buf.Bytes())); err != nil {
+			return err
+		}
+		buf.Reset()
+		lastEmit = time.Now()
+		if s.req.RequestProgress.Enabled {
+			return emit(ProgressMessage(stats()))
+		}
+		return nil
+	}
+
+	ev := &evaluator{q: s.q}
+	if s.q.aggregate {
+		ev.aggs = map[*funcExpr]*aggState{}
+	}
+	var rows int64
+	for n := 0; ; n++ {
+		if n%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return stats(), err
+			}
+			if time.Since(lastEmit) > contEvery {
+				if err := emit(ContMessage()); err != nil {
+					return stats(), err
+				}
+				lastEmit = time.Now()
+			}
+		}
+		if s.q.limit >= 0 && rows >= s.q.limit {
+			break
+		}
+		rec, err := recs.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats(), err
+		}
+		ev.rec = rec
+		if s.q.where != nil {
+			v, err := ev.eval(s.q.where)
+			if err != nil {
+				return stats(), err
+			}
+			if b, _ := v.(bool); !b {
+				continue
+			}
+		}
+		if s.q.aggregate {
+			if err := ev.accumulate(); err != nil {
+				return stats(), err
+			}
+			continue
+		}
+		row, err := ev.row()
+		if err != nil {
+			return stats(), err
+		}
+		s.out.write(&buf, row)
+		rows++
+		if buf.Len() >= recordsChunk {
+			if err := flush(); err != nil {
+				return stats(), err
+			}
+		}
+	}
+	if s.q.aggregate {
+		ev.rec, ev.final = nil, true
+		row, err := ev.row()
+		if err != nil {
+			return stats(), err
+		}
+		s.out.write(&buf, row)
+	}
+	if err := flush(); err != nil {
+		return stats(), err
+	}
+	st = stats()
+	if err := emit(StatsMessage(st)); err != nil {
+		return st, err
+	}
+	return st, emit(EndMessage())
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,670 @@
+package s3select
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Поддерживаемый диалект:
+//
+//	SELECT * | expr [[AS] name], ... FROM S3Object[[*]] [[AS] alias] [WHERE expr] [LIMIT n]
+//
+// Выражения: пути (s._1, s.name, s.a.b[0], "Quoted"), литералы, + - * / % ||,
+// = != <> < <= > >=, AND OR NOT, [NOT] LIKE, [NOT] IN, [NOT] BETWEEN, IS [NOT] NULL,
+// CAST(x AS type), LOWER, UPPER, TRIM, CHAR_LENGTH, SUBSTRING, COALESCE, NULLIF и
+// агрегаты COUNT, SUM, AVG, MIN, MAX.
+
+type query struct {
+	star      bool
+	items     []selectItem
+	alias     string
+	where     expr
+	limit     int64 // -1 — без LIMIT
+	aggregate bool
+}
+
+type selectItem struct {
+	e    expr
+	name string // имя поля в JSON-выводе
+}
+
+type expr interface{}
+
+type (
+	litExpr   struct{ v any }
+	pathExpr  struct{ parts []pathPart }
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+	binExpr struct {
+		op   string
+		l, r expr
+	}
+	isNullExpr struct {
+		x   expr
+		not bool
+	}
+	inExpr struct {
+		x    expr
+		list []expr
+		not  bool
+	}
+	betweenExpr struct {
+		x, lo, hi expr
+		not       bool
+	}
+	likeExpr struct {
+		x, pat expr
+		escape string
+		not    bool
+		cache  map[string]*regexp.Regexp
+	}
+	funcExpr struct {
+		name string
+		args []expr
+		star bool // COUNT(*)
+	}
+	castExpr struct {
+		x   expr
+		typ string
+	}
+)
+
+type pathPart struct {
+	name   string
+	quoted bool
+	index  int // для [n]; -1 — обращение по имени
+}
+
+var aggregateFuncs = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true}
+
+var scalarFuncs = map[string][2]int{ // мин./макс. число аргументов
+	"LOWER": {1, 1}, "UPPER": {1, 1}, "TRIM": {1, 1}, "CHAR_LENGTH": {1, 1}, "CHARACTER_LENGTH": {1, 1},
+	"SUBSTRING": {2, 3}, "COALESCE": {1, 64}, "NULLIF": {2, 2},
+}
+
+var castTypes = map[string]bool{"INT": true, "INTEGER": true, "FLOAT": true, "DECIMAL": true, "NUMERIC": true, "STRING": true, "BOOL": true, "BOOLEAN": true}
+
+// ---- лексер ----
+
+type tokKind int
+
+const (
+	tkEOF tokKind = iota
+	tkIdent
+	tkQuotedIdent
+	tkString
+	tkNumber
+	tkOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) is(kw string) bool { return t.kind == tkIdent && strings.EqualFold(t.text, kw) }
+
+func lex(src string) ([]token, error) {
+	var out []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for {
+				if j >= len(src) {
+					return nil, errf("ParseUnexpectedToken", "unterminated quoted token at position %d", i)
+				}
+				if src[j] == c {
+					if j+1 < len(src) && src[j+1] == c {
+						sb.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteByte(src[j])
+				j++
+			}
+			kind := tkString
+			if c == '"' {
+				kind = tkQuotedIdent
+			}
+			out = append(out, token{kind, sb.String(), i})
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				(src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E')) {
+				j++
+			}
+			out = append(out, token{tkNumber, src[i:j], i})
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			out = append(out, token{tkIdent, src[i:j], i})
+			i = j
+		default:
+			op := string(c)
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "!=", "<>", "<=", ">=", "||":
+					op = two
+				}
+			}
+			if len(op) == 1 && !strings.Contains("(),.*[]=<>+-/%", op) {
+				return nil, errf("ParseUnexpectedToken", "unexpected character %q at position %d", c, i)
+			}
+			out = append(out, token{tkOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(out, token{tkEOF, "", len(src)}), nil
+}
+
+// ---- парсер (рекурсивный спуск) ----
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tkEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) accept(kw string) bool {
+	if t := p.peek(); t.is(kw) || t.kind == tkOp && t.text == kw {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kw string) error {
+	if !p.accept(kw) {
+		return p.unexpected("expected " + kw)
+	}
+	return nil
+}
+
+func (p *parser) unexpected(what string) error {
+	t := p.peek()
+	if t.kind == tkEOF {
+		return errf("ParseUnexpectedToken", "%s, got end of query", what)
+	}
+	return errf("ParseUnexpectedToken", "%s, got %q at position %d", what, t.text, t.pos)
+}
+
+var reserved = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "LIMIT": true, "AS": true, "AND": true, "OR": true, "NOT": true,
+	"LIKE": true, "IN": true, "IS": true, "NULL": true, "BETWEEN": true, "TRUE": true, "FALSE": true, "ESCAPE": true,
+}
+
+func parseQuery(src string) (*query, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	q := &query{limit: -1}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.accept("*") {
+		q.star = true
+	} else {
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			it := selectItem{e: e}
+			if p.accept("AS") {
+				t := p.next()
+				if t.kind != tkIdent && t.kind != tkQuotedIdent {
+					p.i--
+					return nil, p.unexpected("expected alias")
+				}
+				it.name = t.text
+			} else if t := p.peek(); t.kind == tkQuotedIdent || t.kind == tkIdent && !reserved[strings.ToUpper(t.text)] {
+				it.name = p.next().text
+			}
+			q.items = append(q.items, it)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	if !p.accept("S3Object") {
+		return nil, p.unexpected("expected S3Object")
+	}
+	if p.accept("[") {
+		if err := p.expect("*"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek().kind == tkOp && p.peek().text == "." {
+		return nil, errf("UnsupportedSyntax", "paths in FROM clause are not supported")
+	}
+	if p.accept("AS") || p.peek().kind == tkIdent && !reserved[strings.ToUpper(p.peek().text)] {
+		t := p.next()
+		if t.kind != tkIdent {
+			p.i--
+			return nil, p.unexpected("expected alias")
+		}
+		q.alias = t.text
+	}
+	if p.accept("WHERE") {
+		if q.where, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if containsAggregate(q.where) {
+			return nil, errf("UnsupportedSqlOperation", "aggregate functions are not allowed in WHERE")
+		}
+	}
+	if p.accept("LIMIT") {
+		t := p.next()
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if t.kind != tkNumber || err != nil || n < 0 {
+			p.i--
+			return nil, p.unexpected("expected non-negative integer LIMIT")
+		}
+		q.limit = n
+	}
+	if p.peek().kind != tkEOF {
+		return nil, p.unexpected("expected end of query")
+	}
+
+	for i := range q.items {
+		it := &q.items[i]
+		if containsAggregate(it.e) {
+			q.aggregate = true
+		}
+		if it.name == "" {
+			it.name = "_" + strconv.Itoa(i+1)
+			if pe, ok := it.e.(*pathExpr); ok {
+				if last := pe.parts[len(pe.parts)-1]; last.index < 0 && (len(pe.parts) > 1 || !isPositional(last.name)) {
+					it.name = last.name
+				}
+			}
+		}
+	}
+	if q.aggregate {
+		for _, it := range q.items {
+			if pathOutsideAggregate(it.e) {
+				return nil, errf("UnsupportedSqlOperation", "when aggregates are used, every SELECT item must be an aggregate (GROUP BY is not supported)")
+			}
+		}
+	}
+	return q, nil
+}
+
+func (p *parser) expr() (expr, error) { return p.or() }
+
+func (p *parser) or() (expr, error) {
+	l, err := p.and()
+	for err == nil && p.accept("OR") {
+		var r expr
+		if r, err = p.and(); err == nil {
+			l = &binExpr{op: "OR", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) and() (expr, error) {
+	l, err := p.not()
+	for err == nil && p.accept("AND") {
+		var r expr
+		if r, err = p.not(); err == nil {
+			l = &binExpr{op: "AND", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("NOT") {
+		x, err := p.not()
+		return &unaryExpr{op: "NOT", x: x}, err
+	}
+	return p.cmp()
+}
+
+func (p *parser) cmp() (expr, error) {
+	l, err := p.add()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tkOp {
+		switch t.text {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+			p.next()
+			r, err := p.add()
+			op := t.text
+			if op == "<>" {
+				op = "!="
+			}
+			return &binExpr{op: op, l: l, r: r}, err
+		}
+	}
+	if p.accept("IS") {
+		not := p.accept("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return &isNullExpr{x: l, not: not}, nil
+	}
+	not := p.accept("NOT")
+	switch {
+	case p.accept("LIKE"):
+		pat, err := p.add()
+		if err != nil {
+			return nil, err
+		}
+		le := &likeExpr{x: l, pat: pat, not: not, cache: map[string]*regexp.Regexp{}}
+		if p.accept("ESCAPE") {
+			t := p.next()
+			if t.kind != tkString || len(t.text) != 1 {
+				p.i--
+				return nil, p.unexpected("expected single-character ESCAPE string")
+			}
+			le.escape = t.text
+		}
+		return le, nil
+	case p.accept("IN"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		ie := &inExpr{x: l, not: not}
+		for {
+			e, err := p.add()
+			if err != nil {
+				return nil, err
+			}
+			ie.list = append(ie.list, e)
+			if !p.accept(",") {
+				break
+			}
+		}
+		return ie, p.expect(")")
+	case p.accept("BETWEEN"):
+		lo, err := p.add()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.add()
+		return &betweenExpr{x: l, lo: lo, hi: hi, not: not}, err
+	case not:
+		return nil, p.unexpected("expected LIKE, IN or BETWEEN after NOT")
+	}
+	return l, nil
+}
+
+func (p *parser) add() (expr, error) {
+	l, err := p.mul()
+	for err == nil {
+		t := p.peek()
+		if t.kind != tkOp || t.text != "+" && t.text != "-" && t.text != "||" {
+			break
+		}
+		p.next()
+		var r expr
+		if r, err = p.mul(); err == nil {
+			l = &binExpr{op: t.text, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) mul() (expr, error) {
+	l, err := p.unary()
+	for err == nil {
+		t := p.peek()
+		if t.kind != tkOp || t.text != "*" && t.text != "/" && t.text != "%" {
+			break
+		}
+		p.next()
+		var r expr
+		if r, err = p.unary(); err == nil {
+			l = &binExpr{op: t.text, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("-") {
+		x, err := p.unary()
+		return &unaryExpr{op: "-", x: x}, err
+	}
+	p.accept("+")
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tkNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &litExpr{v: float64(n)}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.i--
+			return nil, p.unexpected("bad number")
+		}
+		return &litExpr{v: f}, nil
+	case tkString:
+		return &litExpr{v: t.text}, nil
+	case tkQuotedIdent:
+		p.i--
+		return p.path()
+	case tkOp:
+		if t.text == "(" {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	case tkIdent:
+		switch up := strings.ToUpper(t.text); {
+		case up == "NULL":
+			return &litExpr{v: nil}, nil
+		case up == "TRUE", up == "FALSE":
+			return &litExpr{v: up == "TRUE"}, nil
+		case up == "CAST" && p.peek().text == "(":
+			p.next()
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("AS"); err != nil {
+				return nil, err
+			}
+			typ := strings.ToUpper(p.next().text)
+			if !castTypes[typ] {
+				p.i--
+				return nil, p.unexpected("expected CAST type (INT, FLOAT, DECIMAL, STRING, BOOL)")
+			}
+			return &castExpr{x: x, typ: typ}, p.expect(")")
+		case p.peek().kind == tkOp && p.peek().text == "(":
+			return p.call(up)
+		case reserved[up]:
+			p.i--
+			return nil, p.unexpected("expected expression")
+		}
+		p.i--
+		return p.path()
+	}
+	p.i--
+	return nil, p.unexpected("expected expression")
+}
+
+func (p *parser) call(name string) (expr, error) {
+	p.next() // (
+	fe := &funcExpr{name: name}
+	if aggregateFuncs[name] {
+		if name == "COUNT" && p.accept("*") {
+			fe.star = true
+			return fe, p.expect(")")
+		}
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if containsAggregate(x) {
+			return nil, errf("UnsupportedSqlOperation", "nested aggregate functions are not supported")
+		}
+		fe.args = []expr{x}
+		return fe, p.expect(")")
+	}
+	lim, ok := scalarFuncs[name]
+	if !ok {
+		return nil, errf("UnsupportedFunction", "function %s is not supported", name)
+	}
+	if !p.accept(")") {
+		for {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			fe.args = append(fe.args, x)
+			// SUBSTRING(x FROM a [FOR b])
+			if name == "SUBSTRING" && (p.accept("FROM") || p.accept("FOR")) {
+				continue
+			}
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(fe.args) < lim[0] || len(fe.args) > lim[1] {
+		return nil, errf("IncorrectSqlFunctionArgumentType", "wrong number of arguments for %s", name)
+	}
+	return fe, nil
+}
+
+func (p *parser) path() (expr, error) {
+	pe := &pathExpr{}
+	for {
+		t := p.next()
+		if t.kind != tkIdent && t.kind != tkQuotedIdent {
+			p.i--
+			return nil, p.unexpected("expected identifier")
+		}
+		pe.parts = append(pe.parts, pathPart{name: t.text, quoted: t.kind == tkQuotedIdent, index: -1})
+		for p.accept("[") {
+			t := p.next()
+			n, err := strconv.Atoi(t.text)
+			if t.kind != tkNumber || err != nil || n < 0 {
+				p.i--
+				return nil, p.unexpected("expected array index")
+			}
+			pe.parts = append(pe.parts, pathPart{index: n})
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		}
+		if !p.accept(".") {
+			return pe, nil
+		}
+	}
+}
+
+func isPositional(name string) bool {
+	if len(name) < 2 || name[0] != '_' {
+		return false
+	}
+	_, err := strconv.Atoi(name[1:])
+	return err == nil
+}
+
+// walk обходит выражение; f возвращает false, чтобы не спускаться глубже.
+func walk(e expr, f func(expr) bool) {
+	if e == nil || !f(e) {
+		return
+	}
+	switch x := e.(type) {
+	case *unaryExpr:
+		walk(x.x, f)
+	case *binExpr:
+		walk(x.l, f)
+		walk(x.r, f)
+	case *isNullExpr:
+		walk(x.x, f)
+	case *inExpr:
+		walk(x.x, f)
+		for _, y := range x.list {
+			walk(y, f)
+		}
+	case *betweenExpr:
+		walk(x.x, f)
+		walk(x.lo, f)
+		walk(x.hi, f)
+	case *likeExpr:
+		walk(x.x, f)
+		walk(x.pat, f)
+	case *funcExpr:
+		for _, y := range x.args {
+			walk(y, f)
+		}
+	case *castExpr:
+		walk(x.x, f)
+	}
+}
+
+func containsAggregate(e expr) bool {
+	found := false
+	walk(e, func(x expr) bool {
+		if fe, ok := x.(*funcExpr); ok && aggregateFuncs[fe.name] {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func pathOutsideAggregate(e expr) bool {
+	found := false
+	walk(e, func(x expr) bool {
+		switch y := x.(type) {
+		case *funcExpr:
+			return !aggregateFuncs[y.name]
+		case *pathExpr:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,395 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+)
+
+// Память песочницы. У gopher-lua нет хука на выделение, поэтому:
+//   - оператор .. при компиляции заменяется вызовом concatFunc, а функции
+//     string.* и table.concat обёрнуты: каждая новая строка не длиннее
+//     MaxStringSize и учитывается в счётчике свежих байт;
+//   - объём живых значений (байты строк и записи таблиц, достижимые из
+//     глобалов, реестра и стеков вызовов) считается обходом: когда свежих байт
+//     набралось на остаток лимита и раз в сколько-то инструкций (таблицы растут
+//     по записи за инструкцию, мимо обёрток). Больше MaxMemory — ErrMemory.
+//     Строка считается при каждой ссылке на неё — это оценка сверху.
+
+const (
+	MaxMemory     = 8 << 20
+	MaxStringSize = 1 << 20
+
+	// примерная цена записи таблицы: ключ и значение LValue плюс служебное
+	tableSlotCost = 48
+	// минимальный интервал обхода в инструкциях; дальше он растёт с числом
+	// обойденных значений, чтобы обход стоил O(1) на инструкцию
+	minWalkEvery = 1024
+)
+
+var ErrMemory = errors.New("script memory limit exceeded")
+
+// concatFunc — глобал песочницы, на который заменён оператор ..
+const concatFunc = "__s3mini_concat"
+
+// meter — учёт памяти одной песочницы.
+type meter struct {
+	L        *lua.LState
+	steps    int // инструкций с начала
+	nextWalk int // на какой инструкции следующий обход
+	live     int // байт по последнему обходу
+	fresh    int // байт строк, созданных после него
+	exceeded bool
+	closed   chan struct{}
+}
+
+func newMeter(L *lua.LState) *meter {
+	c := make(chan struct{})
+	close(c)
+	return &meter{L: L, nextWalk: minWalkEvery, closed: c}
+}
+
+// meteredCtx — VM зовёт Done перед каждой инструкцией: здесь и считаются шаги.
+// После превышения канал закрыт навсегда, так что pcall ошибку не поглотит —
+// следующая же инструкция снаружи упадёт снова.
+type meteredCtx struct {
+	context.Context
+	m *meter
+}
+
+func (c meteredCtx) Done() <-chan struct{} {
+	m := c.m
+	if !m.exceeded {
+		if m.steps++; m.steps >= m.nextWalk {
+			m.walk()
+		}
+	}
+	if m.exceeded {
+		return m.closed
+	}
+	return c.Context.Done()
+}
+
+func (c meteredCtx) Err() error {
+	if c.m.exceeded {
+		return ErrMemory
+	}
+	return c.Context.Err()
+}
+
+// alloc учитывает новую строку длины n; превышение — ошибка Lua.
+func (m *meter) alloc(L *lua.LState, n int) {
+	if n > MaxStringSize {
+		L.RaiseError("string longer than %d bytes", MaxStringSize)
+	}
+	m.fresh += n
+	if m.live+m.fresh > MaxMemory {
+		m.walk()
+	}
+	if m.exceeded {
+		L.RaiseError("%s", ErrMemory)
+	}
+}
+
+// walk пересчитывает живые значения и назначает следующий обход.
+func (m *meter) walk() {
+	w := walker{seen: map[lua.LValue]bool{}}
+	w.value(m.L.G.Global)
+	w.value(m.L.G.Registry)
+	w.stack(m.L)
+	m.live, m.fresh = w.size, 0
+	m.exceeded = w.size > MaxMemory
+	m.nextWalk = m.steps + max(minWalkEvery, w.visited)
+}
+
+type walker struct {
+	seen    map[lua.LValue]bool
+	size    int
+	visited int
+}
+
+// stack — все регистры всех кадров, включая временные (GetLocal отдаёт их
+// как "(*temporary)").
+func (w *walker) stack(L *lua.LState) {
+	for level := 0; w.size <= MaxMemory; level++ {
+		dbg, ok := L.GetStack(level)
+		if !ok {
+			return
+		}
+		for n := 1; ; n++ {
+			name, v := L.GetLocal(dbg, n)
+			if name == "" {
+				break
+			}
+			w.value(v)
+		}
+	}
+}
+
+func (w *walker) value(v lua.LValue) {
+	if w.size > MaxMemory {
+		return
+	}
+	w.visited++
+	switch v := v.(type) {
+	case lua.LString:
+		w.size += len(v)
+	case *lua.LTable:
+		if w.seen[v] {
+			return
+		}
+		w.seen[v] = true
+		w.value(v.Metatable)
+		v.ForEach(func(k, val lua.LValue) {
+			w.size += tableSlotCost
+			w.value(k)
+			w.value(val)
+		})
+	case *lua.LFunction:
+		if w.seen[v] {
+			return
+		}
+		w.seen[v] = true
+		w.value(v.Env)
+		for _, uv := range v.Upvalues {
+			w.value(uv.Value())
+		}
+	}
+}
+
+// install подменяет в песочнице всё, что создаёт строки.
+func (m *meter) install(L *lua.LState) {
+	L.SetGlobal(concatFunc, L.NewFunction(m.concat))
+	if t, ok := L.GetGlobal("string").(*lua.LTable); ok {
+		// замыкания с upvalue (gmatch) не оборачиваем: их итератор отдаёт подстроки
+		fns := map[string]lua.LGFunction{}
+		t.ForEach(func(k, v lua.LValue) {
+			if fn, ok := v.(*lua.LFunction); ok && fn.IsG && len(fn.Upvalues) == 0 {
+				fns[k.String()] = fn.GFunction
+			}
+		})
+		for name, fn := range fns {
+			t.RawSetString(name, L.NewFunction(m.wrap(name, fn)))
+		}
+	}
+	if t, ok := L.GetGlobal("table").(*lua.LTable); ok {
+		if fn, ok := t.RawGetString("concat").(*lua.LFunction); ok {
+			t.RawSetString("concat", L.NewFunction(m.wrap("table.concat", fn.GFunction)))
+		}
+	}
+}
+
+// wrap — библиотечная функция с проверкой размера входа (где результат может
+// быть много больше аргументов) и учётом строк-результатов.
+func (m *meter) wrap(name string, fn lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		switch name {
+		case "format":
+			checkFormat(L)
+		case "gsub":
+			checkGsub(L)
+		case "table.concat":
+			checkTableConcat(L)
+		}
+		n := fn(L)
+		for i := L.GetTop() - n + 1; i <= L.GetTop(); i++ {
+			if s, ok := L.Get(i).(lua.LString); ok {
+				m.alloc(L, len(s))
+			}
+		}
+		return n
+	}
+}
+
+// concat — оператор .. : строки и числа склеиваются с учётом памяти, для
+// остального — метаметод __concat, как в VM.
+func (m *meter) concat(L *lua.LState) int {
+	a, b := L.Get(1), L.Get(2)
+	if lua.LVCanConvToString(a) && lua.LVCanConvToString(b) {
+		sa, sb := lua.LVAsString(a), lua.LVAsString(b)
+		m.alloc(L, len(sa)+len(sb))
+		L.Push(lua.LString(sa + sb))
+		return 1
+	}
+	mm := L.GetMetaField(a, "__concat")
+	if mm == lua.LNil {
+		mm = L.GetMetaField(b, "__concat")
+	}
+	if mm == lua.LNil {
+		bad := a
+		if lua.LVCanConvToString(a) {
+			bad = b
+		}
+		L.RaiseError("attempt to concatenate a %s value", bad.Type())
+	}
+	L.Push(mm)
+	L.Push(a)
+	L.Push(b)
+	L.Call(2, 1)
+	return 1
+}
+
+// ширина и точность спецификатора — не больше двух цифр, как в Lua 5.1
+var formatSpec = regexp.MustCompile(`%[-+ #0]*(\d*)(?:\.(\d*))?`)
+
+func checkFormat(L *lua.LState) {
+	for _, sm := range formatSpec.FindAllStringSubmatch(L.CheckString(1), -1) {
+		if len(sm[1]) > 2 || len(sm[2]) > 2 {
+			L.RaiseError("invalid format (width or precision too long)")
+		}
+	}
+}
+
+// checkGsub — для строки-замены оценка сверху до вызова: на каждую позицию
+// строки по замене, а замена со ссылками на захваты (%0..%9) — длиной до самой
+// строки. Функция или таблица замены подменяется обёрткой, которая считает
+// сумму отданных замен.
+func checkGsub(L *lua.LState) {
+	s := len(L.CheckString(1))
+	switch repl := L.Get(3).(type) {
+	case lua.LString, lua.LNumber:
+		per := len(lua.LVAsString(repl))
+		if formatRef.MatchString(lua.LVAsString(repl)) {
+			per += s
+		}
+		if (s+1)*(per+1) > MaxStringSize {
+			L.RaiseError("gsub result may exceed %d bytes", MaxStringSize)
+		}
+	case *lua.LFunction, *lua.LTable:
+		total := s
+		L.Replace(3, L.NewFunction(func(L *lua.LState) int {
+			var v lua.LValue
+			if t, ok := repl.(*lua.LTable); ok {
+				v = L.GetTable(t, L.Get(1))
+			} else {
+				top := L.GetTop()
+				L.Push(repl)
+				for i := 1; i <= top; i++ {
+					L.Push(L.Get(i))
+				}
+				L.Call(top, 1)
+				v = L.Get(-1)
+			}
+			if total += len(lua.LVAsString(v)); total > MaxStringSize {
+				L.RaiseError("gsub result exceeds %d bytes", MaxStringSize)
+			}
+			L.Push(v)
+			return 1
+		}))
+	}
+}
+
+var formatRef = regexp.MustCompile(`%\d`)
+
+// checkTableConcat — длина результата считается до склейки: элементы таблицы
+// могут быть ссылками на одну и ту же длинную строку.
+func checkTableConcat(L *lua.LState) {
+	t := L.CheckTable(1)
+	sep := len(L.OptString(2, ""))
+	i, j := L.OptInt(3, 1), L.OptInt(4, t.Len())
+	total := 0
+	for k := i; k <= j; k++ {
+		total += len(lua.LVAsString(t.RawGetInt(k))) + sep
+		if total > MaxStringSize {
+			L.RaiseError("table.concat result exceeds %d bytes", MaxStringSize)
+		}
+	}
+}
+
+// rewriteConcat заменяет в AST каждый a .. b на вызов concatFunc(a, b).
+func rewriteConcat(stmts []ast.Stmt) {
+	for _, st := range stmts {
+		rewriteStmt(st)
+	}
+}
+
+func rewriteStmt(st ast.Stmt) {
+	switch st := st.(type) {
+	case *ast.AssignStmt:
+		rewriteExprs(st.Lhs)
+		rewriteExprs(st.Rhs)
+	case *ast.LocalAssignStmt:
+		rewriteExprs(st.Exprs)
+	case *ast.FuncCallStmt:
+		st.Expr = rewriteExpr(st.Expr)
+	case *ast.DoBlockStmt:
+		rewriteConcat(st.Stmts)
+	case *ast.WhileStmt:
+		st.Condition = rewriteExpr(st.Condition)
+		rewriteConcat(st.Stmts)
+	case *ast.RepeatStmt:
+		st.Condition = rewriteExpr(st.Condition)
+		rewriteConcat(st.Stmts)
+	case *ast.IfStmt:
+		st.Condition = rewriteExpr(st.Condition)
+		rewriteConcat(st.Then)
+		rewriteConcat(st.Else)
+	case *ast.NumberForStmt:
+		st.Init, st.Limit = rewriteExpr(st.Init), rewriteExpr(st.Limit)
+		if st.Step != nil {
+			st.Step = rewriteExpr(st.Step)
+		}
+		rewriteConcat(st.Stmts)
+	case *ast.GenericForStmt:
+		rewriteExprs(st.Exprs)
+		rewriteConcat(st.Stmts)
+	case *ast.FuncDefStmt:
+		rewriteConcat(st.Func.Stmts)
+	case *ast.ReturnStmt:
+		rewriteExprs(st.Exprs)
+	}
+}
+
+func rewriteExprs(es []ast.Expr) {
+	for i := range es {
+		es[i] = rewriteExpr(es[i])
+	}
+}
+
+func rewriteExpr(e ast.Expr) ast.Expr {
+	switch e := e.(type) {
+	case *ast.StringConcatOpExpr:
+		fn := &ast.IdentExpr{Value: concatFunc}
+		fn.SetLine(e.Line())
+		fn.SetLastLine(e.LastLine())
+		call := &ast.FuncCallExpr{Func: fn, Args: []ast.Expr{rewriteExpr(e.Lhs), rewriteExpr(e.Rhs)}}
+		call.SetLine(e.Line())
+		call.SetLastLine(e.LastLine())
+		return call
+	case *ast.AttrGetExpr:
+		e.Object, e.Key = rewriteExpr(e.Object), rewriteExpr(e.Key)
+	case *ast.TableExpr:
+		for _, f := range e.Fields {
+			if f.Key != nil {
+				f.Key = rewriteExpr(f.Key)
+			}
+			f.Value = rewriteExpr(f.Value)
+		}
+	case *ast.FuncCallExpr:
+		if e.Func != nil {
+			e.Func = rewriteExpr(e.Func)
+		}
+		if e.Receiver != nil {
+			e.Receiver = rewriteExpr(e.Receiver)
+		}
+		rewriteExprs(e.Args)
+	case *ast.LogicalOpExpr:
+		e.Lhs, e.Rhs = rewriteExpr(e.Lhs), rewriteExpr(e.Rhs)
+	case *ast.RelationalOpExpr:
+		e.Lhs, e.Rhs = rewriteExpr(e.Lhs), rewriteExpr(e.Rhs)
+	case *ast.ArithmeticOpExpr:
+		e.Lhs, e.Rhs = rewriteExpr(e.Lhs), rewriteExpr(e.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		e.Expr = rewriteExpr(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		e.Expr = rewriteExpr(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		e.Expr = rewriteExpr(e.Expr)
+	case *ast.FunctionExpr:
+		rewriteConcat(e.Stmts)
+	}
+	return e
+}
@@ -0,0 +1,224 @@
+// Package script — Lua-скрипты бакета: решение allow/deny и правка заголовков
+// запроса для правил, которые не выражаются настройками.
+//
+// Скрипт определяет глобальную функцию handle(req). Вернуть nil/true — пропустить,
+// false или строку (или deny("msg")) — отказать. Таблица req.headers может
+// быть изменена: изменения применяются к запросу (кроме x-amz-*, authorization, host).
+//
+// Песочница: только base (без загрузки кода и доступа к ФС), string, table, math;
+// выполнение ограничено по времени через контекст, стек — по глубине, память —
+// MaxMemory (см. memory.go). Те же лимиты действуют и на верхний уровень скрипта,
+// который выполняет Compile.
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const MaxSourceSize = 64 << 10
+
+var (
+	ErrNoHandler = errors.New("script must define function handle(req)")
+	ErrTimeout   = errors.New("script timed out")
+)
+
+// Program — скомпилированный скрипт; безопасен для параллельного Run.
+type Program struct {
+	proto *lua.FunctionProto
+}
+
+// Request — то, что видит скрипт.
+type Request struct {
+	Method  string
+	Bucket  string
+	Key     string
+	UserID  uint
+	IP      string
+	Size    int64
+	Headers map[string]string // ключи в нижнем регистре
+	Query   map[string]string
+}
+
+// Decision — результат: Allow и правки заголовков ("" — удалить заголовок).
+type Decision struct {
+	Allow   bool
+	Reason  string
+	Headers map[string]string
+}
+
+// Compile разбирает исходник и проверяет, что handle объявлена.
+func Compile(name, src string) (*Program, error) {
+	if len(src) > MaxSourceSize {
+		return nil, fmt.Errorf("script too large (max %d bytes)", MaxSourceSize)
+	}
+	chunk, err := parse.Parse(strings.NewReader(src), name)
+	if err != nil {
+		return nil, err
+	}
+	rewriteConcat(chunk)
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	p := &Program{proto: proto}
+
+	// пробный запуск верхнего уровня: handle должна появиться
+	L, m := newSandbox()
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	L.SetContext(meteredCtx{ctx, m})
+	if err := p.load(L); err != nil {
+		return nil, wrapErr(ctx, m, err)
+	}
+	return p, nil
+}
+
+// Run выполняет handle(req) в новой песочнице не дольше timeout.
+func (p *Program) Run(ctx context.Context, req Request, timeout time.Duration) (Decision, error) {
+	L, m := newSandbox()
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	L.SetContext(meteredCtx{ctx, m})
+
+	if err := p.load(L); err != nil {
+		return Decision{}, wrapErr(ctx, m, err)
+	}
+	tReq, tHdr := requestTable(L, req)
+	if err := L.CallByParam(lua.P{Fn: L.GetGlobal("handle"), NRet: 2, Protect: true}, tReq); err != nil {
+		return Decision{}, wrapErr(ctx, m, err)
+	}
+	ret, reason := L.Get(-2), L.Get(-1)
+	L.Pop(2)
+
+	d := Decision{Allow: true}
+	switch v := ret.(type) {
+	case *lua.LNilType:
+	case lua.LBool:
+		d.Allow = bool(v)
+		if !d.Allow && reason != lua.LNil {
+			d.Reason = reason.String()
+		}
+	case lua.LString:
+		d.Allow, d.Reason = false, string(v)
+	default:
+		return Decision{}, fmt.Errorf("handle returned %s, want nil, boolean or string", ret.Type())
+	}
+	if d.Allow {
+		d.Headers = headerChanges(req.Headers, tHdr)
+	}
+	return d, nil
+}
+
+func (p *Program) load(L *lua.LState) error {
+	L.Push(L.NewFunctionFromProto(p.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		return err
+	}
+	if _, ok := L.GetGlobal("handle").(*lua.LFunction); !ok {
+		return ErrNoHandler
+	}
+	return nil
+}
+
+func newSandbox() (*lua.LState, *meter) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 64, RegistrySize: 1024, RegistryMaxSize: 64 * 1024})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// ни загрузки кода, ни ФС, ни вывода; string.rep — лёгкий способ съесть память
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module",
+		"print", "_printregs", "collectgarbage", "getfenv", "setfenv", "newproxy"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	if t, ok := L.GetGlobal("string").(*lua.LTable); ok {
+		t.RawSetString("rep", lua.LNil)
+	}
+	L.SetGlobal("deny", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(L.OptString(1, "denied by bucket script")))
+		return 2
+	}))
+	L.SetGlobal("allow", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LTrue)
+		return 1
+	}))
+	m := newMeter(L)
+	m.install(L)
+	return L, m
+}
+
+func requestTable(L *lua.LState, req Request) (*lua.LTable, *lua.LTable) {
+	t := L.NewTable()
+	t.RawSetString("method", lua.LString(req.Method))
+	t.RawSetString("bucket", lua.LString(req.Bucket))
+	t.RawSetString("key", lua.LString(req.Key))
+	t.RawSetString("user_id", lua.LNumber(req.UserID))
+	t.RawSetString("ip", lua.LString(req.IP))
+	t.RawSetString("size", lua.LNumber(req.Size))
+	h := L.NewTable()
+	for k, v := range req.Headers {
+		h.RawSetString(k, lua.LString(v))
+	}
+	t.RawSetString("headers", h)
+	q := L.NewTable()
+	for k, v := range req.Query {
+		q.RawSetString(k, lua.LString(v))
+	}
+	t.RawSetString("query", q)
+	return t, h
+}
+
+// headerChanges — что скрипт поменял в req.headers.
+func headerChanges(before map[string]string, t *lua.LTable) map[string]string {
+	out := map[string]string{}
+	after := map[string]string{}
+	t.ForEach(func(k, v lua.LValue) {
+		if ks, ok := k.(lua.LString); ok {
+			after[strings.ToLower(string(ks))] = v.String()
+		}
+	})
+	for k, v := range after {
+		if before[k] != v && writableHeader(k) {
+			out[k] = v
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok && writableHeader(k) {
+			out[k] = ""
+		}
+	}
+	return out
+}
+
+func writableHeader(k string) bool {
+	return !strings.HasPrefix(k, "x-amz-") && k != "authorization" && k != "host"
+}
+
+func wrapErr(ctx context.Context, m *meter, err error) error {
+	if m.exceeded {
+		return ErrMemory
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return err
+}
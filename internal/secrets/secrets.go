@@ -0,0 +1,148 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// sealedPrefix помечает зашифрованные значения; всё без префикса считается
+// legacy-plaintext (строки, записанные до появления мастер-ключа).
+const sealedPrefix = "enc:v1:"
+
+var (
+	ErrNoMasterKey  = errors.New("secret is sealed but master key is not configured")
+	ErrBadMasterKey = errors.New("master key must be 32 bytes (hex or base64)")
+	ErrCorrupted    = errors.New("sealed secret is corrupted")
+)
+
+// Box шифрует секреты пользователей мастер-ключом сервера (AES-256-GCM).
+// nil *Box — валидное значение: секреты хранятся как есть.
+type Box struct {
+	aead cipher.AEAD
+	// ключи, выведенные из оборота: ими только расшифровываем (ротация мастер-ключа)
+	retired []cipher.AEAD
+}
+
+// ParseKey принимает 32-байтовый ключ в hex (64 символа) или base64.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	return nil, ErrBadMasterKey
+}
+
+// KeyFromCommand — хук для KMS/HSM: выполняет команду через sh -c и берёт
+// ключ из её stdout (hex или base64), чтобы мастер-ключ не лежал в окружении.
+func KeyFromCommand(ctx context.Context, command string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).Output()
+	if err != nil {
+		return nil, fmt.Errorf("master key command: %w", err)
+	}
+	return ParseKey(string(out))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrBadMasterKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func NewBox(key []byte) (*Box, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// AddRetiredKey — прежний мастер-ключ: секреты под ним читаются, пока
+// Reseal не перешифрует их текущим.
+func (b *Box) AddRetiredKey(key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	b.retired = append(b.retired, aead)
+	return nil
+}
+
+// IsSealed — значение уже зашифровано.
+func IsSealed(s string) bool { return strings.HasPrefix(s, sealedPrefix) }
+
+// Seal шифрует секрет. Без мастер-ключа возвращает его без изменений.
+func (b *Box) Seal(plain string) (string, error) {
+	if b == nil || IsSealed(plain) {
+		return plain, nil
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	ct := b.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(ct), nil
+}
+
+// Open расшифровывает секрет; legacy-plaintext возвращается как есть.
+func (b *Box) Open(stored string) (string, error) {
+	plain, _, err := b.open(stored)
+	return plain, err
+}
+
+// Reseal приводит хранимое значение к текущему мастер-ключу: plaintext
+// шифруется, запечатанное прежним ключом — перешифровывается. changed=false,
+// если значение уже в актуальном виде.
+func (b *Box) Reseal(stored string) (out string, changed bool, err error) {
+	if b == nil {
+		return stored, false, nil
+	}
+	plain, current, err := b.open(stored)
+	if err != nil {
+		return "", false, err
+	}
+	if IsSealed(stored) && current {
+		return stored, false, nil
+	}
+	out, err = b.Seal(plain)
+	return out, err == nil, err
+}
+
+// open возвращает открытый текст и признак «запечатано текущим ключом».
+func (b *Box) open(stored string) (string, bool, error) {
+	if !IsSealed(stored) {
+		return stored, false, nil
+	}
+	if b == nil {
+		return "", false, ErrNoMasterKey
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	for i, aead := range append([]cipher.AEAD{b.aead}, b.retired...) {
+		ns := aead.NonceSize()
+		if len(raw) < ns {
+			return "", false, ErrCorrupted
+		}
+		if plain, err := aead.Open(nil, raw[:ns], raw[ns:], nil); err == nil {
+			return string(plain), i == 0, nil
+		}
+	}
+	return "", false, ErrCorrupted
+}
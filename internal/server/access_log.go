@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/auth"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var mAccessLogRecords = metrics.NewCounterVec("s3mini_access_log_records_total",
+	"Bucket access log records by result.", "result") // buffered|written|dropped
+
+const (
+	// при таком объёме буфер сбрасывается, не дожидаясь таймера
+	accessLogFlushBytes = 1 << 20
+	// больше не копим (целевой бакет недоступен) — новые записи теряются
+	accessLogMaxBytes = 16 << 20
+)
+
+// ctxAccessInfoKey — что про запрос узнают внутренние слои (кто его подписал);
+// журнал доступа пишет WithRequestLogger уже после ответа.
+const ctxAccessInfoKey ctxKey = "access.info"
+
+type accessInfo struct {
+	requester string // access key; "" — аноним
+}
+
+// setAccessRequester — запомнить подписавшего запрос для журнала доступа.
+func setAccessRequester(r *http.Request, accessKey string) {
+	if info, ok := r.Context().Value(ctxAccessInfoKey).(*accessInfo); ok {
+		info.requester = accessKey
+	}
+}
+
+// BucketLoggingStatus — тело PUT и ответ GET /:bucket?logging
+type BucketLoggingStatus struct {
+	XMLName        xml.Name        `xml:"BucketLoggingStatus"`
+	Xmlns          string          `xml:"xmlns,attr,omitempty"`
+	LoggingEnabled *LoggingEnabled `xml:"LoggingEnabled,omitempty"`
+}
+
+type LoggingEnabled struct {
+	TargetBucket string `xml:"TargetBucket"`
+	TargetPrefix string `xml:"TargetPrefix"`
+}
+
+type accessLogTarget struct {
+	bucket, prefix string
+}
+
+// accessLogBuffer — записи журнала доступа до сброса в целевые бакеты.
+type accessLogBuffer struct {
+	mu    sync.Mutex
+	lines map[accessLogTarget]*bytes.Buffer
+	size  int
+	kick  chan struct{}
+}
+
+func (b *accessLogBuffer) add(t accessLogTarget, line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.size+len(line) > accessLogMaxBytes {
+		mAccessLogRecords.Inc("dropped")
+		return
+	}
+	if b.lines == nil {
+		b.lines = map[accessLogTarget]*bytes.Buffer{}
+	}
+	buf := b.lines[t]
+	if buf == nil {
+		buf = &bytes.Buffer{}
+		b.lines[t] = buf
+	}
+	buf.WriteString(line)
+	b.size += len(line)
+	mAccessLogRecords.Inc("buffered")
+	if b.size >= accessLogFlushBytes && b.kick != nil {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take — забрать накопленное.
+func (b *accessLogBuffer) take() map[accessLogTarget]*bytes.Buffer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := b.lines
+	b.lines, b.size = nil, 0
+	return out
+}
+
+// recordAccess — запись журнала доступа для бакета с включённым ?logging.
+func (s *Server) recordAccess(r *http.Request, ww *statusWriter, info *accessInfo, reqID string, start time.Time) {
+	p := strings.Trim(r.URL.Path, "/")
+	if p == "" || unauthenticatedPaths[r.URL.Path] ||
+		strings.HasPrefix(r.URL.Path, adminPrefix) || strings.HasPrefix(r.URL.Path, internalPrefix) {
+		return
+	}
+	bucket, key, _ := strings.Cut(p, "/")
+	b, err := s.db.FindBucketByName(bucket)
+	if err != nil || b.LoggingTargetBucket == "" {
+		return
+	}
+	line := formatAccessRecord(r, ww, b, key, info.requester, reqID, start)
+	s.accessLog.add(accessLogTarget{b.LoggingTargetBucket, b.LoggingTargetPrefix}, line)
+}
+
+// formatAccessRecord — строка в формате S3 server access log.
+func formatAccessRecord(r *http.Request, ww *statusWriter, b *db.Bucket, key, requester, reqID string, start time.Time) string {
+	dash := func(v string) string {
+		if v == "" {
+			return "-"
+		}
+		return v
+	}
+	quote := func(v string) string {
+		if v == "" {
+			return "-"
+		}
+		return strconv.Quote(v)
+	}
+	errCode := ""
+	if head := string(ww.errHead); ww.status >= 400 {
+		if _, rest, ok := strings.Cut(head, "<Code>"); ok {
+			errCode, _, _ = strings.Cut(rest, "</Code>")
+		}
+	}
+	sent := ""
+	if ww.written > 0 {
+		sent = strconv.FormatInt(ww.written, 10)
+	}
+	objSize := ""
+	if r.Method == http.MethodPut && r.ContentLength >= 0 && key != "" {
+		objSize = strconv.FormatInt(r.ContentLength, 10)
+	}
+	sigVersion, authType := "", ""
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), "AWS "):
+		sigVersion, authType = "SigV2", "AuthHeader"
+	case r.Header.Get("Authorization") != "":
+		sigVersion, authType = "SigV4", "AuthHeader"
+	case r.URL.Query().Get("X-Amz-Signature") != "":
+		sigVersion, authType = "SigV4", "QueryString"
+	case auth.IsSigV2(r):
+		sigVersion, authType = "SigV2", "QueryString"
+	}
+	cipher, tlsVersion := "", ""
+	if r.TLS != nil {
+		cipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+		tlsVersion = strings.ReplaceAll(tls.VersionName(r.TLS.Version), " ", "v")
+	}
+	if key != "" {
+		key = url.PathEscape(key)
+	}
+	fields := []string{
+		strconv.FormatUint(uint64(b.OwnerID), 10),
+		b.Name,
+		start.UTC().Format("[02/Jan/2006:15:04:05 -0700]"),
+		sourceIP(r),
+		dash(requester),
+		reqID,
+		accessOperation(r, key),
+		dash(key),
+		strconv.Quote(r.Method + " " + r.URL.RequestURI() + " " + r.Proto),
+		strconv.Itoa(ww.status),
+		dash(errCode),
+		dash(sent),
+		dash(objSize),
+		strconv.FormatInt(time.Since(start).Milliseconds(), 10),
+		"-", // turn-around time
+		quote(r.Referer()),
+		quote(r.UserAgent()),
+		dash(ww.Header().Get("x-amz-version-id")),
+		"-", // host id
+		dash(sigVersion),
+		dash(cipher),
+		dash(authType),
+		dash(r.Host),
+		dash(tlsVersion),
+	}
+	return strings.Join(fields, " ") + "\n"
+}
+
+// accessOperation — REST.<METHOD>.<ресурс>, как в журнале S3.
+func accessOperation(r *http.Request, key string) string {
+	res := "BUCKET"
+	if key != "" {
+		res = "OBJECT"
+	}
+	q := r.URL.Query()
+	for _, sub := range []string{"acl", "cors", "policy", "lifecycle", "versioning", "tagging", "logging", "notification",
+		"object-lock", "retention", "legal-hold", "location", "uploads", "uploadId", "versions", "select", "attributes", "restore"} {
+		if _, ok := q[sub]; ok {
+			res = strings.ToUpper(strings.ReplaceAll(sub, "-", "_"))
+			if sub == "uploadId" {
+				res = "UPLOAD"
+				if r.Method == http.MethodPut {
+					res = "PART"
+				}
+			}
+			break
+		}
+	}
+	return "REST." + r.Method + "." + res
+}
+
+// StartAccessLog периодически сбрасывает журнал доступа объектами в целевые
+// бакеты. Буфер в памяти процесса, поэтому lease не нужен: каждый узел пишет своё.
+func (s *Server) StartAccessLog(ctx context.Context, every time.Duration) {
+	log := s.Logger.With(slog.String("comp", "access_log"))
+	s.accessLog.mu.Lock()
+	s.accessLog.kick = make(chan struct{}, 1)
+	kick := s.accessLog.kick
+	s.accessLog.mu.Unlock()
+	go func() {
+		log.Info("access_log.started", "every", every.String())
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// последний сброс: контекст уже отменён
+				fctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				s.flushAccessLog(fctx, log)
+				cancel()
+				log.Info("access_log.stopped", "reason", "context canceled")
+				return
+			case <-t.C:
+			case <-kick:
+			}
+			s.flushAccessLog(ctx, log)
+		}
+	}()
+}
+
+func (s *Server) flushAccessLog(ctx context.Context, log *slog.Logger) {
+	// в режиме только чтения записи копятся в буфере (до accessLogMaxBytes)
+	if s.isReadOnly() {
+		return
+	}
+	for t, buf := range s.accessLog.take() {
+		n := bytes.Count(buf.Bytes(), []byte{'\n'})
+		b, err := s.db.FindBucketByName(t.bucket)
+		if err != nil {
+			log.Warn("access_log.target_missing", "target", t.bucket, "records", n, "err", err)
+			mAccessLogRecords.Add(uint64(n), "dropped")
+			continue
+		}
+		var suffix [8]byte
+		_, _ = rand.Read(suffix[:])
+		key := t.prefix + time.Now().UTC().Format("2006-01-02-15-04-05") + "-" + strings.ToUpper(hex.EncodeToString(suffix[:]))
+		if _, err := s.putInternalObject(ctx, b, key, "text/plain", buf); err != nil {
+			log.Error("access_log.write_fail", "target", t.bucket, "key", key, "records", n, "err", err)
+			mAccessLogRecords.Add(uint64(n), "dropped")
+			continue
+		}
+		mAccessLogRecords.Add(uint64(n), "written")
+		log.Info("access_log.flushed", "target", t.bucket, "key", key, "records", n)
+	}
+}
+
+// PUT /:bucket?logging — включить (LoggingEnabled) или выключить (пустой
+// BucketLoggingStatus) журнал доступа. Целевой бакет — того же владельца.
+func (s *Server) handlePutBucketLogging(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.loggingBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	var req BucketLoggingStatus
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse logging xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	target, prefix := "", ""
+	if le := req.LoggingEnabled; le != nil {
+		if le.TargetBucket == "" {
+			writeS3Error(w, http.StatusBadRequest, "MalformedXML", "TargetBucket is required", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if len(le.TargetPrefix) > 1024 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "TargetPrefix is too long", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		tb, err := s.db.FindBucket(le.TargetBucket, b.OwnerID)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			writeS3Error(w, http.StatusBadRequest, "InvalidTargetBucketForLogging",
+				"The target bucket for logging does not exist or is owned by another user", r.URL.Path, requestIDFrom(r))
+			return
+		case err != nil:
+			log.Error("logging.put.target_lookup_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		target, prefix = tb.Name, le.TargetPrefix
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{
+		"logging_target_bucket": target,
+		"logging_target_prefix": prefix,
+	}); err != nil {
+		log.Error("logging.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("logging.put.ok", "target", target, "prefix", prefix)
+}
+
+// GET /:bucket?logging
+func (s *Server) handleGetBucketLogging(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.loggingBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	out := BucketLoggingStatus{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/"}
+	if b.LoggingTargetBucket != "" {
+		out.LoggingEnabled = &LoggingEnabled{TargetBucket: b.LoggingTargetBucket, TargetPrefix: b.LoggingTargetPrefix}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) loggingBucket(w http.ResponseWriter, r *http.Request, bucket string, log *slog.Logger) (*db.Bucket, bool) {
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, false
+	case err != nil:
+		log.Error("logging.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return b, true
+}
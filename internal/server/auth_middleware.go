@@ -2,8 +2,12 @@ package server
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/DanikLP1/s3-storage-service/internal/auth"
@@ -13,44 +17,319 @@ import (
 type credProvider struct{ db *db.DB }
 
 func (c credProvider) LookupSecret(accessKeyID string) (string, error) {
-	u, err := c.db.FindUserByAccessKey(accessKeyID)
-	if err != nil {
-		return "", err
-	}
-	return u.SecretAccessKey, nil
+	return c.db.AccessKeySecret(accessKeyID)
 }
 
 type ctxKey string
 
 const ctxUserKey ctxKey = "auth.user.ID"
 
+// пути без SigV4: пробы k8s и метрики
+var unauthenticatedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
 func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	allowNoSign := os.Getenv("ALLOW_INSECURE_NOSIGN") == "1"
+	if allowNoSign {
+		// только для локальной отладки: запросы без подписи идут без пользователя (userID 0)
+		s.Logger.Warn("auth.insecure_nosign_enabled", "hint", "unset ALLOW_INSECURE_NOSIGN outside development")
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// межузловой канал проверяется подписью узла (requireNodeAuth)
+		if unauthenticatedPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, internalPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := s.runRequestHooks("pre_auth", r); err != nil {
+			writeHookError(w, r, err)
+			return
+		}
+		// браузерная загрузка формой: подпись в полях, проверяет handlePostObject
+		if isPostObject(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
 		if allowNoSign && r.Header.Get("Authorization") == "" {
+			if !decodeStreamingBody(w, r, nil) {
+				return
+			}
+			if err := s.runRequestHooks("post_auth", r); err != nil {
+				writeHookError(w, r, err)
+				return
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		res, err := auth.VerifySigV4(r, credProvider{s.db}, auth.VerifyOptions{
-			MaxSkew:              15 * time.Minute,
+		signed := r.Header.Get("Authorization") != "" || r.URL.Query().Get("X-Amz-Signature") != "" || auth.IsSigV2(r)
+		certAKID := certAccessKeyFrom(r)
+		// без подписи (и без клиентского сертификата) запрос может пройти только по политике (Principal "*") или ACL бакета
+		if !signed && certAKID == "" {
+			ar, granted, err := s.checkBucketAccess(r, 0, "")
+			if err != nil {
+				writeHookError(w, r, err)
+				return
+			}
+			if granted {
+				if !decodeStreamingBody(w, ar, nil) {
+					return
+				}
+				if err := s.runRequestHooks("post_auth", ar); err != nil {
+					writeHookError(w, ar, err)
+					return
+				}
+				next.ServeHTTP(w, ar)
+				return
+			}
+			// анонимный запрос без права — отказ как в S3; в счётчик неудачных
+			// подписей он не идёт, иначе публичный бакет блокировал бы клиентов по IP
+			if r.URL.Query().Get("X-Amz-Credential") == "" {
+				writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied", r.URL.Path, requestIDFrom(r))
+				return
+			}
+		}
+
+		ip := sourceIP(r)
+		akid := auth.AccessKeyFromRequest(r)
+		if !signed {
+			akid = certAKID
+		}
+		// блокируются IP и пара IP + ключ, но не ключ сам по себе
+		ipKey, pairKey, _ := authThrottleKeys(ip, akid)
+		if left, locked := s.authThrottle.Locked(ipKey, pairKey); locked {
+			mAuthRejectedLocked.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(left.Seconds())+1))
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "too many failed authentication attempts, try later", r.URL.Path, requestIDFrom(r))
+			return
+		}
+
+		opts := auth.VerifyOptions{
+			MaxSkew:              time.Duration(s.cfg.MaxClockSkewS) * time.Second,
 			AllowUnsignedPayload: true,
 			ExpectedService:      "s3",
-		})
+			Replay:               s.sigReplay,
+		}
+		var (
+			res *auth.Result
+			err error
+		)
+		switch {
+		case !signed:
+			// сертификат уже проверен при рукопожатии; ключ должен существовать и быть активен
+			if _, err = s.db.FindUserByAccessKey(certAKID); err == nil {
+				res = &auth.Result{AccessKeyID: certAKID, AmzDate: time.Now().UTC()}
+			}
+		case !auth.IsSigV2(r):
+			res, err = auth.VerifySigV4(r, credProvider{s.db}, opts)
+		case s.cfg.AllowSigV2:
+			res, err = auth.VerifySigV2(r, credProvider{s.db}, opts)
+		default:
+			err = auth.ErrUnsuportedAlgorithm
+		}
+		if err == nil && res == nil {
+			err = auth.ErrSignatureMismatch
+		}
 		if err != nil {
-			writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error(), r.URL.Path, "")
+			status, code, msg := authErrorResponse(err)
+			if status == http.StatusInternalServerError {
+				// сбой БД или расшифровки секрета — не вина клиента, в счётчик не идёт
+				loggerFrom(r).Error("auth.verify_fail", "access_key", akid, "err", err)
+			} else {
+				s.onAuthFailure(r, akid, ip, err)
+			}
+			writeS3Error(w, status, code, msg, r.URL.Path, requestIDFrom(r))
+			return
+		}
+		s.authThrottle.Success(pairKey)
+		if !decodeStreamingBody(w, r, res) {
 			return
 		}
 
-		u, err := s.db.FindUserByAccessKey(res.AccessKeyID) // верни структуру с ID
-		if err == nil {
-			ctx := context.WithValue(r.Context(), ctxUserKey, u.ID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+		// ключ могли отключить между проверкой подписи и этим местом: без
+		// конкретного пользователя запрос дальше не идёт
+		u, err := s.db.FindUserByAccessKey(res.AccessKeyID)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				status, code, msg := authErrorResponse(err)
+				writeS3Error(w, status, code, msg, r.URL.Path, requestIDFrom(r))
+				return
+			}
+			loggerFrom(r).Error("auth.user_lookup_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		key, err := s.db.FindAccessKey(res.AccessKeyID)
+		if err != nil {
+			loggerFrom(r).Error("auth.key_lookup_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		setAccessRequester(r, u.AccessKeyID)
+		ctx := context.WithValue(r.Context(), ctxUserKey, u.ID)
+		r = r.WithContext(context.WithValue(ctx, ctxAccessKeyKey, requestKey{key: key, principal: u.AccessKeyID}))
+		// админский API закрыт для role=user и ключей приложений ещё до хуков и политик бакета
+		if strings.HasPrefix(r.URL.Path, adminPrefix) && (u.Role != db.RoleAdmin || key.Policy != "") {
+			s.audit.Warn("admin.denied", "user_id", u.ID, "access_key", key.AccessKeyID, "ip", ip, "method", r.Method, "path", r.URL.Path)
+			writeJSONError(w, http.StatusForbidden, "AccessDenied", "admin role required")
+			return
+		}
+		if err := s.checkKeyScope(r, key, u.AccessKeyID); err != nil {
+			writeHookError(w, r, err)
+			return
+		}
+		release, ok := s.acquireKeyLimit(w, r, key.AccessKeyID, u)
+		if !ok {
+			return
+		}
+		defer release()
+		r, _, err = s.checkBucketAccess(r, u.ID, u.AccessKeyID)
+		if err != nil {
+			writeHookError(w, r, err)
+			return
+		}
+		if err := s.runRequestHooks("post_auth", r); err != nil {
+			writeHookError(w, r, err)
+			return
 		}
+		next.ServeHTTP(w, r)
 	})
 }
 
+// decodeStreamingBody подменяет тело aws-chunked (x-amz-content-sha256: STREAMING-*)
+// декодером, а Content-Length — на x-amz-decoded-content-length, так что хендлеры
+// видят обычное тело. res == nil (анонимный запрос) — допустим только неподписанный вариант.
+// false — ответ уже записан.
+func decodeStreamingBody(w http.ResponseWriter, r *http.Request, res *auth.Result) bool {
+	sha := r.Header.Get("x-amz-content-sha256")
+	if !auth.IsStreamingPayload(sha) {
+		return true
+	}
+	n, err := strconv.ParseInt(r.Header.Get("x-amz-decoded-content-length"), 10, 64)
+	if err != nil || n < 0 {
+		writeS3Error(w, http.StatusLengthRequired, "MissingContentLength", "You must provide the x-amz-decoded-content-length header.", r.URL.Path, requestIDFrom(r))
+		return false
+	}
+	body, err := auth.NewChunkedReader(r.Body, sha, res, trailerChecksum(r.Header))
+	if err != nil {
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "x-amz-content-sha256 "+sha+" is not supported", r.URL.Path, requestIDFrom(r))
+		return false
+	}
+	r.Body = body
+	r.ContentLength = n
+	return true
+}
+
+// trailerChecksum — контрольная сумма, объявленная в x-amz-trailer, для проверки
+// декодером aws-chunked; nil — не объявлена или алгоритм неизвестен (его отвергнет хендлер).
+func trailerChecksum(h http.Header) *auth.ChecksumTrailer {
+	name := strings.ToLower(strings.TrimSpace(h.Get("x-amz-trailer")))
+	alg, ok := strings.CutPrefix(name, hdrChecksumPrefix)
+	if !ok || checksumAlgorithms[strings.ToUpper(alg)] == nil {
+		return nil
+	}
+	return &auth.ChecksumTrailer{Name: name, Hash: checksumAlgorithms[strings.ToUpper(alg)]()}
+}
+
+// writeChunkedBodyError отвечает на ошибку чтения тела aws-chunked: подпись куска
+// не сошлась (403), трейлерная контрольная сумма не та или битое обрамление (400).
+// false — ошибка не от декодера.
+func writeChunkedBodyError(w http.ResponseWriter, r *http.Request, err error) bool {
+	trailer := strings.ToLower(strings.TrimSpace(r.Header.Get("x-amz-trailer")))
+	switch {
+	case errors.Is(err, auth.ErrSignatureMismatch):
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", "chunk signature does not match", r.URL.Path, requestIDFrom(r))
+	case errors.Is(err, auth.ErrChecksumMismatch):
+		alg := strings.ToUpper(strings.TrimPrefix(trailer, hdrChecksumPrefix))
+		writeS3Error(w, http.StatusBadRequest, "BadDigest", "The "+alg+" you specified did not match the calculated checksum.", r.URL.Path, requestIDFrom(r))
+	case errors.Is(err, auth.ErrBadTrailerChecksum):
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "Value for "+trailer+" trailing header is invalid.", r.URL.Path, requestIDFrom(r))
+	case errors.Is(err, auth.ErrMalformedChunk), errors.Is(err, io.ErrUnexpectedEOF):
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error(), r.URL.Path, requestIDFrom(r))
+	default:
+		return false
+	}
+	return true
+}
+
+// authErrorResponse — ответ S3 на ошибку аутентификации: код и статус как у
+// AWS, чтобы SDK показывали понятную причину (и не повторяли запрос зря).
+// 500 — ошибка не клиента (БД, расшифровка секрета).
+func authErrorResponse(err error) (status int, code, msg string) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."
+	case errors.Is(err, auth.ErrSignatureMismatch):
+		return http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided. Check your key and signing method."
+	case errors.Is(err, auth.ErrSkewedDate):
+		return http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the current time is too large."
+	case errors.Is(err, auth.ErrSignatureExpired):
+		return http.StatusForbidden, "AccessDenied", "Request has expired"
+	case errors.Is(err, auth.ErrMalformedAuth), errors.Is(err, auth.ErrBadCredentialScope):
+		return http.StatusBadRequest, "AuthorizationHeaderMalformed", err.Error()
+	case errors.Is(err, auth.ErrUnsuportedAlgorithm):
+		return http.StatusBadRequest, "InvalidRequest", "The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256."
+	case errors.Is(err, auth.ErrMissingDate), errors.Is(err, auth.ErrBadDate):
+		return http.StatusForbidden, "AccessDenied", "AWS authentication requires a valid Date or x-amz-date header"
+	case errors.Is(err, auth.ErrHeaderNotSigned):
+		return http.StatusForbidden, "AccessDenied", "There were headers present in the request which were not signed: " +
+			strings.TrimPrefix(err.Error(), auth.ErrHeaderNotSigned.Error()+": ")
+	case errors.Is(err, auth.ErrNoAuthHeader), errors.Is(err, auth.ErrUnsignedPayload), errors.Is(err, auth.ErrReplayed):
+		return http.StatusForbidden, "AccessDenied", err.Error()
+	}
+	return http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."
+}
+
+// onAuthFailure — учёт неудачи, аудит, блокировка при превышении порога и
+// задержка ответа (tarpit) до неё.
+func (s *Server) onAuthFailure(r *http.Request, akid, ip string, err error) {
+	reason := "bad_signature"
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		reason = "unknown_access_key"
+	case errors.Is(err, auth.ErrSkewedDate):
+		reason = "clock_skew"
+	case errors.Is(err, auth.ErrSignatureExpired):
+		reason = "expired"
+	case errors.Is(err, auth.ErrReplayed):
+		reason = "replay"
+	case errors.Is(err, auth.ErrHeaderNotSigned):
+		reason = "unsigned_header"
+	case errors.Is(err, auth.ErrNoAuthHeader), errors.Is(err, auth.ErrUnsuportedAlgorithm),
+		errors.Is(err, auth.ErrMalformedAuth), errors.Is(err, auth.ErrBadCredentialScope),
+		errors.Is(err, auth.ErrMissingDate), errors.Is(err, auth.ErrBadDate):
+		reason = "malformed"
+	}
+	mAuthFailures.Inc(reason)
+	s.audit.Warn("auth.failure", "access_key", akid, "ip", ip, "reason", reason,
+		"method", r.Method, "path", r.URL.Path, "req_id", requestIDFrom(r))
+
+	ipKey, pairKey, akKey := authThrottleKeys(ip, akid)
+	if d := s.authThrottle.Fail(pairKey); d > 0 {
+		mAuthLockouts.Inc("access_key_ip")
+		s.audit.Warn("auth.lockout", "access_key", akid, "ip", ip, "duration", d.String())
+	}
+	s.authThrottle.Slow(akKey)
+	if d := s.authThrottle.Fail(ipKey); d > 0 {
+		mAuthLockouts.Inc("ip")
+		s.audit.Warn("auth.lockout", "ip", ip, "duration", d.String())
+	}
+
+	// tarpit: ответ об ошибке уходит с задержкой; горутина спит, БД не трогаем
+	if d := s.authThrottle.Delay(akKey, pairKey, ipKey); d > 0 {
+		mAuthTarpitted.Inc()
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+		}
+	}
+}
+
 func getUserIDFromCtx(ctx context.Context) uint {
 	if v := ctx.Value(ctxUserKey); v != nil {
 		if id, ok := v.(uint); ok {
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var (
+	mAuthFailures = metrics.NewCounterVec("s3mini_auth_failures_total",
+		"Failed signature verifications by reason.", "reason")
+	mAuthLockouts = metrics.NewCounterVec("s3mini_auth_lockouts_total",
+		"Temporary lockouts applied after repeated auth failures.", "scope")
+	mAuthRejectedLocked = metrics.NewCounter("s3mini_auth_rejected_locked_total",
+		"Requests rejected because the source IP or the access key from that IP is locked out.")
+	mAuthLockedPrincipals = metrics.NewGauge("s3mini_auth_locked_principals",
+		"Source IPs and access key + IP pairs currently locked out.")
+	mAuthTarpitted = metrics.NewCounter("s3mini_auth_tarpitted_total",
+		"Failed authentication responses delayed by the tarpit.")
+)
+
+// authThrottle считает неудачные попытки подписи по IP, по паре IP + access
+// key и по access key: до порога ответ на каждую следующую ошибку
+// задерживается всё дольше (tarpit), после порога IP или пара блокируется
+// с экспоненциальным ростом. Сам access key только замедляется (Slow): ID
+// ключа не секрет, и блокировка по нему позволила бы кому угодно запереть
+// владельца.
+type authThrottle struct {
+	mu        sync.Mutex
+	entries   map[string]*authFailEntry
+	threshold int           // сколько ошибок подряд допускаем без блокировки
+	base      time.Duration // первая блокировка
+	max       time.Duration // потолок блокировки
+	window    time.Duration // через сколько тишины счётчик сбрасывается
+	delayBase time.Duration // задержка ответа после первой ошибки, дальше x2; 0 — без задержки
+	delayMax  time.Duration // потолок задержки
+	now       func() time.Time
+}
+
+type authFailEntry struct {
+	fails       int
+	lastFail    time.Time
+	lockedUntil time.Time
+}
+
+func newAuthThrottle(threshold int, base, max, delayBase, delayMax time.Duration) *authThrottle {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if base <= 0 {
+		base = 30 * time.Second
+	}
+	if max < base {
+		max = base
+	}
+	if delayMax < delayBase {
+		delayMax = delayBase
+	}
+	return &authThrottle{
+		entries:   make(map[string]*authFailEntry),
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		window:    max * 2,
+		delayBase: delayBase,
+		delayMax:  delayMax,
+		now:       time.Now,
+	}
+}
+
+// Locked — заблокирован ли хоть один из ключей; возвращает оставшееся время.
+func (t *authThrottle) Locked(keys ...string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var left time.Duration
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		if e, ok := t.entries[k]; ok && now.Before(e.lockedUntil) {
+			if d := e.lockedUntil.Sub(now); d > left {
+				left = d
+			}
+		}
+	}
+	return left, left > 0
+}
+
+// authThrottleKeys — ключи счётчиков запроса: IP, пара IP + access key и
+// access key (без access key два последних пустые).
+func authThrottleKeys(ip, akid string) (ipKey, pairKey, akKey string) {
+	ipKey = "ip:" + ip
+	if akid != "" {
+		pairKey, akKey = "ipak:"+ip+"|"+akid, "ak:"+akid
+	}
+	return ipKey, pairKey, akKey
+}
+
+// Fail регистрирует неудачу; если ключ перешёл порог — возвращает длительность новой блокировки.
+func (t *authThrottle) Fail(key string) time.Duration {
+	if key == "" {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	e := t.countLocked(key, now)
+	if e.fails < t.threshold {
+		return 0
+	}
+	// base, 2*base, 4*base ... до max
+	d := t.base
+	for i := t.threshold; i < e.fails && d < t.max; i++ {
+		d *= 2
+	}
+	if d > t.max {
+		d = t.max
+	}
+	e.lockedUntil = now.Add(d)
+	t.updateGaugeLocked(now)
+	return d
+}
+
+// Slow регистрирует неудачу только для задержки ответа: ключ не блокируется.
+func (t *authThrottle) Slow(key string) {
+	if key == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.countLocked(key, t.now())
+}
+
+func (t *authThrottle) countLocked(key string, now time.Time) *authFailEntry {
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.lastFail) > t.window {
+		e = &authFailEntry{}
+		t.entries[key] = e
+	}
+	e.fails++
+	e.lastFail = now
+	if len(t.entries) > 10000 {
+		t.sweepLocked(now)
+	}
+	return e
+}
+
+// Delay — на сколько задержать ответ об ошибке: delayBase, 2*delayBase ...
+// по самому «провинившемуся» из ключей, не больше delayMax. Перебор паролей
+// замедляется ещё до блокировки, а обычная опечатка стоит доли секунды.
+func (t *authThrottle) Delay(keys ...string) time.Duration {
+	if t.delayBase <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fails := 0
+	for _, k := range keys {
+		if e, ok := t.entries[k]; ok && e.fails > fails {
+			fails = e.fails
+		}
+	}
+	if fails == 0 {
+		return 0
+	}
+	d := t.delayBase
+	for i := 1; i < fails && d < t.delayMax; i++ {
+		d *= 2
+	}
+	return min(d, t.delayMax)
+}
+
+// Success сбрасывает счётчик пары IP + access key. Счётчики IP и самого access
+// key гаснут сами по окну: успех владельца не должен обнулять задержку для
+// того, кто перебирает подписи его ключа с других адресов.
+func (t *authThrottle) Success(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+func (t *authThrottle) sweepLocked(now time.Time) {
+	for k, e := range t.entries {
+		if now.Sub(e.lastFail) > t.window && now.After(e.lockedUntil) {
+			delete(t.entries, k)
+		}
+	}
+}
+
+func (t *authThrottle) updateGaugeLocked(now time.Time) {
+	n := 0
+	for _, e := range t.entries {
+		if now.Before(e.lockedUntil) {
+			n++
+		}
+	}
+	mAuthLockedPrincipals.Set(float64(n))
+}
+
+// sourceIP — IP клиента без порта.
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// Перебор подписей чужого ключа блокирует адрес перебирающего, но не
+// владельца: ID ключа не секрет.
+func TestAuthThrottleLocksPairNotKey(t *testing.T) {
+	at := newAuthThrottle(3, time.Minute, time.Hour, 0, 0)
+	for range 5 {
+		ipKey, pairKey, akKey := authThrottleKeys("203.0.113.9", "VICTIM")
+		at.Fail(pairKey)
+		at.Slow(akKey)
+		at.Fail(ipKey)
+	}
+	ipKey, pairKey, _ := authThrottleKeys("203.0.113.9", "VICTIM")
+	if _, locked := at.Locked(ipKey, pairKey); !locked {
+		t.Fatal("attacker's address is not locked")
+	}
+	ipKey, pairKey, akKey := authThrottleKeys("198.51.100.7", "VICTIM")
+	if left, locked := at.Locked(ipKey, pairKey); locked {
+		t.Fatalf("owner is locked out for %s", left)
+	}
+	if _, locked := at.Locked(akKey); locked {
+		t.Fatal("access key itself is locked")
+	}
+}
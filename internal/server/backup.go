@@ -0,0 +1,227 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var mBackups = metrics.NewCounterVec("s3mini_backups_total",
+	"Online metadata backups, by result.", "result")
+
+// Онлайн-бэкап (только SQLite): tar со снимком базы (meta.db), списком блобов,
+// чьи байты нужны снимку (blobs.jsonl), и заголовком backup.json. Сами байты в
+// архив не входят — их копируют из data_dir по списку; блоб, который после
+// снимка остался без ссылок, GC удалит не раньше чем через GC_GRACE_S.
+
+const backupFormat = "s3mini-backup"
+
+type backupInfo struct {
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	DBBytes   int64     `json:"db_bytes"`
+	Blobs     int       `json:"blobs"`
+	BlobBytes int64     `json:"blob_bytes"` // сколько займут байты блобов на диске
+}
+
+// backupBlob — строка blobs.jsonl.
+type backupBlob struct {
+	ID         string `json:"id"`
+	Node       string `json:"node"`
+	Size       int64  `json:"size"`
+	StoredSize int64  `json:"stored_size,omitempty"`
+	Encoding   string `json:"encoding,omitempty"`
+	Checksum   string `json:"checksum"`
+}
+
+// backupSnapshot — снимок и список блобов во временном каталоге рядом с базой.
+type backupSnapshot struct {
+	dir  string
+	info backupInfo
+}
+
+func (bs *backupSnapshot) Close() error { return os.RemoveAll(bs.dir) }
+
+// prepareBackup снимает базу и составляет список её блобов.
+func (s *Server) prepareBackup(ctx context.Context) (*backupSnapshot, error) {
+	file, err := s.db.SQLiteFile()
+	if err != nil {
+		return nil, err
+	}
+	// рядом с базой: снимок размером с неё, а в /tmp места может не быть
+	dir, err := os.MkdirTemp(filepath.Dir(file), ".s3mini-backup-")
+	if err != nil {
+		return nil, err
+	}
+	bs := &backupSnapshot{dir: dir, info: backupInfo{Format: backupFormat, CreatedAt: time.Now().UTC()}}
+	if err := bs.fill(ctx, s.db); err != nil {
+		_ = bs.Close()
+		return nil, err
+	}
+	return bs, nil
+}
+
+func (bs *backupSnapshot) fill(ctx context.Context, database *db.DB) error {
+	snap := filepath.Join(bs.dir, "meta.db")
+	if err := database.BackupSQLite(ctx, snap); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(bs.dir, "blobs.jsonl"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	err = db.SnapshotBlobs(ctx, snap, func(b *db.Blob) error {
+		line := backupBlob{ID: b.ID, Node: storageNode(b.StorageNode), Size: b.Size, Checksum: b.Checksum}
+		stored := b.Size
+		if b.Encoding != "" {
+			line.Encoding, line.StoredSize, stored = b.Encoding, b.StoredSize, b.StoredSize
+		}
+		bs.info.Blobs++
+		bs.info.BlobBytes += stored
+		return enc.Encode(line)
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	// снимок открывали ради списка — размер берётся уже после этого
+	fi, err := os.Stat(snap)
+	if err != nil {
+		return err
+	}
+	bs.info.DBBytes = fi.Size()
+	return f.Close()
+}
+
+// writeTar пишет архив: backup.json, meta.db, blobs.jsonl.
+func (bs *backupSnapshot) writeTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	head, err := json.MarshalIndent(bs.info, "", "  ")
+	if err != nil {
+		return err
+	}
+	mtime := bs.info.CreatedAt.Truncate(time.Second)
+	if err := tw.WriteHeader(&tar.Header{Name: "backup.json", Mode: 0o644, Size: int64(len(head)), ModTime: mtime}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(head); err != nil {
+		return err
+	}
+	for _, name := range []string{"meta.db", "blobs.jsonl"} {
+		if err := tarFile(tw, filepath.Join(bs.dir, name), name, mtime); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func tarFile(tw *tar.Writer, path, name string, mtime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: fi.Size(), ModTime: mtime}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// GET /admin/v1/backup — архив потоком в ответе;
+// POST /admin/v1/backup?bucket=&key= — объектом в бакет (key по умолчанию backups/s3mini-<время>.tar).
+func (s *Server) handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	log := loggerFrom(r)
+	var target *db.Bucket
+	key := r.URL.Query().Get("key")
+	if r.Method == http.MethodPost {
+		name := r.URL.Query().Get("bucket")
+		if name == "" {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "bucket is required")
+			return
+		}
+		b, err := s.db.FindBucketByName(name)
+		if errors.Is(err, db.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "NoSuchBucket", "bucket not found")
+			return
+		}
+		if err != nil {
+			log.Error("admin.backup.bucket_lookup_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		target = b
+	}
+
+	start := time.Now()
+	bs, err := s.prepareBackup(r.Context())
+	if errors.Is(err, db.ErrBackupUnsupported) {
+		writeJSONError(w, http.StatusNotImplemented, "NotImplemented", "online backup needs SQLite; use pg_dump/mysqldump or s3mini export")
+		return
+	}
+	if err != nil {
+		mBackups.Inc("failed")
+		log.Error("admin.backup.snapshot_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "snapshot failed")
+		return
+	}
+	defer bs.Close()
+	if key == "" {
+		key = "backups/s3mini-" + bs.info.CreatedAt.Format("20060102T150405Z") + ".tar"
+	}
+
+	if target == nil {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(key)+`"`)
+		w.WriteHeader(http.StatusOK)
+		if err := bs.writeTar(w); err != nil {
+			// заголовки уже ушли — клиент увидит оборванный архив
+			mBackups.Inc("failed")
+			log.Error("admin.backup.stream_fail", "err", err)
+			return
+		}
+		mBackups.Inc("ok")
+		log.Warn("admin.backup.streamed", "db_bytes", bs.info.DBBytes, "blobs", bs.info.Blobs,
+			"dur_ms", time.Since(start).Milliseconds())
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() { _ = pw.CloseWithError(bs.writeTar(pw)) }()
+	verID, err := s.putInternalObject(r.Context(), target, key, "application/x-tar", pr)
+	_ = pr.Close()
+	if err != nil {
+		mBackups.Inc("failed")
+		log.Error("admin.backup.write_fail", "bucket", target.Name, "key", key, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "cannot write backup object")
+		return
+	}
+	mBackups.Inc("ok")
+	log.Warn("admin.backup.written", "bucket", target.Name, "key", key, "db_bytes", bs.info.DBBytes,
+		"blobs", bs.info.Blobs, "dur_ms", time.Since(start).Milliseconds())
+	writeJSON(w, http.StatusOK, map[string]any{
+		"bucket": target.Name, "key": key, "version_id": apiVersionID(verID), "backup": bs.info,
+	})
+}
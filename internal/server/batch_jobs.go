@@ -0,0 +1,819 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+	"gorm.io/gorm"
+)
+
+// Пакетные задания (в духе S3 Batch Operations): манифест — CSV-объект со строками
+// bucket,key[,version_id]; действие применяется к каждой строке в фоне, с повторами,
+// прогрессом в БД и отчётом об отказах в виде объекта по завершении.
+// Исполнение at-least-once: после падения задание продолжается с последнего
+// чекпоинта, и задачи после него могут выполниться повторно.
+
+const (
+	jobActionCopy    = "copy"
+	jobActionDelete  = "delete"
+	jobActionWebhook = "webhook"
+	jobActionTag     = "tag"
+	jobActionRestore = "restore"
+
+	jobDefaultRetries  = 3
+	jobMaxRetries      = 10
+	jobCheckpointTasks = 100
+	jobCheckpointEvery = 5 * time.Second
+	jobRetryBase       = 200 * time.Millisecond
+	jobWebhookTimeout  = 10 * time.Second
+)
+
+var mBatchTasks = metrics.NewCounterVec("s3mini_batch_tasks_total",
+	"Batch job tasks by action and result.", "action", "result") // ok|failed|retry
+
+var jobHTTPClient = &http.Client{Timeout: jobWebhookTimeout}
+
+// jobParams — параметры действия (JSON в BatchJob.Params).
+type jobParams struct {
+	TargetBucket string            `json:"target_bucket,omitempty"` // copy
+	TargetPrefix string            `json:"target_prefix,omitempty"` // copy: префикс к ключу источника
+	URL          string            `json:"url,omitempty"`           // webhook
+	Tags         map[string]string `json:"tags,omitempty"`          // tag: новый набор тегов версии целиком
+	RestoreDays  int               `json:"restore_days,omitempty"`  // restore
+}
+
+type createJobRequest struct {
+	Manifest struct {
+		Bucket string `json:"bucket"`
+		Key    string `json:"key"`
+	} `json:"manifest"`
+	Action string    `json:"action"`
+	Params jobParams `json:"params"`
+	Report *struct {
+		Bucket string `json:"bucket"`
+		Prefix string `json:"prefix"`
+	} `json:"report,omitempty"`
+	MaxRetries *int `json:"max_retries,omitempty"`
+}
+
+// jobView — задание в ответе API (Params раскрыт).
+type jobView struct {
+	*db.BatchJob
+	Params json.RawMessage `json:"params"`
+}
+
+func viewJob(j *db.BatchJob) jobView {
+	return jobView{BatchJob: j, Params: json.RawMessage(j.Params)}
+}
+
+// jobTask — одна строка манифеста.
+type jobTask struct {
+	Line      int64
+	Bucket    string
+	Key       string
+	VersionID string
+}
+
+// taskFailed — отказ, который повтор не исправит (нет объекта, окно защиты, 4xx вебхука).
+type taskFailed struct{ msg string }
+
+func (e *taskFailed) Error() string { return e.msg }
+
+// --------------------------- админский API ---------------------------
+
+// /admin/v1/jobs — POST создать, GET список (?status=&limit=).
+func (s *Server) handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	log := loggerFrom(r)
+	if r.Method == http.MethodGet {
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "limit must be 1..1000")
+				return
+			}
+			limit = n
+		}
+		jobs, err := s.db.ListBatchJobs(r.URL.Query().Get("status"), limit)
+		if err != nil {
+			log.Error("admin.jobs.list_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		out := make([]jobView, 0, len(jobs))
+		for i := range jobs {
+			out = append(out, viewJob(&jobs[i]))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"jobs": out})
+		return
+	}
+
+	var req createJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "MalformedJSON", err.Error())
+		return
+	}
+	j, status, code, msg := s.validateJob(&req)
+	if j == nil {
+		if status == http.StatusInternalServerError {
+			log.Error("admin.jobs.validate_fail", "err", msg)
+			msg = "db error"
+		}
+		writeJSONError(w, status, code, msg)
+		return
+	}
+	if u, err := s.db.FindUserByID(getUserIDFromCtx(r.Context())); err == nil {
+		j.CreatedBy = u.AccessKeyID
+	}
+	if err := s.db.CreateBatchJob(j); err != nil {
+		log.Error("admin.jobs.create_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	log.Info("admin.jobs.created", "job_id", j.ID, "action", j.Action,
+		"manifest", j.ManifestBucket+"/"+j.ManifestKey)
+	writeJSON(w, http.StatusCreated, viewJob(j))
+}
+
+// validateJob проверяет запрос и собирает задание; j == nil — отказ (status, code, msg).
+func (s *Server) validateJob(req *createJobRequest) (j *db.BatchJob, status int, code, msg string) {
+	if req.Action == "set-tagging" {
+		req.Action = jobActionTag
+	}
+	switch req.Action {
+	case jobActionCopy:
+		if req.Params.TargetBucket == "" {
+			return nil, http.StatusBadRequest, "InvalidArgument", "params.target_bucket is required for copy"
+		}
+		if _, err := s.db.FindBucketByName(req.Params.TargetBucket); err != nil {
+			return nil, bucketLookupStatus(err), "NoSuchBucket", "target bucket not found"
+		}
+	case jobActionDelete:
+	case jobActionWebhook:
+		u, err := url.Parse(req.Params.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, http.StatusBadRequest, "InvalidArgument", "params.url must be an http(s) URL"
+		}
+	case jobActionTag:
+		kv := make([][2]string, 0, len(req.Params.Tags))
+		for k, v := range req.Params.Tags {
+			kv = append(kv, [2]string{k, v})
+		}
+		if _, err := validateTags(kv, maxObjectTags); err != nil {
+			return nil, http.StatusBadRequest, "InvalidTag", err.Error()
+		}
+	case jobActionRestore:
+		if req.Params.RestoreDays < 1 {
+			return nil, http.StatusBadRequest, "InvalidArgument", "params.restore_days must be a positive integer"
+		}
+	default:
+		return nil, http.StatusBadRequest, "InvalidArgument", "action must be copy, delete, tag, restore or webhook"
+	}
+
+	retries := jobDefaultRetries
+	if req.MaxRetries != nil {
+		retries = *req.MaxRetries
+	}
+	if retries < 0 || retries > jobMaxRetries {
+		return nil, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("max_retries must be 0..%d", jobMaxRetries)
+	}
+
+	// манифест фиксируется по версии: правки объекта не меняют уже созданное задание
+	mb, err := s.db.FindBucketByName(req.Manifest.Bucket)
+	if err != nil {
+		return nil, bucketLookupStatus(err), "NoSuchBucket", "manifest bucket not found"
+	}
+	ver, err := s.resolveVersionTx(s.db.DB, mb.ID, req.Manifest.Key, "")
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+		return nil, http.StatusBadRequest, "NoSuchKey", "manifest object not found"
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, "InternalError", err.Error()
+	}
+
+	params, _ := json.Marshal(req.Params)
+	j = &db.BatchJob{
+		ID:                s.db.GenVersionID(),
+		Action:            req.Action,
+		Params:            string(params),
+		ManifestBucket:    mb.Name,
+		ManifestKey:       req.Manifest.Key,
+		ManifestVersionID: ver.VersionID,
+		MaxRetries:        retries,
+		Status:            db.JobStatusNew,
+	}
+	if rp := req.Report; rp != nil && rp.Bucket != "" {
+		if _, err := s.db.FindBucketByName(rp.Bucket); err != nil {
+			return nil, bucketLookupStatus(err), "NoSuchBucket", "report bucket not found"
+		}
+		j.ReportBucket, j.ReportPrefix = rp.Bucket, rp.Prefix
+	}
+	return j, 0, "", ""
+}
+
+func bucketLookupStatus(err error) int {
+	if errors.Is(err, db.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// GET /admin/v1/jobs/{id}
+func (s *Server) handleAdminJob(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	j, ok := s.adminJob(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, viewJob(j))
+}
+
+// POST /admin/v1/jobs/{id}/cancel — воркер остановится на ближайшем чекпоинте.
+func (s *Server) handleAdminJobCancel(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	j, ok := s.adminJob(w, r)
+	if !ok {
+		return
+	}
+	cancelled, err := s.db.CancelBatchJob(j.ID)
+	if err != nil {
+		loggerFrom(r).Error("admin.jobs.cancel_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	if !cancelled {
+		writeJSONError(w, http.StatusConflict, "JobFinished", "job is already "+j.Status)
+		return
+	}
+	loggerFrom(r).Info("admin.jobs.cancelled", "job_id", j.ID)
+	j.Status = db.JobStatusCancelled
+	writeJSON(w, http.StatusOK, viewJob(j))
+}
+
+// GET /admin/v1/jobs/{id}/failures?after=LINE&limit=
+func (s *Server) handleAdminJobFailures(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	j, ok := s.adminJob(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	after, _ := strconv.ParseInt(q.Get("after"), 10, 64)
+	limit := 1000
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "limit must be 1..1000")
+			return
+		}
+		limit = n
+	}
+	fs, err := s.db.ListBatchJobFailures(j.ID, after, limit)
+	if err != nil {
+		loggerFrom(r).Error("admin.jobs.failures_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	if fs == nil {
+		fs = []db.BatchJobFailure{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"job_id": j.ID, "failures": fs})
+}
+
+func (s *Server) adminJob(w http.ResponseWriter, r *http.Request) (*db.BatchJob, bool) {
+	j, err := s.db.GetBatchJob(r.PathValue("id"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchJob", "job not found")
+		return nil, false
+	}
+	if err != nil {
+		loggerFrom(r).Error("admin.jobs.lookup_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return nil, false
+	}
+	return j, true
+}
+
+// ------------------------------ воркер ------------------------------
+
+// StartBatchJobs — раз в every берёт незавершённые задания и выполняет их по очереди.
+func (s *Server) StartBatchJobs(ctx context.Context, every time.Duration) {
+	log := s.Logger.With(slog.String("comp", "batch_jobs"))
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if s.isReadOnly() || !s.holdLease(log, "batch_jobs", every) {
+					continue
+				}
+				for ctx.Err() == nil {
+					j, err := s.db.NextRunnableBatchJob()
+					if err != nil {
+						log.Error("batch_job.next_fail", "err", err)
+						break
+					}
+					if j == nil || !s.runBatchJob(ctx, log.With(slog.String("job_id", j.ID)), j, every) {
+						break
+					}
+				}
+			}
+		}
+	}()
+}
+
+// jobRun — состояние выполнения задания между чекпоинтами.
+type jobRun struct {
+	s        *Server
+	log      *slog.Logger
+	job      *db.BatchJob
+	params   jobParams
+	every    time.Duration
+	pending  []db.BatchJobFailure
+	lastSave time.Time
+}
+
+// runBatchJob выполняет задание с его курсора. false — задание не доведено до
+// конца или его статус не сохранён (остановка, потерян lease, ошибка БД):
+// брать следующее не нужно.
+func (s *Server) runBatchJob(ctx context.Context, log *slog.Logger, j *db.BatchJob, every time.Duration) bool {
+	jr := &jobRun{s: s, log: log, job: j, every: every, lastSave: time.Now()}
+	if err := json.Unmarshal([]byte(j.Params), &jr.params); err != nil {
+		return jr.fail(fmt.Errorf("bad params: %w", err))
+	}
+
+	if j.Status == db.JobStatusNew {
+		total, err := s.countManifest(ctx, j)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			return jr.fail(fmt.Errorf("manifest: %w", err))
+		}
+		j.Total, j.Status = total, db.JobStatusRunning
+		if err := s.db.UpdateBatchJob(j.ID, map[string]any{"total": total, "status": db.JobStatusRunning}); err != nil {
+			log.Error("batch_job.start_fail", "err", err)
+			return false
+		}
+		log.Info("batch_job.started", "action", j.Action, "total", total)
+	} else {
+		log.Info("batch_job.resumed", "action", j.Action, "processed", j.Cursor, "total", j.Total)
+	}
+
+	rc, err := s.openManifest(ctx, j)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		return jr.fail(fmt.Errorf("manifest: %w", err))
+	}
+	defer rc.Close()
+	cr := newManifestReader(rc)
+
+	var line int64
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		var perr *csv.ParseError
+		if err != nil && !errors.As(err, &perr) {
+			if ctx.Err() != nil {
+				jr.checkpoint()
+				return false
+			}
+			return jr.fail(fmt.Errorf("manifest line %d: %w", line, err))
+		}
+		if line <= j.Cursor {
+			continue // уже обработано до перезапуска
+		}
+		if s.isReadOnly() {
+			// остаток — когда сервер выйдет из режима только чтения
+			jr.checkpoint()
+			log.Info("batch_job.paused", "reason", "read_only", "processed", j.Cursor)
+			return false
+		}
+
+		var terr error
+		t, ok := parseManifestRecord(line, rec)
+		if err != nil {
+			terr = &taskFailed{msg: "malformed manifest line: " + err.Error()}
+		} else if !ok {
+			terr = &taskFailed{msg: "manifest line must be bucket,key[,version_id]"}
+		} else {
+			terr = jr.runWithRetries(ctx, t)
+			if ctx.Err() != nil {
+				// задачу, прерванную остановкой, повторим после перезапуска
+				jr.checkpoint()
+				return false
+			}
+		}
+		jr.record(t, terr)
+
+		if len(jr.pending) >= jobCheckpointTasks || line%jobCheckpointTasks == 0 ||
+			time.Since(jr.lastSave) >= jobCheckpointEvery {
+			if !jr.checkpoint() {
+				return false
+			}
+			cur, err := s.db.GetBatchJob(j.ID)
+			if err != nil {
+				log.Error("batch_job.reload_fail", "err", err)
+				return false
+			}
+			if cur.Status == db.JobStatusCancelled {
+				log.Info("batch_job.cancelled", "processed", j.Cursor)
+				return true
+			}
+			if !s.holdLease(log, "batch_jobs", every) {
+				log.Warn("batch_job.lease_lost", "processed", j.Cursor)
+				return false
+			}
+		}
+	}
+	if !jr.checkpoint() {
+		return false
+	}
+	return jr.finish(ctx)
+}
+
+// record учитывает результат задачи.
+func (jr *jobRun) record(t jobTask, err error) {
+	jr.job.Cursor = t.Line
+	if err == nil {
+		jr.job.Succeeded++
+		mBatchTasks.Inc(jr.job.Action, "ok")
+		return
+	}
+	jr.job.Failed++
+	mBatchTasks.Inc(jr.job.Action, "failed")
+	jr.log.Warn("batch_job.task_failed", "line", t.Line, "bucket", t.Bucket, "key", t.Key, "err", err)
+	jr.pending = append(jr.pending, db.BatchJobFailure{
+		JobID: jr.job.ID, Line: t.Line, Bucket: t.Bucket, Key: t.Key, VersionID: t.VersionID, Error: err.Error(),
+	})
+}
+
+func (jr *jobRun) checkpoint() bool {
+	j := jr.job
+	err := jr.s.db.CheckpointBatchJob(j.ID, map[string]any{
+		"cursor": j.Cursor, "succeeded": j.Succeeded, "failed": j.Failed,
+	}, jr.pending)
+	if err != nil {
+		jr.log.Error("batch_job.checkpoint_fail", "err", err)
+		return false
+	}
+	jr.pending = nil
+	jr.lastSave = time.Now()
+	return true
+}
+
+func (jr *jobRun) fail(err error) bool {
+	jr.log.Error("batch_job.failed", "err", err)
+	now := time.Now().UTC()
+	if err := jr.s.db.FinishBatchJob(jr.job.ID, map[string]any{
+		"status": db.JobStatusFailed, "error": err.Error(), "finished_at": &now,
+	}); err != nil {
+		jr.log.Error("batch_job.save_fail", "err", err)
+		return false
+	}
+	return true
+}
+
+func (jr *jobRun) finish(ctx context.Context) bool {
+	j := jr.job
+	fields := map[string]any{"status": db.JobStatusComplete}
+	if j.ReportBucket != "" {
+		key, err := jr.s.writeJobReport(ctx, j)
+		if err != nil {
+			// задачи выполнены, отчёт можно получить через API отказов
+			jr.log.Error("batch_job.report_fail", "err", err)
+			fields["error"] = "report: " + err.Error()
+		} else {
+			fields["report_key"] = key
+		}
+	}
+	now := time.Now().UTC()
+	fields["finished_at"] = &now
+	if err := jr.s.db.FinishBatchJob(j.ID, fields); err != nil {
+		jr.log.Error("batch_job.save_fail", "err", err)
+		return false
+	}
+	jr.log.Info("batch_job.complete", "total", j.Total, "succeeded", j.Succeeded, "failed", j.Failed)
+	return true
+}
+
+// runWithRetries — задача с повторами и экспоненциальной паузой; taskFailed не повторяется.
+func (jr *jobRun) runWithRetries(ctx context.Context, t jobTask) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = jr.runTask(ctx, t)
+		var tf *taskFailed
+		if err == nil || errors.As(err, &tf) || attempt >= jr.job.MaxRetries {
+			return err
+		}
+		mBatchTasks.Inc(jr.job.Action, "retry")
+		jr.log.Info("batch_job.task_retry", "line", t.Line, "attempt", attempt+1, "err", err)
+		if !sleepCtx(ctx, jobRetryBase<<attempt) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (jr *jobRun) runTask(ctx context.Context, t jobTask) error {
+	switch jr.job.Action {
+	case jobActionCopy:
+		return jr.s.jobCopy(t, jr.params)
+	case jobActionDelete:
+		return jr.s.jobDelete(ctx, jr.log, t)
+	case jobActionWebhook:
+		return jobWebhook(ctx, jr.job.ID, jr.params.URL, t)
+	case jobActionTag:
+		return jr.s.jobTag(t, jr.params)
+	case jobActionRestore:
+		return jr.s.jobRestore(t, jr.params)
+	}
+	return &taskFailed{msg: "unknown action " + jr.job.Action}
+}
+
+// --------------------------- действия ---------------------------
+
+// jobCopy — новая версия target_prefix+key в целевом бакете на тот же блоб (без копирования байт).
+func (s *Server) jobCopy(t jobTask, p jobParams) error {
+	src, err := s.db.FindBucketByName(t.Bucket)
+	if errors.Is(err, db.ErrNotFound) {
+		return &taskFailed{msg: "NoSuchBucket"}
+	}
+	if err != nil {
+		return err
+	}
+	dst, err := s.db.FindBucketByName(p.TargetBucket)
+	if errors.Is(err, db.ErrNotFound) {
+		return &taskFailed{msg: "target bucket not found"}
+	}
+	if err != nil {
+		return err
+	}
+	dstKey := p.TargetPrefix + t.Key
+	return s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		ver, err := s.resolveVersionTx(tx, src.ID, t.Key, t.VersionID)
+		if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+			return &taskFailed{msg: "NoSuchKey"}
+		}
+		if err != nil {
+			return err
+		}
+		if archived, err := s.versionArchivedTx(tx, ver); err != nil {
+			return err
+		} else if archived {
+			return &taskFailed{msg: "InvalidObjectState"}
+		}
+		if err := s.db.LockObjectForUpdate(tx, dst.ID, dstKey); err != nil {
+			return err
+		}
+		_, err = s.copyVersionTx(tx, ver, dst.ID, dstKey,
+			coalesce(ver.ContentType, "application/octet-stream"), coalesce(ver.EncryptionContext, ""))
+		return err
+	})
+}
+
+// jobTag — как PUT ?tagging: набор тегов версии заменяется целиком.
+func (s *Server) jobTag(t jobTask, p jobParams) error {
+	b, err := s.db.FindBucketByName(t.Bucket)
+	if errors.Is(err, db.ErrNotFound) {
+		return &taskFailed{msg: "NoSuchBucket"}
+	}
+	if err != nil {
+		return err
+	}
+	return s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		ver, err := s.resolveVersionTx(tx, b.ID, t.Key, t.VersionID)
+		if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+			return &taskFailed{msg: "NoSuchKey"}
+		}
+		if err != nil {
+			return err
+		}
+		return s.db.ReplaceVersionTagsTx(tx, ver, p.Tags)
+	})
+}
+
+// jobRestore — как POST ?restore; восстановление, которое уже идёт, — не отказ.
+func (s *Server) jobRestore(t jobTask, p jobParams) error {
+	b, err := s.db.FindBucketByName(t.Bucket)
+	if errors.Is(err, db.ErrNotFound) {
+		return &taskFailed{msg: "NoSuchBucket"}
+	}
+	if err != nil {
+		return err
+	}
+	return s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		ver, err := s.resolveVersionTx(tx, b.ID, t.Key, t.VersionID)
+		if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+			return &taskFailed{msg: "NoSuchKey"}
+		}
+		if err != nil {
+			return err
+		}
+		if !archiveClasses[ver.StorageClass] {
+			return &taskFailed{msg: "InvalidObjectState"}
+		}
+		_, err = s.requestRestoreTx(tx, ver, p.RestoreDays)
+		return err
+	})
+}
+
+// jobDelete — как DELETE: без version_id ставит delete-marker, с ним удаляет версию.
+func (s *Server) jobDelete(ctx context.Context, log *slog.Logger, t jobTask) error {
+	b, err := s.db.FindBucketByName(t.Bucket)
+	if errors.Is(err, db.ErrNotFound) {
+		return &taskFailed{msg: "NoSuchBucket"}
+	}
+	if err != nil {
+		return err
+	}
+	var res delResult
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		var err error
+		res, err = s.deleteObjectTx(ctx, tx, log.With(slog.String("key", t.Key)), b.ID, t.Key, t.VersionID, false)
+		return err
+	}); err != nil {
+		return err
+	}
+	switch {
+	case res.status == http.StatusNotFound:
+		return &taskFailed{msg: "NoSuchVersion"}
+	case res.locked:
+		return &taskFailed{msg: "version is protected by object lock"}
+	case res.status == http.StatusForbidden:
+		return &taskFailed{msg: "version is inside the bucket protection window"}
+	}
+	return nil
+}
+
+// jobWebhook — POST JSON {job_id, bucket, key, version_id}; 2xx — успех, 4xx — отказ без повтора.
+func jobWebhook(ctx context.Context, jobID, target string, t jobTask) error {
+	body, _ := json.Marshal(map[string]string{
+		"job_id": jobID, "bucket": t.Bucket, "key": t.Key, "version_id": t.VersionID,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return &taskFailed{msg: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := jobHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4:
+		return &taskFailed{msg: "webhook returned " + resp.Status}
+	}
+	return errors.New("webhook returned " + resp.Status)
+}
+
+// --------------------------- манифест и отчёт ---------------------------
+
+func (s *Server) openManifest(ctx context.Context, j *db.BatchJob) (io.ReadCloser, error) {
+	ver, err := s.db.GetVersionTx(s.db.DB, j.ManifestVersionID)
+	if err != nil {
+		return nil, err
+	}
+	if ver.BlobID == nil {
+		return nil, errIsDeleteMarker
+	}
+	return s.openBlob(ctx, *ver.BlobID, 0, -1)
+}
+
+func newManifestReader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return cr
+}
+
+// countManifest — число строк манифеста (для прогресса).
+func (s *Server) countManifest(ctx context.Context, j *db.BatchJob) (int64, error) {
+	rc, err := s.openManifest(ctx, j)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	cr := newManifestReader(rc)
+	var n int64
+	for {
+		_, err := cr.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		var perr *csv.ParseError
+		if err != nil && !errors.As(err, &perr) {
+			return 0, err
+		}
+		n++
+	}
+}
+
+func parseManifestRecord(line int64, rec []string) (jobTask, bool) {
+	t := jobTask{Line: line}
+	if len(rec) < 2 || len(rec) > 3 || rec[0] == "" || rec[1] == "" {
+		return t, false
+	}
+	t.Bucket, t.Key = rec[0], rec[1]
+	if len(rec) == 3 {
+		t.VersionID = rec[2]
+	}
+	return t, true
+}
+
+// writeJobReport кладёт CSV отказов (bucket,key,version_id,error) в report_prefix+<id>.csv.
+func (s *Server) writeJobReport(ctx context.Context, j *db.BatchJob) (string, error) {
+	b, err := s.db.FindBucketByName(j.ReportBucket)
+	if err != nil {
+		return "", err
+	}
+	key := j.ReportPrefix + j.ID + ".csv"
+
+	pr, pw := io.Pipe()
+	go func() {
+		cw := csv.NewWriter(pw)
+		_ = cw.Write([]string{"line", "bucket", "key", "version_id", "error"})
+		var after int64
+		for {
+			fs, err := s.db.ListBatchJobFailures(j.ID, after, 1000)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			for _, f := range fs {
+				_ = cw.Write([]string{strconv.FormatInt(f.Line, 10), f.Bucket, f.Key, f.VersionID, f.Error})
+				after = f.Line
+			}
+			if len(fs) < 1000 {
+				break
+			}
+		}
+		cw.Flush()
+		pw.CloseWithError(cw.Error())
+	}()
+	defer pr.Close()
+
+	if _, err := s.putInternalObject(ctx, b, key, "text/csv", pr); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// putInternalObject — запись объекта самим сервером (отчёты заданий): как PUT,
+// но без HTTP-запроса, хуков и идемпотентности.
+func (s *Server) putInternalObject(ctx context.Context, b *db.Bucket, key, ctype string, body io.Reader) (string, error) {
+	up, err := s.stageUpload(ctx, body, -1, s.uploadOptsFor(b))
+	if err != nil {
+		return "", err
+	}
+	etag := up.ETag()
+	var verID string
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		if err := s.db.LockObjectForUpdate(tx, b.ID, key); err != nil {
+			return err
+		}
+		blobID, size, err := s.adoptUploadTx(ctx, tx, up)
+		if err != nil {
+			return err
+		}
+		verID, err = s.commitVersionTx(tx, b.ID, key, blobID, size, etag, ctype)
+		return err
+	}); err != nil {
+		s.discardStaged(ctx, up, false)
+		return "", err
+	}
+	s.discardStaged(ctx, up, true)
+	return verID, nil
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+	"gorm.io/gorm"
+)
+
+// stagedBlob — байты уже записаны в storage под ID, записи в blobs ещё нет.
+type stagedBlob struct {
+	ID     string
+	Size   int64
+	SHA256 string // hex
+
+	Encoding   string // "" | gzip
+	StoredSize int64  // байт в storage при Encoding != ""
+
+	adopted bool // стал новым блобом в транзакции (см. adoptUploadTx)
+}
+
+func (st *stagedBlob) Checksum() string { return "sha256:" + st.SHA256 }
+
+// md5Check — ожидаемый Content-MD5; сверяется с md5, посчитанным при staging'е.
+type md5Check struct {
+	want string // hex
+}
+
+// newMD5Check разбирает Content-MD5 до чтения тела; nil — заголовка нет.
+// ok=false — заголовок битый, ответ уже записан.
+func newMD5Check(w http.ResponseWriter, r *http.Request) (*md5Check, bool) {
+	v := r.Header.Get("Content-MD5")
+	if v == "" {
+		return nil, true
+	}
+	want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil || len(want) != md5.Size {
+		writeS3Error(w, http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified was invalid.", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return &md5Check{want: hex.EncodeToString(want)}, true
+}
+
+// matches — true, если заголовка не было или md5 прочитанного тела совпал.
+func (c *md5Check) matches(up *stagedUpload) bool {
+	return c == nil || c.want == up.MD5
+}
+
+// stageBlob стримит тело в storage вне транзакции, попутно считая sha256.
+func (s *Server) stageBlob(ctx context.Context, body io.Reader, sizeHint int64) (*stagedBlob, error) {
+	id := s.db.GenBlobID()
+	ws, err := s.storage.Driver().BeginWrite(ctx, storage.BlobID(id), storage.PutOpts{Size: sizeHint})
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	written, err := io.Copy(ws.Writer(), io.TeeReader(body, hasher))
+	if err != nil {
+		_ = ws.Abort(ctx)
+		return nil, err
+	}
+	if err := ws.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &stagedBlob{ID: id, Size: written, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+const blobEncodingGzip = "gzip"
+
+// stageCompressed пишет кусок сжатым, если сжатие экономит хотя бы 10%,
+// иначе как есть (шифрованные/уже сжатые данные бэкапов не жмутся).
+func (s *Server) stageCompressed(ctx context.Context, data []byte) (*stagedBlob, error) {
+	sum := sha256.Sum256(data)
+	var zbuf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&zbuf, gzip.BestSpeed)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	payload, enc := data, ""
+	if zbuf.Len() < len(data)-len(data)/10 {
+		payload, enc = zbuf.Bytes(), blobEncodingGzip
+	}
+
+	id := s.db.GenBlobID()
+	ws, err := s.storage.Driver().BeginWrite(ctx, storage.BlobID(id), storage.PutOpts{Size: int64(len(payload))})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ws.Writer().Write(payload); err != nil {
+		_ = ws.Abort(ctx)
+		return nil, err
+	}
+	if err := ws.Commit(ctx); err != nil {
+		return nil, err
+	}
+	st := &stagedBlob{ID: id, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:]), Encoding: enc}
+	if enc != "" {
+		st.StoredSize = int64(len(payload))
+	}
+	return st, nil
+}
+
+// adoptBlobTx — дедуп по checksum: берём готовый блоб с теми же байтами (staged-копия
+// удаляется) или регистрируем staged как новый блоб. usedNew — staged стал блобом.
+func (s *Server) adoptBlobTx(ctx context.Context, tx *gorm.DB, st *stagedBlob) (blobID string, size int64, usedNew bool, err error) {
+	exist, err := s.db.FindBlobByChecksumTx(tx, st.Checksum())
+	if err == nil {
+		_ = s.storage.Delete(ctx, st.ID)
+		return exist.ID, exist.Size, false, nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return "", 0, false, err
+	}
+	if err := s.db.ReserveBlobPendingTx(tx, st.ID, st.Checksum(), st.Size, "local"); err != nil {
+		return "", 0, false, err
+	}
+	if st.Encoding != "" {
+		if err := s.db.SetBlobEncodingTx(tx, st.ID, st.Encoding, st.StoredSize); err != nil {
+			return "", 0, false, err
+		}
+	}
+	if err := s.db.MarkBlobReadyTx(tx, st.ID); err != nil {
+		return "", 0, false, err
+	}
+	return st.ID, st.Size, true, nil
+}
+
+// stagedUpload — тело PUT, разложенное по одному или нескольким staged-блобам.
+type stagedUpload struct {
+	Parts  []*stagedBlob
+	Size   int64
+	SHA256 string // hex всего тела (дедуп, x-amz-content-sha256)
+	MD5    string // hex всего тела (ETag, Content-MD5)
+}
+
+// ETag — ETag как у S3 для тела одним PUT: md5 в кавычках.
+func (up *stagedUpload) ETag() string { return `"` + up.MD5 + `"` }
+
+// uploadOpts — как раскладывать тело PUT по блобам (зависит от профиля бакета).
+type uploadOpts struct {
+	ChunkSize int64 // 0 — одним блобом
+	Compress  bool  // куски держатся в памяти и жмутся (только для небольших ChunkSize)
+}
+
+// stageUpload пишет тело одним блобом, а если оно больше ChunkSize —
+// кусками по этому размеру (каждый кусок потом дедупится отдельно).
+func (s *Server) stageUpload(ctx context.Context, body io.Reader, sizeHint int64, opts uploadOpts) (*stagedUpload, error) {
+	md5h := md5.New()
+	up, err := s.stageUploadParts(ctx, io.TeeReader(body, md5h), sizeHint, opts)
+	if err != nil {
+		return nil, err
+	}
+	up.MD5 = hex.EncodeToString(md5h.Sum(nil))
+	return up, nil
+}
+
+func (s *Server) stageUploadParts(ctx context.Context, body io.Reader, sizeHint int64, opts uploadOpts) (*stagedUpload, error) {
+	if opts.Compress && opts.ChunkSize > 0 {
+		return s.stageCompressedUpload(ctx, body, opts.ChunkSize)
+	}
+	chunk := opts.ChunkSize
+	if chunk <= 0 || (sizeHint >= 0 && sizeHint <= chunk) {
+		st, err := s.stageBlob(ctx, body, sizeHint)
+		if err != nil {
+			return nil, err
+		}
+		return &stagedUpload{Parts: []*stagedBlob{st}, Size: st.Size, SHA256: st.SHA256}, nil
+	}
+
+	hasher := sha256.New()
+	src := io.TeeReader(body, hasher)
+	up := &stagedUpload{}
+	for {
+		hint := chunk
+		if sizeHint >= 0 && sizeHint-up.Size < chunk {
+			hint = sizeHint - up.Size
+		}
+		st, err := s.stageBlob(ctx, io.LimitReader(src, chunk), hint)
+		if err != nil {
+			s.discardStaged(ctx, up, false)
+			return nil, err
+		}
+		if st.Size == 0 && len(up.Parts) > 0 {
+			// тело кончилось ровно на границе куска
+			_ = s.storage.Delete(ctx, st.ID)
+			break
+		}
+		up.Parts = append(up.Parts, st)
+		up.Size += st.Size
+		if st.Size < chunk {
+			break
+		}
+	}
+	up.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return up, nil
+}
+
+func (s *Server) stageCompressedUpload(ctx context.Context, body io.Reader, chunk int64) (*stagedUpload, error) {
+	hasher := sha256.New()
+	src := io.TeeReader(body, hasher)
+	buf := make([]byte, chunk)
+	up := &stagedUpload{}
+	for {
+		n, rerr := io.ReadFull(src, buf)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			s.discardStaged(ctx, up, false)
+			return nil, rerr
+		}
+		if n > 0 || len(up.Parts) == 0 {
+			st, err := s.stageCompressed(ctx, buf[:n])
+			if err != nil {
+				s.discardStaged(ctx, up, false)
+				return nil, err
+			}
+			up.Parts = append(up.Parts, st)
+			up.Size += st.Size
+		}
+		if rerr != nil {
+			break
+		}
+	}
+	up.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return up, nil
+}
+
+// adoptUploadTx регистрирует staged-куски как блобы (с дедупом по каждому) и
+// возвращает блоб объекта: сам кусок, если он один, иначе manifest поверх кусков.
+func (s *Server) adoptUploadTx(ctx context.Context, tx *gorm.DB, up *stagedUpload) (blobID string, size int64, err error) {
+	if len(up.Parts) == 1 {
+		id, sz, isNew, err := s.adoptBlobTx(ctx, tx, up.Parts[0])
+		up.Parts[0].adopted = isNew
+		return id, sz, err
+	}
+	chunks := make([]db.ChunkRange, 0, len(up.Parts))
+	for _, st := range up.Parts {
+		id, sz, isNew, err := s.adoptBlobTx(ctx, tx, st)
+		if err != nil {
+			return "", 0, err
+		}
+		st.adopted = isNew
+		chunks = append(chunks, db.ChunkRange{BlobID: id, Offset: 0, Size: sz})
+	}
+	blobID, size, _, err = s.db.CreateManifestBlobTx(tx, chunks)
+	return blobID, size, err
+}
+
+// discardStaged удаляет байты staged-кусков; keepAdopted — не трогать те,
+// что стали блобами в закоммиченной транзакции.
+func (s *Server) discardStaged(ctx context.Context, up *stagedUpload, keepAdopted bool) {
+	for _, st := range up.Parts {
+		if keepAdopted && st.adopted {
+			continue
+		}
+		_ = s.storage.Delete(ctx, st.ID)
+	}
+}
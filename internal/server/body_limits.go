@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var mEntityTooLarge = metrics.NewCounter("s3mini_entity_too_large_total",
+	"Uploads rejected with EntityTooLarge by MAX_PUT_SIZE_MB or MAX_OBJECT_SIZE_MB.")
+
+// Пределы размера: MAX_PUT_SIZE_MB — тело одного запроса с данными (PUT,
+// UploadPart, append), MAX_OBJECT_SIZE_MB — итоговая версия (PUT, Complete,
+// compose, append, копии). Тело с Content-Length больше предела отклоняется до
+// чтения, без длины (chunked) — обрывается http.MaxBytesReader на пределе.
+
+var errObjectTooLarge = errors.New("object exceeds the maximum allowed size")
+
+// Пределы в байтах; 0 — без предела.
+func (s *Server) maxPutSize() int64    { return int64(s.cfg.MaxPutSizeMB) << 20 }
+func (s *Server) maxObjectSize() int64 { return int64(s.cfg.MaxObjectSizeMB) << 20 }
+
+// putBodyLimit — предел тела PUT: объект из одного запроса упирается в оба предела.
+func (s *Server) putBodyLimit() int64 {
+	limit, obj := s.maxPutSize(), s.maxObjectSize()
+	if obj > 0 && (limit <= 0 || obj < limit) {
+		limit = obj
+	}
+	return limit
+}
+
+// limitBody ограничивает тело запроса max байтами: больше по Content-Length —
+// сразу 400 EntityTooLarge, иначе чтение за пределом вернёт *http.MaxBytesError.
+func (s *Server) limitBody(w http.ResponseWriter, r *http.Request, max int64) bool {
+	if max <= 0 {
+		return true
+	}
+	if r.ContentLength > max {
+		writeEntityTooLarge(w, r)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+	return true
+}
+
+// checkObjectSize — версия размером size не больше MAX_OBJECT_SIZE_MB.
+func (s *Server) checkObjectSize(size int64) error {
+	if max := s.maxObjectSize(); max > 0 && size > max {
+		return errObjectTooLarge
+	}
+	return nil
+}
+
+// writeTooLargeError отвечает EntityTooLarge, если err — превышение предела
+// тела или объекта.
+func writeTooLargeError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var mbe *http.MaxBytesError
+	if !errors.As(err, &mbe) && !errors.Is(err, errObjectTooLarge) {
+		return false
+	}
+	writeEntityTooLarge(w, r)
+	return true
+}
+
+func writeEntityTooLarge(w http.ResponseWriter, r *http.Request) {
+	mEntityTooLarge.Inc()
+	loggerFrom(r).Warn("upload.entity_too_large", "content_length", r.ContentLength)
+	writeS3Error(w, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed size", r.URL.Path, requestIDFrom(r))
+}
+
+// writeCommitError — ответ на отказ транзакции записи версии по квоте или
+// размеру; false — это другая ошибка.
+func writeCommitError(w http.ResponseWriter, r *http.Request, err error) bool {
+	return writeQuotaError(w, r, err) || writeTooLargeError(w, r, err)
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+	"github.com/DanikLP1/s3-storage-service/internal/policy"
+)
+
+var mBucketPolicyDecisions = metrics.NewCounterVec("s3mini_bucket_policy_decisions_total",
+	"Bucket policy evaluations by decision.", "decision") // allow|deny|nomatch|error
+
+// ctxPrincipalKey — настоящий пользователь, когда запрос выполняется от имени
+// владельца бакета по разрешению политики (0 — аноним).
+const ctxPrincipalKey ctxKey = "auth.principal.ID"
+
+// getPrincipalIDFromCtx — кто на самом деле прислал запрос.
+func getPrincipalIDFromCtx(ctx context.Context) uint {
+	if v, ok := ctx.Value(ctxPrincipalKey).(uint); ok {
+		return v
+	}
+	return getUserIDFromCtx(ctx)
+}
+
+// s3Action — имя действия IAM для запроса.
+func s3Action(r *http.Request, key string) string {
+	q := r.URL.Query()
+	has := func(name string) bool { _, ok := q[name]; return ok }
+	byMethod := func(get, put, del string) string {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return get
+		case http.MethodDelete:
+			return del
+		}
+		return put
+	}
+	versioned := func(a string) string {
+		if q.Get("versionId") != "" {
+			return a + "Version"
+		}
+		return a
+	}
+
+	if key == "" {
+		switch {
+		case has("policy"):
+			return byMethod("s3:GetBucketPolicy", "s3:PutBucketPolicy", "s3:DeleteBucketPolicy")
+		case has("acl"):
+			return byMethod("s3:GetBucketAcl", "s3:PutBucketAcl", "s3:PutBucketAcl")
+		case has("cors"):
+			return byMethod("s3:GetBucketCORS", "s3:PutBucketCORS", "s3:PutBucketCORS")
+		case has("lifecycle"):
+			return byMethod("s3:GetLifecycleConfiguration", "s3:PutLifecycleConfiguration", "s3:PutLifecycleConfiguration")
+		case has("versioning"):
+			return byMethod("s3:GetBucketVersioning", "s3:PutBucketVersioning", "s3:PutBucketVersioning")
+		case has("tagging"):
+			return byMethod("s3:GetBucketTagging", "s3:PutBucketTagging", "s3:PutBucketTagging")
+		case has("notification"):
+			return byMethod("s3:GetBucketNotification", "s3:PutBucketNotification", "s3:PutBucketNotification")
+		case has("logging"):
+			return byMethod("s3:GetBucketLogging", "s3:PutBucketLogging", "s3:PutBucketLogging")
+		case has("object-lock"):
+			return byMethod("s3:GetBucketObjectLockConfiguration", "s3:PutBucketObjectLockConfiguration", "s3:PutBucketObjectLockConfiguration")
+		case has("location"):
+			return "s3:GetBucketLocation"
+		case has("uploads"):
+			return "s3:ListBucketMultipartUploads"
+		case has("versions"):
+			return "s3:ListBucketVersions"
+		// расширения s3mini
+		case has("settings"):
+			return byMethod("s3:GetBucketSettings", "s3:PutBucketSettings", "s3:PutBucketSettings")
+		case has("script"):
+			return byMethod("s3:GetBucketScript", "s3:PutBucketScript", "s3:DeleteBucketScript")
+		case has("changes"):
+			return "s3:GetBucketChanges"
+		}
+		return byMethod("s3:ListBucket", "s3:CreateBucket", "s3:DeleteBucket")
+	}
+
+	switch {
+	case has("tagging"):
+		return versioned(byMethod("s3:GetObjectTagging", "s3:PutObjectTagging", "s3:DeleteObjectTagging"))
+	case has("acl"):
+		return versioned(byMethod("s3:GetObjectAcl", "s3:PutObjectAcl", "s3:PutObjectAcl"))
+	case has("retention"):
+		return byMethod("s3:GetObjectRetention", "s3:PutObjectRetention", "s3:PutObjectRetention")
+	case has("legal-hold"):
+		return byMethod("s3:GetObjectLegalHold", "s3:PutObjectLegalHold", "s3:PutObjectLegalHold")
+	case has("uploadId") && r.Method == http.MethodGet:
+		return "s3:ListMultipartUploadParts"
+	case has("uploadId") && r.Method == http.MethodDelete:
+		return "s3:AbortMultipartUpload"
+	case has("attributes"):
+		return versioned("s3:GetObjectAttributes")
+	case has("restore"):
+		return "s3:RestoreObject"
+	case has("verify"):
+		// перечитывает и хэширует весь блоб — отдельное действие, которое ACL и
+		// гранты чтения не открывают
+		return "s3:VerifyObject"
+	case has("select"):
+		return versioned("s3:GetObject")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return versioned("s3:GetObject")
+	case http.MethodDelete:
+		return versioned("s3:DeleteObject")
+	}
+	return "s3:PutObject"
+}
+
+func resourceARN(bucket, key string) string {
+	if key == "" {
+		return "arn:aws:s3:::" + bucket
+	}
+	return "arn:aws:s3:::" + bucket + "/" + key
+}
+
+// policyContext — значения ключей условий для запроса.
+func policyContext(r *http.Request, principal string) map[string]string {
+	now := time.Now().UTC()
+	c := map[string]string{
+		"aws:sourceip":        sourceIP(r),
+		"aws:securetransport": strconv.FormatBool(r.TLS != nil),
+		"aws:currenttime":     now.Format(time.RFC3339),
+		"aws:epochtime":       strconv.FormatInt(now.Unix(), 10),
+		"aws:principaltype":   "Anonymous",
+	}
+	if principal != "" {
+		c["aws:principaltype"] = "User"
+		c["aws:userid"] = principal
+		c["aws:username"] = principal
+	}
+	if v := r.UserAgent(); v != "" {
+		c["aws:useragent"] = v
+	}
+	if v := r.Referer(); v != "" {
+		c["aws:referer"] = v
+	}
+	q := r.URL.Query()
+	for _, k := range []string{"prefix", "delimiter", "max-keys", "versionId"} {
+		if v, ok := q[k]; ok && len(v) > 0 {
+			c["s3:"+strings.ToLower(k)] = v[0]
+		}
+	}
+	for _, h := range []string{"x-amz-server-side-encryption", "x-amz-copy-source",
+		"x-amz-metadata-directive", "x-amz-storage-class", "x-amz-content-sha256"} {
+		if v := r.Header.Get(h); v != "" {
+			c["s3:"+h] = v
+		}
+	}
+	return c
+}
+
+// checkBucketAccess — фильтр по IP, политика и ACL бакета до обработчиков. Явный Deny
+// политики — 403 для всех, кроме управления самой политикой владельцем (иначе
+// можно запереть себя). Allow политики или canned ACL для чужого пользователя
+// или анонима переводит запрос на владельца бакета: обработчики ищут бакет по
+// владельцу. granted — доступ выдан политикой или ACL; без совпадений запрос
+// идёт как был.
+func (s *Server) checkBucketAccess(r *http.Request, userID uint, principal string) (_ *http.Request, granted bool, _ error) {
+	if strings.HasPrefix(r.URL.Path, adminPrefix) {
+		return r, false, nil
+	}
+	p := strings.Trim(r.URL.Path, "/")
+	if p == "" {
+		return r, false, nil
+	}
+	bucket, key, _ := strings.Cut(p, "/")
+	b, err := s.db.FindBucketByName(bucket)
+	if err != nil {
+		return r, false, nil // нет бакета — пусть ответит обработчик
+	}
+	owner := userID != 0 && b.OwnerID == userID
+	action := s3Action(r, key)
+	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("action", action))
+
+	// фильтр по IP — для всех; владелец может прочитать и поправить сам фильтр
+	// (?settings), иначе, ошибившись в CIDR, запер бы себя
+	if !ipAllowed(b, r) && !(owner && strings.HasSuffix(action, "BucketSettings")) {
+		log.Info("bucket_ip_filter.denied", "principal", principal, "ip", sourceIP(r))
+		return r, false, &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "Access Denied"}
+	}
+
+	d, err := s.accessDecision(r, b, userID, principal, action, key, r.URL.Query().Get("versionId"))
+	if err != nil {
+		log.Error("bucket_policy.parse_fail", "err", err)
+		return r, false, &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "bucket policy failed"}
+	}
+	switch d {
+	case policy.Deny:
+		log.Info("bucket_policy.denied", "principal", principal)
+		return r, false, &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "denied by bucket policy"}
+	case policy.NoMatch:
+		// чужой бакет без политики, гранта и ACL — 403, как в S3; иначе запись
+		// по имени (EnsureBucket) прошла бы мимо владельца. CreateBucket
+		// отвечает сам (409 BucketAlreadyExists)
+		if userID != 0 && b.OwnerID != 0 && !owner && action != "s3:CreateBucket" {
+			log.Info("bucket_access.denied", "principal", principal)
+			return r, false, &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "Access Denied"}
+		}
+		return r, false, nil
+	}
+	if owner {
+		return r, true, nil
+	}
+
+	// копирование читает источник от имени владельца — источник тоже должен
+	// быть открыт этому пользователю
+	if v := r.Header.Get(hdrCopySource); v != "" && r.Method == http.MethodPut {
+		srcBucket, srcKey, srcVer, err := parseCopySource(v)
+		if err != nil {
+			return r, false, nil // ошибку формата вернёт обработчик
+		}
+		src, err := s.db.FindBucketByName(srcBucket)
+		if err != nil || src.OwnerID != b.OwnerID {
+			return r, false, &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "access to the copy source is denied"}
+		}
+		srcAction := "s3:GetObject"
+		if srcVer != "" {
+			srcAction = "s3:GetObjectVersion"
+		}
+		if d, err := s.accessDecision(r, src, userID, principal, srcAction, srcKey, srcVer); err != nil || d != policy.Allow {
+			log.Info("bucket_access.copy_source_denied", "principal", principal, "src_bucket", srcBucket)
+			return r, false, &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "access to the copy source is denied"}
+		}
+		// переименование ещё и удаляет источник
+		if isMoveRequest(r) {
+			if d, err := s.accessDecision(r, src, userID, principal, "s3:DeleteObject", srcKey, ""); err != nil || d != policy.Allow {
+				log.Info("bucket_access.move_source_denied", "principal", principal, "src_bucket", srcBucket)
+				return r, false, &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "deleting the move source is denied"}
+			}
+		}
+	}
+	log.Info("bucket_access.granted", "principal", principal, "owner_id", b.OwnerID)
+	ctx := context.WithValue(r.Context(), ctxPrincipalKey, userID)
+	ctx = context.WithValue(ctx, ctxUserKey, b.OwnerID)
+	return r.WithContext(ctx), true, nil
+}
+
+// canReadSource — источник, который обработчик читает сам (compose), открыт
+// настоящему отправителю, как x-amz-copy-source: политика ключа приложения и,
+// если запрос идёт от имени владельца по разрешению, политика и ACL бакета.
+func (s *Server) canReadSource(r *http.Request, b *db.Bucket, key, versionID string) bool {
+	action := "s3:GetObject"
+	if versionID != "" {
+		action = "s3:GetObjectVersion"
+	}
+	rk, _ := r.Context().Value(ctxAccessKeyKey).(requestKey)
+	if rk.key != nil && rk.key.Policy != "" && !keyPolicyAllows(r, rk.key, rk.principal, action, resourceARN(b.Name, key)) {
+		return false
+	}
+	principalID, granted := r.Context().Value(ctxPrincipalKey).(uint)
+	if !granted {
+		return true
+	}
+	d, err := s.accessDecision(r, b, principalID, rk.principal, action, key, versionID)
+	return err == nil && d == policy.Allow
+}
+
+// accessDecision — сначала политика бакета, затем (для не-владельца) гранты и canned ACL.
+func (s *Server) accessDecision(r *http.Request, b *db.Bucket, userID uint, principal, action, key, versionID string) (policy.Decision, error) {
+	owner := userID != 0 && b.OwnerID == userID
+	d := policy.NoMatch
+	if !owner || !strings.HasSuffix(action, "BucketPolicy") {
+		var err error
+		if d, err = s.evalBucketPolicy(b, principal, action, resourceARN(b.Name, key), r); err != nil || d == policy.Deny {
+			return d, err
+		}
+	}
+	if d == policy.NoMatch && !owner && (s.grantAllows(b, userID, action) || s.aclAllows(b, action, key, versionID)) {
+		d = policy.Allow
+	}
+	return d, nil
+}
+
+func (s *Server) evalBucketPolicy(b *db.Bucket, principal, action, resource string, r *http.Request) (policy.Decision, error) {
+	if b.Policy == "" {
+		return policy.NoMatch, nil
+	}
+	pol, err := policy.Parse([]byte(b.Policy), b.Name)
+	if err != nil {
+		// сохраняется только проверенная политика; сюда попадаем лишь при порче
+		mBucketPolicyDecisions.Inc("error")
+		return policy.NoMatch, err
+	}
+	d := pol.Evaluate(policy.Request{
+		Principal: principal,
+		Action:    action,
+		Resource:  resource,
+		Context:   policyContext(r, principal),
+	})
+	mBucketPolicyDecisions.Inc(d.String())
+	return d, nil
+}
+
+// PUT /:bucket?policy — тело: JSON политики.
+func (s *Server) handlePutBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	log.Info("policy.put.start")
+
+	b, ok := s.policyBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, policy.MaxSize+1))
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", "cannot read policy", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if _, err := policy.Parse(body, bucket); err != nil {
+		log.Warn("policy.put.invalid", "err", err)
+		writeS3Error(w, http.StatusBadRequest, "MalformedPolicy", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{"policy": string(body)}); err != nil {
+		log.Error("policy.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("policy.put.ok", "size", len(body))
+}
+
+// GET /:bucket?policy
+func (s *Server) handleGetBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.policyBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if b.Policy == "" {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucketPolicy", "The bucket policy does not exist", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, b.Policy)
+}
+
+// DELETE /:bucket?policy
+func (s *Server) handleDeleteBucketPolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.policyBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{"policy": ""}); err != nil {
+		log.Error("policy.delete.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("policy.delete.ok")
+}
+
+func (s *Server) policyBucket(w http.ResponseWriter, r *http.Request, bucket string, log *slog.Logger) (*db.Bucket, bool) {
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, false
+	case err != nil:
+		log.Error("policy.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return b, true
+}
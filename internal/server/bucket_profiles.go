@@ -0,0 +1,70 @@
+package server
+
+import (
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// Профиль бакета — набор настроек под сценарий, задаётся одним заголовком
+// x-s3mini-bucket-profile при создании бакета.
+const hdrBucketProfile = "x-s3mini-bucket-profile"
+
+const profileBackup = "backup"
+
+type bucketProfile struct {
+	ChunkSize      int64 // размер кусков PUT (мелкие куски => больше дедупа между снапшотами)
+	Compress       bool
+	ProtectionDays int // WORM-окно
+	// lifecycle-пресет: удаление неактуальных версий и delete-marker'ов
+	NoncurrentDays   int
+	DeleteMarkerDays int
+}
+
+// backup: restic/borg/kopia пишут неизменяемые pack-файлы и удаляют их при prune —
+// удалённое остаётся неактуальной версией на время WORM-окна и потом чистится lifecycle.
+var bucketProfiles = map[string]bucketProfile{
+	profileBackup: {
+		ChunkSize:        4 << 20,
+		Compress:         true,
+		ProtectionDays:   30,
+		NoncurrentDays:   31,
+		DeleteMarkerDays: 31,
+	},
+}
+
+// uploadOptsFor — раскладка PUT по блобам для бакета.
+func (s *Server) uploadOptsFor(b *db.Bucket) uploadOpts {
+	if p, ok := bucketProfiles[b.Profile]; ok {
+		return uploadOpts{ChunkSize: p.ChunkSize, Compress: p.Compress}
+	}
+	return uploadOpts{ChunkSize: int64(s.cfg.PutChunkSizeMB) << 20}
+}
+
+// applyBucketProfile — настройки и lifecycle-пресет профиля для нового бакета.
+func (s *Server) applyBucketProfile(bucketID uint, name string) error {
+	p := bucketProfiles[name]
+	if err := s.db.UpdateBucketSettings(bucketID, map[string]any{
+		"profile":         name,
+		"protection_days": p.ProtectionDays,
+	}); err != nil {
+		return err
+	}
+	return s.db.EnsureDefaultLifecycleRule(bucketID, db.LifecycleRule{
+		Enabled:                     true,
+		ExpireNoncurrentAfterDays:   &p.NoncurrentDays,
+		PurgeDeleteMarkersAfterDays: &p.DeleteMarkerDays,
+	})
+}
+
+// versionProtected — версию нельзя удалить: её держит WORM-окно бакета или
+// Object Lock (без bypass).
+func versionProtected(b *db.Bucket, v *db.ObjectVersion, now time.Time) bool {
+	return inProtectionWindow(b, v, now) || versionLocked(v, now, false)
+}
+
+// inProtectionWindow — версия внутри WORM-окна бакета (delete-marker'ы не защищаем:
+// их удаление данные не уничтожает).
+func inProtectionWindow(b *db.Bucket, v *db.ObjectVersion, now time.Time) bool {
+	return b.ProtectionDays > 0 && !v.IsDelete && now.Before(v.CreatedAt.AddDate(0, 0, b.ProtectionDays))
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+	"github.com/DanikLP1/s3-storage-service/internal/script"
+)
+
+var mBucketScriptRuns = metrics.NewCounterVec("s3mini_bucket_script_runs_total",
+	"Bucket script executions by result.", "result") // allow|deny|error
+
+// bucketScriptHook — post-auth хук: если у бакета есть скрипт, он решает судьбу запроса.
+// Управление самим скриптом (?script) скриптом не фильтруется, иначе владелец
+// может запереть себя навсегда.
+func (s *Server) bucketScriptHook(r *http.Request) error {
+	if strings.HasPrefix(r.URL.Path, adminPrefix) || hasSubresource(r, "script") {
+		return nil
+	}
+	p := strings.Trim(r.URL.Path, "/")
+	if p == "" {
+		return nil
+	}
+	bucket, key, _ := strings.Cut(p, "/")
+	b, err := s.db.FindBucketByName(bucket)
+	if err != nil || b.Script == "" {
+		return nil // нет бакета — пусть ответит обработчик
+	}
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+
+	prog, err := s.compiledScript(b.ID, b.Script)
+	if err != nil {
+		mBucketScriptRuns.Inc("error")
+		log.Error("bucket_script.compile_fail", "err", err)
+		return &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "bucket script failed"}
+	}
+	req := script.Request{
+		Method:  r.Method,
+		Bucket:  bucket,
+		Key:     key,
+		UserID:  getPrincipalIDFromCtx(r.Context()),
+		IP:      sourceIP(r),
+		Size:    r.ContentLength,
+		Headers: map[string]string{},
+		Query:   map[string]string{},
+	}
+	for k := range r.Header {
+		req.Headers[strings.ToLower(k)] = r.Header.Get(k)
+	}
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			req.Query[k] = v[0]
+		}
+	}
+	d, err := prog.Run(r.Context(), req, time.Duration(s.cfg.ScriptTimeoutMS)*time.Millisecond)
+	if err != nil {
+		// fail closed: сломанный скрипт не должен молча открывать доступ
+		mBucketScriptRuns.Inc("error")
+		log.Warn("bucket_script.run_fail", "err", err)
+		return &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "bucket script failed"}
+	}
+	if !d.Allow {
+		mBucketScriptRuns.Inc("deny")
+		log.Info("bucket_script.denied", "key", key, "reason", d.Reason)
+		msg := d.Reason
+		if msg == "" {
+			msg = "denied by bucket script"
+		}
+		return &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: msg}
+	}
+	mBucketScriptRuns.Inc("allow")
+	for k, v := range d.Headers {
+		if v == "" {
+			r.Header.Del(k)
+		} else {
+			r.Header.Set(k, v)
+		}
+	}
+	if len(d.Headers) > 0 {
+		log.Info("bucket_script.headers_rewritten", "key", key, "count", len(d.Headers))
+	}
+	return nil
+}
+
+// cachedScript — скомпилированный скрипт бакета и хэш его исходника.
+type cachedScript struct {
+	sum  [sha256.Size]byte
+	prog *script.Program
+}
+
+// compiledScript — кэш скомпилированных скриптов: по записи на бакет, так что
+// он не больше числа бакетов со скриптом. Новый исходник вытесняет прежний,
+// DELETE ?script и удаление бакета убирают запись.
+func (s *Server) compiledScript(bucketID uint, src string) (*script.Program, error) {
+	sum := sha256.Sum256([]byte(src))
+	if c, ok := s.scripts.Load(bucketID); ok && c.(*cachedScript).sum == sum {
+		return c.(*cachedScript).prog, nil
+	}
+	p, err := script.Compile("bucket", src)
+	if err != nil {
+		return nil, err
+	}
+	s.scripts.Store(bucketID, &cachedScript{sum: sum, prog: p})
+	return p, nil
+}
+
+// PUT /:bucket?script — тело: исходник Lua.
+func (s *Server) handlePutBucketScript(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	log.Info("script.put.start")
+
+	b, ok := s.scriptBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	src, err := io.ReadAll(io.LimitReader(r.Body, script.MaxSourceSize+1))
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", "cannot read script", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if _, err := s.compiledScript(b.ID, string(src)); err != nil {
+		log.Warn("script.put.compile_fail", "err", err)
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{"script": string(src)}); err != nil {
+		log.Error("script.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("script.put.ok", "size", len(src))
+}
+
+// GET /:bucket?script
+func (s *Server) handleGetBucketScript(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.scriptBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if b.Script == "" {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucketScript", "The bucket has no script.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-lua")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, b.Script)
+}
+
+// DELETE /:bucket?script
+func (s *Server) handleDeleteBucketScript(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.scriptBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{"script": ""}); err != nil {
+		log.Error("script.delete.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	s.scripts.Delete(b.ID)
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("script.delete.ok")
+}
+
+func (s *Server) scriptBucket(w http.ResponseWriter, r *http.Request, bucket string, log *slog.Logger) (*db.Bucket, bool) {
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, false
+	case err != nil:
+		log.Error("script.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return b, true
+}
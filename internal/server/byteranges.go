@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// maxByteRanges — больше диапазонов в одном Range заголовок просто игнорируется
+// (RFC 7233 это разрешает): иначе один запрос дробит чтение на тысячи кусков.
+const maxByteRanges = 100
+
+type byteRange struct {
+	start, length int64
+}
+
+func (br byteRange) contentRange(total int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, total)
+}
+
+// parseRanges разбирает "bytes=a-b,c-,-n" для объекта размера total. Невыполнимые
+// диапазоны отбрасываются; не осталось ни одного — errBadRange. nil, nil —
+// заголовок битый или диапазонов слишком много: отдаём объект целиком.
+func parseRanges(rng string, total int64) ([]byteRange, error) {
+	specs := strings.Split(strings.TrimPrefix(rng, "bytes="), ",")
+	if len(specs) > maxByteRanges {
+		return nil, nil
+	}
+	out := make([]byteRange, 0, len(specs))
+	for _, spec := range specs {
+		st, ln, err := parseRange("bytes="+strings.TrimSpace(spec), total)
+		if errors.Is(err, errBadRange) {
+			continue
+		}
+		if ln < 0 {
+			return nil, nil
+		}
+		out = append(out, byteRange{st, ln})
+	}
+	if len(out) == 0 {
+		return nil, errBadRange
+	}
+	return out, nil
+}
+
+// byteRangesBody — заголовки частей multipart/byteranges и точная длина тела:
+// пробный проход multipart.Writer без данных, как в net/http.
+func byteRangesBody(ct string, total int64, ranges []byteRange) (boundary string, parts []textproto.MIMEHeader, size int64) {
+	var cw countWriter
+	mw := multipart.NewWriter(&cw)
+	for _, br := range ranges {
+		h := textproto.MIMEHeader{
+			"Content-Type":  {ct},
+			"Content-Range": {br.contentRange(total)},
+		}
+		_, _ = mw.CreatePart(h)
+		cw += countWriter(br.length)
+		parts = append(parts, h)
+	}
+	_ = mw.Close()
+	return mw.Boundary(), parts, int64(cw)
+}
+
+// writeByteRanges пишет тело multipart/byteranges; open отдаёт байты одного диапазона.
+func writeByteRanges(w io.Writer, boundary string, parts []textproto.MIMEHeader, ranges []byteRange,
+	open func(br byteRange) (io.ReadCloser, error)) (int64, error) {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+	var n int64
+	for i, br := range ranges {
+		pw, err := mw.CreatePart(parts[i])
+		if err != nil {
+			return n, err
+		}
+		rc, err := open(br)
+		if err != nil {
+			return n, err
+		}
+		c, err := io.Copy(pw, rc)
+		rc.Close()
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, mw.Close()
+}
+
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// Лента изменений (CDC): события пишутся в транзакциях изменений (db.ChangeEvent),
+// здесь — чтение с long-poll и чистка по сроку хранения.
+
+const (
+	changesDefaultLimit = 1000
+	changesMaxWait      = 60 * time.Second
+	changesPollEvery    = 250 * time.Millisecond
+)
+
+// pollChanges отдаёт события после after; если их нет — ждёт до wait, опрашивая БД
+// (изменения может закоммитить и другой процесс, см. перезапуск без простоя).
+func (s *Server) pollChanges(ctx context.Context, bucketID uint, after uint64, limit int, wait time.Duration) ([]db.ChangeEvent, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		evs, err := s.db.ListChanges(bucketID, after, limit)
+		if err != nil || len(evs) > 0 || time.Now().After(deadline) {
+			return evs, err
+		}
+		if !sleepCtx(ctx, changesPollEvery) {
+			return nil, nil
+		}
+	}
+}
+
+// changesParams — after, limit (1..1000), wait (секунды, до 60).
+func changesParams(r *http.Request) (after uint64, limit int, wait time.Duration, err error) {
+	q := r.URL.Query()
+	if v := q.Get("after"); v != "" {
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, 0, 0, errors.New("after must be a sequence number")
+		}
+	}
+	limit = changesDefaultLimit
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > changesDefaultLimit {
+			return 0, 0, 0, errors.New("limit must be 1..1000")
+		}
+	}
+	if v := q.Get("wait"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, 0, errors.New("wait must be seconds >= 0")
+		}
+		wait = min(time.Duration(n)*time.Second, changesMaxWait)
+	}
+	return after, limit, wait, nil
+}
+
+// GET /:bucket?changes&after=N&limit=&wait= — лента бакета, владельцу или админу.
+func (s *Server) handleGetBucketChanges(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) && s.isAdmin(r) {
+		b, err = s.db.FindBucketByName(bucket)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("changes.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	after, limit, wait, err := changesParams(r)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	evs, err := s.pollChanges(r.Context(), b.ID, after, limit, wait)
+	if err == nil && r.Context().Err() != nil {
+		return // клиент ушёл во время ожидания
+	}
+	oldest, _, berr := s.db.ChangeSeqBounds()
+	if err == nil {
+		err = berr
+	}
+	if err != nil {
+		log.Error("changes.query_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	res := ChangeFeedResult{Bucket: bucket, After: after, NextAfter: after, OldestSeq: oldest}
+	for _, ev := range evs {
+		res.Events = append(res.Events, ChangeEventXML{
+			Seq: ev.Seq, Type: ev.Type, Key: ev.Key, VersionId: ev.VersionID,
+			ETag: ev.ETag, Size: ev.Size, Time: ev.CreatedAt.UTC().Format(timeRFC3339),
+		})
+		res.NextAfter = ev.Seq
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(res)
+	log.Info("changes.ok", "after", after, "events", len(evs))
+}
+
+// GET /admin/v1/changes?after=N&limit=&wait=[&bucket=] — глобальная лента (JSON).
+func (s *Server) handleAdminChanges(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	log := loggerFrom(r)
+	after, limit, wait, err := changesParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	var bucketID uint
+	if name := r.URL.Query().Get("bucket"); name != "" {
+		b, err := s.db.FindBucketByName(name)
+		if errors.Is(err, db.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "NoSuchBucket", "bucket not found")
+			return
+		}
+		if err != nil {
+			log.Error("admin.changes.bucket_lookup_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		bucketID = b.ID
+	}
+
+	evs, err := s.pollChanges(r.Context(), bucketID, after, limit, wait)
+	if err == nil && r.Context().Err() != nil {
+		return
+	}
+	oldest, _, berr := s.db.ChangeSeqBounds()
+	if err == nil {
+		err = berr
+	}
+	if err != nil {
+		log.Error("admin.changes.query_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	next := after
+	if len(evs) > 0 {
+		next = evs[len(evs)-1].Seq
+	}
+	if evs == nil {
+		evs = []db.ChangeEvent{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"after":      after,
+		"next_after": next,
+		"oldest_seq": oldest,
+		"events":     evs,
+	})
+}
+
+// StartChangeFeedPrune — раз в every удаляет события старше срока хранения.
+func (s *Server) StartChangeFeedPrune(ctx context.Context, every time.Duration) {
+	log := s.Logger.With(slog.String("comp", "change_feed"))
+	keep := time.Duration(s.cfg.ChangeFeedRetentionDays) * 24 * time.Hour
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if s.isReadOnly() || !s.holdLease(log, "change_feed_prune", every) {
+					continue
+				}
+				n, err := s.db.PruneChanges(time.Now().Add(-keep))
+				if err != nil {
+					log.Error("change_feed.prune_fail", "err", err)
+					continue
+				}
+				if n > 0 {
+					log.Info("change_feed.pruned", "events", n)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var mChaosInjected = metrics.NewCounterVec("s3mini_chaos_injected_total",
+	"Faults injected by bucket chaos mode.", "fault") // delay|error|slowdown|truncate|corrupt
+
+// withChaos — режим сбоев для S3-запросов к бакету с включённым BucketChaos.
+// Задержка независима от остального; дальше либо ответ-ошибка, либо (для GET)
+// порча тела ответа.
+func (s *Server) withChaos(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, _, _ := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+		if bucket == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := s.db.ChaosForBucket(bucket)
+		if err != nil || c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		log := loggerFrom(r)
+
+		if c.DelayMs > 0 && roll(c.DelayProb) {
+			mChaosInjected.Inc("delay")
+			log.Info("chaos.delay", "ms", c.DelayMs)
+			if !sleepCtx(r.Context(), time.Duration(c.DelayMs)*time.Millisecond) {
+				return
+			}
+		}
+		switch {
+		case roll(c.ErrorProb):
+			mChaosInjected.Inc("error")
+			log.Info("chaos.error")
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.", r.URL.Path, requestIDFrom(r))
+			return
+		case roll(c.SlowDownProb):
+			mChaosInjected.Inc("slowdown")
+			log.Info("chaos.slowdown")
+			w.Header().Set("Retry-After", "1")
+			writeS3Error(w, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case roll(c.TruncateProb):
+			mChaosInjected.Inc("truncate")
+			log.Info("chaos.truncate")
+			cw := &chaosWriter{ResponseWriter: w, truncate: true}
+			next.ServeHTTP(cw, r)
+			if cw.cut {
+				// недописанное тело при объявленном Content-Length: рвём соединение
+				_ = http.NewResponseController(w).Flush()
+				panic(http.ErrAbortHandler)
+			}
+		case roll(c.CorruptProb):
+			mChaosInjected.Inc("corrupt")
+			log.Info("chaos.corrupt")
+			next.ServeHTTP(&chaosWriter{ResponseWriter: w}, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// chaosWriter портит успешный ответ: обрезает тело на середине или
+// инвертирует первый байт (ETag/checksum при этом остаются прежними).
+type chaosWriter struct {
+	http.ResponseWriter
+	truncate bool
+	status   int
+	limit    int64 // для truncate: сколько байт пропустить
+	written  int64
+	cut      bool
+	touched  bool
+}
+
+func (w *chaosWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+		if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+			w.limit = n / 2
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *chaosWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status/100 != 2 || len(p) == 0 {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.truncate {
+		if w.written+int64(len(p)) <= w.limit {
+			n, err := w.ResponseWriter.Write(p)
+			w.written += int64(n)
+			return n, err
+		}
+		n, _ := w.ResponseWriter.Write(p[:w.limit-w.written])
+		w.written += int64(n)
+		w.cut = true
+		return n, errChaosTruncated
+	}
+	if !w.touched {
+		w.touched = true
+		q := append([]byte(nil), p...)
+		q[0] ^= 0xff
+		return w.ResponseWriter.Write(q)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *chaosWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+var errChaosTruncated = errors.New("chaos: body truncated")
+
+func roll(p float64) bool { return p > 0 && rand.Float64() < p }
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ---- админский API: /admin/v1/buckets/{bucket}/chaos ----
+
+func (s *Server) handleAdminBucketChaos(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	log := loggerFrom(r)
+	b, err := s.db.FindBucketByName(r.PathValue("bucket"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchBucket", "bucket not found")
+		return
+	}
+	if err != nil {
+		log.Error("admin.chaos.bucket_lookup_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		c, err := s.db.GetBucketChaos(b.ID)
+		if errors.Is(err, db.ErrNotFound) {
+			c, err = &db.BucketChaos{BucketID: b.ID}, nil
+		}
+		if err != nil {
+			log.Error("admin.chaos.load_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodPut:
+		var c db.BucketChaos
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "MalformedJSON", err.Error())
+			return
+		}
+		for _, p := range []float64{c.DelayProb, c.ErrorProb, c.SlowDownProb, c.TruncateProb, c.CorruptProb} {
+			if p < 0 || p > 1 {
+				writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "probabilities must be within 0..1")
+				return
+			}
+		}
+		if c.DelayMs < 0 || c.DelayMs > 600000 {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "delay_ms must be within 0..600000")
+			return
+		}
+		c.BucketID = b.ID
+		if err := s.db.SaveBucketChaos(&c); err != nil {
+			log.Error("admin.chaos.save_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		log.Warn("admin.chaos.set", "bucket", b.Name, "enabled", c.Enabled)
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodDelete:
+		if err := s.db.DeleteBucketChaos(b.ID); err != nil {
+			log.Error("admin.chaos.delete_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		log.Info("admin.chaos.cleared", "bucket", b.Name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
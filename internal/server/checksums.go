@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Дополнительные контрольные суммы S3 (x-amz-checksum-*): считаются по телу
+// при записи, хранятся на версии и отдаются по x-amz-checksum-mode: ENABLED.
+const (
+	hdrChecksumAlgorithm    = "x-amz-checksum-algorithm"
+	hdrSDKChecksumAlgorithm = "x-amz-sdk-checksum-algorithm"
+	hdrChecksumMode         = "x-amz-checksum-mode"
+	hdrChecksumPrefix       = "x-amz-checksum-"
+)
+
+var crc64NVME = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// checksumAlgorithms — алгоритм → конструктор хэша.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"CRC32":     func() hash.Hash { return crc32.NewIEEE() },
+	"CRC32C":    func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"CRC64NVME": func() hash.Hash { return crc64.New(crc64NVME) },
+	"SHA1":      sha1.New,
+	"SHA256":    sha256.New,
+}
+
+func checksumHeaderName(alg string) string { return hdrChecksumPrefix + strings.ToLower(alg) }
+
+// checksumCheck — алгоритм и (если клиент его прислал) ожидаемое значение
+// контрольной суммы тела. Значение из трейлера aws-chunked известно только
+// после чтения тела; его сверяет ещё декодер (auth.ChecksumTrailer).
+type checksumCheck struct {
+	Alg     string
+	want    string // base64; "" — только посчитать
+	trailer bool
+	body    io.Reader // исходное тело: у aws-chunked из него берётся трейлер
+	h       hash.Hash
+}
+
+// newChecksumCheck разбирает x-amz-checksum-* до чтения тела; nil — клиент
+// контрольную сумму не просил. ok=false — ответ с ошибкой уже записан.
+func newChecksumCheck(w http.ResponseWriter, r *http.Request) (*checksumCheck, bool) {
+	bad := func(msg string) (*checksumCheck, bool) {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", msg, r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	c := &checksumCheck{body: r.Body}
+	for alg := range checksumAlgorithms {
+		v := r.Header.Get(checksumHeaderName(alg))
+		if v == "" {
+			continue
+		}
+		if c.Alg != "" {
+			return bad("Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+		}
+		c.Alg, c.want = alg, v
+	}
+	if t := strings.ToLower(strings.TrimSpace(r.Header.Get("x-amz-trailer"))); strings.HasPrefix(t, hdrChecksumPrefix) {
+		alg := strings.ToUpper(strings.TrimPrefix(t, hdrChecksumPrefix))
+		if checksumAlgorithms[alg] == nil {
+			return bad("The value specified in the x-amz-trailer header is not supported")
+		}
+		if c.Alg != "" && c.Alg != alg {
+			return bad("Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+		}
+		c.Alg, c.trailer = alg, true
+	}
+	for _, h := range []string{hdrChecksumAlgorithm, hdrSDKChecksumAlgorithm} {
+		v := strings.ToUpper(r.Header.Get(h))
+		if v == "" {
+			continue
+		}
+		if checksumAlgorithms[v] == nil {
+			return bad("Checksum algorithm provided is unsupported. Please try again with any of the valid types: [CRC32, CRC32C, CRC64NVME, SHA1, SHA256]")
+		}
+		if c.Alg == "" {
+			c.Alg = v
+		} else if c.Alg != v {
+			return bad("Value for " + h + " header is invalid.")
+		}
+	}
+	if c.Alg == "" {
+		return nil, true
+	}
+	c.h = checksumAlgorithms[c.Alg]()
+	if c.want != "" && !c.validValue(c.want) {
+		return bad("Value for " + checksumHeaderName(c.Alg) + " header is invalid.")
+	}
+	return c, true
+}
+
+// validValue — base64 длины дайджеста алгоритма.
+func (c *checksumCheck) validValue(v string) bool {
+	raw, err := base64.StdEncoding.DecodeString(v)
+	return err == nil && len(raw) == c.h.Size()
+}
+
+// wrap — тело, попутно считающее контрольную сумму.
+func (c *checksumCheck) wrap(body io.Reader) io.Reader {
+	if c == nil {
+		return body
+	}
+	return io.TeeReader(body, c.h)
+}
+
+// Value — посчитанная контрольная сумма (base64); "" без checksumCheck.
+func (c *checksumCheck) Value() string {
+	if c == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(c.h.Sum(nil))
+}
+
+// verify сверяет посчитанное с заголовком или трейлером; ответ при ошибке пишет сам.
+func (c *checksumCheck) verify(w http.ResponseWriter, r *http.Request) bool {
+	if c == nil {
+		return true
+	}
+	want := c.want
+	if c.trailer {
+		tr, ok := c.body.(interface{ Trailer(name string) string })
+		if ok {
+			want = tr.Trailer(checksumHeaderName(c.Alg))
+		}
+		if want == "" || !c.validValue(want) {
+			writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "Value for "+checksumHeaderName(c.Alg)+" trailing header is invalid.", r.URL.Path, requestIDFrom(r))
+			return false
+		}
+	}
+	if want != "" && want != c.Value() {
+		writeS3Error(w, http.StatusBadRequest, "BadDigest",
+			"The "+c.Alg+" you specified did not match the calculated checksum.", r.URL.Path, requestIDFrom(r))
+		return false
+	}
+	return true
+}
+
+// setChecksumHeader — x-amz-checksum-<alg> в ответе; пусто — ничего.
+func setChecksumHeader(w http.ResponseWriter, alg, value string) {
+	if alg != "" && value != "" {
+		w.Header().Set(checksumHeaderName(alg), value)
+	}
+}
+
+// checksumRequested — GET/HEAD с x-amz-checksum-mode: ENABLED.
+func checksumRequested(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(hdrChecksumMode), "ENABLED")
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+// Аутентификация клиентским сертификатом (mTLS) для внутренних установок:
+// цепочку проверяет TLS по TLS_CLIENT_CA_FILE, а SAN сертификата
+// сопоставляется ключу доступа из MTLS_IDENTITIES. Запрос без подписи с таким
+// сертификатом дальше идёт как запрос этого ключа — с его политикой, лимитами
+// и правами в бакетах. Подпись SigV4/V2, если она есть, важнее сертификата.
+
+const ctxCertKey ctxKey = "auth.cert.accessKey"
+
+var mClientCertAuth = metrics.NewCounterVec("s3mini_client_cert_auth_total",
+	"Requests with a verified TLS client certificate.", "result") // mapped|unmapped
+
+// WithClientCert — транспортный уровень аутентификации: ключ доступа по
+// проверенному клиентскому сертификату кладётся в контекст для AuthMiddleware.
+func (s *Server) WithClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.certIdentities) == 0 || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		leaf := r.TLS.VerifiedChains[0][0]
+		akid, san := s.certAccessKey(leaf)
+		if akid == "" {
+			mClientCertAuth.Inc("unmapped")
+			loggerFrom(r).Info("mtls.unmapped", "subject", leaf.Subject.String())
+			next.ServeHTTP(w, r)
+			return
+		}
+		mClientCertAuth.Inc("mapped")
+		loggerFrom(r).Debug("mtls.mapped", "san", san, "access_key", akid)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxCertKey, akid)))
+	})
+}
+
+// certAccessKey — первый SAN сертификата, для которого задан ключ доступа.
+func (s *Server) certAccessKey(c *x509.Certificate) (akid, san string) {
+	var sans []string
+	sans = append(sans, c.DNSNames...)
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+	sans = append(sans, c.EmailAddresses...)
+	for _, n := range sans {
+		if id, ok := s.certIdentities[n]; ok {
+			return id, n
+		}
+	}
+	return "", ""
+}
+
+// certAccessKeyFrom — ключ доступа из клиентского сертификата; "" — нет.
+func certAccessKeyFrom(r *http.Request) string {
+	v, _ := r.Context().Value(ctxCertKey).(string)
+	return v
+}
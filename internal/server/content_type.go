@@ -0,0 +1,44 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLen — столько байт смотрит http.DetectContentType
+const sniffLen = 512
+
+// headCapture пропускает поток насквозь и запоминает его первые sniffLen байт.
+type headCapture struct {
+	r    io.Reader
+	head []byte
+}
+
+func (h *headCapture) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if rest := sniffLen - len(h.head); rest > 0 && n > 0 {
+		h.head = append(h.head, p[:min(n, rest)]...)
+	}
+	return n, err
+}
+
+// needsSniff — тип не задан клиентом или задан «ничего не знаю».
+func needsSniff(ctype string) bool {
+	return ctype == "" || strings.EqualFold(ctype, "application/octet-stream")
+}
+
+// detectContentType — сперва по расширению ключа, потом по первым байтам.
+func detectContentType(key string, head []byte) string {
+	if ext := path.Ext(key); ext != "" {
+		if t := mime.TypeByExtension(strings.ToLower(ext)); t != "" {
+			return t
+		}
+	}
+	if len(head) == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(head)
+}
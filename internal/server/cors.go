@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+const maxCORSRules = 100
+
+// CORSConfiguration — тело PUT и ответ GET /:bucket?cors
+type CORSConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	Xmlns   string     `xml:"xmlns,attr,omitempty"`
+	Rules   []CORSRule `xml:"CORSRule"`
+}
+
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader,omitempty"`
+	ExposeHeaders  []string `xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds  *int     `xml:"MaxAgeSeconds,omitempty"`
+}
+
+func (c *CORSConfiguration) validate() error {
+	if len(c.Rules) == 0 {
+		return errors.New("at least one CORSRule is required")
+	}
+	if len(c.Rules) > maxCORSRules {
+		return fmt.Errorf("too many CORS rules (max %d)", maxCORSRules)
+	}
+	for i, rule := range c.Rules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return fmt.Errorf("rule %d: AllowedOrigin and AllowedMethod are required", i)
+		}
+		for _, m := range rule.AllowedMethods {
+			switch m {
+			case http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodHead:
+			default:
+				return fmt.Errorf("rule %d: unsupported method %q", i, m)
+			}
+		}
+		for _, o := range rule.AllowedOrigins {
+			if strings.Count(o, "*") > 1 {
+				return fmt.Errorf("rule %d: AllowedOrigin %q can not have more than one wildcard", i, o)
+			}
+		}
+		for _, h := range rule.AllowedHeaders {
+			if strings.Count(h, "*") > 1 {
+				return fmt.Errorf("rule %d: AllowedHeader %q can not have more than one wildcard", i, h)
+			}
+		}
+		if rule.MaxAgeSeconds != nil && *rule.MaxAgeSeconds < 0 {
+			return fmt.Errorf("rule %d: MaxAgeSeconds must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// wildcardMatch — шаблон CORS с не более чем одной '*'.
+func wildcardMatch(pattern, s string) bool {
+	pre, suf, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return pattern == s
+	}
+	return len(s) >= len(pre)+len(suf) && strings.HasPrefix(s, pre) && strings.HasSuffix(s, suf)
+}
+
+// match — правило подходит для origin, метода и запрошенных заголовков (preflight).
+func (rule *CORSRule) match(origin, method string, headers []string) bool {
+	okOrigin := false
+	for _, o := range rule.AllowedOrigins {
+		if wildcardMatch(o, origin) {
+			okOrigin = true
+			break
+		}
+	}
+	okMethod := false
+	for _, m := range rule.AllowedMethods {
+		if m == method {
+			okMethod = true
+			break
+		}
+	}
+	if !okOrigin || !okMethod {
+		return false
+	}
+	for _, h := range headers {
+		allowed := false
+		for _, a := range rule.AllowedHeaders {
+			if wildcardMatch(strings.ToLower(a), h) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// setHeaders — Access-Control-* для подошедшего правила.
+func (rule *CORSRule) setHeaders(h http.Header, origin string) {
+	allowOrigin := origin
+	if len(rule.AllowedOrigins) == 1 && rule.AllowedOrigins[0] == "*" {
+		allowOrigin = "*"
+	} else {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Origin", allowOrigin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+	h.Add("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+}
+
+// bucketCORS — правила бакета из пути запроса; nil — бакета или правил нет.
+func (s *Server) bucketCORS(r *http.Request) *CORSConfiguration {
+	p := strings.Trim(r.URL.Path, "/")
+	if p == "" || strings.HasPrefix(r.URL.Path, adminPrefix) || strings.HasPrefix(r.URL.Path, internalPrefix) {
+		return nil
+	}
+	bucket, _, _ := strings.Cut(p, "/")
+	b, err := s.db.FindBucketByName(bucket)
+	if err != nil || b.CORS == "" {
+		return nil
+	}
+	var c CORSConfiguration
+	if err := xml.Unmarshal([]byte(b.CORS), &c); err != nil {
+		loggerFrom(r).Error("cors.parse_fail", "bucket", bucket, "err", err)
+		return nil
+	}
+	return &c
+}
+
+// WithCORS отвечает на preflight (OPTIONS) без подписи и добавляет
+// Access-Control-* к обычным запросам с Origin по правилам бакета (?cors).
+func (s *Server) WithCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodOptions {
+			s.handleCORSPreflight(w, r, origin)
+			return
+		}
+		if c := s.bucketCORS(r); c != nil {
+			for i := range c.Rules {
+				if c.Rules[i].match(origin, r.Method, nil) {
+					c.Rules[i].setHeaders(w.Header(), origin)
+					break
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OPTIONS /:bucket[/:key] — preflight: правило должно разрешать origin, метод
+// и все заголовки из Access-Control-Request-Headers.
+func (s *Server) handleCORSPreflight(w http.ResponseWriter, r *http.Request, origin string) {
+	log := loggerFrom(r).With(slog.String("origin", origin))
+	method := r.Header.Get("Access-Control-Request-Method")
+	if method == "" {
+		writeS3Error(w, http.StatusBadRequest, "BadRequest", "Invalid Access-Control-Request-Method header", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	var headers []string
+	for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+				headers = append(headers, h)
+			}
+		}
+	}
+	c := s.bucketCORS(r)
+	if c == nil {
+		log.Info("cors.preflight.not_enabled")
+		writeS3Error(w, http.StatusForbidden, "AccessForbidden", "CORSResponse: CORS is not enabled for this bucket.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if !rule.match(origin, method, headers) {
+			continue
+		}
+		rule.setHeaders(w.Header(), origin)
+		if len(headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		w.WriteHeader(http.StatusOK)
+		log.Info("cors.preflight.ok", "method", method, "rule", rule.ID)
+		return
+	}
+	log.Info("cors.preflight.denied", "method", method)
+	writeS3Error(w, http.StatusForbidden, "AccessForbidden", "CORSResponse: This CORS request is not allowed. This is usually because the evalution of Origin, request method / Access-Control-Request-Method or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec.", r.URL.Path, requestIDFrom(r))
+}
+
+// PUT /:bucket?cors
+func (s *Server) handlePutBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.corsBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	var c CORSConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&c); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse CORS xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := c.validate(); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	var buf bytes.Buffer
+	c.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	_ = xml.NewEncoder(&buf).Encode(c)
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{"cors": buf.String()}); err != nil {
+		log.Error("cors.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("cors.put.ok", "rules", len(c.Rules))
+}
+
+// GET /:bucket?cors
+func (s *Server) handleGetBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.corsBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if b.CORS == "" {
+		writeS3Error(w, http.StatusNotFound, "NoSuchCORSConfiguration", "The CORS configuration does not exist", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, b.CORS)
+}
+
+// DELETE /:bucket?cors
+func (s *Server) handleDeleteBucketCORS(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.corsBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{"cors": ""}); err != nil {
+		log.Error("cors.delete.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("cors.delete.ok")
+}
+
+func (s *Server) corsBucket(w http.ResponseWriter, r *http.Request, bucket string, log *slog.Logger) (*db.Bucket, bool) {
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, false
+	case err != nil:
+		log.Error("cors.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return b, true
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var (
+	mDBMaintRuns = metrics.NewCounterVec("s3mini_db_maintenance_runs_total",
+		"SQLite maintenance operations (checkpoint, optimize, vacuum), by result (ok, busy, failed).", "op", "result")
+	mDBMaintDuration = metrics.NewGaugeVec("s3mini_db_maintenance_duration_seconds",
+		"Duration of the last SQLite maintenance operation.", "op")
+	mDBCheckpointedPages = metrics.NewCounter("s3mini_db_wal_checkpointed_pages_total",
+		"WAL pages written back to the SQLite database by maintenance checkpoints.")
+	mDBVacuumFreedPages = metrics.NewCounter("s3mini_db_vacuum_freed_pages_total",
+		"SQLite pages returned to the filesystem by incremental vacuum.")
+	mDBFreelistPages = metrics.NewGauge("s3mini_db_freelist_pages",
+		"Free SQLite pages left in the database file after the last maintenance pass.")
+)
+
+// dbVacuumBudget — сколько времени за проход отдаётся incremental vacuum;
+// остаток страниц освободится на следующих проходах.
+const dbVacuumBudget = 5 * time.Second
+
+// Обслуживание SQLite раз в DB_MAINTENANCE_S: checkpoint WAL (TRUNCATE — файл
+// -wal обрезается до нуля), PRAGMA optimize и incremental vacuum. Файл базы
+// общий, поэтому проход делает держатель lease; в режиме только чтения
+// проходы пропускаются.
+
+// StartDBMaintenance запускает обслуживание базы; для PostgreSQL и MySQL ничего не делает.
+func (s *Server) StartDBMaintenance(ctx context.Context) {
+	every := time.Duration(s.cfg.DBMaintenanceS) * time.Second
+	log := s.Logger.With(slog.String("comp", "db_maintenance"))
+	if every <= 0 {
+		log.Info("db_maintenance.disabled")
+		return
+	}
+	go func() {
+		log.Info("db_maintenance.started", "every", every.String())
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info("db_maintenance.stopped", "reason", "context canceled")
+				return
+			case <-t.C:
+			}
+			if s.isReadOnly() || !s.holdLease(log, "db_maintenance", every) {
+				continue
+			}
+			if err := s.maintainDB(ctx, log); errors.Is(err, db.ErrMaintenanceUnsupported) {
+				log.Info("db_maintenance.unsupported", "reason", err.Error())
+				return
+			}
+		}
+	}()
+}
+
+// maintainDB — один проход. Ошибка одной операции не отменяет остальные.
+func (s *Server) maintainDB(ctx context.Context, log *slog.Logger) error {
+	start := time.Now()
+
+	opStart := time.Now()
+	cp, err := s.db.CheckpointWAL(ctx)
+	if errors.Is(err, db.ErrMaintenanceUnsupported) {
+		return err
+	}
+	mDBMaintDuration.Set(time.Since(opStart).Seconds(), "checkpoint")
+	switch {
+	case err != nil:
+		mDBMaintRuns.Inc("checkpoint", "failed")
+		log.Error("db_maintenance.checkpoint_fail", "err", err)
+	case cp.Busy:
+		// WAL держит долгий читатель (листинг, бэкап) — перенесём в следующий раз
+		mDBMaintRuns.Inc("checkpoint", "busy")
+		log.Warn("db_maintenance.checkpoint_busy", "wal_pages", cp.Log, "checkpointed", cp.Checkpointed)
+	default:
+		mDBMaintRuns.Inc("checkpoint", "ok")
+	}
+	if cp.Checkpointed > 0 {
+		mDBCheckpointedPages.Add(uint64(cp.Checkpointed))
+	}
+
+	opStart = time.Now()
+	if err := s.db.Optimize(ctx); err != nil {
+		mDBMaintRuns.Inc("optimize", "failed")
+		log.Error("db_maintenance.optimize_fail", "err", err)
+	} else {
+		mDBMaintRuns.Inc("optimize", "ok")
+	}
+	mDBMaintDuration.Set(time.Since(opStart).Seconds(), "optimize")
+
+	opStart = time.Now()
+	vac, err := s.db.IncrementalVacuum(ctx, dbVacuumBudget)
+	mDBMaintDuration.Set(time.Since(opStart).Seconds(), "vacuum")
+	if err != nil {
+		mDBMaintRuns.Inc("vacuum", "failed")
+		log.Error("db_maintenance.vacuum_fail", "err", err)
+	} else {
+		mDBMaintRuns.Inc("vacuum", "ok")
+		mDBVacuumFreedPages.Add(uint64(vac.Freed))
+		mDBFreelistPages.Set(float64(vac.Left))
+		if !vac.Enabled && vac.Left > 0 {
+			// база создана до auto_vacuum=INCREMENTAL — нужен разовый VACUUM
+			log.Warn("db_maintenance.vacuum_disabled", "free_pages", vac.Left)
+		}
+	}
+
+	log.Info("db_maintenance.pass_end", "wal_pages", cp.Log, "checkpointed", cp.Checkpointed,
+		"freed_pages", vac.Freed, "free_pages_left", vac.Left, "dur_ms", time.Since(start).Milliseconds())
+	return nil
+}
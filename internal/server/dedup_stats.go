@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var (
+	mDedupLogicalBytes = metrics.NewGauge("s3mini_dedup_logical_bytes",
+		"Bytes of all live object versions as seen by clients.")
+	mDedupPhysicalBytes = metrics.NewGauge("s3mini_dedup_physical_bytes",
+		"Bytes of plain blobs actually stored.")
+	mDedupSavedBytes = metrics.NewGauge("s3mini_dedup_saved_bytes",
+		"Logical minus physical bytes (savings from checksum dedup and manifests).")
+)
+
+// Разбивки по бакетам в метриках нет: /metrics не требует аутентификации, а
+// имена бакетов и их объём видны только админу (/admin/v1/dedup).
+
+// сколько держим снимки истории экономии
+const dedupHistoryRetention = 90 * 24 * time.Hour
+
+// StartDedupStats периодически считает статистику дедупа: обновляет метрики
+// и пишет снимок в историю (для графика экономии во времени).
+func (s *Server) StartDedupStats(ctx context.Context, every time.Duration) {
+	log := s.Logger.With(slog.String("comp", "dedup_stats"))
+	go func() {
+		log.Info("dedup_stats.started", "every", every.String())
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			// снимок истории — запись в БД, в режиме только чтения проход пропускается
+			if !s.isReadOnly() && s.holdLease(log, "dedup_stats", every) {
+				s.collectDedupStats(log)
+			}
+			select {
+			case <-ctx.Done():
+				log.Info("dedup_stats.stopped", "reason", "context canceled")
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (s *Server) collectDedupStats(log *slog.Logger) {
+	snap, err := s.db.GlobalUsageStats()
+	if err != nil {
+		log.Error("dedup_stats.global_fail", "err", err)
+		return
+	}
+	mDedupLogicalBytes.Set(float64(snap.LogicalBytes))
+	mDedupPhysicalBytes.Set(float64(snap.PhysicalBytes))
+	mDedupSavedBytes.Set(float64(snap.LogicalBytes - snap.PhysicalBytes))
+
+	snap.TakenAt = time.Now().UTC()
+	if err := s.db.SaveDedupSnapshot(&snap); err != nil {
+		log.Error("dedup_stats.snapshot_fail", "err", err)
+	}
+	if err := s.db.PruneDedupSnapshots(snap.TakenAt.Add(-dedupHistoryRetention)); err != nil {
+		log.Warn("dedup_stats.prune_fail", "err", err)
+	}
+	log.Info("dedup_stats.collected", "logical", snap.LogicalBytes, "physical", snap.PhysicalBytes)
+}
+
+type dedupReport struct {
+	Scope         string             `json:"scope"` // global | имя бакета
+	Versions      int64              `json:"versions"`
+	Blobs         int64              `json:"blobs,omitempty"`
+	LogicalBytes  int64              `json:"logical_bytes"`
+	PhysicalBytes int64              `json:"physical_bytes"`
+	SavedBytes    int64              `json:"saved_bytes"`
+	Ratio         float64            `json:"dedup_ratio"` // logical / physical
+	Buckets       []db.BucketUsage   `json:"buckets,omitempty"`
+	TopDuplicates []db.DuplicateBlob `json:"top_duplicates"`
+}
+
+// GET /admin/v1/dedup[?bucket=name][&top=N]
+func (s *Server) handleAdminDedup(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	log := loggerFrom(r)
+	top := 20
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "top must be 0..1000")
+			return
+		}
+		top = n
+	}
+
+	rep := dedupReport{Scope: "global", TopDuplicates: []db.DuplicateBlob{}}
+	var bucketID uint
+	if name := r.URL.Query().Get("bucket"); name != "" {
+		b, err := s.db.FindBucketByName(name)
+		if errors.Is(err, db.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "NoSuchBucket", "bucket not found")
+			return
+		}
+		if err != nil {
+			log.Error("admin_dedup.bucket_lookup_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		bucketID, rep.Scope = b.ID, b.Name
+	}
+
+	buckets, err := s.db.BucketUsageStats(bucketID)
+	if err != nil {
+		log.Error("admin_dedup.buckets_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	if bucketID == 0 {
+		snap, err := s.db.GlobalUsageStats()
+		if err != nil {
+			log.Error("admin_dedup.global_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		rep.Versions, rep.Blobs = snap.Versions, snap.Blobs
+		rep.LogicalBytes, rep.PhysicalBytes = snap.LogicalBytes, snap.PhysicalBytes
+		rep.Buckets = buckets
+	} else if len(buckets) == 1 {
+		rep.Versions = buckets[0].Versions
+		rep.LogicalBytes, rep.PhysicalBytes = buckets[0].LogicalBytes, buckets[0].PhysicalBytes
+	}
+	rep.SavedBytes = rep.LogicalBytes - rep.PhysicalBytes
+	if rep.PhysicalBytes > 0 {
+		rep.Ratio = float64(rep.LogicalBytes) / float64(rep.PhysicalBytes)
+	}
+
+	if top > 0 {
+		dups, err := s.db.TopDuplicateBlobs(bucketID, top)
+		if err != nil {
+			log.Error("admin_dedup.top_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		if dups != nil {
+			rep.TopDuplicates = dups
+		}
+	}
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// GET /admin/v1/dedup/history[?days=N] — снимки экономии во времени.
+func (s *Server) handleAdminDedupHistory(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "days must be positive")
+			return
+		}
+		days = n
+	}
+	snaps, err := s.db.DedupHistory(time.Now().UTC().AddDate(0, 0, -days))
+	if err != nil {
+		loggerFrom(r).Error("admin_dedup.history_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	if snaps == nil {
+		snaps = []db.DedupSnapshot{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"snapshots": snaps})
+}
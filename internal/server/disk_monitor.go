@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+)
+
+var (
+	mDiskFreeBytes = metrics.NewGaugeVec("s3mini_disk_free_bytes",
+		"Free space available to the server on the storage node filesystem.", "node")
+	mDiskTotalBytes = metrics.NewGaugeVec("s3mini_disk_total_bytes",
+		"Size of the storage node filesystem.", "node")
+	mDiskWatermark = metrics.NewGaugeVec("s3mini_disk_watermark",
+		"Free space level of the storage node: 0 ok, 1 below DISK_WARN_FREE_MB, 2 below DISK_MIN_FREE_MB.", "node")
+	mDiskState = metrics.NewGaugeVec("s3mini_disk_state",
+		"State of a disk in a multi-disk storage node: 0 ok, 1 full, 2 failed.", "node", "disk")
+	mDiskRejected = metrics.NewCounter("s3mini_disk_full_rejected_total",
+		"Uploads rejected with 507 because the storage disk is low on free space.")
+)
+
+// Свободное место на дисках хранилища: раз в DISK_CHECK_S монитор замеряет
+// каждый узел (statfs корня fsdriver). Ниже DISK_WARN_FREE_MB — предупреждение
+// в лог и watermark=1, чтобы диск успели расчистить заранее; ниже
+// DISK_MIN_FREE_MB на основном узле запросы с данными (PUT, UploadPart,
+// append, POST-форма) получают 507 InsufficientStorage. Чтение, удаление и
+// листинги работают: место освобождается удалением. Остальное место под
+// порогом остаётся SQLite, tmp-файлам и GC. У узла из нескольких дисков
+// (DATA_DIRS) тот же проход проверяет каждый диск пробной записью: сломанный
+// или заполненный диск новых блобов не получает.
+
+// diskState — последний замер основного узла.
+type diskState struct {
+	Free, Total int64
+	At          time.Time
+}
+
+// Пороги в байтах; 0 — выключен.
+func (s *Server) diskWarnFree() int64 { return int64(s.cfg.DiskWarnFreeMB) << 20 }
+func (s *Server) diskMinFree() int64  { return int64(s.cfg.DiskMinFreeMB) << 20 }
+
+// diskLevel — 0 места хватает, 1 ниже порога предупреждения, 2 ниже минимума.
+func (s *Server) diskLevel(free int64) int {
+	switch {
+	case s.diskMinFree() > 0 && free < s.diskMinFree():
+		return 2
+	case s.diskWarnFree() > 0 && free < s.diskWarnFree():
+		return 1
+	}
+	return 0
+}
+
+// StartDiskMonitor замеряет место сразу и дальше раз в DISK_CHECK_S.
+func (s *Server) StartDiskMonitor(ctx context.Context) {
+	every := time.Duration(s.cfg.DiskCheckS) * time.Second
+	log := s.Logger.With(slog.String("comp", "disk"))
+	if every <= 0 || (s.diskWarnFree() <= 0 && s.diskMinFree() <= 0) {
+		log.Info("disk.monitor_disabled")
+		return
+	}
+	go func() {
+		log.Info("disk.started", "every", every.String(), "warn_free_mb", s.cfg.DiskWarnFreeMB, "min_free_mb", s.cfg.DiskMinFreeMB)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		levels, states := map[string]int{}, map[string]string{}
+		for {
+			if !s.checkDisks(ctx, log, levels, states) {
+				log.Info("disk.unsupported")
+				return
+			}
+			select {
+			case <-ctx.Done():
+				log.Info("disk.stopped", "reason", "context canceled")
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// checkDisks — один замер всех узлов; levels и states — прошлые уровни узлов
+// и состояния дисков, чтобы писать в лог переходы, а не каждый замер.
+// false — ни один узел не умеет мерить место.
+func (s *Server) checkDisks(ctx context.Context, log *slog.Logger, levels map[string]int, states map[string]string) bool {
+	measured := false
+	for _, node := range s.storage.NodeNames() {
+		if disks, ok := s.storage.CheckDisksNode(ctx, node); ok {
+			s.trackDiskStates(log, node, disks, states)
+		}
+		free, total, ok, err := s.storage.DiskSpaceNode(ctx, node)
+		if !ok || errors.Is(err, errors.ErrUnsupported) {
+			continue
+		}
+		measured = true
+		if err != nil {
+			log.Error("disk.stat_fail", "node", node, "err", err)
+			continue
+		}
+		mDiskFreeBytes.Set(float64(free), node)
+		mDiskTotalBytes.Set(float64(total), node)
+		if node == storage.NodeLocal {
+			s.disk.Store(&diskState{Free: free, Total: total, At: time.Now().UTC()})
+		}
+
+		level, prev := s.diskLevel(free), levels[node]
+		mDiskWatermark.Set(float64(level), node)
+		levels[node] = level
+		switch {
+		case level == 2 && prev != 2:
+			log.Error("disk.full", "node", node, "free_bytes", free, "min_free_bytes", s.diskMinFree())
+		case level == 1 && prev != 1:
+			log.Warn("disk.low", "node", node, "free_bytes", free, "warn_free_bytes", s.diskWarnFree())
+		case level == 0 && prev != 0:
+			log.Info("disk.recovered", "node", node, "free_bytes", free)
+		}
+	}
+	return measured
+}
+
+var diskStateValue = map[string]float64{storage.DiskOK: 0, storage.DiskFull: 1, storage.DiskFailed: 2}
+
+func (s *Server) trackDiskStates(log *slog.Logger, node string, disks []storage.DiskStatus, states map[string]string) {
+	for _, d := range disks {
+		mDiskState.Set(diskStateValue[d.State], node, d.Path)
+		k := node + "|" + d.Path
+		prev, seen := states[k]
+		states[k] = d.State
+		if prev == d.State || (!seen && d.State == storage.DiskOK) {
+			continue
+		}
+		switch d.State {
+		case storage.DiskFailed:
+			log.Error("disk.jbod_failed", "node", node, "disk", d.Path, "err", d.Error)
+		case storage.DiskFull:
+			log.Warn("disk.jbod_full", "node", node, "disk", d.Path, "free_bytes", d.Free)
+		default:
+			log.Info("disk.jbod_ok", "node", node, "disk", d.Path, "was", prev)
+		}
+	}
+}
+
+// checkDiskSpace отвечает 507, если после записи size байт (-1 — размер
+// неизвестен) на основном узле останется меньше DISK_MIN_FREE_MB.
+func (s *Server) checkDiskSpace(w http.ResponseWriter, r *http.Request, size int64) bool {
+	st := s.disk.Load()
+	min := s.diskMinFree()
+	if st == nil || min <= 0 {
+		return true
+	}
+	if size < 0 {
+		size = 0
+	}
+	if st.Free-size >= min {
+		return true
+	}
+	mDiskRejected.Inc()
+	loggerFrom(r).Warn("disk.write_rejected", "free_bytes", st.Free, "size", size, "min_free_bytes", min)
+	w.Header().Set("Retry-After", "60")
+	writeS3Error(w, http.StatusInsufficientStorage, "InsufficientStorage",
+		"Not enough free disk space on the server to store the object.", r.URL.Path, requestIDFrom(r))
+	return false
+}
+
+// nodeDisks — место узла и его диски в админском API.
+type nodeDisks struct {
+	Node           string               `json:"node"`
+	Free           int64                `json:"free_bytes"`
+	Total          int64                `json:"total_bytes"`
+	Error          string               `json:"error,omitempty"`
+	Disks          []storage.DiskStatus `json:"disks,omitempty"` // только у узла из нескольких дисков
+	WritesRejected bool                 `json:"writes_rejected"` // ниже DISK_MIN_FREE_MB (основной узел)
+}
+
+// GET /admin/v1/disks — свободное место узлов хранения и состояние их дисков.
+func (s *Server) handleAdminDisks(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	out := []nodeDisks{}
+	for _, node := range s.storage.NodeNames() {
+		nd := nodeDisks{Node: node}
+		free, total, ok, err := s.storage.DiskSpaceNode(r.Context(), node)
+		switch {
+		case !ok || errors.Is(err, errors.ErrUnsupported):
+			nd.Error = "driver cannot report disk space"
+		case err != nil:
+			nd.Error = err.Error()
+		default:
+			nd.Free, nd.Total = free, total
+		}
+		nd.Disks, _ = s.storage.DisksNode(node)
+		if node == storage.NodeLocal {
+			if st := s.disk.Load(); st != nil && s.diskMinFree() > 0 {
+				nd.WritesRejected = st.Free < s.diskMinFree()
+			}
+		}
+		out = append(out, nd)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"nodes": out})
+}
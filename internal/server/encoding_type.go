@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// encoding-type=url в листингах: ключи, префиксы и delimiter в ответе
+// URL-кодируются. Иначе управляющие символы, недопустимые в XML 1.0, дошли бы
+// до клиента заменёнными на U+FFFD, и такой ключ нельзя было бы запросить.
+
+const encodingTypeURL = "url"
+
+// parseEncodingType — пусто или "url"; остальное — 400 как в S3.
+func parseEncodingType(w http.ResponseWriter, r *http.Request) (string, bool) {
+	et := r.URL.Query().Get("encoding-type")
+	if et != "" && et != encodingTypeURL {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Invalid Encoding Method specified in Request", r.URL.Path, requestIDFrom(r))
+		return "", false
+	}
+	return et, true
+}
+
+// listKey — строка для ответа листинга. QueryEscape (пробел — "+") — так кодирует
+// S3, и SDK раскодируют именно так.
+func listKey(encodingType, s string) string {
+	if encodingType != encodingTypeURL {
+		return s
+	}
+	return url.QueryEscape(s)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const hdrSSEContext = "x-amz-server-side-encryption-context"
+
+// лимит как у KMS: 8 КБ на весь контекст; у нас — размер колонки
+const maxEncryptionContextLen = 2048
+
+var errBadEncryptionContext = errors.New("x-amz-server-side-encryption-context must be base64-encoded JSON object of strings")
+
+// parseEncryptionContext разбирает заголовок (base64 JSON {"k":"v"}) в
+// канонический JSON с отсортированными ключами. Пустой заголовок — "".
+func parseEncryptionContext(h http.Header) (string, error) {
+	raw := h.Get(hdrSSEContext)
+	if raw == "" {
+		return "", nil
+	}
+	js, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return "", errBadEncryptionContext
+	}
+	var kv map[string]string
+	if err := json.Unmarshal(js, &kv); err != nil || len(kv) == 0 {
+		return "", errBadEncryptionContext
+	}
+	canon, _ := json.Marshal(kv) // map => ключи отсортированы
+	if len(canon) > maxEncryptionContextLen {
+		return "", errors.New("encryption context is too large")
+	}
+	return string(canon), nil
+}
+
+// checkPutEncryptionContext — контекст с PUT: валиден и идёт вместе с SSE-KMS.
+func checkPutEncryptionContext(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ectx, err := parseEncryptionContext(r.Header)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error(), r.URL.Path, requestIDFrom(r))
+		return "", false
+	}
+	if ectx != "" {
+		switch r.Header.Get("x-amz-server-side-encryption") {
+		case "aws:kms", "aws:kms:dsse":
+		default:
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument",
+				"encryption context requires x-amz-server-side-encryption: aws:kms", r.URL.Path, requestIDFrom(r))
+			return "", false
+		}
+	}
+	return ectx, true
+}
+
+// checkGetEncryptionContext — версия с контекстом отдаётся только тому, кто
+// предъявил тот же контекст (аналог KMS grant на Decrypt).
+func checkGetEncryptionContext(w http.ResponseWriter, r *http.Request, stored *string) bool {
+	if stored == nil || *stored == "" {
+		return true
+	}
+	got, err := parseEncryptionContext(r.Header)
+	if err != nil || got != *stored {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied",
+			"encryption context does not match the one the object was stored with", r.URL.Path, requestIDFrom(r))
+		return false
+	}
+	w.Header().Set("x-amz-server-side-encryption", "aws:kms")
+	w.Header().Set(hdrSSEContext, base64.StdEncoding.EncodeToString([]byte(got)))
+	return true
+}
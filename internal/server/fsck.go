@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+)
+
+// s3mini fsck: сверка таблицы blobs с файлами на узлах хранения. Находит файлы
+// без записи (сироты), недописанные .tmp-* от оборванных загрузок, записи без
+// файла, расхождения размера (и sha256 с -checksums) и ссылки версий на
+// несуществующие блобы. С Repair удаляет сирот и tmp-файлы, а пропавшие и
+// испорченные блобы помечает в verify_status — байты этим не вернуть.
+
+const (
+	fsckOrphan   = "orphan"   // файл без записи в blobs (или запись на другом узле)
+	fsckTmp      = "tmp"      // недописанный файл оборванной записи
+	fsckMissing  = "missing"  // у готового блоба нет файла
+	fsckSize     = "size"     // размер файла не совпадает с записью
+	fsckChecksum = "checksum" // sha256 байт не совпадает с записью
+	fsckDangling = "dangling" // версия или кусок ссылается на несуществующий блоб
+)
+
+const fsckBatch = 500
+
+// FsckOptions — режим проверки.
+type FsckOptions struct {
+	Repair    bool
+	Checksums bool // перечитать байты каждого блоба и сверить sha256
+	// файлы моложе MinAge могут принадлежать идущей записи — их не трогаем
+	MinAge time.Duration
+}
+
+// FsckIssue — одна найденная несостыковка.
+type FsckIssue struct {
+	Kind     string
+	Node     string
+	BlobID   string
+	Detail   string
+	Action   string // что сделано при Repair: deleted, marked missing, ...
+	Repaired bool   // проблемы больше нет
+}
+
+// FsckStats — итог проверки.
+type FsckStats struct {
+	Files        int // просмотрено файлов
+	Blobs        int // просмотрено записей blobs
+	Issues       int
+	Repaired     int
+	SkippedNodes []string // драйвер узла не умеет перечислять файлы
+}
+
+// Fsck проверяет хранилище и базу, о каждой находке сообщает report.
+func (s *Server) Fsck(ctx context.Context, opt FsckOptions, report func(FsckIssue)) (FsckStats, error) {
+	var st FsckStats
+	emit := func(is FsckIssue) {
+		st.Issues++
+		if is.Repaired {
+			st.Repaired++
+		}
+		report(is)
+	}
+	for _, node := range s.storage.NodeNames() {
+		ok, err := s.fsckFiles(ctx, node, opt, &st, emit)
+		if err != nil {
+			return st, fmt.Errorf("node %s: %w", node, err)
+		}
+		if !ok {
+			st.SkippedNodes = append(st.SkippedNodes, node)
+		}
+	}
+	if err := s.fsckBlobs(ctx, opt, &st, emit); err != nil {
+		return st, err
+	}
+	refs, err := s.db.DanglingBlobRefs(1000)
+	if err != nil {
+		return st, err
+	}
+	for _, ref := range refs {
+		emit(FsckIssue{Kind: fsckDangling, BlobID: ref.BlobID, Detail: ref.Table + " " + ref.Owner})
+	}
+	return st, nil
+}
+
+// fsckFiles — файлы узла: сироты и недописанные записи.
+func (s *Server) fsckFiles(ctx context.Context, node string, opt FsckOptions, st *FsckStats, emit func(FsckIssue)) (bool, error) {
+	now := time.Now()
+	var batch []storage.StoredFile
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]string, len(batch))
+		for i, f := range batch {
+			ids[i] = string(f.ID)
+		}
+		blobs, err := s.db.BlobsByIDs(ids)
+		if err != nil {
+			return err
+		}
+		for _, f := range batch {
+			b, found := blobs[string(f.ID)]
+			var detail string
+			switch {
+			case !found:
+				detail = "no blob record"
+			case storageNode(b.StorageNode) != node:
+				detail = "blob lives on node " + storageNode(b.StorageNode)
+			case b.Kind == "manifest":
+				detail = "manifest blob has no bytes of its own"
+			default:
+				continue
+			}
+			is := FsckIssue{Kind: fsckOrphan, Node: node, BlobID: string(f.ID),
+				Detail: fmt.Sprintf("%s, %d bytes, %s old", detail, f.Size, now.Sub(f.ModTime).Round(time.Second))}
+			if opt.Repair {
+				if err := s.storage.DeleteNode(ctx, node, string(f.ID)); err != nil {
+					is.Action = "delete failed: " + err.Error()
+				} else {
+					is.Action, is.Repaired = "deleted", true
+				}
+			}
+			emit(is)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	ok, err := s.storage.WalkNode(ctx, node, func(f storage.StoredFile) error {
+		st.Files++
+		if now.Sub(f.ModTime) < opt.MinAge {
+			return nil
+		}
+		if !f.Tmp {
+			batch = append(batch, f)
+			if len(batch) >= fsckBatch {
+				return flush()
+			}
+			return nil
+		}
+		is := FsckIssue{Kind: fsckTmp, Node: node, BlobID: string(f.ID),
+			Detail: fmt.Sprintf("%s, %d bytes, %s old", f.Name, f.Size, now.Sub(f.ModTime).Round(time.Second))}
+		if opt.Repair {
+			if err := s.storage.RemoveTmpNode(ctx, node, f.Name); err != nil {
+				is.Action = "delete failed: " + err.Error()
+			} else {
+				is.Action, is.Repaired = "deleted", true
+			}
+		}
+		emit(is)
+		return nil
+	})
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, flush()
+}
+
+// fsckBlobs — записи blobs: есть ли файл, тот ли размер, (с Checksums) те ли байты.
+func (s *Server) fsckBlobs(ctx context.Context, opt FsckOptions, st *FsckStats, emit func(FsckIssue)) error {
+	after := ""
+	for {
+		blobs, err := s.db.ListBlobsAfter(after, fsckBatch)
+		if err != nil {
+			return err
+		}
+		if len(blobs) == 0 {
+			return nil
+		}
+		after = blobs[len(blobs)-1].ID
+		for _, b := range blobs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			st.Blobs++
+			// pending — незавершённая загрузка, у manifest-блоба своих байт нет
+			if b.Kind == "manifest" || b.State == "pending" {
+				continue
+			}
+			if err := s.fsckBlob(ctx, b, opt, emit); err != nil {
+				return fmt.Errorf("blob %s: %w", b.ID, err)
+			}
+		}
+	}
+}
+
+func (s *Server) fsckBlob(ctx context.Context, b db.Blob, opt FsckOptions, emit func(FsckIssue)) error {
+	node := storageNode(b.StorageNode)
+	mark := func(is FsckIssue, status string) error {
+		if opt.Repair {
+			if err := s.db.MarkBlobVerified(b.ID, status, time.Now().UTC()); err != nil {
+				return err
+			}
+			is.Action = "marked " + status
+		}
+		emit(is)
+		return nil
+	}
+
+	size, found, err := s.storage.StatNode(ctx, node, b.ID)
+	if err != nil && s.storage.HasNode(node) {
+		return err
+	}
+	if !found {
+		detail := fmt.Sprintf("ref_count %d%s", b.RefCount, s.fsckUsers(b.ID))
+		if err != nil {
+			detail = err.Error() + ", " + detail
+		}
+		return mark(FsckIssue{Kind: fsckMissing, Node: node, BlobID: b.ID, Detail: detail}, verifyMissing)
+	}
+	want := b.Size
+	if b.Encoding != "" {
+		want = b.StoredSize
+	}
+	if size != want {
+		return mark(FsckIssue{Kind: fsckSize, Node: node, BlobID: b.ID,
+			Detail: fmt.Sprintf("%d bytes on disk, want %d%s", size, want, s.fsckUsers(b.ID))}, verifyCorrupt)
+	}
+	if !opt.Checksums {
+		return nil
+	}
+	br, err := s.verifyBlob(ctx, b.ID)
+	if err != nil {
+		// битый gzip и т.п. — тоже порча, а не сбой проверки
+		return mark(FsckIssue{Kind: fsckChecksum, Node: node, BlobID: b.ID,
+			Detail: "read: " + err.Error() + s.fsckUsers(b.ID)}, verifyCorrupt)
+	}
+	if br.Status == verifyOK {
+		if opt.Repair {
+			return s.db.MarkBlobVerified(b.ID, verifyOK, time.Now().UTC())
+		}
+		return nil
+	}
+	return mark(FsckIssue{Kind: fsckChecksum, Node: node, BlobID: b.ID,
+		Detail: fmt.Sprintf("read %s (%d bytes), want %s%s", br.Actual, br.ReadSize, br.Expected, s.fsckUsers(b.ID))}, br.Status)
+}
+
+// fsckUsers — несколько версий, которые задевает проблема с блобом, для отчёта.
+func (s *Server) fsckUsers(blobID string) string {
+	users, err := s.db.BlobUsers(blobID, 4)
+	if err != nil || len(users) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(users))
+	for i, u := range users {
+		if i == 3 {
+			parts = append(parts, "...")
+			break
+		}
+		parts = append(parts, u.Bucket+"/"+u.Key+"@"+u.VersionID)
+	}
+	return ", used by " + strings.Join(parts, " ")
+}
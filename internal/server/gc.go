@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"log/slog"
+
+	"gorm.io/gorm"
 )
 
 func (s *Server) StartGC(ctx context.Context, every time.Duration, batch int) {
@@ -22,46 +24,87 @@ func (s *Server) StartGC(ctx context.Context, every time.Duration, batch int) {
 				log.Info("gc.stopped", "reason", "context canceled")
 				return
 			case <-t.C:
-				start := time.Now()
-				totalFiles := 0
-				var totalBytes int64 = 0
-
-				rows, err := s.db.BlobsForGCWithSize(batch)
-				if err != nil {
-					log.Error("gc.query_fail", "err", err)
-					continue
-				}
-				if len(rows) == 0 {
-					log.Info("gc.nothing_to_do")
+				if s.isReadOnly() || !s.holdLease(log, "gc", every) {
 					continue
 				}
+				s.gcPass(ctx, log, batch)
+			}
+		}
+	}()
+}
 
-				log.Info("gc.pass_begin", "candidates", len(rows))
-				for _, r := range rows {
-					// удаляем байты
-					if err := s.storage.Delete(ctx, r.ID); err != nil {
-						log.Error("gc.storage_delete_fail", "blob_id", r.ID, "err", err)
-						// пропускаем удаление записи — попробуем в следующий проход
-						continue
-					}
-					// удаляем запись
-					if err := s.db.DeleteBlobRecordTx(s.db.DB, r.ID); err != nil {
-						log.Error("gc.db_delete_fail", "blob_id", r.ID, "err", err)
-						// это не критично: байты уже удалены, но запись добьём на следующем проходе
-						continue
-					}
+// gcPass — один проход GC: корзины, приговор сиротам и удаление тех, чьё окно
+// истекло.
+func (s *Server) gcPass(ctx context.Context, log *slog.Logger, batch int) {
+	start := time.Now()
+	totalFiles := 0
+	var totalBytes int64 = 0
 
-					totalFiles++
-					totalBytes += r.Size
-					log.Info("gc.deleted", "blob_id", r.ID, "size", r.Size)
-				}
+	// корзины бакетов: истёкшие версии снимают ссылки, и их блобы
+	// встают в очередь ниже
+	if n, err := s.db.PurgeExpiredTrash(start, batch); err != nil {
+		log.Error("gc.trash_purge_fail", "err", err)
+	} else if n > 0 {
+		log.Info("gc.trash_purged", "versions", n)
+	}
 
-				log.Info("gc.pass_end",
-					"deleted_files", totalFiles,
-					"freed_bytes", totalBytes,
-					"dur_ms", time.Since(start).Milliseconds(),
-				)
-			}
+	// фаза 1: приговор осиротевшим блобам; фаза 2: удаление тех, кто
+	// за окно GC_GRACE_S так и не получил ссылку
+	if n, err := s.db.CondemnBlobs(start); err != nil {
+		log.Error("gc.condemn_fail", "err", err)
+		return
+	} else if n > 0 {
+		log.Info("gc.condemned", "blobs", n)
+	}
+	cutoff := start.Add(-s.gcGrace())
+	rows, err := s.db.BlobsForGCWithSize(batch, cutoff)
+	if err != nil {
+		log.Error("gc.query_fail", "err", err)
+		return
+	}
+	if len(rows) == 0 {
+		log.Info("gc.nothing_to_do")
+		return
+	}
+
+	log.Info("gc.pass_begin", "candidates", len(rows))
+	for _, r := range rows {
+		// сначала запись, и только если ссылок так и нет: PUT мог
+		// продедупиться на этот блоб уже после выборки кандидатов
+		var gone bool
+		err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+			var err error
+			gone, err = s.db.DeleteBlobIfUnreferencedTx(tx, r.ID, cutoff)
+			return err
+		})
+		if err != nil {
+			log.Error("gc.db_delete_fail", "blob_id", r.ID, "err", err)
+			continue
 		}
-	}()
+		if !gone {
+			log.Info("gc.blob_revived", "blob_id", r.ID)
+			continue
+		}
+		// байты без записи уже никто не прочтёт; не удалились — останутся сиротой на диске
+		if err := s.storage.Delete(ctx, r.ID); err != nil {
+			log.Error("gc.storage_delete_fail", "blob_id", r.ID, "err", err)
+			continue
+		}
+
+		totalFiles++
+		totalBytes += r.Size
+		log.Info("gc.deleted", "blob_id", r.ID, "size", r.Size)
+	}
+
+	log.Info("gc.pass_end",
+		"deleted_files", totalFiles,
+		"freed_bytes", totalBytes,
+		"dur_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// gcGrace — окно между приговором блоба и его удалением. Пока оно идёт, блоб
+// можно снова получить ссылкой (дедуп), а начатые чтения его байт — дочитать.
+func (s *Server) gcGrace() time.Duration {
+	return time.Duration(s.cfg.GCGraceS) * time.Second
 }
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+	"github.com/DanikLP1/s3-storage-service/internal/storage/fsdriver"
+)
+
+// Гонки PUT с дедупом, DELETE и GC; запускать с go test -race. Тела общие у
+// всех воркеров, так что PUT то и дело дедупится на блоб, с которого другой
+// воркер только что снял последнюю ссылку, а GC без окна (GC_GRACE_S=0)
+// забирает сирот каждые несколько миллисекунд. Живой блоб терять нельзя: GET ключа
+// обязан отдать байты его текущей версии.
+
+type raceEnv struct {
+	s       *Server
+	handler http.Handler
+	driver  storage.StorageDriver
+}
+
+func newRaceEnv(t *testing.T) *raceEnv {
+	t.Helper()
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("db: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := database.DB.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	if _, err := database.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	userID, err := database.EnsureUser("RACE", "racesecret")
+	if err != nil {
+		t.Fatalf("user: %v", err)
+	}
+	if err := database.SetUserRole("RACE", db.RoleAdmin); err != nil {
+		t.Fatalf("role: %v", err)
+	}
+
+	cfg := config.New()
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.GCGraceS = 0
+	drv := fsdriver.New(cfg.DataDir)
+	s := New(database, drv, slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+	// подпись запросов здесь не проверяется — пользователь сразу в контексте
+	router := s.Router()
+	handler := s.WithRequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxUserKey, userID)))
+	}))
+	return &raceEnv{s: s, handler: handler, driver: drv}
+}
+
+func (e *raceEnv) do(method, path string, body []byte) *httptest.ResponseRecorder {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, rd)
+	if body != nil {
+		req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	}
+	rec := httptest.NewRecorder()
+	e.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// mutate — PUT или DELETE с повтором после 5xx, как у клиентов S3: под -race
+// SQLite не всегда дожидается блокировки записи за busy_timeout. GET не
+// повторяется — потерянные байты должны всплыть.
+func (e *raceEnv) mutate(method, path string, body []byte) *httptest.ResponseRecorder {
+	rec := e.do(method, path, body)
+	for attempt := 1; rec.Code >= 500 && attempt < 5; attempt++ {
+		rec = e.do(method, path, body)
+	}
+	return rec
+}
+
+// raceVersion — версия ключа, которую воркер записал и ещё не удалил.
+type raceVersion struct {
+	id   string
+	body []byte
+}
+
+func TestRacePutDeleteGC(t *testing.T) {
+	for _, versioning := range []string{db.VersioningDisabled, db.VersioningEnabled} {
+		t.Run(versioning, func(t *testing.T) {
+			e := newRaceEnv(t)
+			if rec := e.do(http.MethodPut, "/race", nil); rec.Code != http.StatusOK {
+				t.Fatalf("create bucket: %d %s", rec.Code, rec.Body)
+			}
+			bkt, err := e.s.db.FindBucketByName("race")
+			if err != nil {
+				t.Fatalf("bucket: %v", err)
+			}
+			if err := e.s.db.UpdateBucketSettings(bkt.ID, map[string]any{"versioning": versioning}); err != nil {
+				t.Fatalf("versioning: %v", err)
+			}
+
+			// проходы GC как у StartGC, но с остановкой до конца теста: после него
+			// временный каталог с БД и блобами удаляется
+			ctx, cancel := context.WithCancel(context.Background())
+			gcDone := make(chan struct{})
+			go func() {
+				defer close(gcDone)
+				log := e.s.Logger.With("comp", "gc")
+				for ; ctx.Err() == nil; time.Sleep(5 * time.Millisecond) {
+					e.s.gcPass(ctx, log, 64)
+				}
+			}()
+
+			bodies := make([][]byte, 4)
+			for i := range bodies {
+				bodies[i] = bytes.Repeat([]byte(fmt.Sprintf("body %d;", i)), 512)
+			}
+			const workers, ops = 4, 40
+			var wg sync.WaitGroup
+			errs := make(chan error, workers)
+			for w := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := raceWorker(e, versioning == db.VersioningEnabled, w, ops, bodies); err != nil {
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			cancel()
+			<-gcDone
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			// у каждой оставшейся версии есть и запись блоба, и его байты
+			var blobIDs []string
+			if err := e.s.db.Model(&db.ObjectVersion{}).Where("blob_id IS NOT NULL").Distinct().Pluck("blob_id", &blobIDs).Error; err != nil {
+				t.Fatalf("versions: %v", err)
+			}
+			for _, id := range blobIDs {
+				if _, err := e.s.db.GetBlob(id); err != nil {
+					t.Errorf("blob %s of a live version: %v", id, err)
+					continue
+				}
+				if _, ok, err := e.driver.Stat(context.Background(), storage.BlobID(id)); err != nil || !ok {
+					t.Errorf("bytes of blob %s: exists=%v err=%v", id, ok, err)
+				}
+			}
+		})
+	}
+}
+
+// raceWorker пишет и удаляет свои ключи и после каждой операции сверяет GET
+// с тем, что должно быть текущей версией. С версиями удаляется конкретная
+// версия (последняя или из середины истории), без них — ключ целиком.
+func raceWorker(e *raceEnv, versioned bool, w, ops int, bodies [][]byte) error {
+	rnd := rand.New(rand.NewSource(int64(w)))
+	history := map[string][]raceVersion{}
+	for i := range ops {
+		key := fmt.Sprintf("/race/w%d/k%d", w, i%3)
+		body := bodies[rnd.Intn(len(bodies))]
+		rec := e.mutate(http.MethodPut, key, body)
+		if rec.Code != http.StatusOK {
+			return fmt.Errorf("PUT %s: %d %s", key, rec.Code, rec.Body)
+		}
+		v := raceVersion{id: rec.Header().Get("x-amz-version-id"), body: body}
+		if versioned {
+			history[key] = append(history[key], v)
+		} else {
+			history[key] = []raceVersion{v}
+		}
+
+		if rnd.Intn(2) == 0 {
+			path := key
+			if versioned {
+				j := len(history[key]) - 1
+				if rnd.Intn(2) == 0 {
+					j = rnd.Intn(len(history[key]))
+				}
+				path += "?versionId=" + history[key][j].id
+				history[key] = append(history[key][:j], history[key][j+1:]...)
+			} else {
+				delete(history, key)
+			}
+			if rec := e.mutate(http.MethodDelete, path, nil); rec.Code != http.StatusNoContent {
+				return fmt.Errorf("DELETE %s: %d %s", path, rec.Code, rec.Body)
+			}
+		}
+
+		rec = e.do(http.MethodGet, key, nil)
+		vers := history[key]
+		if len(vers) == 0 {
+			if rec.Code != http.StatusNotFound {
+				return fmt.Errorf("GET %s of a deleted key: %d", key, rec.Code)
+			}
+			continue
+		}
+		if want := vers[len(vers)-1].body; rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), want) {
+			return fmt.Errorf("GET %s: %d, %d bytes, want %d", key, rec.Code, rec.Body.Len(), len(want))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+const (
+	hdrACL        = "x-amz-acl"
+	allUsersGroup = "http://acs.amazonaws.com/groups/global/AllUsers"
+	xsiNamespace  = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// parseACLHeader — canned ACL из x-amz-acl; "" — заголовка нет.
+func parseACLHeader(h http.Header) (string, bool) {
+	switch v := h.Get(hdrACL); v {
+	case "", db.ACLPrivate, db.ACLPublicRead, db.ACLPublicReadWrite:
+		return v, true
+	}
+	return "", false
+}
+
+// заголовки x-amz-grant-* и право, которое каждый выдаёт
+var grantHeaders = []struct{ name, perm string }{
+	{"x-amz-grant-read", db.PermRead},
+	{"x-amz-grant-write", db.PermWrite},
+	{"x-amz-grant-read-acp", db.PermReadACP},
+	{"x-amz-grant-write-acp", db.PermWriteACP},
+	{"x-amz-grant-full-control", db.PermFullControl},
+}
+
+// parseGrantHeaders — гранты из x-amz-grant-*: `id="7", uri="..."`.
+func parseGrantHeaders(h http.Header) ([]ACLGrant, error) {
+	var out []ACLGrant
+	for _, gh := range grantHeaders {
+		v := h.Get(gh.name)
+		if v == "" {
+			continue
+		}
+		for _, item := range strings.Split(v, ",") {
+			typ, val, _ := strings.Cut(strings.TrimSpace(item), "=")
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			if val == "" {
+				return nil, fmt.Errorf("invalid %s value", gh.name)
+			}
+			switch strings.ToLower(strings.TrimSpace(typ)) {
+			case "id":
+				out = append(out, ACLGrant{Grantee: ACLGrantee{ID: val}, Permission: gh.perm})
+			case "uri":
+				out = append(out, ACLGrant{Grantee: ACLGrantee{URI: val}, Permission: gh.perm})
+			default:
+				return nil, fmt.Errorf("%s: only id and uri grantees are supported", gh.name)
+			}
+		}
+	}
+	return out, nil
+}
+
+// readACLRequest — ACL для PUT ?acl: из x-amz-acl, x-amz-grant-* или тела
+// AccessControlPolicy. Гранты AllUsers сводятся к canned ACL (READ и READ+WRITE),
+// гранты CanonicalUser с ID пользователя — к списку грантов; владелец и так
+// имеет FULL_CONTROL, его гранты пропускаются.
+func (s *Server) readACLRequest(r *http.Request, ownerID uint) (acl string, grants []db.BucketGrant, status int, code, msg string) {
+	acl, ok := parseACLHeader(r.Header)
+	if !ok {
+		return "", nil, http.StatusBadRequest, "InvalidArgument", "unsupported canned ACL " + r.Header.Get(hdrACL)
+	}
+	list, err := parseGrantHeaders(r.Header)
+	if err != nil {
+		return "", nil, http.StatusBadRequest, "InvalidArgument", err.Error()
+	}
+	switch {
+	case acl != "" && len(list) > 0:
+		return "", nil, http.StatusBadRequest, "InvalidRequest", "Specifying both Canned ACLs and Header Grants is not allowed"
+	case acl != "":
+		return acl, nil, 0, "", ""
+	case len(list) == 0:
+		var x AccessControlPolicy
+		if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&x); err != nil {
+			return "", nil, http.StatusBadRequest, "MalformedACLError", "The XML you provided was not well-formed or did not validate against our published schema."
+		}
+		list = x.Grants
+	}
+
+	read, write := false, false
+	seen := map[db.BucketGrant]bool{}
+	for _, g := range list {
+		switch {
+		case g.Grantee.URI == allUsersGroup && g.Permission == db.PermRead:
+			read = true
+		case g.Grantee.URI == allUsersGroup && g.Permission == db.PermWrite:
+			write = true
+		case g.Grantee.URI == "" && g.Grantee.ID != "":
+			id, err := strconv.ParseUint(g.Grantee.ID, 10, 32)
+			if err != nil {
+				return "", nil, http.StatusBadRequest, "InvalidArgument", "Invalid id"
+			}
+			switch g.Permission {
+			case db.PermRead, db.PermWrite, db.PermReadACP, db.PermWriteACP, db.PermFullControl:
+			default:
+				return "", nil, http.StatusBadRequest, "MalformedACLError", "unknown permission " + g.Permission
+			}
+			if uint(id) == ownerID {
+				continue
+			}
+			if _, err := s.db.FindUserByID(uint(id)); errors.Is(err, db.ErrNotFound) {
+				return "", nil, http.StatusBadRequest, "InvalidArgument", "Invalid id"
+			} else if err != nil {
+				return "", nil, http.StatusInternalServerError, "InternalError", "db error"
+			}
+			bg := db.BucketGrant{GranteeID: uint(id), Permission: g.Permission}
+			if !seen[bg] {
+				seen[bg] = true
+				grants = append(grants, bg)
+			}
+		default:
+			return "", nil, http.StatusNotImplemented, "NotImplemented", "only AllUsers READ/WRITE and CanonicalUser grants are supported"
+		}
+	}
+	switch {
+	case read && write:
+		return db.ACLPublicReadWrite, grants, 0, "", ""
+	case read:
+		return db.ACLPublicRead, grants, 0, "", ""
+	case write:
+		return "", nil, http.StatusNotImplemented, "NotImplemented", "only grants expressible as a canned ACL are supported"
+	}
+	return db.ACLPrivate, grants, 0, "", ""
+}
+
+// aclToXML — canned ACL и гранты пользователям в виде списка грантов.
+func aclToXML(ownerID uint, acl string, grants []db.BucketGrant) AccessControlPolicy {
+	owner := S3Owner{ID: strconv.FormatUint(uint64(ownerID), 10), DisplayName: "local"}
+	out := AccessControlPolicy{
+		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
+		Owner: owner,
+		Grants: []ACLGrant{{
+			Grantee:    ACLGrantee{XSI: xsiNamespace, Type: "CanonicalUser", ID: owner.ID, DisplayName: owner.DisplayName},
+			Permission: "FULL_CONTROL",
+		}},
+	}
+	for _, g := range grants {
+		out.Grants = append(out.Grants, ACLGrant{
+			Grantee:    ACLGrantee{XSI: xsiNamespace, Type: "CanonicalUser", ID: strconv.FormatUint(uint64(g.GranteeID), 10)},
+			Permission: g.Permission,
+		})
+	}
+	all := ACLGrantee{XSI: xsiNamespace, Type: "Group", URI: allUsersGroup}
+	if acl == db.ACLPublicRead || acl == db.ACLPublicReadWrite {
+		out.Grants = append(out.Grants, ACLGrant{Grantee: all, Permission: "READ"})
+	}
+	if acl == db.ACLPublicReadWrite {
+		out.Grants = append(out.Grants, ACLGrant{Grantee: all, Permission: "WRITE"})
+	}
+	return out
+}
+
+// grantPermission — право гранта бакета, которое нужно для действия; "" — только
+// владельцу. В отличие от S3, READ/WRITE бакета распространяются на его объекты:
+// гранты делят бакет целиком.
+func grantPermission(action string) string {
+	switch {
+	case action == "s3:GetBucketAcl", strings.HasPrefix(action, "s3:GetObjectAcl"):
+		return db.PermReadACP
+	case action == "s3:PutBucketAcl", strings.HasPrefix(action, "s3:PutObjectAcl"):
+		return db.PermWriteACP
+	case strings.HasPrefix(action, "s3:List"), strings.HasPrefix(action, "s3:GetObject"), action == "s3:GetBucketLocation":
+		return db.PermRead
+	case action == "s3:PutObject", action == "s3:AbortMultipartUpload", action == "s3:RestoreObject",
+		strings.HasPrefix(action, "s3:DeleteObject"), strings.HasPrefix(action, "s3:PutObjectTagging"):
+		return db.PermWrite
+	}
+	return ""
+}
+
+// grantAllows — открывают ли гранты бакета действие пользователю userID.
+func (s *Server) grantAllows(b *db.Bucket, userID uint, action string) bool {
+	need := grantPermission(action)
+	if userID == 0 || need == "" {
+		return false
+	}
+	perms, err := s.db.GranteePermissions(b.ID, userID)
+	if err != nil {
+		return false
+	}
+	for _, p := range perms {
+		if p == need || p == db.PermFullControl {
+			return true
+		}
+	}
+	return false
+}
+
+// aclAllows — что canned ACL открывает не-владельцу: листинг и запись — по
+// ACL бакета, чтение объекта — по ACL его версии.
+func (s *Server) aclAllows(b *db.Bucket, action, key, versionID string) bool {
+	switch action {
+	case "s3:ListBucket", "s3:ListBucketMultipartUploads":
+		return b.ACL == db.ACLPublicRead || b.ACL == db.ACLPublicReadWrite
+	case "s3:PutObject", "s3:DeleteObject", "s3:AbortMultipartUpload":
+		return b.ACL == db.ACLPublicReadWrite
+	case "s3:GetObject", "s3:GetObjectVersion":
+		ver, err := s.resolveVersionTx(s.db.DB, b.ID, key, versionID)
+		if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+			// кто может листать бакет, тот и так узнает, что ключа нет: 404 вместо 403, как в S3
+			return b.ACL == db.ACLPublicRead || b.ACL == db.ACLPublicReadWrite
+		}
+		return err == nil && (ver.ACL == db.ACLPublicRead || ver.ACL == db.ACLPublicReadWrite)
+	}
+	return false
+}
+
+// PUT /:bucket?acl
+func (s *Server) handlePutBucketACL(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Error("acl.put.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	acl, grants, status, code, msg := s.readACLRequest(r, b.OwnerID)
+	if status != 0 {
+		writeS3Error(w, status, code, msg, r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := s.db.SetBucketACL(b.ID, acl, grants); err != nil {
+		log.Error("acl.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("acl.put.ok", "acl", acl, "was", b.ACL, "grants", len(grants))
+}
+
+// GET /:bucket?acl
+func (s *Server) handleGetBucketACL(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Error("acl.get.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	grants, err := s.db.ListBucketGrants(b.ID)
+	if err != nil {
+		log.Error("acl.get.grants_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(aclToXML(b.OwnerID, b.ACL, grants))
+}
+
+// PUT /:bucket/:key?acl[&versionId=ID]
+func (s *Server) handlePutObjectACL(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	ver, ok := s.versionTarget(w, r, log)
+	if !ok {
+		return
+	}
+	ownerID := getUserIDFromCtx(r.Context())
+	acl, grants, status, code, msg := s.readACLRequest(r, ownerID)
+	if status != 0 {
+		writeS3Error(w, status, code, msg, r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if len(grants) > 0 {
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "grants to users are supported on buckets only", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := s.db.UpdateVersionFieldsTx(s.db.DB, ver.VersionID, map[string]any{"acl": acl}); err != nil {
+		log.Error("acl.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	w.WriteHeader(http.StatusOK)
+	log.Info("acl.put.ok", "version_id", ver.VersionID, "acl", acl, "was", ver.ACL)
+}
+
+// GET /:bucket/:key?acl[&versionId=ID]
+func (s *Server) handleGetObjectACL(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	ver, ok := s.versionTarget(w, r, log)
+	if !ok {
+		return
+	}
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(aclToXML(getUserIDFromCtx(r.Context()), ver.ACL, nil))
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// Админский API (/admin/v1/...): JSON, SigV4 как у S3, доступ только role=admin.
+
+const adminPrefix = "/admin/v1/"
+
+type adminError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (s *Server) adminRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminPrefix+"dedup", s.handleAdminDedup)
+	mux.HandleFunc(adminPrefix+"dedup/history", s.handleAdminDedupHistory)
+	mux.HandleFunc(adminPrefix+"usage", s.handleAdminUsage)
+	mux.HandleFunc(adminPrefix+"usage/recount", s.handleAdminUsageRecount)
+	mux.HandleFunc(adminPrefix+"changes", s.handleAdminChanges)
+	mux.HandleFunc(adminPrefix+"buckets/{bucket}/chaos", s.handleAdminBucketChaos)
+	mux.HandleFunc(adminPrefix+"buckets/{bucket}/quota", s.handleAdminBucketQuota)
+	mux.HandleFunc(adminPrefix+"buckets/{bucket}/simulation", s.handleAdminBucketSimulation)
+	mux.HandleFunc(adminPrefix+"buckets/{bucket}/trash", s.handleAdminBucketTrash)
+	mux.HandleFunc(adminPrefix+"buckets/{bucket}/trash/{version}", s.handleAdminTrashedVersion)
+	mux.HandleFunc(adminPrefix+"buckets/{bucket}/trash/{version}/restore", s.handleAdminTrashRestore)
+	mux.HandleFunc(adminPrefix+"jobs", s.handleAdminJobs)
+	mux.HandleFunc(adminPrefix+"jobs/{id}", s.handleAdminJob)
+	mux.HandleFunc(adminPrefix+"jobs/{id}/cancel", s.handleAdminJobCancel)
+	mux.HandleFunc(adminPrefix+"jobs/{id}/failures", s.handleAdminJobFailures)
+	mux.HandleFunc(adminPrefix+"notifications/dead", s.handleAdminDeadNotifications)
+	mux.HandleFunc(adminPrefix+"notifications/dead/requeue", s.handleAdminRequeueNotifications)
+	mux.HandleFunc(adminPrefix+"users", s.handleAdminUsers)
+	mux.HandleFunc(adminPrefix+"users/{id}", s.handleAdminUser)
+	mux.HandleFunc(adminPrefix+"users/{id}/keys", s.handleAdminUserKeys)
+	mux.HandleFunc(adminPrefix+"users/{id}/keys/{key}", s.handleAdminUserKey)
+	mux.HandleFunc(adminPrefix+"users/{id}/keys/{key}/rotate", s.handleAdminUserKeyRotate)
+	mux.HandleFunc(adminPrefix+"read-only", s.handleAdminReadOnly)
+	mux.HandleFunc(adminPrefix+"backup", s.handleAdminBackup)
+	mux.HandleFunc(adminPrefix+"disks", s.handleAdminDisks)
+	return s.requireAdmin(s.withReadOnlyAdmin(mux))
+}
+
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid := getUserIDFromCtx(r.Context())
+		u, err := s.db.FindUserByID(uid)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			loggerFrom(r).Error("admin.user_lookup_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		if u == nil || u.Role != db.RoleAdmin {
+			s.audit.Warn("admin.denied", "user_id", uid, "ip", sourceIP(r), "method", r.Method, "path", r.URL.Path)
+			writeJSONError(w, http.StatusForbidden, "AccessDenied", "admin role required")
+			return
+		}
+		l := loggerFrom(r).With(slog.String("admin", u.AccessKeyID))
+		s.audit.Info("admin.request", "admin", u.AccessKeyID, "method", r.Method, "path", r.URL.Path,
+			"query", r.URL.RawQuery, "req_id", requestIDFrom(r))
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), l)))
+	})
+}
+
+// isAdmin — автор запроса с role=admin (для S3-ручек, доступных и админу).
+func (s *Server) isAdmin(r *http.Request) bool {
+	u, err := s.db.FindUserByID(getUserIDFromCtx(r.Context()))
+	return err == nil && u.Role == db.RoleAdmin
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, adminError{Code: code, Message: msg})
+}
+
+// requireMethod — 405 для всего, кроме перечисленных методов.
+func requireMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	writeJSONError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method")
+	return false
+}
@@ -0,0 +1,422 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/policy"
+)
+
+// Пользователи и ключи доступа (/admin/v1/users). Секрет ключа отдаётся один
+// раз — в ответе на его выпуск; в базе он хранится зашифрованным мастер-ключом.
+
+// как в IAM: не больше двух активных ключей, чтобы ротация шла «выпустить новый —
+// перевести клиентов — отключить старый»
+const maxActiveAccessKeys = 2
+
+type userView struct {
+	ID        uint        `json:"id"`
+	Name      string      `json:"name"` // первый ключ пользователя, Principal в политиках
+	Role      string      `json:"role"`
+	Status    string      `json:"status"`
+	Limits    *userLimits `json:"limits,omitempty"`
+	Quota     *quotaView  `json:"quota,omitempty"` // на все бакеты пользователя
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// userLimits — лимиты на каждый ключ пользователя; отсутствующее поле —
+// значение из конфигурации (KEY_RATE_LIMIT_RPS и т.д.), 0 — без лимита.
+type userLimits struct {
+	RPS      *float64 `json:"rps,omitempty"`
+	Burst    *int     `json:"burst,omitempty"`
+	InFlight *int     `json:"in_flight,omitempty"`
+}
+
+type accessKeyView struct {
+	AccessKeyID     string          `json:"access_key_id"`
+	SecretAccessKey string          `json:"secret_access_key,omitempty"`
+	Status          string          `json:"status"`
+	Policy          json.RawMessage `json:"policy,omitempty"` // только у ключей приложений
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+func viewUser(u *db.User) userView {
+	v := userView{ID: u.ID, Name: u.AccessKeyID, Role: u.Role, Status: u.Status, CreatedAt: u.CreatedAt}
+	if u.RateLimitRPS != nil || u.RateBurst != nil || u.MaxInFlight != nil {
+		v.Limits = &userLimits{RPS: u.RateLimitRPS, Burst: u.RateBurst, InFlight: u.MaxInFlight}
+	}
+	if u.QuotaMaxBytes > 0 || u.QuotaMaxObjects > 0 {
+		v.Quota = &quotaView{MaxBytes: u.QuotaMaxBytes, MaxObjects: u.QuotaMaxObjects}
+	}
+	return v
+}
+
+// newAccessKey — пара в формате AWS: 20 символов ID и 40 символов секрета.
+func newAccessKey() (id, secret string) {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	b := make([]byte, 16+30)
+	_, _ = rand.Read(b)
+	idb := make([]byte, 16)
+	for i := range idb {
+		idb[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return "S3MK" + string(idb), base64.RawStdEncoding.EncodeToString(b[16:])
+}
+
+// GET  /admin/v1/users — список пользователей
+// POST /admin/v1/users {"role":"user|admin"} — пользователь с первым ключом
+func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	log := loggerFrom(r)
+	if r.Method == http.MethodGet {
+		users, err := s.db.ListUsers()
+		if err != nil {
+			log.Error("admin.users.list_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		out := make([]userView, 0, len(users))
+		for i := range users {
+			out = append(out, viewUser(&users[i]))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"users": out})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "MalformedJSON", err.Error())
+		return
+	}
+	switch req.Role {
+	case "":
+		req.Role = db.RoleUser
+	case db.RoleUser, db.RoleAdmin:
+	default:
+		writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "role must be user or admin")
+		return
+	}
+	id, secret := newAccessKey()
+	u, err := s.db.CreateUser(id, secret, req.Role)
+	if err != nil {
+		log.Error("admin.users.create_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	s.audit.Info("admin.users.created", "user_id", u.ID, "role", u.Role, "access_key_id", id)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"user":       viewUser(u),
+		"access_key": accessKeyView{AccessKeyID: id, SecretAccessKey: secret, Status: "active", CreatedAt: u.CreatedAt},
+	})
+}
+
+// GET   /admin/v1/users/{id} — пользователь и его ключи (без секретов)
+// PATCH /admin/v1/users/{id} {"status":"active|disabled","role":"user|admin",
+// "limits":{"rps":10,"burst":20,"in_flight":4},"quota":{"max_bytes":...,"max_objects":...}}
+// — limits и quota заменяются целиком
+func (s *Server) handleAdminUser(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPatch) {
+		return
+	}
+	u, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+	log := loggerFrom(r)
+	if r.Method == http.MethodPatch {
+		var req struct {
+			Status string      `json:"status"`
+			Role   string      `json:"role"`
+			Limits *userLimits `json:"limits"`
+			Quota  *quotaView  `json:"quota"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "MalformedJSON", err.Error())
+			return
+		}
+		fields := map[string]any{}
+		switch req.Status {
+		case "":
+		case "active", "disabled":
+			fields["status"] = req.Status
+		default:
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "status must be active or disabled")
+			return
+		}
+		switch req.Role {
+		case "":
+		case db.RoleUser, db.RoleAdmin:
+			fields["role"] = req.Role
+		default:
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "role must be user or admin")
+			return
+		}
+		if l := req.Limits; l != nil {
+			if (l.RPS != nil && *l.RPS < 0) || (l.Burst != nil && *l.Burst < 0) || (l.InFlight != nil && *l.InFlight < 0) {
+				writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "limits must not be negative")
+				return
+			}
+			fields["rate_limit_rps"], fields["rate_burst"], fields["max_in_flight"] = l.RPS, l.Burst, l.InFlight
+		}
+		if q := req.Quota; q != nil {
+			if q.MaxBytes < 0 || q.MaxObjects < 0 {
+				writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "quota must not be negative")
+				return
+			}
+			fields["quota_max_bytes"], fields["quota_max_objects"] = q.MaxBytes, q.MaxObjects
+		}
+		// без этого админ одним запросом закрывает API самому себе
+		if u.ID == getUserIDFromCtx(r.Context()) && (fields["status"] == "disabled" || fields["role"] == db.RoleUser) {
+			writeJSONError(w, http.StatusConflict, "InvalidOperation", "cannot disable or demote yourself")
+			return
+		}
+		if err := s.db.UpdateUser(u.ID, fields); err != nil {
+			log.Error("admin.users.update_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		s.audit.Info("admin.users.updated", "user_id", u.ID, "status", req.Status, "role", req.Role)
+		if u, ok = s.adminUser(w, r); !ok {
+			return
+		}
+	}
+	keys, ok := s.adminUserKeys(w, r, u.ID)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"user": viewUser(u), "access_keys": keys})
+}
+
+// GET  /admin/v1/users/{id}/keys — ключи пользователя
+// POST /admin/v1/users/{id}/keys — выпустить ещё один ключ; с телом
+// {"policy": {...}} или {"scope": {"buckets": [...], "prefix": "...", "access": "read-only"}}
+// — ключ приложения, ограниченный этой политикой
+func (s *Server) handleAdminUserKeys(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	u, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodGet {
+		keys, ok := s.adminUserKeys(w, r, u.ID)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"access_keys": keys})
+		return
+	}
+	var req struct {
+		Policy json.RawMessage `json:"policy"`
+		Scope  *keyScope       `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "MalformedJSON", err.Error())
+		return
+	}
+	keyPolicy := ""
+	switch {
+	case len(req.Policy) > 0 && req.Scope != nil:
+		writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "policy and scope are mutually exclusive")
+		return
+	case req.Scope != nil:
+		p, err := req.Scope.policy()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+			return
+		}
+		keyPolicy = p
+	case len(req.Policy) > 0:
+		if _, err := policy.ParseIdentity(req.Policy); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "MalformedPolicy", err.Error())
+			return
+		}
+		keyPolicy = string(req.Policy)
+	}
+	s.issueAccessKey(w, r, u, keyPolicy, "")
+}
+
+// POST /admin/v1/users/{id}/keys/{key}/rotate — новый ключ вместо key: старый
+// отключается в той же транзакции, удалить его можно после перевода клиентов.
+func (s *Server) handleAdminUserKeyRotate(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	u, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+	// новый ключ наследует политику старого: ротация не меняет прав приложения
+	old, err := s.db.FindAccessKey(r.PathValue("key"))
+	if errors.Is(err, db.ErrNotFound) || (err == nil && old.UserID != u.ID) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchAccessKey", "access key not found")
+		return
+	}
+	if err != nil {
+		loggerFrom(r).Error("admin.keys.lookup_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	s.issueAccessKey(w, r, u, old.Policy, old.AccessKeyID)
+}
+
+func (s *Server) issueAccessKey(w http.ResponseWriter, r *http.Request, u *db.User, keyPolicy, replace string) {
+	log := loggerFrom(r)
+	if replace == "" && keyPolicy == "" {
+		n, err := s.db.CountActiveAccessKeys(u.ID)
+		if err != nil {
+			log.Error("admin.keys.count_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		if n >= maxActiveAccessKeys {
+			writeJSONError(w, http.StatusConflict, "LimitExceeded",
+				"user already has "+strconv.Itoa(maxActiveAccessKeys)+" active access keys; disable or rotate one")
+			return
+		}
+	}
+	id, secret := newAccessKey()
+	err := s.db.CreateAccessKey(u.ID, id, secret, keyPolicy, replace)
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchAccessKey", "access key not found")
+		return
+	}
+	if err != nil {
+		log.Error("admin.keys.create_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	s.audit.Info("admin.keys.issued", "user_id", u.ID, "access_key_id", id, "replaced", replace, "scoped", keyPolicy != "")
+	writeJSON(w, http.StatusCreated, accessKeyView{AccessKeyID: id, SecretAccessKey: secret, Status: "active",
+		Policy: rawPolicy(keyPolicy), CreatedAt: time.Now().UTC()})
+}
+
+// PATCH  /admin/v1/users/{id}/keys/{key} {"status":"active|disabled"}
+// DELETE /admin/v1/users/{id}/keys/{key} — только отключённый ключ
+func (s *Server) handleAdminUserKey(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+	u, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+	log := loggerFrom(r)
+	keyID := r.PathValue("key")
+	keys, ok := s.adminUserKeys(w, r, u.ID)
+	if !ok {
+		return
+	}
+	var key *accessKeyView
+	for i := range keys {
+		if keys[i].AccessKeyID == keyID {
+			key = &keys[i]
+		}
+	}
+	if key == nil {
+		writeJSONError(w, http.StatusNotFound, "NoSuchAccessKey", "access key not found")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if key.Status == "active" {
+			writeJSONError(w, http.StatusConflict, "InvalidOperation", "disable the access key before deleting it")
+			return
+		}
+		if err := s.db.DeleteAccessKey(u.ID, keyID); err != nil {
+			log.Error("admin.keys.delete_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		s.audit.Info("admin.keys.deleted", "user_id", u.ID, "access_key_id", keyID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "MalformedJSON", err.Error())
+		return
+	}
+	switch req.Status {
+	case "active":
+		if key.Status != "active" && len(key.Policy) == 0 {
+			n, err := s.db.CountActiveAccessKeys(u.ID)
+			if err != nil {
+				log.Error("admin.keys.count_fail", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+				return
+			}
+			if n >= maxActiveAccessKeys {
+				writeJSONError(w, http.StatusConflict, "LimitExceeded", "too many active access keys")
+				return
+			}
+		}
+	case "disabled":
+	default:
+		writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "status must be active or disabled")
+		return
+	}
+	if err := s.db.SetAccessKeyStatus(u.ID, keyID, req.Status); err != nil {
+		log.Error("admin.keys.update_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	s.audit.Info("admin.keys.updated", "user_id", u.ID, "access_key_id", keyID, "status", req.Status)
+	key.Status = req.Status
+	writeJSON(w, http.StatusOK, key)
+}
+
+func (s *Server) adminUser(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "NoSuchUser", "user not found")
+		return nil, false
+	}
+	u, err := s.db.FindUserByID(uint(id))
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchUser", "user not found")
+		return nil, false
+	}
+	if err != nil {
+		loggerFrom(r).Error("admin.users.lookup_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return nil, false
+	}
+	return u, true
+}
+
+func (s *Server) adminUserKeys(w http.ResponseWriter, r *http.Request, userID uint) ([]accessKeyView, bool) {
+	keys, err := s.db.ListAccessKeys(userID)
+	if err != nil {
+		loggerFrom(r).Error("admin.keys.list_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return nil, false
+	}
+	out := make([]accessKeyView, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, accessKeyView{AccessKeyID: k.AccessKeyID, Status: k.Status, Policy: rawPolicy(k.Policy), CreatedAt: k.CreatedAt})
+	}
+	return out, true
+}
+
+func rawPolicy(p string) json.RawMessage {
+	if p == "" {
+		return nil
+	}
+	return json.RawMessage(p)
+}
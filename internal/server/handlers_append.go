@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/DanikLP1/s3-storage-service/internal/auth"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+// потолок кусков в append-объекте: каждый append пишет новый manifest со всеми
+// кусками, так что N append'ов — O(N²) строк blob_chunks; дальше клиент должен
+// переписать объект целиком
+const maxAppendChunks = 1000
+
+const hdrNextAppendPosition = "x-s3mini-next-append-position"
+
+// POST /:bucket/:key?append&position=N
+// Дописывает тело в конец объекта. position обязан совпадать с текущей длиной
+// (0 для нового ключа); новая версия — manifest из кусков старой + новый кусок.
+// ETag, как у PUT, — md5 всего тела: старые байты читаются до блокировки, и
+// под ней проверяется, что HEAD за это время не сменился.
+func (s *Server) handleAppend(w http.ResponseWriter, r *http.Request) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("key", key))
+	log.Info("append_object.start")
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	position, err := strconv.ParseInt(r.URL.Query().Get("position"), 10, 64)
+	if err != nil || position < 0 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "position must be a non-negative integer", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if !s.limitBody(w, r, s.maxPutSize()) || !s.checkDiskSpace(w, r, r.ContentLength) {
+		return
+	}
+
+	bkt, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("append_object.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if !checkUploadPolicy(w, r, bkt) {
+		log.Warn("append_object.upload_policy_denied")
+		return
+	}
+
+	reqEncCtx, ok := checkPutEncryptionContext(w, r)
+	if !ok {
+		return
+	}
+
+	// дешёвая проверка позиции до чтения тела; окончательная — под локом
+	base, err := s.appendHead(bkt.ID, key)
+	if err != nil {
+		log.Error("append_object.head_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if cur := appendLength(base); cur != position {
+		s.writePositionMismatch(w, r, cur)
+		return
+	}
+
+	md5c, ok := newMD5Check(w, r)
+	if !ok {
+		return
+	}
+	ck, ok := newChecksumCheck(w, r)
+	if !ok {
+		return
+	}
+	// md5 всего тела: старые байты, затем тело запроса по мере записи куска
+	md5h := md5.New()
+	if base != nil && appendLength(base) > 0 {
+		if err := s.hashBody(r, md5h, *base.BlobID); err != nil {
+			log.Error("append_object.read_base_fail", "version_id", base.VersionID, "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "read error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
+	up, err := s.stageUpload(r.Context(), io.TeeReader(ck.wrap(r.Body), md5h), r.ContentLength, uploadOpts{})
+	if err != nil {
+		if writeChunkedBodyError(w, r, err) {
+			log.Warn("append_object.bad_chunked_body", "err", err)
+			return
+		}
+		if writeTooLargeError(w, r, err) {
+			return
+		}
+		log.Error("append_object.write_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "write error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// кусок проверяется, как тело PUT, до того как попасть в объект
+	if r.ContentLength >= 0 && up.Size != r.ContentLength {
+		log.Warn("append_object.bad_length", "got", up.Size, "want", r.ContentLength)
+		s.discardStaged(r.Context(), up, false)
+		writeS3Error(w, http.StatusBadRequest, "BadDigest", "mismatched content length", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if want := r.Header.Get("x-amz-content-sha256"); want != "" && want != up.SHA256 && want != "UNSIGNED-PAYLOAD" && !auth.IsStreamingPayload(want) {
+		log.Warn("append_object.bad_sha256", "want", want, "got", up.SHA256)
+		s.discardStaged(r.Context(), up, false)
+		writeS3Error(w, http.StatusBadRequest, "BadDigest", "sha256 mismatch", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if !md5c.matches(up) {
+		log.Warn("append_object.bad_md5", "content_md5", r.Header.Get("Content-MD5"))
+		s.discardStaged(r.Context(), up, false)
+		writeS3Error(w, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if !ck.verify(w, r) {
+		log.Warn("append_object.bad_checksum", "algorithm", ck.Alg)
+		s.discardStaged(r.Context(), up, false)
+		return
+	}
+	st := up.Parts[0]
+
+	var (
+		verID, etag string
+		next        int64
+		mismatch    = int64(-1)
+		changed     bool
+		tooMany     bool
+		ctxDenied   bool
+		usedNew     bool
+	)
+	err = s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		if err := s.db.LockObjectForUpdate(tx, bkt.ID, key); err != nil {
+			return err
+		}
+		head, err := s.resolveVersionTx(tx, bkt.ID, key, "")
+		exists := err == nil
+		if err != nil && !errors.Is(err, db.ErrNotFound) && !errors.Is(err, errIsDeleteMarker) {
+			return err
+		}
+		var cur int64
+		if exists {
+			cur = coalesce(head.Size, 0)
+		}
+		if cur != position {
+			mismatch = cur
+			return nil
+		}
+		// md5 посчитан по той HEAD, что была до чтения тела
+		if exists != (base != nil) || exists && head.VersionID != base.VersionID {
+			changed = true
+			return nil
+		}
+		// append наследует контекст шифрования HEAD и требует его же в запросе
+		encCtx := reqEncCtx
+		if exists {
+			encCtx = coalesce(head.EncryptionContext, "")
+			if encCtx != reqEncCtx {
+				ctxDenied = true
+				return nil
+			}
+		}
+		// пустой append к существующему объекту — ничего не меняем
+		if exists && st.Size == 0 {
+			verID, etag, next = head.VersionID, coalesce(head.ETag, ""), cur
+			return nil
+		}
+
+		var chunks []db.ChunkRange
+		if exists {
+			if chunks, err = s.db.ResolveRangeTx(tx, *head.BlobID, 0, -1); err != nil {
+				return err
+			}
+			if len(chunks) >= maxAppendChunks {
+				tooMany = true
+				return nil
+			}
+		}
+
+		newID, newSize, isNew, err := s.adoptBlobTx(r.Context(), tx, st)
+		if err != nil {
+			return err
+		}
+		usedNew = isNew
+
+		ctype := r.Header.Get("Content-Type")
+		blobID, size := newID, newSize
+		if exists {
+			chunks = append(chunks, db.ChunkRange{BlobID: newID, Offset: 0, Size: newSize})
+			blobID, size, _, err = s.db.CreateManifestBlobTx(tx, chunks)
+			if err != nil {
+				return err
+			}
+			if ctype == "" {
+				ctype = coalesce(head.ContentType, "")
+			}
+		}
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		etag = `"` + hex.EncodeToString(md5h.Sum(nil)) + `"`
+		verID, err = s.commitVersionTx(tx, bkt.ID, key, blobID, size, etag, ctype)
+		if err != nil {
+			return err
+		}
+		if encCtx != "" {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"encryption_context": encCtx}); err != nil {
+				return err
+			}
+		}
+		next = size
+		log.Info("append_object.committed", "blob_id", blobID, "appended", newSize, "size", size)
+		return nil
+	})
+	if err != nil || mismatch >= 0 || changed || tooMany || ctxDenied || !usedNew {
+		// staged-кусок не стал блобом (или txn откатилась) — убираем байты
+		_ = s.storage.Delete(r.Context(), st.ID)
+	}
+	if err != nil {
+		if writeCommitError(w, r, err) {
+			return
+		}
+		log.Error("append_object.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if mismatch >= 0 {
+		s.writePositionMismatch(w, r, mismatch)
+		return
+	}
+	if changed {
+		writeS3Error(w, http.StatusConflict, "OperationAborted",
+			"object was replaced while appending; retry", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if ctxDenied {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied",
+			"encryption context does not match the one the object was stored with", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if tooMany {
+		writeS3Error(w, http.StatusConflict, "ObjectNotAppendable",
+			"object has too many appended segments; rewrite it with PUT", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("x-amz-version-id", apiVersionID(verID))
+	w.Header().Set(hdrNextAppendPosition, strconv.FormatInt(next, 10))
+	w.WriteHeader(http.StatusOK)
+	log.Info("append_object.ok", "version_id", verID, "next_position", next)
+}
+
+// appendHead — HEAD ключа, к которой пойдёт append (nil, если ключа нет или
+// HEAD — delete-marker).
+func (s *Server) appendHead(bucketID uint, key string) (*db.ObjectVersion, error) {
+	var head *db.ObjectVersion
+	err := s.db.WithTx(func(tx *gorm.DB) error {
+		var err error
+		head, err = s.resolveVersionTx(tx, bucketID, key, "")
+		if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+			head = nil
+			return nil
+		}
+		return err
+	})
+	return head, err
+}
+
+// appendLength — текущая длина объекта для append.
+func appendLength(head *db.ObjectVersion) int64 {
+	if head == nil {
+		return 0
+	}
+	return coalesce(head.Size, 0)
+}
+
+// hashBody дописывает в h байты блоба целиком.
+func (s *Server) hashBody(r *http.Request, h io.Writer, blobID string) error {
+	rc, err := s.openBlob(r.Context(), blobID, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(h, rc)
+	return err
+}
+
+func (s *Server) writePositionMismatch(w http.ResponseWriter, r *http.Request, cur int64) {
+	w.Header().Set(hdrNextAppendPosition, strconv.FormatInt(cur, 10))
+	writeS3Error(w, http.StatusConflict, "PositionNotEqualToLength",
+		"position does not match the current object length", r.URL.Path, requestIDFrom(r))
+}
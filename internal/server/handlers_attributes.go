@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// атрибуты, которые можно запросить через x-amz-object-attributes
+var objectAttributeNames = map[string]bool{
+	"ETag": true, "Checksum": true, "ObjectParts": true, "StorageClass": true, "ObjectSize": true,
+}
+
+type objectAttributesChecksum struct {
+	ChecksumCRC32     string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C    string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumCRC64NVME string `xml:"ChecksumCRC64NVME,omitempty"`
+	ChecksumSHA1      string `xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256    string `xml:"ChecksumSHA256,omitempty"`
+}
+
+type objectAttributesParts struct {
+	TotalPartsCount int `xml:"TotalPartsCount"`
+}
+
+type getObjectAttributesResponse struct {
+	XMLName      xml.Name                  `xml:"GetObjectAttributesResponse"`
+	Xmlns        string                    `xml:"xmlns,attr"`
+	ETag         string                    `xml:"ETag,omitempty"`
+	Checksum     *objectAttributesChecksum `xml:"Checksum,omitempty"`
+	ObjectParts  *objectAttributesParts    `xml:"ObjectParts,omitempty"`
+	StorageClass string                    `xml:"StorageClass,omitempty"`
+	ObjectSize   *int64                    `xml:"ObjectSize,omitempty"`
+}
+
+// GET /:bucket/:key?attributes[&versionId=ID]
+func (s *Server) handleGetObjectAttributes(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+
+	want := map[string]bool{}
+	for _, v := range r.Header.Values("x-amz-object-attributes") {
+		for _, a := range strings.Split(v, ",") {
+			a = strings.TrimSpace(a)
+			if a == "" {
+				continue
+			}
+			if !objectAttributeNames[a] {
+				writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Invalid attribute name specified.", r.URL.Path, requestIDFrom(r))
+				return
+			}
+			want[a] = true
+		}
+	}
+	if len(want) == 0 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "The x-amz-object-attributes header specifying the attributes to be retrieved is either missing or empty", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	ver, ok := s.versionTarget(w, r, log)
+	if !ok {
+		return
+	}
+
+	resp := getObjectAttributesResponse{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/"}
+	etag := stripQuotes(coalesce(ver.ETag, ""))
+	if want["ETag"] {
+		resp.ETag = etag
+	}
+	if want["Checksum"] && ver.ChecksumValue != "" {
+		c := &objectAttributesChecksum{}
+		switch ver.ChecksumAlgorithm {
+		case "CRC32":
+			c.ChecksumCRC32 = ver.ChecksumValue
+		case "CRC32C":
+			c.ChecksumCRC32C = ver.ChecksumValue
+		case "CRC64NVME":
+			c.ChecksumCRC64NVME = ver.ChecksumValue
+		case "SHA1":
+			c.ChecksumSHA1 = ver.ChecksumValue
+		case "SHA256":
+			c.ChecksumSHA256 = ver.ChecksumValue
+		}
+		resp.Checksum = c
+	}
+	// число частей известно только у multipart-объектов: ETag вида "<md5>-N"
+	if want["ObjectParts"] {
+		if i := strings.LastIndexByte(etag, '-'); i >= 0 {
+			if n, err := strconv.Atoi(etag[i+1:]); err == nil {
+				resp.ObjectParts = &objectAttributesParts{TotalPartsCount: n}
+			}
+		}
+	}
+	if want["StorageClass"] {
+		resp.StorageClass = ver.StorageClass
+		if resp.StorageClass == "" {
+			resp.StorageClass = storageClassStandard
+		}
+	}
+	if want["ObjectSize"] {
+		resp.ObjectSize = ver.Size
+	}
+
+	w.Header().Set("Last-Modified", versionLastModified(ver).Format(http.TimeFormat))
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(resp)
+	log.Info("object_attributes.get.ok", "version_id", ver.VersionID, "attributes", len(want))
+}
@@ -1,11 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"encoding/xml"
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/DanikLP1/s3-storage-service/internal/db"
 	"gorm.io/gorm"
@@ -42,6 +47,27 @@ func (s *Server) handleListBuckets(w http.ResponseWriter, r *http.Request) {
 	log.Info("list_buckets.ok", "count", len(out))
 }
 
+// reservedBucketNames — первые сегменты служебных маршрутов: у бакета с таким
+// именем часть путей перекрыли бы /internal/v1/, /admin/v1/, /metrics и пробы.
+var reservedBucketNames = map[string]bool{
+	"internal": true, "admin": true, "metrics": true, "healthz": true, "readyz": true,
+}
+
+// bucketNameAvailable — запись (PUT/POST) не создаёт бакет с зарезервированным
+// именем ни явно, ни неявно при загрузке объекта. Уже существующий бакет с таким
+// именем (созданный до резервирования) остаётся доступным.
+func (s *Server) bucketNameAvailable(w http.ResponseWriter, r *http.Request, bucket string) bool {
+	if !reservedBucketNames[bucket] {
+		return true
+	}
+	if _, err := s.db.FindBucketByName(bucket); !errors.Is(err, db.ErrNotFound) {
+		return true
+	}
+	loggerFrom(r).Warn("bucket.reserved_name", "bucket", bucket)
+	writeS3Error(w, http.StatusBadRequest, "InvalidBucketName", "The specified bucket name is reserved.", r.URL.Path, requestIDFrom(r))
+	return false
+}
+
 // PUT /:bucket  -> создать, если нет
 func (s *Server) handlePutBucket(w http.ResponseWriter, r *http.Request, bucket string) {
 	log := loggerFrom(r).With(slog.String("bucket", bucket))
@@ -56,6 +82,19 @@ func (s *Server) handlePutBucket(w http.ResponseWriter, r *http.Request, bucket
 
 	ownerID := getUserIDFromCtx(r.Context())
 
+	profile := r.Header.Get(hdrBucketProfile)
+	if _, ok := bucketProfiles[profile]; profile != "" && !ok {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "unknown bucket profile "+profile, r.URL.Path, requestIDFrom(r))
+		return
+	}
+	acl, ok := parseACLHeader(r.Header)
+	if !ok {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "unsupported canned ACL "+r.Header.Get(hdrACL), r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	lockEnabled := strings.EqualFold(r.Header.Get(hdrBucketLockEnabled), "true")
+
 	id, err := s.db.EnsureBucket(bucket, ownerID)
 	if err != nil {
 		// Важный момент: сюда уже не прилетит ErrRecordNotFound — FirstOrCreate сам создаст
@@ -63,6 +102,50 @@ func (s *Server) handlePutBucket(w http.ResponseWriter, r *http.Request, bucket
 		writeS3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path, requestIDFrom(r))
 		return
 	}
+	// имена бакетов общие на всех: чужое имя не занять и не перенастроить
+	if b, err := s.db.FindBucketByID(id); err == nil && b.OwnerID != ownerID {
+		log.Warn("create_bucket.owned_by_other")
+		writeS3Error(w, http.StatusConflict, "BucketAlreadyExists",
+			"The requested bucket name is not available. The bucket namespace is shared by all users of the system.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if profile != "" {
+		// профиль задаётся один раз — при создании; повторный PUT его не меняет
+		b, err := s.db.FindBucketByID(id)
+		if err == nil && b.Profile == "" {
+			err = s.applyBucketProfile(id, profile)
+			log.Info("create_bucket.profile_applied", "profile", profile)
+		}
+		if err != nil {
+			log.Error("create_bucket.profile_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket profile error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
+	if acl != "" {
+		// EnsureBucket находит бакет по имени; ACL меняем только у своего
+		b, err := s.db.FindBucketByID(id)
+		if err == nil && b.OwnerID == ownerID {
+			err = s.db.UpdateBucketSettings(id, map[string]any{"acl": acl})
+		}
+		if err != nil {
+			log.Error("create_bucket.acl_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
+	if lockEnabled {
+		// Object Lock включается и при создании; версионирование у нового бакета и так Enabled
+		b, err := s.db.FindBucketByID(id)
+		if err == nil && b.OwnerID == ownerID && b.Versioning == db.VersioningEnabled {
+			err = s.db.UpdateBucketSettings(id, map[string]any{"object_lock_enabled": true})
+		}
+		if err != nil {
+			log.Error("create_bucket.object_lock_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
 	// идемпотентный успех
 	w.Header().Set("Location", "/"+bucket)
 	w.Header().Set("Content-Type", "application/xml")
@@ -78,7 +161,7 @@ func (s *Server) handleDeleteBucket(w http.ResponseWriter, r *http.Request, buck
 
 	if bucket == "" {
 		log.Warn("delete_bucket.invalid_name")
-		http.Error(w, "empty bucket name", http.StatusBadRequest)
+		writeS3Error(w, http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.", r.URL.Path, requestIDFrom(r))
 		return
 	}
 
@@ -112,14 +195,61 @@ func (s *Server) handleDeleteBucket(w http.ResponseWriter, r *http.Request, buck
 		)
 		return
 	}
-	log.Error("delete_bucket.db_fail_delete", "err", err)
-	writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
-	return
+	if err != nil {
+		log.Error("delete_bucket.db_fail_delete", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
 
+	s.scripts.Delete(bucketID)
 	w.WriteHeader(http.StatusNoContent) // 204, без тела
 	log.Info("delete_bucket.ok", "bucket_id", bucketID)
 }
 
+// HEAD /:bucket  -> 200 свой, 403 чужой, 404 нет такого
+func (s *Server) handleHeadBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, err := s.db.FindBucketByName(bucket)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Error("head_bucket.db_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// регион отдаём и на 403: по нему SDK понимают, куда идти
+	w.Header().Set("x-amz-bucket-region", s.cfg.Region)
+	if b.OwnerID != getUserIDFromCtx(r.Context()) {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GET /:bucket?location
+func (s *Server) handleGetBucketLocation(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	_, err := s.db.BucketIDByName(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Error("bucket_location.db_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	region := s.cfg.Region
+	if region == "us-east-1" {
+		region = ""
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(LocationConstraint{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/", Region: region})
+}
+
 // ----------------- Bucket Lifecycles -------------------------
 
 func (s *Server) handlePutBucketLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
@@ -139,19 +269,104 @@ func (s *Server) handlePutBucketLifecycle(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	body, err := io.ReadAll(io.LimitReader(r.Body, 256<<10))
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", "cannot read request body", r.URL.Path, requestIDFrom(r))
+		return
+	}
 	var cfg LifecycleConfiguration
-	if err := xml.NewDecoder(r.Body).Decode(&cfg); err != nil {
+	var raw rawLifecycleRules
+	if err := xml.Unmarshal(body, &cfg); err != nil {
 		log.Warn("lifecycle.put.bad_xml", "err", err)
 		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse lifecycle xml", r.URL.Path, requestIDFrom(r))
 		return
 	}
+	if err := xml.Unmarshal(body, &raw); err != nil || len(raw.Rules) != len(cfg.Rules) {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse lifecycle xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if len(cfg.Rules) == 0 || len(cfg.Rules) > 1000 {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "lifecycle configuration must have 1..1000 rules", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	ids := map[string]bool{}
+	for _, xr := range cfg.Rules {
+		if xr.Status != "Enabled" && xr.Status != "Disabled" {
+			writeS3Error(w, http.StatusBadRequest, "MalformedXML", "Status must be Enabled or Disabled", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if len(xr.ID) > 255 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "ID length should not exceed allowed limit of 255", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if xr.ID != "" && ids[xr.ID] {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Rule ID must be unique. Found same ID for more than one rule", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		ids[xr.ID] = true
+		if e := xr.Expiration; e != nil {
+			if e.Days != nil && e.Date != "" {
+				writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Expiration can have either Days or Date, not both", r.URL.Path, requestIDFrom(r))
+				return
+			}
+			if e.Days != nil && *e.Days <= 0 {
+				writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "'Days' for Expiration action must be a positive integer", r.URL.Path, requestIDFrom(r))
+				return
+			}
+			if e.Date != "" {
+				d, err := time.Parse(time.RFC3339, e.Date)
+				if err != nil || !d.UTC().Equal(d.UTC().Truncate(24*time.Hour)) {
+					writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "'Date' must be at midnight GMT", r.URL.Path, requestIDFrom(r))
+					return
+				}
+			}
+		}
+		_, tags, gt, lt := xr.Filter.conditions()
+		kv := make([][2]string, 0, len(tags))
+		for _, t := range tags {
+			kv = append(kv, [2]string{t.Key, t.Value})
+		}
+		if _, err := validateTags(kv, maxObjectTags); err != nil {
+			writeS3Error(w, http.StatusBadRequest, "InvalidTag", err.Error(), r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if (gt != nil && *gt < 0) || (lt != nil && *lt <= 0) || (gt != nil && lt != nil && *gt >= *lt) {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument",
+				"ObjectSizeGreaterThan must be non-negative and less than ObjectSizeLessThan", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if len(tags) > 0 && xr.AbortIncompleteMultipartUpload != nil {
+			writeS3Error(w, http.StatusBadRequest, "InvalidRequest",
+				"AbortIncompleteMultipartUpload cannot be specified with Tags.", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		tr := xr.Transition
+		if tr == nil {
+			continue
+		}
+		if tr.StorageClass == storageClassStandard || !storageClasses[tr.StorageClass] {
+			log.Warn("lifecycle.put.bad_transition_class", "class", tr.StorageClass)
+			writeInvalidStorageClass(w, r)
+			return
+		}
+		if tr.Days == nil || *tr.Days < 0 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Transition requires a non-negative Days", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if !s.storage.HasNode(storageNodeTier) {
+			log.Warn("lifecycle.put.no_tier")
+			writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "Transitions are not supported: no storage tier is configured", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
 
 	if err := s.db.WithTx(func(tx *gorm.DB) error {
 		if err := tx.Where("bucket_id = ?", bucketID).Delete(&db.LifecycleRule{}).Error; err != nil {
 			return err
 		}
-		for _, xr := range cfg.Rules {
+		for i, xr := range cfg.Rules {
 			rule := ruleFromXML(bucketID, xr)
+			rule.RuleXML = "<Rule>" + raw.Rules[i].Inner + "</Rule>"
 			if err := tx.Create(&rule).Error; err != nil {
 				return err
 			}
@@ -198,17 +413,27 @@ func (s *Server) handleGetBucketLifecycle(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	cfg := LifecycleConfiguration{Rules: make([]Rule, 0, len(rules))}
-	for _, r := range rules {
-		cfg.Rules = append(cfg.Rules, ruleToXML(r))
+	// правила отдаются в порядке PUT; сохранённый <Rule> — как есть, правила без
+	// него (пресеты профилей, старые записи) собираются из полей
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	var out bytes.Buffer
+	out.WriteString(`<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	for _, rule := range rules {
+		if rule.RuleXML != "" {
+			out.WriteString(rule.RuleXML)
+			continue
+		}
+		if err := xml.NewEncoder(&out).Encode(ruleToXML(rule)); err != nil {
+			log.Error("lifecycle.get.encode_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "Can't write response to XML", r.URL.Path, requestIDFrom(r))
+			return
+		}
 	}
+	out.WriteString(`</LifecycleConfiguration>`)
 
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
-	if err := xml.NewEncoder(w).Encode(cfg); err != nil {
-		log.Error("lifecycle.get.encode_fail", "err", err)
-		writeS3Error(w, http.StatusInternalServerError, "InternalError", "Can't write response to XML", r.URL.Path, requestIDFrom(r))
-	}
+	_, _ = w.Write(out.Bytes())
 	log.Info("lifecycle.get.ok", "rules", len(rules))
 }
 
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// GET /:bucket?settings
+func (s *Server) handleGetBucketSettings(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	log.Info("settings.get.start")
+
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		log.Warn("settings.get.no_such_bucket")
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Error("settings.get.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(bucketSettingsToXML(b))
+	log.Info("settings.get.ok")
+}
+
+// PUT /:bucket?settings
+func (s *Server) handlePutBucketSettings(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	log.Info("settings.put.start")
+
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		log.Warn("settings.put.no_such_bucket")
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Error("settings.put.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	var x BucketSettings
+	if err := xml.NewDecoder(r.Body).Decode(&x); err != nil {
+		log.Warn("settings.put.bad_xml", "err", err)
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse settings xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if p := x.Protection; p != nil {
+		// WORM: окно можно только расширить; сократить — только админ
+		if p.Days < 0 || p.Days > 36500 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "protection days must be 0..36500", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if p.Days < b.ProtectionDays && !s.isAdmin(r) {
+			log.Warn("settings.put.protection_decrease_denied", "from", b.ProtectionDays, "to", p.Days)
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "protection window can only be extended", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
+	if t := x.Trash; t != nil && (t.Days < 0 || t.Days > 3650) {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "trash days must be 0..3650", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if ipf := x.IPFilter; ipf != nil {
+		for _, c := range append(append([]string{}, ipf.Allow...), ipf.Deny...) {
+			if _, err := parseCIDR(c); err != nil {
+				writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "invalid IP or CIDR: "+c, r.URL.Path, requestIDFrom(r))
+				return
+			}
+		}
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, bucketSettingsFields(x)); err != nil {
+		log.Error("settings.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("settings.put.ok")
+}
+
+// checkUploadPolicy проверяет требования бакета к загрузке по одним заголовкам —
+// до того, как тело будет прочитано. ok=false => ответ уже записан.
+func checkUploadPolicy(w http.ResponseWriter, r *http.Request, b *db.Bucket) bool {
+	if b.RejectUnsignedPayload {
+		sha := r.Header.Get("x-amz-content-sha256")
+		if sha == "" || strings.HasPrefix(sha, "UNSIGNED-PAYLOAD") || strings.HasPrefix(sha, "STREAMING-UNSIGNED-PAYLOAD") {
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "bucket policy requires a signed payload", r.URL.Path, requestIDFrom(r))
+			return false
+		}
+	}
+	if b.RequireContentChecksum && !hasContentChecksum(r.Header) {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "bucket policy requires Content-MD5 or x-amz-checksum-* header", r.URL.Path, requestIDFrom(r))
+		return false
+	}
+	if b.RequireSSE {
+		switch r.Header.Get("x-amz-server-side-encryption") {
+		case "AES256", "aws:kms", "aws:kms:dsse":
+		default:
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "bucket policy requires x-amz-server-side-encryption", r.URL.Path, requestIDFrom(r))
+			return false
+		}
+	}
+	return true
+}
+
+func hasContentChecksum(h http.Header) bool {
+	if h.Get("Content-MD5") != "" {
+		return true
+	}
+	for alg := range checksumAlgorithms {
+		if h.Get(checksumHeaderName(alg)) != "" {
+			return true
+		}
+	}
+	// трейлерная контрольная сумма (aws-chunked)
+	return strings.HasPrefix(strings.ToLower(h.Get("x-amz-trailer")), "x-amz-checksum-")
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+// максимум источников в одном compose (как у GCS)
+const maxComposeSources = 32
+
+// POST /:bucket/:key?compose
+func (s *Server) handleCompose(w http.ResponseWriter, r *http.Request) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("key", key))
+	log.Info("compose.start")
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	bucketID, err := s.db.BucketIDByName(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("compose.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	// контекст шифрования запроса: нужен для источников, сохранённых с контекстом
+	reqEncCtx, _ := parseEncryptionContext(r.Header)
+
+	var req ComposeRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Warn("compose.bad_xml", "err", err)
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse compose xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if len(req.Sources) == 0 || len(req.Sources) > maxComposeSources {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest",
+			fmt.Sprintf("compose needs 1..%d sources", maxComposeSources), r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	// обработчик читает источники от имени владельца — каждый должен быть
+	// открыт на чтение тому, кто прислал запрос
+	b, err := s.db.FindBucketByName(bucket)
+	if err != nil {
+		log.Error("compose.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	for _, src := range req.Sources {
+		if !s.canReadSource(r, b, src.Key, src.VersionId) {
+			log.Info("compose.source_denied", "source", src.Key)
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "access to the compose source is denied: "+src.Key, r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
+
+	// ошибки источников — клиентские; отдаём их после txn
+	type srcErr struct {
+		status    int
+		code, msg string
+	}
+	var badSrc *srcErr
+	var verID, etag string
+	var size int64
+	var lastMod time.Time
+
+	err = s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		var chunks []db.ChunkRange
+		ctype := req.ContentType
+		for _, src := range req.Sources {
+			ver, err := s.resolveVersionTx(tx, bucketID, src.Key, src.VersionId)
+			if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+				badSrc = &srcErr{http.StatusNotFound, "NoSuchKey", "compose source does not exist: " + src.Key}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if ec := coalesce(ver.EncryptionContext, ""); ec != "" && ec != reqEncCtx {
+				badSrc = &srcErr{http.StatusForbidden, "AccessDenied", "encryption context does not match source " + src.Key}
+				return nil
+			}
+			srcSize := coalesce(ver.Size, 0)
+			var off, n int64 = 0, srcSize
+			if src.Range != "" {
+				st, ln, err := parseRange(src.Range, srcSize)
+				if err != nil || ln < 0 {
+					badSrc = &srcErr{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "bad range for source " + src.Key}
+					return nil
+				}
+				off, n = st, ln
+			}
+			parts, err := s.db.ResolveRangeTx(tx, *ver.BlobID, off, n)
+			if err != nil {
+				return err
+			}
+			chunks = append(chunks, parts...)
+			if ctype == "" {
+				ctype = coalesce(ver.ContentType, "")
+			}
+		}
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+
+		if err := s.db.LockObjectForUpdate(tx, bucketID, key); err != nil {
+			return err
+		}
+		blobID, sz, checksum, err := s.db.CreateManifestBlobTx(tx, chunks)
+		if err != nil {
+			return err
+		}
+		size = sz
+		etag = `"` + checksum + `"`
+		verID, err = s.commitVersionTx(tx, bucketID, key, blobID, size, etag, ctype)
+		if err != nil {
+			return err
+		}
+		nv, err := s.db.GetVersionTx(tx, verID)
+		if err != nil {
+			return err
+		}
+		lastMod = versionLastModified(nv)
+		log.Info("compose.committed", "blob_id", blobID, "chunks", len(chunks), "size", size)
+		return nil
+	})
+	if err != nil {
+		if writeCommitError(w, r, err) {
+			return
+		}
+		log.Error("compose.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if badSrc != nil {
+		log.Warn("compose.bad_source", "msg", badSrc.msg)
+		writeS3Error(w, badSrc.status, badSrc.code, badSrc.msg, r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("x-amz-version-id", apiVersionID(verID))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(ComposeObjectResult{
+		ETag:         etag,
+		LastModified: lastMod.Format(timeRFC3339),
+		Size:         size,
+	})
+	log.Info("compose.ok", "version_id", verID, "sources", len(req.Sources))
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+const hdrCopySource = "x-amz-copy-source"
+
+// hdrMove — расширение s3mini: CopyObject с "x-s3mini-move: true" переименовывает
+// объект — копия и delete-marker источника в одной транзакции, байты не копируются.
+const hdrMove = "x-s3mini-move"
+
+func isMoveRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(hdrMove), "true")
+}
+
+// parseCopySource разбирает x-amz-copy-source: "[/]bucket/key[?versionId=...]",
+// ключ URL-кодирован (знак '?' в ключе приходит как %3F).
+func parseCopySource(v string) (bucket, key, versionID string, err error) {
+	path, query, _ := strings.Cut(v, "?")
+	p, err := url.PathUnescape(path)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid copy source encoding")
+	}
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", "", fmt.Errorf("copy source must be /bucket/key")
+	}
+	if query != "" {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid copy source query")
+		}
+		versionID = q.Get("versionId")
+	}
+	return bucket, key, versionID, nil
+}
+
+// validCopySourceRange — x-amz-copy-source-range допускает только bytes=first-last.
+func validCopySourceRange(v string) bool {
+	a, z, ok := strings.Cut(strings.TrimPrefix(v, "bytes="), "-")
+	if !ok || !strings.HasPrefix(v, "bytes=") {
+		return false
+	}
+	first, err1 := strconv.ParseInt(a, 10, 64)
+	last, err2 := strconv.ParseInt(z, 10, 64)
+	return err1 == nil && err2 == nil && first >= 0 && last >= first
+}
+
+// copyRangeEnd — last из уже проверенного validCopySourceRange диапазона.
+func copyRangeEnd(v string) int64 {
+	_, z, _ := strings.Cut(v, "-")
+	n, _ := strconv.ParseInt(z, 10, 64)
+	return n
+}
+
+// checkCopySourceConditions — x-amz-copy-source-if-* против ETag и Last-Modified
+// источника; false — 412. Как в S3, совпавший if-match отменяет проверку
+// if-unmodified-since, а if-modified-since смотрится только без if-none-match.
+// Неразбираемые даты игнорируются.
+func checkCopySourceConditions(h http.Header, etag string, lastMod time.Time) bool {
+	if v := h.Get("x-amz-copy-source-if-match"); v != "" {
+		if !etagListMatches(v, etag) {
+			return false
+		}
+	} else if t, err := http.ParseTime(h.Get("x-amz-copy-source-if-unmodified-since")); err == nil && lastMod.After(t) {
+		return false
+	}
+	if v := h.Get("x-amz-copy-source-if-none-match"); v != "" {
+		if etagListMatches(v, etag) {
+			return false
+		}
+	} else if t, err := http.ParseTime(h.Get("x-amz-copy-source-if-modified-since")); err == nil && !lastMod.After(t) {
+		return false
+	}
+	return true
+}
+
+// PUT /:bucket/:key с x-amz-copy-source — серверное копирование: новая версия
+// ссылается на блоб источника, байты не читаются и не пишутся.
+func (s *Server) handleCopyObject(w http.ResponseWriter, r *http.Request) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("key", key))
+	log.Info("copy_object.start")
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	srcBucket, srcKey, srcVersionID, err := parseCopySource(r.Header.Get(hdrCopySource))
+	if err != nil {
+		log.Warn("copy_object.bad_source", "err", err)
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	log = log.With(slog.String("src_bucket", srcBucket), slog.String("src_key", srcKey))
+
+	directive := r.Header.Get("x-amz-metadata-directive")
+	switch directive {
+	case "", "COPY", "REPLACE":
+	default:
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument",
+			"x-amz-metadata-directive must be COPY or REPLACE", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	tagDirective := r.Header.Get("x-amz-tagging-directive")
+	switch tagDirective {
+	case "", "COPY", "REPLACE":
+	default:
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument",
+			"x-amz-tagging-directive must be COPY or REPLACE", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	tags, err := parseTaggingHeader(r.Header)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidTag", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// ACL источника не копируется, как в S3: копия private, если не задан x-amz-acl
+	acl, ok := parseACLHeader(r.Header)
+	if !ok {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "unsupported canned ACL "+r.Header.Get(hdrACL), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	class, ok := parseStorageClassHeader(r.Header)
+	if !ok {
+		writeInvalidStorageClass(w, r)
+		return
+	}
+	move := isMoveRequest(r)
+	if move && srcVersionID != "" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "A move request cannot specify a source versionId.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if move && srcBucket == bucket && srcKey == key {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "A move request cannot target its own source.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// копия «в себя» допустима, если меняются метаданные или класс хранения
+	if srcBucket == bucket && srcKey == key && srcVersionID == "" && directive != "REPLACE" && class == "" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest",
+			"This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata.",
+			r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	ownerID := getUserIDFromCtx(r.Context())
+	srcBucketID, err := s.db.BucketIDByName(srcBucket, ownerID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+srcBucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("copy_object.src_bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	bucketID, err := s.db.EnsureBucket(bucket, ownerID)
+	if err != nil {
+		log.Error("copy_object.ensure_bucket_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	bkt, err := s.db.FindBucketByID(bucketID)
+	if err != nil {
+		log.Error("copy_object.bucket_load_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// Object Lock источника не копируется: у копии свой срок или DefaultRetention
+	lock, code, msg := parseLockHeaders(r.Header, bkt, time.Now())
+	if code != "" {
+		writeS3Error(w, http.StatusBadRequest, code, msg, r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// тела нет: требования к подписи тела и checksum здесь не применимы
+	pb := *bkt
+	pb.RejectUnsignedPayload, pb.RequireContentChecksum = false, false
+	if !checkUploadPolicy(w, r, &pb) {
+		log.Warn("copy_object.upload_policy_denied")
+		return
+	}
+	// контекст запроса открывает источник с контекстом, он же ложится на копию
+	encCtx, ok := checkPutEncryptionContext(w, r)
+	if !ok {
+		return
+	}
+	// REPLACE: x-amz-meta-* берутся из запроса (их отсутствие стирает метаданные источника)
+	meta, ok := checkPutUserMetadata(w, r)
+	if !ok {
+		return
+	}
+
+	type srcErr struct {
+		status    int
+		code, msg string
+	}
+	var badSrc *srcErr
+	var verID, etag, fromVersion string
+	var lastMod time.Time
+	var moved delResult
+	err = s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		ver, err := s.resolveVersionTx(tx, srcBucketID, srcKey, srcVersionID)
+		switch {
+		case errors.Is(err, db.ErrNotFound) && srcVersionID != "":
+			badSrc = &srcErr{http.StatusNotFound, "NoSuchVersion", "The specified version does not exist."}
+			return nil
+		case errors.Is(err, errIsDeleteMarker) && srcVersionID != "":
+			// явная ссылка на delete-marker — как в S3
+			badSrc = &srcErr{http.StatusBadRequest, "InvalidRequest", "The source of a copy request may not specifically refer to a delete marker by version id."}
+			return nil
+		case errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker):
+			badSrc = &srcErr{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+			return nil
+		case err != nil:
+			return err
+		}
+		if ec := coalesce(ver.EncryptionContext, ""); ec != "" && ec != encCtx {
+			badSrc = &srcErr{http.StatusForbidden, "AccessDenied", "encryption context does not match the copy source"}
+			return nil
+		}
+		if archived, err := s.versionArchivedTx(tx, ver); err != nil {
+			return err
+		} else if archived {
+			badSrc = &srcErr{http.StatusForbidden, "InvalidObjectState", "Operation is not valid for the source object's storage class"}
+			return nil
+		}
+		if !checkCopySourceConditions(r.Header, coalesce(ver.ETag, ""), versionLastModified(ver)) {
+			badSrc = &srcErr{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"}
+			return nil
+		}
+
+		ctype := coalesce(ver.ContentType, "")
+		if directive == "REPLACE" {
+			ctype = r.Header.Get("Content-Type")
+		}
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		if err := s.db.LockObjectForUpdate(tx, bucketID, key); err != nil {
+			return err
+		}
+		verID, err = s.copyVersionTx(tx, ver, bucketID, key, ctype, encCtx)
+		if err != nil {
+			return err
+		}
+		if directive == "REPLACE" {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"user_metadata": userMetadataField(meta)}); err != nil {
+				return err
+			}
+		}
+		if tagDirective == "REPLACE" {
+			if err := s.db.SetVersionTagsTx(tx, verID, tags); err != nil {
+				return err
+			}
+		}
+		if acl != "" {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"acl": acl}); err != nil {
+				return err
+			}
+		}
+		// класс источника не наследуется: без заголовка копия — STANDARD, как в S3
+		if class != "" && class != storageClassStandard {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"storage_class": class}); err != nil {
+				return err
+			}
+		}
+		if err := s.db.UpdateVersionFieldsTx(tx, verID, lock.fields()); err != nil {
+			return err
+		}
+		if move {
+			// без versionId удаление — всегда delete-marker (или снос ключа при Disabled),
+			// окно защиты и Object Lock его не останавливают
+			moved, err = s.deleteObjectTx(r.Context(), tx, log, srcBucketID, srcKey, "", false)
+			if err != nil {
+				return err
+			}
+		}
+		nv, err := s.db.GetVersionTx(tx, verID)
+		if err != nil {
+			return err
+		}
+		lastMod = versionLastModified(nv)
+		etag, fromVersion = coalesce(ver.ETag, ""), ver.VersionID
+		log.Info("copy_object.committed", "blob_id", *ver.BlobID, "size", coalesce(ver.Size, 0))
+		return nil
+	})
+	if err != nil {
+		if writeCommitError(w, r, err) {
+			return
+		}
+		log.Error("copy_object.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if badSrc != nil {
+		log.Warn("copy_object.bad_source", "code", badSrc.code)
+		writeS3Error(w, badSrc.status, badSrc.code, badSrc.msg, r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	if move {
+		s.runPostDeleteHooks(r, DeleteInfo{Bucket: srcBucket, Key: srcKey, VersionID: moved.returnVersion, DeleteMarker: moved.marker})
+		log.Info("copy_object.moved", "src_delete_marker", moved.returnVersion)
+	}
+	w.Header().Set("x-amz-version-id", apiVersionID(verID))
+	w.Header().Set("x-amz-copy-source-version-id", apiVersionID(fromVersion))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(CopyObjectResult{
+		ETag:         etag,
+		LastModified: lastMod.Format(timeRFC3339),
+	})
+	log.Info("copy_object.ok", "version_id", verID, "src_version_id", fromVersion)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Межузловой API (/internal/v1/...). Аутентификация — подпись узла
+// секретом кластера (cluster.Verifier), SigV4 здесь не используется.
+
+const internalPrefix = "/internal/v1/"
+
+func (s *Server) internalRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(internalPrefix+"blobs/", s.handleInternalBlob)
+	return s.requireNodeAuth(mux)
+}
+
+func (s *Server) requireNodeAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nodeID, err := s.nodeAuth.Verify(r)
+		if err != nil {
+			s.audit.Warn("node_auth.failure", "ip", sourceIP(r), "node_id", r.Header.Get("X-S3mini-Node-Id"),
+				"path", r.URL.Path, "err", err)
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "node authentication failed", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		l := loggerFrom(r).With(slog.String("peer_node", nodeID))
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), l)))
+	})
+}
+
+// GET|HEAD /internal/v1/blobs/{id} — чтение блоба узлом-пиром (peer read, репликация).
+func (s *Server) handleInternalBlob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, internalPrefix+"blobs/")
+	log := loggerFrom(r).With(slog.String("blob_id", id))
+	if id == "" || strings.Contains(id, "/") {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "bad blob id", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	b, err := s.db.GetBlob(id)
+	if err != nil {
+		log.Warn("internal_blob.not_found", "err", err)
+		writeS3Error(w, http.StatusNotFound, "NoSuchBlob", "blob not found", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("X-S3mini-Checksum", b.Checksum)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	var start, length int64 = 0, -1
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "bytes=") {
+		st, ln, err := parseRange(rng, b.Size)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if ln >= 0 {
+			start, length, status = st, ln, http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, b.Size))
+		}
+	}
+	if length < 0 {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", b.Size))
+	} else {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+
+	rc, err := s.openBlob(r.Context(), id, start, length)
+	if err != nil {
+		log.Error("internal_blob.read_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "read error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	defer rc.Close()
+	w.WriteHeader(status)
+	n, _ := io.Copy(w, rc)
+	log.Info("internal_blob.served", "bytes", n, "status", status)
+}
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"encoding/base64"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -19,12 +18,11 @@ func (s *Server) handleListObjectsV2(w http.ResponseWriter, r *http.Request, buc
 	if ct != "" {
 		// токен главнее start-after
 		startAfter = ""
-		// быстрая валидация токена (прежде чем идти в repo)
-		if _, err := base64.RawURLEncoding.DecodeString(ct); err != nil {
-			log.Warn("list_objects_v2.invalid_continuation_token")
-			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is invalid.", r.URL.Path, requestIDFrom(r))
-			return
-		}
+	}
+
+	encType, ok := parseEncodingType(w, r)
+	if !ok {
+		return
 	}
 
 	// delimiter — ровно один символ (как в AWS)
@@ -59,6 +57,15 @@ func (s *Server) handleListObjectsV2(w http.ResponseWriter, r *http.Request, buc
 	}
 
 	// 2) params
+	var cursor *db.ListV2Cursor
+	if ct != "" {
+		// токен выдан для этого бакета и тех же prefix/delimiter
+		if cursor, err = s.decodeListToken(bucket, ct, q.Get("prefix"), delim); err != nil {
+			log.Warn("list_objects_v2.invalid_continuation_token")
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
 	maxKeys := 1000
 	if v := q.Get("max-keys"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
@@ -71,29 +78,27 @@ func (s *Server) handleListObjectsV2(w http.ResponseWriter, r *http.Request, buc
 		Delimiter:    delim,
 		MaxKeys:      maxKeys,
 		StartAfter:   startAfter, // уже с учётом игнора при токене
-		ContTokenRaw: ct,         // передаём токен в repo
+		ContTokenRaw: ct,
+		Cursor:       cursor,
 		FetchOwner:   q.Get("fetch-owner") == "true",
-		EncodingType: q.Get("encoding-type"),
+		EncodingType: encType,
 	}
 
 	// 3) repo
 	res, err := s.db.ListObjectsV2(r.Context(), params)
 	if err != nil {
-		if errors.Is(err, db.ErrInvalidContToken) {
-			log.Warn("list_objects_v2.invalid_continuation_token_repo")
-			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is invalid.", r.URL.Path, requestIDFrom(r))
-			return
-		}
 		log.Error("list_objects_v2.db_fail_list", "err", err)
 		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", "/"+bucket, requestIDFrom(r))
 		return
 	}
 
+	if res.Next != nil {
+		res.NextToken = s.encodeListToken(bucket, params, res.Next)
+	}
+
 	// 4) ответ
 	xmlRes := toListV2XML(bucket, params, res)
 
-	// x-amz-request-id для удобства отладки
-	w.Header().Set("x-amz-request-id", requestIDFrom(r))
 	writeListObjectsV2(w, xmlRes)
 
 	log.Info("list_objects_v2.ok",
@@ -106,29 +111,31 @@ func (s *Server) handleListObjectsV2(w http.ResponseWriter, r *http.Request, buc
 const timeRFC3339 = "2006-01-02T15:04:05Z"
 
 func toListV2XML(bucket string, p db.ListV2Params, res *db.ListV2Result) ListBucketResultV2 {
+	enc := p.EncodingType
 	out := ListBucketResultV2{
 		Name:                  bucket,
-		Prefix:                p.Prefix,
-		Delimiter:             p.Delimiter,
+		Prefix:                listKey(enc, p.Prefix),
+		Delimiter:             listKey(enc, p.Delimiter),
 		MaxKeys:               p.MaxKeys,
 		EncodingType:          p.EncodingType,
 		IsTruncated:           res.IsTruncated,
 		KeyCount:              res.KeyCount,
 		ContinuationToken:     p.ContTokenRaw,
 		NextContinuationToken: res.NextToken,
-		StartAfter:            p.StartAfter,
+		StartAfter:            listKey(enc, p.StartAfter),
 	}
 	for _, cp := range res.CommonPrefixes {
-		out.CommonPrefixes = append(out.CommonPrefixes, CommonPrefix{Prefix: cp})
+		out.CommonPrefixes = append(out.CommonPrefixes, CommonPrefix{Prefix: listKey(enc, cp)})
 	}
 	for _, it := range res.Objects {
 		obj := ListV2ObjectXML{
-			Key:          it.Key,
+			Key:          listKey(enc, it.Key),
 			LastModified: it.LastModified.UTC().Format(timeRFC3339),
 			Size:         it.Size,
+			StorageClass: it.StorageClass,
 		}
 		if it.ETag != nil && *it.ETag != "" {
-			obj.ETag = `"` + *it.ETag + `"`
+			obj.ETag = `"` + stripQuotes(*it.ETag) + `"` // в БД ETag уже в кавычках
 		}
 		if p.FetchOwner && it.OwnerID != nil {
 			obj.Owner = &ListV2OwnerXML{ID: *it.OwnerID, DisplayName: coalesce(it.OwnerName, "")}
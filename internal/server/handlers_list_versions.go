@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// GET /:bucket?versions — ListObjectVersions: все версии и delete-marker'ы.
+func (s *Server) handleListObjectVersions(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	q := r.URL.Query()
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	keyMarker, verMarker := q.Get("key-marker"), q.Get("version-id-marker")
+	if verMarker != "" && keyMarker == "" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "A version-id marker cannot be specified without a key marker.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	encType, ok := parseEncodingType(w, r)
+	if !ok {
+		return
+	}
+	if len(delim) > 1 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "delimiter must be a single character", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	maxKeys := 1000
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		maxKeys = min(n, 1000)
+	}
+	log.Info("list_versions.start", "prefix", prefix, "delimiter", delim, "key_marker", keyMarker, "version_id_marker", verMarker)
+
+	bucketID, err := s.db.BucketIDByName(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("list_versions.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	// позиция: после version-id-marker внутри key-marker или после всего key-marker
+	var after *db.ObjectVersion
+	if verMarker != "" {
+		after, err = s.resolveVersionTx(s.db.DB, bucketID, keyMarker, verMarker)
+		if errors.Is(err, db.ErrNotFound) {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Invalid version id specified", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if err != nil && !errors.Is(err, errIsDeleteMarker) {
+			log.Error("list_versions.marker_lookup_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
+
+	res := ListVersionsResult{
+		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:  bucket, Prefix: listKey(encType, prefix), Delimiter: listKey(encType, delim), MaxKeys: maxKeys,
+		KeyMarker: listKey(encType, keyMarker), VersionIdMarker: verMarker, EncodingType: encType,
+	}
+	// как в ListMultipartUploads: с delimiter версии схлопываются в CommonPrefix,
+	// поэтому читаем пачками, пока не наберём max-keys элементов (+1 — узнать про усечение)
+	const batch = 1000
+	curKey := keyMarker
+	lastCP := ""
+	count := 0
+scan:
+	for {
+		rows, err := s.db.ListObjectVersions(bucketID, prefix, curKey, after, batch)
+		if err != nil {
+			log.Error("list_versions.db_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		for i := range rows {
+			v := &rows[i]
+			curKey, after = v.Key, &v.ObjectVersion
+			if !strings.HasPrefix(v.Key, prefix) {
+				continue // LIKE понимает _ и % как шаблоны
+			}
+			if delim != "" {
+				if idx := strings.Index(v.Key[len(prefix):], delim); idx >= 0 {
+					cp := v.Key[:len(prefix)+idx+1]
+					if cp == lastCP || cp == keyMarker {
+						continue
+					}
+					if count == maxKeys {
+						res.IsTruncated = true
+						break scan
+					}
+					res.CommonPrefixes = append(res.CommonPrefixes, CommonPrefix{Prefix: listKey(encType, cp)})
+					res.NextKeyMarker, res.NextVersionIdMarker = cp, ""
+					lastCP = cp
+					count++
+					continue
+				}
+			}
+			if count == maxKeys {
+				res.IsTruncated = true
+				break scan
+			}
+			res.Entries = append(res.Entries, versionEntryXML(v, encType))
+			res.NextKeyMarker, res.NextVersionIdMarker = v.Key, apiVersionID(v.VersionID)
+			count++
+		}
+		if len(rows) < batch {
+			break
+		}
+	}
+	if !res.IsTruncated {
+		res.NextKeyMarker, res.NextVersionIdMarker = "", ""
+	}
+	res.NextKeyMarker = listKey(encType, res.NextKeyMarker)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(res)
+	log.Info("list_versions.ok", "entries", len(res.Entries), "prefixes", len(res.CommonPrefixes), "truncated", res.IsTruncated)
+}
+
+func versionEntryXML(v *db.VersionListItem, encodingType string) ObjectVersionXML {
+	e := ObjectVersionXML{
+		Key: listKey(encodingType, v.Key), VersionId: apiVersionID(v.VersionID), IsLatest: v.IsLatest,
+		LastModified: v.CreatedAt.UTC().Format(timeRFC3339),
+	}
+	if v.IsDelete || v.BlobID == nil {
+		e.XMLName.Local = "DeleteMarker"
+		return e
+	}
+	e.XMLName.Local = "Version"
+	if etag := coalesce(v.ETag, ""); etag != "" {
+		e.ETag = `"` + stripQuotes(etag) + `"`
+	}
+	size := coalesce(v.Size, 0)
+	e.Size = &size
+	e.StorageClass = v.StorageClass
+	if e.StorageClass == "" {
+		e.StorageClass = storageClassStandard
+	}
+	return e
+}
@@ -0,0 +1,765 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/auth"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+// Multipart upload: части хранятся отдельными блобами (с дедупом, как тело PUT),
+// Complete собирает из них manifest-блоб — байты не копируются.
+
+const (
+	maxPartNumber = 10000
+	minPartSize   = 5 << 20 // все части, кроме последней (как в S3)
+)
+
+// POST /:bucket/:key?uploads
+func (s *Server) handleInitiateMultipart(w http.ResponseWriter, r *http.Request) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("key", key))
+	log.Info("mpu_initiate.start")
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	ownerID := getUserIDFromCtx(r.Context())
+	bucketID, err := s.db.EnsureBucket(bucket, ownerID)
+	if err != nil {
+		log.Error("mpu_initiate.ensure_bucket_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	bkt, err := s.db.FindBucketByID(bucketID)
+	if err != nil {
+		log.Error("mpu_initiate.bucket_load_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// тела здесь нет: требования к подписи тела и checksum проверяются на частях
+	pb := *bkt
+	pb.RejectUnsignedPayload, pb.RequireContentChecksum = false, false
+	if !checkUploadPolicy(w, r, &pb) {
+		log.Warn("mpu_initiate.upload_policy_denied")
+		return
+	}
+	encCtx, ok := checkPutEncryptionContext(w, r)
+	if !ok {
+		return
+	}
+	meta, ok := checkPutUserMetadata(w, r)
+	if !ok {
+		return
+	}
+	acl, ok := parseACLHeader(r.Header)
+	if !ok {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "unsupported canned ACL "+r.Header.Get(hdrACL), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	class, ok := parseStorageClassHeader(r.Header)
+	if !ok {
+		writeInvalidStorageClass(w, r)
+		return
+	}
+	if class == "" {
+		class = storageClassStandard
+	}
+
+	ctype := r.Header.Get("Content-Type")
+	if bkt.DetectContentType && needsSniff(ctype) {
+		ctype = detectContentType(key, nil)
+	}
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	u := &db.MultipartUpload{
+		UploadID: s.db.GenVersionID(), BucketID: bucketID, Key: key,
+		ContentType: ctype, ACL: acl, StorageClass: class, InitiatorID: ownerID,
+	}
+	if encCtx != "" {
+		u.EncryptionContext = &encCtx
+	}
+	if meta != "" {
+		u.UserMetadata = &meta
+	}
+	if err := s.db.CreateMultipartUpload(u); err != nil {
+		log.Error("mpu_initiate.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(InitiateMultipartUploadResult{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket: bucket, Key: key, UploadId: u.UploadID,
+	})
+	log.Info("mpu_initiate.ok", "upload_id", u.UploadID)
+}
+
+// multipartTarget — бакет, ключ и загрузка из запроса ?uploadId. ok=false => ответ уже записан.
+func (s *Server) multipartTarget(w http.ResponseWriter, r *http.Request, log *slog.Logger) (*db.Bucket, string, *db.MultipartUpload, bool) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return nil, "", nil, false
+	}
+	bucketID, err := s.db.BucketIDByName(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, "", nil, false
+	}
+	if err != nil {
+		log.Error("mpu.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, "", nil, false
+	}
+	bkt, err := s.db.FindBucketByID(bucketID)
+	if err != nil {
+		log.Error("mpu.bucket_load_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, "", nil, false
+	}
+	u, err := s.db.GetMultipartUploadTx(s.db.DB, r.URL.Query().Get("uploadId"))
+	if errors.Is(err, db.ErrNotFound) || (err == nil && (u.BucketID != bucketID || u.Key != key)) {
+		writeNoSuchUpload(w, r)
+		return nil, "", nil, false
+	}
+	if err != nil {
+		log.Error("mpu.upload_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, "", nil, false
+	}
+	return bkt, key, u, true
+}
+
+func parsePartNumber(w http.ResponseWriter, r *http.Request) (int, bool) {
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > maxPartNumber {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument",
+			fmt.Sprintf("partNumber must be an integer between 1 and %d", maxPartNumber), r.URL.Path, requestIDFrom(r))
+		return 0, false
+	}
+	return n, true
+}
+
+// partDigest — байты хэша из ETag части для ETag объекта. У частей из PUT это
+// sha256 содержимого, у скопированных диапазонов — хэш состава manifest-блоба.
+func partDigest(etag string) []byte {
+	v := stripQuotes(etag)
+	if i := strings.LastIndexByte(v, ':'); i >= 0 {
+		v = v[i+1:]
+	}
+	if sum, err := hex.DecodeString(v); err == nil {
+		return sum
+	}
+	sum := sha256.Sum256([]byte(v))
+	return sum[:]
+}
+
+func writeNoSuchUpload(w http.ResponseWriter, r *http.Request) {
+	writeS3Error(w, http.StatusNotFound, "NoSuchUpload",
+		"The specified multipart upload does not exist.", r.URL.Path, requestIDFrom(r))
+}
+
+// PUT /:bucket/:key?partNumber=N&uploadId=ID
+func (s *Server) handleUploadPart(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	partNumber, ok := parsePartNumber(w, r)
+	if !ok {
+		return
+	}
+	if !s.limitBody(w, r, s.maxPutSize()) || !s.checkDiskSpace(w, r, r.ContentLength) {
+		return
+	}
+	bkt, _, u, ok := s.multipartTarget(w, r, log)
+	if !ok {
+		return
+	}
+	log = log.With(slog.String("upload_id", u.UploadID), slog.Int("part", partNumber))
+	log.Info("mpu_part.start")
+
+	// SSE задаётся при создании загрузки, на частях его нет
+	pb := *bkt
+	pb.RequireSSE = false
+	if !checkUploadPolicy(w, r, &pb) {
+		log.Warn("mpu_part.upload_policy_denied")
+		return
+	}
+	md5c, ok := newMD5Check(w, r)
+	if !ok {
+		return
+	}
+	ck, ok := newChecksumCheck(w, r)
+	if !ok {
+		return
+	}
+
+	up, err := s.stageUpload(r.Context(), ck.wrap(r.Body), r.ContentLength, s.uploadOptsFor(bkt))
+	if err != nil {
+		if writeChunkedBodyError(w, r, err) {
+			log.Warn("mpu_part.bad_chunked_body", "err", err)
+			return
+		}
+		if writeTooLargeError(w, r, err) {
+			return
+		}
+		log.Error("mpu_part.write_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "write error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if r.ContentLength >= 0 && up.Size != r.ContentLength {
+		s.discardStaged(r.Context(), up, false)
+		writeS3Error(w, http.StatusBadRequest, "BadDigest", "mismatched content length", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if want := r.Header.Get("x-amz-content-sha256"); want != "" && want != up.SHA256 && want != "UNSIGNED-PAYLOAD" && !auth.IsStreamingPayload(want) {
+		log.Warn("mpu_part.bad_sha256", "want", want, "got", up.SHA256)
+		s.discardStaged(r.Context(), up, false)
+		writeS3Error(w, http.StatusBadRequest, "BadDigest", "sha256 mismatch", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if !md5c.matches(up) {
+		log.Warn("mpu_part.bad_md5", "content_md5", r.Header.Get("Content-MD5"))
+		s.discardStaged(r.Context(), up, false)
+		writeS3Error(w, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// контрольная сумма части только проверяется: составную сумму объекта не храним
+	if !ck.verify(w, r) {
+		log.Warn("mpu_part.bad_checksum", "algorithm", ck.Alg)
+		s.discardStaged(r.Context(), up, false)
+		return
+	}
+	etag := up.ETag()
+
+	var gone bool
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		// загрузку могли завершить или отменить, пока шло тело
+		if _, err := s.db.GetMultipartUploadTx(tx, u.UploadID); errors.Is(err, db.ErrNotFound) {
+			gone = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+		blobID, size, err := s.adoptUploadTx(r.Context(), tx, up)
+		if err != nil {
+			return err
+		}
+		return s.db.PutMultipartPartTx(tx, &db.MultipartPart{
+			UploadID: u.UploadID, PartNumber: partNumber, BlobID: blobID, Size: size, ETag: etag,
+		})
+	}); err != nil {
+		s.discardStaged(r.Context(), up, false)
+		log.Error("mpu_part.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if gone {
+		s.discardStaged(r.Context(), up, false)
+		writeNoSuchUpload(w, r)
+		return
+	}
+	s.discardStaged(r.Context(), up, true)
+
+	w.Header().Set("ETag", etag)
+	if ck != nil {
+		setChecksumHeader(w, ck.Alg, ck.Value())
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("mpu_part.ok", "size", up.Size)
+}
+
+// PUT /:bucket/:key?partNumber=N&uploadId=ID с x-amz-copy-source — часть из
+// существующего объекта. Без x-amz-copy-source-range частью становится сам блоб
+// источника, с ним — manifest-блоб поверх нужных кусков; байты не копируются.
+func (s *Server) handleUploadPartCopy(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	partNumber, ok := parsePartNumber(w, r)
+	if !ok {
+		return
+	}
+	bkt, _, u, ok := s.multipartTarget(w, r, log)
+	if !ok {
+		return
+	}
+	log = log.With(slog.String("upload_id", u.UploadID), slog.Int("part", partNumber))
+	log.Info("mpu_part_copy.start")
+
+	srcBucket, srcKey, srcVersionID, err := parseCopySource(r.Header.Get(hdrCopySource))
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	srcRange := r.Header.Get("x-amz-copy-source-range")
+	if srcRange != "" && !validCopySourceRange(srcRange) {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument",
+			"x-amz-copy-source-range must be bytes=first-last", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	srcBucketID, err := s.db.BucketIDByName(srcBucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+srcBucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("mpu_part_copy.src_bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	// тела нет, SSE задан при создании загрузки
+	pb := *bkt
+	pb.RejectUnsignedPayload, pb.RequireContentChecksum, pb.RequireSSE = false, false, false
+	if !checkUploadPolicy(w, r, &pb) {
+		log.Warn("mpu_part_copy.upload_policy_denied")
+		return
+	}
+	reqEncCtx, _ := parseEncryptionContext(r.Header)
+
+	type srcErr struct {
+		status    int
+		code, msg string
+	}
+	var badSrc *srcErr
+	var gone bool
+	var etag, fromVersion string
+	var size int64
+	var part *db.MultipartPart
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		if _, err := s.db.GetMultipartUploadTx(tx, u.UploadID); errors.Is(err, db.ErrNotFound) {
+			gone = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+		ver, err := s.resolveVersionTx(tx, srcBucketID, srcKey, srcVersionID)
+		if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+			badSrc = &srcErr{http.StatusNotFound, "NoSuchKey", "The specified copy source does not exist."}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ec := coalesce(ver.EncryptionContext, ""); ec != "" && ec != reqEncCtx {
+			badSrc = &srcErr{http.StatusForbidden, "AccessDenied", "encryption context does not match the copy source"}
+			return nil
+		}
+		if !checkCopySourceConditions(r.Header, coalesce(ver.ETag, ""), versionLastModified(ver)) {
+			badSrc = &srcErr{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"}
+			return nil
+		}
+		fromVersion = ver.VersionID
+
+		blobID, srcSize := *ver.BlobID, coalesce(ver.Size, 0)
+		size, etag = srcSize, coalesce(ver.ETag, "")
+		if srcRange != "" {
+			off, n, err := parseRange(srcRange, srcSize)
+			if err != nil || n < 0 || off+n-1 != copyRangeEnd(srcRange) {
+				badSrc = &srcErr{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable"}
+				return nil
+			}
+			chunks, err := s.db.ResolveRangeTx(tx, blobID, off, n)
+			if err != nil {
+				return err
+			}
+			var checksum string
+			blobID, size, checksum, err = s.db.CreateManifestBlobTx(tx, chunks)
+			if err != nil {
+				return err
+			}
+			etag = `"` + checksum + `"`
+		}
+		part = &db.MultipartPart{
+			UploadID: u.UploadID, PartNumber: partNumber, BlobID: blobID, Size: size, ETag: etag,
+		}
+		return s.db.PutMultipartPartTx(tx, part)
+	}); err != nil {
+		log.Error("mpu_part_copy.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if gone {
+		writeNoSuchUpload(w, r)
+		return
+	}
+	if badSrc != nil {
+		log.Warn("mpu_part_copy.bad_source", "code", badSrc.code)
+		writeS3Error(w, badSrc.status, badSrc.code, badSrc.msg, r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	w.Header().Set("x-amz-copy-source-version-id", apiVersionID(fromVersion))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(CopyPartResult{
+		ETag:         etag,
+		LastModified: part.UpdatedAt.UTC().Format(timeRFC3339),
+	})
+	log.Info("mpu_part_copy.ok", "size", size, "src_version_id", fromVersion)
+}
+
+// POST /:bucket/:key?uploadId=ID
+func (s *Server) handleCompleteMultipart(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	bkt, key, u, ok := s.multipartTarget(w, r, log)
+	if !ok {
+		return
+	}
+	log = log.With(slog.String("upload_id", u.UploadID))
+	log.Info("mpu_complete.start")
+
+	var req CompleteMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse complete multipart upload xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	// 1) сверка списка клиента с загруженными частями — вне транзакции
+	stored, err := s.db.ListMultipartPartsTx(s.db.DB, u.UploadID)
+	if err != nil {
+		log.Error("mpu_complete.parts_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	byNum := make(map[int]db.MultipartPart, len(stored))
+	for _, p := range stored {
+		byNum[p.PartNumber] = p
+	}
+	parts := make([]db.MultipartPart, 0, len(req.Parts))
+	var total int64
+	for i := 1; i < len(req.Parts); i++ {
+		if req.Parts[i].PartNumber <= req.Parts[i-1].PartNumber {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPartOrder",
+				"The list of parts was not in ascending order.", r.URL.Path, requestIDFrom(r))
+			return
+		}
+	}
+	for i, cp := range req.Parts {
+		p, ok := byNum[cp.PartNumber]
+		if !ok || stripQuotes(cp.ETag) != stripQuotes(p.ETag) {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart",
+				fmt.Sprintf("part %d was not found or its ETag does not match", cp.PartNumber), r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if i < len(req.Parts)-1 && p.Size < minPartSize {
+			writeS3Error(w, http.StatusBadRequest, "EntityTooSmall",
+				fmt.Sprintf("part %d is smaller than the minimum allowed size", cp.PartNumber), r.URL.Path, requestIDFrom(r))
+			return
+		}
+		parts = append(parts, p)
+		total += p.Size
+	}
+
+	var chunks []db.ChunkRange
+	for _, p := range parts {
+		cr, err := s.db.ResolveRangeTx(s.db.DB, p.BlobID, 0, -1)
+		if err != nil {
+			log.Error("mpu_complete.resolve_fail", "part", p.PartNumber, "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		chunks = append(chunks, cr...)
+	}
+
+	if s.hasPrePutHooks() {
+		err := s.runPrePutHooks(r, PutInfo{
+			Bucket: bkt.Name, Key: key, Size: total, ContentType: u.ContentType,
+			Open: func() (io.ReadCloser, error) { return &chunkReader{ctx: r.Context(), s: s, chunks: chunks}, nil },
+		})
+		if err != nil {
+			log.Warn("mpu_complete.hook_rejected", "err", err)
+			writeHookError(w, r, err)
+			return
+		}
+	}
+
+	// ETag как у S3: md5 от склеенных md5 частей и их число
+	h := md5.New()
+	for _, p := range parts {
+		h.Write(partDigest(p.ETag))
+	}
+	etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(h.Sum(nil)), len(parts))
+
+	// 2) транзакция: части не должны были смениться с момента сверки
+	var verID string
+	var gone, changed bool
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		if err := s.db.LockObjectForUpdate(tx, u.BucketID, key); err != nil {
+			return err
+		}
+		cur, err := s.db.ListMultipartPartsTx(tx, u.UploadID)
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.GetMultipartUploadTx(tx, u.UploadID); errors.Is(err, db.ErrNotFound) {
+			gone = true // параллельный Complete или Abort
+			return nil
+		} else if err != nil {
+			return err
+		}
+		curBlob := make(map[int]string, len(cur))
+		for _, p := range cur {
+			curBlob[p.PartNumber] = p.BlobID
+		}
+		for _, p := range parts {
+			if curBlob[p.PartNumber] != p.BlobID {
+				changed = true // часть перезагрузили во время Complete
+				return nil
+			}
+		}
+		blobID, size, _, err := s.db.CreateManifestBlobTx(tx, chunks)
+		if err != nil {
+			return err
+		}
+		verID, err = s.commitVersionTx(tx, u.BucketID, key, blobID, size, etag, u.ContentType)
+		if err != nil {
+			return err
+		}
+		if ec := coalesce(u.EncryptionContext, ""); ec != "" {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"encryption_context": ec}); err != nil {
+				return err
+			}
+		}
+		if u.UserMetadata != nil {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"user_metadata": *u.UserMetadata}); err != nil {
+				return err
+			}
+		}
+		if u.ACL != "" && u.ACL != db.ACLPrivate {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"acl": u.ACL}); err != nil {
+				return err
+			}
+		}
+		if u.StorageClass != "" && u.StorageClass != storageClassStandard {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"storage_class": u.StorageClass}); err != nil {
+				return err
+			}
+		}
+		// неупомянутые в списке части осиротеют и уйдут в GC
+		return s.db.DeleteMultipartUploadTx(tx, u.UploadID)
+	}); err != nil {
+		if writeCommitError(w, r, err) {
+			return
+		}
+		log.Error("mpu_complete.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if gone {
+		writeNoSuchUpload(w, r)
+		return
+	}
+	if changed {
+		writeS3Error(w, http.StatusBadRequest, "InvalidPart", "a part was replaced during completion", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	w.Header().Set("x-amz-version-id", apiVersionID(verID))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(CompleteMultipartUploadResult{
+		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
+		Location: "/" + bkt.Name + "/" + key,
+		Bucket:   bkt.Name, Key: key, ETag: etag,
+	})
+	log.Info("mpu_complete.ok", "version_id", verID, "parts", len(parts), "size", total)
+}
+
+// DELETE /:bucket/:key?uploadId=ID
+func (s *Server) handleAbortMultipart(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	_, _, u, ok := s.multipartTarget(w, r, log)
+	if !ok {
+		return
+	}
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		return s.db.DeleteMultipartUploadTx(tx, u.UploadID)
+	}); err != nil {
+		log.Error("mpu_abort.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("mpu_abort.ok", "upload_id", u.UploadID)
+}
+
+func initiatorXML(u *db.MultipartUpload) MultipartInitiatorXML {
+	// как и в ListBuckets: ID пользователя, имя — заглушка
+	return MultipartInitiatorXML{ID: strconv.FormatUint(uint64(u.InitiatorID), 10), DisplayName: "local"}
+}
+
+// GET /:bucket/:key?uploadId=ID[&part-number-marker=&max-parts=]
+func (s *Server) handleListParts(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	q := r.URL.Query()
+	marker, maxParts := 0, 1000
+	if v := q.Get("part-number-marker"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "part-number-marker must be a non-negative integer", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		marker = n
+	}
+	if v := q.Get("max-parts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "max-parts must be a non-negative integer", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		maxParts = min(n, 1000)
+	}
+	bkt, key, u, ok := s.multipartTarget(w, r, log)
+	if !ok {
+		return
+	}
+
+	parts, err := s.db.ListMultipartPartsPage(u.UploadID, marker, maxParts+1)
+	if err != nil {
+		log.Error("mpu_list_parts.db_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	res := ListPartsResult{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket: bkt.Name, Key: key, UploadId: u.UploadID,
+		Initiator: initiatorXML(u), Owner: initiatorXML(u), StorageClass: u.StorageClass,
+		PartNumberMarker: marker, MaxParts: maxParts,
+	}
+	if len(parts) > maxParts {
+		res.IsTruncated = true
+		parts = parts[:maxParts]
+	}
+	for _, p := range parts {
+		res.Parts = append(res.Parts, PartXML{
+			PartNumber: p.PartNumber, LastModified: p.UpdatedAt.UTC().Format(timeRFC3339),
+			ETag: p.ETag, Size: p.Size,
+		})
+		res.NextPartNumberMarker = p.PartNumber
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(res)
+	log.Info("mpu_list_parts.ok", "upload_id", u.UploadID, "parts", len(res.Parts), "truncated", res.IsTruncated)
+}
+
+// GET /:bucket?uploads[&prefix=&delimiter=&key-marker=&upload-id-marker=&max-uploads=]
+func (s *Server) handleListMultipartUploads(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	q := r.URL.Query()
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	keyMarker, uidMarker := q.Get("key-marker"), q.Get("upload-id-marker")
+	if keyMarker == "" {
+		uidMarker = "" // без key-marker игнорируется (как в S3)
+	}
+	encType, ok := parseEncodingType(w, r)
+	if !ok {
+		return
+	}
+	if len(delim) > 1 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "delimiter must be a single character", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	maxUploads := 1000
+	if v := q.Get("max-uploads"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "max-uploads must be a non-negative integer", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		maxUploads = min(n, 1000)
+	}
+
+	bucketID, err := s.db.BucketIDByName(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("mpu_list.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	res := ListMultipartUploadsResult{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket: bucket, KeyMarker: listKey(encType, q.Get("key-marker")), UploadIdMarker: uidMarker,
+		Prefix: listKey(encType, prefix), Delimiter: listKey(encType, delim), MaxUploads: maxUploads,
+		EncodingType: encType,
+	}
+	// с delimiter несколько загрузок схлопываются в один CommonPrefix, поэтому
+	// читаем пачками, пока не наберём max-uploads элементов (+1 — узнать про усечение)
+	const batch = 1000
+	curKey, curUID := keyMarker, uidMarker
+	lastCP := ""
+	count := 0
+scan:
+	for {
+		rows, err := s.db.ListMultipartUploads(bucketID, prefix, curKey, curUID, batch)
+		if err != nil {
+			log.Error("mpu_list.db_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		for i := range rows {
+			u := &rows[i]
+			curKey, curUID = u.Key, u.UploadID
+			if !strings.HasPrefix(u.Key, prefix) {
+				continue // LIKE понимает _ и % как шаблоны
+			}
+			if delim != "" {
+				if idx := strings.Index(u.Key[len(prefix):], delim); idx >= 0 {
+					cp := u.Key[:len(prefix)+idx+1]
+					if cp == lastCP || cp == keyMarker {
+						continue
+					}
+					if count == maxUploads {
+						res.IsTruncated = true
+						break scan
+					}
+					res.CommonPrefixes = append(res.CommonPrefixes, CommonPrefix{Prefix: listKey(encType, cp)})
+					res.NextKeyMarker, res.NextUploadIdMarker = cp, ""
+					lastCP = cp
+					count++
+					continue
+				}
+			}
+			if count == maxUploads {
+				res.IsTruncated = true
+				break scan
+			}
+			res.Uploads = append(res.Uploads, MultipartUploadXML{
+				Key: listKey(encType, u.Key), UploadId: u.UploadID,
+				Initiator: initiatorXML(u), Owner: initiatorXML(u), StorageClass: u.StorageClass,
+				Initiated: u.CreatedAt.UTC().Format(timeRFC3339),
+			})
+			res.NextKeyMarker, res.NextUploadIdMarker = u.Key, u.UploadID
+			count++
+		}
+		if len(rows) < batch {
+			break
+		}
+	}
+	if !res.IsTruncated {
+		res.NextKeyMarker, res.NextUploadIdMarker = "", ""
+	}
+	res.NextKeyMarker = listKey(encType, res.NextKeyMarker)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(res)
+	log.Info("mpu_list.ok", "uploads", len(res.Uploads), "prefixes", len(res.CommonPrefixes), "truncated", res.IsTruncated)
+}
@@ -2,8 +2,6 @@ package server
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -11,9 +9,11 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/DanikLP1/s3-storage-service/internal/auth"
 	"github.com/DanikLP1/s3-storage-service/internal/db"
-	"github.com/DanikLP1/s3-storage-service/internal/storage"
+	"github.com/DanikLP1/s3-storage-service/internal/transform"
 	"gorm.io/gorm"
 )
 
@@ -43,6 +43,9 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
 		return
 	}
+	if !s.limitBody(w, r, s.putBodyLimit()) || !s.checkDiskSpace(w, r, r.ContentLength) {
+		return
+	}
 
 	ownerID := getUserIDFromCtx(r.Context())
 	bucketID, err := s.db.EnsureBucket(bucket, ownerID)
@@ -51,35 +54,125 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 		writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket error", r.URL.Path, requestIDFrom(r))
 		return
 	}
-
-	// ---- 1) IO вне транзакции: стримим байты в storage и считаем хэш ----
-	newBlobID := s.db.GenBlobID()
-	ws, err := s.storage.Driver().BeginWrite(r.Context(), storage.BlobID(newBlobID), storage.PutOpts{Size: r.ContentLength})
+	bkt, err := s.db.FindBucketByID(bucketID)
+	if err != nil {
+		log.Error("put_object.bucket_load_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if !checkUploadPolicy(w, r, bkt) {
+		log.Warn("put_object.upload_policy_denied")
+		return
+	}
+	encCtx, ok := checkPutEncryptionContext(w, r)
+	if !ok {
+		log.Warn("put_object.bad_encryption_context")
+		return
+	}
+	meta, ok := checkPutUserMetadata(w, r)
+	if !ok {
+		log.Warn("put_object.metadata_too_large")
+		return
+	}
+	tags, err := parseTaggingHeader(r.Header)
 	if err != nil {
-		log.Error("put_object.beginwrite_fail", "err", err)
-		writeS3Error(w, http.StatusInternalServerError, "InternalError", "write begin error", r.URL.Path, requestIDFrom(r))
+		writeS3Error(w, http.StatusBadRequest, "InvalidTag", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	acl, ok := parseACLHeader(r.Header)
+	if !ok {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "unsupported canned ACL "+r.Header.Get(hdrACL), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	class, ok := parseStorageClassHeader(r.Header)
+	if !ok {
+		writeInvalidStorageClass(w, r)
+		return
+	}
+	if class == "" {
+		class = storageClassStandard
+	}
+	lock, code, msg := parseLockHeaders(r.Header, bkt, time.Now())
+	if code != "" {
+		writeS3Error(w, http.StatusBadRequest, code, msg, r.URL.Path, requestIDFrom(r))
 		return
 	}
 
-	hasher := sha256.New()
-	written, copyErr := io.Copy(ws.Writer(), io.TeeReader(r.Body, hasher))
-	if copyErr != nil {
-		_ = ws.Abort(r.Context())
-		log.Error("put_object.write_fail", "err", copyErr)
-		writeS3Error(w, http.StatusInternalServerError, "InternalError", "write error", r.URL.Path, requestIDFrom(r))
+	// If-Match: перезапись только поверх ожидаемой HEAD; If-None-Match: * — только
+	// создание. Проверяем до чтения тела (чтобы не гонять байты зря) и ещё раз под локом ключа.
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifNoneMatch != "" && strings.TrimSpace(ifNoneMatch) != "*" {
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "If-None-Match on PUT supports only *", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if ifMatch != "" || ifNoneMatch != "" {
+		var failed int
+		err := s.db.WithTx(func(tx *gorm.DB) error {
+			var err error
+			failed, err = s.checkPutPreconditionTx(tx, bucketID, key, ifMatch, ifNoneMatch)
+			return err
+		})
+		if err != nil {
+			log.Error("put_object.precondition_lookup_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if failed != 0 {
+			log.Info("put_object.precondition_failed", "if_match", ifMatch, "if_none_match", ifNoneMatch, "status", failed)
+			writePreconditionError(w, r, failed)
+			return
+		}
+	}
+
+	// квота до чтения тела; при Suspended/Disabled перезапись освобождает место
+	// старой версии, поэтому заранее отказываем только при Enabled
+	if bkt.Versioning == db.VersioningEnabled && r.ContentLength > 0 {
+		if err := s.db.CheckQuota(bucketID, 1, r.ContentLength); err != nil {
+			if !writeQuotaError(w, r, err) {
+				log.Error("put_object.quota_check_fail", "err", err)
+				writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			}
+			return
+		}
+	}
+
+	md5c, ok := newMD5Check(w, r)
+	if !ok {
 		return
 	}
-	if err := ws.Commit(r.Context()); err != nil {
-		log.Error("put_object.commit_fail", "err", err)
-		writeS3Error(w, http.StatusInternalServerError, "InternalError", "commit error", r.URL.Path, requestIDFrom(r))
+	ck, ok := newChecksumCheck(w, r)
+	if !ok {
 		return
 	}
 
-	size := written
-	sumHex := hex.EncodeToString(hasher.Sum(nil))
-	checksum := "sha256:" + sumHex
-	etag := `"` + checksum + `"`
+	// ---- 1) IO вне транзакции: стримим байты в storage и считаем хэш ----
 	ctype := r.Header.Get("Content-Type")
+	body := ck.wrap(r.Body)
+	var sniff *headCapture
+	if bkt.DetectContentType && needsSniff(ctype) {
+		sniff = &headCapture{r: body}
+		body = sniff
+	}
+	up, err := s.stageUpload(r.Context(), body, r.ContentLength, s.uploadOptsFor(bkt))
+	if err != nil {
+		if writeChunkedBodyError(w, r, err) {
+			log.Warn("put_object.bad_chunked_body", "err", err)
+			return
+		}
+		if writeTooLargeError(w, r, err) {
+			return
+		}
+		log.Error("put_object.write_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "write error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	size := up.Size
+	sumHex := up.SHA256
+	etag := up.ETag()
+	if sniff != nil {
+		ctype = detectContentType(key, sniff.head)
+		log.Info("put_object.content_type_detected", "content_type", ctype)
+	}
 	if ctype == "" {
 		ctype = "application/octet-stream"
 	}
@@ -87,16 +180,44 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 	// базовые валидации сразу
 	if r.ContentLength >= 0 && size != r.ContentLength {
 		log.Warn("put_object.bad_length", "got", size, "want", r.ContentLength)
-		_ = s.storage.Delete(r.Context(), newBlobID) // зачистим запись на диске
+		s.discardStaged(r.Context(), up, false) // зачистим запись на диске
 		writeS3Error(w, http.StatusBadRequest, "BadDigest", "mismatched content length", r.URL.Path, requestIDFrom(r))
 		return
 	}
-	if want := r.Header.Get("x-amz-content-sha256"); want != "" && want != sumHex && want != "UNSIGNED-PAYLOAD" {
+	if want := r.Header.Get("x-amz-content-sha256"); want != "" && want != sumHex && want != "UNSIGNED-PAYLOAD" && !auth.IsStreamingPayload(want) {
 		log.Warn("put_object.bad_sha256", "want", want, "got", sumHex)
-		_ = s.storage.Delete(r.Context(), newBlobID)
+		s.discardStaged(r.Context(), up, false)
 		writeS3Error(w, http.StatusBadRequest, "BadDigest", "sha256 mismatch", r.URL.Path, requestIDFrom(r))
 		return
 	}
+	if !md5c.matches(up) {
+		log.Warn("put_object.bad_md5", "content_md5", r.Header.Get("Content-MD5"))
+		s.discardStaged(r.Context(), up, false)
+		writeS3Error(w, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if !ck.verify(w, r) {
+		log.Warn("put_object.bad_checksum", "algorithm", ck.Alg)
+		s.discardStaged(r.Context(), up, false)
+		return
+	}
+	var ckAlg, ckValue string
+	if ck != nil {
+		ckAlg, ckValue = ck.Alg, ck.Value()
+	}
+
+	if s.hasPrePutHooks() {
+		err := s.runPrePutHooks(r, PutInfo{
+			Bucket: bucket, Key: key, Size: size, SHA256: sumHex, ContentType: ctype,
+			Open: func() (io.ReadCloser, error) { return s.openStaged(r.Context(), up), nil },
+		})
+		if err != nil {
+			log.Warn("put_object.hook_rejected", "err", err)
+			s.discardStaged(r.Context(), up, false)
+			writeHookError(w, r, err)
+			return
+		}
+	}
 
 	idem := r.Header.Get("X-Idempotency-Key")
 	if idem != "" {
@@ -112,9 +233,8 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 		status    int
 	}
 	var res putResult
-
-	staged := true
-	usedNew := false
+	var precondFailed int
+	var dropped []string // Disabled: блобы перезаписанных версий
 
 	// ---- 2) Транзакция: лок ключа, дедуп, метаданные, идемпотентность ----
 	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
@@ -137,46 +257,115 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if ifMatch != "" || ifNoneMatch != "" {
+			failed, err := s.checkPutPreconditionTx(tx, bucketID, key, ifMatch, ifNoneMatch)
+			if err != nil {
+				log.Error("put_object.precondition_lookup_fail", "err", err)
+				return err
+			}
+			if failed != 0 {
+				precondFailed = failed
+				return nil
+			}
+		}
+
 		// дедуп по checksum
-		var useBlobID string
-		var useSize int64
-		if exist, err := s.db.FindBlobByChecksumTx(tx, checksum); err == nil && exist != nil {
-			// нашли готовый blob — удаляем только что записанную копию
-			_ = s.storage.Delete(r.Context(), newBlobID)
-			staged = false
-			useBlobID, useSize = exist.ID, exist.Size
-			log.Info("put_object.dedup_hit", "blob_id", useBlobID, "size", useSize)
-		} else if err != nil && !errors.Is(err, db.ErrNotFound) {
-			_ = s.storage.Delete(r.Context(), newBlobID)
-			log.Error("put_object.find_checksum_fail", "err", err)
+		useBlobID, useSize, err := s.adoptUploadTx(r.Context(), tx, up)
+		if err != nil {
+			log.Error("put_object.adopt_blob_fail", "err", err)
 			return err
+		}
+		if len(up.Parts) > 1 {
+			log.Info("put_object.chunked", "blob_id", useBlobID, "size", useSize, "chunks", len(up.Parts))
+		} else if up.Parts[0].adopted {
+			log.Info("put_object.blob_ready", "blob_id", useBlobID, "size", useSize)
 		} else {
-			// резервируем и помечаем ready новый blob
-			if err := s.db.ReserveBlobPendingTx(tx, newBlobID, checksum, size, "local"); err != nil {
-				_ = s.storage.Delete(r.Context(), newBlobID)
-				log.Error("put_object.reserve_blob_fail", "err", err)
-				return err
+			log.Info("put_object.dedup_hit", "blob_id", useBlobID, "size", useSize)
+		}
+
+		// компакция: те же байты и тип, что у текущей HEAD — новую версию не плодим;
+		// под Object Lock каждая запись — своя версия со своим сроком
+		if bkt.CompactIdenticalVersions && !bkt.ObjectLockEnabled {
+			head, err := s.db.GetHeadVersionTx(tx, bucketID, key)
+			// тот же предикат, что у фоновой компакции (ListCompactableVersions)
+			same := err == nil && db.SameVersionContent(head, &db.ObjectVersion{
+				BlobID: &useBlobID, ContentType: &ctype, EncryptionContext: &encCtx, UserMetadata: &meta,
+				ACL: acl, StorageClass: class, ChecksumAlgorithm: ckAlg,
+			})
+			if same {
+				if same, err = s.sameTagsTx(tx, head.VersionID, tags); err != nil {
+					log.Error("put_object.head_tags_fail", "err", err)
+					return err
+				}
 			}
-			if err := s.db.MarkBlobReadyTx(tx, newBlobID); err != nil {
-				log.Error("put_object.mark_ready_fail", "err", err)
+			if same {
+				if err := s.db.TouchVersionTx(tx, head.VersionID); err != nil {
+					log.Error("put_object.touch_version_fail", "err", err)
+					return err
+				}
+				if idem != "" {
+					if err := s.db.SaveIdempotencyTx(tx, bucketID, key, idem, head.VersionID, coalesce(head.ETag, etag)); err != nil {
+						log.Warn("put_object.idem_save_warn", "err", err)
+					}
+				}
+				res = putResult{versionID: head.VersionID, etag: coalesce(head.ETag, etag), blobID: useBlobID, size: useSize, status: http.StatusOK}
+				log.Info("put_object.compacted", "version_id", head.VersionID)
+				return nil
+			} else if err != nil && !errors.Is(err, db.ErrNotFound) {
+				log.Error("put_object.head_lookup_fail", "err", err)
 				return err
 			}
-			usedNew = true
-			useBlobID, useSize = newBlobID, size
-			log.Info("put_object.blob_ready", "blob_id", useBlobID, "size", useSize)
 		}
 
-		verID := s.db.GenVersionID()
-		if err := s.db.InsertObjectVersionTx(tx, bucketID, key, verID, useBlobID, useSize, etag, ctype); err != nil {
-			log.Error("put_object.create_version_fail", "err", err)
-			return err
+		if bkt.Versioning == db.VersioningDisabled {
+			if dropped, err = s.dropKeyVersionsTx(tx, bkt, key); err != nil {
+				log.Error("put_object.drop_versions_fail", "err", err)
+				return err
+			}
 		}
-		if err := s.db.UpsertObjectTx(tx, bucketID, key, useBlobID, useSize, etag, ctype, verID); err != nil {
-			log.Error("put_object.upsert_obj_fail", "err", err)
+		verID, err := s.commitVersionTx(tx, bucketID, key, useBlobID, useSize, etag, ctype)
+		if err != nil {
+			log.Error("put_object.commit_version_fail", "err", err)
 			return err
 		}
-		if err := s.db.SetHeadVersionTx(tx, bucketID, key, verID); err != nil {
-			log.Error("put_object.set_head_fail", "err", err)
+		if encCtx != "" {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"encryption_context": encCtx}); err != nil {
+				log.Error("put_object.encryption_context_fail", "err", err)
+				return err
+			}
+		}
+		if meta != "" {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"user_metadata": meta}); err != nil {
+				log.Error("put_object.user_metadata_fail", "err", err)
+				return err
+			}
+		}
+		if len(tags) > 0 {
+			if err := s.db.SetVersionTagsTx(tx, verID, tags); err != nil {
+				log.Error("put_object.tagging_fail", "err", err)
+				return err
+			}
+		}
+		if class != storageClassStandard {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"storage_class": class}); err != nil {
+				log.Error("put_object.storage_class_fail", "err", err)
+				return err
+			}
+		}
+		if acl != "" {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"acl": acl}); err != nil {
+				log.Error("put_object.acl_fail", "err", err)
+				return err
+			}
+		}
+		if ckAlg != "" {
+			if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"checksum_algorithm": ckAlg, "checksum_value": ckValue}); err != nil {
+				log.Error("put_object.checksum_fail", "err", err)
+				return err
+			}
+		}
+		if err := s.db.UpdateVersionFieldsTx(tx, verID, lock.fields()); err != nil {
+			log.Error("put_object.object_lock_fail", "err", err)
 			return err
 		}
 
@@ -196,9 +385,9 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 		}
 		return nil
 	}); err != nil {
-		if staged {
-			_ = s.storage.Delete(r.Context(), newBlobID)
-			staged = false
+		s.discardStaged(r.Context(), up, false)
+		if writeCommitError(w, r, err) {
+			return
 		}
 		if !errors.Is(err, context.Canceled) {
 			log.Error("put_object.tx_fail", "err", err)
@@ -207,15 +396,24 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if staged && !usedNew {
-		_ = s.storage.Delete(r.Context(), newBlobID)
-		staged = false
+	s.discardStaged(r.Context(), up, true)
+	s.reclaimBlobs(r.Context(), log, dropped)
+
+	if precondFailed != 0 {
+		// HEAD сменилась, пока мы читали тело
+		log.Info("put_object.precondition_failed", "if_match", ifMatch, "if_none_match", ifNoneMatch, "status", precondFailed)
+		writePreconditionError(w, r, precondFailed)
+		return
 	}
 
 	// ---- 3) HTTP‑ответ уже после успешной txn ----
 	if res.versionID != "" {
 		w.Header().Set("ETag", res.etag)
-		w.Header().Set("x-amz-version-id", res.versionID)
+		w.Header().Set("x-amz-version-id", apiVersionID(res.versionID))
+		setChecksumHeader(w, ckAlg, ckValue)
+		if ver, err := s.db.GetVersionTx(s.db.DB, res.versionID); err == nil {
+			s.setExpirationHeader(w, log, ver)
+		}
 		w.Header().Set("Content-Type", "application/xml")
 		w.WriteHeader(res.status)
 		log.Info("put_object.ok", "blob_id", res.blobID, "size", res.size, "version_id", res.versionID)
@@ -224,7 +422,7 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 
 	// идемпотентный HIT: заголовки уже есть в res
 	w.Header().Set("ETag", res.etag)
-	w.Header().Set("x-amz-version-id", res.versionID)
+	w.Header().Set("x-amz-version-id", apiVersionID(res.versionID))
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
 	log.Info("put_object.idem_ok", "version_id", res.versionID)
@@ -254,14 +452,19 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	versionID := r.URL.Query().Get("versionId")
-	var ver *db.ObjectVersion
-	if versionID == "" {
-		ver, err = s.db.GetHeadVersionTx(s.db.DB, bucketID, key)
-	} else {
-		ver, err = s.db.GetVersionTx(s.db.DB, versionID)
+	ver, err := s.resolveVersionTx(s.db.DB, bucketID, key, versionID)
+	if errors.Is(err, errIsDeleteMarker) {
+		log.Info("get_object.delete_marker", "version_id", ver.VersionID)
+		writeDeleteMarker(w, r, ver, versionID)
+		return
+	}
+	if errors.Is(err, db.ErrNotFound) && versionID != "" {
+		log.Info("get_object.no_such_version", "version_id", versionID)
+		writeS3Error(w, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.", r.URL.Path, requestIDFrom(r))
+		return
 	}
-	if errors.Is(err, db.ErrNotFound) || (ver != nil && ver.IsDelete) {
-		log.Info("get_object.not_found", "version_id", versionID)
+	if errors.Is(err, db.ErrNotFound) {
+		log.Info("get_object.not_found")
 		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", r.URL.Path, requestIDFrom(r))
 		return
 	}
@@ -271,6 +474,16 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if archived, err := s.versionArchivedTx(s.db.DB, ver); err != nil {
+		log.Error("get_object.db_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	} else if archived {
+		log.Info("get_object.archived", "version_id", ver.VersionID, "class", ver.StorageClass)
+		writeInvalidObjectState(w, r)
+		return
+	}
+
 	b, err := s.db.GetBlob(*ver.BlobID)
 	if err != nil {
 		log.Error("get_object.blob_missing", "blob_id", *ver.BlobID, "err", err)
@@ -278,23 +491,62 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// предикаты
-	if ver.ETag != nil {
-		ifMatch := r.Header.Get("If-Match")
-		if ifMatch != "" && stripQuotes(ifMatch) != stripQuotes(*ver.ETag) {
-			log.Info("get_object.precondition_failed", "if_match", ifMatch, "etag", *ver.ETag)
-			w.WriteHeader(http.StatusPreconditionFailed)
+	if !checkGetEncryptionContext(w, r, ver.EncryptionContext) {
+		log.Warn("get_object.encryption_context_mismatch", "version_id", ver.VersionID)
+		return
+	}
+
+	if t, params, err := transform.Lookup(r.URL.Query()); t != nil || err != nil {
+		bkt, berr := s.db.FindBucketByID(bucketID)
+		if berr != nil {
+			log.Error("get_object.bucket_load_fail", "err", berr)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "bucket error", r.URL.Path, requestIDFrom(r))
 			return
 		}
-		ifNone := r.Header.Get("If-None-Match")
-		if ifNone != "" && stripQuotes(ifNone) == stripQuotes(*ver.ETag) {
-			log.Info("get_object.not_modified", "etag", *ver.ETag)
-			w.WriteHeader(http.StatusNotModified)
+		// без включённых трансформаций параметры просто игнорируются, как в S3
+		if bkt.TransformsEnabled {
+			if err != nil {
+				writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error(), r.URL.Path, requestIDFrom(r))
+				return
+			}
+			s.serveTransformed(w, r, ver, t, params)
 			return
 		}
-		w.Header().Set("ETag", *ver.ETag)
 	}
-	w.Header().Set("x-amz-version-id", ver.VersionID)
+
+	// предикаты: If-Match / If-Unmodified-Since → 412, If-None-Match / If-Modified-Since → 304
+	etag := coalesce(ver.ETag, "")
+	lastMod := versionLastModified(ver)
+	switch objectPrecondition(r.Header, etag, lastMod) {
+	case http.StatusPreconditionFailed:
+		log.Info("get_object.precondition_failed", "etag", etag, "last_modified", lastMod)
+		writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", r.URL.Path, requestIDFrom(r))
+		return
+	case http.StatusNotModified:
+		log.Info("get_object.not_modified", "etag", etag, "last_modified", lastMod)
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	s.setTaggingCount(w, ver.VersionID)
+	setLockHeaders(w, ver)
+	setStorageClassHeader(w, ver.StorageClass)
+	setRestoreHeader(w, ver)
+	setUserMetadataHeaders(w, ver.UserMetadata)
+	if checksumRequested(r) && r.Header.Get("Range") == "" {
+		setChecksumHeader(w, ver.ChecksumAlgorithm, ver.ChecksumValue)
+	}
+	if versionID == "" {
+		s.setExpirationHeader(w, log, ver)
+	}
 
 	ct := "application/octet-stream"
 	if ver.ContentType != nil && *ver.ContentType != "" {
@@ -308,48 +560,36 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 	var start, length int64 = 0, -1
 	status := http.StatusOK
 	if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "bytes=") {
-		spec := strings.TrimPrefix(rng, "bytes=")
-		var a, z string
-		if i := strings.IndexByte(spec, '-'); i >= 0 {
-			a, z = spec[:i], spec[i+1:]
+		ranges, err := parseRanges(rng, total)
+		if err != nil {
+			log.Warn("get_object.bad_range", "range", rng, "size", total)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
 		}
-		switch {
-		case a != "" && z != "":
-			as, _ := strconv.ParseInt(a, 10, 64)
-			bs, _ := strconv.ParseInt(z, 10, 64)
-			if as < 0 || bs < as || as >= total {
-				log.Warn("get_object.bad_range", "range", rng, "size", total)
-				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
-				return
-			}
-			start, length, status = as, bs-as+1, http.StatusPartialContent
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", as, bs, total))
-		case a != "" && z == "":
-			as, _ := strconv.ParseInt(a, 10, 64)
-			if as < 0 || as >= total {
-				log.Warn("get_object.bad_range", "range", rng, "size", total)
-				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
-				return
+		if len(ranges) > 1 {
+			// несколько диапазонов — 206 multipart/byteranges (RFC 7233)
+			boundary, parts, size := byteRangesBody(ct, total, ranges)
+			w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			w.WriteHeader(http.StatusPartialContent)
+			n, err := writeByteRanges(w, boundary, parts, ranges, func(br byteRange) (io.ReadCloser, error) {
+				return s.openBlob(r.Context(), *ver.BlobID, br.start, br.length)
+			})
+			if err != nil {
+				log.Error("get_object.read_fail", "err", err)
 			}
-			start, length, status = as, total-as, http.StatusPartialContent
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", as, total-1, total))
-		case a == "" && z != "":
-			zs, _ := strconv.ParseInt(z, 10, 64)
-			if zs <= 0 {
-				log.Warn("get_object.bad_range", "range", rng)
-				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
-				return
-			}
-			if zs > total {
-				zs = total
-			}
-			start, length, status = total-zs, zs, http.StatusPartialContent
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, total-1, total))
+			log.Info("get_object.multi_range", "blob_id", *ver.BlobID, "version_id", ver.VersionID, "ranges", len(ranges), "bytes", n)
+			return
+		}
+		if len(ranges) == 1 {
+			start, length, status = ranges[0].start, ranges[0].length, http.StatusPartialContent
+			w.Header().Set("Content-Range", ranges[0].contentRange(total))
 		}
 		log.Info("get_object.range", "start", start, "length", length, "total", total)
 	}
 
-	rc, err := s.storage.ReadAt(r.Context(), *ver.BlobID, start, length)
+	rc, err := s.openBlob(r.Context(), *ver.BlobID, start, length)
 	if err != nil {
 		log.Error("get_object.read_fail", "err", err)
 		writeS3Error(w, http.StatusInternalServerError, "InternalError", "read error", r.URL.Path, requestIDFrom(r))
@@ -367,103 +607,211 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 	log.Info("get_object.ok", "blob_id", *ver.BlobID, "version_id", ver.VersionID, "status", status, "bytes", n)
 }
 
-func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+// HEAD /:bucket/:key — те же заголовки, что у GET, без чтения блоба и без тела.
+func (s *Server) handleHead(w http.ResponseWriter, r *http.Request) {
 	bucket, key, err := parseBucketKey(r.URL.Path)
 	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("key", key))
-	log.Info("delete_object.start")
 	if err != nil {
-		log.Warn("delete_object.bad_path", "err", err)
 		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
 		return
 	}
 
-	ownerID := getUserIDFromCtx(r.Context())
-	bucketID, err := s.db.BucketIDByName(bucket, ownerID)
+	bucketID, err := s.db.BucketIDByName(bucket, getUserIDFromCtx(r.Context()))
 	if errors.Is(err, db.ErrNotFound) {
-		log.Warn("delete_object.no_such_bucket")
 		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
 		return
 	}
 	if err != nil {
-		log.Error("delete_object.bucket_lookup_fail", "err", err)
+		log.Error("head_object.bucket_lookup_fail", "err", err)
 		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
 		return
 	}
 
 	versionID := r.URL.Query().Get("versionId")
-
-	type delResult struct {
-		returnVersion string
-		status        int
+	ver, err := s.resolveVersionTx(s.db.DB, bucketID, key, versionID)
+	if errors.Is(err, errIsDeleteMarker) {
+		writeDeleteMarker(w, r, ver, versionID)
+		return
+	}
+	if errors.Is(err, db.ErrNotFound) && versionID != "" {
+		writeS3Error(w, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("head_object.db_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
 	}
-	var res delResult
 
-	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
-		if err := s.db.LockObjectForUpdate(tx, bucketID, key); err != nil {
-			log.Error("delete_object.lock_fail", "err", err)
-			return err
+	if !checkGetEncryptionContext(w, r, ver.EncryptionContext) {
+		return
+	}
+	// размер и тип результата трансформации известны только после неё (или из кэша)
+	if t, params, terr := transform.Lookup(r.URL.Query()); t != nil && terr == nil {
+		if bkt, err := s.db.FindBucketByID(bucketID); err == nil && bkt.TransformsEnabled {
+			s.serveTransformed(w, r, ver, t, params)
+			return
 		}
+	}
 
-		// 1) Без versionId — мягкое удаление (delete‑marker)
-		if versionID == "" {
-			dm := s.db.GenVersionID()
-			if err := s.db.CreateDeleteMarkerTx(tx, bucketID, key, dm); err != nil {
-				log.Error("delete_object.create_dm_fail", "err", err)
-				return err
-			}
-			if err := s.db.SetHeadVersionTx(tx, bucketID, key, dm); err != nil {
-				log.Error("delete_object.set_head_fail", "err", err)
-				return err
-			}
-			res = delResult{returnVersion: dm, status: http.StatusNoContent}
-			log.Info("delete_object.ok_delete_marker", "version_id", dm)
-			return nil
+	etag := coalesce(ver.ETag, "")
+	lastMod := versionLastModified(ver)
+	if status := objectPrecondition(r.Header, etag, lastMod); status != 0 {
+		log.Info("head_object.precondition", "status", status, "etag", etag)
+		if status == http.StatusNotModified {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
 		}
+		w.WriteHeader(status)
+		return
+	}
 
-		// 2) С versionId — удаление указанной версии
-		ver, err := s.db.GetVersionTx(tx, versionID)
-		if errors.Is(err, db.ErrNotFound) {
-			log.Warn("delete_object.no_such_version", "version_id", versionID)
-			// В txn нельзя писать ответ — просто вернём «мягкую» ошибку наружу
-			res = delResult{status: http.StatusNotFound}
-			return nil
-		}
+	total := coalesce(ver.Size, 0)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	s.setTaggingCount(w, ver.VersionID)
+	setLockHeaders(w, ver)
+	setStorageClassHeader(w, ver.StorageClass)
+	setRestoreHeader(w, ver)
+	setUserMetadataHeaders(w, ver.UserMetadata)
+	if checksumRequested(r) && r.Header.Get("Range") == "" {
+		setChecksumHeader(w, ver.ChecksumAlgorithm, ver.ChecksumValue)
+	}
+	if versionID == "" {
+		s.setExpirationHeader(w, log, ver)
+	}
+	ct := coalesce(ver.ContentType, "application/octet-stream")
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	status, length := http.StatusOK, total
+	if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "bytes=") {
+		ranges, err := parseRanges(rng, total)
 		if err != nil {
-			log.Error("delete_object.get_version_fail", "err", err)
-			return err
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
 		}
-
-		if err := s.db.DeleteVersionTx(tx, versionID); err != nil {
-			log.Error("delete_object.delete_version_fail", "err", err)
-			return err
+		switch {
+		case len(ranges) > 1:
+			boundary, _, size := byteRangesBody(ct, total, ranges)
+			status, length = http.StatusPartialContent, size
+			w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		case len(ranges) == 1:
+			status, length = http.StatusPartialContent, ranges[0].length
+			w.Header().Set("Content-Range", ranges[0].contentRange(total))
 		}
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	log.Info("head_object.ok", "version_id", ver.VersionID, "status", status)
+}
 
-		// Если это был HEAD — переставить HEAD на предыдущую (или на delete‑marker)
-		head, _ := s.db.GetHeadVersionTx(tx, bucketID, key)
-		if head == nil || head.VersionID == versionID {
-			if prev, err := s.db.GetPrevVersionTx(tx, bucketID, key, versionID); err == nil && prev != nil {
-				_ = s.db.SetHeadVersionTx(tx, bucketID, key, prev.VersionID)
-				log.Info("delete_object.head_moved", "new_head", prev.VersionID)
-			} else {
-				dm := s.db.GenVersionID()
-				_ = s.db.CreateDeleteMarkerTx(tx, bucketID, key, dm)
-				_ = s.db.SetHeadVersionTx(tx, bucketID, key, dm)
-				log.Info("delete_object.head_set_dm", "dm", dm)
-			}
+// versionLastModified — Last-Modified версии; в HTTP-датах точность — секунда,
+// поэтому и сравниваем с If-(Un)Modified-Since по секундам.
+func versionLastModified(ver *db.ObjectVersion) time.Time {
+	return ver.CreatedAt.UTC().Truncate(time.Second)
+}
+
+// objectPrecondition — условные заголовки GET/HEAD в порядке RFC 7232: If-Match /
+// If-Unmodified-Since дают 412, If-None-Match / If-Modified-Since — 304. Дата
+// учитывается, только если соответствующего заголовка с ETag нет. 0 — отдаём объект.
+func objectPrecondition(h http.Header, etag string, lastMod time.Time) int {
+	if v := h.Get("If-Match"); v != "" {
+		if !etagListMatches(v, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(h.Get("If-Unmodified-Since")); err == nil && lastMod.After(t) {
+		return http.StatusPreconditionFailed
+	}
+	if v := h.Get("If-None-Match"); v != "" {
+		if etagListMatches(v, etag) {
+			return http.StatusNotModified
 		}
+	} else if t, err := http.ParseTime(h.Get("If-Modified-Since")); err == nil && !lastMod.After(t) {
+		return http.StatusNotModified
+	}
+	return 0
+}
 
-		// GC блоба, если осиротел
-		if ver.BlobID != nil {
-			if cnt, _ := s.db.BlobRefCountFromVersionsTx(tx, *ver.BlobID); cnt == 0 {
-				_ = s.storage.Delete(r.Context(), *ver.BlobID)
-				_ = s.db.DeleteBlobRecordTx(tx, *ver.BlobID)
-				log.Info("delete_object.blob_gc", "blob_id", *ver.BlobID)
-			}
+var errBadRange = errors.New("range not satisfiable")
+
+// parseRange разбирает "bytes=a-b" | "bytes=a-" | "bytes=-n" для объекта размера total.
+// length = -1 — диапазон синтаксически не распознан и игнорируется (отдаём целиком).
+func parseRange(rng string, total int64) (start, length int64, err error) {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return 0, -1, nil
+	}
+	a, z := spec[:i], spec[i+1:]
+	switch {
+	case a != "" && z != "":
+		as, _ := strconv.ParseInt(a, 10, 64)
+		bs, _ := strconv.ParseInt(z, 10, 64)
+		if as < 0 || bs < as || as >= total {
+			return 0, 0, errBadRange
+		}
+		if bs >= total {
+			bs = total - 1
+		}
+		return as, bs - as + 1, nil
+	case a != "" && z == "":
+		as, _ := strconv.ParseInt(a, 10, 64)
+		if as < 0 || as >= total {
+			return 0, 0, errBadRange
 		}
+		return as, total - as, nil
+	case a == "" && z != "":
+		zs, _ := strconv.ParseInt(z, 10, 64)
+		if zs <= 0 {
+			return 0, 0, errBadRange
+		}
+		if zs > total {
+			zs = total
+		}
+		return total - zs, zs, nil
+	}
+	return 0, -1, nil
+}
 
-		res = delResult{returnVersion: versionID, status: http.StatusNoContent}
-		log.Info("delete_object.ok", "version_id", versionID)
-		return nil
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("key", key))
+	log.Info("delete_object.start")
+	if err != nil {
+		log.Warn("delete_object.bad_path", "err", err)
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	ownerID := getUserIDFromCtx(r.Context())
+	bucketID, err := s.db.BucketIDByName(bucket, ownerID)
+	if errors.Is(err, db.ErrNotFound) {
+		log.Warn("delete_object.no_such_bucket")
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("delete_object.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	versionID := r.URL.Query().Get("versionId")
+
+	var res delResult
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		var err error
+		res, err = s.deleteObjectTx(r.Context(), tx, log, bucketID, key, versionID, canBypassGovernance(r))
+		return err
 	}); err != nil {
 		log.Error("delete_object.tx_fail", "err", err)
 		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
@@ -475,6 +823,147 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		writeS3Error(w, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.", r.URL.Path, requestIDFrom(r))
 		return
 	}
-	w.Header().Set("x-amz-version-id", res.returnVersion)
+	if res.status == http.StatusForbidden && res.locked {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied because object protected by object lock.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if res.status == http.StatusForbidden {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "version is inside the bucket protection window", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	s.runPostDeleteHooks(r, DeleteInfo{Bucket: bucket, Key: key, VersionID: res.returnVersion, DeleteMarker: res.marker})
+	if res.returnVersion != "" {
+		w.Header().Set("x-amz-version-id", apiVersionID(res.returnVersion))
+	}
+	if res.marker || res.markerRemoved {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
 	w.WriteHeader(res.status)
 }
+
+type delResult struct {
+	returnVersion string
+	marker        bool // создан delete-marker
+	markerRemoved bool // по versionId удалён сам delete-marker
+	locked        bool // 403 из-за Object Lock, а не окна защиты
+	status        int
+}
+
+// deleteObjectTx — удаление объекта (delete-marker) или конкретной версии внутри
+// транзакции. status: 204, 404 (нет версии) или 403 (окно защиты, Object Lock).
+// bypassGovernance снимает retention GOVERNANCE, но не COMPLIANCE и не legal hold.
+func (s *Server) deleteObjectTx(ctx context.Context, tx *gorm.DB, log *slog.Logger, bucketID uint, key, versionID string, bypassGovernance bool) (delResult, error) {
+	if err := s.db.LockObjectForUpdate(tx, bucketID, key); err != nil {
+		log.Error("delete_object.lock_fail", "err", err)
+		return delResult{}, err
+	}
+
+	bkt, err := s.db.FindBucketByID(bucketID)
+	if err != nil {
+		return delResult{}, err
+	}
+
+	// 0) Disabled: ключ удаляется насовсем вместе с блобами
+	if versionID == "" && bkt.Versioning == db.VersioningDisabled {
+		dropped, err := s.dropKeyVersionsTx(tx, bkt, key)
+		if err != nil {
+			log.Error("delete_object.drop_versions_fail", "err", err)
+			return delResult{}, err
+		}
+		for _, id := range dropped {
+			if s.reclaimOrphanTx(ctx, tx, id) {
+				log.Info("delete_object.blob_gc", "blob_id", id)
+			}
+		}
+		left, err := s.db.ListKeyVersionsTx(tx, bucketID, key)
+		if err != nil {
+			return delResult{}, err
+		}
+		if len(left) == 0 {
+			if err := s.db.DeleteObjectTx(tx, bucketID, key); err != nil {
+				log.Error("delete_object.delete_row_fail", "err", err)
+				return delResult{}, err
+			}
+			log.Info("delete_object.ok_removed", "blobs", len(dropped))
+			return delResult{status: http.StatusNoContent}, nil
+		}
+		// остались версии под окном защиты — прячем их delete-marker'ом
+	}
+
+	// 1) Без versionId — мягкое удаление (delete‑marker)
+	if versionID == "" {
+		dm, err := s.newVersionIDTx(tx, bucketID, key)
+		if err != nil {
+			log.Error("delete_object.version_id_fail", "err", err)
+			return delResult{}, err
+		}
+		if err := s.db.CreateDeleteMarkerTx(tx, bucketID, key, dm); err != nil {
+			log.Error("delete_object.create_dm_fail", "err", err)
+			return delResult{}, err
+		}
+		if err := s.db.SetHeadVersionTx(tx, bucketID, key, dm); err != nil {
+			log.Error("delete_object.set_head_fail", "err", err)
+			return delResult{}, err
+		}
+		log.Info("delete_object.ok_delete_marker", "version_id", dm)
+		return delResult{returnVersion: dm, marker: true, status: http.StatusNoContent}, nil
+	}
+
+	// 2) С versionId — удаление указанной версии
+	var ver *db.ObjectVersion
+	if versionID == nullVersionID {
+		ver, err = s.getNullVersionTx(tx, bucketID, key)
+	} else {
+		ver, err = s.db.GetVersionTx(tx, versionID)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		log.Warn("delete_object.no_such_version", "version_id", versionID)
+		// В txn нельзя писать ответ — просто вернём «мягкую» ошибку наружу
+		return delResult{status: http.StatusNotFound}, nil
+	}
+	if err != nil {
+		log.Error("delete_object.get_version_fail", "err", err)
+		return delResult{}, err
+	}
+	if ver.BucketID != bucketID || ver.Key != key {
+		log.Warn("delete_object.version_of_other_key", "version_id", versionID)
+		return delResult{status: http.StatusNotFound}, nil
+	}
+	versionID = ver.VersionID
+	now := time.Now()
+	if versionLocked(ver, now, bypassGovernance) {
+		log.Warn("delete_object.version_locked", "version_id", versionID, "mode", ver.RetentionMode, "legal_hold", ver.LegalHold)
+		return delResult{status: http.StatusForbidden, locked: true}, nil
+	}
+	if inProtectionWindow(bkt, ver, now) {
+		log.Warn("delete_object.version_protected", "version_id", versionID, "protection_days", bkt.ProtectionDays)
+		return delResult{status: http.StatusForbidden}, nil
+	}
+
+	if err := s.removeVersionTx(tx, bkt, versionID); err != nil {
+		log.Error("delete_object.delete_version_fail", "err", err)
+		return delResult{}, err
+	}
+
+	// Если это был HEAD — переставить HEAD на предыдущую (или на delete‑marker)
+	head, _ := s.db.GetHeadVersionTx(tx, bucketID, key)
+	if head == nil || head.VersionID == versionID {
+		if prev, err := s.db.GetPrevVersionTx(tx, bucketID, key, versionID); err == nil && prev != nil {
+			_ = s.db.SetHeadVersionTx(tx, bucketID, key, prev.VersionID)
+			log.Info("delete_object.head_moved", "new_head", prev.VersionID)
+		} else {
+			dm := s.db.GenVersionID()
+			_ = s.db.CreateDeleteMarkerTx(tx, bucketID, key, dm)
+			_ = s.db.SetHeadVersionTx(tx, bucketID, key, dm)
+			log.Info("delete_object.head_set_dm", "dm", dm)
+		}
+	}
+
+	// GC блоба, если осиротел
+	if ver.BlobID != nil && s.reclaimOrphanTx(ctx, tx, *ver.BlobID) {
+		log.Info("delete_object.blob_gc", "blob_id", *ver.BlobID)
+	}
+
+	log.Info("delete_object.ok", "version_id", versionID, "delete_marker", ver.IsDelete)
+	return delResult{returnVersion: versionID, markerRemoved: ver.IsDelete, status: http.StatusNoContent}, nil
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/auth"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// поля формы до файла (AWS: 20 КБ)
+const maxPostFormFields = 20 << 10
+
+var errEntityTooSmall, errEntityTooLarge = errors.New("entity too small"), errors.New("entity too large")
+
+// isPostObject — POST /:bucket с multipart/form-data: браузерная загрузка формой,
+// подпись лежит в полях формы, а не в Authorization.
+func isPostObject(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	p := strings.Trim(r.URL.Path, "/")
+	if p == "" || strings.Contains(p, "/") || strings.HasPrefix(r.URL.Path, adminPrefix) {
+		return false
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == "multipart/form-data"
+}
+
+// lengthRangeReader — content-length-range политики: больше max — ошибка на
+// чтении, меньше min — ошибка вместо EOF, чтобы загрузка не закоммитилась.
+type lengthRangeReader struct {
+	r        io.Reader
+	min, max int64
+	n        int64
+	err      error
+}
+
+func (l *lengthRangeReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		l.err = errEntityTooLarge
+		return 0, l.err
+	}
+	if err == io.EOF && l.n < l.min {
+		l.err = errEntityTooSmall
+		return n, l.err
+	}
+	return n, err
+}
+
+// captureWriter — ответ внутреннего PUT: POST отвечает по-своему
+// (success_action_status / redirect).
+type captureWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *captureWriter) Header() http.Header { return c.header }
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+func (c *captureWriter) Write(p []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	return c.body.Write(p)
+}
+
+// PostResponse — ответ на POST при success_action_status=201
+type PostResponse struct {
+	XMLName  xml.Name `xml:"PostResponse"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// POST /:bucket (multipart/form-data) — загрузка из браузера по подписанной
+// политике. Поля до file собираются, подпись и условия политики проверяются до
+// чтения файла, сам файл идёт через обычный PUT (дедуп, версии, хуки).
+func (s *Server) handlePostObject(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	log.Info("post_object.start")
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedPOSTRequest", "The body of your POST request is not well-formed multipart/form-data.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	fields := map[string]string{} // имена в нижнем регистре
+	var file *multipart.Part
+	budget := maxPostFormFields
+	for file == nil {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "MalformedPOSTRequest", "The body of your POST request is not well-formed multipart/form-data.", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		name := strings.ToLower(part.FormName())
+		if name == "file" {
+			file = part // поля после файла, как и в S3, не читаем
+			break
+		}
+		v, _ := io.ReadAll(io.LimitReader(part, int64(budget)+1))
+		if len(v) > budget {
+			writeS3Error(w, http.StatusBadRequest, "MaxPostPreDataLengthExceeded", "Your POST request fields preceding the upload file were too large.", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		budget -= len(v)
+		if name != "" {
+			fields[name] = string(v)
+		}
+	}
+	if file == nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "POST requires exactly one file upload per request.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if fields["key"] == "" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Bucket POST must contain a field named 'key'.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	key := strings.ReplaceAll(fields["key"], "${filename}", file.FileName())
+	fields["key"] = key
+	log = log.With(slog.String("key", key))
+
+	// подпись и политика; без policy — только анонимно, по ACL/политике бакета
+	var userID uint
+	principal := ""
+	lr := &lengthRangeReader{r: file, max: 1<<63 - 1}
+	if fields["policy"] != "" {
+		ip := sourceIP(r)
+		akid, _, _ := strings.Cut(fields["x-amz-credential"], "/")
+		ipKey, pairKey, _ := authThrottleKeys(ip, akid)
+		if left, locked := s.authThrottle.Locked(ipKey, pairKey); locked {
+			mAuthRejectedLocked.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(left.Seconds())+1))
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "too many failed authentication attempts, try later", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		res, err := auth.VerifyPostPolicy(fields, credProvider{s.db}, auth.VerifyOptions{ExpectedService: "s3"})
+		if err != nil {
+			s.onAuthFailure(r, akid, ip, err)
+			if errors.Is(err, db.ErrNotFound) {
+				status, code, msg := authErrorResponse(err)
+				writeS3Error(w, status, code, msg, r.URL.Path, requestIDFrom(r))
+				return
+			}
+			writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error(), r.URL.Path, requestIDFrom(r))
+			return
+		}
+		s.authThrottle.Success(pairKey)
+		u, err := s.db.FindUserByAccessKey(res.AccessKeyID)
+		if err != nil {
+			writeS3Error(w, http.StatusForbidden, "InvalidAccessKeyId", "The AWS access key Id you provided does not exist in our records.", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		userID, principal = u.ID, u.AccessKeyID
+		setAccessRequester(r, principal)
+		k, err := s.db.FindAccessKey(res.AccessKeyID)
+		if err != nil {
+			log.Error("post_object.key_lookup_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if k.Policy != "" && !keyPolicyAllows(r, k, principal, "s3:PutObject", resourceARN(bucket, key)) {
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "not allowed by the access key policy", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		// AuthMiddleware форму пропускает, лимиты ключа — здесь
+		release, ok := s.acquireKeyLimit(w, r, k.AccessKeyID, u)
+		if !ok {
+			return
+		}
+		defer release()
+
+		pol, err := auth.ParsePostPolicy(fields["policy"])
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPolicyDocument", err.Error(), r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if err := pol.Check(fields, bucket, time.Now()); err != nil {
+			log.Warn("post_object.policy_rejected", "err", err)
+			writeS3Error(w, http.StatusForbidden, "AccessDenied", "Invalid according to Policy: "+err.Error(), r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if pol.HasLengthRange {
+			lr.min, lr.max = pol.MinLength, pol.MaxLength
+		}
+	}
+
+	// внутренний PUT /:bucket/:key с заголовками из полей формы
+	pr := r.Clone(context.WithValue(r.Context(), ctxUserKey, userID))
+	pr.Method = http.MethodPut
+	pr.URL = &url.URL{Path: "/" + bucket + "/" + key}
+	pr.Header = http.Header{}
+	for field, hdr := range map[string]string{
+		"content-type":                 "Content-Type",
+		"acl":                          hdrACL,
+		"x-amz-server-side-encryption": "x-amz-server-side-encryption",
+	} {
+		if v := fields[field]; v != "" {
+			pr.Header.Set(hdr, v)
+		}
+	}
+	pr.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	pr.ContentLength = -1
+	pr.Body = io.NopCloser(lr)
+
+	pr, granted, err := s.checkBucketAccess(pr, userID, principal)
+	if err != nil {
+		writeHookError(w, r, err)
+		return
+	}
+	if userID == 0 && !granted && os.Getenv("ALLOW_INSECURE_NOSIGN") != "1" {
+		log.Warn("post_object.anonymous_denied")
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := s.runRequestHooks("post_auth", pr); err != nil {
+		writeHookError(w, r, err)
+		return
+	}
+
+	cw := &captureWriter{header: http.Header{}}
+	s.handlePut(cw, pr)
+	switch {
+	case errors.Is(lr.err, errEntityTooLarge):
+		writeS3Error(w, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed size", r.URL.Path, requestIDFrom(r))
+		return
+	case errors.Is(lr.err, errEntityTooSmall):
+		writeS3Error(w, http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed size", r.URL.Path, requestIDFrom(r))
+		return
+	case cw.status != http.StatusOK:
+		for k, v := range cw.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(cw.status)
+		_, _ = w.Write(cw.body.Bytes())
+		return
+	}
+
+	etag := cw.header.Get("ETag")
+	loc := (&url.URL{Scheme: "http", Host: r.Host, Path: "/" + bucket + "/" + key}).String()
+	if r.TLS != nil {
+		loc = "https" + strings.TrimPrefix(loc, "http")
+	}
+	w.Header().Set("ETag", etag)
+	if v := cw.header.Get("x-amz-version-id"); v != "" {
+		w.Header().Set("x-amz-version-id", v)
+	}
+	log.Info("post_object.ok", "size", lr.n, "principal", principal)
+
+	redirect := fields["success_action_redirect"]
+	if redirect == "" {
+		redirect = fields["redirect"]
+	}
+	if u, err := url.Parse(redirect); redirect != "" && err == nil && u.IsAbs() {
+		q := u.Query()
+		q.Set("bucket", bucket)
+		q.Set("key", key)
+		q.Set("etag", etag)
+		u.RawQuery = q.Encode()
+		w.Header().Set("Location", u.String())
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Location", loc)
+	switch fields["success_action_status"] {
+	case "200":
+		w.WriteHeader(http.StatusOK)
+	case "201":
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusCreated)
+		_ = xml.NewEncoder(w).Encode(PostResponse{Location: loc, Bucket: bucket, Key: key, ETag: etag})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
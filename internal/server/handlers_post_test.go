@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postForm — тело браузерной загрузки, подписанное секретом ключа.
+func postForm(t *testing.T, accessKey, secret, bucket, key string, body []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	policy := base64.StdEncoding.EncodeToString([]byte(`{"expiration":"` + now.Add(time.Hour).Format(time.RFC3339) +
+		`","conditions":[{"bucket":"` + bucket + `"},["starts-with","$key",""],` +
+		`{"x-amz-algorithm":"AWS4-HMAC-SHA256"},["starts-with","$x-amz-credential",""],["starts-with","$x-amz-date",""]]}`))
+	sign := func(k []byte, data string) []byte {
+		m := hmac.New(sha256.New, k)
+		m.Write([]byte(data))
+		return m.Sum(nil)
+	}
+	sk := []byte("AWS4" + secret)
+	for _, part := range []string{date, "us-east-1", "s3", "aws4_request"} {
+		sk = sign(sk, part)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range [][2]string{
+		{"key", key},
+		{"x-amz-algorithm", "AWS4-HMAC-SHA256"},
+		{"x-amz-credential", accessKey + "/" + date + "/us-east-1/s3/aws4_request"},
+		{"x-amz-date", now.Format("20060102T150405Z")},
+		{"policy", policy},
+		{"x-amz-signature", hex.EncodeToString(sign(sk, policy))},
+	} {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "upload.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(body)
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+// Форму AuthMiddleware пропускает без проверки — лимиты ключа обязан
+// применить сам handlePostObject.
+func TestPostObjectKeyRateLimit(t *testing.T) {
+	e := newRaceEnv(t)
+	if rec := e.do(http.MethodPut, "/forms", nil); rec.Code != http.StatusOK {
+		t.Fatalf("create bucket: %d %s", rec.Code, rec.Body)
+	}
+	// один токен без пополнения: вторая загрузка подряд упирается в лимит
+	e.s.cfg.KeyRateLimitRPS = 0.001
+	e.s.cfg.KeyRateBurst = 1
+
+	for i, want := range []int{http.StatusNoContent, http.StatusServiceUnavailable} {
+		body, ctype := postForm(t, "RACE", "racesecret", "forms", "file.txt", []byte("form data"))
+		req := httptest.NewRequest(http.MethodPost, "/forms", body)
+		req.Header.Set("Content-Type", ctype)
+		rec := httptest.NewRecorder()
+		e.handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("upload %d: %d %s, want %d", i+1, rec.Code, rec.Body, want)
+		}
+		if want == http.StatusServiceUnavailable {
+			if !strings.Contains(rec.Body.String(), "<Code>SlowDown</Code>") || rec.Header().Get("Retry-After") == "" {
+				t.Fatalf("upload %d: %s, Retry-After %q", i+1, rec.Body, rec.Header().Get("Retry-After"))
+			}
+		}
+	}
+}
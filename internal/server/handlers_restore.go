@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+// versionArchivedTx — байты версии архивного класса лежат на втором уровне и
+// без RestoreObject не читаются. Класс, выставленный одним PUT (байты на
+// основном узле), и восстановленная копия читаются как обычно.
+func (s *Server) versionArchivedTx(tx *gorm.DB, ver *db.ObjectVersion) (bool, error) {
+	if !archiveClasses[ver.StorageClass] || ver.BlobID == nil {
+		return false, nil
+	}
+	if !ver.RestoreOngoing && ver.RestoreExpiresAt != nil {
+		return false, nil
+	}
+	chunks, err := s.db.ResolveRangeTx(tx, *ver.BlobID, 0, -1)
+	if err != nil {
+		return false, err
+	}
+	for _, ch := range chunks {
+		if ch.Node == storageNodeTier {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func writeInvalidObjectState(w http.ResponseWriter, r *http.Request) {
+	writeS3Error(w, http.StatusForbidden, "InvalidObjectState",
+		"The operation is not valid for the object's storage class", r.URL.Path, requestIDFrom(r))
+}
+
+// restoreExpiry — до какого момента живёт восстановленная копия: days дней от
+// now с округлением вверх до полуночи UTC, как в S3.
+func restoreExpiry(now time.Time, days int) time.Time {
+	return now.UTC().AddDate(0, 0, days).Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// setRestoreHeader — x-amz-restore в ответе HEAD/GET, если восстановление запрашивали.
+func setRestoreHeader(w http.ResponseWriter, ver *db.ObjectVersion) {
+	switch {
+	case ver.RestoreOngoing:
+		w.Header().Set("x-amz-restore", `ongoing-request="true"`)
+	case ver.RestoreExpiresAt != nil:
+		w.Header().Set("x-amz-restore",
+			`ongoing-request="false", expiry-date="`+ver.RestoreExpiresAt.UTC().Format(http.TimeFormat)+`"`)
+	}
+}
+
+// requestRestoreTx ставит восстановление версии в очередь (202), продлевает срок
+// уже восстановленной копии (200) или отвечает 409, если перенос ещё идёт.
+func (s *Server) requestRestoreTx(tx *gorm.DB, ver *db.ObjectVersion, days int) (int, error) {
+	if err := s.db.LockObjectForUpdate(tx, ver.BucketID, ver.Key); err != nil {
+		return 0, err
+	}
+	cur, err := s.db.GetVersionTx(tx, ver.VersionID)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case cur.RestoreOngoing:
+		return http.StatusConflict, nil
+	case cur.RestoreExpiresAt != nil:
+		return http.StatusOK, s.db.UpdateVersionFieldsTx(tx, cur.VersionID, map[string]any{
+			"restore_days":       days,
+			"restore_expires_at": restoreExpiry(time.Now(), days),
+		})
+	}
+	return http.StatusAccepted, s.db.UpdateVersionFieldsTx(tx, cur.VersionID, map[string]any{
+		"restore_days":    days,
+		"restore_ongoing": true,
+	})
+}
+
+// POST /:bucket/:key?restore[&versionId=ID]
+// Байты переносит на основной узел lifecycle-воркер (202 Accepted), повторный
+// запрос к восстановленной версии продлевает срок копии (200 OK).
+func (s *Server) handleRestoreObject(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+
+	var req RestoreRequest
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil || req.Days < 1 {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	switch req.Tier {
+	case "", "Expedited", "Standard", "Bulk":
+	default:
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	ver, ok := s.versionTarget(w, r, log)
+	if !ok {
+		return
+	}
+	if !archiveClasses[ver.StorageClass] {
+		writeS3Error(w, http.StatusForbidden, "InvalidObjectState",
+			"Restore is not allowed for the object's current storage class", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	var status int
+	err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		var err error
+		status, err = s.requestRestoreTx(tx, ver, req.Days)
+		return err
+	})
+	if err != nil {
+		log.Error("restore.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if status == http.StatusConflict {
+		writeS3Error(w, http.StatusConflict, "RestoreAlreadyInProgress", "Object restore is already in progress", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(status)
+	log.Info("restore.ok", "version_id", ver.VersionID, "days", req.Days, "tier", req.Tier, "status", status)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+	"github.com/DanikLP1/s3-storage-service/internal/s3select"
+)
+
+var mSelect = metrics.NewCounterVec("s3mini_select_requests_total",
+	"SelectObjectContent requests by result.", "result") // ok|error
+
+// POST /:bucket/:key?select&select-type=2 — SelectObjectContent: SQL по CSV/JSON
+// на стороне сервера, результат — event stream (Records, Stats, End).
+func (s *Server) handleSelectObject(w http.ResponseWriter, r *http.Request) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("key", key))
+	log.Info("select_object.start")
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if r.URL.Query().Get("select-type") != "2" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "select-type must be 2", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	var req s3select.Request
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 256<<10)).Decode(&req); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse SelectObjectContentRequest", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	sel, err := s3select.Prepare(&req)
+	if err != nil {
+		var se *s3select.Error
+		errors.As(err, &se)
+		status := http.StatusBadRequest
+		if se.Code == "NotImplemented" {
+			status = http.StatusNotImplemented
+		}
+		log.Info("select_object.bad_request", "code", se.Code, "err", se.Message)
+		mSelect.Inc("error")
+		writeS3Error(w, status, se.Code, se.Message, r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	bucketID, err := s.db.BucketIDByName(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("select_object.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	versionID := r.URL.Query().Get("versionId")
+	ver, err := s.resolveVersionTx(s.db.DB, bucketID, key, versionID)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("select_object.db_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if !checkGetEncryptionContext(w, r, ver.EncryptionContext) {
+		return
+	}
+
+	rc, err := s.openBlob(r.Context(), *ver.BlobID, 0, -1)
+	if err != nil {
+		log.Error("select_object.read_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "read error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	w.WriteHeader(http.StatusOK)
+	rcw := http.NewResponseController(w)
+	emit := func(msg []byte) error {
+		if _, err := w.Write(msg); err != nil {
+			return err
+		}
+		_ = rcw.Flush()
+		return nil
+	}
+	st, err := sel.Run(r.Context(), rc, emit)
+	if err != nil {
+		// статус уже отправлен — ошибка идёт сообщением потока
+		code, msg := "InternalError", "We encountered an internal error. Please try again."
+		var se *s3select.Error
+		if errors.As(err, &se) {
+			code, msg = se.Code, se.Message
+		} else {
+			log.Error("select_object.run_fail", "err", err)
+		}
+		_ = emit(s3select.ErrorMessage(code, msg))
+		mSelect.Inc("error")
+		log.Warn("select_object.failed", "code", code, "err", msg, "scanned", st.BytesScanned)
+		return
+	}
+	mSelect.Inc("ok")
+	log.Info("select_object.ok", "scanned", st.BytesScanned, "processed", st.BytesProcessed, "returned", st.BytesReturned)
+}
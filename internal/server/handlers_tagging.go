@@ -0,0 +1,294 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+// лимиты S3 на теги
+const (
+	maxObjectTags  = 10
+	maxBucketTags  = 50
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+)
+
+const hdrTagging = "x-amz-tagging"
+
+// validateTags — лимиты S3: не больше max тегов, ключ 1..128 и значение до 256 символов,
+// ключи уникальны, префикс aws: зарезервирован.
+func validateTags(kv [][2]string, max int) (map[string]string, error) {
+	if len(kv) > max {
+		return nil, fmt.Errorf("tags cannot be greater than %d", max)
+	}
+	out := make(map[string]string, len(kv))
+	for _, t := range kv {
+		k, v := t[0], t[1]
+		if n := utf8.RuneCountInString(k); n == 0 || n > maxTagKeyLen {
+			return nil, fmt.Errorf("the tag key must be 1..%d characters", maxTagKeyLen)
+		}
+		if utf8.RuneCountInString(v) > maxTagValueLen {
+			return nil, fmt.Errorf("the tag value must be at most %d characters", maxTagValueLen)
+		}
+		if strings.HasPrefix(k, "aws:") {
+			return nil, fmt.Errorf("tag keys with the aws: prefix are reserved")
+		}
+		if _, dup := out[k]; dup {
+			return nil, fmt.Errorf("cannot provide multiple tags with the same key")
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// parseTaggingHeader — x-amz-tagging в виде query-строки "k1=v1&k2=v2".
+func parseTaggingHeader(h http.Header) (map[string]string, error) {
+	raw := h.Get(hdrTagging)
+	if raw == "" {
+		return nil, nil
+	}
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return nil, fmt.Errorf("x-amz-tagging must be URL query encoded")
+	}
+	kv := make([][2]string, 0, len(q))
+	for k, vs := range q {
+		for _, v := range vs {
+			kv = append(kv, [2]string{k, v})
+		}
+	}
+	return validateTags(kv, maxObjectTags)
+}
+
+// readTaggingBody — XML Tagging из тела запроса, проверенный validateTags.
+func readTaggingBody(w http.ResponseWriter, r *http.Request, max int) (map[string]string, bool) {
+	var req Tagging
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse tagging xml", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	kv := make([][2]string, 0, len(req.TagSet))
+	for _, t := range req.TagSet {
+		kv = append(kv, [2]string{t.Key, t.Value})
+	}
+	tags, err := validateTags(kv, max)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidTag", err.Error(), r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return tags, true
+}
+
+func tagsToXML(tags []db.ObjectVersionTag) Tagging {
+	out := Tagging{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/", TagSet: make([]TagXML, 0, len(tags))}
+	for _, t := range tags {
+		out.TagSet = append(out.TagSet, TagXML{Key: t.Key, Value: t.Value})
+	}
+	return out
+}
+
+// versionTarget — версия объекта для подресурсов (?tagging, ?acl); ошибки отдаёт сам.
+func (s *Server) versionTarget(w http.ResponseWriter, r *http.Request, log *slog.Logger) (*db.ObjectVersion, bool) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	bucketID, err := s.db.BucketIDByName(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, false
+	}
+	if err != nil {
+		log.Error("object_subresource.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	versionID := r.URL.Query().Get("versionId")
+	ver, err := s.resolveVersionTx(s.db.DB, bucketID, key, versionID)
+	switch {
+	case errors.Is(err, errIsDeleteMarker):
+		// у delete-marker'а нет ни тегов, ни ACL, как в S3
+		writeDeleteMarker(w, r, ver, versionID)
+		return nil, false
+	case errors.Is(err, db.ErrNotFound) && versionID != "":
+		writeS3Error(w, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	case err != nil:
+		log.Error("object_subresource.version_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return ver, true
+}
+
+// PUT /:bucket/:key?tagging[&versionId=ID]
+func (s *Server) handlePutObjectTagging(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	tags, ok := readTaggingBody(w, r, maxObjectTags)
+	if !ok {
+		return
+	}
+	ver, ok := s.versionTarget(w, r, log)
+	if !ok {
+		return
+	}
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		return s.db.ReplaceVersionTagsTx(tx, ver, tags)
+	}); err != nil {
+		log.Error("tagging.put.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	w.WriteHeader(http.StatusOK)
+	log.Info("tagging.put.ok", "version_id", ver.VersionID, "tags", len(tags))
+}
+
+// GET /:bucket/:key?tagging[&versionId=ID]
+func (s *Server) handleGetObjectTagging(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	ver, ok := s.versionTarget(w, r, log)
+	if !ok {
+		return
+	}
+	tags, err := s.db.ListVersionTagsTx(s.db.DB, ver.VersionID)
+	if err != nil {
+		log.Error("tagging.get.db_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(tagsToXML(tags))
+}
+
+// DELETE /:bucket/:key?tagging[&versionId=ID]
+func (s *Server) handleDeleteObjectTagging(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	ver, ok := s.versionTarget(w, r, log)
+	if !ok {
+		return
+	}
+	if err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		return s.db.ReplaceVersionTagsTx(tx, ver, nil)
+	}); err != nil {
+		log.Error("tagging.delete.tx_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "tx error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("tagging.delete.ok", "version_id", ver.VersionID)
+}
+
+// setTaggingCount — x-amz-tagging-count на GET/HEAD, если теги есть.
+func (s *Server) setTaggingCount(w http.ResponseWriter, versionID string) {
+	if n, err := s.db.CountVersionTags(versionID); err == nil && n > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.FormatInt(n, 10))
+	}
+}
+
+// sameTagsTx — набор тегов версии совпадает с want (для компакции PUT).
+func (s *Server) sameTagsTx(tx *gorm.DB, versionID string, want map[string]string) (bool, error) {
+	cur, err := s.db.ListVersionTagsTx(tx, versionID)
+	if err != nil || len(cur) != len(want) {
+		return false, err
+	}
+	for _, t := range cur {
+		if v, ok := want[t.Key]; !ok || v != t.Value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// PUT /:bucket?tagging
+func (s *Server) handlePutBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	tags, ok := readTaggingBody(w, r, maxBucketTags)
+	if !ok {
+		return
+	}
+	b, ok := s.taggingBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if err := s.db.SetBucketTags(b.ID, tags); err != nil {
+		log.Error("bucket_tagging.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("bucket_tagging.put.ok", "tags", len(tags))
+}
+
+// GET /:bucket?tagging
+func (s *Server) handleGetBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.taggingBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	tags, err := s.db.ListBucketTags(b.ID)
+	if err != nil {
+		log.Error("bucket_tagging.get.db_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if len(tags) == 0 {
+		writeS3Error(w, http.StatusNotFound, "NoSuchTagSet", "The TagSet does not exist", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	out := Tagging{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/", TagSet: make([]TagXML, 0, len(tags))}
+	for _, t := range tags {
+		out.TagSet = append(out.TagSet, TagXML{Key: t.Key, Value: t.Value})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(out)
+}
+
+// DELETE /:bucket?tagging
+func (s *Server) handleDeleteBucketTagging(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.taggingBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if err := s.db.SetBucketTags(b.ID, nil); err != nil {
+		log.Error("bucket_tagging.delete.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Info("bucket_tagging.delete.ok")
+}
+
+func (s *Server) taggingBucket(w http.ResponseWriter, r *http.Request, bucket string, log *slog.Logger) (*db.Bucket, bool) {
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, false
+	case err != nil:
+		log.Error("bucket_tagging.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return b, true
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var mVerifyBlobs = metrics.NewCounterVec("s3mini_verify_blobs_total",
+	"Blobs re-verified on demand, by result.", "result")
+
+const (
+	verifyOK      = "ok"
+	verifyCorrupt = "corrupt"
+	verifyMissing = "missing"
+)
+
+// POST /:bucket/:key?verify[&versionId=] — перечитать байты версии, пересчитать
+// sha256 каждого plain-блоба и сравнить с записанным. Владелец бакета или админ.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	bucket, key, err := parseBucketKey(r.URL.Path)
+	log := loggerFrom(r).With(slog.String("bucket", bucket), slog.String("key", key))
+	log.Info("verify_object.start")
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	bkt, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	if errors.Is(err, db.ErrNotFound) && s.isAdmin(r) {
+		bkt, err = s.db.FindBucketByName(bucket)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("verify_object.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	ver, err := s.resolveVersionTx(s.db.DB, bkt.ID, key, r.URL.Query().Get("versionId"))
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err != nil {
+		log.Error("verify_object.version_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	chunks, err := s.db.ResolveRangeTx(s.db.DB, *ver.BlobID, 0, -1)
+	if err != nil {
+		log.Error("verify_object.resolve_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+
+	now := time.Now().UTC()
+	res := VerifyObjectResult{
+		Key: key, VersionId: apiVersionID(ver.VersionID), Status: verifyOK,
+		ETag: coalesce(ver.ETag, ""), Size: coalesce(ver.Size, 0), VerifiedAt: now.Format(timeRFC3339),
+	}
+	seen := map[string]bool{}
+	for _, ch := range chunks {
+		if seen[ch.BlobID] {
+			continue
+		}
+		seen[ch.BlobID] = true
+		br, err := s.verifyBlob(r.Context(), ch.BlobID)
+		if err != nil {
+			log.Error("verify_object.blob_fail", "blob_id", ch.BlobID, "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "verify error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if err := s.db.MarkBlobVerified(br.BlobId, br.Status, now); err != nil {
+			log.Warn("verify_object.mark_fail", "blob_id", br.BlobId, "err", err)
+		}
+		mVerifyBlobs.Inc(br.Status)
+		if br.Status != verifyOK && res.Status == verifyOK {
+			res.Status = br.Status
+		}
+		res.Blobs = append(res.Blobs, br)
+	}
+
+	// ETag вида sha256:<hex> или md5 одного PUT — это хэш всего тела, его тоже сверяем
+	if want, h := bodyETagHash(res.ETag); h != nil && res.Status == verifyOK {
+		got, _, err := s.hashStream(r.Context(), *ver.BlobID, h)
+		if err != nil {
+			log.Error("verify_object.etag_hash_fail", "err", err)
+			writeS3Error(w, http.StatusInternalServerError, "InternalError", "verify error", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		match := got == want
+		res.ETagMatch = &match
+		if !match {
+			res.Status = verifyCorrupt
+		}
+	}
+
+	if res.Status != verifyOK {
+		log.Warn("verify_object.mismatch", "version_id", ver.VersionID, "status", res.Status)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(res)
+	log.Info("verify_object.done", "version_id", ver.VersionID, "status", res.Status, "blobs", len(res.Blobs))
+}
+
+// verifyBlob перечитывает plain-блоб целиком и сверяет sha256 и размер.
+func (s *Server) verifyBlob(ctx context.Context, blobID string) (VerifyBlobResult, error) {
+	b, err := s.db.GetBlob(blobID)
+	if err != nil {
+		return VerifyBlobResult{}, err
+	}
+	br := VerifyBlobResult{BlobId: b.ID, Expected: b.Checksum, Size: b.Size}
+	if _, ok, err := s.storage.StatNode(ctx, b.StorageNode, b.ID); err != nil {
+		return br, err
+	} else if !ok {
+		br.Status = verifyMissing
+		return br, nil
+	}
+	sum, n, err := s.hashStream(ctx, b.ID, sha256.New())
+	if err != nil {
+		return br, err
+	}
+	br.Actual, br.ReadSize = "sha256:"+sum, n
+	br.Status = verifyOK
+	if br.Actual != b.Checksum || n != b.Size {
+		br.Status = verifyCorrupt
+	}
+	return br, nil
+}
+
+// bodyETagHash — хэш, которым посчитан ETag всего тела: sha256 для "sha256:<hex>",
+// md5 для 32 hex-символов одного PUT. Для multipart ("<hex>-N") и manifest — nil.
+func bodyETagHash(etag string) (string, hash.Hash) {
+	v := stripQuotes(etag)
+	if want, ok := strings.CutPrefix(v, "sha256:"); ok {
+		return want, sha256.New()
+	}
+	if _, err := hex.DecodeString(v); err == nil && len(v) == 2*md5.Size {
+		return v, md5.New()
+	}
+	return "", nil
+}
+
+// hashStream — хэш h и длина тела блоба (manifest читается по кускам).
+func (s *Server) hashStream(ctx context.Context, blobID string, h hash.Hash) (string, int64, error) {
+	rc, err := s.openBlob(ctx, blobID, 0, -1)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// PUT /:bucket?versioning — Enabled | Suspended | Disabled. При Suspended новые
+// версии и delete-marker'ы пишутся как null-версия ключа и заменяют прежнюю
+// null-версию; версии, записанные до этого, остаются. Disabled (расширение s3mini)
+// истории не держит вовсе: PUT заменяет все версии ключа, DELETE удаляет ключ.
+func (s *Server) handlePutBucketVersioning(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	var req VersioningConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse versioning xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	switch req.Status {
+	case db.VersioningEnabled, db.VersioningSuspended, db.VersioningDisabled:
+	default:
+		writeS3Error(w, http.StatusBadRequest, "IllegalVersioningConfigurationException",
+			"The versioning status must be Enabled, Suspended or Disabled", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if req.MfaDelete == "Enabled" {
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "MFA delete is not supported", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Error("versioning.put.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if b.ObjectLockEnabled && req.Status != db.VersioningEnabled {
+		writeS3Error(w, http.StatusConflict, "InvalidBucketState",
+			"An Object Lock configuration is present on this bucket, so the versioning state cannot be changed.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{"versioning": req.Status}); err != nil {
+		log.Error("versioning.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("versioning.put.ok", "status", req.Status, "was", b.Versioning)
+}
+
+// GET /:bucket?versioning
+func (s *Server) handleGetBucketVersioning(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Error("versioning.get.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(VersioningConfiguration{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Status: b.Versioning,
+	})
+}
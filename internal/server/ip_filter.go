@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// Фильтр бакета по адресу клиента (?settings, IPFilter) — упрощённая замена
+// условия aws:SourceIp в политике: проверяется до политики и ACL для всех,
+// включая владельца.
+
+func splitCIDRs(s string) []string {
+	var out []string
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// parseCIDR принимает и голый адрес (как /32 или /128).
+func parseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.New("bad ip")
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+func ipInAny(ip net.IP, cidrs []string) bool {
+	for _, c := range cidrs {
+		if n, err := parseCIDR(c); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed — адрес клиента проходит фильтр бакета: не в Deny и, если Allow
+// задан, в Allow.
+func ipAllowed(b *db.Bucket, r *http.Request) bool {
+	if b.IPAllow == "" && b.IPDeny == "" {
+		return true
+	}
+	ip := net.ParseIP(sourceIP(r))
+	if ip == nil {
+		return false
+	}
+	if ipInAny(ip, splitCIDRs(b.IPDeny)) {
+		return false
+	}
+	allow := splitCIDRs(b.IPAllow)
+	return len(allow) == 0 || ipInAny(ip, allow)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/policy"
+)
+
+// Ключи приложений: к ключу доступа привязывается политика в формате IAM
+// (identity-based, без Principal), и запрос по такому ключу проходит, только
+// если она явно разрешает действие. Права владельца ключа это не расширяет —
+// политика и ACL бакета проверяются как обычно.
+
+const (
+	scopeReadOnly  = "read-only"
+	scopeWriteOnly = "write-only"
+	scopeReadWrite = "read-write"
+)
+
+// ctxAccessKeyKey — ключ, которым подписан запрос, и его владелец: по ним
+// обработчики проверяют источники, которых нет в URL и заголовках (compose).
+const ctxAccessKeyKey ctxKey = "auth.accessKey"
+
+type requestKey struct {
+	key       *db.AccessKey
+	principal string
+}
+
+// keyScope — короткая запись типичной политики ключа приложения: бакеты,
+// префикс ключей и режим доступа.
+type keyScope struct {
+	Buckets []string `json:"buckets"`
+	Prefix  string   `json:"prefix,omitempty"`
+	Access  string   `json:"access"` // read-only|write-only|read-write
+}
+
+// Запись перечислена поимённо: шаблон s3:PutObject* открыл бы и ACL,
+// retention и legal hold — ключ на запись сделал бы объект публичным или
+// запер бы его под Object Lock.
+var (
+	scopeReadObjectActions  = []string{"s3:GetObject*"}
+	scopeWriteObjectActions = []string{"s3:PutObject", "s3:PutObjectTagging", "s3:DeleteObject", "s3:DeleteObjectVersion",
+		"s3:AbortMultipartUpload", "s3:ListMultipartUploadParts", "s3:RestoreObject"}
+	scopeReadBucketActions  = []string{"s3:ListBucket", "s3:ListBucketVersions"}
+	scopeWriteBucketActions = []string{"s3:ListBucketMultipartUploads"}
+)
+
+// policy разворачивает короткую запись в JSON политики ключа.
+func (sc keyScope) policy() (string, error) {
+	if len(sc.Buckets) == 0 {
+		return "", errors.New("scope.buckets is required")
+	}
+	var objActs, bucketActs []string
+	switch sc.Access {
+	case scopeReadOnly:
+		objActs, bucketActs = scopeReadObjectActions, scopeReadBucketActions
+	case scopeWriteOnly:
+		objActs, bucketActs = scopeWriteObjectActions, scopeWriteBucketActions
+	case scopeReadWrite:
+		objActs = append(append([]string{}, scopeReadObjectActions...), scopeWriteObjectActions...)
+		bucketActs = append(append([]string{}, scopeReadBucketActions...), scopeWriteBucketActions...)
+	default:
+		return "", errors.New("scope.access must be read-only, write-only or read-write")
+	}
+	var objRes, bucketRes []string
+	for _, b := range sc.Buckets {
+		if b == "" || strings.ContainsAny(b, "/") {
+			return "", errors.New("scope.buckets must be bucket names")
+		}
+		bucketRes = append(bucketRes, resourceARN(b, ""))
+		objRes = append(objRes, resourceARN(b, sc.Prefix+"*"))
+	}
+
+	type statement struct {
+		Effect    string
+		Action    []string
+		Resource  []string
+		Condition map[string]map[string]string `json:",omitempty"`
+	}
+	list := statement{Effect: "Allow", Action: bucketActs, Resource: bucketRes}
+	// листинг — только внутри префикса, как s3:prefix в политиках AWS
+	if sc.Prefix != "" {
+		list.Condition = map[string]map[string]string{"StringLike": {"s3:prefix": sc.Prefix + "*"}}
+	}
+	doc := struct {
+		Version   string
+		Statement []statement
+	}{
+		Version: "2012-10-17",
+		Statement: []statement{
+			{Effect: "Allow", Action: objActs, Resource: objRes},
+			list,
+			{Effect: "Allow", Action: []string{"s3:GetBucketLocation"}, Resource: bucketRes},
+		},
+	}
+	out, err := json.Marshal(doc)
+	return string(out), err
+}
+
+// checkKeyScope — политика ключа приложения. Без явного Allow — отказ, как у
+// identity policy в IAM. Админский API таким ключам закрыт ещё в AuthMiddleware.
+func (s *Server) checkKeyScope(r *http.Request, k *db.AccessKey, principal string) error {
+	if k.Policy == "" {
+		return nil
+	}
+	denied := &HookError{Status: http.StatusForbidden, Code: "AccessDenied", Message: "not allowed by the access key policy"}
+	allows := func(action, resource string) bool { return keyPolicyAllows(r, k, principal, action, resource) }
+
+	p := strings.Trim(r.URL.Path, "/")
+	if p == "" {
+		if !allows("s3:ListAllMyBuckets", "*") {
+			return denied
+		}
+		return nil
+	}
+	bucket, key, _ := strings.Cut(p, "/")
+	if action := s3Action(r, key); !allows(action, resourceARN(bucket, key)) {
+		loggerFrom(r).Info("key_policy.denied", "access_key", k.AccessKeyID, "action", action)
+		return denied
+	}
+
+	if v := r.Header.Get(hdrCopySource); v != "" && r.Method == http.MethodPut {
+		srcBucket, srcKey, srcVer, err := parseCopySource(v)
+		if err != nil {
+			return nil // ошибку формата вернёт обработчик
+		}
+		srcAction := "s3:GetObject"
+		if srcVer != "" {
+			srcAction = "s3:GetObjectVersion"
+		}
+		if !allows(srcAction, resourceARN(srcBucket, srcKey)) ||
+			(isMoveRequest(r) && !allows("s3:DeleteObject", resourceARN(srcBucket, srcKey))) {
+			loggerFrom(r).Info("key_policy.copy_source_denied", "access_key", k.AccessKeyID, "src_bucket", srcBucket)
+			return denied
+		}
+	}
+	return nil
+}
+
+// keyPolicyAllows — политика ключа явно разрешает действие над ресурсом.
+func keyPolicyAllows(r *http.Request, k *db.AccessKey, principal, action, resource string) bool {
+	pol, err := policy.ParseIdentity([]byte(k.Policy))
+	if err != nil {
+		// сохраняется только проверенная политика; сюда попадаем лишь при порче
+		loggerFrom(r).Error("key_policy.parse_fail", "access_key", k.AccessKeyID, "err", err)
+		return false
+	}
+	return pol.Evaluate(policy.Request{
+		Principal: principal,
+		Action:    action,
+		Resource:  resource,
+		Context:   policyContext(r, principal),
+	}) == policy.Allow
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// Режимы scope открывают только перечисленные действия: ключ на запись
+// загружает и удаляет, но не трогает ACL, retention и legal hold.
+func TestKeyScopeActions(t *testing.T) {
+	s := &Server{}
+	tests := []struct {
+		access, method, target string
+		allowed                bool
+	}{
+		{scopeWriteOnly, http.MethodPut, "/photos/app1/a.jpg", true},
+		{scopeWriteOnly, http.MethodPut, "/photos/app1/a.jpg?tagging", true},
+		{scopeWriteOnly, http.MethodDelete, "/photos/app1/a.jpg", true},
+		{scopeWriteOnly, http.MethodDelete, "/photos/app1/a.jpg?versionId=v1", true},
+		{scopeWriteOnly, http.MethodPut, "/photos/app1/a.jpg?acl", false},
+		{scopeWriteOnly, http.MethodPut, "/photos/app1/a.jpg?retention", false},
+		{scopeWriteOnly, http.MethodPut, "/photos/app1/a.jpg?legal-hold", false},
+		{scopeWriteOnly, http.MethodDelete, "/photos/app1/a.jpg?tagging", false},
+		{scopeWriteOnly, http.MethodGet, "/photos/app1/a.jpg", false},
+		{scopeWriteOnly, http.MethodPut, "/photos/other/a.jpg", false},
+		{scopeReadWrite, http.MethodPut, "/photos/app1/a.jpg?acl", false},
+		{scopeReadWrite, http.MethodGet, "/photos/app1/a.jpg", true},
+		{scopeReadOnly, http.MethodPut, "/photos/app1/a.jpg", false},
+		{scopeReadOnly, http.MethodGet, "/photos/app1/a.jpg", true},
+	}
+	for _, tc := range tests {
+		pol, err := keyScope{Buckets: []string{"photos"}, Prefix: "app1/", Access: tc.access}.policy()
+		if err != nil {
+			t.Fatalf("%s: policy: %v", tc.access, err)
+		}
+		k := &db.AccessKey{AccessKeyID: "APPKEY", Policy: pol}
+		r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(""))
+		err = s.checkKeyScope(r, k, "OWNER")
+		if allowed := err == nil; allowed != tc.allowed {
+			t.Errorf("%s %s %s: allowed=%v, want %v (%v)", tc.access, tc.method, tc.target, allowed, tc.allowed, err)
+			continue
+		}
+		if err != nil {
+			if he, ok := err.(*HookError); !ok || he.Code != "AccessDenied" {
+				t.Errorf("%s %s %s: %v, want AccessDenied", tc.access, tc.method, tc.target, err)
+			}
+		}
+	}
+}
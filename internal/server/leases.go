@@ -0,0 +1,26 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+)
+
+// holdLease — может ли этот процесс сейчас выполнять проход воркера name.
+// TTL чуть больше периода воркера: пока мы живы и продлеваем, чужой не возьмёт.
+func (s *Server) holdLease(log *slog.Logger, name string, every time.Duration) bool {
+	ok, err := s.db.AcquireLease(name, s.leaseHolder, every+time.Minute)
+	if err != nil {
+		log.Error("lease.acquire_fail", "lease", name, "err", err)
+		return false
+	}
+	if !ok {
+		log.Info("lease.held_elsewhere", "lease", name)
+	}
+	return ok
+}
+
+// ReleaseLeases отдаёт lease'ы воркеров — вызывается при остановке, чтобы новый
+// процесс (перезапуск без простоя) подхватил их сразу, а не по истечении TTL.
+func (s *Server) ReleaseLeases() error {
+	return s.db.ReleaseLeases(s.leaseHolder)
+}
@@ -2,11 +2,14 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/DanikLP1/s3-storage-service/internal/db"
-	"github.com/DanikLP1/s3-storage-service/internal/logging"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
 	"gorm.io/gorm"
 )
 
@@ -20,7 +23,7 @@ type LifecycleWorker struct {
 func (s *Server) StartLifecycle(ctx context.Context, every time.Duration, batch int) {
 	lw := &LifecycleWorker{
 		s: s, Every: every, Batch: batch,
-		logger: logging.New(logging.Config{Level: "info", JSON: true}).With(slog.String("comp", "lifecycle")),
+		logger: s.Logger.With(slog.String("comp", "lifecycle")),
 	}
 	go lw.run(ctx)
 }
@@ -36,13 +39,18 @@ func (lw *LifecycleWorker) run(ctx context.Context) {
 			lw.logger.Info("lyfecycle.stopped")
 			return
 		case <-t.C:
-			lw.onePass(ctx)
+			if !lw.s.isReadOnly() && lw.s.holdLease(lw.logger, "lifecycle", lw.Every) {
+				lw.onePass(ctx)
+			}
 		}
 	}
 }
 
 func (lw *LifecycleWorker) onePass(ctx context.Context) {
 	start := time.Now()
+	lw.compactVersions(ctx)
+	lw.restoreObjects(ctx)
+
 	rules, err := lw.s.db.ListEnabledLifecycleRules()
 	if err != nil {
 		lw.logger.Error("rules_load_fail", "err", err)
@@ -64,11 +72,11 @@ func (lw *LifecycleWorker) onePass(ctx context.Context) {
 		// 1) Noncurrent expiration: по возрасту
 		if rule.ExpireNoncurrentAfterDays != nil && *rule.ExpireNoncurrentAfterDays >= 0 {
 			cut := time.Now().AddDate(0, 0, -*rule.ExpireNoncurrentAfterDays)
-			vers, err := lw.s.db.ListNoncurrentByAge(rule.BucketID, rule.Prefix, cut, lw.Batch)
+			vers, err := lw.s.db.ListNoncurrentByAge(&rule, cut, lw.Batch)
 			if err != nil {
 				rlog.Error("noncurrent_query_fail", "err", err)
 			} else {
-				changed := lw.deleteVersionsTx(ctx, vers, "noncurrent_deleted")
+				changed := lw.deleteVersionsTx(ctx, vers, "noncurrent_deleted", nil)
 				totalChanged += changed
 				if changed > 0 {
 					rlog.Info("noncurrent_deleted", "count", changed)
@@ -78,11 +86,11 @@ func (lw *LifecycleWorker) onePass(ctx context.Context) {
 
 		// 1b) Nucurrent keep newest K
 		if rule.NoncurrentNewerVersionsToKeep != nil && *rule.NoncurrentNewerVersionsToKeep >= 0 {
-			vers, err := lw.s.db.ListNoncurrentKeepNewest(rule.BucketID, rule.Prefix, *rule.NoncurrentNewerVersionsToKeep, lw.Batch)
+			vers, err := lw.s.db.ListNoncurrentKeepNewest(&rule, *rule.NoncurrentNewerVersionsToKeep, lw.Batch)
 			if err != nil {
 				rlog.Error("noncurrent_keep_query_fail", "err", err)
 			} else {
-				changed := lw.deleteVersionsTx(ctx, vers, "nocurrent_pruned")
+				changed := lw.deleteVersionsTx(ctx, vers, "nocurrent_pruned", nil)
 				totalChanged += changed
 				if changed > 0 {
 					rlog.Info("noncurrent_pruned", "count", changed, "keep", *rule.NoncurrentNewerVersionsToKeep)
@@ -93,7 +101,7 @@ func (lw *LifecycleWorker) onePass(ctx context.Context) {
 		// 2) Purge delete-markers
 		if rule.PurgeDeleteMarkersAfterDays != nil && *rule.PurgeDeleteMarkersAfterDays >= 0 {
 			cut := time.Now().AddDate(0, 0, -*rule.PurgeDeleteMarkersAfterDays)
-			dms, err := lw.s.db.ListDeleteMarkersForPurge(rule.BucketID, rule.Prefix, cut, lw.Batch)
+			dms, err := lw.s.db.ListDeleteMarkersForPurge(&rule, cut, lw.Batch)
 			if err != nil {
 				rlog.Error("dm_query_fail", "err", err)
 			} else {
@@ -105,10 +113,9 @@ func (lw *LifecycleWorker) onePass(ctx context.Context) {
 			}
 		}
 
-		// 3) Expire current (HEAD) - ставим delete-marker
-		if rule.ExpireCurrentAfterDays != nil && *rule.ExpireCurrentAfterDays >= 0 {
-			cut := time.Now().AddDate(0, 0, -*rule.PurgeDeleteMarkersAfterDays)
-			objs, err := lw.s.db.ListHeadsOlderThan(rule.BucketID, rule.Prefix, cut, lw.Batch)
+		// 3) Expire current (HEAD) - ставим delete-marker: по возрасту или, начиная с Date, все подряд
+		if cut, ok := expireCurrentCutoff(&rule, time.Now()); ok {
+			objs, err := lw.s.db.ListHeadsOlderThan(&rule, cut, lw.Batch)
 			if err != nil {
 				rlog.Error("head_query_fail", "err", err)
 			} else {
@@ -120,15 +127,204 @@ func (lw *LifecycleWorker) onePass(ctx context.Context) {
 			}
 		}
 
+		// 4) Transition: HEAD старше N дней — на второй уровень хранения
+		if rule.TransitionToClass != "" && rule.TransitionAfterDays != nil && *rule.TransitionAfterDays >= 0 {
+			if !lw.s.storage.HasNode(storageNodeTier) {
+				rlog.Warn("transition_no_tier")
+			} else {
+				cut := time.Now().AddDate(0, 0, -*rule.TransitionAfterDays)
+				vers, err := lw.s.db.ListCurrentForTransition(&rule, cut, lw.Batch)
+				if err != nil {
+					rlog.Error("transition_query_fail", "err", err)
+				} else {
+					changed := lw.transitionTx(ctx, vers, rule.TransitionToClass)
+					totalChanged += changed
+					if changed > 0 {
+						rlog.Info("transitioned", "count", changed, "class", rule.TransitionToClass)
+					}
+				}
+			}
+		}
+
+		// 5) AbortIncompleteMultipartUpload: брошенные загрузки и их части
+		if rule.AbortIncompleteAfterDays != nil && *rule.AbortIncompleteAfterDays >= 0 {
+			cut := time.Now().AddDate(0, 0, -*rule.AbortIncompleteAfterDays)
+			ups, err := lw.s.db.ListStaleMultipartUploads(&rule, cut, lw.Batch)
+			if err != nil {
+				rlog.Error("mpu_query_fail", "err", err)
+			} else {
+				changed := lw.abortUploadsTx(ctx, ups)
+				totalChanged += changed
+				if changed > 0 {
+					rlog.Info("mpu_aborted", "count", changed)
+				}
+			}
+		}
+
 		rlog.Info("rule_end")
 	}
 	lw.logger.Info("pass_end", "changed", totalChanged, "dur_ms", time.Since(start).Milliseconds())
 }
 
+// expireCurrentCutoff — до какого момента созданные HEAD-версии истекают по правилу.
+func expireCurrentCutoff(rule *db.LifecycleRule, now time.Time) (time.Time, bool) {
+	switch {
+	case rule.ExpireCurrentAfterDays != nil && *rule.ExpireCurrentAfterDays >= 0:
+		return now.AddDate(0, 0, -*rule.ExpireCurrentAfterDays), true
+	case rule.ExpireCurrentOnDate != nil && !now.Before(*rule.ExpireCurrentOnDate):
+		return now, true
+	}
+	return time.Time{}, false
+}
+
+// setExpirationHeader — x-amz-expiration для текущей версии: когда её удалит
+// ближайшее подходящее правило Expiration и какое именно. Дни считаются от
+// создания версии и округляются вверх до полуночи UTC, как в S3.
+func (s *Server) setExpirationHeader(w http.ResponseWriter, log *slog.Logger, ver *db.ObjectVersion) {
+	rules, err := s.db.ListEnabledBucketLifecycleRules(ver.BucketID)
+	if err != nil {
+		log.Warn("lifecycle.expiration_header_fail", "err", err)
+		return
+	}
+	var tags map[string]string
+	var best *db.LifecycleRule
+	var bestAt time.Time
+	for i := range rules {
+		rule := &rules[i]
+		var at time.Time
+		switch {
+		case rule.ExpireCurrentAfterDays != nil && *rule.ExpireCurrentAfterDays > 0:
+			at = ver.CreatedAt.UTC().AddDate(0, 0, *rule.ExpireCurrentAfterDays)
+			if day := at.Truncate(24 * time.Hour); !day.Equal(at) {
+				at = day.Add(24 * time.Hour)
+			}
+		case rule.ExpireCurrentOnDate != nil:
+			at = rule.ExpireCurrentOnDate.UTC()
+		default:
+			continue
+		}
+		if rule.Tags != "" && tags == nil {
+			list, err := s.db.ListVersionTagsTx(s.db.DB, ver.VersionID)
+			if err != nil {
+				log.Warn("lifecycle.expiration_header_fail", "err", err)
+				return
+			}
+			tags = make(map[string]string, len(list))
+			for _, t := range list {
+				tags[t.Key] = t.Value
+			}
+		}
+		if !rule.Matches(ver.Key, coalesce(ver.Size, 0), tags) {
+			continue
+		}
+		if best == nil || at.Before(bestAt) {
+			best, bestAt = rule, at
+		}
+	}
+	if best != nil {
+		w.Header().Set("x-amz-expiration",
+			`expiry-date="`+bestAt.Format(http.TimeFormat)+`", rule-id="`+best.RuleID+`"`)
+	}
+}
+
+// restoreObjects — RestoreObject: запрошенные копии архивных версий переезжают
+// на основной узел, копии с истёкшим сроком — обратно на второй уровень.
+func (lw *LifecycleWorker) restoreObjects(ctx context.Context) {
+	vers, err := lw.s.db.ListPendingRestores(lw.Batch)
+	if err != nil {
+		lw.logger.Error("restore_query_fail", "err", err)
+		return
+	}
+	for _, v := range vers {
+		until := restoreExpiry(time.Now(), max(v.RestoreDays, 1))
+		moved, err := lw.moveVersionTx(ctx, v, storage.NodeLocal, map[string]any{
+			"restore_ongoing":    false,
+			"restore_expires_at": until,
+		})
+		if err != nil {
+			lw.logger.Error("restore_fail", "key", v.Key, "version_id", v.VersionID, "err", err)
+			continue
+		}
+		lw.logger.Info("restored", "key", v.Key, "version_id", v.VersionID, "blobs", moved, "until", until)
+	}
+
+	expired, err := lw.s.db.ListExpiredRestores(time.Now(), lw.Batch)
+	if err != nil {
+		lw.logger.Error("restore_expired_query_fail", "err", err)
+		return
+	}
+	reset := map[string]any{"restore_days": 0, "restore_expires_at": nil}
+	for _, v := range expired {
+		// без второго уровня возвращать некуда — копия просто перестаёт считаться восстановленной
+		if !lw.s.storage.HasNode(storageNodeTier) || !archiveClasses[v.StorageClass] {
+			if err := lw.s.db.UpdateVersionFieldsTx(lw.s.db.DB, v.VersionID, reset); err != nil {
+				lw.logger.Error("restore_expire_fail", "key", v.Key, "version_id", v.VersionID, "err", err)
+			}
+			continue
+		}
+		moved, err := lw.moveVersionTx(ctx, v, storageNodeTier, reset)
+		if err != nil {
+			lw.logger.Error("restore_expire_fail", "key", v.Key, "version_id", v.VersionID, "err", err)
+			continue
+		}
+		lw.logger.Info("restore_expired", "key", v.Key, "version_id", v.VersionID, "blobs", moved)
+	}
+}
+
+// compactVersions — схлопывание истории в бакетах с CompactIdenticalVersions:
+// из пары соседних одинаковых версий удаляется старшая.
+func (lw *LifecycleWorker) compactVersions(ctx context.Context) {
+	buckets, err := lw.s.db.ListBucketsWithCompaction()
+	if err != nil {
+		lw.logger.Error("compaction_buckets_fail", "err", err)
+		return
+	}
+	for _, b := range buckets {
+		vers, err := lw.s.db.ListCompactableVersions(b.ID, lw.Batch)
+		if err != nil {
+			lw.logger.Error("compaction_query_fail", "bucket_id", b.ID, "err", err)
+			continue
+		}
+		if changed := lw.dropDuplicatesTx(ctx, vers); changed > 0 {
+			lw.logger.Info("versions_compacted", "bucket_id", b.ID, "count", changed)
+		}
+	}
+}
+
+// dropDuplicatesTx удаляет старшие версии пар из ListCompactableVersions. Пары
+// выбраны без блокировки: пока очередь дошла, следующую версию могли удалить
+// (DELETE ?versionId=) или поменять ей теги — тогда старшая остаётся, иначе
+// ушла бы последняя копия.
+func (lw *LifecycleWorker) dropDuplicatesTx(ctx context.Context, vers []db.ObjectVersion) int {
+	return lw.deleteVersionsTx(ctx, vers, "version_compacted", func(tx *gorm.DB, cur *db.ObjectVersion) (bool, error) {
+		next, err := lw.s.db.GetNextVersionTx(tx, cur)
+		if errors.Is(err, db.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil || !db.SameVersionContent(cur, next) {
+			return false, err
+		}
+		tags, err := lw.s.db.ListVersionTagsTx(tx, cur.VersionID)
+		if err != nil {
+			return false, err
+		}
+		want := make(map[string]string, len(tags))
+		for _, t := range tags {
+			want[t.Key] = t.Value
+		}
+		return lw.s.sameTagsTx(tx, next.VersionID, want)
+	})
+}
+
 // --------------------- шаги в транзакциях --------------------------
 
-func (lw *LifecycleWorker) deleteVersionsTx(ctx context.Context, vers []db.ObjectVersion, event string) int {
+// deleteVersionsTx удаляет версии, каждую в своей транзакции под блокировкой
+// ключа. recheck (если задан) перепроверяет версию уже под блокировкой: false —
+// версия остаётся.
+func (lw *LifecycleWorker) deleteVersionsTx(ctx context.Context, vers []db.ObjectVersion, event string,
+	recheck func(tx *gorm.DB, cur *db.ObjectVersion) (bool, error)) int {
 	changed := 0
+	buckets := map[uint]*db.Bucket{}
 	for _, v := range vers {
 		_ = lw.s.db.WithTxImmediate(func(tx *gorm.DB) error {
 			// лочим объект
@@ -136,18 +332,44 @@ func (lw *LifecycleWorker) deleteVersionsTx(ctx context.Context, vers []db.Objec
 				lw.logger.Error("lock_fail", "key", v.Key, "err", err)
 				return err
 			}
-			// удаляем версию
-			if err := lw.s.db.DeleteVersionTx(tx, v.VersionID); err != nil {
+			// WORM-окно бакета и Object Lock действуют и на lifecycle
+			b, ok := buckets[v.BucketID]
+			if !ok {
+				var err error
+				if b, err = lw.s.db.FindBucketByID(v.BucketID); err != nil {
+					return err
+				}
+				buckets[v.BucketID] = b
+			}
+			if b.ProtectionDays > 0 || b.ObjectLockEnabled || recheck != nil {
+				cur, err := lw.s.db.GetVersionTx(tx, v.VersionID)
+				if err != nil {
+					return err
+				}
+				if versionProtected(b, cur, time.Now()) {
+					lw.logger.Info("version_protected", "key", v.Key, "version_id", v.VersionID)
+					return nil
+				}
+				if recheck != nil {
+					ok, err := recheck(tx, cur)
+					if err != nil {
+						lw.logger.Error("recheck_fail", "key", v.Key, "version_id", v.VersionID, "err", err)
+						return err
+					}
+					if !ok {
+						lw.logger.Info("version_kept", "key", v.Key, "version_id", v.VersionID)
+						return nil
+					}
+				}
+			}
+			// удаляем версию (в бакете с корзиной — переносим в неё)
+			if err := lw.s.removeVersionTx(tx, b, v.VersionID); err != nil {
 				lw.logger.Error("delete_version_fail", "version_id", v.VersionID, "err", err)
 				return err
 			}
 			// GC блоба, если осирател
-			if v.BlobID != nil {
-				if cnt, _ := lw.s.db.BlobRefCountFromVersionsTx(tx, *v.BlobID); cnt == 0 {
-					_ = lw.s.storage.Delete(ctx, *v.BlobID)
-					_ = lw.s.db.DeleteBlobRecordTx(tx, *v.BlobID)
-					lw.logger.Info("g.deleted", "blob_id", *v.BlobID)
-				}
+			if v.BlobID != nil && lw.s.reclaimOrphanTx(ctx, tx, *v.BlobID) {
+				lw.logger.Info("g.deleted", "blob_id", *v.BlobID)
 			}
 			changed++
 			lw.logger.Info(event, "key", v.Key, "version_id", v.VersionID)
@@ -183,6 +405,31 @@ func (lw *LifecycleWorker) purgeDeleteMarkersTx(dms []db.ObjectVersion) int {
 	return changed
 }
 
+// abortUploadsTx отменяет загрузки; блобы частей, на которые больше никто не
+// ссылается, без окна GC_GRACE_S удаляются сразу (остальное подберёт GC).
+func (lw *LifecycleWorker) abortUploadsTx(ctx context.Context, ups []db.MultipartUpload) int {
+	changed := 0
+	for _, u := range ups {
+		_ = lw.s.db.WithTxImmediate(func(tx *gorm.DB) error {
+			parts, err := lw.s.db.ListMultipartPartsTx(tx, u.UploadID)
+			if err != nil {
+				return err
+			}
+			if err := lw.s.db.DeleteMultipartUploadTx(tx, u.UploadID); err != nil {
+				lw.logger.Error("mpu_abort_fail", "upload_id", u.UploadID, "err", err)
+				return err
+			}
+			for _, p := range parts {
+				lw.s.reclaimOrphanTx(ctx, tx, p.BlobID)
+			}
+			changed++
+			lw.logger.Info("mpu_aborted", "key", u.Key, "upload_id", u.UploadID, "parts", len(parts))
+			return nil
+		})
+	}
+	return changed
+}
+
 func (lw *LifecycleWorker) expireCurrentTx(objs []db.Object) int {
 	changed := 0
 	for _, o := range objs {
@@ -208,3 +455,88 @@ func (lw *LifecycleWorker) expireCurrentTx(objs []db.Object) int {
 	}
 	return changed
 }
+
+// transitionTx переносит байты версий на узел storageNodeTier и меняет им класс.
+func (lw *LifecycleWorker) transitionTx(ctx context.Context, vers []db.ObjectVersion, class string) int {
+	changed := 0
+	for _, v := range vers {
+		moved, err := lw.moveVersionTx(ctx, v, storageNodeTier, map[string]any{"storage_class": class})
+		if err != nil {
+			lw.logger.Error("transition_fail", "key", v.Key, "version_id", v.VersionID, "err", err)
+			continue
+		}
+		changed++
+		lw.logger.Info("transitioned", "key", v.Key, "version_id", v.VersionID, "class", class, "blobs", moved)
+	}
+	return changed
+}
+
+// moveVersionTx переносит байты версии на узел to и пишет ей fields.
+// Сначала блоб копируется, затем в транзакции переписывается blobs.storage_node
+// (чтения сразу идут на новый узел), и только после коммита удаляется старая копия.
+// Блоб общий для всех ссылающихся на него версий — переезжает он целиком,
+// manifest-блоб — всеми своими кусками. Возвращает число перенесённых блобов.
+func (lw *LifecycleWorker) moveVersionTx(ctx context.Context, v db.ObjectVersion, to string, fields map[string]any) (int, error) {
+	chunks, err := lw.s.db.ResolveRangeTx(lw.s.db.DB, *v.BlobID, 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	type move struct{ id, from string }
+	var copied []move
+	dropCopies := func() {
+		for _, m := range copied {
+			_ = lw.s.storage.DeleteNode(ctx, to, m.id)
+		}
+	}
+	seen := map[string]bool{}
+	for _, ch := range chunks {
+		if storageNode(ch.Node) == to || seen[ch.BlobID] {
+			continue
+		}
+		seen[ch.BlobID] = true
+		if err := lw.s.storage.Copy(ctx, ch.BlobID, ch.Node, to); err != nil {
+			dropCopies()
+			return 0, fmt.Errorf("copy blob %s: %w", ch.BlobID, err)
+		}
+		copied = append(copied, move{ch.BlobID, ch.Node})
+	}
+
+	var moved, orphaned []move
+	err = lw.s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		moved, orphaned = moved[:0], orphaned[:0]
+		if err := lw.s.db.LockObjectForUpdate(tx, v.BucketID, v.Key); err != nil {
+			return err
+		}
+		for _, m := range copied {
+			ok, err := lw.s.db.MoveBlobNodeTx(tx, m.id, m.from, to)
+			if err != nil {
+				return err
+			}
+			if ok {
+				moved = append(moved, m)
+			} else {
+				orphaned = append(orphaned, m) // блоб успел уйти в GC
+			}
+		}
+		// версию могли удалить, пока копировали
+		if _, err := lw.s.db.GetVersionTx(tx, v.VersionID); errors.Is(err, db.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return lw.s.db.UpdateVersionFieldsTx(tx, v.VersionID, fields)
+	})
+	if err != nil {
+		dropCopies()
+		return 0, err
+	}
+	for _, m := range moved {
+		if err := lw.s.storage.DeleteNode(ctx, m.from, m.id); err != nil {
+			lw.logger.Warn("move_cleanup_fail", "blob_id", m.id, "node", m.from, "err", err)
+		}
+	}
+	for _, m := range orphaned {
+		_ = lw.s.storage.DeleteNode(ctx, to, m.id)
+	}
+	return len(moved), nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// Фоновая компакция выбирает пары одинаковых версий без блокировки, а удаляет
+// старшую позже, под блокировкой ключа. Если младшую за это время удалили,
+// старшая — единственная копия и должна остаться.
+func TestCompactionRechecksSuccessor(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		dropNext   bool
+		wantKeeper bool
+	}{
+		{"successor_alive", false, false},
+		{"successor_deleted", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newRaceEnv(t)
+			if rec := e.do(http.MethodPut, "/compact", nil); rec.Code != http.StatusOK {
+				t.Fatalf("create bucket: %d %s", rec.Code, rec.Body)
+			}
+			bkt, err := e.s.db.FindBucketByName("compact")
+			if err != nil {
+				t.Fatalf("bucket: %v", err)
+			}
+			if err := e.s.db.UpdateBucketSettings(bkt.ID, map[string]any{"versioning": db.VersioningEnabled}); err != nil {
+				t.Fatalf("versioning: %v", err)
+			}
+			// пара одинаковых версий появляется до включения компакции: с ней PUT
+			// не создал бы вторую
+			body := []byte("same bytes")
+			var ids []string
+			for range 2 {
+				rec := e.do(http.MethodPut, "/compact/doc", body)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("put: %d %s", rec.Code, rec.Body)
+				}
+				ids = append(ids, rec.Header().Get("x-amz-version-id"))
+			}
+			if err := e.s.db.UpdateBucketSettings(bkt.ID, map[string]any{"compact_identical_versions": true}); err != nil {
+				t.Fatalf("compaction: %v", err)
+			}
+
+			vers, err := e.s.db.ListCompactableVersions(bkt.ID, 100)
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			if len(vers) != 1 || vers[0].VersionID != ids[0] {
+				t.Fatalf("compactable: %+v, want %s", vers, ids[0])
+			}
+			if tc.dropNext {
+				if rec := e.do(http.MethodDelete, "/compact/doc?versionId="+ids[1], nil); rec.Code != http.StatusNoContent {
+					t.Fatalf("delete successor: %d %s", rec.Code, rec.Body)
+				}
+			}
+
+			lw := &LifecycleWorker{s: e.s, Batch: 100, logger: e.s.Logger.With(slog.String("comp", "lifecycle"))}
+			changed := lw.dropDuplicatesTx(context.Background(), vers)
+			if kept := changed == 0; kept != tc.wantKeeper {
+				t.Fatalf("compacted %d versions, want kept=%v", changed, tc.wantKeeper)
+			}
+			rec := e.do(http.MethodGet, "/compact/doc", nil)
+			if rec.Code != http.StatusOK || rec.Body.String() != string(body) {
+				t.Fatalf("GET after compaction: %d %q", rec.Code, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/DanikLP1/s3-storage-service/internal/config"
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// Токен продолжения ListObjectsV2 — позиция листинга вместе с параметрами, к
+// которым она относится, под HMAC: клиент не может подделать курсор или
+// продолжить им листинг другого бакета, префикса или delimiter.
+
+var errListToken = errors.New("invalid continuation token")
+
+type listToken struct {
+	Prefix    string `json:"p,omitempty"`
+	Delimiter string `json:"d,omitempty"`
+	After     string `json:"a"`
+	Rollup    bool   `json:"r,omitempty"` // After — CommonPrefix
+}
+
+// listTokenKey — LIST_TOKEN_KEY; без него — из CLUSTER_SECRET (общий у узлов
+// кластера), иначе случайный: токены тогда живут до перезапуска процесса.
+func listTokenKey(cfg config.Config) []byte {
+	if cfg.ListTokenKey != "" {
+		return []byte(cfg.ListTokenKey)
+	}
+	if cfg.ClusterSecret != "" {
+		m := hmac.New(sha256.New, []byte(cfg.ClusterSecret))
+		m.Write([]byte("s3mini list continuation token"))
+		return m.Sum(nil)
+	}
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}
+
+func (s *Server) listTokenMAC(bucket string, payload []byte) []byte {
+	m := hmac.New(sha256.New, s.listTokenKey)
+	m.Write([]byte(bucket))
+	m.Write([]byte{0})
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+// encodeListToken — base64url(JSON) "." base64url(HMAC).
+func (s *Server) encodeListToken(bucket string, p db.ListV2Params, c *db.ListV2Cursor) string {
+	payload, _ := json.Marshal(listToken{Prefix: p.Prefix, Delimiter: p.Delimiter, After: c.After, Rollup: c.Rollup})
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.listTokenMAC(bucket, payload))
+}
+
+// decodeListToken проверяет подпись и что токен выдан для тех же prefix и
+// delimiter, что в запросе.
+func (s *Server) decodeListToken(bucket, raw, prefix, delimiter string) (*db.ListV2Cursor, error) {
+	enc, sig, ok := strings.Cut(raw, ".")
+	if !ok {
+		return nil, errListToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, errListToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.listTokenMAC(bucket, payload)) {
+		return nil, errListToken
+	}
+	var t listToken
+	if err := json.Unmarshal(payload, &t); err != nil || t.After == "" {
+		return nil, errListToken
+	}
+	if t.Prefix != prefix || t.Delimiter != delimiter {
+		return nil, errListToken
+	}
+	return &db.ListV2Cursor{After: t.After, Rollup: t.Rollup}, nil
+}
@@ -3,21 +3,34 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"log/slog"
 	"math/rand"
 	"net/http"
+	"os"
 	"time"
 )
 
+const ctxLoggerKey ctxKey = "logger"
+
 const (
-	ctxLoggerKey    ctxKey = "logger"
-	ctxRequestIDKey ctxKey = "req_id"
+	hdrRequestID = "x-amz-request-id"
+	hdrHostID    = "x-amz-id-2"
 )
 
+// hostID — x-amz-id-2 и HostId в ошибках: по нему видно, какой экземпляр ответил.
+var hostID = func() string {
+	name, _ := os.Hostname()
+	sum := sha256.Sum256([]byte(name))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}()
+
 type statusWriter struct {
 	http.ResponseWriter
 	status  int
 	written int64
+	errHead []byte // начало тела ошибки: код для журнала доступа
 }
 
 func (w *statusWriter) WriteHeader(code int) {
@@ -25,20 +38,26 @@ func (w *statusWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status >= 400 && len(w.errHead) < 256 {
+		w.errHead = append(w.errHead, p[:min(len(p), 256-len(w.errHead))]...)
+	}
 	n, err := w.ResponseWriter.Write(p)
 	w.written += int64(n)
 	return n, err
 }
 
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
 func (s *Server) WithRecover(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rec := recover(); rec != nil {
-				reqID := r.Context().Value(ctxRequestIDKey)
-				s.Logger.Error("panic", "req_id", reqID, "path", r.URL.Path, "err", rec)
-				w.Header().Set("Content-Type", "application/xml")
-				w.WriteHeader(http.StatusInternalServerError)
-				_, _ = w.Write([]byte("<Error><Code>InternalError</Code><Message>panic</Message></Error>"))
+				if rec == http.ErrAbortHandler {
+					panic(rec) // намеренный обрыв соединения
+				}
+				// WithRecover снаружи WithRequestLogger: ID запроса — из уже выставленного заголовка
+				s.Logger.Error("panic", "req_id", w.Header().Get(hdrRequestID), "path", r.URL.Path, "err", rec)
+				writeS3Error(w, http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.", r.URL.Path, "")
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -57,12 +76,15 @@ func (s *Server) WithRequestLogger(next http.Handler) http.Handler {
 			slog.String("remote", r.RemoteAddr),
 		)
 		ctx = context.WithValue(ctx, ctxLoggerKey, l)
+		info := &accessInfo{}
+		ctx = context.WithValue(ctx, ctxAccessInfoKey, info)
 
 		ww := &statusWriter{ResponseWriter: w, status: 200}
 		start := time.Now()
 
 		// полезно вернуть ID запроса клиенту
-		ww.Header().Set("x-amz-request-id", reqID)
+		ww.Header().Set(hdrRequestID, reqID)
+		ww.Header().Set(hdrHostID, hostID)
 
 		next.ServeHTTP(ww, r.WithContext(ctx))
 
@@ -71,6 +93,7 @@ func (s *Server) WithRequestLogger(next http.Handler) http.Handler {
 			slog.Duration("dur", time.Since(start)),
 			slog.Int64("bytes", ww.written),
 		)
+		s.recordAccess(r, ww, info, reqID, start)
 	})
 }
 
@@ -83,6 +106,10 @@ func genReqID() string {
 	return string(b)
 }
 
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxLoggerKey, l)
+}
+
 // helper: взять логгер из контекста
 func loggerFrom(r *http.Request) *slog.Logger {
 	if l, ok := r.Context().Value(ctxLoggerKey).(*slog.Logger); ok && l != nil {
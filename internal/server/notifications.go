@@ -0,0 +1,594 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+	"github.com/DanikLP1/s3-storage-service/internal/notify"
+)
+
+// Уведомления о событиях бакета (?notification). Источник — лента изменений:
+// диспетчер читает её по курсору, сопоставляет события с правилами бакета и
+// ставит доставки в очередь в БД; отправка — с повторами и dead-letter.
+
+var mNotifications = metrics.NewCounterVec("s3mini_notifications_total",
+	"Bucket event notification deliveries by result.", "result") // delivered|retry|dead
+
+const (
+	maxNotificationRules = 100
+	notifyBatch          = 500
+	notifyDeliverBatch   = 100
+	notifyTimeout        = 10 * time.Second
+	notifyMaxBackoff     = 10 * time.Minute
+)
+
+// NotificationConfiguration — тело PUT и ответ GET /:bucket?notification.
+// Цели AWS (SNS/SQS/Lambda) не поддерживаются, вместо них — вебхуки,
+// subject'ы NATS и топики Kafka.
+type NotificationConfiguration struct {
+	XMLName  xml.Name               `xml:"NotificationConfiguration"`
+	Xmlns    string                 `xml:"xmlns,attr,omitempty"`
+	Webhooks []WebhookConfiguration `xml:"WebhookConfiguration"`
+	Nats     []NatsConfiguration    `xml:"NatsConfiguration"`
+	Kafka    []KafkaConfiguration   `xml:"KafkaConfiguration"`
+
+	Topics    []struct{} `xml:"TopicConfiguration"`
+	Queues    []struct{} `xml:"QueueConfiguration"`
+	Functions []struct{} `xml:"CloudFunctionConfiguration"`
+}
+
+type WebhookConfiguration struct {
+	ID       string              `xml:"Id,omitempty"`
+	Endpoint string              `xml:"Endpoint"`
+	Events   []string            `xml:"Event"`
+	Filter   *NotificationFilter `xml:"Filter,omitempty"`
+}
+
+// NatsConfiguration — публикация в Subject на сервере Url (nats://[user:pass@|token@]host[:port]).
+type NatsConfiguration struct {
+	ID      string              `xml:"Id,omitempty"`
+	URL     string              `xml:"Url"`
+	Subject string              `xml:"Subject"`
+	Events  []string            `xml:"Event"`
+	Filter  *NotificationFilter `xml:"Filter,omitempty"`
+}
+
+// KafkaConfiguration — запись в Topic; Broker — bootstrap-адреса host:port.
+type KafkaConfiguration struct {
+	ID      string              `xml:"Id,omitempty"`
+	Brokers []string            `xml:"Broker"`
+	Topic   string              `xml:"Topic"`
+	Events  []string            `xml:"Event"`
+	Filter  *NotificationFilter `xml:"Filter,omitempty"`
+}
+
+type NotificationFilter struct {
+	S3Key struct {
+		Rules []FilterRule `xml:"FilterRule"`
+	} `xml:"S3Key"`
+}
+
+type FilterRule struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+// события, на которые можно подписаться
+var notificationEvents = map[string]bool{
+	"s3:ObjectCreated:*":                   true,
+	"s3:ObjectCreated:Put":                 true,
+	"s3:ObjectRemoved:*":                   true,
+	"s3:ObjectRemoved:Delete":              true,
+	"s3:ObjectRemoved:DeleteMarkerCreated": true,
+}
+
+// notificationEventName — событие S3 для записи ленты; "" — не уведомляем.
+// Создание версии любым путём (PUT, копия, multipart) — ObjectCreated:Put.
+func notificationEventName(changeType string) string {
+	switch changeType {
+	case db.ChangeObjectCreated:
+		return "ObjectCreated:Put"
+	case db.ChangeDeleteMarkerCreated:
+		return "ObjectRemoved:DeleteMarkerCreated"
+	case db.ChangeVersionDeleted:
+		return "ObjectRemoved:Delete"
+	}
+	return ""
+}
+
+// notifyRule — правило любого вида: куда (sink + target) и на что подписано.
+// target — строка для NotificationDelivery.Target, её разбирает sink.
+type notifyRule struct {
+	id     *string
+	sink   string
+	target string
+	events []string
+	filter *NotificationFilter
+}
+
+func (c *NotificationConfiguration) rules() []notifyRule {
+	var out []notifyRule
+	for i := range c.Webhooks {
+		r := &c.Webhooks[i]
+		out = append(out, notifyRule{&r.ID, "webhook", r.Endpoint, r.Events, r.Filter})
+	}
+	for i := range c.Nats {
+		r := &c.Nats[i]
+		out = append(out, notifyRule{&r.ID, "nats", strings.TrimRight(r.URL, "/") + "/" + r.Subject, r.Events, r.Filter})
+	}
+	for i := range c.Kafka {
+		r := &c.Kafka[i]
+		out = append(out, notifyRule{&r.ID, "kafka", "kafka://" + strings.Join(r.Brokers, ",") + "/" + r.Topic, r.Events, r.Filter})
+	}
+	return out
+}
+
+func (c *NotificationConfiguration) validate() error {
+	if len(c.Topics)+len(c.Queues)+len(c.Functions) > 0 {
+		return errNotificationTarget
+	}
+	rules := c.rules()
+	if len(rules) > maxNotificationRules {
+		return fmt.Errorf("too many notification rules (max %d)", maxNotificationRules)
+	}
+	ids := map[string]bool{}
+	for _, rule := range rules {
+		if *rule.id == "" {
+			var b [8]byte
+			_, _ = rand.Read(b[:])
+			*rule.id = hex.EncodeToString(b[:])
+		}
+		id := *rule.id
+		if ids[id] {
+			return fmt.Errorf("duplicate rule Id %q", id)
+		}
+		ids[id] = true
+		if err := validateNotifyTarget(rule.sink, rule.target); err != nil {
+			return fmt.Errorf("rule %q: %w", id, err)
+		}
+		if len(rule.events) == 0 {
+			return fmt.Errorf("rule %q: at least one Event is required", id)
+		}
+		for _, e := range rule.events {
+			if !notificationEvents[e] {
+				return fmt.Errorf("rule %q: unsupported event %q", id, e)
+			}
+		}
+		if rule.filter != nil {
+			seen := map[string]bool{}
+			for _, fr := range rule.filter.S3Key.Rules {
+				if (fr.Name != "prefix" && fr.Name != "suffix") || seen[fr.Name] {
+					return fmt.Errorf("rule %q: filter rule names must be prefix or suffix, each at most once", id)
+				}
+				seen[fr.Name] = true
+			}
+		}
+	}
+	return nil
+}
+
+func validateNotifyTarget(sink, target string) error {
+	switch sink {
+	case "webhook":
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("Endpoint must be an absolute http(s) URL")
+		}
+	case "nats":
+		server, subject := splitNotifyTarget(target)
+		u, err := url.Parse(server)
+		if err != nil || u.Scheme != "nats" || u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+			return errors.New("Url must be nats://host[:port]")
+		}
+		if !notify.ValidNATSSubject(subject) {
+			return errors.New("Subject must be a NATS subject without wildcards")
+		}
+	case "kafka":
+		brokers, topic := splitKafkaTarget(target)
+		if len(brokers) == 0 {
+			return errors.New("at least one Broker is required")
+		}
+		for _, b := range brokers {
+			if _, port, err := net.SplitHostPort(b); err != nil || port == "" {
+				return fmt.Errorf("Broker %q must be host:port", b)
+			}
+		}
+		if !notify.ValidKafkaTopic(topic) {
+			return errors.New("Topic must be a valid Kafka topic name")
+		}
+	}
+	return nil
+}
+
+// splitNotifyTarget — "scheme://host/name" → ("scheme://host", "name").
+func splitNotifyTarget(target string) (server, name string) {
+	i := strings.LastIndexByte(target, '/')
+	return target[:i], target[i+1:]
+}
+
+func splitKafkaTarget(target string) (brokers []string, topic string) {
+	server, topic := splitNotifyTarget(target)
+	for _, b := range strings.Split(strings.TrimPrefix(server, "kafka://"), ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers, topic
+}
+
+var errNotificationTarget = errors.New("only Webhook, Nats and Kafka configurations are supported")
+
+// match — правило подписано на событие и ключ проходит фильтр.
+func (rule *notifyRule) match(event, key string) bool {
+	if rule.filter != nil {
+		for _, fr := range rule.filter.S3Key.Rules {
+			if fr.Name == "prefix" && !wildcardMatch(fr.Value+"*", key) ||
+				fr.Name == "suffix" && !wildcardMatch("*"+fr.Value, key) {
+				return false
+			}
+		}
+	}
+	for _, e := range rule.events {
+		if wildcardMatch(e, "s3:"+event) {
+			return true
+		}
+	}
+	return false
+}
+
+// notificationRecord — событие в формате уведомлений S3 (Records[]).
+func notificationRecord(b *db.Bucket, ev *db.ChangeEvent, event, ruleID, region string) []byte {
+	obj := map[string]any{
+		"key":       url.QueryEscape(ev.Key),
+		"sequencer": fmt.Sprintf("%016X", ev.Seq),
+	}
+	if ev.Size != nil {
+		obj["size"] = *ev.Size
+	}
+	if ev.ETag != "" {
+		obj["eTag"] = stripQuotes(ev.ETag)
+	}
+	if ev.VersionID != "" {
+		obj["versionId"] = apiVersionID(ev.VersionID)
+	}
+	rec := map[string]any{
+		"eventVersion": "2.1",
+		"eventSource":  "s3mini:s3",
+		"awsRegion":    region,
+		"eventTime":    ev.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+		"eventName":    event,
+		"s3": map[string]any{
+			"s3SchemaVersion": "1.0",
+			"configurationId": ruleID,
+			"bucket": map[string]any{
+				"name":          b.Name,
+				"ownerIdentity": map[string]string{"principalId": strconv.FormatUint(uint64(b.OwnerID), 10)},
+				"arn":           resourceARN(b.Name, ""),
+			},
+			"object": obj,
+		},
+	}
+	out, _ := json.Marshal(map[string]any{"Records": []any{rec}})
+	return out
+}
+
+// notifySink — способ доставки (NotificationDelivery.Sink).
+type notifySink interface {
+	Send(ctx context.Context, d *db.NotificationDelivery) error
+}
+
+var notifySinks = map[string]notifySink{
+	"webhook": webhookSink{client: &http.Client{Timeout: notifyTimeout}},
+	"nats":    natsSink{},
+	"kafka":   kafkaSink{},
+}
+
+// webhookSink — POST JSON на Endpoint правила; успех — 2xx.
+type webhookSink struct{ client *http.Client }
+
+func (w webhookSink) Send(ctx context.Context, d *db.NotificationDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Target, strings.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("webhook returned " + resp.Status)
+	}
+	return nil
+}
+
+// natsSink — PUB в subject (core NATS); target — "nats://host:port/subject".
+type natsSink struct{}
+
+func (natsSink) Send(ctx context.Context, d *db.NotificationDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	server, subject := splitNotifyTarget(d.Target)
+	return notify.PublishNATS(ctx, server, subject, []byte(d.Payload))
+}
+
+// kafkaSink — запись в топик с ключом-именем объекта; target — "kafka://b1:9092,b2:9092/topic".
+type kafkaSink struct{}
+
+func (kafkaSink) Send(ctx context.Context, d *db.NotificationDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	brokers, topic := splitKafkaTarget(d.Target)
+	return notify.PublishKafka(ctx, brokers, topic, []byte(d.Key), []byte(d.Payload))
+}
+
+// StartNotifications — диспетчер уведомлений: раз в every разбирает новые
+// события ленты и отправляет доставки, срок которых наступил.
+func (s *Server) StartNotifications(ctx context.Context, every time.Duration) {
+	log := s.Logger.With(slog.String("comp", "notifications"))
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				// курсор, очередь и счётчики попыток — записи в БД; доставки ждут выхода из режима только чтения
+				if s.isReadOnly() || !s.holdLease(log, "notifications", every) {
+					continue
+				}
+				if err := s.enqueueNotifications(log); err != nil {
+					log.Error("notify.enqueue_fail", "err", err)
+				}
+				s.deliverNotifications(ctx, log)
+			}
+		}
+	}()
+}
+
+// enqueueNotifications — события ленты после курсора в очередь доставки.
+// Первый запуск начинает с текущего конца ленты: история не рассылается.
+func (s *Server) enqueueNotifications(log *slog.Logger) error {
+	cursor, ok, err := s.db.NotificationCursorSeq()
+	if err != nil {
+		return err
+	}
+	oldest, latest, err := s.db.ChangeSeqBounds()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Info("notify.cursor_init", "seq", latest)
+		return s.db.EnqueueNotifications(nil, latest)
+	}
+	if oldest > cursor+1 {
+		log.Warn("notify.feed_gap", "cursor", cursor, "oldest_seq", oldest)
+	}
+	configs := map[uint]*NotificationConfiguration{}
+	buckets := map[uint]*db.Bucket{}
+	for {
+		evs, err := s.db.ListChanges(0, cursor, notifyBatch)
+		if err != nil || len(evs) == 0 {
+			return err
+		}
+		var ds []db.NotificationDelivery
+		now := time.Now()
+		for i := range evs {
+			ev := &evs[i]
+			event := notificationEventName(ev.Type)
+			if event == "" {
+				continue
+			}
+			cfg, seen := configs[ev.BucketID]
+			if !seen {
+				if b, err := s.db.FindBucketByID(ev.BucketID); err == nil && b.Notification != "" {
+					var c NotificationConfiguration
+					if err := xml.Unmarshal([]byte(b.Notification), &c); err != nil {
+						log.Error("notify.config_parse_fail", "bucket", b.Name, "err", err)
+					} else {
+						cfg, buckets[ev.BucketID] = &c, b
+					}
+				}
+				configs[ev.BucketID] = cfg
+			}
+			if cfg == nil {
+				continue
+			}
+			for _, rule := range cfg.rules() {
+				if !rule.match(event, ev.Key) {
+					continue
+				}
+				ds = append(ds, db.NotificationDelivery{
+					BucketID: ev.BucketID, Seq: ev.Seq, RuleID: *rule.id,
+					Sink: rule.sink, Target: rule.target, Key: ev.Key, Event: event,
+					Payload:       string(notificationRecord(buckets[ev.BucketID], ev, event, *rule.id, s.cfg.Region)),
+					Status:        db.NotifyPending,
+					NextAttemptAt: now,
+				})
+			}
+		}
+		cursor = evs[len(evs)-1].Seq
+		if err := s.db.EnqueueNotifications(ds, cursor); err != nil {
+			return err
+		}
+		if len(ds) > 0 {
+			log.Info("notify.enqueued", "deliveries", len(ds), "cursor", cursor)
+		}
+		if len(evs) < notifyBatch {
+			return nil
+		}
+	}
+}
+
+// deliverNotifications — попытка доставки; неудача откладывает следующую
+// попытку (1s, 2s, 4s… до 10 мин), после NOTIFY_MAX_ATTEMPTS — dead-letter.
+func (s *Server) deliverNotifications(ctx context.Context, log *slog.Logger) {
+	ds, err := s.db.DueNotifications(time.Now(), notifyDeliverBatch)
+	if err != nil {
+		log.Error("notify.due_fail", "err", err)
+		return
+	}
+	for i := range ds {
+		d := &ds[i]
+		if ctx.Err() != nil {
+			return
+		}
+		sink, ok := notifySinks[d.Sink]
+		err := fmt.Errorf("unknown sink %q", d.Sink)
+		if ok {
+			err = sink.Send(ctx, d)
+		}
+		if err == nil {
+			if err := s.db.DeleteNotification(d.ID); err != nil {
+				log.Error("notify.delete_fail", "id", d.ID, "err", err)
+			}
+			mNotifications.Inc("delivered")
+			log.Info("notify.delivered", "id", d.ID, "rule", d.RuleID, "event", d.Event, "attempts", d.Attempts+1)
+			continue
+		}
+		attempts := d.Attempts + 1
+		upd := map[string]any{"attempts": attempts, "last_error": err.Error()}
+		if attempts >= s.cfg.NotifyMaxAttempts {
+			upd["status"] = db.NotifyDead
+			mNotifications.Inc("dead")
+			log.Warn("notify.dead_letter", "id", d.ID, "rule", d.RuleID, "target", d.Target, "attempts", attempts, "err", err)
+		} else {
+			backoff := min(time.Second<<min(attempts-1, 20), notifyMaxBackoff)
+			upd["next_attempt_at"] = time.Now().Add(backoff)
+			mNotifications.Inc("retry")
+			log.Info("notify.retry", "id", d.ID, "rule", d.RuleID, "attempts", attempts, "backoff", backoff.String(), "err", err)
+		}
+		if err := s.db.UpdateNotification(d.ID, upd); err != nil {
+			log.Error("notify.update_fail", "id", d.ID, "err", err)
+		}
+	}
+}
+
+// PUT /:bucket?notification — заменить правила; пустой NotificationConfiguration
+// выключает уведомления.
+func (s *Server) handlePutBucketNotification(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.notificationBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	var c NotificationConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 256<<10)).Decode(&c); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse notification xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := c.validate(); err != nil {
+		if errors.Is(err, errNotificationTarget) {
+			writeS3Error(w, http.StatusNotImplemented, "NotImplemented", err.Error(), r.URL.Path, requestIDFrom(r))
+			return
+		}
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	stored := ""
+	if len(c.rules()) > 0 {
+		var buf bytes.Buffer
+		c.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+		_ = xml.NewEncoder(&buf).Encode(c)
+		stored = buf.String()
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, map[string]any{"notification": stored}); err != nil {
+		log.Error("notification.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("notification.put.ok", "rules", len(c.rules()))
+}
+
+// GET /:bucket?notification — без правил отдаётся пустая конфигурация, как в S3.
+func (s *Server) handleGetBucketNotification(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.notificationBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	if b.Notification == "" {
+		_ = xml.NewEncoder(w).Encode(NotificationConfiguration{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/"})
+		return
+	}
+	_, _ = io.WriteString(w, b.Notification)
+}
+
+func (s *Server) notificationBucket(w http.ResponseWriter, r *http.Request, bucket string, log *slog.Logger) (*db.Bucket, bool) {
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, false
+	case err != nil:
+		log.Error("notification.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return b, true
+}
+
+// GET /admin/v1/notifications/dead?after=ID&limit= — недоставленные уведомления.
+func (s *Server) handleAdminDeadNotifications(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	q := r.URL.Query()
+	after, _ := strconv.ParseUint(q.Get("after"), 10, 64)
+	limit := 1000
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "limit must be 1..1000")
+			return
+		}
+		limit = n
+	}
+	ds, err := s.db.ListDeadNotifications(after, limit)
+	if err != nil {
+		loggerFrom(r).Error("admin.notifications.dead_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	if ds == nil {
+		ds = []db.NotificationDelivery{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": ds})
+}
+
+// POST /admin/v1/notifications/dead/requeue — повторить все dead-letter заново.
+func (s *Server) handleAdminRequeueNotifications(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	n, err := s.db.RequeueDeadNotifications(time.Now())
+	if err != nil {
+		loggerFrom(r).Error("admin.notifications.requeue_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	loggerFrom(r).Info("admin.notifications.requeued", "deliveries", n)
+	writeJSON(w, http.StatusOK, map[string]any{"requeued": n})
+}
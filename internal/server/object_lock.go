@@ -0,0 +1,390 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	hdrLockMode          = "x-amz-object-lock-mode"
+	hdrLockRetainUntil   = "x-amz-object-lock-retain-until-date"
+	hdrLockLegalHold     = "x-amz-object-lock-legal-hold"
+	hdrBypassGovernance  = "x-amz-bypass-governance-retention"
+	hdrBucketLockEnabled = "x-amz-bucket-object-lock-enabled"
+)
+
+// срок по умолчанию — не больше ста лет
+const maxLockDefaultYears = 100
+
+// ObjectLockConfiguration — тело PUT и ответ GET /:bucket?object-lock
+type ObjectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration"`
+	Xmlns             string          `xml:"xmlns,attr,omitempty"`
+	ObjectLockEnabled string          `xml:"ObjectLockEnabled,omitempty"`
+	Rule              *ObjectLockRule `xml:"Rule,omitempty"`
+}
+
+type ObjectLockRule struct {
+	DefaultRetention DefaultRetention `xml:"DefaultRetention"`
+}
+
+type DefaultRetention struct {
+	Mode  string `xml:"Mode"`
+	Days  int    `xml:"Days,omitempty"`
+	Years int    `xml:"Years,omitempty"`
+}
+
+// ObjectRetention — тело PUT и ответ GET /:bucket/:key?retention
+type ObjectRetention struct {
+	XMLName         xml.Name `xml:"Retention"`
+	Xmlns           string   `xml:"xmlns,attr,omitempty"`
+	Mode            string   `xml:"Mode,omitempty"`
+	RetainUntilDate string   `xml:"RetainUntilDate,omitempty"`
+}
+
+// ObjectLegalHold — тело PUT и ответ GET /:bucket/:key?legal-hold
+type ObjectLegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Status  string   `xml:"Status"` // ON | OFF
+}
+
+func validLockMode(m string) bool {
+	return m == db.LockGovernance || m == db.LockCompliance
+}
+
+// versionLocked — версию держит Object Lock: legal hold или неистёкший срок
+// хранения. GOVERNANCE снимается bypass'ом, COMPLIANCE — никем.
+func versionLocked(v *db.ObjectVersion, now time.Time, bypassGovernance bool) bool {
+	if v.LegalHold {
+		return true
+	}
+	if v.RetainUntil == nil || !now.Before(*v.RetainUntil) {
+		return false
+	}
+	return v.RetentionMode == db.LockCompliance || !bypassGovernance
+}
+
+// canBypassGovernance — x-amz-bypass-governance-retention: true от самого
+// владельца бакета, а не по разрешению политики или ACL.
+func canBypassGovernance(r *http.Request) bool {
+	ctx := r.Context()
+	return strings.EqualFold(r.Header.Get(hdrBypassGovernance), "true") &&
+		getPrincipalIDFromCtx(ctx) == getUserIDFromCtx(ctx)
+}
+
+// objectLock — Object Lock новой версии из заголовков PUT/Copy.
+type objectLock struct {
+	mode      string
+	until     *time.Time
+	legalHold bool
+}
+
+// fields — колонки версии; пусто — заголовков не было (действует DefaultRetention).
+func (l objectLock) fields() map[string]any {
+	f := map[string]any{}
+	if l.mode != "" {
+		f["retention_mode"], f["retain_until"] = l.mode, *l.until
+	}
+	if l.legalHold {
+		f["legal_hold"] = true
+	}
+	return f
+}
+
+// parseLockHeaders — x-amz-object-lock-*; принимаются только бакетом с Object Lock.
+func parseLockHeaders(h http.Header, b *db.Bucket, now time.Time) (lock objectLock, code, msg string) {
+	mode, until, hold := h.Get(hdrLockMode), h.Get(hdrLockRetainUntil), h.Get(hdrLockLegalHold)
+	if mode == "" && until == "" && hold == "" {
+		return lock, "", ""
+	}
+	if !b.ObjectLockEnabled {
+		return lock, "InvalidRequest", "Bucket is missing Object Lock Configuration"
+	}
+	if (mode == "") != (until == "") {
+		return lock, "InvalidArgument", "x-amz-object-lock-retain-until-date and x-amz-object-lock-mode must both be supplied"
+	}
+	if mode != "" {
+		if !validLockMode(mode) {
+			return lock, "InvalidArgument", "Unknown wormMode directive."
+		}
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return lock, "InvalidArgument", "The retain until date must be provided in ISO 8601 format"
+		}
+		if !t.After(now) {
+			return lock, "InvalidArgument", "The retain until date must be in the future!"
+		}
+		t = t.UTC()
+		lock.mode, lock.until = mode, &t
+	}
+	switch hold {
+	case "", "OFF":
+	case "ON":
+		lock.legalHold = true
+	default:
+		return lock, "InvalidArgument", "Legal Hold must be either of 'ON' or 'OFF'"
+	}
+	return lock, "", ""
+}
+
+// applyDefaultRetentionTx — DefaultRetention бакета для только что записанной версии.
+func (s *Server) applyDefaultRetentionTx(tx *gorm.DB, bucketID uint, verID string) error {
+	b, err := s.db.FindBucketByID(bucketID)
+	if err != nil || !b.ObjectLockEnabled || b.LockDefaultMode == "" {
+		return err
+	}
+	until := time.Now().UTC().AddDate(b.LockDefaultYears, 0, b.LockDefaultDays)
+	return s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"retention_mode": b.LockDefaultMode, "retain_until": until})
+}
+
+// setLockHeaders — Object Lock версии в ответе GET/HEAD.
+func setLockHeaders(w http.ResponseWriter, v *db.ObjectVersion) {
+	if v.RetainUntil != nil {
+		w.Header().Set(hdrLockMode, v.RetentionMode)
+		w.Header().Set(hdrLockRetainUntil, v.RetainUntil.UTC().Format(time.RFC3339))
+	}
+	if v.LegalHold {
+		w.Header().Set(hdrLockLegalHold, "ON")
+	}
+}
+
+// retentionWeakened — пока прежний срок действует, новый короче, мягче по режиму
+// или срок снимается вовсе.
+func retentionWeakened(v *db.ObjectVersion, mode string, until *time.Time, now time.Time) bool {
+	if v.RetainUntil == nil || !now.Before(*v.RetainUntil) {
+		return false
+	}
+	return until == nil || until.Before(*v.RetainUntil) ||
+		v.RetentionMode == db.LockCompliance && mode != db.LockCompliance
+}
+
+// PUT /:bucket?object-lock — включить Object Lock (насовсем) и задать
+// DefaultRetention; без Rule срок по умолчанию снимается.
+func (s *Server) handlePutObjectLockConfig(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.objectLockBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	var c ObjectLockConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&c); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse object lock xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if c.ObjectLockEnabled != "Enabled" {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "ObjectLockEnabled must be Enabled", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if b.Versioning != db.VersioningEnabled {
+		writeS3Error(w, http.StatusConflict, "InvalidBucketState", "Versioning must be 'Enabled' on the bucket to apply a Object Lock configuration", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	upd := map[string]any{"object_lock_enabled": true, "lock_default_mode": "", "lock_default_days": 0, "lock_default_years": 0}
+	if c.Rule != nil {
+		d := c.Rule.DefaultRetention
+		switch {
+		case !validLockMode(d.Mode):
+			writeS3Error(w, http.StatusBadRequest, "MalformedXML", "DefaultRetention Mode must be GOVERNANCE or COMPLIANCE", r.URL.Path, requestIDFrom(r))
+			return
+		case d.Days < 0 || d.Years < 0 || (d.Days > 0) == (d.Years > 0):
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "DefaultRetention must specify either Days or Years as a positive integer", r.URL.Path, requestIDFrom(r))
+			return
+		case d.Years > maxLockDefaultYears || d.Days > maxLockDefaultYears*365:
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "DefaultRetention period is too long", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		upd["lock_default_mode"], upd["lock_default_days"], upd["lock_default_years"] = d.Mode, d.Days, d.Years
+	}
+	if err := s.db.UpdateBucketSettings(b.ID, upd); err != nil {
+		log.Error("object_lock.put.save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("object_lock.put.ok", "default_mode", upd["lock_default_mode"], "was_enabled", b.ObjectLockEnabled)
+}
+
+// GET /:bucket?object-lock
+func (s *Server) handleGetObjectLockConfig(w http.ResponseWriter, r *http.Request, bucket string) {
+	log := loggerFrom(r).With(slog.String("bucket", bucket))
+	b, ok := s.objectLockBucket(w, r, bucket, log)
+	if !ok {
+		return
+	}
+	if !b.ObjectLockEnabled {
+		writeS3Error(w, http.StatusNotFound, "ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	out := ObjectLockConfiguration{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/", ObjectLockEnabled: "Enabled"}
+	if b.LockDefaultMode != "" {
+		out.Rule = &ObjectLockRule{DefaultRetention{Mode: b.LockDefaultMode, Days: b.LockDefaultDays, Years: b.LockDefaultYears}}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) objectLockBucket(w http.ResponseWriter, r *http.Request, bucket string, log *slog.Logger) (*db.Bucket, bool) {
+	b, err := s.db.FindBucket(bucket, getUserIDFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.", "/"+bucket, requestIDFrom(r))
+		return nil, false
+	case err != nil:
+		log.Error("object_lock.db_fail_lookup", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return b, true
+}
+
+// lockTarget — версия для ?retention / ?legal-hold; бакет должен быть с Object Lock.
+func (s *Server) lockTarget(w http.ResponseWriter, r *http.Request, log *slog.Logger) (*db.ObjectVersion, bool) {
+	ver, ok := s.versionTarget(w, r, log)
+	if !ok {
+		return nil, false
+	}
+	b, err := s.db.FindBucketByID(ver.BucketID)
+	if err != nil {
+		log.Error("object_lock.bucket_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	if !b.ObjectLockEnabled {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "Bucket is missing Object Lock Configuration", r.URL.Path, requestIDFrom(r))
+		return nil, false
+	}
+	return ver, true
+}
+
+// PUT /:bucket/:key?retention[&versionId=ID] — продлить срок можно всегда;
+// сократить, смягчить режим или снять — только GOVERNANCE и только с bypass.
+func (s *Server) handlePutObjectRetention(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	ver, ok := s.lockTarget(w, r, log)
+	if !ok {
+		return
+	}
+	var req ObjectRetention
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "cannot parse retention xml", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	now := time.Now()
+	var until *time.Time
+	switch {
+	case req.Mode == "" && req.RetainUntilDate == "":
+		// снять срок
+	case !validLockMode(req.Mode):
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "Retention Mode must be GOVERNANCE or COMPLIANCE", r.URL.Path, requestIDFrom(r))
+		return
+	default:
+		t, err := time.Parse(time.RFC3339, req.RetainUntilDate)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "The retain until date must be provided in ISO 8601 format", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		if !t.After(now) {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "The retain until date must be in the future!", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		t = t.UTC()
+		until = &t
+	}
+
+	// проверка и запись под локом ключа: параллельный PUT не должен сократить
+	// срок, проверенный по устаревшему значению
+	denied := false
+	err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		if err := s.db.LockObjectForUpdate(tx, ver.BucketID, ver.Key); err != nil {
+			return err
+		}
+		cur, err := s.db.GetVersionTx(tx, ver.VersionID)
+		if err != nil {
+			return err
+		}
+		if retentionWeakened(cur, req.Mode, until, now) && (cur.RetentionMode == db.LockCompliance || !canBypassGovernance(r)) {
+			log.Warn("object_lock.retention_denied", "version_id", cur.VersionID, "mode", cur.RetentionMode, "until", cur.RetainUntil)
+			denied = true
+			return nil
+		}
+		return s.db.UpdateVersionFieldsTx(tx, cur.VersionID, map[string]any{"retention_mode": req.Mode, "retain_until": until})
+	})
+	if err != nil {
+		log.Error("object_lock.retention_save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if denied {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied because object protected by object lock.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("object_lock.retention_put.ok", "version_id", ver.VersionID, "mode", req.Mode, "until", req.RetainUntilDate)
+}
+
+// GET /:bucket/:key?retention[&versionId=ID]
+func (s *Server) handleGetObjectRetention(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	ver, ok := s.lockTarget(w, r, log)
+	if !ok {
+		return
+	}
+	if ver.RetainUntil == nil {
+		writeS3Error(w, http.StatusNotFound, "NoSuchObjectLockConfiguration", "The specified object does not have a ObjectLock configuration", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(ObjectRetention{
+		Xmlns:           "http://s3.amazonaws.com/doc/2006-03-01/",
+		Mode:            ver.RetentionMode,
+		RetainUntilDate: ver.RetainUntil.UTC().Format(time.RFC3339),
+	})
+}
+
+// PUT /:bucket/:key?legal-hold[&versionId=ID]
+func (s *Server) handlePutObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	ver, ok := s.lockTarget(w, r, log)
+	if !ok {
+		return
+	}
+	var req ObjectLegalHold
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil || (req.Status != "ON" && req.Status != "OFF") {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", "LegalHold Status must be ON or OFF", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if err := s.db.UpdateVersionFieldsTx(s.db.DB, ver.VersionID, map[string]any{"legal_hold": req.Status == "ON"}); err != nil {
+		log.Error("object_lock.legal_hold_save_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.Info("object_lock.legal_hold_put.ok", "version_id", ver.VersionID, "status", req.Status)
+}
+
+// GET /:bucket/:key?legal-hold[&versionId=ID]
+func (s *Server) handleGetObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	log := loggerFrom(r).With(slog.String("path", r.URL.Path))
+	ver, ok := s.lockTarget(w, r, log)
+	if !ok {
+		return
+	}
+	status := "OFF"
+	if ver.LegalHold {
+		status = "ON"
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(ObjectLegalHold{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/", Status: status})
+}
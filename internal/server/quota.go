@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var mQuotaRejected = metrics.NewCounterVec("s3mini_quota_rejected_total",
+	"Writes rejected by a bucket or user quota.", "scope")
+
+// Квоты бакета и пользователя: проверяет база в транзакции записи версии
+// (commitVersionTx), здесь — ответ клиенту и админский API. PUT с известной
+// длиной проверяется ещё до приёма тела, чтобы не гнать байты впустую.
+
+// quotaView — квота в админском API; 0 — без предела.
+type quotaView struct {
+	MaxBytes   int64 `json:"max_bytes"`
+	MaxObjects int64 `json:"max_objects"`
+}
+
+// writeQuotaError отвечает 403 QuotaExceeded, если err — превышение квоты.
+func writeQuotaError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var qe *db.QuotaError
+	if !errors.As(err, &qe) {
+		return false
+	}
+	mQuotaRejected.Inc(qe.Scope)
+	loggerFrom(r).Warn("quota.exceeded", "scope", qe.Scope, "limit", qe.Limit, "max", qe.Max, "used", qe.Used)
+	writeS3Error(w, http.StatusForbidden, "QuotaExceeded", "The "+qe.Scope+" quota has been exceeded.", r.URL.Path, requestIDFrom(r))
+	return true
+}
+
+// GET    /admin/v1/buckets/{bucket}/quota — квота бакета и занятое место
+// PUT    /admin/v1/buckets/{bucket}/quota {"max_bytes":..., "max_objects":...}
+// DELETE /admin/v1/buckets/{bucket}/quota — снять квоту
+func (s *Server) handleAdminBucketQuota(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	log := loggerFrom(r)
+	b, err := s.db.FindBucketByName(r.PathValue("bucket"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchBucket", "bucket not found")
+		return
+	}
+	if err != nil {
+		log.Error("admin.quota.bucket_lookup_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+
+	q := quotaView{MaxBytes: b.QuotaMaxBytes, MaxObjects: b.QuotaMaxObjects}
+	switch r.Method {
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "MalformedJSON", err.Error())
+			return
+		}
+		if q.MaxBytes < 0 || q.MaxObjects < 0 {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "quota must not be negative")
+			return
+		}
+	case http.MethodDelete:
+		q = quotaView{}
+	}
+	if r.Method != http.MethodGet {
+		err := s.db.UpdateBucketSettings(b.ID, map[string]any{"quota_max_bytes": q.MaxBytes, "quota_max_objects": q.MaxObjects})
+		if err != nil {
+			log.Error("admin.quota.save_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		s.audit.Info("admin.quota.set", "bucket", b.Name, "max_bytes", q.MaxBytes, "max_objects", q.MaxObjects)
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	usage, err := s.db.BucketUsageCounters(b.ID, 0)
+	if err != nil {
+		log.Error("admin.quota.usage_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	resp := map[string]any{"bucket": b.Name, "quota": q}
+	if len(usage) > 0 {
+		resp["usage"] = usage[0]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
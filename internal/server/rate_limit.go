@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var mRateLimited = metrics.NewCounterVec("s3mini_rate_limited_total",
+	"Requests rejected with SlowDown by per-access-key limits.", "limit") // rate|inflight
+
+// keyLimits — лимиты одного ключа доступа; 0 — без ограничения.
+type keyLimits struct {
+	RPS      float64 // запросов в секунду (скорость пополнения корзины)
+	Burst    int     // ёмкость корзины; 0 — max(1, RPS)
+	InFlight int     // одновременных запросов
+}
+
+// limitsFor — лимиты пользователя из БД, незаданные берутся из конфигурации.
+func (s *Server) limitsFor(u *db.User) keyLimits {
+	l := keyLimits{RPS: s.cfg.KeyRateLimitRPS, Burst: s.cfg.KeyRateBurst, InFlight: s.cfg.KeyMaxInFlight}
+	if u.RateLimitRPS != nil {
+		l.RPS = *u.RateLimitRPS
+	}
+	if u.RateBurst != nil {
+		l.Burst = *u.RateBurst
+	}
+	if u.MaxInFlight != nil {
+		l.InFlight = *u.MaxInFlight
+	}
+	return l
+}
+
+// acquireKeyLimit — acquire для ключа запроса; при превышении лимита пишет
+// SlowDown с Retry-After и возвращает ok=false. release обязателен при ok=true.
+func (s *Server) acquireKeyLimit(w http.ResponseWriter, r *http.Request, accessKeyID string, u *db.User) (release func(), ok bool) {
+	release, limit, retry, ok := s.keyLimiter.acquire(accessKeyID, s.limitsFor(u))
+	if !ok {
+		mRateLimited.Inc(limit)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		writeS3Error(w, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.", r.URL.Path, requestIDFrom(r))
+	}
+	return release, ok
+}
+
+// keyLimiter — token bucket и счётчик одновременных запросов на каждый
+// access key. Состояние только в памяти: после рестарта корзины полные.
+type keyLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*keyBucket
+	now     func() time.Time
+}
+
+type keyBucket struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+func newKeyLimiter() *keyLimiter {
+	return &keyLimiter{buckets: map[string]*keyBucket{}, now: time.Now}
+}
+
+// acquire берёт токен и слот; release обязателен при ok=true. limit —
+// какой лимит сработал ("rate"|"inflight"), retry — когда появится токен.
+func (kl *keyLimiter) acquire(accessKeyID string, l keyLimits) (release func(), limit string, retry time.Duration, ok bool) {
+	if l.RPS <= 0 && l.InFlight <= 0 {
+		return func() {}, "", 0, true
+	}
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	now := kl.now()
+	burst := float64(l.Burst)
+	if burst <= 0 {
+		burst = max(1, l.RPS)
+	}
+	b := kl.buckets[accessKeyID]
+	if b == nil {
+		b = &keyBucket{tokens: burst, last: now}
+		kl.buckets[accessKeyID] = b
+	}
+	if l.InFlight > 0 && b.inFlight >= l.InFlight {
+		return nil, "inflight", time.Second, false
+	}
+	if l.RPS > 0 {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*l.RPS)
+		b.last = now
+		if b.tokens < 1 {
+			return nil, "rate", time.Duration((1 - b.tokens) / l.RPS * float64(time.Second)), false
+		}
+		b.tokens--
+	}
+	b.inFlight++
+	return func() {
+		kl.mu.Lock()
+		b.inFlight--
+		kl.mu.Unlock()
+	}, "", 0, true
+}
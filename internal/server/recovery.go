@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	mRecoveryRemoved = metrics.NewCounterVec("s3mini_recovery_removed_total",
+		"Leftovers of interrupted writes removed by crash recovery, by kind (tmp_file, pending_blob).", "kind")
+	mRecoveryFreedBytes = metrics.NewCounter("s3mini_recovery_freed_bytes_total",
+		"Bytes freed by crash recovery.")
+)
+
+// Уборка после сбоев: процесс, упавший посреди PUT, оставляет недописанные
+// .tmp-* файлы, а оборванная загрузка — блобы в state=pending, которые GC не
+// трогает. Всё старше RECOVERY_AGE_S убирается при старте и дальше раз в every.
+// tmp-файлы у каждого процесса свои (локальный диск), поэтому их чистит каждый;
+// pending-записи — общие, их чистит держатель lease.
+
+// StartRecovery запускает уборку: первый проход сразу, дальше раз в every.
+func (s *Server) StartRecovery(ctx context.Context, every time.Duration, batch int) {
+	age := time.Duration(s.cfg.RecoveryAgeS) * time.Second
+	log := s.Logger.With(slog.String("comp", "recovery"))
+	if age <= 0 {
+		log.Info("recovery.disabled")
+		return
+	}
+	go func() {
+		log.Info("recovery.started", "every", every.String(), "age", age.String())
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			if !s.isReadOnly() {
+				s.recoverOnce(ctx, log, age, every, batch)
+			}
+			select {
+			case <-ctx.Done():
+				log.Info("recovery.stopped", "reason", "context canceled")
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (s *Server) recoverOnce(ctx context.Context, log *slog.Logger, age, every time.Duration, batch int) {
+	start := time.Now()
+	files, bytes := s.recoverTmpFiles(ctx, log, start.Add(-age))
+
+	var blobs int
+	if s.holdLease(log, "recovery", every) {
+		var freed int64
+		blobs, freed = s.recoverPendingBlobs(ctx, log, start.Add(-age), batch)
+		bytes += freed
+	}
+	if files > 0 || blobs > 0 {
+		log.Info("recovery.pass_end", "tmp_files", files, "pending_blobs", blobs,
+			"freed_bytes", bytes, "dur_ms", time.Since(start).Milliseconds())
+	}
+}
+
+// recoverTmpFiles удаляет недописанные файлы, не менявшиеся с before. Идущая
+// запись обновляет ModTime, а открытые сессии своего процесса драйвер
+// не отдаёт (ErrTmpInUse).
+func (s *Server) recoverTmpFiles(ctx context.Context, log *slog.Logger, before time.Time) (int, int64) {
+	var (
+		files int
+		bytes int64
+	)
+	for _, node := range s.storage.NodeNames() {
+		_, err := s.storage.WalkNode(ctx, node, func(f storage.StoredFile) error {
+			if !f.Tmp || !f.ModTime.Before(before) {
+				return nil
+			}
+			err := s.storage.RemoveTmpNode(ctx, node, f.Name)
+			if errors.Is(err, storage.ErrTmpInUse) {
+				return nil
+			}
+			if err != nil {
+				log.Error("recovery.tmp_remove_fail", "node", node, "file", f.Name, "err", err)
+				return nil
+			}
+			files++
+			bytes += f.Size
+			mRecoveryRemoved.Inc("tmp_file")
+			mRecoveryFreedBytes.Add(uint64(f.Size))
+			log.Info("recovery.tmp_removed", "node", node, "file", f.Name, "size", f.Size,
+				"age", time.Since(f.ModTime).Round(time.Second).String())
+			return nil
+		})
+		if err != nil {
+			log.Error("recovery.walk_fail", "node", node, "err", err)
+		}
+	}
+	return files, bytes
+}
+
+// recoverPendingBlobs удаляет записи и байты блобов, застрявших в pending с before.
+func (s *Server) recoverPendingBlobs(ctx context.Context, log *slog.Logger, before time.Time, batch int) (int, int64) {
+	var (
+		blobs int
+		bytes int64
+	)
+	for ctx.Err() == nil {
+		rows, err := s.db.StalePendingBlobs(before, batch)
+		if err != nil {
+			log.Error("recovery.pending_query_fail", "err", err)
+			break
+		}
+		removed := 0
+		for _, r := range rows {
+			var gone bool
+			err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+				var err error
+				gone, err = s.db.DeleteStalePendingBlobTx(tx, r.ID, before)
+				return err
+			})
+			if err != nil {
+				log.Error("recovery.pending_delete_fail", "blob_id", r.ID, "err", err)
+				continue
+			}
+			if !gone {
+				continue
+			}
+			// записи уже нет: не удалились байты — останутся сиротой для fsck
+			if err := s.storage.Delete(ctx, r.ID); err != nil {
+				log.Error("recovery.storage_delete_fail", "blob_id", r.ID, "err", err)
+			}
+			removed++
+			bytes += r.Size
+			mRecoveryRemoved.Inc("pending_blob")
+			mRecoveryFreedBytes.Add(uint64(r.Size))
+			log.Info("recovery.pending_removed", "blob_id", r.ID, "size", r.Size)
+		}
+		blobs += removed
+		// не удалось ничего — на следующем проходе, а не по кругу
+		if len(rows) < batch || removed == 0 {
+			break
+		}
+	}
+	return blobs, bytes
+}
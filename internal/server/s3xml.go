@@ -3,6 +3,8 @@ package server
 import (
 	"encoding/xml"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -15,13 +17,19 @@ type s3Error struct {
 	Message   string   `xml:"Message"`
 	Resource  string   `xml:"Resource,omitempty"`
 	RequestID string   `xml:"RequestId,omitempty"`
+	HostID    string   `xml:"HostId,omitempty"`
 }
 
+// writeS3Error — XML-ошибка S3. Пустой reqID берётся из x-amz-request-id, который
+// уже выставил WithRequestLogger, так что RequestId/HostId есть в любой ошибке.
 func writeS3Error(w http.ResponseWriter, status int, code, msg, resource, reqID string) {
+	if reqID == "" {
+		reqID = w.Header().Get(hdrRequestID)
+	}
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(status)
 	_ = xml.NewEncoder(w).Encode(s3Error{
-		Code: code, Message: msg, Resource: resource, RequestID: reqID,
+		Code: code, Message: msg, Resource: resource, RequestID: reqID, HostID: w.Header().Get(hdrHostID),
 	})
 }
 
@@ -101,22 +109,73 @@ func writeListObjectsV2(
 
 type LifecycleConfiguration struct {
 	XMLName xml.Name `xml:"LifecycleConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
 	Rules   []Rule   `xml:"Rule"`
 }
+
+// rawLifecycleRules — те же <Rule>, но сырым XML: GET ?lifecycle отдаёт их как
+// прислали, иначе terraform и компания видят вечный diff.
+type rawLifecycleRules struct {
+	Rules []struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"Rule"`
+}
 type Rule struct {
 	ID     string  `xml:"ID,omitempty"`
 	Status string  `xml:"Status"` // Enabled/Disabled
 	Filter *Filter `xml:"Filter,omitempty"`
 	// действия
+	Transition                     *Transition                     `xml:"Transition,omitempty"`
 	Expiration                     *Expiration                     `xml:"Expiration,omitempty"`
 	NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration,omitempty"`
 	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload,omitempty"`
 }
 type Filter struct {
-	Prefix string `xml:"Prefix,omitempty"`
+	Prefix                string     `xml:"Prefix,omitempty"`
+	Tag                   *TagXML    `xml:"Tag,omitempty"`
+	ObjectSizeGreaterThan *int64     `xml:"ObjectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    *int64     `xml:"ObjectSizeLessThan,omitempty"`
+	And                   *FilterAnd `xml:"And,omitempty"`
+}
+
+// FilterAnd — несколько условий фильтра сразу (все должны выполниться).
+type FilterAnd struct {
+	Prefix                string   `xml:"Prefix,omitempty"`
+	Tags                  []TagXML `xml:"Tag"`
+	ObjectSizeGreaterThan *int64   `xml:"ObjectSizeGreaterThan,omitempty"`
+	ObjectSizeLessThan    *int64   `xml:"ObjectSizeLessThan,omitempty"`
+}
+
+// conditions — условия фильтра с раскрытым And.
+func (f *Filter) conditions() (prefix string, tags []TagXML, gt, lt *int64) {
+	if f == nil {
+		return "", nil, nil, nil
+	}
+	prefix, gt, lt = f.Prefix, f.ObjectSizeGreaterThan, f.ObjectSizeLessThan
+	if f.Tag != nil {
+		tags = append(tags, *f.Tag)
+	}
+	if a := f.And; a != nil {
+		prefix += a.Prefix
+		tags = append(tags, a.Tags...)
+		gt, lt = coalescePtr(gt, a.ObjectSizeGreaterThan), coalescePtr(lt, a.ObjectSizeLessThan)
+	}
+	return
+}
+
+func coalescePtr[T any](a, b *T) *T {
+	if a != nil {
+		return a
+	}
+	return b
+}
+type Transition struct {
+	Days         *int   `xml:"Days,omitempty"`
+	StorageClass string `xml:"StorageClass"`
 }
 type Expiration struct {
-	Days *int `xml:"Days,omitempty"`
+	Days *int   `xml:"Days,omitempty"`
+	Date string `xml:"Date,omitempty"` // ISO 8601, полночь UTC
 }
 type NoncurrentVersionExpiration struct {
 	NoncurrentDays          *int `xml:"NoncurrentDays,omitempty"`
@@ -127,14 +186,32 @@ type AbortIncompleteMultipartUpload struct {
 }
 
 func ruleFromXML(bucketID uint, x Rule) db.LifecycleRule {
-	prefix := ""
-	if x.Filter != nil {
-		prefix = x.Filter.Prefix
-	}
+	prefix, tags, gt, lt := x.Filter.conditions()
 	enabled := strings.EqualFold(x.Status, "Enabled")
-	r := db.LifecycleRule{BucketID: bucketID, Prefix: prefix, Enabled: enabled}
+	r := db.LifecycleRule{
+		BucketID: bucketID, RuleID: x.ID, Prefix: prefix, Enabled: enabled,
+		SizeGreaterThan: gt, SizeLessThan: lt,
+	}
+	if len(tags) > 0 {
+		q := url.Values{}
+		for _, t := range tags {
+			q.Set(t.Key, t.Value)
+		}
+		r.Tags = q.Encode()
+	}
 	if x.Expiration != nil {
 		r.ExpireCurrentAfterDays = x.Expiration.Days
+		if d, err := time.Parse(time.RFC3339, x.Expiration.Date); err == nil {
+			d = d.UTC()
+			r.ExpireCurrentOnDate = &d
+		}
+	}
+	if x.AbortIncompleteMultipartUpload != nil {
+		r.AbortIncompleteAfterDays = x.AbortIncompleteMultipartUpload.DaysAfterInitiation
+	}
+	if x.Transition != nil {
+		r.TransitionToClass = x.Transition.StorageClass
+		r.TransitionAfterDays = x.Transition.Days
 	}
 	if x.NoncurrentVersionExpiration != nil {
 		r.ExpireNoncurrentAfterDays = x.NoncurrentVersionExpiration.NoncurrentDays
@@ -150,8 +227,15 @@ func ruleToXML(r db.LifecycleRule) Rule {
 		status = "Enabled"
 	}
 	var exp *Expiration
-	if r.ExpireCurrentAfterDays != nil {
+	if r.ExpireCurrentAfterDays != nil || r.ExpireCurrentOnDate != nil {
 		exp = &Expiration{Days: r.ExpireCurrentAfterDays}
+		if r.ExpireCurrentOnDate != nil {
+			exp.Date = r.ExpireCurrentOnDate.UTC().Format(timeRFC3339)
+		}
+	}
+	var tr *Transition
+	if r.TransitionToClass != "" {
+		tr = &Transition{Days: r.TransitionAfterDays, StorageClass: r.TransitionToClass}
 	}
 	var nce *NoncurrentVersionExpiration
 	if r.ExpireNoncurrentAfterDays != nil || r.NoncurrentNewerVersionsToKeep != nil {
@@ -160,11 +244,377 @@ func ruleToXML(r db.LifecycleRule) Rule {
 			NewerNoncurrentVersions: r.NoncurrentNewerVersionsToKeep,
 		}
 	}
+	var aimu *AbortIncompleteMultipartUpload
+	if r.AbortIncompleteAfterDays != nil {
+		aimu = &AbortIncompleteMultipartUpload{DaysAfterInitiation: r.AbortIncompleteAfterDays}
+	}
 	return Rule{
-		Status:                      status,
-		Filter:                      &Filter{Prefix: r.Prefix},
-		Expiration:                  exp,
-		NoncurrentVersionExpiration: nce,
-		// AbortIncompleteMultipartUpload можно добавить позже
+		ID:                             r.RuleID,
+		Status:                         status,
+		Filter:                         filterToXML(&r),
+		Transition:                     tr,
+		Expiration:                     exp,
+		NoncurrentVersionExpiration:    nce,
+		AbortIncompleteMultipartUpload: aimu,
+	}
+}
+
+// filterToXML — только префикс отдаётся как раньше, составной фильтр — через And.
+func filterToXML(r *db.LifecycleRule) *Filter {
+	tagMap := r.TagFilter()
+	if len(tagMap) == 0 && r.SizeGreaterThan == nil && r.SizeLessThan == nil {
+		return &Filter{Prefix: r.Prefix}
 	}
+	keys := make([]string, 0, len(tagMap))
+	for k := range tagMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := make([]TagXML, 0, len(keys))
+	for _, k := range keys {
+		tags = append(tags, TagXML{Key: k, Value: tagMap[k]})
+	}
+	return &Filter{And: &FilterAnd{
+		Prefix: r.Prefix, Tags: tags,
+		ObjectSizeGreaterThan: r.SizeGreaterThan, ObjectSizeLessThan: r.SizeLessThan,
+	}}
+}
+
+// BucketSettings — расширение s3mini (/:bucket?settings) для настроек,
+// у которых нет стандартного S3-API. PUT обновляет только переданные секции.
+type BucketSettings struct {
+	XMLName           xml.Name                 `xml:"BucketSettings"`
+	Profile           string                   `xml:"Profile,omitempty"` // только чтение, задаётся при создании
+	Security          *BucketSecuritySettings  `xml:"Security,omitempty"`
+	VersionCompaction *VersionCompactionConfig `xml:"VersionCompaction,omitempty"`
+	Transforms        *TransformsConfig        `xml:"Transforms,omitempty"`
+	ContentType       *ContentTypeConfig       `xml:"ContentTypeDetection,omitempty"`
+	Protection        *ProtectionConfig        `xml:"Protection,omitempty"`
+	IPFilter          *IPFilterConfig          `xml:"IPFilter,omitempty"`
+	Trash             *TrashConfig             `xml:"Trash,omitempty"`
+}
+
+type BucketSecuritySettings struct {
+	RejectUnsignedPayload       bool `xml:"RejectUnsignedPayload"`
+	RequireContentChecksum      bool `xml:"RequireContentChecksum"`
+	RequireServerSideEncryption bool `xml:"RequireServerSideEncryption"`
+}
+
+type VersionCompactionConfig struct {
+	Enabled bool `xml:"Enabled"`
+}
+
+type TransformsConfig struct {
+	Enabled bool `xml:"Enabled"`
+}
+
+type ContentTypeConfig struct {
+	Enabled bool `xml:"Enabled"`
+}
+
+// ProtectionConfig — WORM-окно: версии моложе Days нельзя удалить.
+type ProtectionConfig struct {
+	Days int `xml:"Days"`
+}
+
+// TrashConfig — удалённые версии Days дней лежат в корзине; 0 — корзины нет.
+type TrashConfig struct {
+	Days int `xml:"Days"`
+}
+
+// IPFilterConfig — адреса клиентов (IP или CIDR): Deny сильнее Allow, без Allow — все.
+type IPFilterConfig struct {
+	Allow []string `xml:"Allow"`
+	Deny  []string `xml:"Deny"`
+}
+
+func bucketSettingsToXML(b *db.Bucket) BucketSettings {
+	return BucketSettings{
+		Profile: b.Profile,
+		Security: &BucketSecuritySettings{
+			RejectUnsignedPayload:       b.RejectUnsignedPayload,
+			RequireContentChecksum:      b.RequireContentChecksum,
+			RequireServerSideEncryption: b.RequireSSE,
+		},
+		VersionCompaction: &VersionCompactionConfig{Enabled: b.CompactIdenticalVersions},
+		Transforms:        &TransformsConfig{Enabled: b.TransformsEnabled},
+		ContentType:       &ContentTypeConfig{Enabled: b.DetectContentType},
+		Protection:        &ProtectionConfig{Days: b.ProtectionDays},
+		IPFilter:          &IPFilterConfig{Allow: splitCIDRs(b.IPAllow), Deny: splitCIDRs(b.IPDeny)},
+		Trash:             &TrashConfig{Days: b.TrashDays},
+	}
+}
+
+// bucketSettingsFields — колонки для UpdateBucketSettings из переданных секций.
+func bucketSettingsFields(x BucketSettings) map[string]any {
+	f := map[string]any{}
+	if sec := x.Security; sec != nil {
+		f["reject_unsigned_payload"] = sec.RejectUnsignedPayload
+		f["require_content_checksum"] = sec.RequireContentChecksum
+		f["require_sse"] = sec.RequireServerSideEncryption
+	}
+	if vc := x.VersionCompaction; vc != nil {
+		f["compact_identical_versions"] = vc.Enabled
+	}
+	if tc := x.Transforms; tc != nil {
+		f["transforms_enabled"] = tc.Enabled
+	}
+	if ct := x.ContentType; ct != nil {
+		f["detect_content_type"] = ct.Enabled
+	}
+	if p := x.Protection; p != nil {
+		f["protection_days"] = p.Days
+	}
+	if ipf := x.IPFilter; ipf != nil {
+		f["ip_allow"] = strings.Join(ipf.Allow, ",")
+		f["ip_deny"] = strings.Join(ipf.Deny, ",")
+	}
+	if t := x.Trash; t != nil {
+		f["trash_days"] = t.Days
+	}
+	return f
+}
+
+// ComposeRequest — расширение s3mini (POST /:bucket/:key?compose, по мотивам GCS compose):
+// новый объект из диапазонов существующих объектов того же бакета без копирования байт.
+type ComposeRequest struct {
+	XMLName     xml.Name        `xml:"ComposeRequest"`
+	ContentType string          `xml:"ContentType,omitempty"`
+	Sources     []ComposeSource `xml:"Source"`
+}
+
+type ComposeSource struct {
+	Key       string `xml:"Key"`
+	VersionId string `xml:"VersionId,omitempty"`
+	Range     string `xml:"Range,omitempty"` // bytes=a-b
+}
+
+type ComposeObjectResult struct {
+	XMLName      xml.Name `xml:"ComposeObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+	Size         int64    `xml:"Size"`
+}
+
+// LocationConstraint — ответ GET /:bucket?location; для us-east-1 пусто, как в S3
+type LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+// VersioningConfiguration — ?versioning на бакете
+type VersioningConfiguration struct {
+	XMLName   xml.Name `xml:"VersioningConfiguration"`
+	Xmlns     string   `xml:"xmlns,attr,omitempty"`
+	Status    string   `xml:"Status,omitempty"`
+	MfaDelete string   `xml:"MfaDelete,omitempty"`
+}
+
+// Tagging — тело PUT и ответ GET /:bucket/:key?tagging
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	TagSet  []TagXML `xml:"TagSet>Tag"`
+}
+
+type TagXML struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// AccessControlPolicy — ответ GET и тело PUT ?acl (бакет и объект)
+type AccessControlPolicy struct {
+	XMLName xml.Name   `xml:"AccessControlPolicy"`
+	Xmlns   string     `xml:"xmlns,attr,omitempty"`
+	Owner   S3Owner    `xml:"Owner"`
+	Grants  []ACLGrant `xml:"AccessControlList>Grant"`
+}
+
+type ACLGrant struct {
+	Grantee    ACLGrantee `xml:"Grantee"`
+	Permission string     `xml:"Permission"`
+}
+
+type ACLGrantee struct {
+	XSI         string `xml:"xmlns:xsi,attr,omitempty"`
+	Type        string `xml:"xsi:type,attr,omitempty"` // CanonicalUser | Group
+	ID          string `xml:"ID,omitempty"`
+	DisplayName string `xml:"DisplayName,omitempty"`
+	URI         string `xml:"URI,omitempty"`
+}
+
+// CopyObjectResult — ответ PUT /:bucket/:key с x-amz-copy-source
+type CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+// VerifyObjectResult — ответ POST /:bucket/:key?verify
+type VerifyObjectResult struct {
+	XMLName    xml.Name           `xml:"VerifyObjectResult"`
+	Key        string             `xml:"Key"`
+	VersionId  string             `xml:"VersionId"`
+	Status     string             `xml:"Status"` // ok|corrupt|missing
+	ETag       string             `xml:"ETag"`
+	ETagMatch  *bool              `xml:"ETagMatch,omitempty"` // только для ETag вида sha256:
+	Size       int64              `xml:"Size"`
+	VerifiedAt string             `xml:"VerifiedAt"`
+	Blobs      []VerifyBlobResult `xml:"Blob"`
+}
+
+type VerifyBlobResult struct {
+	BlobId   string `xml:"BlobId"`
+	Status   string `xml:"Status"`
+	Expected string `xml:"ExpectedChecksum"`
+	Actual   string `xml:"ActualChecksum,omitempty"`
+	Size     int64  `xml:"Size"`
+	ReadSize int64  `xml:"ActualSize"`
+}
+
+// ChangeFeedResult — ответ GET /:bucket?changes (лента изменений метаданных)
+type ChangeFeedResult struct {
+	XMLName   xml.Name         `xml:"ChangeFeedResult"`
+	Bucket    string           `xml:"Bucket"`
+	After     uint64           `xml:"After"`
+	NextAfter uint64           `xml:"NextAfter"` // передать как after в следующем запросе
+	OldestSeq uint64           `xml:"OldestSeq"` // after+1 < OldestSeq — часть событий уже удалена
+	Events    []ChangeEventXML `xml:"Event"`
+}
+
+type ChangeEventXML struct {
+	Seq       uint64 `xml:"Seq"`
+	Type      string `xml:"Type"`
+	Key       string `xml:"Key"`
+	VersionId string `xml:"VersionId,omitempty"`
+	ETag      string `xml:"ETag,omitempty"`
+	Size      *int64 `xml:"Size,omitempty"`
+	Time      string `xml:"Time"`
+}
+
+// ---- Multipart upload ----
+
+type InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadId string   `xml:"UploadId"`
+}
+
+type CompleteMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []CompletedPart `xml:"Part"`
+}
+
+type CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// CopyPartResult — ответ UploadPartCopy
+type CopyPartResult struct {
+	XMLName      xml.Name `xml:"CopyPartResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+type MultipartInitiatorXML struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type ListMultipartUploadsResult struct {
+	XMLName            xml.Name             `xml:"ListMultipartUploadsResult"`
+	Xmlns              string               `xml:"xmlns,attr"`
+	Bucket             string               `xml:"Bucket"`
+	KeyMarker          string               `xml:"KeyMarker"`
+	UploadIdMarker     string               `xml:"UploadIdMarker"`
+	NextKeyMarker      string               `xml:"NextKeyMarker,omitempty"`
+	NextUploadIdMarker string               `xml:"NextUploadIdMarker,omitempty"`
+	Prefix             string               `xml:"Prefix"`
+	Delimiter          string               `xml:"Delimiter,omitempty"`
+	MaxUploads         int                  `xml:"MaxUploads"`
+	EncodingType       string               `xml:"EncodingType,omitempty"`
+	IsTruncated        bool                 `xml:"IsTruncated"`
+	Uploads            []MultipartUploadXML `xml:"Upload,omitempty"`
+	CommonPrefixes     []CommonPrefix       `xml:"CommonPrefixes,omitempty"`
+}
+
+type MultipartUploadXML struct {
+	Key          string                `xml:"Key"`
+	UploadId     string                `xml:"UploadId"`
+	Initiator    MultipartInitiatorXML `xml:"Initiator"`
+	Owner        MultipartInitiatorXML `xml:"Owner"`
+	StorageClass string                `xml:"StorageClass"`
+	Initiated    string                `xml:"Initiated"`
+}
+
+type ListPartsResult struct {
+	XMLName              xml.Name              `xml:"ListPartsResult"`
+	Xmlns                string                `xml:"xmlns,attr"`
+	Bucket               string                `xml:"Bucket"`
+	Key                  string                `xml:"Key"`
+	UploadId             string                `xml:"UploadId"`
+	Initiator            MultipartInitiatorXML `xml:"Initiator"`
+	Owner                MultipartInitiatorXML `xml:"Owner"`
+	StorageClass         string                `xml:"StorageClass"`
+	PartNumberMarker     int                   `xml:"PartNumberMarker"`
+	NextPartNumberMarker int                   `xml:"NextPartNumberMarker"`
+	MaxParts             int                   `xml:"MaxParts"`
+	IsTruncated          bool                  `xml:"IsTruncated"`
+	Parts                []PartXML             `xml:"Part,omitempty"`
+}
+
+type PartXML struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type ListVersionsResult struct {
+	XMLName             xml.Name           `xml:"ListVersionsResult"`
+	Xmlns               string             `xml:"xmlns,attr"`
+	Name                string             `xml:"Name"`
+	Prefix              string             `xml:"Prefix"`
+	KeyMarker           string             `xml:"KeyMarker"`
+	VersionIdMarker     string             `xml:"VersionIdMarker"`
+	NextKeyMarker       string             `xml:"NextKeyMarker,omitempty"`
+	NextVersionIdMarker string             `xml:"NextVersionIdMarker,omitempty"`
+	Delimiter           string             `xml:"Delimiter,omitempty"`
+	MaxKeys             int                `xml:"MaxKeys"`
+	EncodingType        string             `xml:"EncodingType,omitempty"`
+	IsTruncated         bool               `xml:"IsTruncated"`
+	Entries             []ObjectVersionXML // Version и DeleteMarker вперемешку, в порядке листинга
+	CommonPrefixes      []CommonPrefix     `xml:"CommonPrefixes,omitempty"`
+}
+
+// ObjectVersionXML — <Version> или <DeleteMarker> (имя элемента в XMLName).
+type ObjectVersionXML struct {
+	XMLName      xml.Name
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         *int64 `xml:"Size,omitempty"`
+	StorageClass string `xml:"StorageClass,omitempty"`
+}
+
+// RestoreRequest — тело POST /:bucket/:key?restore; Tier принимается, но скорость одна
+type RestoreRequest struct {
+	XMLName xml.Name `xml:"RestoreRequest"`
+	Days    int      `xml:"Days"`
+	Tier    string   `xml:"GlacierJobParameters>Tier"`
 }
@@ -1,26 +1,80 @@
 package server
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/DanikLP1/s3-storage-service/internal/auth"
+	"github.com/DanikLP1/s3-storage-service/internal/cluster"
+	"github.com/DanikLP1/s3-storage-service/internal/config"
 	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
 	"github.com/DanikLP1/s3-storage-service/internal/storage"
 )
 
 type Server struct {
 	db      *db.DB
 	storage *storage.Storage
+	cfg     config.Config
 	Logger  *slog.Logger
+	audit   *slog.Logger
+
+	authThrottle *authThrottle
+	sigReplay    *auth.ReplayCache // nil — AUTH_REPLAY_CHECK=0
+	leaseHolder  string            // node:pid — держатель lease'ов фоновых воркеров
+	keyLimiter   *keyLimiter
+	hooks        hooks
+	scripts      sync.Map // ID бакета -> *cachedScript
+	simCounters  simCounters
+	accessLog    accessLogBuffer
+
+	// межузловой канал: проверка входящих запросов
+	nodeAuth *cluster.Verifier
+
+	// SAN клиентского сертификата -> access key (MTLS_IDENTITIES)
+	certIdentities map[string]string
+	// HMAC токенов продолжения ListObjectsV2
+	listTokenKey []byte
+	// режим только чтения; nil — обычный
+	readOnly atomic.Pointer[readOnlyState]
+	// последний замер места на основном узле; nil — не замерялось
+	disk atomic.Pointer[diskState]
 }
 
-func New(database *db.DB, d storage.StorageDriver, logger *slog.Logger) *Server {
-	return &Server{
+func New(database *db.DB, d storage.StorageDriver, logger *slog.Logger, cfg config.Config) *Server {
+	s := &Server{
 		db:      database,
 		storage: storage.NewWithDriver(d),
+		cfg:     cfg,
 		Logger:  logger,
+		audit:   logger.With(slog.String("comp", "audit")),
+		authThrottle: newAuthThrottle(cfg.AuthMaxFailures,
+			time.Duration(cfg.AuthLockoutBaseS)*time.Second,
+			time.Duration(cfg.AuthLockoutMaxS)*time.Second,
+			time.Duration(cfg.AuthFailDelayMS)*time.Millisecond,
+			time.Duration(cfg.AuthFailDelayMaxMS)*time.Millisecond),
+		nodeAuth: cluster.NewVerifier([]byte(cfg.ClusterSecret), 5*time.Minute),
+
+		leaseHolder: fmt.Sprintf("%s:%d", cfg.NodeID, os.Getpid()),
+		keyLimiter:  newKeyLimiter(),
+	}
+	// формат уже проверен при старте (main)
+	s.certIdentities, _ = cfg.CertIdentities()
+	s.listTokenKey = listTokenKey(cfg)
+	if cfg.AuthReplayCheck {
+		s.sigReplay = auth.NewReplayCache(2 * time.Duration(cfg.MaxClockSkewS) * time.Second)
+	}
+	if cfg.ReadOnly {
+		s.SetReadOnly(true, "READ_ONLY=1")
 	}
+	s.OnPostAuth(s.bucketScriptHook)
+	return s
 }
 
 // Router возвращает http.Handler, который вешается в main.go
@@ -39,39 +93,34 @@ func (s *Server) Router() http.Handler {
 		}
 		w.WriteHeader(http.StatusOK)
 	})
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle(internalPrefix, s.internalRouter())
+	mux.Handle(adminPrefix, s.adminRouter())
 
 	// Главный маршрутизатор S3 API
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/", s.withReadOnly(s.withSimulation(s.withChaos(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Корень: список бакетов
 		if r.URL.Path == "/" {
 			if r.Method == http.MethodGet {
 				s.handleListBuckets(w, r)
 				return
 			}
-			writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "only GET on /", r.URL.Path, "")
+			writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "only GET on /", r.URL.Path, requestIDFrom(r))
 			return
 		}
 
-		// helpers
-		hasLifecycle := func() bool {
-			// Go 1.21+: r.URL.Query().Has("lifecycle")
-			q := r.URL.Query()
-			if _, ok := q["lifecycle"]; ok {
-				return true
-			}
-			// также ловим варианты типа ?lifecycle=1
-			return q.Get("lifecycle") != ""
-		}
-
 		p := strings.Trim(r.URL.Path, "/")
 		parts := strings.SplitN(p, "/", 2)
+		if (r.Method == http.MethodPut || r.Method == http.MethodPost) && !s.bucketNameAvailable(w, r, parts[0]) {
+			return
+		}
 
 		// -------- Bucket-level --------
 		if len(parts) == 1 {
 			bucket := parts[0]
 
 			// S3 lifecycle: /:bucket?lifecycle
-			if hasLifecycle() {
+			if hasSubresource(r, "lifecycle") {
 				switch r.Method {
 				case http.MethodPut:
 					s.handlePutBucketLifecycle(w, r, bucket) // читает XML из тела, сохраняет правила
@@ -83,9 +132,192 @@ func (s *Server) Router() http.Handler {
 					s.handleDeleteBucketLifecycle(w, r, bucket) // удаляет правила, 204
 					return
 				default:
-					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported lifecycle method", r.URL.Path, "")
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported lifecycle method", r.URL.Path, requestIDFrom(r))
+					return
+				}
+			}
+
+			// Расширение s3mini: /:bucket?settings
+			if hasSubresource(r, "settings") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketSettings(w, r, bucket)
+					return
+				case http.MethodGet:
+					s.handleGetBucketSettings(w, r, bucket)
+					return
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported settings method", r.URL.Path, requestIDFrom(r))
+					return
+				}
+			}
+
+			// Расширение s3mini: /:bucket?changes (лента изменений)
+			if hasSubresource(r, "changes") {
+				if r.Method != http.MethodGet {
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported changes method", r.URL.Path, requestIDFrom(r))
+					return
+				}
+				s.handleGetBucketChanges(w, r, bucket)
+				return
+			}
+
+			// Расширение s3mini: /:bucket?script (Lua)
+			if hasSubresource(r, "script") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketScript(w, r, bucket)
+					return
+				case http.MethodGet:
+					s.handleGetBucketScript(w, r, bucket)
+					return
+				case http.MethodDelete:
+					s.handleDeleteBucketScript(w, r, bucket)
+					return
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported script method", r.URL.Path, requestIDFrom(r))
+					return
+				}
+			}
+
+			// S3: /:bucket?versioning
+			if hasSubresource(r, "versioning") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketVersioning(w, r, bucket)
+				case http.MethodGet:
+					s.handleGetBucketVersioning(w, r, bucket)
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported versioning method", r.URL.Path, requestIDFrom(r))
+				}
+				return
+			}
+
+			// S3: /:bucket?cors (preflight OPTIONS отвечает WithCORS)
+			if hasSubresource(r, "cors") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketCORS(w, r, bucket)
+				case http.MethodGet:
+					s.handleGetBucketCORS(w, r, bucket)
+				case http.MethodDelete:
+					s.handleDeleteBucketCORS(w, r, bucket)
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported cors method", r.URL.Path, requestIDFrom(r))
+				}
+				return
+			}
+
+			// S3: /:bucket?acl
+			if hasSubresource(r, "acl") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketACL(w, r, bucket)
+				case http.MethodGet:
+					s.handleGetBucketACL(w, r, bucket)
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported acl method", r.URL.Path, requestIDFrom(r))
+				}
+				return
+			}
+
+			// S3: /:bucket?policy
+			if hasSubresource(r, "policy") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketPolicy(w, r, bucket)
+				case http.MethodGet:
+					s.handleGetBucketPolicy(w, r, bucket)
+				case http.MethodDelete:
+					s.handleDeleteBucketPolicy(w, r, bucket)
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported policy method", r.URL.Path, requestIDFrom(r))
+				}
+				return
+			}
+
+			// S3: /:bucket?logging
+			if hasSubresource(r, "logging") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketLogging(w, r, bucket)
+				case http.MethodGet:
+					s.handleGetBucketLogging(w, r, bucket)
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported logging method", r.URL.Path, requestIDFrom(r))
+				}
+				return
+			}
+
+			// S3: /:bucket?notification
+			if hasSubresource(r, "notification") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketNotification(w, r, bucket)
+				case http.MethodGet:
+					s.handleGetBucketNotification(w, r, bucket)
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported notification method", r.URL.Path, requestIDFrom(r))
+				}
+				return
+			}
+
+			// S3: /:bucket?object-lock
+			if hasSubresource(r, "object-lock") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutObjectLockConfig(w, r, bucket)
+				case http.MethodGet:
+					s.handleGetObjectLockConfig(w, r, bucket)
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported object-lock method", r.URL.Path, requestIDFrom(r))
+				}
+				return
+			}
+
+			// S3: /:bucket?tagging
+			if hasSubresource(r, "tagging") {
+				switch r.Method {
+				case http.MethodPut:
+					s.handlePutBucketTagging(w, r, bucket)
+				case http.MethodGet:
+					s.handleGetBucketTagging(w, r, bucket)
+				case http.MethodDelete:
+					s.handleDeleteBucketTagging(w, r, bucket)
+				default:
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported tagging method", r.URL.Path, requestIDFrom(r))
+				}
+				return
+			}
+
+			// S3: /:bucket?location — SDK спрашивают регион до первой операции
+			if hasSubresource(r, "location") {
+				if r.Method != http.MethodGet {
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported location method", r.URL.Path, requestIDFrom(r))
+					return
+				}
+				s.handleGetBucketLocation(w, r, bucket)
+				return
+			}
+
+			// S3: /:bucket?versions — все версии и delete-marker'ы
+			if hasSubresource(r, "versions") {
+				if r.Method != http.MethodGet {
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported versions method", r.URL.Path, requestIDFrom(r))
+					return
+				}
+				s.handleListObjectVersions(w, r, bucket)
+				return
+			}
+
+			// S3 multipart: /:bucket?uploads — незавершённые загрузки
+			if hasSubresource(r, "uploads") {
+				if r.Method != http.MethodGet {
+					writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported uploads method", r.URL.Path, requestIDFrom(r))
 					return
 				}
+				s.handleListMultipartUploads(w, r, bucket)
+				return
 			}
 
 			// Обычные bucket-операции
@@ -96,6 +328,16 @@ func (s *Server) Router() http.Handler {
 			case http.MethodDelete:
 				s.handleDeleteBucket(w, r, bucket)
 				return
+			case http.MethodHead:
+				s.handleHeadBucket(w, r, bucket)
+				return
+			case http.MethodPost:
+				if isPostObject(r) {
+					s.handlePostObject(w, r, bucket)
+					return
+				}
+				writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "unsupported bucket POST", r.URL.Path, requestIDFrom(r))
+				return
 			case http.MethodGet:
 				// ListObjectsV2
 				if r.URL.Query().Get("list-type") == "2" {
@@ -103,34 +345,152 @@ func (s *Server) Router() http.Handler {
 					return
 				}
 				// Можно вернуть NotImplemented, если V1 не поддерживаешь
-				writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "list objects not implemented", r.URL.Path, "")
+				writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "list objects not implemented", r.URL.Path, requestIDFrom(r))
 				return
 			default:
-				writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method for bucket", r.URL.Path, "")
+				writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method for bucket", r.URL.Path, requestIDFrom(r))
 				return
 			}
 		}
 
 		// -------- Object-level (bucket/key) --------
+		// S3 object ACL: /:bucket/:key?acl
+		if hasSubresource(r, "acl") {
+			switch r.Method {
+			case http.MethodPut:
+				s.handlePutObjectACL(w, r)
+			case http.MethodGet:
+				s.handleGetObjectACL(w, r)
+			default:
+				writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported acl method", r.URL.Path, requestIDFrom(r))
+			}
+			return
+		}
+
+		// S3 Object Lock: /:bucket/:key?retention, ?legal-hold
+		if hasSubresource(r, "retention") {
+			switch r.Method {
+			case http.MethodPut:
+				s.handlePutObjectRetention(w, r)
+			case http.MethodGet:
+				s.handleGetObjectRetention(w, r)
+			default:
+				writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported retention method", r.URL.Path, requestIDFrom(r))
+			}
+			return
+		}
+		if hasSubresource(r, "legal-hold") {
+			switch r.Method {
+			case http.MethodPut:
+				s.handlePutObjectLegalHold(w, r)
+			case http.MethodGet:
+				s.handleGetObjectLegalHold(w, r)
+			default:
+				writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported legal-hold method", r.URL.Path, requestIDFrom(r))
+			}
+			return
+		}
+
+		// S3 object tagging: /:bucket/:key?tagging
+		if hasSubresource(r, "tagging") {
+			switch r.Method {
+			case http.MethodPut:
+				s.handlePutObjectTagging(w, r)
+			case http.MethodGet:
+				s.handleGetObjectTagging(w, r)
+			case http.MethodDelete:
+				s.handleDeleteObjectTagging(w, r)
+			default:
+				writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported tagging method", r.URL.Path, requestIDFrom(r))
+			}
+			return
+		}
+
+		// S3 GetObjectAttributes: /:bucket/:key?attributes
+		if hasSubresource(r, "attributes") {
+			if r.Method != http.MethodGet {
+				writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported attributes method", r.URL.Path, requestIDFrom(r))
+				return
+			}
+			s.handleGetObjectAttributes(w, r)
+			return
+		}
+
 		switch r.Method {
 		case http.MethodPut:
+			if hasSubresource(r, "uploadId") {
+				if r.Header.Get(hdrCopySource) != "" {
+					s.handleUploadPartCopy(w, r)
+					return
+				}
+				s.handleUploadPart(w, r)
+				return
+			}
+			if r.Header.Get(hdrCopySource) != "" {
+				s.handleCopyObject(w, r)
+				return
+			}
 			s.handlePut(w, r)
 			return
 		case http.MethodGet:
+			if hasSubresource(r, "uploadId") {
+				s.handleListParts(w, r)
+				return
+			}
 			s.handleGet(w, r)
 			return
 		case http.MethodDelete:
+			if hasSubresource(r, "uploadId") {
+				s.handleAbortMultipart(w, r)
+				return
+			}
 			s.handleDelete(w, r)
 			return
+		case http.MethodPost:
+			if hasSubresource(r, "uploads") {
+				s.handleInitiateMultipart(w, r)
+				return
+			}
+			if hasSubresource(r, "uploadId") {
+				s.handleCompleteMultipart(w, r)
+				return
+			}
+			if hasSubresource(r, "compose") {
+				s.handleCompose(w, r)
+				return
+			}
+			if hasSubresource(r, "verify") {
+				s.handleVerify(w, r)
+				return
+			}
+			if hasSubresource(r, "select") {
+				s.handleSelectObject(w, r)
+				return
+			}
+			if hasSubresource(r, "append") {
+				s.handleAppend(w, r)
+				return
+			}
+			if hasSubresource(r, "restore") {
+				s.handleRestoreObject(w, r)
+				return
+			}
+			writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "unsupported object POST", r.URL.Path, requestIDFrom(r))
+			return
 		case http.MethodHead:
-			// HEAD отдаёт только заголовки (у тебя handleGet уже это умеет — без тела при 304/412 и т.п.)
-			s.handleGet(w, r)
+			s.handleHead(w, r)
 			return
 		default:
-			writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method", r.URL.Path, "")
+			writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method", r.URL.Path, requestIDFrom(r))
 			return
 		}
-	})
+	})))))
 
 	return mux
 }
+
+// hasSubresource — есть ли в запросе S3-подресурс (?lifecycle, ?lifecycle=1 и т.п.)
+func hasSubresource(r *http.Request, name string) bool {
+	_, ok := r.URL.Query()[name]
+	return ok
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+)
+
+// Детерминированные профили бакета («первый GET каждого ключа — 503»,
+// «PUT +2с»), в отличие от chaos срабатывают предсказуемо: по счётчику
+// совпавших запросов на ключ. Счётчики в памяти, сбрасываются при смене профиля.
+
+type simRule struct {
+	Method  string `json:"method,omitempty"` // "" — любой
+	Prefix  string `json:"prefix,omitempty"` // префикс ключа
+	DelayMs int    `json:"delay_ms,omitempty"`
+	Status  int    `json:"status,omitempty"`  // 0 — только задержка
+	Code    string `json:"code,omitempty"`    // S3-код ошибки; по умолчанию из статуса
+	FirstN  int    `json:"first_n,omitempty"` // срабатывает на первых N запросах к ключу
+	EveryN  int    `json:"every_n,omitempty"` // срабатывает на каждом N-м запросе к ключу
+}
+
+type simProfile struct {
+	Name  string    `json:"name"`
+	Rules []simRule `json:"rules"`
+}
+
+const maxSimRules = 64
+
+// simCounters — сколько раз правило совпало по ключу: bucketID -> "rule|key" -> n.
+type simCounters struct {
+	mu     sync.Mutex
+	counts map[uint]map[string]int
+}
+
+func (c *simCounters) next(bucketID uint, k string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = map[uint]map[string]int{}
+	}
+	m := c.counts[bucketID]
+	if m == nil || len(m) > 100000 { // не растём бесконечно на уникальных ключах
+		m = map[string]int{}
+		c.counts[bucketID] = m
+	}
+	m[k]++
+	return m[k]
+}
+
+func (c *simCounters) reset(bucketID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.counts, bucketID)
+}
+
+func (r simRule) matches(method, key string) bool {
+	return (r.Method == "" || strings.EqualFold(r.Method, method)) && strings.HasPrefix(key, r.Prefix)
+}
+
+func (r simRule) fires(n int) bool {
+	if r.FirstN > 0 && n > r.FirstN {
+		return false
+	}
+	return r.EveryN <= 0 || n%r.EveryN == 0
+}
+
+func (r simRule) errorCode() string {
+	if r.Code != "" {
+		return r.Code
+	}
+	switch r.Status {
+	case http.StatusServiceUnavailable:
+		return "ServiceUnavailable"
+	case http.StatusInternalServerError:
+		return "InternalError"
+	}
+	return strings.ReplaceAll(http.StatusText(r.Status), " ", "")
+}
+
+func (p *simProfile) validate() error {
+	if len(p.Rules) > maxSimRules {
+		return errors.New("too many rules")
+	}
+	for _, r := range p.Rules {
+		switch strings.ToUpper(r.Method) {
+		case "", http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete:
+		default:
+			return errors.New("unsupported method " + r.Method)
+		}
+		if r.Status != 0 && (r.Status < 400 || r.Status > 599) {
+			return errors.New("status must be 0 or 400..599")
+		}
+		if r.DelayMs < 0 || r.DelayMs > 600000 {
+			return errors.New("delay_ms must be within 0..600000")
+		}
+		if r.FirstN < 0 || r.EveryN < 0 {
+			return errors.New("first_n/every_n must be >= 0")
+		}
+		if r.Status == 0 && r.DelayMs == 0 {
+			return errors.New("rule must set status or delay_ms")
+		}
+	}
+	return nil
+}
+
+// withSimulation применяет профиль бакета: все совпавшие задержки суммируются,
+// первая совпавшая ошибка возвращается вместо ответа.
+func (s *Server) withSimulation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, key, _ := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+		if bucket == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sp, err := s.db.SimulationForBucket(bucket)
+		if err != nil || sp == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var p simProfile
+		if err := json.Unmarshal([]byte(sp.Rules), &p.Rules); err != nil {
+			loggerFrom(r).Error("simulation.bad_rules", "bucket", bucket, "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var delay time.Duration
+		var fail *simRule
+		for i, rule := range p.Rules {
+			if !rule.matches(r.Method, key) {
+				continue
+			}
+			n := s.simCounters.next(sp.BucketID, strconv.Itoa(i)+"|"+key)
+			if !rule.fires(n) {
+				continue
+			}
+			delay += time.Duration(rule.DelayMs) * time.Millisecond
+			if rule.Status != 0 && fail == nil {
+				fail = &p.Rules[i]
+			}
+		}
+		if delay > 0 {
+			loggerFrom(r).Info("simulation.delay", "profile", sp.Name, "ms", delay.Milliseconds())
+			if !sleepCtx(r.Context(), delay) {
+				return
+			}
+		}
+		if fail != nil {
+			loggerFrom(r).Info("simulation.error", "profile", sp.Name, "status", fail.Status)
+			writeS3Error(w, fail.Status, fail.errorCode(), "simulated failure ("+sp.Name+")", r.URL.Path, requestIDFrom(r))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ---- админский API: /admin/v1/buckets/{bucket}/simulation ----
+
+func (s *Server) handleAdminBucketSimulation(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	log := loggerFrom(r)
+	b, err := s.db.FindBucketByName(r.PathValue("bucket"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchBucket", "bucket not found")
+		return
+	}
+	if err != nil {
+		log.Error("admin.simulation.bucket_lookup_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sp, err := s.db.SimulationForBucket(b.Name)
+		if err != nil {
+			log.Error("admin.simulation.load_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		if sp == nil {
+			writeJSONError(w, http.StatusNotFound, "NoSuchSimulation", "bucket has no simulation profile")
+			return
+		}
+		p := simProfile{Name: sp.Name}
+		_ = json.Unmarshal([]byte(sp.Rules), &p.Rules)
+		writeJSON(w, http.StatusOK, p)
+	case http.MethodPut:
+		var p simProfile
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "MalformedJSON", err.Error())
+			return
+		}
+		if err := p.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+			return
+		}
+		rules, _ := json.Marshal(p.Rules)
+		if err := s.db.SaveBucketSimulation(&db.BucketSimulation{BucketID: b.ID, Name: p.Name, Rules: string(rules)}); err != nil {
+			log.Error("admin.simulation.save_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		s.simCounters.reset(b.ID)
+		log.Warn("admin.simulation.set", "bucket", b.Name, "profile", p.Name, "rules", len(p.Rules))
+		writeJSON(w, http.StatusOK, p)
+	case http.MethodDelete:
+		if err := s.db.DeleteBucketSimulation(b.ID); err != nil {
+			log.Error("admin.simulation.delete_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		s.simCounters.reset(b.ID)
+		log.Info("admin.simulation.cleared", "bucket", b.Name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
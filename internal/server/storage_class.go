@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+)
+
+const (
+	hdrStorageClass      = "x-amz-storage-class"
+	storageClassStandard = "STANDARD"
+
+	storageNodeTier = storage.NodeTier
+)
+
+// storageClasses — классы хранения, которые принимает S3. Для PUT это только метка
+// версии; физически на второй уровень байты переносит lifecycle Transition.
+var storageClasses = map[string]bool{
+	storageClassStandard: true,
+	"REDUCED_REDUNDANCY":  true,
+	"STANDARD_IA":         true,
+	"ONEZONE_IA":          true,
+	"INTELLIGENT_TIERING": true,
+	"GLACIER":             true,
+	"GLACIER_IR":          true,
+	"DEEP_ARCHIVE":        true,
+}
+
+// archiveClasses — классы, байты которых после Transition не читаются без RestoreObject.
+var archiveClasses = map[string]bool{
+	"GLACIER":      true,
+	"DEEP_ARCHIVE": true,
+}
+
+// storageNode — имя узла блоба; пустое — основной узел.
+func storageNode(n string) string {
+	if n == "" {
+		return storage.NodeLocal
+	}
+	return n
+}
+
+// parseStorageClassHeader — класс из x-amz-storage-class; "" — заголовка нет.
+func parseStorageClassHeader(h http.Header) (string, bool) {
+	v := h.Get(hdrStorageClass)
+	if v == "" {
+		return "", true
+	}
+	return v, storageClasses[v]
+}
+
+func writeInvalidStorageClass(w http.ResponseWriter, r *http.Request) {
+	writeS3Error(w, http.StatusBadRequest, "InvalidStorageClass",
+		"The storage class you specified is not valid", r.URL.Path, requestIDFrom(r))
+}
+
+// setStorageClassHeader — x-amz-storage-class в ответе GET/HEAD; для STANDARD S3 его не шлёт.
+func setStorageClassHeader(w http.ResponseWriter, class string) {
+	if class != "" && class != storageClassStandard {
+		w.Header().Set(hdrStorageClass, class)
+	}
+}
+
+// AddStorageTier подключает второй уровень хранения для lifecycle-переходов.
+func (s *Server) AddStorageTier(d storage.StorageDriver) {
+	s.storage.AddNode(storageNodeTier, d)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+	"github.com/DanikLP1/s3-storage-service/internal/transform"
+	"gorm.io/gorm"
+)
+
+var mTransforms = metrics.NewCounterVec("s3mini_transforms_total",
+	"GET-time transformations by transformer and cache result.", "transform", "cache")
+
+// serveTransformed отдаёт результат трансформации версии: из кэша производных
+// блобов или посчитанный сейчас (и сохранённый в кэш).
+func (s *Server) serveTransformed(w http.ResponseWriter, r *http.Request, ver *db.ObjectVersion, t transform.Transformer, params string) {
+	log := loggerFrom(r).With("transform", t.Name(), "params", params)
+	srcBlobID := *ver.BlobID
+	key := transform.CacheKey(t, params)
+
+	d, err := s.db.FindDerivedBlob(srcBlobID, key)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error("transform.cache_lookup_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "db error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	if d != nil {
+		b, err := s.db.GetBlob(d.BlobID)
+		if err == nil {
+			mTransforms.Inc(t.Name(), "hit")
+			rc, err := s.openBlob(r.Context(), d.BlobID, 0, -1)
+			if err == nil {
+				defer rc.Close()
+				if !writeTransformedHeaders(w, r, ver, b.Checksum, d.ContentType, b.Size) {
+					return
+				}
+				if r.Method != http.MethodHead {
+					_, _ = io.Copy(w, rc)
+				}
+				log.Info("transform.cache_hit", "blob_id", d.BlobID)
+				return
+			}
+		}
+		// запись в кэше есть, а блоба нет — пересчитаем
+		log.Warn("transform.cache_stale", "blob_id", d.BlobID, "err", err)
+	}
+
+	ctype, _, _ := mime.ParseMediaType(coalesce(ver.ContentType, ""))
+	src, err := s.openBlob(r.Context(), srcBlobID, 0, -1)
+	if err != nil {
+		log.Error("transform.open_fail", "err", err)
+		writeS3Error(w, http.StatusInternalServerError, "InternalError", "read error", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	out, outType, err := t.Apply(src, ctype, params)
+	_ = src.Close()
+	switch {
+	case errors.Is(err, transform.ErrUnsupportedType):
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "object type "+ctype+" cannot be transformed", r.URL.Path, requestIDFrom(r))
+		return
+	case errors.Is(err, transform.ErrSourceTooLarge):
+		writeS3Error(w, http.StatusBadRequest, "InvalidRequest", "object is too large to transform", r.URL.Path, requestIDFrom(r))
+		return
+	case err != nil:
+		log.Warn("transform.apply_fail", "err", err)
+		writeS3Error(w, http.StatusUnprocessableEntity, "InvalidRequest", "cannot transform object: "+err.Error(), r.URL.Path, requestIDFrom(r))
+		return
+	}
+	mTransforms.Inc(t.Name(), "miss")
+
+	// в режиме только чтения результат отдаётся без записи в кэш
+	if s.isReadOnly() {
+		log.Info("transform.cache_skipped_read_only")
+	} else if err := s.cacheDerived(r.Context(), srcBlobID, key, out, outType); err != nil {
+		// кэш — оптимизация; результат всё равно отдаём
+		log.Warn("transform.cache_store_fail", "err", err)
+	}
+	sum := sha256.Sum256(out)
+	if !writeTransformedHeaders(w, r, ver, "sha256:"+hex.EncodeToString(sum[:]), outType, int64(len(out))) {
+		return
+	}
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(out)
+	}
+	log.Info("transform.computed", "size", len(out))
+}
+
+// cacheDerived сохраняет результат как обычный блоб (с дедупом) и запись кэша.
+func (s *Server) cacheDerived(ctx context.Context, srcBlobID, key string, out []byte, outType string) error {
+	st, err := s.stageBlob(ctx, bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		return err
+	}
+	err = s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		blobID, _, isNew, err := s.adoptBlobTx(ctx, tx, st)
+		if err != nil {
+			return err
+		}
+		st.adopted = isNew
+		return s.db.SaveDerivedBlobTx(tx, &db.DerivedBlob{
+			SourceBlobID: srcBlobID, Transform: key, BlobID: blobID, ContentType: outType,
+		})
+	})
+	if err != nil || !st.adopted {
+		_ = s.storage.Delete(ctx, st.ID)
+	}
+	return err
+}
+
+// writeTransformedHeaders — заголовки производного ответа; false, если уже ответили 304/412.
+func writeTransformedHeaders(w http.ResponseWriter, r *http.Request, ver *db.ObjectVersion, checksum, ctype string, size int64) bool {
+	etag := `"` + checksum + `"`
+	lastMod := versionLastModified(ver)
+	switch objectPrecondition(r.Header, etag, lastMod) {
+	case http.StatusPreconditionFailed:
+		writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", r.URL.Path, requestIDFrom(r))
+		return false
+	case http.StatusNotModified:
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	setStorageClassHeader(w, ver.StorageClass)
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	w.WriteHeader(http.StatusOK)
+	return true
+}
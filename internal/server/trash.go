@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+// Корзина бакета (?settings, Trash): версии с данными, которые удаляет клиент
+// (DELETE ?versionId, перезапись в Suspended и Disabled) или lifecycle, на
+// TrashDays дней переезжают в корзину. Оттуда их возвращает админский API, а
+// по истечении срока запись удаляет GC — блоб уходит в его очередь.
+
+// removeVersionTx — удаление версии с учётом корзины бакета. Вызывается под
+// LockObjectForUpdate.
+func (s *Server) removeVersionTx(tx *gorm.DB, bkt *db.Bucket, versionID string) error {
+	if bkt.TrashDays <= 0 {
+		return s.db.DeleteVersionTx(tx, versionID)
+	}
+	return s.db.TrashVersionTx(tx, versionID, time.Now().AddDate(0, 0, bkt.TrashDays))
+}
+
+// ---- админский API: /admin/v1/buckets/{bucket}/trash ----
+
+// GET /admin/v1/buckets/{bucket}/trash?prefix=&after_key=&after_version_id=&limit=
+func (s *Server) handleAdminBucketTrash(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	b, ok := s.adminBucket(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	limit := 1000
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "limit must be 1..1000")
+			return
+		}
+		limit = n
+	}
+	vs, err := s.db.ListTrash(b.ID, q.Get("prefix"), q.Get("after_key"), q.Get("after_version_id"), limit+1)
+	if err != nil {
+		loggerFrom(r).Error("admin.trash.list_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	truncated := len(vs) > limit
+	if truncated {
+		vs = vs[:limit]
+	}
+	if vs == nil {
+		vs = []db.TrashedVersion{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"bucket": b.Name, "trash_days": b.TrashDays, "versions": vs, "is_truncated": truncated,
+	})
+}
+
+// DELETE /admin/v1/buckets/{bucket}/trash/{version} — удалить из корзины досрочно.
+func (s *Server) handleAdminTrashedVersion(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodDelete) {
+		return
+	}
+	b, ok := s.adminBucket(w, r)
+	if !ok {
+		return
+	}
+	log := loggerFrom(r)
+	found, err := s.db.DeleteTrashedVersion(b.ID, r.PathValue("version"))
+	if err != nil {
+		log.Error("admin.trash.purge_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "NoSuchVersion", "version not in trash")
+		return
+	}
+	log.Warn("admin.trash.purged", "bucket", b.Name, "version_id", r.PathValue("version"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /admin/v1/buckets/{bucket}/trash/{version}/restore — вернуть версию.
+// Она становится текущей, если HEAD ключа — не более новая версия с данными
+// (delete-marker, в том числе созданный самим удалением, её не перекрывает).
+func (s *Server) handleAdminTrashRestore(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	b, ok := s.adminBucket(w, r)
+	if !ok {
+		return
+	}
+	log := loggerFrom(r)
+	t, err := s.db.GetTrashedVersion(b.ID, r.PathValue("version"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchVersion", "version not in trash")
+		return
+	}
+	if err != nil {
+		log.Error("admin.trash.lookup_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+
+	current := false
+	err = s.db.WithTxImmediate(func(tx *gorm.DB) error {
+		if err := s.db.LockObjectForUpdate(tx, b.ID, t.Key); err != nil {
+			return err
+		}
+		ver, err := s.db.RestoreTrashedVersionTx(tx, b.ID, t.VersionID)
+		if err != nil {
+			return err
+		}
+		head, _ := s.db.GetHeadVersionTx(tx, b.ID, t.Key)
+		if head != nil && !head.IsDelete && head.CreatedAt.After(ver.CreatedAt) {
+			return nil
+		}
+		var ctype string
+		if ver.ContentType != nil {
+			ctype = *ver.ContentType
+		}
+		if err := s.db.UpsertObjectTx(tx, b.ID, t.Key, *ver.BlobID, t.Size, t.ETag, ctype, ver.VersionID); err != nil {
+			return err
+		}
+		current = true
+		return s.db.SetHeadVersionTx(tx, b.ID, t.Key, ver.VersionID)
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchVersion", "version not in trash")
+		return
+	}
+	if err != nil {
+		log.Error("admin.trash.restore_fail", "version_id", t.VersionID, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	log.Warn("admin.trash.restored", "bucket", b.Name, "key", t.Key, "version_id", t.VersionID, "current", current)
+	writeJSON(w, http.StatusOK, map[string]any{"key": t.Key, "version_id": t.VersionID, "current": current})
+}
+
+func (s *Server) adminBucket(w http.ResponseWriter, r *http.Request) (*db.Bucket, bool) {
+	b, err := s.db.FindBucketByName(r.PathValue("bucket"))
+	if errors.Is(err, db.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "NoSuchBucket", "bucket not found")
+		return nil, false
+	}
+	if err != nil {
+		loggerFrom(r).Error("admin.bucket_lookup_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return nil, false
+	}
+	return b, true
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"github.com/DanikLP1/s3-storage-service/internal/metrics"
+)
+
+var (
+	mBucketObjects = metrics.NewGaugeVec("s3mini_bucket_objects",
+		"Object versions with data per bucket (usage counters).", "bucket")
+	mBucketBytes = metrics.NewGaugeVec("s3mini_bucket_bytes",
+		"Bytes of object versions per bucket (usage counters).", "bucket")
+	mBucketTrashBytes = metrics.NewGaugeVec("s3mini_bucket_trash_bytes",
+		"Bytes of versions in the bucket trash (usage counters).", "bucket")
+	mUserObjects = metrics.NewGaugeVec("s3mini_user_objects",
+		"Object versions with data in buckets owned by the user.", "user")
+	mUserBytes = metrics.NewGaugeVec("s3mini_user_bytes",
+		"Bytes of object versions in buckets owned by the user.", "user")
+)
+
+// Учёт занятого места: счётчики бакетов ведёт база (bucket_usage_counters),
+// здесь — админский API и gauge'и. Метрики обновляются раз в every из тех же
+// счётчиков: запрос по числу бакетов, а не версий, поэтому lease не нужен.
+
+// StartUsageMetrics обновляет gauge'и занятого места: сразу и дальше раз в every.
+func (s *Server) StartUsageMetrics(ctx context.Context, every time.Duration) {
+	log := s.Logger.With(slog.String("comp", "usage"))
+	go func() {
+		log.Info("usage.started", "every", every.String())
+		t := time.NewTicker(every)
+		defer t.Stop()
+		// бакеты, которых не стало, обнуляются, а не висят с последним значением
+		seenBuckets, seenUsers := map[string]bool{}, map[string]bool{}
+		for {
+			seenBuckets, seenUsers = s.collectUsage(log, seenBuckets, seenUsers)
+			select {
+			case <-ctx.Done():
+				log.Info("usage.stopped", "reason", "context canceled")
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (s *Server) collectUsage(log *slog.Logger, prevBuckets, prevUsers map[string]bool) (map[string]bool, map[string]bool) {
+	buckets, err := s.db.BucketUsageCounters(0, 0)
+	if err != nil {
+		log.Error("usage.buckets_fail", "err", err)
+		return prevBuckets, prevUsers
+	}
+	users, err := s.db.UserUsageCounters(0)
+	if err != nil {
+		log.Error("usage.users_fail", "err", err)
+		return prevBuckets, prevUsers
+	}
+	seenBuckets := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		seenBuckets[b.Bucket] = true
+		mBucketObjects.Set(float64(b.Objects), b.Bucket)
+		mBucketBytes.Set(float64(b.Bytes), b.Bucket)
+		mBucketTrashBytes.Set(float64(b.TrashBytes), b.Bucket)
+	}
+	for name := range prevBuckets {
+		if !seenBuckets[name] {
+			mBucketObjects.Set(0, name)
+			mBucketBytes.Set(0, name)
+			mBucketTrashBytes.Set(0, name)
+		}
+	}
+	seenUsers := make(map[string]bool, len(users))
+	for _, u := range users {
+		name := u.Name
+		if name == "" {
+			name = strconv.FormatUint(uint64(u.UserID), 10)
+		}
+		seenUsers[name] = true
+		mUserObjects.Set(float64(u.Objects), name)
+		mUserBytes.Set(float64(u.Bytes), name)
+	}
+	for name := range prevUsers {
+		if !seenUsers[name] {
+			mUserObjects.Set(0, name)
+			mUserBytes.Set(0, name)
+		}
+	}
+	return seenBuckets, seenUsers
+}
+
+// GET /admin/v1/usage[?bucket=name][&user=id] — занятое место по бакетам и пользователям.
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	log := loggerFrom(r)
+	var bucketID, userID uint
+	if v := r.URL.Query().Get("user"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "InvalidArgument", "user must be a user id")
+			return
+		}
+		userID = uint(id)
+	}
+	if name := r.URL.Query().Get("bucket"); name != "" {
+		b, err := s.db.FindBucketByName(name)
+		if errors.Is(err, db.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "NoSuchBucket", "bucket not found")
+			return
+		}
+		if err != nil {
+			log.Error("admin.usage.bucket_lookup_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		bucketID = b.ID
+	}
+
+	buckets, err := s.db.BucketUsageCounters(bucketID, userID)
+	if err != nil {
+		log.Error("admin.usage.buckets_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	if buckets == nil {
+		buckets = []db.BucketUsageRow{}
+	}
+	resp := map[string]any{"buckets": buckets}
+	if bucketID == 0 {
+		users, err := s.db.UserUsageCounters(userID)
+		if err != nil {
+			log.Error("admin.usage.users_fail", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+			return
+		}
+		if users == nil {
+			users = []db.UserUsageRow{}
+		}
+		resp["users"] = users
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /admin/v1/usage/recount — пересчитать счётчики по таблицам (полный обход версий).
+func (s *Server) handleAdminUsageRecount(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	log := loggerFrom(r)
+	start := time.Now()
+	if err := s.db.RecountUsage(); err != nil {
+		log.Error("admin.usage.recount_fail", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "InternalError", "db error")
+		return
+	}
+	log.Warn("admin.usage.recounted", "dur_ms", time.Since(start).Milliseconds())
+	writeJSON(w, http.StatusOK, map[string]any{"recounted": true})
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const hdrMetaPrefix = "x-amz-meta-"
+
+// лимит S3: 2 КБ на имена и значения всех x-amz-meta-* вместе
+const maxUserMetadataSize = 2048
+
+// parseUserMetadata собирает x-amz-meta-* в канонический JSON {"имя":"значение"}
+// (имена в нижнем регистре, ключи отсортированы). Без заголовков — "".
+// false — метаданные больше лимита.
+func parseUserMetadata(h http.Header) (string, bool) {
+	kv := map[string]string{}
+	total := 0
+	for name, vals := range h {
+		lname := strings.ToLower(name)
+		if !strings.HasPrefix(lname, hdrMetaPrefix) || len(lname) == len(hdrMetaPrefix) {
+			continue
+		}
+		k, v := lname[len(hdrMetaPrefix):], strings.Join(vals, ",")
+		kv[k] = v
+		total += len(k) + len(v)
+	}
+	if total > maxUserMetadataSize {
+		return "", false
+	}
+	if len(kv) == 0 {
+		return "", true
+	}
+	canon, _ := json.Marshal(kv) // map => ключи отсортированы
+	return string(canon), true
+}
+
+// checkPutUserMetadata — метаданные с PUT/CreateMultipartUpload/CopyObject REPLACE.
+func checkPutUserMetadata(w http.ResponseWriter, r *http.Request) (string, bool) {
+	meta, ok := parseUserMetadata(r.Header)
+	if !ok {
+		writeS3Error(w, http.StatusBadRequest, "MetadataTooLarge",
+			"Your metadata headers exceed the maximum allowed metadata size.", r.URL.Path, requestIDFrom(r))
+	}
+	return meta, ok
+}
+
+// setUserMetadataHeaders — x-amz-meta-* версии в ответе GET/HEAD.
+func setUserMetadataHeaders(w http.ResponseWriter, stored *string) {
+	if stored == nil || *stored == "" {
+		return
+	}
+	var kv map[string]string
+	if err := json.Unmarshal([]byte(*stored), &kv); err != nil {
+		return
+	}
+	for k, v := range kv {
+		w.Header()[http.CanonicalHeaderKey(hdrMetaPrefix+k)] = []string{v}
+	}
+}
+
+// userMetadataField — значение колонки user_metadata: пустые метаданные — NULL.
+func userMetadataField(meta string) any {
+	if meta == "" {
+		return nil
+	}
+	return meta
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/db"
+	"gorm.io/gorm"
+)
+
+var errIsDeleteMarker = errors.New("version is a delete marker")
+
+// commitVersionTx — новая версия ключа поверх готового блоба: строка версии,
+// строка objects, перевод HEAD, DefaultRetention бакета и проверка квот и
+// MAX_OBJECT_SIZE_MB. Вызывается под LockObjectForUpdate.
+func (s *Server) commitVersionTx(tx *gorm.DB, bucketID uint, key, blobID string, size int64, etag, ctype string) (string, error) {
+	if err := s.checkObjectSize(size); err != nil {
+		return "", err
+	}
+	verID, err := s.newVersionIDTx(tx, bucketID, key)
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.InsertObjectVersionTx(tx, bucketID, key, verID, blobID, size, etag, ctype); err != nil {
+		return "", err
+	}
+	if err := s.db.UpsertObjectTx(tx, bucketID, key, blobID, size, etag, ctype, verID); err != nil {
+		return "", err
+	}
+	if err := s.db.SetHeadVersionTx(tx, bucketID, key, verID); err != nil {
+		return "", err
+	}
+	if err := s.applyDefaultRetentionTx(tx, bucketID, verID); err != nil {
+		return "", err
+	}
+	// счётчики уже учли новую версию и вытесненные ею — проверяем итог
+	if err := s.db.CheckQuotaTx(tx, bucketID); err != nil {
+		return "", err
+	}
+	return verID, nil
+}
+
+// nullVersionID — так клиент адресует версию, записанную при Suspended.
+const nullVersionID = "null"
+
+// apiVersionID — ID версии для ответа клиенту.
+func apiVersionID(id string) string {
+	if strings.HasPrefix(id, db.NullVersionPrefix) {
+		return nullVersionID
+	}
+	return id
+}
+
+// newVersionIDTx — ID для новой версии или delete-marker'а ключа. При Suspended
+// это null-версия, и прежняя null-версия ключа удаляется (блоб заберёт GC),
+// если её не держит окно защиты. Вызывается под LockObjectForUpdate.
+func (s *Server) newVersionIDTx(tx *gorm.DB, bucketID uint, key string) (string, error) {
+	bkt, err := s.db.FindBucketByID(bucketID)
+	if err != nil {
+		return "", err
+	}
+	switch bkt.Versioning {
+	case db.VersioningDisabled:
+		// блобы прежних версий заберёт GC; PUT и DELETE освобождают их сразу сами
+		if _, err := s.dropKeyVersionsTx(tx, bkt, key); err != nil {
+			return "", err
+		}
+		return db.NullVersionPrefix + s.db.GenVersionID(), nil
+	case db.VersioningSuspended:
+	default:
+		return s.db.GenVersionID(), nil
+	}
+	olds, err := s.db.ListNullVersionsTx(tx, bucketID, key)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	for i := range olds {
+		if versionProtected(bkt, &olds[i], now) {
+			continue
+		}
+		if err := s.removeVersionTx(tx, bkt, olds[i].VersionID); err != nil {
+			return "", err
+		}
+	}
+	return db.NullVersionPrefix + s.db.GenVersionID(), nil
+}
+
+// dropKeyVersionsTx — режим Disabled: удалить все версии ключа, кроме тех, что
+// держит окно защиты. Возвращает блобы, на которые больше никто не ссылается.
+func (s *Server) dropKeyVersionsTx(tx *gorm.DB, bkt *db.Bucket, key string) ([]string, error) {
+	vers, err := s.db.ListKeyVersionsTx(tx, bkt.ID, key)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var orphans []string
+	for i := range vers {
+		v := &vers[i]
+		if versionProtected(bkt, v, now) {
+			continue
+		}
+		if err := s.removeVersionTx(tx, bkt, v.VersionID); err != nil {
+			return nil, err
+		}
+		if v.BlobID == nil {
+			continue
+		}
+		if n, err := s.db.BlobRefCountTx(tx, *v.BlobID); err != nil {
+			return nil, err
+		} else if n == 0 {
+			orphans = append(orphans, *v.BlobID)
+		}
+	}
+	return orphans, nil
+}
+
+// reclaimBlobs — освободить блобы сразу после коммита, не дожидаясь GC. Ссылки
+// перепроверяются: тот же блоб мог достаться новой версии через дедуп. С окном
+// GC_GRACE_S блобы остаются в очереди GC и удаляются по его истечении.
+func (s *Server) reclaimBlobs(ctx context.Context, log *slog.Logger, ids []string) {
+	if s.gcGrace() > 0 {
+		return
+	}
+	for _, id := range ids {
+		var gone bool
+		err := s.db.WithTxImmediate(func(tx *gorm.DB) error {
+			var err error
+			gone, err = s.db.DeleteBlobIfUnreferencedTx(tx, id, time.Time{})
+			return err
+		})
+		if err != nil {
+			log.Warn("blob_reclaim.fail", "blob_id", id, "err", err)
+			continue
+		}
+		if gone {
+			if err := s.storage.Delete(ctx, id); err != nil {
+				log.Warn("blob_reclaim.storage_delete_fail", "blob_id", id, "err", err)
+				continue
+			}
+			log.Info("blob_reclaim.ok", "blob_id", id)
+		}
+	}
+}
+
+// reclaimOrphanTx — удалить блоб, с которого транзакция сняла последнюю ссылку,
+// не дожидаясь GC (только без окна GC_GRACE_S). true — блоб удалён.
+func (s *Server) reclaimOrphanTx(ctx context.Context, tx *gorm.DB, id string) bool {
+	if s.gcGrace() > 0 {
+		return false
+	}
+	if cnt, _ := s.db.BlobRefCountTx(tx, id); cnt != 0 {
+		return false
+	}
+	_ = s.storage.Delete(ctx, id)
+	_ = s.db.DeleteBlobRecordTx(tx, id)
+	return true
+}
+
+// copyVersionTx — новая версия key на том же блобе, что и ver: байты не копируются,
+// ETag, размер, теги, x-amz-checksum и x-amz-meta-* переезжают как есть. Вызывается под LockObjectForUpdate.
+func (s *Server) copyVersionTx(tx *gorm.DB, ver *db.ObjectVersion, bucketID uint, key, ctype, encCtx string) (string, error) {
+	verID, err := s.commitVersionTx(tx, bucketID, key, *ver.BlobID, coalesce(ver.Size, 0), coalesce(ver.ETag, ""), ctype)
+	if err != nil {
+		return "", err
+	}
+	if encCtx != "" {
+		if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"encryption_context": encCtx}); err != nil {
+			return "", err
+		}
+	}
+	if ver.UserMetadata != nil {
+		if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{"user_metadata": *ver.UserMetadata}); err != nil {
+			return "", err
+		}
+	}
+	if ver.ChecksumAlgorithm != "" {
+		if err := s.db.UpdateVersionFieldsTx(tx, verID, map[string]any{
+			"checksum_algorithm": ver.ChecksumAlgorithm, "checksum_value": ver.ChecksumValue,
+		}); err != nil {
+			return "", err
+		}
+	}
+	if err := s.db.CopyVersionTagsTx(tx, ver.VersionID, verID); err != nil {
+		return "", err
+	}
+	return verID, nil
+}
+
+// resolveVersionTx — HEAD ключа (versionID == ""), его null-версия ("null") или
+// конкретная версия этого ключа.
+// Для delete-marker возвращает саму версию и errIsDeleteMarker.
+func (s *Server) resolveVersionTx(tx *gorm.DB, bucketID uint, key, versionID string) (*db.ObjectVersion, error) {
+	var ver *db.ObjectVersion
+	var err error
+	switch versionID {
+	case "":
+		ver, err = s.db.GetHeadVersionTx(tx, bucketID, key)
+	case nullVersionID:
+		ver, err = s.getNullVersionTx(tx, bucketID, key)
+	default:
+		ver, err = s.db.GetVersionTx(tx, versionID)
+		if err == nil && (ver.BucketID != bucketID || ver.Key != key) {
+			return nil, db.ErrNotFound
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ver.IsDelete || ver.BlobID == nil {
+		return ver, errIsDeleteMarker
+	}
+	return ver, nil
+}
+
+// writeDeleteMarker — ответ на обращение к delete-marker'у, как в S3: HEAD ключа
+// даёт 404 NoSuchKey, явный versionId — 405; в обоих случаях x-amz-delete-marker
+// и версия маркера, чтобы клиент отличал «удалён» от «не было».
+func writeDeleteMarker(w http.ResponseWriter, r *http.Request, ver *db.ObjectVersion, versionID string) {
+	w.Header().Set("x-amz-delete-marker", "true")
+	w.Header().Set("x-amz-version-id", apiVersionID(ver.VersionID))
+	w.Header().Set("Last-Modified", versionLastModified(ver).Format(http.TimeFormat))
+	if versionID != "" {
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", r.URL.Path, requestIDFrom(r))
+}
+
+func (s *Server) getNullVersionTx(tx *gorm.DB, bucketID uint, key string) (*db.ObjectVersion, error) {
+	vs, err := s.db.ListNullVersionsTx(tx, bucketID, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) == 0 {
+		return nil, db.ErrNotFound
+	}
+	return &vs[0], nil
+}
+
+// etagListMatches — значение If-Match: "*" или список ETag через запятую.
+func etagListMatches(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || (v != "" && stripQuotes(v) == stripQuotes(etag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPutPreconditionTx — проверка If-Match / If-None-Match: * против текущей HEAD ключа.
+// Возвращает HTTP-статус отказа (404 — ключа нет для If-Match, 412 — ETag не совпал
+// или ключ уже существует) или 0. Delete marker в HEAD считается отсутствием ключа.
+func (s *Server) checkPutPreconditionTx(tx *gorm.DB, bucketID uint, key, ifMatch, ifNoneMatch string) (int, error) {
+	head, err := s.resolveVersionTx(tx, bucketID, key, "")
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, errIsDeleteMarker) {
+		if ifMatch != "" {
+			return http.StatusNotFound, nil
+		}
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if ifNoneMatch != "" {
+		return http.StatusPreconditionFailed, nil
+	}
+	if !etagListMatches(ifMatch, coalesce(head.ETag, "")) {
+		return http.StatusPreconditionFailed, nil
+	}
+	return 0, nil
+}
+
+func writePreconditionError(w http.ResponseWriter, r *http.Request, status int) {
+	if status == http.StatusNotFound {
+		writeS3Error(w, status, "NoSuchKey", "The specified key does not exist.", r.URL.Path, requestIDFrom(r))
+		return
+	}
+	writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed",
+		"At least one of the pre-conditions you specified did not hold", r.URL.Path, requestIDFrom(r))
+}
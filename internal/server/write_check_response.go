@@ -21,6 +21,8 @@ func (w *writeCheckResponseWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+func (w *writeCheckResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
 func responseAlreadyWritten(w http.ResponseWriter) bool {
 	if wc, ok := w.(*writeCheckResponseWriter); ok {
 		return wc.wroteHeader
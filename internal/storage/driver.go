@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
+	"time"
 )
 
 type BlobID string
@@ -24,3 +26,54 @@ type WriteSession interface {
 	Commit(ctx context.Context) error
 	Abort(ctx context.Context) error
 }
+
+// StoredFile — файл в хранилище драйвера: готовый блоб или недописанная
+// запись (tmp) от оборванной загрузки.
+type StoredFile struct {
+	ID      BlobID
+	Name    string // путь внутри хранилища, для RemoveTmp
+	Size    int64
+	ModTime time.Time
+	Tmp     bool
+}
+
+// ErrTmpInUse — недописанный файл принадлежит записи, которая ещё идёт.
+var ErrTmpInUse = errors.New("temporary file belongs to an active write")
+
+// Walker — драйвер, который умеет перечислять свои файлы (fsck, уборка
+// после сбоев). Необязательное расширение StorageDriver.
+type Walker interface {
+	Walk(ctx context.Context, fn func(StoredFile) error) error
+	RemoveTmp(ctx context.Context, name string) error // ErrTmpInUse — запись ещё идёт
+}
+
+// SpaceReporter — драйвер, который знает свободное место под своими файлами
+// (мониторинг диска). Необязательное расширение StorageDriver.
+type SpaceReporter interface {
+	DiskSpace(ctx context.Context) (free, total int64, err error)
+}
+
+// DiskStatus — состояние одного диска составного драйвера.
+type DiskStatus struct {
+	Path      string    `json:"path"`
+	State     string    `json:"state"` // ok | full | failed; до первой проверки — ok
+	Error     string    `json:"error,omitempty"`
+	Free      int64     `json:"free_bytes"`
+	Total     int64     `json:"total_bytes"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Состояния диска: full и failed новых блобов не получают, читать с них можно.
+const (
+	DiskOK     = "ok"
+	DiskFull   = "full"
+	DiskFailed = "failed"
+)
+
+// DiskSet — драйвер из нескольких дисков (JBOD). CheckDisks проверяет их
+// сейчас (пробная запись, место), Disks — итог последней проверки.
+// Необязательное расширение StorageDriver.
+type DiskSet interface {
+	CheckDisks(ctx context.Context) []DiskStatus
+	Disks() []DiskStatus
+}
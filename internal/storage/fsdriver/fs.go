@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	iofs "io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/DanikLP1/s3-storage-service/internal/storage"
 	"github.com/oklog/ulid/v2"
@@ -14,6 +16,8 @@ import (
 
 type FS struct {
 	Root string
+
+	active sync.Map // tmp-пути открытых writeSession — их RemoveTmp не трогает
 }
 
 func New(root string) *FS { return &FS{Root: root} }
@@ -31,6 +35,7 @@ func (fs *FS) pathFor(id storage.BlobID) (dir, tmp, final string) {
 }
 
 type writeSession struct {
+	fs        *FS
 	tmpPath   string
 	finalPath string
 	dirPath   string
@@ -50,7 +55,9 @@ func (fs *FS) BeginWrite(ctx context.Context, id storage.BlobID, opts storage.Pu
 	if err != nil {
 		return nil, err
 	}
+	fs.active.Store(tmp, struct{}{})
 	ws := &writeSession{
+		fs:        fs,
 		tmpPath:   tmp,
 		finalPath: final,
 		dirPath:   dir,
@@ -71,6 +78,7 @@ func (ws *writeSession) Write(p []byte) (int, error) {
 }
 
 func (ws *writeSession) Commit(ctx context.Context) error {
+	defer ws.fs.active.Delete(ws.tmpPath)
 	if err := ws.f.Sync(); err != nil {
 		_ = ws.f.Close()
 		_ = os.Remove(ws.tmpPath)
@@ -94,6 +102,7 @@ func (ws *writeSession) Commit(ctx context.Context) error {
 }
 
 func (ws *writeSession) Abort(ctx context.Context) error {
+	defer ws.fs.active.Delete(ws.tmpPath)
 	_ = ws.f.Close()
 	return (os.Remove(ws.tmpPath))
 }
@@ -139,3 +148,61 @@ func (fs *FS) Delete(ctx context.Context, id storage.BlobID) error {
 	}
 	return err
 }
+
+// Walk обходит blobs/: <id>.bin — блобы, <id>.bin.tmp-* — незавершённые записи.
+// Прочие файлы пропускаются.
+func (fs *FS) Walk(ctx context.Context, fn func(storage.StoredFile) error) error {
+	root := filepath.Join(fs.Root, "blobs")
+	err := filepath.WalkDir(root, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return ctx.Err()
+		}
+		name := d.Name()
+		f := storage.StoredFile{}
+		if base, _, ok := strings.Cut(name, ".bin.tmp-"); ok {
+			f.ID, f.Tmp = storage.BlobID(base), true
+		} else if base, ok := strings.CutSuffix(name, ".bin"); ok {
+			f.ID = storage.BlobID(base)
+		} else {
+			return nil
+		}
+		fi, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil // удалили, пока шёл обход
+		}
+		if err != nil {
+			return err
+		}
+		f.Name, _ = filepath.Rel(fs.Root, path)
+		f.Size, f.ModTime = fi.Size(), fi.ModTime()
+		return fn(f)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RemoveTmp удаляет незавершённую запись, найденную Walk. Файлы открытых
+// сессий этого процесса не трогает; чужие (другой процесс на том же каталоге)
+// вызывающий отличает сам — по давности ModTime.
+func (fs *FS) RemoveTmp(ctx context.Context, name string) error {
+	if !strings.Contains(filepath.Base(name), ".bin.tmp-") {
+		return fmt.Errorf("not a temporary blob file: %s", name)
+	}
+	path := filepath.Join(fs.Root, name)
+	if _, ok := fs.active.Load(path); ok {
+		return storage.ErrTmpInUse
+	}
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
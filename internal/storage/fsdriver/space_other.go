@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd)
+
+package fsdriver
+
+import (
+	"context"
+	"errors"
+)
+
+// DiskSpace на этой платформе не поддерживается: мониторинг диска выключится.
+func (fs *FS) DiskSpace(ctx context.Context) (free, total int64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd
+
+package fsdriver
+
+import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"path/filepath"
+	"syscall"
+)
+
+// DiskSpace — место на файловой системе корня; free — доступное
+// непривилегированному процессу (без резерва root).
+func (fs *FS) DiskSpace(ctx context.Context) (free, total int64, err error) {
+	// корень создаётся первой записью — до неё смотрим ближайший существующий каталог
+	dir := fs.Root
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(dir, &st)
+		if err == nil {
+			return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+		}
+		parent := filepath.Dir(dir)
+		if !errors.Is(err, iofs.ErrNotExist) || parent == dir {
+			return 0, 0, err
+		}
+		dir = parent
+	}
+}
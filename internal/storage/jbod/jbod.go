@@ -0,0 +1,282 @@
+package jbod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+	"github.com/DanikLP1/s3-storage-service/internal/storage/fsdriver"
+	"github.com/oklog/ulid/v2"
+)
+
+// JBOD — блобы на нескольких дисках: каждый диск — обычный каталог fsdriver,
+// диск блоба выбирается rendezvous-хэшированием (HRW) BlobID по путям дисков.
+// Добавленный диск забирает себе ~1/N блобов, остальные остаются на месте;
+// пока их не перенесёт Rebalance, чтение ищет блоб по дискам в том же порядке.
+// Диск, который не прошёл проверку (failed) или заполнен (full), новых блобов
+// не получает — они уходят на следующий по порядку.
+
+var ErrNoWritableDisk = errors.New("no writable disk")
+
+// probeName — файл пробной записи CheckDisks в корне диска.
+const probeName = ".s3mini-probe-"
+
+type disk struct {
+	fs *fsdriver.FS
+
+	mu     sync.Mutex
+	status storage.DiskStatus
+}
+
+func (d *disk) state() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status.State
+}
+
+func (d *disk) writable() bool { return d.state() == storage.DiskOK }
+
+// fail помечает диск сломанным до следующей проверки.
+func (d *disk) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status.State, d.status.Error = storage.DiskFailed, err.Error()
+}
+
+type JBOD struct {
+	disks   []*disk
+	minFree int64 // диск со свободным местом меньше — full
+}
+
+// New собирает драйвер из каталогов roots; minFree > 0 — порог, ниже которого
+// диск считается заполненным.
+func New(minFree int64, roots ...string) *JBOD {
+	j := &JBOD{minFree: minFree}
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		j.disks = append(j.disks, &disk{
+			fs:     fsdriver.New(root),
+			status: storage.DiskStatus{Path: root, State: storage.DiskOK},
+		})
+	}
+	return j
+}
+
+// order — диски в порядке предпочтения для id: первый — место блоба.
+func (j *JBOD) order(id storage.BlobID) []*disk {
+	type ranked struct {
+		d     *disk
+		score uint64
+	}
+	rs := make([]ranked, len(j.disks))
+	for i, d := range j.disks {
+		h := fnv.New64a()
+		h.Write([]byte(d.fs.Root))
+		h.Write([]byte{0})
+		h.Write([]byte(id))
+		rs[i] = ranked{d, mix64(h.Sum64())}
+	}
+	sort.Slice(rs, func(a, b int) bool { return rs[a].score > rs[b].score })
+	out := make([]*disk, len(rs))
+	for i, r := range rs {
+		out[i] = r.d
+	}
+	return out
+}
+
+// mix64 — финализатор splitmix64: у FNV близкие входы дают близкие хэши.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// target — диск, куда сейчас пишется id; nil — писать некуда.
+func (j *JBOD) target(id storage.BlobID) *disk {
+	for _, d := range j.order(id) {
+		if d.writable() {
+			return d
+		}
+	}
+	return nil
+}
+
+func (j *JBOD) BeginWrite(ctx context.Context, id storage.BlobID, opts storage.PutOpts) (storage.WriteSession, error) {
+	var firstErr error
+	for _, d := range j.order(id) {
+		if !d.writable() {
+			continue
+		}
+		ws, err := d.fs.BeginWrite(ctx, id, opts)
+		if err == nil {
+			return ws, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// не создаётся файл — диск отказал; пишем на следующий
+		d.fail(err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = ErrNoWritableDisk
+	}
+	return nil, firstErr
+}
+
+func (j *JBOD) ReadAt(ctx context.Context, id storage.BlobID, off int64, n int64) (io.ReadCloser, error) {
+	var lastErr error
+	for _, d := range j.order(id) {
+		rc, err := d.fs.ReadAt(ctx, id, off, n)
+		if err == nil {
+			return rc, nil
+		}
+		// ошибка диска важнее «нет файла» на остальных
+		if lastErr == nil || !errors.Is(err, iofs.ErrNotExist) {
+			lastErr = err
+		}
+	}
+	if lastErr == nil {
+		lastErr = ErrNoWritableDisk
+	}
+	return nil, lastErr
+}
+
+func (j *JBOD) Stat(ctx context.Context, id storage.BlobID) (int64, bool, error) {
+	var firstErr error
+	for _, d := range j.order(id) {
+		size, ok, err := d.fs.Stat(ctx, id)
+		if ok {
+			return size, true, nil
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return 0, false, firstErr
+}
+
+// Delete удаляет блоб со всех дисков: после прерванного Rebalance копий может быть две.
+func (j *JBOD) Delete(ctx context.Context, id storage.BlobID) error {
+	var firstErr error
+	for _, d := range j.disks {
+		if err := d.fs.Delete(ctx, id); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Walk обходит все диски; Name файла — "<номер диска>:<путь на диске>".
+func (j *JBOD) Walk(ctx context.Context, fn func(storage.StoredFile) error) error {
+	for i, d := range j.disks {
+		err := d.fs.Walk(ctx, func(f storage.StoredFile) error {
+			f.Name = strconv.Itoa(i) + ":" + f.Name
+			return fn(f)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.fs.Root, err)
+		}
+	}
+	return nil
+}
+
+func (j *JBOD) RemoveTmp(ctx context.Context, name string) error {
+	idx, rest, ok := strings.Cut(name, ":")
+	i, err := strconv.Atoi(idx)
+	if !ok || err != nil || i < 0 || i >= len(j.disks) {
+		return fmt.Errorf("not a jbod file name: %s", name)
+	}
+	return j.disks[i].fs.RemoveTmp(ctx, rest)
+}
+
+// DiskSpace — место всех дисков; free — только у тех, что принимают запись.
+func (j *JBOD) DiskSpace(ctx context.Context) (free, total int64, err error) {
+	measured := false
+	for _, d := range j.disks {
+		f, t, err := d.fs.DiskSpace(ctx)
+		if err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				return 0, 0, err
+			}
+			continue
+		}
+		measured = true
+		total += t
+		if d.writable() {
+			free += f
+		}
+	}
+	if !measured {
+		return 0, 0, errors.New("no disk could be measured")
+	}
+	return free, total, nil
+}
+
+// CheckDisks — пробная запись с fsync в корень каждого диска и замер места.
+func (j *JBOD) CheckDisks(ctx context.Context) []storage.DiskStatus {
+	out := make([]storage.DiskStatus, len(j.disks))
+	for i, d := range j.disks {
+		st := storage.DiskStatus{Path: d.fs.Root, State: storage.DiskOK, CheckedAt: time.Now().UTC()}
+		if err := probe(d.fs.Root); err != nil {
+			st.State, st.Error = storage.DiskFailed, err.Error()
+		} else if free, total, err := d.fs.DiskSpace(ctx); err == nil {
+			st.Free, st.Total = free, total
+			if j.minFree > 0 && free < j.minFree {
+				st.State = storage.DiskFull
+			}
+		}
+		d.mu.Lock()
+		d.status = st
+		d.mu.Unlock()
+		out[i] = st
+	}
+	return out
+}
+
+func (j *JBOD) Disks() []storage.DiskStatus {
+	out := make([]storage.DiskStatus, len(j.disks))
+	for i, d := range j.disks {
+		d.mu.Lock()
+		out[i] = d.status
+		d.mu.Unlock()
+	}
+	return out
+}
+
+func probe(root string) error {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(root, probeName+ulid.Make().String())
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if _, err := f.Write([]byte("ok")); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
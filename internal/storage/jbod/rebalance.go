@@ -0,0 +1,96 @@
+package jbod
+
+import (
+	"context"
+	"io"
+
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+)
+
+// Move — блоб лежит не на своём диске.
+type Move struct {
+	ID        storage.BlobID
+	From, To  string
+	Size      int64
+	Duplicate bool // на своём диске копия уже есть — лишняя просто удаляется
+}
+
+// RebalanceStats — итог Rebalance.
+type RebalanceStats struct {
+	Files   int   // блобов осмотрено
+	Moved   int   // перенесено на свой диск
+	Removed int   // удалено лишних копий
+	Bytes   int64 // перенесено байт
+	Failed  int
+}
+
+// Rebalance переносит блобы на диски, которые им выбирает хэш (после
+// добавления диска или восстановления сломанного). Копия пишется с fsync
+// и только потом удаляется исходная; если исходную тем временем удалил GC,
+// удаляется и копия. dryRun — только перечислить (счётчики — что было бы
+// сделано). fn получает каждый перенос и его ошибку (nil — успех).
+func (j *JBOD) Rebalance(ctx context.Context, dryRun bool, fn func(Move, error)) (RebalanceStats, error) {
+	var st RebalanceStats
+	for _, from := range j.disks {
+		err := from.fs.Walk(ctx, func(f storage.StoredFile) error {
+			if f.Tmp {
+				return nil
+			}
+			st.Files++
+			to := j.target(f.ID)
+			if to == nil || to == from {
+				return nil
+			}
+			m := Move{ID: f.ID, From: from.fs.Root, To: to.fs.Root, Size: f.Size}
+			if size, ok, _ := to.fs.Stat(ctx, f.ID); ok && size == f.Size {
+				m.Duplicate = true
+			}
+			var err error
+			if !dryRun {
+				err = j.move(ctx, from, to, m)
+			}
+			switch {
+			case err != nil:
+				st.Failed++
+			case m.Duplicate:
+				st.Removed++
+			default:
+				st.Moved++
+				st.Bytes += m.Size
+			}
+			fn(m, err)
+			return ctx.Err()
+		})
+		if err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+func (j *JBOD) move(ctx context.Context, from, to *disk, m Move) error {
+	if m.Duplicate {
+		return from.fs.Delete(ctx, m.ID)
+	}
+	rc, err := from.fs.ReadAt(ctx, m.ID, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	ws, err := to.fs.BeginWrite(ctx, m.ID, storage.PutOpts{Size: m.Size})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ws.Writer(), rc); err != nil {
+		_ = ws.Abort(ctx)
+		return err
+	}
+	if err := ws.Commit(ctx); err != nil {
+		return err
+	}
+	// блоб удалили, пока копировали, — копия осиротела бы
+	if _, ok, err := from.fs.Stat(ctx, m.ID); err == nil && !ok {
+		return to.fs.Delete(ctx, m.ID)
+	}
+	return from.fs.Delete(ctx, m.ID)
+}
@@ -0,0 +1,337 @@
+package s3driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/DanikLP1/s3-storage-service/internal/storage"
+)
+
+// Driver хранит блобы объектами во внешнем S3-совместимом хранилище (AWS S3,
+// MinIO, ещё один s3mini) через aws-sdk-go-v2: метаданные остаются в своей БД,
+// байты — в бакете под ключами <prefix>blobs/<id>.bin. Подпись, повторы с
+// backoff и цепочку источников ключей (AWS_*, ~/.aws, роль инстанса) даёт SDK.
+// Запись копится во временном файле и уходит на Commit одним PUT или, если
+// она больше partSize, multipart-загрузкой; до Commit объекта в бакете нет.
+
+// Scheme — префикс адреса бакета вместо каталога: s3://bucket/prefix.
+const Scheme = "s3://"
+
+const (
+	// сколько раз SDK пробует запрос при сетевой ошибке, 5xx и throttling
+	maxAttempts = 5
+	// часть multipart-загрузки; запись не больше неё уходит одним PUT
+	partSize = 64 << 20
+	// регион, если его не задали и бакет его не назвал
+	defaultRegion = "us-east-1"
+)
+
+type Config struct {
+	Endpoint  string // http(s)://host[:port]; пусто — AWS S3
+	Region    string // пусто — из AWS_REGION/профиля, для AWS S3 — у бакета
+	Bucket    string
+	Prefix    string // "" или "dir/" — ключи блобов начинаются с него
+	AccessKey string // пусто — цепочка источников ключей SDK
+	SecretKey string
+	PathStyle bool   // host/bucket/key (MinIO, s3mini); иначе bucket.host/key
+	SpoolDir  string // каталог временных файлов записи; пусто — os.TempDir()
+}
+
+type Driver struct {
+	cfg    Config
+	client *s3.Client
+}
+
+// IsURL — dir задаёт бакет (s3://...), а не каталог.
+func IsURL(dir string) bool { return strings.HasPrefix(dir, Scheme) }
+
+// ParseURL разбирает s3://bucket[/prefix] в бакет и префикс ключей (с "/" на конце).
+func ParseURL(s string) (bucket, prefix string, err error) {
+	rest, ok := strings.CutPrefix(s, Scheme)
+	if !ok {
+		return "", "", fmt.Errorf("%q: not an %s URL", s, Scheme)
+	}
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%q: bucket is empty", s)
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return bucket, prefix, nil
+}
+
+// New собирает клиент. Регион, не заданный ни в cfg, ни в окружении SDK, у
+// AWS S3 спрашивается у бакета, у остальных хранилищ — us-east-1.
+func New(cfg Config) (*Driver, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3driver: bucket is required")
+	}
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = os.TempDir()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRetryMaxAttempts(maxAttempts)}
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	if cfg.AccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, "")))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("s3driver: %w", err)
+	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = defaultRegion
+		if cfg.Endpoint == "" {
+			if awsCfg.Region, err = bucketRegion(ctx, awsCfg, cfg.Bucket); err != nil {
+				return nil, fmt.Errorf("s3driver: region of bucket %s: %w", cfg.Bucket, err)
+			}
+		}
+	}
+	cfg.Region = awsCfg.Region
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(strings.TrimRight(cfg.Endpoint, "/"))
+		}
+		o.UsePathStyle = cfg.PathStyle
+	})
+	return &Driver{cfg: cfg, client: client}, nil
+}
+
+// bucketRegion — регион бакета в AWS S3. Запрос не в тот регион получает 301,
+// но x-amz-bucket-region есть и в нём.
+func bucketRegion(ctx context.Context, awsCfg aws.Config, bucket string) (string, error) {
+	client := s3.NewFromConfig(awsCfg)
+	out, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		if out.BucketRegion != nil {
+			return *out.BucketRegion, nil
+		}
+		return defaultRegion, nil
+	}
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.Response != nil {
+		if region := re.Response.Header.Get("X-Amz-Bucket-Region"); region != "" {
+			return region, nil
+		}
+	}
+	return "", err
+}
+
+// String — s3://bucket/prefix, для логов.
+func (d *Driver) String() string { return Scheme + d.cfg.Bucket + "/" + d.cfg.Prefix }
+
+func (d *Driver) key(id storage.BlobID) string {
+	return d.cfg.Prefix + "blobs/" + string(id) + ".bin"
+}
+
+// httpStatus — код ответа хранилища из ошибки SDK (0 — ответа не было).
+func httpStatus(err error) int {
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode()
+	}
+	return 0
+}
+
+type writeSession struct {
+	d    *Driver
+	id   storage.BlobID
+	f    *os.File
+	size int64
+}
+
+func (d *Driver) BeginWrite(ctx context.Context, id storage.BlobID, opts storage.PutOpts) (storage.WriteSession, error) {
+	f, err := os.CreateTemp(d.cfg.SpoolDir, "s3mini-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	return &writeSession{d: d, id: id, f: f}, nil
+}
+
+func (ws *writeSession) Writer() io.Writer { return ws }
+
+func (ws *writeSession) Write(p []byte) (int, error) {
+	n, err := ws.f.Write(p)
+	ws.size += int64(n)
+	return n, err
+}
+
+func (ws *writeSession) Commit(ctx context.Context) error {
+	defer ws.cleanup()
+	key := ws.d.key(ws.id)
+	if ws.size > partSize {
+		return ws.d.putMultipart(ctx, key, ws.f, ws.size)
+	}
+	_, err := ws.d.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(ws.d.cfg.Bucket),
+		Key:           aws.String(key),
+		Body:          io.NewSectionReader(ws.f, 0, ws.size),
+		ContentLength: aws.Int64(ws.size),
+		ContentType:   aws.String("application/octet-stream"),
+	})
+	return err
+}
+
+// putMultipart грузит файл частями по partSize. При ошибке загрузка
+// отменяется, чтобы брошенные части не копились в бакете.
+func (d *Driver) putMultipart(ctx context.Context, key string, f *os.File, size int64) error {
+	mpu, err := d.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(d.cfg.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/octet-stream"),
+	})
+	if err != nil {
+		return err
+	}
+	if err = d.uploadParts(ctx, key, mpu.UploadId, f, size); err != nil {
+		// контекст запроса мог уже кончиться — отмена идёт со своим сроком
+		actx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		_, _ = d.client.AbortMultipartUpload(actx, &s3.AbortMultipartUploadInput{
+			Bucket: aws.String(d.cfg.Bucket), Key: aws.String(key), UploadId: mpu.UploadId,
+		})
+	}
+	return err
+}
+
+func (d *Driver) uploadParts(ctx context.Context, key string, uploadID *string, f *os.File, size int64) error {
+	var parts []types.CompletedPart
+	for off, num := int64(0), int32(1); off < size; off, num = off+partSize, num+1 {
+		n := min(partSize, size-off)
+		out, err := d.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(d.cfg.Bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(num),
+			Body:          io.NewSectionReader(f, off, n),
+			ContentLength: aws.Int64(n),
+		})
+		if err != nil {
+			return err
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(num)})
+	}
+	_, err := d.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(d.cfg.Bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+func (ws *writeSession) Abort(ctx context.Context) error {
+	ws.cleanup()
+	return nil
+}
+
+func (ws *writeSession) cleanup() {
+	_ = ws.f.Close()
+	_ = os.Remove(ws.f.Name())
+}
+
+// ReadAt читает n байт с off (n < 0 — до конца) запросом с Range. Нет
+// объекта — ошибка с fs.ErrNotExist.
+func (d *Driver) ReadAt(ctx context.Context, id storage.BlobID, off int64, n int64) (io.ReadCloser, error) {
+	if n == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	in := &s3.GetObjectInput{Bucket: aws.String(d.cfg.Bucket), Key: aws.String(d.key(id))}
+	switch {
+	case n > 0:
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	case off > 0:
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-", off))
+	}
+	out, err := d.client.GetObject(ctx, in)
+	switch httpStatus(err) {
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("blob %s: %w", id, iofs.ErrNotExist)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// off на конце объекта (или пустой объект): S3 отвечает 416, файл — пустым чтением
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{Reader: io.LimitReader(out.Body, n), Closer: out.Body}, nil
+	}
+	return out.Body, nil
+}
+
+func (d *Driver) Stat(ctx context.Context, id storage.BlobID) (int64, bool, error) {
+	out, err := d.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(d.cfg.Bucket), Key: aws.String(d.key(id))})
+	if httpStatus(err) == http.StatusNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return aws.ToInt64(out.ContentLength), true, nil
+}
+
+// Delete удаляет объект; S3 отвечает 204 и на отсутствующий ключ.
+func (d *Driver) Delete(ctx context.Context, id storage.BlobID) error {
+	_, err := d.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(d.cfg.Bucket), Key: aws.String(d.key(id))})
+	if httpStatus(err) == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// Walk перечисляет блобы ListObjectsV2 по <prefix>blobs/. Недописанных
+// записей в бакете не бывает: до Commit байты лежат во временном файле.
+func (d *Driver) Walk(ctx context.Context, fn func(storage.StoredFile) error) error {
+	dir := d.cfg.Prefix + "blobs/"
+	pages := s3.NewListObjectsV2Paginator(d.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(d.cfg.Bucket),
+		Prefix: aws.String(dir),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("s3driver: list %s: %w", d, err)
+		}
+		for _, c := range page.Contents {
+			key := aws.ToString(c.Key)
+			base, ok := strings.CutSuffix(strings.TrimPrefix(key, dir), ".bin")
+			if !ok || strings.Contains(base, "/") {
+				continue
+			}
+			f := storage.StoredFile{ID: storage.BlobID(base), Name: key, Size: aws.ToInt64(c.Size), ModTime: aws.ToTime(c.LastModified)}
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// RemoveTmp — Walk недописанных записей не отдаёт, удалять нечего.
+func (d *Driver) RemoveTmp(ctx context.Context, name string) error {
+	return fmt.Errorf("not a temporary blob file: %s", name)
+}
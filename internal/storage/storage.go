@@ -2,21 +2,53 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"sort"
 )
 
+// NodeLocal — основной узел хранения; блобы с пустым StorageNode тоже здесь.
+const NodeLocal = "local"
+
+// NodeTier — второй уровень хранения (TIER_DATA_DIR), куда блобы переносит
+// lifecycle Transition.
+const NodeTier = "tier"
+
 type Storage struct {
 	driver StorageDriver
+	// дополнительные узлы (уровни хранения), куда lifecycle переносит блобы
+	nodes map[string]StorageDriver
 }
 
 func NewWithDriver(d StorageDriver) *Storage {
-	return &Storage{driver: d}
+	return &Storage{driver: d, nodes: map[string]StorageDriver{}}
 }
 
 func (s *Storage) Driver() StorageDriver {
 	return s.driver
 }
 
+// AddNode регистрирует дополнительный узел хранения под именем name.
+func (s *Storage) AddNode(name string, d StorageDriver) {
+	s.nodes[name] = d
+}
+
+// HasNode — узел name настроен (основной есть всегда).
+func (s *Storage) HasNode(name string) bool {
+	_, err := s.node(name)
+	return err == nil
+}
+
+func (s *Storage) node(name string) (StorageDriver, error) {
+	if name == "" || name == NodeLocal {
+		return s.driver, nil
+	}
+	if d, ok := s.nodes[name]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("storage node %q is not configured", name)
+}
+
 func (s *Storage) Put(ctx context.Context, id string, r io.Reader, size int64, checksum []byte) error {
 	ws, err := s.driver.BeginWrite(ctx, BlobID(id), PutOpts{Size: size, Checksum: checksum})
 	if err != nil {
@@ -33,10 +65,157 @@ func (s *Storage) ReadAt(ctx context.Context, id string, off int64, n int64) (io
 	return s.driver.ReadAt(ctx, BlobID(id), off, n)
 }
 
+// ReadAtNode — как ReadAt, но с узла, где лежит блоб (Blob.StorageNode).
+func (s *Storage) ReadAtNode(ctx context.Context, node, id string, off int64, n int64) (io.ReadCloser, error) {
+	d, err := s.node(node)
+	if err != nil {
+		return nil, err
+	}
+	return d.ReadAt(ctx, BlobID(id), off, n)
+}
+
 func (s *Storage) Stat(ctx context.Context, id string) (int64, bool, error) {
 	return s.driver.Stat(ctx, BlobID(id))
 }
 
+func (s *Storage) StatNode(ctx context.Context, node, id string) (int64, bool, error) {
+	d, err := s.node(node)
+	if err != nil {
+		return 0, false, err
+	}
+	return d.Stat(ctx, BlobID(id))
+}
+
+// Delete удаляет блоб со всех узлов: вызывающие знают только id, а блоб мог
+// быть перенесён lifecycle-переходом. Отсутствие файла ошибкой не считается.
 func (s *Storage) Delete(ctx context.Context, id string) error {
-	return s.driver.Delete(ctx, BlobID(id))
+	err := s.driver.Delete(ctx, BlobID(id))
+	for _, d := range s.nodes {
+		if e := d.Delete(ctx, BlobID(id)); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// DeleteNode удаляет копию блоба только с узла node.
+func (s *Storage) DeleteNode(ctx context.Context, node, id string) error {
+	d, err := s.node(node)
+	if err != nil {
+		return err
+	}
+	return d.Delete(ctx, BlobID(id))
+}
+
+// Copy копирует байты блоба (как есть, без распаковки) с узла from на узел to.
+func (s *Storage) Copy(ctx context.Context, id, from, to string) error {
+	src, err := s.node(from)
+	if err != nil {
+		return err
+	}
+	dst, err := s.node(to)
+	if err != nil {
+		return err
+	}
+	size, ok, err := src.Stat(ctx, BlobID(id))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("blob %s not found on node %q", id, from)
+	}
+	rc, err := src.ReadAt(ctx, BlobID(id), 0, -1)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	ws, err := dst.BeginWrite(ctx, BlobID(id), PutOpts{Size: size})
+	if err != nil {
+		return err
+	}
+	if n, err := io.Copy(ws.Writer(), rc); err != nil || n != size {
+		_ = ws.Abort(ctx)
+		if err == nil {
+			err = fmt.Errorf("blob %s: copied %d of %d bytes", id, n, size)
+		}
+		return err
+	}
+	return ws.Commit(ctx)
+}
+
+// NodeNames — основной узел и все дополнительные, основной первым.
+func (s *Storage) NodeNames() []string {
+	names := make([]string, 0, len(s.nodes)+1)
+	names = append(names, NodeLocal)
+	for name := range s.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// WalkNode перечисляет файлы узла node. ok=false — драйвер узла этого не умеет.
+func (s *Storage) WalkNode(ctx context.Context, node string, fn func(StoredFile) error) (bool, error) {
+	d, err := s.node(node)
+	if err != nil {
+		return false, err
+	}
+	w, ok := d.(Walker)
+	if !ok {
+		return false, nil
+	}
+	return true, w.Walk(ctx, fn)
+}
+
+// RemoveTmpNode удаляет недописанный файл name с узла node.
+func (s *Storage) RemoveTmpNode(ctx context.Context, node, name string) error {
+	d, err := s.node(node)
+	if err != nil {
+		return err
+	}
+	w, ok := d.(Walker)
+	if !ok {
+		return fmt.Errorf("storage node %q cannot list files", node)
+	}
+	return w.RemoveTmp(ctx, name)
+}
+
+// DiskSpaceNode — свободное и общее место узла node. ok=false — драйвер узла этого не умеет.
+func (s *Storage) DiskSpaceNode(ctx context.Context, node string) (free, total int64, ok bool, err error) {
+	d, err := s.node(node)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	sr, ok := d.(SpaceReporter)
+	if !ok {
+		return 0, 0, false, nil
+	}
+	free, total, err = sr.DiskSpace(ctx)
+	return free, total, true, err
+}
+
+// CheckDisksNode проверяет диски узла node. ok=false — узел не из нескольких дисков.
+func (s *Storage) CheckDisksNode(ctx context.Context, node string) ([]DiskStatus, bool) {
+	d, err := s.node(node)
+	if err != nil {
+		return nil, false
+	}
+	ds, ok := d.(DiskSet)
+	if !ok {
+		return nil, false
+	}
+	return ds.CheckDisks(ctx), true
+}
+
+// DisksNode — итог последней проверки дисков узла node.
+func (s *Storage) DisksNode(node string) ([]DiskStatus, bool) {
+	d, err := s.node(node)
+	if err != nil {
+		return nil, false
+	}
+	ds, ok := d.(DiskSet)
+	if !ok {
+		return nil, false
+	}
+	return ds.Disks(), true
 }
@@ -0,0 +1,145 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+const (
+	maxImageSide   = 4096
+	maxImageSource = 32 << 20
+)
+
+func init() { Register(imageResize{}) }
+
+// imageResize — уменьшение картинки под рамку w×h с сохранением пропорций
+// (?w=200, ?h=200 или оба). Увеличение не делаем — отдаём исходный размер.
+type imageResize struct{}
+
+func (imageResize) Name() string { return "image.resize" }
+
+func (imageResize) Parse(q url.Values) (string, bool, error) {
+	ws, hs := q.Get("w"), q.Get("h")
+	if ws == "" && hs == "" {
+		return "", false, nil
+	}
+	w, err := parseSide(ws)
+	if err != nil {
+		return "", false, err
+	}
+	h, err := parseSide(hs)
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("w=%d,h=%d", w, h), true, nil
+}
+
+func parseSide(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 || n > maxImageSide {
+		return 0, fmt.Errorf("image size must be 1..%d", maxImageSide)
+	}
+	return n, nil
+}
+
+func (imageResize) Apply(src io.Reader, contentType, params string) ([]byte, string, error) {
+	var w, h int
+	if _, err := fmt.Sscanf(params, "w=%d,h=%d", &w, &h); err != nil {
+		return nil, "", err
+	}
+	raw, err := io.ReadAll(io.LimitReader(src, maxImageSource+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(raw) > maxImageSource {
+		return nil, "", ErrSourceTooLarge
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(raw))
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(raw))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(raw))
+	default:
+		return nil, "", ErrUnsupportedType
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	dw, dh := fitBox(img.Bounds().Dx(), img.Bounds().Dy(), w, h)
+	dst := downscale(img, dw, dh)
+
+	var out bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85})
+	default:
+		// gif тоже отдаём png: палитра после усреднения не сохраняется
+		contentType = "image/png"
+		err = png.Encode(&out, dst)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Bytes(), contentType, nil
+}
+
+// fitBox — размер, вписанный в рамку w×h (0 — сторона не ограничена), не больше исходного.
+func fitBox(sw, sh, w, h int) (int, int) {
+	scale := 1.0
+	if w > 0 && float64(w)/float64(sw) < scale {
+		scale = float64(w) / float64(sw)
+	}
+	if h > 0 && float64(h)/float64(sh) < scale {
+		scale = float64(h) / float64(sh)
+	}
+	dw, dh := int(float64(sw)*scale+0.5), int(float64(sh)*scale+0.5)
+	return max(dw, 1), max(dh, 1)
+}
+
+// downscale — усреднение по области (box filter): без внешних зависимостей,
+// для уменьшения даёт приличное качество.
+func downscale(src image.Image, dw, dh int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*sh/dh, b.Min.Y+(y+1)*sh/dh
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*sw/dw, b.Min.X+(x+1)*sw/dw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,59 @@
+// Package transform — преобразования объекта на GET (?w=200&h=200 и т.п.).
+// Результат кэшируется сервером как производный блоб, сами трансформеры
+// ничего не знают о хранилище.
+package transform
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"sync"
+)
+
+var (
+	ErrUnsupportedType = errors.New("transform: unsupported content type")
+	ErrSourceTooLarge  = errors.New("transform: source too large")
+)
+
+type Transformer interface {
+	// Name — стабильное имя, входит в ключ кэша.
+	Name() string
+	// Parse достаёт параметры из query. ok=false — запрос не для этого трансформера;
+	// params — каноническая строка (одинаковые запросы => одинаковый ключ кэша).
+	Parse(q url.Values) (params string, ok bool, err error)
+	// Apply читает исходник и возвращает результат и его Content-Type.
+	Apply(src io.Reader, contentType, params string) (out []byte, outType string, err error)
+}
+
+var (
+	mu       sync.RWMutex
+	registry []Transformer
+)
+
+// Register добавляет трансформер; порядок регистрации = приоритет при разборе query.
+func Register(t Transformer) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry = append(registry, t)
+}
+
+// Lookup — первый трансформер, который узнал свои параметры в query.
+func Lookup(q url.Values) (Transformer, string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, t := range registry {
+		params, ok, err := t.Parse(q)
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			return t, params, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// CacheKey — ключ производного блоба: имя трансформера + канонические параметры.
+func CacheKey(t Transformer, params string) string {
+	return t.Name() + ":" + params
+}